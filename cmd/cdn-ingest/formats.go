@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// Log formats, named after the CDN writing them
+const (
+	formatCloudFront = "cloudfront"
+	formatFastly     = "fastly"
+	formatAkamai     = "akamai"
+)
+
+// logRecord is one request of an access log, in the CDN's own terms
+// converted to the collector's
+type logRecord struct {
+	time      time.Time
+	requestID string
+	path      string
+	query     string
+	cookie    string
+	status    int
+	bytes     int64
+	// cacheStatus is normalized to the models.Cache* statuses where the
+	// CDN's maps to one
+	cacheStatus string
+	// ttfb and duration are in milliseconds
+	ttfb      float64
+	duration  float64
+	userAgent string
+	clientIP  string
+	pop       string
+}
+
+// parser turns log lines into records. ok is false for lines that hold no
+// request, such as headers.
+type parser interface {
+	parse(line string) (record logRecord, ok bool, err error)
+}
+
+func newParser(format string) (parser, error) {
+	switch format {
+	case formatCloudFront:
+		return newCloudFrontParser(), nil
+	case formatFastly:
+		return fastlyParser{}, nil
+	case formatAkamai:
+		return akamaiParser{}, nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, must be %s, %s or %s", format, formatCloudFront, formatFastly, formatAkamai)
+}
+
+// cloudFrontFields are the fields of CloudFront standard logs, in the order
+// files without a #Fields header are read in
+var cloudFrontFields = strings.Fields("date time x-edge-location sc-bytes c-ip cs-method cs(Host) cs-uri-stem sc-status " +
+	"cs(Referer) cs(User-Agent) cs-uri-query cs(Cookie) x-edge-result-type x-edge-request-id x-host-header cs-protocol " +
+	"cs-bytes time-taken x-forwarded-for ssl-protocol ssl-cipher x-edge-response-result-type cs-protocol-version " +
+	"fle-status fle-encrypted-fields c-port time-to-first-byte x-edge-detailed-result-type sc-content-type " +
+	"sc-content-len sc-range-start sc-range-end")
+
+// cloudFrontParser reads CloudFront standard logs: tab-separated fields
+// named by the file's #Fields header, with - for empty ones
+type cloudFrontParser struct {
+	index map[string]int
+}
+
+func newCloudFrontParser() *cloudFrontParser {
+	p := &cloudFrontParser{}
+	p.setFields(cloudFrontFields)
+	return p
+}
+
+func (p *cloudFrontParser) setFields(fields []string) {
+	p.index = make(map[string]int, len(fields))
+	for i, field := range fields {
+		p.index[field] = i
+	}
+}
+
+func (p *cloudFrontParser) parse(line string) (logRecord, bool, error) {
+	if fields, ok := strings.CutPrefix(line, "#Fields:"); ok {
+		p.setFields(strings.Fields(fields))
+		return logRecord{}, false, nil
+	}
+	if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+		return logRecord{}, false, nil
+	}
+
+	values := strings.Split(line, "\t")
+	field := func(name string) string {
+		i, ok := p.index[name]
+		if !ok || i >= len(values) || values[i] == "-" {
+			return ""
+		}
+		return values[i]
+	}
+
+	t, err := time.Parse("2006-01-02 15:04:05", field("date")+" "+field("time"))
+	if err != nil {
+		return logRecord{}, false, fmt.Errorf("invalid time: %w", err)
+	}
+	record := logRecord{
+		time:        t,
+		requestID:   field("x-edge-request-id"),
+		path:        field("cs-uri-stem"),
+		query:       field("cs-uri-query"),
+		cookie:      unescape(field("cs(Cookie)")),
+		cacheStatus: cloudFrontCacheStatus(field("x-edge-result-type")),
+		userAgent:   unescape(field("cs(User-Agent)")),
+		clientIP:    field("c-ip"),
+		pop:         field("x-edge-location"),
+	}
+	record.status, _ = strconv.Atoi(field("sc-status"))
+	record.bytes, _ = strconv.ParseInt(field("sc-bytes"), 10, 64)
+	record.ttfb = seconds(field("time-to-first-byte"))
+	record.duration = seconds(field("time-taken"))
+	return record, true, nil
+}
+
+func cloudFrontCacheStatus(result string) string {
+	switch result {
+	case "Hit", "RefreshHit", "OriginShieldHit":
+		return models.CacheHit
+	case "Miss":
+		return models.CacheMiss
+	case "Error", "LimitExceeded", "CapacityExceeded":
+		return models.CacheError
+	}
+	return strings.ToLower(result)
+}
+
+// fastlyParser reads JSON lines of a Fastly real-time log endpoint whose
+// format names the fields like this:
+//
+//	{"timestamp":"%{strftime(\{"%Y-%m-%dT%H:%M:%S%z"\}, time.start)}V",
+//	 "request_id":"%{req.xid}V", "client_ip":"%{req.http.Fastly-Client-IP}V",
+//	 "url":"%{json.escape(req.url)}V", "status":%{resp.status}V,
+//	 "bytes":%{resp.bytes_written}V, "cache_status":"%{fastly_info.state}V",
+//	 "ttfb":%{time.to_first_byte}V, "elapsed_us":%{time.elapsed.usec}V,
+//	 "user_agent":"%{json.escape(req.http.User-Agent)}V",
+//	 "cookie":"%{json.escape(req.http.Cookie)}V", "pop":"%{server.datacenter}V"}
+type fastlyParser struct{}
+
+func (fastlyParser) parse(line string) (logRecord, bool, error) {
+	fields, ok, err := jsonFields(line)
+	if !ok || err != nil {
+		return logRecord{}, false, err
+	}
+	t, err := parseTime(fields.str("timestamp"))
+	if err != nil {
+		return logRecord{}, false, err
+	}
+	path, query, _ := strings.Cut(fields.str("url"), "?")
+	state := strings.ToUpper(fields.str("cache_status"))
+	return logRecord{
+		time:        t,
+		requestID:   fields.str("request_id"),
+		path:        path,
+		query:       query,
+		cookie:      fields.str("cookie"),
+		status:      int(fields.num("status")),
+		bytes:       int64(fields.num("bytes")),
+		cacheStatus: fastlyCacheStatus(state),
+		ttfb:        fields.num("ttfb") * 1000,
+		duration:    fields.num("elapsed_us") / 1000,
+		userAgent:   fields.str("user_agent"),
+		clientIP:    fields.str("client_ip"),
+		pop:         fields.str("pop"),
+	}, true, nil
+}
+
+// fastlyCacheStatus normalizes fastly_info.state, e.g. HIT-STALE or
+// MISS-CLUSTER
+func fastlyCacheStatus(state string) string {
+	switch {
+	case strings.HasPrefix(state, "HIT"):
+		return models.CacheHit
+	case strings.HasPrefix(state, "MISS"):
+		return models.CacheMiss
+	case strings.HasPrefix(state, "PASS"):
+		return models.CachePass
+	case strings.HasPrefix(state, "ERROR"):
+		return models.CacheError
+	}
+	return strings.ToLower(state)
+}
+
+// akamaiParser reads Akamai DataStream 2 logs in JSON format, whose values
+// are mostly strings
+type akamaiParser struct{}
+
+func (akamaiParser) parse(line string) (logRecord, bool, error) {
+	fields, ok, err := jsonFields(line)
+	if !ok || err != nil {
+		return logRecord{}, false, err
+	}
+	t, err := parseTime(fields.str("reqTimeSec"))
+	if err != nil {
+		return logRecord{}, false, err
+	}
+	path := fields.str("reqPath")
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	cacheStatus := models.CacheMiss
+	if fields.str("cacheStatus") == "1" {
+		cacheStatus = models.CacheHit
+	}
+	turnaround := fields.num("turnAroundTimeMSec")
+	return logRecord{
+		time:        t,
+		requestID:   fields.str("reqId"),
+		path:        path,
+		query:       fields.str("queryStr"),
+		cookie:      unescape(fields.str("cookie")),
+		status:      int(fields.num("statusCode")),
+		bytes:       int64(fields.num("bytes")),
+		cacheStatus: cacheStatus,
+		ttfb:        turnaround,
+		duration:    turnaround + fields.num("transferTimeMSec"),
+		userAgent:   unescape(fields.str("UA")),
+		clientIP:    fields.str("cliIP"),
+	}, true, nil
+}
+
+// jsonObject is a decoded JSON log line
+type jsonObject map[string]any
+
+func jsonFields(line string) (jsonObject, bool, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, false, nil
+	}
+	var fields jsonObject
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return nil, false, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return fields, true, nil
+}
+
+// str returns the field as a string, whether it was logged as one or not
+func (o jsonObject) str(key string) string {
+	switch v := o[key].(type) {
+	case string:
+		if v == "-" {
+			return ""
+		}
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+// num returns the field as a number, whether it was logged as one or as a
+// string, and 0 when it is neither
+func (o jsonObject) num(key string) float64 {
+	switch v := o[key].(type) {
+	case float64:
+		return v
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return n
+	}
+	return 0
+}
+
+// parseTime reads RFC 3339 times, with or without a colon in the offset,
+// and Unix times in seconds
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, errors.New("missing time")
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.UnixMicro(int64(secs * 1e6)).UTC(), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05Z0700"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// seconds converts a logged number of seconds to milliseconds
+func seconds(s string) float64 {
+	n, _ := strconv.ParseFloat(s, 64)
+	return n * 1000
+}
+
+// unescape decodes the URL encoding CloudFront and Akamai apply to headers,
+// keeping values that aren't encoded as they are
+func unescape(s string) string {
+	if decoded, err := url.PathUnescape(s); err == nil {
+		return decoded
+	}
+	return s
+}
@@ -0,0 +1,408 @@
+// Command cdn-ingest reads CDN access logs and writes their media segment
+// requests to a sink as cdn_delivery events, so delivery (bytes, cache
+// status, time to first byte) can be analyzed next to what players
+// reported.
+//
+//	cdn-ingest -format cloudfront|fastly|akamai [-config file] [-sink type] [-follow] [flags] [path ...]
+//
+// Paths are log files, optionally gzipped, or directories of them, read in
+// name order; - reads standard input. With -follow the one path given is
+// tailed like tail -F instead, from its end.
+//
+// Requests are matched to player sessions by the -session-key query
+// parameter or cookie, when the player puts its session ID in segment
+// URLs, or else by the -viewer-key one holding the viewer's userId or
+// anonymousId: the request then joins the session of that viewer whose
+// player events it falls within -session-gap of. Viewer matching reads the
+// player events back from the sink, so it needs a sink that can be queried.
+// Events of requests with a known CDN request ID get an ID derived from
+// it, so loading a log twice writes the same events.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/config"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/sink/split"
+)
+
+// deliveryIDNamespace derives event IDs from CDN request IDs
+var deliveryIDNamespace = uuid.MustParse("b8e3c1d2-7f4a-4e59-a6d0-1c2f9e8b3a75")
+
+// pruneInterval is how often a follow forgets sessions long over
+const pruneInterval = time.Minute
+
+func main() {
+	configPath := flag.String("config", os.Getenv("ESV_CONFIG"), "path to a YAML config file")
+	sinkType := flag.String("sink", "", "sink type to write to, overriding the config")
+	format := flag.String("format", "", "log format: cloudfront, fastly or akamai")
+	tenant := flag.String("tenant", "", "tenant the events belong to")
+	follow := flag.Bool("follow", false, "tail the file given, reading the lines appended to it")
+	sessionKey := flag.String("session-key", "sid", "query parameter or cookie with the player's sessionId")
+	viewerKey := flag.String("viewer-key", "vid", "query parameter or cookie with the viewer's userId or anonymousId")
+	sessionGap := flag.Duration("session-gap", 30*time.Minute, "how far from a session's player events its requests may be")
+	refresh := flag.Duration("refresh", time.Minute, "how often player events of the current hour are read again")
+	videoPattern := flag.String("video-pattern", "", "regexp whose first group is the videoId in segment paths, for unmatched requests")
+	segments := flag.String("segments", ".ts,.m4s,.mp4,.m4a,.m4v,.aac,.cmfv,.cmfa,.webm", "file extensions of segment requests")
+	batchSize := flag.Int("batch", 500, "events per batch written")
+	flushInterval := flag.Duration("flush", time.Second, "longest a following ingest holds events before writing them")
+	dryRun := flag.Bool("dry-run", false, "read and count requests without writing them")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -format name [flags] [path ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	if *sinkType != "" {
+		cfg.Sink.Type = *sinkType
+		cfg.Sink.Fanout = nil
+		if err := cfg.Validate(); err != nil {
+			fatal("Invalid sink", err)
+		}
+	}
+	slog.SetDefault(cfg.ServerLogger(os.Stderr))
+
+	p, err := newParser(*format)
+	if err != nil {
+		fatal("Invalid format", err)
+	}
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	if *follow && (len(paths) != 1 || paths[0] == "-") {
+		fatal("Invalid paths", errors.New("-follow takes one file"))
+	}
+	files, err := logFiles(paths)
+	if err != nil {
+		fatal("Failed to list log files", err)
+	}
+
+	in := &ingester{
+		parser:     p,
+		cdn:        *format,
+		tenant:     *tenant,
+		sessionKey: *sessionKey,
+		viewerKey:  *viewerKey,
+		batchSize:  max(*batchSize, 1),
+		dryRun:     *dryRun,
+	}
+	if *tenant == "" && cfg.Tenancy.Enabled {
+		in.tenant = auth.DefaultTenant
+	}
+	for _, ext := range strings.Split(*segments, ",") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			in.segments = append(in.segments, strings.ToLower(ext))
+		}
+	}
+	if *videoPattern != "" {
+		in.videoPattern, err = regexp.Compile(*videoPattern)
+		if err != nil {
+			fatal("Invalid video pattern", err)
+		}
+	}
+	if !*dryRun {
+		in.sink, err = cfg.NewSink()
+		if err != nil {
+			fatal("Failed to create event sink", err)
+		}
+		in.sink = split.Wrap(in.sink, cfg.Sink.SplitLimits())
+	}
+	if querier, ok := in.sink.(sink.Querier); ok && *viewerKey != "" {
+		in.index = newSessionIndex(querier, queryTenant(in.tenant), *sessionGap, *refresh)
+	} else if *viewerKey != "" && !*dryRun {
+		slog.Warn("Sink can't be queried, requests are matched to sessions by their session key only", "sink", cfg.Sink.Type)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lines := make(chan string, 1024)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		if *follow {
+			readErr <- followFile(ctx, files[0], lines)
+			return
+		}
+		for _, file := range files {
+			if err := readFile(ctx, file, lines); err != nil {
+				readErr <- fmt.Errorf("%s: %w", file, err)
+				return
+			}
+		}
+		readErr <- nil
+	}()
+
+	started := time.Now()
+	err = in.run(ctx, lines, *flushInterval, *follow)
+	// The reader sent its error before closing lines; when run stopped
+	// first, it may be stuck reading standard input
+	select {
+	case readErr := <-readErr:
+		if err == nil && readErr != nil {
+			err = readErr
+		}
+	default:
+	}
+	if in.sink != nil {
+		if closeErr := closeSink(in.sink); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close event sink: %w", closeErr)
+		}
+	}
+
+	fmt.Printf("ingested %d delivery events in %d batches from %d lines in %s: %d matched to player sessions, %d other requests, %d invalid lines\n",
+		in.events, in.batches, in.lines, time.Since(started).Round(time.Millisecond), in.matched, in.skipped, in.invalid)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fatal("Ingest stopped", err)
+	}
+}
+
+// queryTenant is the tenant player events are queried in
+func queryTenant(tenant string) string {
+	if tenant == "" {
+		return auth.DefaultTenant
+	}
+	return tenant
+}
+
+// ingester turns log lines into delivery events and writes them in batches
+type ingester struct {
+	parser       parser
+	cdn          string
+	sink         sink.EventSink
+	index        *sessionIndex
+	tenant       string
+	sessionKey   string
+	viewerKey    string
+	videoPattern *regexp.Regexp
+	segments     []string
+	batchSize    int
+	dryRun       bool
+
+	pending []models.Event
+
+	lines   int
+	invalid int
+	skipped int
+	matched int
+	events  int
+	batches int
+}
+
+// run ingests lines until they run out, writing what's pending every
+// flushInterval when following
+func (in *ingester) run(ctx context.Context, lines <-chan string, flushInterval time.Duration, follow bool) error {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	lastPrune := time.Now()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return in.flush(ctx)
+			}
+			if err := in.handle(ctx, line); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if !follow {
+				continue
+			}
+			if err := in.flush(ctx); err != nil {
+				return err
+			}
+			if in.index != nil && time.Since(lastPrune) >= pruneInterval {
+				in.index.prune(time.Now().Add(-in.index.gap - 2*time.Hour))
+				lastPrune = time.Now()
+			}
+		case <-ctx.Done():
+			// The events read before the interrupt are still written
+			if err := in.flush(context.WithoutCancel(ctx)); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+func (in *ingester) handle(ctx context.Context, line string) error {
+	in.lines++
+	record, ok, err := in.parser.parse(line)
+	if err != nil {
+		in.invalid++
+		slog.Debug("Skipping invalid log line", "line", in.lines, "error", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	if !in.isSegment(record.path) {
+		in.skipped++
+		return nil
+	}
+
+	event, err := in.event(ctx, record)
+	if err != nil {
+		return err
+	}
+	in.pending = append(in.pending, event)
+	if len(in.pending) >= in.batchSize {
+		return in.flush(ctx)
+	}
+	return nil
+}
+
+func (in *ingester) isSegment(p string) bool {
+	return slices.Contains(in.segments, strings.ToLower(path.Ext(p)))
+}
+
+// event builds the delivery event of record, with the player session it
+// belongs to when one matches
+func (in *ingester) event(ctx context.Context, record logRecord) (models.Event, error) {
+	sessionID := in.lookup(record, in.sessionKey)
+	viewer := in.lookup(record, in.viewerKey)
+	var session *span
+	if in.index != nil && (sessionID != "" || viewer != "") {
+		var err error
+		session, err = in.index.match(ctx, sessionID, viewer, record.time)
+		if err != nil {
+			return models.Event{}, err
+		}
+	}
+
+	event := models.Event{
+		SchemaVersion: schema.Current,
+		EventName:     models.CDNDelivery,
+		Timestamp:     record.time.UTC().Format(time.RFC3339Nano),
+		SessionID:     sessionID,
+		AnonymousID:   viewer,
+		Technical: &models.Technical{
+			UserAgent: record.userAgent,
+			CDN:       in.cdn,
+			EdgePOP:   record.pop,
+		},
+	}
+	if record.requestID != "" {
+		event.EventID = uuid.NewSHA1(deliveryIDNamespace, []byte(in.cdn+"\x00"+record.requestID)).String()
+	}
+	if session != nil {
+		in.matched++
+		event.SessionID = session.sessionID
+		event.VideoID = session.videoID
+		event.UserID = session.userID
+		event.AnonymousID = session.anonymousID
+	}
+	if event.VideoID == "" && in.videoPattern != nil {
+		if m := in.videoPattern.FindStringSubmatch(record.path); len(m) > 1 {
+			event.VideoID = m[1]
+		}
+	}
+
+	data, err := json.Marshal(models.Delivery{
+		RequestID:   record.requestID,
+		Path:        record.path,
+		Status:      record.status,
+		Bytes:       record.bytes,
+		CacheStatus: record.cacheStatus,
+		TTFB:        record.ttfb,
+		Duration:    record.duration,
+		ClientIP:    record.clientIP,
+	})
+	if err != nil {
+		return models.Event{}, err
+	}
+	event.CustomData = string(data)
+	return event, nil
+}
+
+// lookup returns the value of the query parameter or, failing that, the
+// cookie named key
+func (in *ingester) lookup(record logRecord, key string) string {
+	if key == "" {
+		return ""
+	}
+	if query, err := url.ParseQuery(record.query); err == nil {
+		if v := query.Get(key); v != "" {
+			return v
+		}
+	}
+	if record.cookie == "" {
+		return ""
+	}
+	cookies, _ := http.ParseCookie(record.cookie)
+	for _, c := range cookies {
+		if c.Name == key {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// flush writes the pending events as one batch
+func (in *ingester) flush(ctx context.Context) error {
+	if len(in.pending) == 0 {
+		return nil
+	}
+	batch := models.EventBatch{
+		ClientID:      "cdn-ingest",
+		BatchID:       uuid.NewString(),
+		Timestamp:     time.Now().UTC().Format(time.RFC3339Nano),
+		SchemaVersion: schema.Current,
+		Tenant:        in.tenant,
+		Events:        in.pending,
+	}
+	batch.AssignEventIDs()
+	if !in.dryRun {
+		if err := in.sink.LogBatch(ctx, batch); err != nil {
+			return fmt.Errorf("batch %s: %w", batch.BatchID, err)
+		}
+	}
+	in.batches++
+	in.events += len(batch.Events)
+	in.pending = nil
+	return nil
+}
+
+// closeSink flushes and closes the sink so ingested batches are written
+func closeSink(eventSink sink.EventSink) error {
+	if flusher, ok := eventSink.(sink.Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	return eventSink.Close()
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
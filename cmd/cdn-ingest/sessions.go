@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+// queryPageSize is how many player events are read from the sink at once
+const queryPageSize = 1000
+
+// span is the stretch of time a player session sent events in
+type span struct {
+	sessionID   string
+	videoID     string
+	userID      string
+	anonymousID string
+	first, last time.Time
+}
+
+// distance is how far t lies outside the span, 0 within it
+func (s *span) distance(t time.Time) time.Duration {
+	switch {
+	case t.Before(s.first):
+		return s.first.Sub(t)
+	case t.After(s.last):
+		return t.Sub(s.last)
+	}
+	return 0
+}
+
+// sessionIndex finds the player session a CDN request belongs to. It reads
+// the player events stored in the sink an hour at a time, as the requests
+// it's asked about need them, and remembers the sessions of every viewer.
+type sessionIndex struct {
+	querier sink.Querier
+	tenant  string
+	// gap is how long before a session's first or after its last event
+	// its segment requests may come
+	gap time.Duration
+	// refresh is how soon an hour read before it ended is read again
+	refresh time.Duration
+
+	sessions map[string]*span
+	viewers  map[string][]*span
+	// loaded are the hours read so far and when they were read
+	loaded map[time.Time]time.Time
+}
+
+func newSessionIndex(querier sink.Querier, tenant string, gap, refresh time.Duration) *sessionIndex {
+	return &sessionIndex{
+		querier:  querier,
+		tenant:   tenant,
+		gap:      gap,
+		refresh:  refresh,
+		sessions: make(map[string]*span),
+		viewers:  make(map[string][]*span),
+		loaded:   make(map[time.Time]time.Time),
+	}
+}
+
+// match returns the session of sessionID, or the session of viewer closest
+// to t within the gap, or nil when there is none
+func (x *sessionIndex) match(ctx context.Context, sessionID, viewer string, t time.Time) (*span, error) {
+	if err := x.load(ctx, t); err != nil {
+		return nil, err
+	}
+	if sessionID != "" {
+		return x.sessions[sessionID], nil
+	}
+	var best *span
+	for _, s := range x.viewers[viewer] {
+		if d := s.distance(t); d <= x.gap && (best == nil || d < best.distance(t)) {
+			best = s
+		}
+	}
+	return best, nil
+}
+
+// load reads the hours around t not read yet, and the ones read before
+// they ended once refresh passed
+func (x *sessionIndex) load(ctx context.Context, t time.Time) error {
+	now := time.Now()
+	for hour := t.Add(-x.gap).Truncate(time.Hour); !hour.After(t.Add(x.gap)); hour = hour.Add(time.Hour) {
+		loadedAt, ok := x.loaded[hour]
+		if ok && (loadedAt.After(hour.Add(time.Hour+x.gap)) || now.Sub(loadedAt) < x.refresh) {
+			continue
+		}
+		if err := x.loadHour(ctx, hour); err != nil {
+			return err
+		}
+		x.loaded[hour] = now
+	}
+	return nil
+}
+
+func (x *sessionIndex) loadHour(ctx context.Context, hour time.Time) error {
+	q := sink.Query{Tenant: x.tenant, From: hour, To: hour.Add(time.Hour), Limit: queryPageSize}
+	for {
+		result, err := x.querier.QueryEvents(ctx, q)
+		if err != nil {
+			return fmt.Errorf("failed to read player events of %s: %w", hour.Format(time.RFC3339), err)
+		}
+		for _, record := range result.Events {
+			x.add(record)
+		}
+		if result.NextCursor == "" {
+			return nil
+		}
+		q.Cursor = result.NextCursor
+	}
+}
+
+// add extends the span of the record's session, leaving out the events of
+// earlier CDN ingests
+func (x *sessionIndex) add(record models.EventRecord) {
+	sessionID := record.SessionID
+	if sessionID == "" {
+		sessionID = record.BatchSessionID
+	}
+	if sessionID == "" || record.EventName == models.CDNDelivery {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, record.Timestamp)
+	if err != nil {
+		return
+	}
+
+	s, ok := x.sessions[sessionID]
+	if !ok {
+		s = &span{sessionID: sessionID, first: t, last: t}
+		x.sessions[sessionID] = s
+	}
+	s.first = minTime(s.first, t)
+	s.last = maxTime(s.last, t)
+	if s.videoID == "" {
+		s.videoID = record.VideoID
+	}
+	for _, id := range []string{record.UserID, record.AnonymousID} {
+		if id != "" && !x.hasViewer(id, s) {
+			x.viewers[id] = append(x.viewers[id], s)
+		}
+	}
+	if s.userID == "" {
+		s.userID = record.UserID
+	}
+	if s.anonymousID == "" {
+		s.anonymousID = record.AnonymousID
+	}
+}
+
+func (x *sessionIndex) hasViewer(viewer string, s *span) bool {
+	for _, known := range x.viewers[viewer] {
+		if known == s {
+			return true
+		}
+	}
+	return false
+}
+
+// prune forgets the sessions that ended and the hours that passed before
+// cutoff, which keeps a long-running follow from growing without bound
+func (x *sessionIndex) prune(cutoff time.Time) {
+	for hour := range x.loaded {
+		if hour.Add(time.Hour).Before(cutoff) {
+			delete(x.loaded, hour)
+		}
+	}
+	for id, s := range x.sessions {
+		if s.last.Before(cutoff) {
+			delete(x.sessions, id)
+		}
+	}
+	for viewer, spans := range x.viewers {
+		kept := spans[:0]
+		for _, s := range spans {
+			if !s.last.Before(cutoff) {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			delete(x.viewers, viewer)
+		} else {
+			x.viewers[viewer] = kept
+		}
+	}
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
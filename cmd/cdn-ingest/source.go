@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often a followed file is checked for new lines
+const pollInterval = time.Second
+
+// maxLineSize caps a log line; longer ones are cut off
+const maxLineSize = 1 << 20
+
+// logFiles expands directories in paths to the files in them, in name
+// order, which is time order for the CDNs' file names
+func logFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		if path == "-" {
+			files = append(files, path)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				names = append(names, filepath.Join(path, entry.Name()))
+			}
+		}
+		slices.Sort(names)
+		files = append(files, names...)
+	}
+	return files, nil
+}
+
+// readFile sends the lines of path, gunzipped when it ends in .gz, or of
+// standard input for -
+func readFile(ctx context.Context, path string, lines chan<- string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+		if strings.HasSuffix(path, ".gz") {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			r = gz
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+	for scanner.Scan() {
+		select {
+		case lines <- scanner.Text():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
+
+// followFile sends the lines appended to path from now on, like tail -F:
+// when the file is replaced by a rotation it continues with the new one
+// from its start, and when it is truncated from the start again
+func followFile(ctx context.Context, path string, lines chan<- string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(f, 64<<10)
+	var partial strings.Builder
+	for {
+		chunk, err := reader.ReadString('\n')
+		offset += int64(len(chunk))
+		if partial.Len()+len(chunk) <= maxLineSize {
+			partial.WriteString(chunk)
+		}
+		if err == nil {
+			select {
+			case lines <- strings.TrimRight(partial.String(), "\r\n"):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			partial.Reset()
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		// At the end of the file: wait for more, then check whether it
+		// was rotated or truncated meanwhile
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		current, statErr := os.Stat(path)
+		if statErr != nil {
+			// Between a rotation's rename and the new file's creation
+			continue
+		}
+		opened, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		switch {
+		case !os.SameFile(opened, current):
+			// Lines still written to the rotated file are read before
+			// switching; with none left, the new file takes over
+			if opened.Size() > offset {
+				continue
+			}
+			next, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f, offset = next, 0
+			reader.Reset(f)
+			partial.Reset()
+		case current.Size() < offset:
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			offset = 0
+			reader.Reset(f)
+			partial.Reset()
+		}
+	}
+}
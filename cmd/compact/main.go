@@ -0,0 +1,145 @@
+// Command compact rewrites the pretty-printed text logs of the file sink as
+// compact NDJSON and indexes them by session and event time, so queries of
+// the file sink read only the lines that may match instead of whole files.
+//
+//	compact [-config file] [-tenant name] [path ...]
+//
+// Paths are log files or directories of rotated ones; without paths the
+// configured logger directory is used, and with tenancy the directory of
+// every tenant in it. Every events-*.log, gzipped or not, becomes an
+// uncompressed events-*.ndjson of the same name, with its index in
+// events-*.ndjson.idx, and is removed. NDJSON files are left as they are.
+// The active events.log of a running collector isn't rotated and so isn't
+// touched; set logger.format to ndjson for new logs. Encrypted logs are
+// read with the configured keyring and stay encrypted, unindexed.
+//
+// Text logs don't record the tenant of their batches. With tenancy the
+// converted records get the tenant whose directory the file is in; -tenant
+// must name that tenant if given. Files elsewhere get -tenant, or the
+// default tenant when tenancy is enabled.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/config"
+	"github.com/adtyap26/event-stream-video/internal/logger"
+	"github.com/adtyap26/event-stream-video/internal/sink/tenant"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("ESV_CONFIG"), "path to a YAML config file")
+	tenantName := flag.String("tenant", "", "tenant recorded for the batches of text logs outside tenant directories")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [path ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	slog.SetDefault(cfg.ServerLogger(os.Stderr))
+	keys, err := cfg.NewKeyring()
+	if err != nil {
+		fatal("Failed to load the keyring", err)
+	}
+
+	var dirs []config.LogDir
+	if paths := flag.Args(); len(paths) > 0 {
+		for _, path := range paths {
+			dirs = append(dirs, config.LogDir{Path: path, Tenant: tenantDir(cfg, path)})
+		}
+	} else if dirs, err = cfg.LogDirs(); err != nil {
+		fatal("Failed to list log directories", err)
+	}
+
+	converted, total := 0, 0
+	for _, dir := range dirs {
+		recorded, err := recordedTenant(cfg, dir.Tenant, *tenantName)
+		if err != nil {
+			fatal("Refusing to compact log files", fmt.Errorf("%s: %w", dir.Path, err))
+		}
+		files, err := logFiles(dir.Path)
+		if err != nil {
+			fatal("Failed to list log files", err)
+		}
+		total += len(files)
+		for _, file := range files {
+			if !logger.IsText(file) {
+				continue
+			}
+			out, err := logger.ConvertText(file, recorded, keys)
+			if err != nil {
+				fatal("Failed to compact log file", err)
+			}
+			slog.Info("Compacted log file", "file", file, "to", out, "tenant", recorded)
+			converted++
+		}
+	}
+	fmt.Printf("compacted %d of %d log files\n", converted, total)
+}
+
+// tenantDir returns the tenant whose directory of the logger directory
+// path is, or is in, as config.LogDirs names them, or "" for other paths
+func tenantDir(cfg config.Config, path string) string {
+	if !cfg.Tenancy.Enabled {
+		return ""
+	}
+	dir := path
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	root, err := filepath.Abs(cfg.Logger.Dir)
+	if err != nil {
+		return ""
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil || filepath.Dir(abs) != root {
+		return ""
+	}
+	return filepath.Base(abs)
+}
+
+// recordedTenant picks the tenant recorded for the text logs of the
+// directory of tenant dirTenant, "" outside tenant directories, given the
+// -tenant flag
+func recordedTenant(cfg config.Config, dirTenant, flagTenant string) (string, error) {
+	switch {
+	case dirTenant == "" && flagTenant == "" && cfg.Tenancy.Enabled:
+		return auth.DefaultTenant, nil
+	case dirTenant == "":
+		return flagTenant, nil
+	case flagTenant == "":
+		return dirTenant, nil
+	case tenant.SafeName(flagTenant) != dirTenant:
+		return "", fmt.Errorf("-tenant %s conflicts with the directory of tenant %s", flagTenant, dirTenant)
+	}
+	// The directory name of tenants with unsafe characters in their name
+	// loses them
+	return flagTenant, nil
+}
+
+// logFiles expands a directory to the rotated log files in it
+func logFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	return logger.RotatedFiles(path)
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
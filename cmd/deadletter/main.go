@@ -0,0 +1,118 @@
+// Command deadletter lists the batches in the dead letter queue and
+// replays them into the configured sink.
+//
+//	deadletter [-config file] [-dir dir] list
+//	deadletter [-config file] [-dir dir] replay
+//
+// -dir reads another queue in the same format, such as the taxonomy's
+// quarantine.
+//
+// With the file sink, replay while the server is stopped: both would
+// otherwise write to the same log directory.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/adtyap26/event-stream-video/internal/config"
+	"github.com/adtyap26/event-stream-video/internal/deadletter"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/sink/split"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("ESV_CONFIG"), "path to a YAML config file")
+	dir := flag.String("dir", "", "queue directory, instead of deadLetter.dir from the config")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-config file] [-dir dir] list|replay\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	slog.SetDefault(cfg.ServerLogger(os.Stderr))
+
+	if *dir == "" {
+		*dir = cfg.DeadLetter.Dir
+	}
+	queue, err := deadletter.Open(*dir)
+	if err != nil {
+		fatal("Failed to open dead letter queue", err)
+	}
+
+	switch flag.Arg(0) {
+	case "list":
+		list(queue)
+	case "replay":
+		replay(cfg, queue)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// list prints one line per queued batch
+func list(queue *deadletter.Queue) {
+	entries, err := queue.List()
+	if err != nil {
+		fatal("Failed to read dead letter queue", err)
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s\ttenant=%s\tclient=%s\tbatch=%s\tevents=%d\t%s\n",
+			entry.FailedAt.Format("2006-01-02T15:04:05Z07:00"), entry.Batch.Tenant, entry.Batch.ClientID,
+			entry.Batch.BatchID, len(entry.Batch.Events), entry.Reason)
+	}
+	fmt.Printf("%d batches\n", len(entries))
+}
+
+// replay writes the queued batches to the sink from the configuration
+func replay(cfg config.Config, queue *deadletter.Queue) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	eventSink, err := cfg.NewSink()
+	if err != nil {
+		fatal("Failed to create event sink", err)
+	}
+	eventSink = split.Wrap(eventSink, cfg.Sink.SplitLimits())
+
+	result, err := queue.Replay(ctx, eventSink)
+	closeErr := closeSink(eventSink)
+
+	fmt.Printf("replayed %d batches, %d remaining\n", result.Replayed, result.Failed)
+	if err != nil {
+		fatal("Replay stopped", err)
+	}
+	if closeErr != nil {
+		fatal("Failed to close event sink", closeErr)
+	}
+}
+
+// closeSink flushes and closes the sink so replayed batches are written
+func closeSink(eventSink sink.EventSink) error {
+	if flusher, ok := eventSink.(sink.Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	return eventSink.Close()
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
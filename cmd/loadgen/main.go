@@ -0,0 +1,278 @@
+// Command loadgen simulates concurrent player sessions sending realistic
+// event sequences to a collector, to benchmark it and its sink before
+// launch.
+//
+//	loadgen [-target url] [-api-key key] [-sessions n] [-rps n] [-duration d] [flags]
+//
+// Each session plays a video from start to end (play, timeupdate,
+// buffering, seeks, pauses, ended) and posts its events in batches; a new
+// session takes over once a video ends. -rps caps the requests per second
+// of all sessions together. A summary of requests, events, responses by
+// status and latency percentiles is printed at the end.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+)
+
+// maxLatencySamples caps the latencies kept for percentiles; later ones
+// replace kept ones at random, so long runs keep a fair sample
+const maxLatencySamples = 100_000
+
+// reportInterval is how often progress is logged
+const reportInterval = 5 * time.Second
+
+func main() {
+	target := flag.String("target", "http://localhost:8080", "base URL of the collector")
+	apiKey := flag.String("api-key", os.Getenv("ESV_API_KEY"), "API key sent with every batch")
+	sessions := flag.Int("sessions", 50, "concurrent player sessions")
+	requestsPerSecond := flag.Float64("rps", 0, "maximum requests per second of all sessions, 0 for no limit")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run")
+	batchSize := flag.Int("batch", 10, "events per batch")
+	payloadSize := flag.Int("payload", 0, "bytes of customData padding per event")
+	videos := flag.Int("videos", 100, "distinct videos sessions pick from")
+	videoLength := flag.Duration("video-length", 10*time.Minute, "longest simulated video")
+	compress := flag.Bool("compress", false, "gzip request bodies")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each request")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *sessions <= 0 || *batchSize <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	g := &generator{
+		endpoint:    strings.TrimSuffix(*target, "/") + "/api/v1/events",
+		apiKey:      *apiKey,
+		batchSize:   *batchSize,
+		payloadSize: *payloadSize,
+		videos:      *videos,
+		videoLength: *videoLength,
+		compress:    *compress,
+		client: &http.Client{
+			Timeout:   *timeout,
+			Transport: &http.Transport{MaxIdleConnsPerHost: *sessions},
+		},
+		statuses: make(map[string]int),
+	}
+	if *requestsPerSecond > 0 {
+		g.limiter = rate.NewLimiter(rate.Limit(*requestsPerSecond), max(1, int(*requestsPerSecond)))
+	}
+
+	slog.Info("Generating load", "endpoint", g.endpoint, "sessions", *sessions, "rps", *requestsPerSecond,
+		"duration", *duration, "batch", *batchSize)
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := range *sessions {
+		wg.Go(func() {
+			g.play(ctx, rand.New(rand.NewPCG(uint64(started.UnixNano()), uint64(i))))
+		})
+	}
+	reportDone := make(chan struct{})
+	go func() {
+		defer close(reportDone)
+		g.report(ctx, started)
+	}()
+	wg.Wait()
+	<-reportDone
+
+	g.summary(os.Stdout, time.Since(started))
+}
+
+// generator runs the sessions and records how the collector responded
+type generator struct {
+	endpoint    string
+	apiKey      string
+	batchSize   int
+	payloadSize int
+	videos      int
+	videoLength time.Duration
+	compress    bool
+	client      *http.Client
+	limiter     *rate.Limiter
+
+	mu        sync.Mutex
+	requests  int
+	events    int
+	bytes     int64
+	sessions  int
+	statuses  map[string]int
+	latencies []time.Duration
+	observed  int
+}
+
+// play runs one simulated viewer until ctx ends, starting a new session
+// whenever the last one ended
+func (g *generator) play(ctx context.Context, rng *rand.Rand) {
+	for ctx.Err() == nil {
+		s := newSession(rng, g.videos, g.payloadSize, g.videoLength)
+		g.mu.Lock()
+		g.sessions++
+		g.mu.Unlock()
+
+		for !s.ended() {
+			if g.limiter != nil && g.limiter.Wait(ctx) != nil {
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			g.send(ctx, s.id, s.next(g.batchSize))
+		}
+	}
+}
+
+// send posts one batch and records the outcome. Requests cut short by the
+// end of the run are not counted.
+func (g *generator) send(ctx context.Context, sessionID string, events []models.Event) {
+	batch := models.EventBatch{
+		ClientID:      "loadgen",
+		SessionID:     sessionID,
+		BatchID:       uuid.NewString(),
+		Events:        events,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339Nano),
+		SchemaVersion: schema.Current,
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		slog.Error("Error encoding batch", "error", err)
+		return
+	}
+	if g.compress {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		gz.Close()
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Error creating request", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Analytics-Client", "esv-loadgen")
+	if g.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if g.apiKey != "" {
+		req.Header.Set("X-API-Key", g.apiKey)
+	}
+
+	sent := time.Now()
+	resp, err := g.client.Do(req)
+	latency := time.Since(sent)
+	status := "error"
+	if err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		status = fmt.Sprint(resp.StatusCode)
+	} else if ctx.Err() != nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requests++
+	g.events += len(events)
+	g.bytes += int64(len(body))
+	g.statuses[status]++
+	g.observe(latency)
+}
+
+// observe keeps latency in a uniform sample of at most maxLatencySamples
+func (g *generator) observe(latency time.Duration) {
+	g.observed++
+	if len(g.latencies) < maxLatencySamples {
+		g.latencies = append(g.latencies, latency)
+		return
+	}
+	if i := rand.IntN(g.observed); i < maxLatencySamples {
+		g.latencies[i] = latency
+	}
+}
+
+// report logs the request rate every reportInterval until ctx ends
+func (g *generator) report(ctx context.Context, started time.Time) {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	last, lastRequests := started, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			g.mu.Lock()
+			requests, events := g.requests, g.events
+			g.mu.Unlock()
+			rps := float64(requests-lastRequests) / now.Sub(last).Seconds()
+			slog.Info("Progress", "requests", requests, "events", events, "rps", fmt.Sprintf("%.1f", rps))
+			last, lastRequests = now, requests
+		}
+	}
+}
+
+// summary prints the totals, rates, responses by status and latencies
+func (g *generator) summary(w io.Writer, elapsed time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	seconds := elapsed.Seconds()
+	fmt.Fprintf(w, "duration   %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "sessions   %d\n", g.sessions)
+	fmt.Fprintf(w, "requests   %d (%.1f/s)\n", g.requests, float64(g.requests)/seconds)
+	fmt.Fprintf(w, "events     %d (%.1f/s)\n", g.events, float64(g.events)/seconds)
+	fmt.Fprintf(w, "sent       %.1f MiB (%.2f MiB/s)\n", float64(g.bytes)/(1<<20), float64(g.bytes)/(1<<20)/seconds)
+
+	statuses := make([]string, 0, len(g.statuses))
+	for status := range g.statuses {
+		statuses = append(statuses, status)
+	}
+	slices.Sort(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "status %-5s %d\n", status, g.statuses[status])
+	}
+
+	if len(g.latencies) == 0 {
+		return
+	}
+	slices.Sort(g.latencies)
+	percentile := func(p float64) time.Duration {
+		return g.latencies[min(int(p*float64(len(g.latencies))), len(g.latencies)-1)]
+	}
+	fmt.Fprintf(w, "latency    p50 %s  p90 %s  p99 %s  max %s\n",
+		percentile(0.5).Round(time.Microsecond), percentile(0.9).Round(time.Microsecond),
+		percentile(0.99).Round(time.Microsecond), g.latencies[len(g.latencies)-1].Round(time.Microsecond))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+)
+
+// Chances, per simulated second of playback, of the player stalling,
+// seeking or being paused
+const (
+	bufferChance = 0.01
+	seekChance   = 0.005
+	pauseChance  = 0.005
+)
+
+// timeupdateInterval is how far playback moves between timeupdate events,
+// as often as browsers fire them
+const timeupdateInterval = 250 * time.Millisecond
+
+// userAgents are the browsers simulated sessions claim to be
+var userAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (X11; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0",
+}
+
+// session plays one video from start to end, producing the events a
+// browser player would: playerInit, loadstart, play and playing, then
+// timeupdates interrupted by buffering, seeks and pauses, and finally
+// ended
+type session struct {
+	id       string
+	videoID  string
+	duration float64
+	position float64
+	rng      *rand.Rand
+	// pending are events produced but not taken yet
+	pending []models.Event
+	started bool
+	done    bool
+
+	technical models.Technical
+	padding   string
+}
+
+// newSession starts a session of one of videos videos. Events carry
+// customData padded to about payloadSize bytes.
+func newSession(rng *rand.Rand, videos, payloadSize int, maxDuration time.Duration) *session {
+	s := &session{
+		id:       uuid.NewString(),
+		videoID:  fmt.Sprintf("video-%d", rng.IntN(max(videos, 1))),
+		duration: 30 + rng.Float64()*max(maxDuration.Seconds()-30, 0),
+		rng:      rng,
+		technical: models.Technical{
+			UserAgent:        userAgents[rng.IntN(len(userAgents))],
+			ScreenResolution: "1920x1080",
+			ViewportSize:     "1280x720",
+			PlayerSize:       "1280x720",
+			ConnectionType:   "4g",
+		},
+	}
+	if payloadSize > 0 {
+		s.padding = fmt.Sprintf(`{"padding":%q}`, strings.Repeat("x", payloadSize))
+	}
+	return s
+}
+
+// next returns the session's next n events, fewer once it ended
+func (s *session) next(n int) []models.Event {
+	for len(s.pending) < n && !s.done {
+		s.advance()
+	}
+	n = min(n, len(s.pending))
+	events := s.pending[:n:n]
+	s.pending = s.pending[n:]
+	return events
+}
+
+// ended reports whether every event of the session was taken
+func (s *session) ended() bool {
+	return s.done && len(s.pending) == 0
+}
+
+// advance produces the events of the next step of playback
+func (s *session) advance() {
+	if !s.started {
+		s.started = true
+		s.emit("playerInit", false)
+		s.emit("loadstart", false)
+		s.emit("play", false)
+		s.emit("playing", false)
+		return
+	}
+
+	step := timeupdateInterval.Seconds()
+	switch r := s.rng.Float64(); {
+	case r < bufferChance*step:
+		s.emit("waiting", false)
+		s.emit("playing", false)
+	case r < (bufferChance+seekChance)*step:
+		s.emit("seeking", false)
+		s.position = s.rng.Float64() * s.duration
+		s.emit("seeked", false)
+	case r < (bufferChance+seekChance+pauseChance)*step:
+		s.emit("pause", true)
+		s.emit("play", false)
+		s.emit("playing", false)
+	}
+
+	s.position = min(s.position+step, s.duration)
+	if s.position >= s.duration {
+		s.emit("ended", true)
+		s.done = true
+		return
+	}
+	s.emit("timeupdate", false)
+}
+
+func (s *session) emit(name string, paused bool) {
+	technical := s.technical
+	s.pending = append(s.pending, models.Event{
+		EventID:       uuid.NewString(),
+		SchemaVersion: schema.Current,
+		EventName:     name,
+		VideoID:       s.videoID,
+		Timestamp:     time.Now().UTC().Format(time.RFC3339Nano),
+		SessionID:     s.id,
+		PlaybackState: &models.PlaybackState{
+			CurrentTime:  s.position,
+			Duration:     s.duration,
+			Paused:       paused,
+			Ended:        name == "ended",
+			PlaybackRate: 1,
+			Volume:       1,
+			ReadyState:   4,
+			Bitrate:      2_500_000,
+			Quality:      "720p",
+		},
+		Technical:  &technical,
+		CustomData: s.padding,
+	})
+}
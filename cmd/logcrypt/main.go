@@ -0,0 +1,170 @@
+// Command logcrypt decrypts the encrypted log files of the file sink and
+// makes keys for local keyrings.
+//
+//	logcrypt [-config file] [-keyring file] [-out dir] decrypt [path ...]
+//	logcrypt genkey
+//
+// decrypt writes the plaintext of the files to stdout, or with -out to one
+// file each in dir, gunzipped. Paths are log files or directories of rotated
+// ones; without paths the configured logger directory is used. The active
+// events.ndjson of a directory is left out, but may be named as a path.
+// Batches that were written unencrypted are passed through as they are.
+//
+// The keys come from logger.encryption in the config, whether or not
+// encryption is still enabled, so files written before it was turned off
+// stay readable.
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/adtyap26/event-stream-video/internal/config"
+	"github.com/adtyap26/event-stream-video/internal/encryption"
+	"github.com/adtyap26/event-stream-video/internal/logger"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("ESV_CONFIG"), "path to a YAML config file")
+	keyring := flag.String("keyring", "", "local keyring, instead of logger.encryption.keyring from the config")
+	outDir := flag.String("out", "", "directory to write decrypted files to, instead of stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] decrypt [path ...]\n       %s genkey\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch flag.Arg(0) {
+	case "genkey":
+		fmt.Println(encryption.NewKey())
+	case "decrypt":
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			fatal("Failed to load config", err)
+		}
+		slog.SetDefault(cfg.ServerLogger(os.Stderr))
+		if *keyring != "" {
+			cfg.Logger.Encryption.Keyring = *keyring
+		}
+		paths := flag.Args()[1:]
+		if len(paths) == 0 {
+			paths = []string{cfg.Logger.Dir}
+		}
+		decrypt(cfg.Logger.Encryption, paths, *outDir)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// decrypt writes the plaintext of the log files in paths to stdout or outDir
+func decrypt(cfg encryption.Config, paths []string, outDir string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	keys, err := encryption.New(ctx, cfg)
+	if err != nil {
+		fatal("Failed to load log encryption keys", err)
+	}
+	files, err := logFiles(paths)
+	if err != nil {
+		fatal("Failed to list log files", err)
+	}
+
+	stdout := bufio.NewWriter(os.Stdout)
+	for _, file := range files {
+		if outDir == "" {
+			err = decryptFile(ctx, file, keys, stdout)
+		} else {
+			err = decryptTo(ctx, file, keys, outDir)
+		}
+		if err != nil {
+			stdout.Flush()
+			fatal("Failed to decrypt "+file, err)
+		}
+	}
+	if err := stdout.Flush(); err != nil {
+		fatal("Failed to write output", err)
+	}
+}
+
+// decryptTo writes the plaintext of file to a file of the same name in dir,
+// without .gz
+func decryptTo(ctx context.Context, file string, keys *encryption.Keyring, dir string) error {
+	name := strings.TrimSuffix(filepath.Base(file), ".gz")
+	out, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	err = decryptFile(ctx, file, keys, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// decryptFile copies the plaintext of file to w
+func decryptFile(ctx context.Context, file string, keys *encryption.Keyring, w io.Writer) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	_, err = io.Copy(w, encryption.NewReader(ctx, r, keys))
+	return err
+}
+
+// logFiles expands directories in paths to the rotated log files in them
+func logFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		rotated, err := logger.RotatedFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, rotated...)
+	}
+	return files, nil
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,71 @@
+// Command replay reads archived event logs and writes their batches to a
+// sink, e.g. to backfill a new ClickHouse table from file logs.
+//
+//	replay [-config file] [-sink type] [-rate events/s] [-tenant name] [-archive] [-dry-run] [path ...]
+//
+// Paths are log files (events-*.log or *.ndjson, optionally gzipped) or
+// directories, whose rotated files are replayed oldest first. Without
+// paths the configured logger directory is used. The active events.* file
+// of a running collector is skipped; rotate it first.
+//
+// -archive first replays the logs the archiver moved to cold storage, as
+// listed by the manifest in its bucket. Files in Glacier are restored
+// before they can be read: their restore is requested and they are skipped,
+// to be replayed by running again once it completes.
+//
+// Batches are written as they were logged; the sink is responsible for
+// skipping ones it already holds.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/config"
+	"github.com/adtyap26/event-stream-video/internal/replay"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv("ESV_CONFIG"), "path to a YAML config file")
+	var opts replay.Options
+	opts.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [path ...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	opts.Paths = flag.Args()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	slog.SetDefault(cfg.ServerLogger(os.Stderr))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	started := time.Now()
+	result, err := replay.Run(ctx, cfg, opts)
+	fmt.Printf("%s in %s\n", result, time.Since(started).Round(time.Millisecond))
+	if errors.Is(err, replay.ErrRestoring) {
+		fmt.Printf("%d archived files are being restored from cold storage, replay again once they are\n", result.Restoring)
+		os.Exit(1)
+	}
+	if err != nil {
+		fatal("Replay stopped", err)
+	}
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
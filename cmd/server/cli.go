@@ -0,0 +1,218 @@
+// Command server runs the event collector and the maintenance tasks that
+// go with it.
+//
+//	server [command] [flags] [args]
+//
+// The commands are:
+//
+//	serve            run the collector, the default without a command
+//	validate-config  load the config and report whether it is valid
+//	replay           write the batches of event logs to the sink, as cmd/replay
+//	gzip-logs        gzip rotated event logs and remove those beyond maxFiles
+//
+// To rewrite text logs as indexed NDJSON instead of gzipping them, run
+// cmd/compact.
+//
+// Every command takes -config, the YAML config file (ESV_CONFIG when
+// unset), and -log-level, which overrides server.logLevel. serve also takes
+// -port, which overrides server.port. Flags win over ESV_* environment
+// variables, which win over the file.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/config"
+	"github.com/adtyap26/event-stream-video/internal/logger"
+	"github.com/adtyap26/event-stream-video/internal/replay"
+)
+
+// command is a subcommand of the server binary
+type command struct {
+	summary string
+	run     func(name string, args []string)
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve":           {"run the collector, the default without a command", serve},
+		"validate-config": {"load the config and report whether it is valid", validateConfig},
+		"replay":          {"write the batches of event logs to the sink", replayLogs},
+		"gzip-logs":       {"gzip rotated event logs and remove those beyond maxFiles", gzipLogs},
+	}
+}
+
+// commandOrder lists the commands in usage
+var commandOrder = []string{"serve", "validate-config", "replay", "gzip-logs"}
+
+func main() {
+	// Without a command the flags are serve's, as before there were
+	// commands
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	cmd.run(name, args)
+}
+
+func usage(w *os.File) {
+	fmt.Fprintf(w, "usage: %s [command] [flags] [args]\n\ncommands:\n", os.Args[0])
+	for _, name := range commandOrder {
+		fmt.Fprintf(w, "  %-16s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(w, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
+
+// options are the flags the commands share
+type options struct {
+	configPath string
+	logLevel   string
+	// port is only a flag of serve
+	port int
+}
+
+// flags returns the flag set of the command called name with the shared
+// flags registered
+func (o *options) flags(name, args string, port bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&o.configPath, "config", os.Getenv("ESV_CONFIG"), "path to a YAML config file")
+	fs.StringVar(&o.logLevel, "log-level", "", "minimum level of log records: debug, info, warn or error, overriding the config")
+	if port {
+		fs.IntVar(&o.port, "port", 0, "port to listen on, overriding the config")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s [flags]%s\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// load reads the config and applies the flags on top of it
+func (o *options) load() (config.Config, error) {
+	cfg, err := config.Load(o.configPath)
+	if err != nil {
+		return config.Config{}, err
+	}
+	if o.port == 0 && o.logLevel == "" {
+		return cfg, nil
+	}
+	if o.port != 0 {
+		cfg.Server.Port = o.port
+	}
+	if o.logLevel != "" {
+		cfg.Server.LogLevel = o.logLevel
+	}
+	if err := cfg.Validate(); err != nil {
+		return config.Config{}, fmt.Errorf("invalid flags: %w", err)
+	}
+	return cfg, nil
+}
+
+// validateConfig loads the config the way serve would and reports whether
+// it is valid, exiting with 1 when it isn't
+func validateConfig(name string, args []string) {
+	var opts options
+	fs := opts.flags(name, "", true)
+	fs.Parse(args)
+
+	cfg, err := opts.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	source := opts.configPath
+	if source == "" {
+		source = "the default config"
+	}
+	fmt.Printf("%s is valid: port %d, sink %s, pipeline %s\n",
+		source, cfg.Server.Port, cfg.Sink.Type, strings.Join(cfg.Pipeline.Processors, ","))
+}
+
+// replayLogs writes the batches of event logs to the sink, like cmd/replay
+func replayLogs(name string, args []string) {
+	var opts options
+	var replayOpts replay.Options
+	fs := opts.flags(name, " [path ...]", false)
+	replayOpts.RegisterFlags(fs)
+	fs.Parse(args)
+	replayOpts.Paths = fs.Args()
+
+	cfg, err := opts.load()
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	slog.SetDefault(cfg.ServerLogger(os.Stderr))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	started := time.Now()
+	result, err := replay.Run(ctx, cfg, replayOpts)
+	fmt.Printf("%s in %s\n", result, time.Since(started).Round(time.Millisecond))
+	if errors.Is(err, replay.ErrRestoring) {
+		fmt.Printf("%d archived files are being restored from cold storage, replay again once they are\n", result.Restoring)
+		os.Exit(1)
+	}
+	if err != nil {
+		fatal("Replay stopped", err)
+	}
+}
+
+// gzipLogs gzips the rotated event logs of the logger directory and its
+// tenant directories, or of the directories given, and removes the oldest
+// beyond maxFiles, e.g. the logs a collector rotated with compression off
+func gzipLogs(name string, args []string) {
+	var opts options
+	fs := opts.flags(name, " [dir ...]", false)
+	maxFiles := fs.Int("max-files", -1, "rotated files to keep in each directory, 0 for all (default logger.maxFiles)")
+	fs.Parse(args)
+
+	cfg, err := opts.load()
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	slog.SetDefault(cfg.ServerLogger(os.Stderr))
+
+	keep := cfg.Logger.MaxFiles
+	if *maxFiles >= 0 {
+		keep = *maxFiles
+	}
+	dirs := fs.Args()
+	if len(dirs) == 0 {
+		logDirs, err := cfg.LogDirs()
+		if err != nil {
+			fatal("Failed to list log directories", err)
+		}
+		for _, dir := range logDirs {
+			dirs = append(dirs, dir.Path)
+		}
+	}
+	for _, dir := range dirs {
+		compressed, removed, err := logger.CompressRotated(dir, keep)
+		if err != nil {
+			fatal("Failed to gzip event logs", fmt.Errorf("%s: %w", dir, err))
+		}
+		fmt.Printf("%s: compressed %d files, removed %d\n", dir, compressed, removed)
+	}
+}
@@ -1,30 +1,552 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/adtyap26/event-stream-video/internal/api"
-	"github.com/adtyap26/event-stream-video/internal/logger"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/deadletter"
+	"github.com/adtyap26/event-stream-video/internal/erasure"
+	"github.com/adtyap26/event-stream-video/internal/mqtt"
+	"github.com/adtyap26/event-stream-video/internal/quota"
+	"github.com/adtyap26/event-stream-video/internal/rollup"
+	"github.com/adtyap26/event-stream-video/internal/session"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/sink/split"
+	"github.com/adtyap26/event-stream-video/internal/sink/toggle"
+	"github.com/adtyap26/event-stream-video/internal/stream"
+	"github.com/adtyap26/event-stream-video/internal/tracing"
 )
 
-func main() {
-	// Create event logger
-	eventLogger, err := logger.NewEventLogger()
+// serve runs the collector until SIGINT or SIGTERM
+func serve(name string, args []string) {
+	var opts options
+	fs := opts.flags(name, "", true)
+	fs.Parse(args)
+
+	cfg, err := opts.load()
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+	slog.SetDefault(cfg.ServerLogger(os.Stderr))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	// Create event sink
+	eventSink, err := cfg.NewSink()
+	if err != nil {
+		fatal("Failed to create event sink", err)
+	}
+	// The SQL sink keeps metric rollups in tables next to the events
+	rollupStore, _ := eventSink.(rollup.Store)
+	// Batches over the sink's limits are written in parts
+	eventSink = split.Wrap(eventSink, cfg.Sink.SplitLimits())
+
+	// The admin API can switch the sink off, e.g. for database maintenance;
+	// batches then wait in the buffer or go to the dead letter queue
+	var sinkToggle *toggle.Sink
+	if cfg.Admin.Token != "" {
+		sinkToggle = toggle.Wrap(cfg.Sink.Type, eventSink)
+		eventSink = sinkToggle
+	}
+
+	// Batches the sink rejects are kept for cmd/deadletter to replay
+	deadLetters, err := cfg.OpenDeadLetterQueue()
+	if err != nil {
+		fatal("Failed to open dead letter queue", err)
+	}
+
+	// Buffered batches wait in Redis until a collector has stored them
+	buffer, err := cfg.NewBuffer(eventSink, deadLetters)
 	if err != nil {
-		log.Fatalf("Failed to create event logger: %v", err)
+		fatal("Failed to start Redis buffer", err)
+	}
+	if buffer != nil {
+		eventSink = buffer
 	}
-	defer eventLogger.Close()
 
-	// Set up API routes with the event logger
-	router := api.SetupRoutes(eventLogger)
+	// Logged batches wait on local disk until they are shipped to the sink
+	walLog, err := cfg.OpenWAL(eventSink, deadLetters)
+	if err != nil {
+		fatal("Failed to open write-ahead log", err)
+	}
+	if walLog != nil {
+		eventSink = walLog
+	}
+	if deadLetters != nil {
+		eventSink = deadletter.Wrap(eventSink, deadLetters)
+	}
 
-	// Start server
-	port := 8080
-	log.Printf("Starting server on http://localhost:%d", port)
-	log.Printf("Test page available at http://localhost:%d/index.html", port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), router); err != nil {
-		log.Fatalf("Server error: %v", err)
+	// Old log and Parquet files move to cold storage
+	archiver, err := cfg.NewArchiver()
+	if err != nil {
+		fatal("Failed to start archiver", err)
+	}
+	if archiver != nil {
+		defer archiver.Close()
+	}
+
+	// Load API keys; authentication is disabled when none are configured
+	keyStore, err := auth.NewKeyStore(cfg.Auth.KeysFile, cfg.Auth.Keys)
+	if err != nil {
+		fatal("Failed to load API keys", err)
+	}
+
+	coord, err := cfg.NewCoordinator()
+	if err != nil {
+		fatal("Failed to connect to the coordination store", err)
+	}
+	if coord != nil {
+		defer coord.Close()
+	}
+	deduplicator, err := cfg.NewDeduplicator(coord)
+	if err != nil {
+		fatal("Failed to create deduplicator", err)
+	}
+	eventDeduplicator, err := cfg.NewEventDeduplicator(coord)
+	if err != nil {
+		fatal("Failed to create event deduplicator", err)
+	}
+	ledger, err := cfg.NewBatchLedger(coord)
+	if err != nil {
+		fatal("Failed to create batch ledger", err)
+	}
+
+	corsPolicy, err := cfg.CORSPolicy()
+	if err != nil {
+		fatal("Invalid CORS policy", err)
+	}
+
+	routeOpts := []api.Option{
+		api.WithCORSPolicy(corsPolicy),
+		api.WithValidationLimits(cfg.ValidationLimits()),
+		api.WithStaticDir(cfg.Server.StaticDir),
+		api.WithMaxDecompressedSize(cfg.Server.MaxDecompressedSize),
+		api.WithMaxBodySize(cfg.Limits.MaxBodySize),
+		api.WithTimestampNormalizer(cfg.TimestampNormalizer()),
+		api.WithPipeline(cfg.Pipeline.Processors),
+		api.WithTimeouts(cfg.Server.Timeouts.Read, cfg.Server.Timeouts.Write),
+	}
+	for route, size := range cfg.Limits.Endpoints {
+		routeOpts = append(routeOpts, api.WithBodyLimit(route, size))
+	}
+	for route, timeout := range cfg.Server.Timeouts.Routes {
+		routeOpts = append(routeOpts, api.WithRouteTimeout(route, timeout))
+	}
+	if cfg.API.V2 {
+		routeOpts = append(routeOpts, api.WithV2())
+	}
+	for _, d := range cfg.API.Deprecations {
+		routeOpts = append(routeOpts, api.WithDeprecation(d.Version, api.Deprecation{At: d.Date, Sunset: d.Sunset, Link: d.Link}))
+	}
+	if deduplicator != nil {
+		routeOpts = append(routeOpts, api.WithDeduplicator(deduplicator))
+		defer deduplicator.Close()
+	}
+	if eventDeduplicator != nil {
+		routeOpts = append(routeOpts, api.WithEventDeduplicator(eventDeduplicator))
+		defer eventDeduplicator.Close()
+	}
+	if ledger != nil {
+		routeOpts = append(routeOpts, api.WithBatchLedger(ledger))
+		defer ledger.Close()
+	}
+	var tracker *session.Tracker
+	if cfg.Sessions.Enabled {
+		tracker = session.NewTracker(eventSink, cfg.Sessions.Timeout, cfg.Sessions.ConcurrencyWindow)
+		if coord != nil {
+			tracker.ShareState(coord.Sessions(cfg.Sessions.Timeout))
+		}
+		routeOpts = append(routeOpts, api.WithSessionTracker(tracker))
+	}
+	identities, err := cfg.NewIdentityGraph()
+	if err != nil {
+		fatal("Failed to load identity links", err)
+	}
+	if identities != nil {
+		routeOpts = append(routeOpts, api.WithIdentities(identities))
+		defer identities.Close()
+		if tracker != nil {
+			tracker.OnIdentify(func(id session.Identity) {
+				if _, err := identities.Link(id.Tenant, id.AnonymousID, id.UserID); err != nil {
+					slog.Error("Error recording identity link", "sessionId", id.SessionID, "error", err)
+				}
+			})
+			if cfg.Identities.MergeSessions {
+				tracker.MergeIdentified()
+			}
+		}
+	}
+	if aggregator := cfg.NewQoEAggregator(); aggregator != nil {
+		tracker.OnSessionEnd(aggregator.Record)
+		routeOpts = append(routeOpts, api.WithQoE(aggregator))
+	}
+	if stats := cfg.NewVideoStats(); stats != nil {
+		tracker.OnSessionEnd(stats.Record)
+		routeOpts = append(routeOpts, api.WithVideoStats(stats))
+	}
+	rollups := cfg.NewRollups(rollupStore)
+	if rollups != nil {
+		tracker.OnProgress(rollups.Record)
+		routeOpts = append(routeOpts, api.WithRollups(rollups))
+	}
+	if recorder := cfg.NewActivityRecorder(); recorder != nil {
+		routeOpts = append(routeOpts, api.WithDashboard(recorder))
+	}
+	if groups := cfg.NewErrorGroups(); groups != nil {
+		routeOpts = append(routeOpts, api.WithErrorGroups(groups))
+	}
+	if detector := cfg.NewAnomalyDetector(); detector != nil {
+		if tracker != nil {
+			tracker.OnSessionEnd(detector.RecordSession)
+		}
+		routeOpts = append(routeOpts, api.WithAnomalyDetector(detector))
+		defer detector.Close()
+	}
+	webhooks, err := cfg.NewWebhooks()
+	if err != nil {
+		fatal("Failed to start webhooks", err)
+	}
+	if webhooks != nil {
+		routeOpts = append(routeOpts, api.WithWebhooks(webhooks))
+		defer webhooks.Close()
+	}
+	bridge, err := cfg.NewMQTTBridge()
+	if err != nil {
+		fatal("Failed to create MQTT bridge", err)
+	}
+	if bridge != nil {
+		routeOpts = append(routeOpts, api.WithMQTT(bridge))
+	}
+	var broker *stream.Broker
+	if cfg.Stream.Enabled {
+		broker = stream.NewBroker()
+		routeOpts = append(routeOpts, api.WithEventStream(broker))
+	}
+	enricher, err := cfg.NewEnricher()
+	if err != nil {
+		fatal("Failed to create enricher", err)
+	}
+	if enricher != nil {
+		routeOpts = append(routeOpts, api.WithEnricher(enricher))
+		defer enricher.Close()
+	}
+	regionRouter, err := cfg.NewRegionRouter()
+	if err != nil {
+		fatal("Failed to create region router", err)
+	}
+	if regionRouter != nil {
+		if cfg.Enrichment.GeoIPDatabase == "" {
+			slog.Warn("Regions are configured without a GeoIP database, every client gets the default region")
+		}
+		routeOpts = append(routeOpts, api.WithRegions(regionRouter))
+	}
+	sdkConfig := cfg.NewSDKConfig()
+	if sdkConfig != nil {
+		routeOpts = append(routeOpts, api.WithSDKConfig(sdkConfig))
+	}
+	scrubber, err := cfg.NewScrubber()
+	if err != nil {
+		fatal("Failed to create scrubber", err)
+	}
+	if scrubber != nil {
+		routeOpts = append(routeOpts, api.WithScrubber(scrubber))
+	}
+	botFilter, err := cfg.NewBotFilter()
+	if err != nil {
+		fatal("Failed to create bot filter", err)
+	}
+	if botFilter != nil {
+		routeOpts = append(routeOpts, api.WithBotFilter(botFilter))
 	}
+	transforms, err := cfg.NewTransforms()
+	if err != nil {
+		fatal("Failed to compile transformation rules", err)
+	}
+	if transforms != nil {
+		routeOpts = append(routeOpts, api.WithTransforms(transforms))
+	}
+	metadataResolver, err := cfg.NewMetadataResolver()
+	if err != nil {
+		fatal("Failed to create video metadata resolver", err)
+	}
+	if metadataResolver != nil {
+		routeOpts = append(routeOpts, api.WithMetadata(metadataResolver))
+	}
+	eventTaxonomy, err := cfg.NewTaxonomy()
+	if err != nil {
+		fatal("Failed to create event taxonomy", err)
+	}
+	if eventTaxonomy != nil {
+		routeOpts = append(routeOpts, api.WithTaxonomy(eventTaxonomy))
+	}
+	dimensionRegistry, err := cfg.NewDimensionRegistry()
+	if err != nil {
+		fatal("Failed to load custom dimensions", err)
+	}
+	if dimensionRegistry != nil {
+		routeOpts = append(routeOpts, api.WithDimensions(dimensionRegistry))
+	}
+	responseCache, err := cfg.NewCache()
+	if err != nil {
+		fatal("Failed to create response cache", err)
+	}
+	if responseCache != nil {
+		routeOpts = append(routeOpts, api.WithCache(responseCache))
+	}
+	sampler, err := cfg.NewSampler()
+	if err != nil {
+		fatal("Failed to create sampler", err)
+	}
+	if sampler != nil {
+		routeOpts = append(routeOpts, api.WithSampler(sampler))
+	}
+	limiter := cfg.NewRateLimiter(coord)
+	if limiter != nil {
+		routeOpts = append(routeOpts, api.WithRateLimiter(limiter))
+		defer limiter.Close()
+	}
+	quotas, err := cfg.NewQuotaTracker()
+	if err != nil {
+		fatal("Failed to create quota tracker", err)
+	}
+	if quotas != nil {
+		routeOpts = append(routeOpts, api.WithQuotas(quotas))
+		defer closeQuotas(quotas)
+	}
+	if cfg.LoadShed.Enabled {
+		routeOpts = append(routeOpts, api.WithLoadShedding(cfg.LoadShed.Threshold, cfg.LoadShed.RetryAfter))
+	}
+	if cfg.Metrics.Enabled {
+		routeOpts = append(routeOpts, api.WithMetrics())
+	}
+	if cfg.Tracing.Enabled {
+		routeOpts = append(routeOpts, api.WithTracing())
+	}
+	var erasures *erasure.Manager
+	if cfg.Admin.Token != "" {
+		routeOpts = append(routeOpts, api.WithAdmin(cfg.Admin.Token), api.WithSinkToggle(sinkToggle))
+		if eraser, ok := eventSink.(sink.Eraser); ok && cfg.Erasure.Enabled {
+			// Jobs report the archived copies they can't erase
+			var archived erasure.Archive
+			if archiver != nil {
+				archived = archiver
+			}
+			erasures = erasure.NewManager(eraser, archived, cfg.Erasure.Timeout)
+			routeOpts = append(routeOpts, api.WithErasure(erasures))
+		}
+	}
+	certificates := cfg.NewClientCertificates()
+	if certificates != nil {
+		routeOpts = append(routeOpts, api.WithClientCertificates(certificates))
+	}
+	if keyStore != nil {
+		routeOpts = append(routeOpts, api.WithKeyStore(keyStore))
+	} else if certificates == nil {
+		slog.Warn("No API keys configured, authentication is disabled")
+	}
+	signatures, err := cfg.NewSignatureVerifier()
+	if err != nil {
+		fatal("Failed to set up signature verification", err)
+	}
+	if signatures != nil {
+		routeOpts = append(routeOpts, api.WithSignatures(signatures))
+	}
+
+	// Set up API routes with the event sink
+	router := api.SetupRoutes(eventSink, routeOpts...)
+
+	// The bridge ingests through the routes' event handler, so it starts
+	// once they are set up
+	if bridge != nil {
+		if err := bridge.Start(); err != nil {
+			fatal("Failed to start MQTT bridge", err)
+		}
+	}
+
+	tlsConfig, redirect, err := cfg.NewTLS()
+	if err != nil {
+		fatal("Failed to set up TLS", err)
+	}
+
+	// Start server. HTTP/2 is negotiated over TLS, and over cleartext only
+	// when asked for.
+	port := cfg.Server.Port
+	server := cfg.NewServer(fmt.Sprintf(":%d", port), router)
+	server.TLSConfig = tlsConfig
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(cfg.Server.HTTP2Cleartext)
+	if broker != nil {
+		// Live streams never finish on their own, end them so Shutdown can drain
+		server.RegisterOnShutdown(broker.Close)
+	}
+	// Requests still running when draining times out are cancelled, so
+	// their sink writes stop rather than race the sink closing
+	requests, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	server.BaseContext = func(net.Listener) context.Context { return requests }
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGHUP applies changed sampling rules, rate limits, CORS origins,
+	// webhooks, SDK settings and transformation rules without dropping
+	// requests in flight
+	reloads := &reloader{
+		load:       opts.load,
+		running:    cfg,
+		sampler:    sampler,
+		limiter:    limiter,
+		cors:       corsPolicy,
+		webhooks:   webhooks,
+		sdkConfig:  sdkConfig,
+		transforms: transforms,
+	}
+	go reloads.watch(ctx)
+
+	// The dashboard takes over / from the SDK test page
+	pageKey, pagePath := "testPage", "/index.html"
+	if cfg.Dashboard.Enabled {
+		pageKey, pagePath = "dashboard", "/dashboard/"
+	}
+
+	serverErr := make(chan error, 2)
+	go func() {
+		if tlsConfig != nil {
+			slog.Info("Starting server", "addr", server.Addr, "tls", true,
+				pageKey, fmt.Sprintf("https://localhost:%d%s", port, pagePath))
+			serverErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		slog.Info("Starting server", "addr", server.Addr,
+			pageKey, fmt.Sprintf("http://localhost:%d%s", port, pagePath))
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Plain HTTP only redirects to HTTPS and answers ACME challenges
+	var redirectServer *http.Server
+	if redirect != nil {
+		// Redirects are never streamed, the timeouts can apply to the server
+		redirectServer = cfg.NewServer(fmt.Sprintf(":%d", cfg.Server.TLS.HTTPPort), redirect)
+		redirectServer.ReadTimeout = cfg.Server.Timeouts.Read
+		redirectServer.WriteTimeout = cfg.Server.Timeouts.Write
+		go func() {
+			slog.Info("Starting HTTP redirect server", "addr", redirectServer.Addr)
+			serverErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			closeBridge(bridge)
+			closeErasures(erasures)
+			closeTracker(tracker)
+			closeRollups(rollups)
+			closeSink(eventSink)
+			fatal("Server error", err)
+		}
+	case <-ctx.Done():
+		stop()
+		slog.Info("Shutting down, draining requests", "timeout", cfg.Server.ShutdownTimeout.String())
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		if redirectServer != nil {
+			redirectServer.Shutdown(shutdownCtx)
+		}
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error during shutdown", "error", err)
+			cancelRequests()
+		}
+	}
+
+	closeBridge(bridge)
+	closeErasures(erasures)
+	closeTracker(tracker)
+	closeRollups(rollups)
+	closeSink(eventSink)
+	closeTracing(shutdownTracing)
+	slog.Info("Server stopped")
+}
+
+// closeTracing exports the spans still buffered, including those of the
+// final sink flush
+func closeTracing(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+}
+
+// closeBridge stops taking messages from the MQTT broker and waits for
+// those being ingested
+func closeBridge(bridge *mqtt.Bridge) {
+	if bridge != nil {
+		bridge.Close()
+	}
+}
+
+// closeErasures stops erasing before the sink is closed under the job
+func closeErasures(erasures *erasure.Manager) {
+	if erasures != nil {
+		erasures.Close()
+	}
+}
+
+// closeTracker ends open sessions so their summaries reach the sink
+func closeTracker(tracker *session.Tracker) {
+	if tracker == nil {
+		return
+	}
+	if err := tracker.Close(); err != nil {
+		slog.Error("Error closing session tracker", "error", err)
+	}
+}
+
+// closeRollups adds the rollups not stored yet before the sink closes
+func closeRollups(rollups *rollup.Engine) {
+	if rollups != nil {
+		rollups.Close()
+	}
+}
+
+// closeQuotas saves the usage counted so far
+func closeQuotas(quotas *quota.Tracker) {
+	if err := quotas.Close(); err != nil {
+		slog.Error("Error saving quota usage", "error", err)
+	}
+}
+
+// closeSink flushes and closes the sink so no queued events are lost
+func closeSink(eventSink sink.EventSink) {
+	if flusher, ok := eventSink.(sink.Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			slog.Error("Error flushing events", "error", err)
+		}
+	}
+	if err := eventSink.Close(); err != nil {
+		slog.Error("Error closing event sink", "error", err)
+	}
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/adtyap26/event-stream-video/internal/config"
+	"github.com/adtyap26/event-stream-video/internal/cors"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/ratelimit"
+	"github.com/adtyap26/event-stream-video/internal/sampling"
+	"github.com/adtyap26/event-stream-video/internal/sdkconfig"
+	"github.com/adtyap26/event-stream-video/internal/transform"
+	"github.com/adtyap26/event-stream-video/internal/webhook"
+)
+
+// reloader applies the sections of a changed configuration that can change
+// while the server runs: sampling rules, rate limits, CORS origins, webhook
+// targets, SDK settings and transformation rules. Restarting for them would
+// drop the beacons browsers send while their pages unload. Other changes
+// wait for a restart.
+type reloader struct {
+	// load reads the configuration with the command-line overrides applied
+	load func() (config.Config, error)
+	// running is the configuration in effect
+	running config.Config
+
+	// Nil when the feature was off at startup, it can then only be turned
+	// on by a restart
+	sampler    *sampling.Sampler
+	limiter    *ratelimit.Limiter
+	cors       *cors.Policy
+	webhooks   *webhook.Dispatcher
+	sdkConfig  *sdkconfig.Store
+	transforms *transform.Engine
+}
+
+// watch reloads the configuration on every SIGHUP until ctx ends
+func (r *reloader) watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			r.reload()
+		}
+	}
+}
+
+// reload loads the configuration again and applies the sections that
+// changed. Sections left unchanged in the file keep what the admin API set
+// since. An invalid configuration is logged and the running one is kept.
+func (r *reloader) reload() {
+	next, err := r.load()
+	if err != nil {
+		slog.Error("Failed to reload config, keeping the running one", "error", err)
+		metrics.ConfigReloads.WithLabelValues("failed").Inc()
+		return
+	}
+
+	var applied, restart []string
+	if !reflect.DeepEqual(r.running.Sampling, next.Sampling) {
+		if r.applySampling(next.Sampling) {
+			applied = append(applied, "sampling")
+		} else {
+			restart = append(restart, "sampling")
+		}
+	}
+	if !reflect.DeepEqual(r.running.RateLimit, next.RateLimit) {
+		if r.applyRateLimit(next.RateLimit) {
+			applied = append(applied, "rateLimit")
+		} else {
+			restart = append(restart, "rateLimit")
+		}
+	}
+	if !reflect.DeepEqual(r.running.CORS, next.CORS) {
+		// Validated by Load already
+		if err := r.cors.Update(next.CORS); err != nil {
+			slog.Error("Failed to reload CORS policy", "error", err)
+			restart = append(restart, "cors")
+		} else {
+			r.running.CORS = next.CORS
+			applied = append(applied, "cors")
+		}
+	}
+	if !reflect.DeepEqual(r.running.Webhooks, next.Webhooks) {
+		if r.applyWebhooks(next.Webhooks) {
+			applied = append(applied, "webhooks")
+		} else {
+			restart = append(restart, "webhooks")
+		}
+	}
+	if !reflect.DeepEqual(r.running.SDK, next.SDK) {
+		if r.applySDKConfig(next.SDK) {
+			applied = append(applied, "sdk")
+		} else {
+			restart = append(restart, "sdk")
+		}
+	}
+	if !reflect.DeepEqual(r.running.Transforms, next.Transforms) {
+		if r.applyTransforms(next.Transforms) {
+			applied = append(applied, "transforms")
+		} else {
+			restart = append(restart, "transforms")
+		}
+	}
+
+	// Whatever else differs from the running configuration needs a restart
+	rest := next
+	rest.Sampling, rest.RateLimit = r.running.Sampling, r.running.RateLimit
+	rest.CORS, rest.Webhooks = r.running.CORS, r.running.Webhooks
+	rest.SDK, rest.Transforms = r.running.SDK, r.running.Transforms
+	if !reflect.DeepEqual(rest, r.running) {
+		restart = append(restart, "other settings")
+	}
+
+	metrics.ConfigReloads.WithLabelValues("applied").Inc()
+	slog.Info("Reloaded config", "applied", applied)
+	if len(restart) > 0 {
+		slog.Warn("Some config changes take effect after a restart", "sections", restart)
+	}
+}
+
+// applySampling replaces the sampling rules, reporting false when the
+// sampler wasn't started
+func (r *reloader) applySampling(next config.SamplingConfig) bool {
+	if r.sampler == nil {
+		if next.Enabled {
+			return false
+		}
+		r.running.Sampling = next
+		return true
+	}
+	var rules []sampling.Rule
+	if next.Enabled {
+		rules = next.Rules
+	}
+	if err := r.sampler.SetRules(rules); err != nil {
+		// Validated by Load already
+		slog.Error("Failed to reload sampling rules", "error", err)
+		return false
+	}
+	r.running.Sampling = next
+	return true
+}
+
+// applyRateLimit changes the per-client and tenant rates, reporting false
+// when rate limiting has to be started or stopped
+func (r *reloader) applyRateLimit(next config.RateLimitConfig) bool {
+	if r.limiter == nil || !next.Enabled {
+		if r.limiter != nil || next.Enabled {
+			return false
+		}
+		r.running.RateLimit = next
+		return true
+	}
+	r.limiter.SetRate(next.RequestsPerSecond, next.Burst)
+	for tenant := range r.limiter.TenantRates() {
+		if _, ok := next.Tenants[tenant]; !ok {
+			r.limiter.RemoveTenantLimit(tenant)
+		}
+	}
+	for tenant, limit := range next.Tenants {
+		r.limiter.SetTenantLimit(tenant, limit.RequestsPerSecond, limit.Burst)
+	}
+	r.running.RateLimit = next
+	return true
+}
+
+// applyWebhooks replaces the webhooks, reporting false when none were
+// configured at startup
+func (r *reloader) applyWebhooks(next []webhook.Config) bool {
+	if r.webhooks == nil {
+		if len(next) > 0 {
+			return false
+		}
+		r.running.Webhooks = next
+		return true
+	}
+	if err := r.webhooks.Reload(next); err != nil {
+		// Validated by Load already
+		slog.Error("Failed to reload webhooks", "error", err)
+		return false
+	}
+	r.running.Webhooks = next
+	return true
+}
+
+// applySDKConfig replaces the SDK settings, reporting false when their
+// delivery has to be started or stopped
+func (r *reloader) applySDKConfig(next sdkconfig.Config) bool {
+	if r.sdkConfig == nil || !next.Enabled {
+		if r.sdkConfig != nil || next.Enabled {
+			return false
+		}
+		r.running.SDK = next
+		return true
+	}
+	r.sdkConfig.Update(next)
+	r.running.SDK = next
+	return true
+}
+
+// applyTransforms replaces the transformation rules, reporting false when
+// there were none at startup
+func (r *reloader) applyTransforms(next transform.Config) bool {
+	if r.transforms == nil {
+		if next.HasRules() {
+			return false
+		}
+		r.running.Transforms = next
+		return true
+	}
+	if err := r.transforms.SetConfig(next); err != nil {
+		// Validated by Load already
+		slog.Error("Failed to reload transformation rules", "error", err)
+		return false
+	}
+	r.running.Transforms = next
+	return true
+}
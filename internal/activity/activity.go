@@ -0,0 +1,349 @@
+// Package activity keeps a short in-memory history of what the collector
+// ingested, for the dashboard: the ingestion rate, the most watched videos
+// and the latest errors
+package activity
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+const (
+	// DefaultRetention is how far back activity is kept
+	DefaultRetention = 15 * time.Minute
+	// DefaultMaxErrors is how many recent errors are kept per tenant
+	DefaultMaxErrors = 100
+	// maxVideos caps how many videos are counted per tenant; the least
+	// recently seen are forgotten first
+	maxVideos = 10000
+	// videoBucket is the resolution of video counts
+	videoBucket = time.Minute
+)
+
+// Error sources
+const (
+	// SourceIngest marks batches the collector rejected or failed to store
+	SourceIngest = "ingest"
+	// SourcePlayer marks error events reported by players
+	SourcePlayer = "player"
+)
+
+// Error is a recent ingestion failure or player error
+type Error struct {
+	At        time.Time `json:"at"`
+	Source    string    `json:"source"`
+	Message   string    `json:"message"`
+	ClientID  string    `json:"clientId,omitempty"`
+	BatchID   string    `json:"batchId,omitempty"`
+	SessionID string    `json:"sessionId,omitempty"`
+	VideoID   string    `json:"videoId,omitempty"`
+}
+
+// Point is the ingestion of one second
+type Point struct {
+	At      time.Time `json:"at"`
+	Batches int       `json:"batches"`
+	Events  int       `json:"events"`
+	Errors  int       `json:"errors"`
+}
+
+// Rate is the ingestion of a tenant over a window, one point per second,
+// oldest first
+type Rate struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	Batches int `json:"batches"`
+	Events  int `json:"events"`
+	Errors  int `json:"errors"`
+	// EventsPerSecond is the mean rate over the window
+	EventsPerSecond float64 `json:"eventsPerSecond"`
+
+	Points []Point `json:"points"`
+}
+
+// Video is how many events of a video were stored over a window
+type Video struct {
+	VideoID string `json:"videoId"`
+	Events  int    `json:"events"`
+	Plays   int    `json:"plays"`
+}
+
+// second holds the counts of the second starting at unix
+type second struct {
+	unix    int64
+	batches int
+	events  int
+	errors  int
+}
+
+// videoCounts holds a video's counts by the start of their minute
+type videoCounts struct {
+	buckets  map[int64]*Video
+	lastSeen time.Time
+}
+
+// tenantActivity is the history of one tenant
+type tenantActivity struct {
+	// seconds is a ring indexed by unix second modulo its length
+	seconds []second
+	videos  map[string]*videoCounts
+	// errors is a ring of the latest errors, next is where the next one goes
+	errors []Error
+	next   int
+}
+
+// Recorder collects the activity of every tenant. It is safe for
+// concurrent use.
+type Recorder struct {
+	mu        sync.Mutex
+	tenants   map[string]*tenantActivity
+	retention time.Duration
+	maxErrors int
+	now       func() time.Time
+}
+
+// NewRecorder keeps retention of history and the latest maxErrors errors
+// of each tenant. Zero values select the defaults.
+func NewRecorder(retention time.Duration, maxErrors int) *Recorder {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if maxErrors <= 0 {
+		maxErrors = DefaultMaxErrors
+	}
+	return &Recorder{
+		tenants:   make(map[string]*tenantActivity),
+		retention: retention.Truncate(time.Second),
+		maxErrors: maxErrors,
+		now:       time.Now,
+	}
+}
+
+// Retention is the longest window Rate and TopVideos answer
+func (r *Recorder) Retention() time.Duration {
+	return r.retention
+}
+
+// tenant returns the history of tenant, creating it. r.mu must be held.
+func (r *Recorder) tenant(tenant string) *tenantActivity {
+	t, ok := r.tenants[tenant]
+	if !ok {
+		t = &tenantActivity{
+			seconds: make([]second, int(r.retention/time.Second)+1),
+			videos:  make(map[string]*videoCounts),
+		}
+		r.tenants[tenant] = t
+	}
+	return t
+}
+
+// second returns the counts of the second at now, clearing a slot left
+// over from an earlier lap of the ring
+func (t *tenantActivity) second(now time.Time) *second {
+	unix := now.Unix()
+	s := &t.seconds[unix%int64(len(t.seconds))]
+	if s.unix != unix {
+		*s = second{unix: unix}
+	}
+	return s
+}
+
+// Record counts a stored batch and keeps the error events in it
+func (r *Recorder) Record(batch models.EventBatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	t := r.tenant(batch.Tenant)
+	s := t.second(now)
+	s.batches++
+	s.events += len(batch.Events)
+
+	minute := now.Truncate(videoBucket).Unix()
+	for _, event := range batch.Events {
+		if event.EventName == "error" {
+			r.addError(t, Error{
+				At:        now,
+				Source:    SourcePlayer,
+				Message:   session.ErrorMessage(event),
+				ClientID:  batch.ClientID,
+				BatchID:   batch.BatchID,
+				SessionID: event.SessionID,
+				VideoID:   event.VideoID,
+			})
+		}
+		if event.VideoID == "" {
+			continue
+		}
+		t.countVideo(event, minute, now)
+	}
+}
+
+// countVideo adds event to its video's bucket at minute
+func (t *tenantActivity) countVideo(event models.Event, minute int64, now time.Time) {
+	video, ok := t.videos[event.VideoID]
+	if !ok {
+		if len(t.videos) >= maxVideos {
+			t.forgetOldestVideo()
+		}
+		video = &videoCounts{buckets: make(map[int64]*Video)}
+		t.videos[event.VideoID] = video
+	}
+	video.lastSeen = now
+
+	counts, ok := video.buckets[minute]
+	if !ok {
+		counts = &Video{VideoID: event.VideoID}
+		video.buckets[minute] = counts
+	}
+	counts.Events++
+	if event.EventName == "play" {
+		counts.Plays++
+	}
+}
+
+func (t *tenantActivity) forgetOldestVideo() {
+	var oldest string
+	var oldestAt time.Time
+	for id, video := range t.videos {
+		if oldestAt.IsZero() || video.lastSeen.Before(oldestAt) {
+			oldest, oldestAt = id, video.lastSeen
+		}
+	}
+	delete(t.videos, oldest)
+}
+
+// RecordError keeps a failure to ingest batch, which may be empty when the
+// request body couldn't be decoded
+func (r *Recorder) RecordError(batch models.EventBatch, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	t := r.tenant(batch.Tenant)
+	t.second(now).errors++
+	r.addError(t, Error{
+		At:        now,
+		Source:    SourceIngest,
+		Message:   err.Error(),
+		ClientID:  batch.ClientID,
+		BatchID:   batch.BatchID,
+		SessionID: batch.SessionID,
+	})
+}
+
+// addError adds e to the ring of t. r.mu must be held.
+func (r *Recorder) addError(t *tenantActivity, e Error) {
+	if len(t.errors) < r.maxErrors {
+		t.errors = append(t.errors, e)
+		return
+	}
+	t.errors[t.next] = e
+	t.next = (t.next + 1) % len(t.errors)
+}
+
+// Rate returns the ingestion of tenant over the window ending now, capped
+// at the retention period
+func (r *Recorder) Rate(tenant string, window time.Duration) Rate {
+	window = min(window, r.retention).Truncate(time.Second)
+	if window < time.Second {
+		window = time.Second
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now().Truncate(time.Second)
+	from := now.Add(-window + time.Second)
+	rate := Rate{From: from, To: now.Add(time.Second), Points: make([]Point, 0, int(window/time.Second))}
+
+	t := r.tenants[tenant]
+	for at := from; !at.After(now); at = at.Add(time.Second) {
+		point := Point{At: at}
+		if t != nil {
+			if s := t.seconds[at.Unix()%int64(len(t.seconds))]; s.unix == at.Unix() {
+				point.Batches, point.Events, point.Errors = s.batches, s.events, s.errors
+			}
+		}
+		rate.Batches += point.Batches
+		rate.Events += point.Events
+		rate.Errors += point.Errors
+		rate.Points = append(rate.Points, point)
+	}
+	rate.EventsPerSecond = float64(rate.Events) / window.Seconds()
+	return rate
+}
+
+// TopVideos returns up to limit videos of tenant with the most events over
+// the window ending now, rounded out to whole minutes and capped at the
+// retention period
+func (r *Recorder) TopVideos(tenant string, window time.Duration, limit int) []Video {
+	window = min(window, r.retention)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	first := now.Add(-window).Truncate(videoBucket).Unix()
+	expired := now.Add(-r.retention - videoBucket).Unix()
+
+	videos := []Video{}
+	if t, ok := r.tenants[tenant]; ok {
+		for id, video := range t.videos {
+			total := Video{VideoID: id}
+			for start, counts := range video.buckets {
+				if start < expired {
+					delete(video.buckets, start)
+					continue
+				}
+				if start >= first {
+					total.Events += counts.Events
+					total.Plays += counts.Plays
+				}
+			}
+			if len(video.buckets) == 0 {
+				delete(t.videos, id)
+			}
+			if total.Events > 0 {
+				videos = append(videos, total)
+			}
+		}
+	}
+
+	sort.Slice(videos, func(i, j int) bool {
+		if videos[i].Events != videos[j].Events {
+			return videos[i].Events > videos[j].Events
+		}
+		return videos[i].VideoID < videos[j].VideoID
+	})
+	if limit > 0 && len(videos) > limit {
+		videos = videos[:limit]
+	}
+	return videos
+}
+
+// Errors returns up to limit of the latest errors of tenant, newest first
+func (r *Recorder) Errors(tenant string, limit int) []Error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	errors := []Error{}
+	t, ok := r.tenants[tenant]
+	if !ok {
+		return errors
+	}
+	for i := range t.errors {
+		// Walk back from the newest entry, just before next
+		j := (t.next - 1 - i + 2*len(t.errors)) % len(t.errors)
+		errors = append(errors, t.errors[j])
+		if limit > 0 && len(errors) == limit {
+			break
+		}
+	}
+	return errors
+}
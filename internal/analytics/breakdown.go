@@ -0,0 +1,134 @@
+package analytics
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// MaxBreakdownGroups caps the values a breakdown tells apart; the events
+// of further values are counted together
+const MaxBreakdownGroups = 1000
+
+// BreakdownGroup is what the events of one value of a dimension add up to
+type BreakdownGroup struct {
+	// Value is nil for the events without the dimension
+	Value    any `json:"value"`
+	Events   int `json:"events"`
+	Sessions int `json:"sessions"`
+	// UniqueViewers, like Sessions, is estimated beyond a few hundred
+	UniqueViewers int `json:"uniqueViewers"`
+}
+
+type breakdownCounts struct {
+	value    any
+	events   int
+	sessions *sketch
+	viewers  *sketch
+}
+
+func newBreakdownCounts(value any) *breakdownCounts {
+	return &breakdownCounts{value: value, sessions: newSketch(), viewers: newSketch()}
+}
+
+func (c *breakdownCounts) add(record models.EventRecord) {
+	c.events++
+	session := record.SessionID
+	if session == "" {
+		session = record.BatchSessionID
+	}
+	if session != "" {
+		c.sessions.add(session)
+	}
+	switch {
+	case record.UserID != "":
+		c.viewers.add("u:" + record.UserID)
+	case record.AnonymousID != "":
+		c.viewers.add("a:" + record.AnonymousID)
+	case session != "":
+		c.viewers.add("s:" + session)
+	}
+}
+
+func (c *breakdownCounts) group() BreakdownGroup {
+	return BreakdownGroup{Value: c.value, Events: c.events, Sessions: c.sessions.count(), UniqueViewers: c.viewers.count()}
+}
+
+// Breakdown counts events, sessions and viewers by the value of a
+// dimension
+type Breakdown struct {
+	groups  map[string]*breakdownCounts
+	missing *breakdownCounts
+	other   *breakdownCounts
+}
+
+func NewBreakdown() *Breakdown {
+	return &Breakdown{
+		groups:  make(map[string]*breakdownCounts),
+		missing: newBreakdownCounts(nil),
+		other:   newBreakdownCounts(nil),
+	}
+}
+
+// Observe counts a stored event whose dimension is value, or which doesn't
+// have it when ok is false. Bots' events are ignored.
+func (b *Breakdown) Observe(record models.EventRecord, value any, ok bool) {
+	if record.IsBot {
+		return
+	}
+	if !ok {
+		b.missing.add(record)
+		return
+	}
+	// The JSON of the value tells the number 1 from the string "1"
+	key, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	counts, found := b.groups[string(key)]
+	if !found {
+		if len(b.groups) >= MaxBreakdownGroups {
+			b.other.add(record)
+			return
+		}
+		counts = newBreakdownCounts(value)
+		b.groups[string(key)] = counts
+	}
+	counts.add(record)
+}
+
+// Groups returns the limit values with the most events, most first, and
+// the counts of the events without the dimension and of the other values
+func (b *Breakdown) Groups(limit int) (top []BreakdownGroup, missing, other BreakdownGroup) {
+	all := make([]*breakdownCounts, 0, len(b.groups))
+	for _, counts := range b.groups {
+		all = append(all, counts)
+	}
+	slices.SortFunc(all, func(a, b *breakdownCounts) int {
+		if c := cmp.Compare(b.events, a.events); c != 0 {
+			return c
+		}
+		ka, _ := json.Marshal(a.value)
+		kb, _ := json.Marshal(b.value)
+		return cmp.Compare(string(ka), string(kb))
+	})
+
+	top = make([]BreakdownGroup, 0, min(limit, len(all)))
+	for i, counts := range all {
+		if i < limit {
+			top = append(top, counts.group())
+		} else {
+			b.other.merge(counts)
+		}
+	}
+	return top, b.missing.group(), b.other.group()
+}
+
+// merge counts the events of other in c
+func (c *breakdownCounts) merge(other *breakdownCounts) {
+	c.events += other.events
+	c.sessions.merge(other.sessions)
+	c.viewers.merge(other.viewers)
+}
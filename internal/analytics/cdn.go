@@ -0,0 +1,234 @@
+package analytics
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// maxCDNs is how many CDN edge POPs the aggregator keeps buckets for
+const maxCDNs = 1000
+
+// CDNQoE is the quality of experience of the sessions a CDN, or one of its
+// edge POPs, delivered over a time window
+type CDNQoE struct {
+	CDN     string    `json:"cdn"`
+	EdgePOP string    `json:"edgePop,omitempty"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+
+	// Attempts counts sessions that tried to play
+	Attempts              int `json:"attempts"`
+	Plays                 int `json:"plays"`
+	ExitsBeforeVideoStart int `json:"exitsBeforeVideoStart"`
+	VideoStartFailures    int `json:"videoStartFailures"`
+	// ErrorSessions counts sessions that hit a player error
+	ErrorSessions int `json:"errorSessions"`
+
+	ExitBeforeVideoStartRate       float64 `json:"exitBeforeVideoStartRate"`
+	VideoStartFailureRate          float64 `json:"videoStartFailureRate"`
+	ErrorRate                      float64 `json:"errorRate"`
+	AverageTimeToFirstFrameSeconds float64 `json:"averageTimeToFirstFrameSeconds"`
+	RebufferRatio                  float64 `json:"rebufferRatio"`
+	WatchTimeSeconds               float64 `json:"watchTimeSeconds"`
+	RebufferTimeSeconds            float64 `json:"rebufferTimeSeconds"`
+	AverageBitrate                 float64 `json:"averageBitrate,omitempty"`
+}
+
+// cdnCounts are the totals of one bucket of a CDN edge POP
+type cdnCounts struct {
+	attempts      int
+	plays         int
+	exits         int
+	startFailures int
+	errorSessions int
+	ttffSum       float64
+	watchTime     float64
+	rebufferTime  float64
+	bitrateSum    float64
+	bitrateTime   float64
+}
+
+// add counts a session. Sessions that haven't ended count once they
+// started playing or failed; until then they may still exit.
+func (c *cdnCounts) add(state session.State, ended bool) {
+	if !ended && !state.PlaybackStarted && state.ErrorCount == 0 {
+		return
+	}
+	c.attempts++
+	switch {
+	case state.PlaybackStarted:
+		c.plays++
+		c.ttffSum += state.StartupTimeSeconds
+	case state.ErrorCount > 0:
+		c.startFailures++
+	default:
+		c.exits++
+	}
+	if state.ErrorCount > 0 {
+		c.errorSessions++
+	}
+	c.watchTime += state.WatchTimeSeconds
+	c.rebufferTime += state.RebufferTimeSeconds
+	if state.AverageBitrate > 0 && state.WatchTimeSeconds > 0 {
+		c.bitrateSum += state.AverageBitrate * state.WatchTimeSeconds
+		c.bitrateTime += state.WatchTimeSeconds
+	}
+}
+
+func (c *cdnCounts) merge(other *cdnCounts) {
+	c.attempts += other.attempts
+	c.plays += other.plays
+	c.exits += other.exits
+	c.startFailures += other.startFailures
+	c.errorSessions += other.errorSessions
+	c.ttffSum += other.ttffSum
+	c.watchTime += other.watchTime
+	c.rebufferTime += other.rebufferTime
+	c.bitrateSum += other.bitrateSum
+	c.bitrateTime += other.bitrateTime
+}
+
+func (c *cdnCounts) qoe(key cdnKey, from, to time.Time) CDNQoE {
+	qoe := CDNQoE{
+		CDN:                      key.cdn,
+		EdgePOP:                  key.pop,
+		From:                     from,
+		To:                       to,
+		Attempts:                 c.attempts,
+		Plays:                    c.plays,
+		ExitsBeforeVideoStart:    c.exits,
+		VideoStartFailures:       c.startFailures,
+		ErrorSessions:            c.errorSessions,
+		ExitBeforeVideoStartRate: ratio(c.exits, c.attempts),
+		VideoStartFailureRate:    ratio(c.startFailures, c.attempts),
+		ErrorRate:                ratio(c.errorSessions, c.attempts),
+		RebufferRatio:            rebufferRatio(c.rebufferTime, c.watchTime),
+		WatchTimeSeconds:         c.watchTime,
+		RebufferTimeSeconds:      c.rebufferTime,
+	}
+	if c.plays > 0 {
+		qoe.AverageTimeToFirstFrameSeconds = c.ttffSum / float64(c.plays)
+	}
+	if c.bitrateTime > 0 {
+		qoe.AverageBitrate = c.bitrateSum / c.bitrateTime
+	}
+	return qoe
+}
+
+type cdnKey struct {
+	tenant string
+	cdn    string
+	pop    string
+}
+
+// cdnBuckets holds an edge POP's counts by the start of their bucket
+type cdnBuckets struct {
+	buckets   map[int64]*cdnCounts
+	updatedAt time.Time
+}
+
+// recordCDN adds an ended session to the bucket of its last event at the
+// session's CDN edge POP. a.mu must be held.
+func (a *Aggregator) recordCDN(state session.State) {
+	if state.CDN == "" {
+		return
+	}
+	now := a.now()
+	key := cdnKey{tenant: state.Tenant, cdn: state.CDN, pop: state.EdgePOP}
+	pop, ok := a.cdns[key]
+	if !ok {
+		if len(a.cdns) >= maxCDNs {
+			a.evictOldestCDN()
+		}
+		pop = &cdnBuckets{buckets: make(map[int64]*cdnCounts)}
+		a.cdns[key] = pop
+	}
+	pop.updatedAt = now
+
+	start := bucketStart(state.LastEventAt)
+	counts, ok := pop.buckets[start]
+	if !ok {
+		counts = &cdnCounts{}
+		pop.buckets[start] = counts
+	}
+	counts.add(state, true)
+
+	// Drop buckets that fell out of the retention period
+	cutoff := now.Add(-DefaultStatsRetention - statsBucket).Unix()
+	for start := range pop.buckets {
+		if start < cutoff {
+			delete(pop.buckets, start)
+		}
+	}
+}
+
+// evictOldestCDN drops the least recently updated edge POP
+func (a *Aggregator) evictOldestCDN() {
+	var oldest cdnKey
+	var oldestAt time.Time
+	for key, pop := range a.cdns {
+		if oldestAt.IsZero() || pop.updatedAt.Before(oldestAt) {
+			oldest, oldestAt = key, pop.updatedAt
+		}
+	}
+	delete(a.cdns, oldest)
+}
+
+// CDNs compares the CDNs of a tenant over the window ending now, capped at
+// DefaultStatsRetention and rounded out to whole five-minute buckets.
+// byPOP reports each edge POP of a CDN on its own. live adds sessions that
+// haven't ended yet, such as those from Tracker.Active, so an incident
+// shows up while viewers are still watching.
+func (a *Aggregator) CDNs(tenant string, window time.Duration, byPOP bool, live []session.State) []CDNQoE {
+	window = min(window, DefaultStatsRetention)
+	to := a.now()
+	from := to.Add(-window)
+	first := bucketStart(from)
+
+	totals := make(map[cdnKey]*cdnCounts)
+	total := func(cdn, pop string) *cdnCounts {
+		key := cdnKey{cdn: cdn}
+		if byPOP {
+			key.pop = pop
+		}
+		counts, ok := totals[key]
+		if !ok {
+			counts = &cdnCounts{}
+			totals[key] = counts
+		}
+		return counts
+	}
+
+	a.mu.Lock()
+	for key, pop := range a.cdns {
+		if key.tenant != tenant {
+			continue
+		}
+		for start, counts := range pop.buckets {
+			if start >= first {
+				total(key.cdn, key.pop).merge(counts)
+			}
+		}
+	}
+	a.mu.Unlock()
+
+	for _, state := range live {
+		if state.Tenant == tenant && state.CDN != "" && !state.LastEventAt.Before(from) {
+			total(state.CDN, state.EdgePOP).add(state, false)
+		}
+	}
+
+	cdns := make([]CDNQoE, 0, len(totals))
+	for key, counts := range totals {
+		if counts.attempts > 0 {
+			cdns = append(cdns, counts.qoe(key, from, to))
+		}
+	}
+	slices.SortFunc(cdns, func(a, b CDNQoE) int {
+		return cmp.Or(cmp.Compare(a.CDN, b.CDN), cmp.Compare(a.EdgePOP, b.EdgePOP))
+	})
+	return cdns
+}
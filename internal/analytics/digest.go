@@ -0,0 +1,165 @@
+package analytics
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+const (
+	// digestCompression bounds a digest to several hundred centroids, which
+	// keeps quantiles within about 1% of rank and far closer at the tails
+	digestCompression = 100
+	// digestBuffer is how many values a digest takes before merging them
+	// into its centroids
+	digestBuffer = 5 * digestCompression
+)
+
+// centroid is the mean of weight values that a digest merged
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// digest estimates the quantiles of the values added to it with a merging
+// t-digest. Centroids stay small towards the tails, so high and low
+// percentiles are accurate, and digests of different buckets can be merged
+// without keeping the values. It isn't safe for concurrent use.
+type digest struct {
+	// centroids are merged and sorted by mean
+	centroids []centroid
+	// unmerged are added since the last compress
+	unmerged []centroid
+	total    float64
+	min, max float64
+}
+
+func newDigest() *digest {
+	return &digest{min: math.Inf(1), max: math.Inf(-1)}
+}
+
+// add records value
+func (d *digest) add(value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	d.addCentroid(centroid{mean: value, weight: 1})
+	d.min = min(d.min, value)
+	d.max = max(d.max, value)
+}
+
+func (d *digest) addCentroid(c centroid) {
+	d.unmerged = append(d.unmerged, c)
+	d.total += c.weight
+	if len(d.unmerged) >= digestBuffer {
+		d.compress()
+	}
+}
+
+// merge adds every value of other to d
+func (d *digest) merge(other *digest) {
+	if other.total == 0 {
+		return
+	}
+	for _, c := range other.centroids {
+		d.addCentroid(c)
+	}
+	for _, c := range other.unmerged {
+		d.addCentroid(c)
+	}
+	d.min = min(d.min, other.min)
+	d.max = max(d.max, other.max)
+}
+
+// compress merges the unmerged centroids into the others, combining
+// neighbours as long as each centroid holds no more of the values than the
+// t-digest size bound allows at its quantile
+func (d *digest) compress() {
+	if len(d.unmerged) == 0 {
+		return
+	}
+	all := append(d.centroids, d.unmerged...)
+	slices.SortFunc(all, func(a, b centroid) int { return cmp.Compare(a.mean, b.mean) })
+
+	merged := make([]centroid, 0, min(len(all), 2*digestCompression))
+	current := all[0]
+	sofar := 0.0
+	for _, next := range all[1:] {
+		proposed := current.weight + next.weight
+		q0 := sofar / d.total
+		q2 := (sofar + proposed) / d.total
+		limit := d.total * 4 * min(q0*(1-q0), q2*(1-q2)) / digestCompression
+		if proposed <= limit {
+			current.mean += (next.mean - current.mean) * next.weight / proposed
+			current.weight = proposed
+			continue
+		}
+		merged = append(merged, current)
+		sofar += current.weight
+		current = next
+	}
+	d.centroids = append(merged, current)
+	d.unmerged = d.unmerged[:0]
+}
+
+// quantile estimates the value q (0 to 1) of the way through the values
+// added, interpolating between the centroids around it. It is 0 for an
+// empty digest.
+func (d *digest) quantile(q float64) float64 {
+	d.compress()
+	switch {
+	case len(d.centroids) == 0:
+		return 0
+	case q <= 0:
+		return d.min
+	case q >= 1:
+		return d.max
+	case len(d.centroids) == 1:
+		return d.centroids[0].mean
+	}
+
+	// Each centroid stands for the values around its mean, so its mean
+	// sits at the middle of its weight
+	target := q * d.total
+	first, last := d.centroids[0], d.centroids[len(d.centroids)-1]
+	if target < first.weight/2 {
+		return d.min + (first.mean-d.min)*target/(first.weight/2)
+	}
+	if target > d.total-last.weight/2 {
+		return last.mean + (d.max-last.mean)*(target-(d.total-last.weight/2))/(last.weight/2)
+	}
+	cumulative := first.weight / 2
+	for i := 1; i < len(d.centroids); i++ {
+		prev, c := d.centroids[i-1], d.centroids[i]
+		step := (prev.weight + c.weight) / 2
+		if target <= cumulative+step {
+			return prev.mean + (c.mean-prev.mean)*(target-cumulative)/step
+		}
+		cumulative += step
+	}
+	return last.mean
+}
+
+// Percentiles summarize a distribution without letting a few extreme
+// values skew it the way an average does
+type Percentiles struct {
+	P10 float64 `json:"p10"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// percentiles returns the percentiles of d, or nil when it is empty
+func (d *digest) percentiles() *Percentiles {
+	if d.total == 0 {
+		return nil
+	}
+	return &Percentiles{
+		P10: d.quantile(0.10),
+		P50: d.quantile(0.50),
+		P90: d.quantile(0.90),
+		P95: d.quantile(0.95),
+		P99: d.quantile(0.99),
+	}
+}
@@ -0,0 +1,143 @@
+package analytics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// MaxFunnelSteps caps the number of steps of a funnel
+const MaxFunnelSteps = 20
+
+// DefaultFunnelSteps follow a viewer of the JavaScript SDK from the player
+// loading to the end of the video
+var DefaultFunnelSteps = []string{"playerInit", "play", "playing", "25%", "50%", "75%", "ended"}
+
+// FunnelStep is how many sessions reached a step of a funnel
+type FunnelStep struct {
+	Step     string `json:"step"`
+	Sessions int    `json:"sessions"`
+	// DropOff counts the sessions that reached the previous step but not
+	// this one
+	DropOff int `json:"dropOff"`
+	// ConversionRate is the share of the sessions of the first step that
+	// reached this one, StepConversionRate the share of the previous step's
+	ConversionRate     float64 `json:"conversionRate"`
+	StepConversionRate float64 `json:"stepConversionRate"`
+}
+
+// funnelStep matches the events of a step: an event name, or a share of
+// the video watched, given as a percentage
+type funnelStep struct {
+	name      string
+	eventName string
+	progress  float64
+}
+
+func (s funnelStep) matches(event models.Event) bool {
+	if s.eventName != "" {
+		return event.EventName == s.eventName
+	}
+	p := event.PlaybackState
+	return p != nil && p.Duration > 0 && p.CurrentTime/p.Duration >= s.progress
+}
+
+// Funnel counts how far sessions get through an ordered list of steps.
+// Events must be observed in time order; a session reaches a step with the
+// first matching event after it reached the previous one.
+type Funnel struct {
+	steps []funnelStep
+	// reached is the number of steps each session has reached
+	reached map[string]int
+}
+
+// NewFunnel creates a funnel of steps. A step is an event name, or a
+// percentage such as 25% that a session reaches once the playhead of one
+// of its events is that far into the video.
+func NewFunnel(steps []string) (*Funnel, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("a funnel needs at least one step")
+	}
+	if len(steps) > MaxFunnelSteps {
+		return nil, fmt.Errorf("a funnel can have at most %d steps", MaxFunnelSteps)
+	}
+
+	f := &Funnel{reached: make(map[string]int)}
+	for _, step := range steps {
+		step = strings.TrimSpace(step)
+		if step == "" {
+			return nil, fmt.Errorf("funnel steps must not be empty")
+		}
+		s := funnelStep{name: step}
+		if percent, ok := strings.CutSuffix(step, "%"); ok {
+			p, err := strconv.ParseFloat(percent, 64)
+			if err != nil || p <= 0 || p > 100 {
+				return nil, fmt.Errorf("invalid funnel step %q, percentages must be between 0 and 100", step)
+			}
+			s.progress = p / 100
+		} else {
+			s.eventName = step
+		}
+		f.steps = append(f.steps, s)
+	}
+	return f, nil
+}
+
+// Observe advances the session of a stored event. Bots' events are
+// ignored.
+func (f *Funnel) Observe(record models.EventRecord) {
+	if record.IsBot {
+		return
+	}
+	session := record.SessionID
+	if session == "" {
+		session = record.BatchSessionID
+	}
+	if session == "" {
+		return
+	}
+
+	// An event reaches at most one named step, but may pass any number of
+	// percentages, such as 25% and 50% after a seek
+	reached := f.reached[session]
+	named := false
+	for reached < len(f.steps) {
+		step := f.steps[reached]
+		if (step.eventName != "" && named) || !step.matches(record.Event) {
+			break
+		}
+		named = named || step.eventName != ""
+		reached++
+	}
+	if reached > 0 {
+		f.reached[session] = reached
+	}
+}
+
+// Steps returns the sessions that reached each step so far
+func (f *Funnel) Steps() []FunnelStep {
+	counts := make([]int, len(f.steps))
+	for _, reached := range f.reached {
+		for i := range reached {
+			counts[i]++
+		}
+	}
+
+	steps := make([]FunnelStep, len(f.steps))
+	for i, step := range f.steps {
+		steps[i] = FunnelStep{
+			Step:           step.name,
+			Sessions:       counts[i],
+			ConversionRate: ratio(counts[i], counts[0]),
+		}
+		if i == 0 {
+			steps[i].StepConversionRate = ratio(counts[i], counts[0])
+		} else {
+			steps[i].DropOff = counts[i-1] - counts[i]
+			steps[i].StepConversionRate = ratio(counts[i], counts[i-1])
+		}
+	}
+	return steps
+}
@@ -0,0 +1,313 @@
+// Package analytics derives video quality-of-experience metrics from
+// session state
+package analytics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// DefaultMaxVideos is how many videos the aggregator keeps totals for
+const DefaultMaxVideos = 10000
+
+// SessionQoE is the quality of experience of one session
+type SessionQoE struct {
+	SessionID  string `json:"sessionId"`
+	VideoID    string `json:"videoId"`
+	StreamType string `json:"streamType,omitempty"`
+
+	// TimeToFirstFrameSeconds is the time from load or play to the first
+	// frame; zero until playback starts
+	TimeToFirstFrameSeconds float64 `json:"timeToFirstFrameSeconds"`
+	// RebufferRatio is the share of playback time spent rebuffering
+	RebufferRatio     float64 `json:"rebufferRatio"`
+	RebufferCount     int     `json:"rebufferCount"`
+	WatchTimeSeconds  float64 `json:"watchTimeSeconds"`
+	PlaybackStarted   bool    `json:"playbackStarted"`
+	AverageBitrate    float64 `json:"averageBitrate,omitempty"`
+	ExitedBeforeStart bool    `json:"exitedBeforeVideoStart"`
+	ErrorCount        int     `json:"errorCount"`
+	SessionEnded      bool    `json:"sessionEnded"`
+
+	// Renditions is the watch time at each rendition, and Upshifts and
+	// Downshifts the bitrate switches, for tuning adaptive bitrate ladders
+	Renditions []session.RenditionTime `json:"renditions,omitempty"`
+	Upshifts   int                     `json:"upshifts,omitempty"`
+	Downshifts int                     `json:"downshifts,omitempty"`
+
+	// Ads are the ad events of the session; time to first frame excludes
+	// prerolls
+	Ads session.AdStats `json:"ads,omitzero"`
+	// AdCompletionRate is the share of started ads that completed
+	AdCompletionRate float64 `json:"adCompletionRate,omitempty"`
+
+	// LiveJoinTimeSeconds is the time to first frame of a live stream
+	LiveJoinTimeSeconds float64 `json:"liveJoinTimeSeconds,omitempty"`
+	// LiveLatencySeconds is the mean distance from the live edge
+	LiveLatencySeconds float64 `json:"liveLatencySeconds,omitempty"`
+}
+
+// ForSession computes the QoE of a session. ended tells whether the session
+// is over, since a live session can't have exited before start yet.
+func ForSession(state session.State, ended bool) SessionQoE {
+	qoe := SessionQoE{
+		SessionID:               state.SessionID,
+		VideoID:                 state.VideoID,
+		StreamType:              state.StreamType,
+		TimeToFirstFrameSeconds: state.StartupTimeSeconds,
+		RebufferRatio:           rebufferRatio(state.RebufferTimeSeconds, state.WatchTimeSeconds),
+		RebufferCount:           state.RebufferCount,
+		WatchTimeSeconds:        state.WatchTimeSeconds,
+		PlaybackStarted:         state.PlaybackStarted,
+		AverageBitrate:          state.AverageBitrate,
+		Renditions:              state.Renditions,
+		Upshifts:                state.Upshifts,
+		Downshifts:              state.Downshifts,
+		ExitedBeforeStart:       ended && exitedBeforeStart(state),
+		ErrorCount:              state.ErrorCount,
+		SessionEnded:            ended,
+		Ads:                     state.Ads,
+		AdCompletionRate:        ratio(state.Ads.Completions, state.Ads.Starts),
+	}
+	if models.IsLiveStream(state.StreamType) {
+		qoe.LiveJoinTimeSeconds = state.StartupTimeSeconds
+		qoe.LiveLatencySeconds = state.LiveLatencySeconds
+	}
+	return qoe
+}
+
+// ratio is n/total, or 0 without a total
+func ratio(n, total int) float64 {
+	if total > 0 {
+		return float64(n) / float64(total)
+	}
+	return 0
+}
+
+// exitedBeforeStart reports an attempted playback that never showed a frame
+// and didn't fail with a player error (those count as video start failures)
+func exitedBeforeStart(state session.State) bool {
+	return state.PlaybackAttempted && !state.PlaybackStarted && state.ErrorCount == 0
+}
+
+func rebufferRatio(rebuffer, watch float64) float64 {
+	if total := rebuffer + watch; total > 0 {
+		return rebuffer / total
+	}
+	return 0
+}
+
+// VideoQoE aggregates the QoE of the ended sessions of one video
+type VideoQoE struct {
+	VideoID string `json:"videoId"`
+	// StreamType is the stream type of the last session
+	StreamType string `json:"streamType,omitempty"`
+
+	// Attempts counts sessions that tried to play the video
+	Attempts              int `json:"attempts"`
+	Plays                 int `json:"plays"`
+	ExitsBeforeVideoStart int `json:"exitsBeforeVideoStart"`
+	VideoStartFailures    int `json:"videoStartFailures"`
+
+	ExitBeforeVideoStartRate       float64 `json:"exitBeforeVideoStartRate"`
+	AverageTimeToFirstFrameSeconds float64 `json:"averageTimeToFirstFrameSeconds"`
+	RebufferRatio                  float64 `json:"rebufferRatio"`
+	WatchTimeSeconds               float64 `json:"watchTimeSeconds"`
+	RebufferTimeSeconds            float64 `json:"rebufferTimeSeconds"`
+	AverageBitrate                 float64 `json:"averageBitrate,omitempty"`
+
+	AdStarts         int     `json:"adStarts,omitempty"`
+	AdCompletions    int     `json:"adCompletions,omitempty"`
+	AdErrors         int     `json:"adErrors,omitempty"`
+	AdCompletionRate float64 `json:"adCompletionRate,omitempty"`
+	AdTimeSeconds    float64 `json:"adTimeSeconds,omitempty"`
+
+	// LivePlays counts the played sessions of the video as a live stream,
+	// which the live averages are taken over
+	LivePlays                  int     `json:"livePlays,omitempty"`
+	AverageLiveJoinTimeSeconds float64 `json:"averageLiveJoinTimeSeconds,omitempty"`
+	AverageLiveLatencySeconds  float64 `json:"averageLiveLatencySeconds,omitempty"`
+
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// videoTotals are the running sums behind a VideoQoE
+type videoTotals struct {
+	VideoQoE
+	ttffSum     float64
+	bitrateSum  float64
+	bitrateTime float64
+	joinSum     float64
+	latencySum  float64
+	// latencyPlays counts the live plays that reported a latency
+	latencyPlays int
+}
+
+func (v *videoTotals) add(state session.State, at time.Time) {
+	v.Attempts++
+	switch {
+	case state.PlaybackStarted:
+		v.Plays++
+		v.ttffSum += state.StartupTimeSeconds
+		if models.IsLiveStream(state.StreamType) {
+			v.LivePlays++
+			v.joinSum += state.StartupTimeSeconds
+			v.AverageLiveJoinTimeSeconds = v.joinSum / float64(v.LivePlays)
+			if state.LiveLatencySeconds > 0 {
+				v.latencyPlays++
+				v.latencySum += state.LiveLatencySeconds
+				v.AverageLiveLatencySeconds = v.latencySum / float64(v.latencyPlays)
+			}
+		}
+	case state.ErrorCount > 0:
+		v.VideoStartFailures++
+	default:
+		v.ExitsBeforeVideoStart++
+	}
+	if state.StreamType != "" {
+		v.StreamType = state.StreamType
+	}
+
+	v.WatchTimeSeconds += state.WatchTimeSeconds
+	v.RebufferTimeSeconds += state.RebufferTimeSeconds
+	if state.AverageBitrate > 0 && state.WatchTimeSeconds > 0 {
+		v.bitrateSum += state.AverageBitrate * state.WatchTimeSeconds
+		v.bitrateTime += state.WatchTimeSeconds
+	}
+
+	v.ExitBeforeVideoStartRate = float64(v.ExitsBeforeVideoStart) / float64(v.Attempts)
+	if v.Plays > 0 {
+		v.AverageTimeToFirstFrameSeconds = v.ttffSum / float64(v.Plays)
+	}
+	v.RebufferRatio = rebufferRatio(v.RebufferTimeSeconds, v.WatchTimeSeconds)
+	v.AdStarts += state.Ads.Starts
+	v.AdCompletions += state.Ads.Completions
+	v.AdErrors += state.Ads.Errors
+	v.AdTimeSeconds += state.Ads.TimeSeconds
+	v.AdCompletionRate = ratio(v.AdCompletions, v.AdStarts)
+	if v.bitrateTime > 0 {
+		v.AverageBitrate = v.bitrateSum / v.bitrateTime
+	}
+	v.UpdatedAt = at
+}
+
+// Aggregator accumulates per-video and per-CDN QoE from ended sessions and
+// records the per-session distributions as Prometheus metrics
+type Aggregator struct {
+	mu        sync.Mutex
+	videos    map[videoKey]*videoTotals
+	cdns      map[cdnKey]*cdnBuckets
+	maxVideos int
+	now       func() time.Time
+}
+
+type videoKey struct {
+	tenant  string
+	videoID string
+}
+
+// NewAggregator creates an Aggregator keeping totals for up to maxVideos
+// videos; the least recently updated video is dropped beyond that
+func NewAggregator(maxVideos int) *Aggregator {
+	if maxVideos <= 0 {
+		maxVideos = DefaultMaxVideos
+	}
+	return &Aggregator{
+		videos:    make(map[videoKey]*videoTotals),
+		cdns:      make(map[cdnKey]*cdnBuckets),
+		maxVideos: maxVideos,
+		now:       time.Now,
+	}
+}
+
+// Record folds the final state of an ended session into the totals of its
+// video and CDN. Sessions that never tried to play are ignored. It has the
+// signature expected by session.Tracker.OnSessionEnd.
+func (a *Aggregator) Record(state session.State) {
+	if !state.PlaybackAttempted {
+		return
+	}
+	observe(state)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := videoKey{tenant: state.Tenant, videoID: state.VideoID}
+	totals, ok := a.videos[key]
+	if !ok {
+		if len(a.videos) >= a.maxVideos {
+			a.evictOldest()
+		}
+		totals = &videoTotals{VideoQoE: VideoQoE{VideoID: state.VideoID}}
+		a.videos[key] = totals
+	}
+	totals.add(state, a.now())
+	a.recordCDN(state)
+}
+
+// evictOldest drops the least recently updated video
+func (a *Aggregator) evictOldest() {
+	var oldest videoKey
+	var oldestAt time.Time
+	for key, totals := range a.videos {
+		if oldestAt.IsZero() || totals.UpdatedAt.Before(oldestAt) {
+			oldest, oldestAt = key, totals.UpdatedAt
+		}
+	}
+	delete(a.videos, oldest)
+}
+
+// Video returns the QoE of a tenant's video
+func (a *Aggregator) Video(tenant, videoID string) (VideoQoE, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	totals, ok := a.videos[videoKey{tenant: tenant, videoID: videoID}]
+	if !ok {
+		return VideoQoE{}, false
+	}
+	return totals.VideoQoE, true
+}
+
+// observe records an ended session in the QoE metrics
+func observe(state session.State) {
+	tenant := state.Tenant
+	switch {
+	case state.PlaybackStarted:
+		metrics.QoESessions.WithLabelValues(tenant, "played").Inc()
+		metrics.QoETimeToFirstFrame.WithLabelValues(tenant).Observe(state.StartupTimeSeconds)
+		metrics.QoERebufferRatio.WithLabelValues(tenant).Observe(
+			rebufferRatio(state.RebufferTimeSeconds, state.WatchTimeSeconds))
+		if state.AverageBitrate > 0 {
+			metrics.QoEAverageBitrate.WithLabelValues(tenant).Observe(state.AverageBitrate)
+		}
+		if models.IsLiveStream(state.StreamType) {
+			metrics.QoELiveJoinTime.WithLabelValues(tenant).Observe(state.StartupTimeSeconds)
+			if state.LiveLatencySeconds > 0 {
+				metrics.QoELiveLatency.WithLabelValues(tenant).Observe(state.LiveLatencySeconds)
+			}
+		}
+	case state.ErrorCount > 0:
+		metrics.QoESessions.WithLabelValues(tenant, "start_failure").Inc()
+	default:
+		metrics.QoESessions.WithLabelValues(tenant, "exit_before_start").Inc()
+	}
+
+	ads := state.Ads
+	for event, n := range map[string]int{
+		"start":          ads.Starts,
+		"first_quartile": ads.FirstQuartiles,
+		"midpoint":       ads.Midpoints,
+		"third_quartile": ads.ThirdQuartiles,
+		"complete":       ads.Completions,
+		"error":          ads.Errors,
+	} {
+		if n > 0 {
+			metrics.QoEAdEvents.WithLabelValues(tenant, event).Add(float64(n))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package analytics
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// sketchPrecision gives 4096 HyperLogLog registers, about 1.6% error
+	sketchPrecision = 12
+	sketchRegisters = 1 << sketchPrecision
+	// sketchSparseLimit is how many exact hashes a sketch keeps before it
+	// switches to registers; below it counts are exact
+	sketchSparseLimit = 512
+)
+
+// sketch estimates the number of distinct values added to it. Small sets
+// are counted exactly; larger ones with HyperLogLog, so memory stays at
+// 4 KiB per sketch however many viewers a video has.
+type sketch struct {
+	sparse    map[uint64]struct{}
+	registers []uint8
+}
+
+func newSketch() *sketch {
+	return &sketch{sparse: make(map[uint64]struct{})}
+}
+
+// add records value
+func (s *sketch) add(value string) {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	s.addHash(mix(h.Sum64()))
+}
+
+func (s *sketch) addHash(hash uint64) {
+	if s.registers != nil {
+		s.setRegister(hash)
+		return
+	}
+	s.sparse[hash] = struct{}{}
+	if len(s.sparse) > sketchSparseLimit {
+		s.densify()
+	}
+}
+
+func (s *sketch) setRegister(hash uint64) {
+	index := hash >> (64 - sketchPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<sketchPrecision|1<<(sketchPrecision-1)) + 1)
+	if rank > s.registers[index] {
+		s.registers[index] = rank
+	}
+}
+
+// densify moves the exact hashes into registers
+func (s *sketch) densify() {
+	s.registers = make([]uint8, sketchRegisters)
+	for hash := range s.sparse {
+		s.setRegister(hash)
+	}
+	s.sparse = nil
+}
+
+// merge adds every value of other to s
+func (s *sketch) merge(other *sketch) {
+	if other.registers == nil {
+		for hash := range other.sparse {
+			s.addHash(hash)
+		}
+		return
+	}
+	if s.registers == nil {
+		s.densify()
+	}
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+}
+
+// count returns the estimated number of distinct values
+func (s *sketch) count() int {
+	if s.registers == nil {
+		return len(s.sparse)
+	}
+
+	const m = float64(sketchRegisters)
+	sum, zeros := 0.0, 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate while many registers are empty
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int(estimate + 0.5)
+}
+
+// mix spreads FNV's output over all 64 bits, which HyperLogLog relies on
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
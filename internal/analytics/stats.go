@@ -0,0 +1,286 @@
+package analytics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+const (
+	// DefaultStatsRetention is how far back video stats can be queried
+	DefaultStatsRetention = 24 * time.Hour
+	// statsBucket is the resolution of video stats windows
+	statsBucket = 5 * time.Minute
+)
+
+// VideoStats are the viewing totals of one video, or of every video of a
+// tenant, over a time window
+type VideoStats struct {
+	VideoID string    `json:"videoId,omitempty"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+
+	Sessions      int `json:"sessions"`
+	Plays         int `json:"plays"`
+	Completes     int `json:"completes"`
+	UniqueViewers int `json:"uniqueViewers"`
+
+	TotalWatchTimeSeconds   float64 `json:"totalWatchTimeSeconds"`
+	AverageWatchTimeSeconds float64 `json:"averageWatchTimeSeconds"`
+	// CompletionRate is the share of plays watched to the end
+	CompletionRate float64 `json:"completionRate"`
+	// ErrorRate is the share of sessions that hit a player error
+	ErrorRate float64 `json:"errorRate"`
+
+	// StartupTimeSeconds are the percentiles of the time to first frame of
+	// the plays, and Bitrate those of the average bitrate of the sessions
+	// that reported one. They are left out without any.
+	StartupTimeSeconds *Percentiles `json:"startupTimeSeconds,omitempty"`
+	Bitrate            *Percentiles `json:"bitrate,omitempty"`
+}
+
+// statsCounts are the totals of one bucket
+type statsCounts struct {
+	sessions  int
+	plays     int
+	completes int
+	errors    int
+	watchTime float64
+	viewers   *sketch
+	startup   *digest
+	bitrate   *digest
+}
+
+func newStatsCounts() *statsCounts {
+	return &statsCounts{viewers: newSketch(), startup: newDigest(), bitrate: newDigest()}
+}
+
+func (c *statsCounts) add(state session.State) {
+	c.sessions++
+	if state.PlaybackStarted {
+		c.plays++
+		c.startup.add(state.StartupTimeSeconds)
+	}
+	if state.AverageBitrate > 0 {
+		c.bitrate.add(state.AverageBitrate)
+	}
+	if state.Ended {
+		c.completes++
+	}
+	if state.ErrorCount > 0 {
+		c.errors++
+	}
+	c.watchTime += state.WatchTimeSeconds
+	c.viewers.add(viewerID(state))
+}
+
+func (c *statsCounts) merge(other *statsCounts) {
+	c.sessions += other.sessions
+	c.plays += other.plays
+	c.completes += other.completes
+	c.errors += other.errors
+	c.watchTime += other.watchTime
+	c.viewers.merge(other.viewers)
+	c.startup.merge(other.startup)
+	c.bitrate.merge(other.bitrate)
+}
+
+// viewerID identifies the viewer of a session: the signed-in user, else
+// the device's anonymous ID, else the session itself
+func viewerID(state session.State) string {
+	switch {
+	case state.UserID != "":
+		return "u:" + state.UserID
+	case state.AnonymousID != "":
+		return "a:" + state.AnonymousID
+	default:
+		return "s:" + state.SessionID
+	}
+}
+
+// videoBuckets holds a video's counts by the start of their bucket
+type videoBuckets struct {
+	buckets   map[int64]*statsCounts
+	updatedAt time.Time
+}
+
+// record adds state to the bucket of its last event and drops the buckets
+// that fell out of retention
+func (v *videoBuckets) record(state session.State, now time.Time, retention time.Duration) {
+	v.updatedAt = now
+	start := bucketStart(state.LastEventAt)
+	counts, ok := v.buckets[start]
+	if !ok {
+		counts = newStatsCounts()
+		v.buckets[start] = counts
+	}
+	counts.add(state)
+
+	cutoff := now.Add(-retention - statsBucket).Unix()
+	for start := range v.buckets {
+		if start < cutoff {
+			delete(v.buckets, start)
+		}
+	}
+}
+
+// sum merges the buckets starting at first or later into total
+func (v *videoBuckets) sum(total *statsCounts, first int64) {
+	for start, counts := range v.buckets {
+		if start >= first {
+			total.merge(counts)
+		}
+	}
+}
+
+func newVideoBuckets() *videoBuckets {
+	return &videoBuckets{buckets: make(map[int64]*statsCounts)}
+}
+
+// StatsStore keeps per-video and per-tenant viewing totals of ended
+// sessions in five-minute buckets, so they can be summed over any window
+// within the retention period
+type StatsStore struct {
+	mu     sync.Mutex
+	videos map[videoKey]*videoBuckets
+	// tenants sums every video of a tenant, evicted ones included
+	tenants   map[string]*videoBuckets
+	retention time.Duration
+	maxVideos int
+	now       func() time.Time
+}
+
+// NewStatsStore creates a store that answers windows up to retention long
+// for at most maxVideos videos
+func NewStatsStore(retention time.Duration, maxVideos int) *StatsStore {
+	if retention <= 0 {
+		retention = DefaultStatsRetention
+	}
+	if maxVideos <= 0 {
+		maxVideos = DefaultMaxVideos
+	}
+	return &StatsStore{
+		videos:    make(map[videoKey]*videoBuckets),
+		tenants:   make(map[string]*videoBuckets),
+		retention: retention,
+		maxVideos: maxVideos,
+		now:       time.Now,
+	}
+}
+
+// Retention is the longest window Query answers
+func (s *StatsStore) Retention() time.Duration {
+	return s.retention
+}
+
+// Record adds an ended session to the bucket of its last event. Register
+// it with Tracker.OnSessionEnd.
+func (s *StatsStore) Record(state session.State) {
+	if state.VideoID == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	key := videoKey{tenant: state.Tenant, videoID: state.VideoID}
+	video, ok := s.videos[key]
+	if !ok {
+		if len(s.videos) >= s.maxVideos {
+			s.evictOldest()
+		}
+		video = newVideoBuckets()
+		s.videos[key] = video
+	}
+	video.record(state, now, s.retention)
+
+	tenant, ok := s.tenants[state.Tenant]
+	if !ok {
+		tenant = newVideoBuckets()
+		s.tenants[state.Tenant] = tenant
+	}
+	tenant.record(state, now, s.retention)
+}
+
+// evictOldest drops the least recently updated video
+func (s *StatsStore) evictOldest() {
+	var oldest videoKey
+	var oldestAt time.Time
+	for key, video := range s.videos {
+		if oldestAt.IsZero() || video.updatedAt.Before(oldestAt) {
+			oldest, oldestAt = key, video.updatedAt
+		}
+	}
+	delete(s.videos, oldest)
+}
+
+// Query sums a tenant's video over the window ending now. Window edges are
+// rounded out to whole five-minute buckets and the window is capped at
+// the retention period. live adds sessions that haven't ended yet, such
+// as those from Tracker.Active; only the ones of this video whose last
+// event falls in the window are counted.
+func (s *StatsStore) Query(tenant, videoID string, window time.Duration, live []session.State) VideoStats {
+	return s.query(tenant, videoID, window, live)
+}
+
+// QueryTenant sums all of a tenant's videos over the window ending now,
+// like Query does one. live adds the tenant's sessions of any video that
+// haven't ended yet.
+func (s *StatsStore) QueryTenant(tenant string, window time.Duration, live []session.State) VideoStats {
+	return s.query(tenant, "", window, live)
+}
+
+// query sums the video of a tenant, or every video with an empty videoID
+func (s *StatsStore) query(tenant, videoID string, window time.Duration, live []session.State) VideoStats {
+	window = min(window, s.retention)
+	to := s.now()
+	from := to.Add(-window)
+
+	total := newStatsCounts()
+	s.mu.Lock()
+	buckets, ok := s.tenants[tenant]
+	if videoID != "" {
+		buckets, ok = s.videos[videoKey{tenant: tenant, videoID: videoID}]
+	}
+	if ok {
+		buckets.sum(total, bucketStart(from))
+	}
+	s.mu.Unlock()
+
+	for _, state := range live {
+		if state.Tenant != tenant || state.VideoID == "" || state.LastEventAt.Before(from) {
+			continue
+		}
+		if videoID == "" || state.VideoID == videoID {
+			total.add(state)
+		}
+	}
+
+	stats := VideoStats{
+		VideoID:               videoID,
+		From:                  from,
+		To:                    to,
+		Sessions:              total.sessions,
+		Plays:                 total.plays,
+		Completes:             total.completes,
+		UniqueViewers:         total.viewers.count(),
+		TotalWatchTimeSeconds: total.watchTime,
+		StartupTimeSeconds:    total.startup.percentiles(),
+		Bitrate:               total.bitrate.percentiles(),
+	}
+	if total.plays > 0 {
+		stats.AverageWatchTimeSeconds = total.watchTime / float64(total.plays)
+		stats.CompletionRate = float64(total.completes) / float64(total.plays)
+	}
+	if total.sessions > 0 {
+		stats.ErrorRate = float64(total.errors) / float64(total.sessions)
+	}
+	return stats
+}
+
+// bucketStart returns the Unix time of the start of t's bucket
+func bucketStart(t time.Time) int64 {
+	return t.Truncate(statsBucket).Unix()
+}
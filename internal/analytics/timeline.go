@@ -0,0 +1,306 @@
+package analytics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// DefaultTimelineGap is the silence between two events of a session that
+// a timeline marks as a gap. The JavaScript SDK sends a heartbeat every
+// 10 seconds while playing.
+const DefaultTimelineGap = 30 * time.Second
+
+// Timeline entry kinds. Joins, stalls, seeks, ads and gaps are spans with
+// a duration; the rest are instants.
+const (
+	// TimelineJoin runs from the player loading to the first frame
+	TimelineJoin = "join"
+	TimelinePlay = "play"
+	// TimelinePause is a pause, lasting until playback resumes
+	TimelinePause = "pause"
+	// TimelineStall is rebuffering while the viewer was watching
+	TimelineStall = "stall"
+	TimelineSeek  = "seek"
+	// TimelineQualitySwitch is a change of the rendition playing
+	TimelineQualitySwitch = "quality_switch"
+	TimelineError         = "error"
+	TimelineAd            = "ad"
+	TimelineEnd           = "end"
+	// TimelineGap is a silence of the session longer than the gap
+	// threshold, such as a closed laptop or a lost connection
+	TimelineGap = "gap"
+)
+
+// TimelineEntry is one step of a session, placed by its offset from the
+// session's first event
+type TimelineEntry struct {
+	Kind string `json:"kind"`
+	// Event and EventID are of the event the entry starts at
+	Event         string    `json:"event,omitempty"`
+	EventID       string    `json:"eventId,omitempty"`
+	At            time.Time `json:"at"`
+	OffsetSeconds float64   `json:"offsetSeconds"`
+	// DurationSeconds is the length of spans
+	DurationSeconds float64 `json:"durationSeconds,omitempty"`
+	// Open marks spans the session's events never closed, such as a join
+	// the viewer left before the first frame. They last until the session's
+	// last event.
+	Open bool `json:"open,omitempty"`
+	// Position is the playhead when the entry started, and ToPosition where
+	// a seek landed
+	Position   float64 `json:"position"`
+	ToPosition float64 `json:"toPosition,omitempty"`
+
+	// From and To are the renditions of a quality switch, Direction up or
+	// down
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Direction string `json:"direction,omitempty"`
+
+	// Message, Category and Fingerprint describe errors
+	Message     string `json:"message,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Fatal       bool   `json:"fatal,omitempty"`
+
+	// Paused marks gaps while the player was paused or hadn't started,
+	// when players send no heartbeats
+	Paused bool `json:"paused,omitempty"`
+}
+
+// Timeline is the ordered steps of a session, for a debugging waterfall
+type Timeline struct {
+	SessionID string    `json:"sessionId"`
+	VideoID   string    `json:"videoId,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	// DurationSeconds is the time from the first event to the last
+	DurationSeconds float64 `json:"durationSeconds"`
+	// Events counts the events read, duplicates of retried batches aside
+	Events  int             `json:"events"`
+	Entries []TimelineEntry `json:"entries"`
+}
+
+// TimelineBuilder reconstructs the timeline of a session from its events,
+// which must be observed in time order
+type TimelineBuilder struct {
+	gap      time.Duration
+	timeline Timeline
+	seen     map[string]struct{}
+	last     time.Time
+	paused   bool
+	started  bool
+	position float64
+	// rendition is the quality or bitrate playing, empty before one was
+	// reported
+	rendition string
+	bitrate   float64
+	// open holds the index in Entries of the span of each kind still open
+	open map[string]int
+}
+
+// NewTimelineBuilder marks silences longer than gap, or DefaultTimelineGap
+// when gap is zero
+func NewTimelineBuilder(sessionID string, gap time.Duration) *TimelineBuilder {
+	if gap <= 0 {
+		gap = DefaultTimelineGap
+	}
+	return &TimelineBuilder{
+		gap:      gap,
+		timeline: Timeline{SessionID: sessionID, Entries: []TimelineEntry{}},
+		seen:     make(map[string]struct{}),
+		paused:   true,
+		open:     make(map[string]int),
+	}
+}
+
+// Observe adds a stored event of the session. Events whose ID was already
+// observed, stored again by a retried batch, are skipped.
+func (b *TimelineBuilder) Observe(record models.EventRecord) {
+	if record.EventID != "" {
+		if _, ok := b.seen[record.EventID]; ok {
+			return
+		}
+		b.seen[record.EventID] = struct{}{}
+	}
+	event := record.Event
+	at := recordTime(record)
+	t := &b.timeline
+	if t.Events == 0 {
+		t.Start = at
+		t.VideoID = event.VideoID
+	} else if silence := at.Sub(b.last); silence > b.gap {
+		gap := b.entry(TimelineGap, models.Event{}, b.last)
+		gap.DurationSeconds = silence.Seconds()
+		gap.Paused = b.paused
+		t.Entries = append(t.Entries, gap)
+	}
+	t.Events++
+	b.last = at
+	// Players already report the target of a seek when it starts
+	seekFrom := b.position
+	if p := event.PlaybackState; p != nil {
+		b.position = p.CurrentTime
+	}
+
+	switch event.EventName {
+	case "playerInit", "loadstart":
+		if !b.started {
+			b.begin(TimelineJoin, event, at)
+		}
+	case "play":
+		if !b.started {
+			b.begin(TimelineJoin, event, at)
+		}
+		b.close(TimelinePause, at)
+		b.add(TimelinePlay, event, at)
+	case "playing":
+		b.started = true
+		b.paused = false
+		b.close(TimelineJoin, at)
+		b.close(TimelinePause, at)
+		b.close(TimelineStall, at)
+		b.close(TimelineAd, at)
+	case "timeupdate", "heartbeat":
+		if b.started && !b.paused {
+			b.close(TimelineStall, at)
+		}
+	case "waiting", "stalled":
+		// Buffering while joining or seeking is part of that span
+		if b.started && !b.isOpen(TimelineSeek) {
+			b.begin(TimelineStall, event, at)
+		}
+	case "seeking":
+		if !b.isOpen(TimelineSeek) {
+			b.begin(TimelineSeek, event, at)
+			b.timeline.Entries[b.open[TimelineSeek]].Position = seekFrom
+		}
+	case "seeked":
+		if i, ok := b.open[TimelineSeek]; ok {
+			t.Entries[i].ToPosition = b.position
+		}
+		b.close(TimelineSeek, at)
+	case "pause":
+		b.close(TimelineStall, at)
+		b.begin(TimelinePause, event, at)
+		b.paused = true
+	case "ended":
+		b.close(TimelineStall, at)
+		b.add(TimelineEnd, event, at)
+		b.paused = true
+	case "error":
+		entry := b.entry(TimelineError, event, at)
+		entry.Message = session.ErrorMessage(event)
+		if e := event.PlayerError; e != nil {
+			entry.Category, entry.Fingerprint, entry.Fatal = e.Category, e.Fingerprint, e.Fatal
+		}
+		t.Entries = append(t.Entries, entry)
+	case models.AdStart:
+		b.close(TimelineAd, at)
+		b.begin(TimelineAd, event, at)
+	case models.AdComplete, models.AdError:
+		b.close(TimelineAd, at)
+	}
+	b.observeRendition(event, at)
+}
+
+// observeRendition adds a quality switch when event reports another
+// rendition than the one playing
+func (b *TimelineBuilder) observeRendition(event models.Event, at time.Time) {
+	p := event.PlaybackState
+	if p == nil || (p.Quality == "" && p.Bitrate <= 0) {
+		return
+	}
+	rendition := p.Quality
+	if rendition == "" {
+		rendition = fmt.Sprintf("%.0fkbps", p.Bitrate/1000)
+	}
+	previous, previousBitrate := b.rendition, b.bitrate
+	b.rendition, b.bitrate = rendition, p.Bitrate
+	if previous == "" || previous == rendition {
+		return
+	}
+
+	entry := b.entry(TimelineQualitySwitch, event, at)
+	entry.From, entry.To = previous, rendition
+	if previousBitrate > 0 && p.Bitrate > 0 {
+		entry.Direction = "up"
+		if p.Bitrate < previousBitrate {
+			entry.Direction = "down"
+		}
+	}
+	b.timeline.Entries = append(b.timeline.Entries, entry)
+}
+
+func (b *TimelineBuilder) entry(kind string, event models.Event, at time.Time) TimelineEntry {
+	return TimelineEntry{
+		Kind:          kind,
+		Event:         event.EventName,
+		EventID:       event.EventID,
+		At:            at,
+		OffsetSeconds: at.Sub(b.timeline.Start).Seconds(),
+		Position:      b.position,
+	}
+}
+
+// add appends an instant
+func (b *TimelineBuilder) add(kind string, event models.Event, at time.Time) {
+	b.timeline.Entries = append(b.timeline.Entries, b.entry(kind, event, at))
+}
+
+// begin opens a span of kind, unless one is open
+func (b *TimelineBuilder) begin(kind string, event models.Event, at time.Time) {
+	if b.isOpen(kind) {
+		return
+	}
+	entry := b.entry(kind, event, at)
+	b.open[kind] = len(b.timeline.Entries)
+	b.timeline.Entries = append(b.timeline.Entries, entry)
+}
+
+// close ends the open span of kind at at
+func (b *TimelineBuilder) close(kind string, at time.Time) {
+	i, ok := b.open[kind]
+	if !ok {
+		return
+	}
+	entry := &b.timeline.Entries[i]
+	entry.DurationSeconds = at.Sub(entry.At).Seconds()
+	delete(b.open, kind)
+}
+
+func (b *TimelineBuilder) isOpen(kind string) bool {
+	_, ok := b.open[kind]
+	return ok
+}
+
+// Timeline returns the timeline of the events observed. Spans still open
+// last until the last event. It reports false when no event was observed.
+func (b *TimelineBuilder) Timeline() (Timeline, bool) {
+	t := b.timeline
+	if t.Events == 0 {
+		return Timeline{}, false
+	}
+	t.End = b.last
+	t.DurationSeconds = t.End.Sub(t.Start).Seconds()
+
+	t.Entries = append([]TimelineEntry(nil), t.Entries...)
+	for _, i := range b.open {
+		t.Entries[i].DurationSeconds = b.last.Sub(t.Entries[i].At).Seconds()
+		t.Entries[i].Open = true
+	}
+	return t, true
+}
+
+// recordTime is the client time of record, or when it was received if the
+// client's is missing or unparseable
+func recordTime(record models.EventRecord) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, record.Timestamp); err == nil {
+		return t
+	}
+	t, _ := time.Parse(time.RFC3339Nano, record.ReceivedAt)
+	return t
+}
@@ -0,0 +1,436 @@
+// Package anomaly watches the ingested events and ended sessions for spikes
+// in player errors, rebuffering and exits before video start per video and
+// CDN, and alerts webhooks when one is found
+package anomaly
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/analytics"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// Metrics the detector watches
+const (
+	// MetricErrorRate is the share of events that are player errors
+	MetricErrorRate = "errorRate"
+	// MetricRebufferRatio is the share of playback time of ended sessions
+	// spent rebuffering
+	MetricRebufferRatio = "rebufferRatio"
+	// MetricEBVS is the share of ended sessions that attempted playback and
+	// left before the first frame without a player error
+	MetricEBVS = "exitBeforeVideoStart"
+)
+
+// Dimensions rates are measured per
+const (
+	DimensionVideo = "videoId"
+	DimensionCDN   = "cdn"
+)
+
+const (
+	// maxSeries caps how many tenant, metric and dimension value
+	// combinations are watched; new ones are ignored beyond that
+	maxSeries = 100000
+	// idleWindows is how many windows without enough samples a series is
+	// kept for, baseline included
+	idleWindows = 60
+)
+
+// Config configures anomaly detection
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Window is the interval each rate is measured over before it is
+	// compared to its baseline
+	Window time.Duration `yaml:"window"`
+	// BaselineWindows is how many past windows the baseline, a moving
+	// average of the rate, roughly spans
+	BaselineWindows int `yaml:"baselineWindows"`
+	// SpikeFactor is how many times its baseline a rate must reach to spike
+	SpikeFactor float64 `yaml:"spikeFactor"`
+	// MinEvents and MinSessions are the fewest events (for the error rate)
+	// or ended sessions (for the others) a window needs to be judged
+	MinEvents   int `yaml:"minEvents"`
+	MinSessions int `yaml:"minSessions"`
+
+	// ErrorRate, RebufferRatio and EBVSRate are the rates below which no
+	// alert fires however far above the baseline they are
+	ErrorRate     float64 `yaml:"errorRate"`
+	RebufferRatio float64 `yaml:"rebufferRatio"`
+	EBVSRate      float64 `yaml:"ebvsRate"`
+
+	// Cooldown is how long the same metric and dimension value stay quiet
+	// after an alert
+	Cooldown time.Duration `yaml:"cooldown"`
+	Webhooks []Webhook     `yaml:"webhooks"`
+}
+
+// DefaultConfig judges one-minute windows against roughly the last half
+// hour, once enabled
+func DefaultConfig() Config {
+	return Config{
+		Window:          time.Minute,
+		BaselineWindows: 30,
+		SpikeFactor:     3,
+		MinEvents:       100,
+		MinSessions:     10,
+		ErrorRate:       0.02,
+		RebufferRatio:   0.05,
+		EBVSRate:        0.2,
+		Cooldown:        15 * time.Minute,
+	}
+}
+
+// Validate checks the window, the thresholds and the webhooks
+func (c Config) Validate() error {
+	if c.Window < time.Second {
+		return fmt.Errorf("invalid anomaly window %v, must be at least 1s", c.Window)
+	}
+	if c.SpikeFactor < 1 {
+		return fmt.Errorf("invalid anomaly spike factor %v, must be at least 1", c.SpikeFactor)
+	}
+	for name, threshold := range map[string]float64{
+		MetricErrorRate:     c.ErrorRate,
+		MetricRebufferRatio: c.RebufferRatio,
+		MetricEBVS:          c.EBVSRate,
+	} {
+		if threshold < 0 || threshold > 1 {
+			return fmt.Errorf("invalid %s threshold %v, must be between 0 and 1", name, threshold)
+		}
+	}
+	for _, webhook := range c.Webhooks {
+		if err := webhook.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Webhook formats
+const (
+	// FormatJSON posts the Alert as JSON
+	FormatJSON = "json"
+	// FormatSlack posts a Slack incoming webhook message
+	FormatSlack = "slack"
+	// FormatPagerDuty triggers a PagerDuty Events API v2 alert
+	FormatPagerDuty = "pagerduty"
+)
+
+// pagerDutyURL is the Events API v2 endpoint used when a PagerDuty webhook
+// has no URL
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// Webhook is where alerts are posted
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Format is json, slack or pagerduty
+	Format string `yaml:"format"`
+	// RoutingKey is the integration key of a PagerDuty service
+	RoutingKey string `yaml:"routingKey"`
+}
+
+func (w Webhook) validate() error {
+	switch w.Format {
+	case "", FormatJSON, FormatSlack:
+		if w.URL == "" {
+			return fmt.Errorf("alert webhook has no URL")
+		}
+	case FormatPagerDuty:
+		if w.RoutingKey == "" {
+			return fmt.Errorf("PagerDuty alert webhook has no routing key")
+		}
+	default:
+		return fmt.Errorf("unknown alert webhook format %q, must be %s, %s or %s", w.Format, FormatJSON, FormatSlack, FormatPagerDuty)
+	}
+	if w.URL != "" {
+		if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid alert webhook URL %q", w.URL)
+		}
+	}
+	return nil
+}
+
+// Alert is a rate that spiked over one window
+type Alert struct {
+	Tenant    string `json:"tenant,omitempty"`
+	Metric    string `json:"metric"`
+	Dimension string `json:"dimension"`
+	Value     string `json:"value"`
+
+	Rate float64 `json:"rate"`
+	// Baseline is the usual rate, over BaselineWindows of the past windows;
+	// zero BaselineWindows means there was none yet
+	Baseline        float64 `json:"baseline"`
+	BaselineWindows int     `json:"baselineWindows"`
+	Threshold       float64 `json:"threshold"`
+	// Samples is how many events or sessions the rate was measured over
+	Samples int `json:"samples"`
+
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Summary describes the alert in one line
+func (a Alert) Summary() string {
+	summary := fmt.Sprintf("%s of %s %s was %.1f%% over %d samples", a.Metric, a.Dimension, a.Value, a.Rate*100, a.Samples)
+	if a.BaselineWindows > 0 {
+		summary += fmt.Sprintf(", usually %.1f%%", a.Baseline*100)
+	}
+	if a.Tenant != "" {
+		summary += " (tenant " + a.Tenant + ")"
+	}
+	return summary
+}
+
+// seriesKey identifies the rate of a metric for one dimension value
+type seriesKey struct {
+	tenant    string
+	metric    string
+	dimension string
+	value     string
+}
+
+// series is the current window and the baseline of one rate. The rate of a
+// window is num/den; samples counts what it was measured over.
+type series struct {
+	num     float64
+	den     float64
+	samples int
+
+	baseline  float64
+	windows   int
+	idle      int
+	alertedAt time.Time
+}
+
+// Detector measures error, rebuffer and EBVS rates per video and CDN over
+// fixed windows and alerts when one spikes above its baseline. It is safe
+// for concurrent use.
+type Detector struct {
+	cfg      Config
+	alpha    float64
+	notifier *notifier
+	now      func() time.Time
+
+	mu     sync.Mutex
+	series map[seriesKey]*series
+	from   time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a Detector and starts judging its windows. Zero fields of
+// cfg select the defaults.
+func New(cfg Config) *Detector {
+	defaults := DefaultConfig()
+	if cfg.Window <= 0 {
+		cfg.Window = defaults.Window
+	}
+	if cfg.BaselineWindows <= 0 {
+		cfg.BaselineWindows = defaults.BaselineWindows
+	}
+	if cfg.SpikeFactor <= 0 {
+		cfg.SpikeFactor = defaults.SpikeFactor
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = defaults.Cooldown
+	}
+
+	d := &Detector{
+		cfg: cfg,
+		// An exponential moving average weighted like a simple average of
+		// BaselineWindows windows
+		alpha:    2 / float64(cfg.BaselineWindows+1),
+		notifier: newNotifier(cfg.Webhooks),
+		now:      time.Now,
+		series:   make(map[seriesKey]*series),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	d.from = d.now()
+	go d.run()
+	return d
+}
+
+// Observe counts the events of a stored batch towards the error rates
+func (d *Detector) Observe(batch models.EventBatch) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, event := range batch.Events {
+		var errors float64
+		if event.EventName == "error" {
+			errors = 1
+		}
+		for dimension, value := range dimensions(event.VideoID, event.CDN()) {
+			if s := d.get(batch.Tenant, MetricErrorRate, dimension, value); s != nil {
+				s.num += errors
+				s.den++
+				s.samples++
+			}
+		}
+	}
+}
+
+// RecordSession counts an ended session towards the rebuffer and EBVS
+// rates. It has the signature expected by session.Tracker.OnSessionEnd.
+func (d *Detector) RecordSession(state session.State) {
+	if !state.PlaybackAttempted {
+		return
+	}
+	qoe := analytics.ForSession(state, true)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for dimension, value := range dimensions(state.VideoID, state.CDN) {
+		if s := d.get(state.Tenant, MetricEBVS, dimension, value); s != nil {
+			if qoe.ExitedBeforeStart {
+				s.num++
+			}
+			s.den++
+			s.samples++
+		}
+		if !state.PlaybackStarted {
+			continue
+		}
+		if s := d.get(state.Tenant, MetricRebufferRatio, dimension, value); s != nil {
+			s.num += state.RebufferTimeSeconds
+			s.den += state.RebufferTimeSeconds + state.WatchTimeSeconds
+			s.samples++
+		}
+	}
+}
+
+// dimensions returns the dimension values that are set
+func dimensions(videoID, cdn string) map[string]string {
+	values := make(map[string]string, 2)
+	if videoID != "" {
+		values[DimensionVideo] = videoID
+	}
+	if cdn != "" {
+		values[DimensionCDN] = cdn
+	}
+	return values
+}
+
+// get returns the series of a rate, creating it, or nil when too many are
+// watched. d.mu must be held.
+func (d *Detector) get(tenant, metric, dimension, value string) *series {
+	key := seriesKey{tenant: tenant, metric: metric, dimension: dimension, value: value}
+	s, ok := d.series[key]
+	if !ok {
+		if len(d.series) >= maxSeries {
+			return nil
+		}
+		s = &series{}
+		d.series[key] = s
+	}
+	return s
+}
+
+func (d *Detector) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.cfg.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			for _, alert := range d.evaluate(d.now()) {
+				slog.Warn("Anomaly detected", "tenant", alert.Tenant, "metric", alert.Metric,
+					"dimension", alert.Dimension, "value", alert.Value, "rate", alert.Rate,
+					"baseline", alert.Baseline, "samples", alert.Samples)
+				metrics.Alerts.WithLabelValues(alert.Tenant, alert.Metric, alert.Dimension).Inc()
+				d.notifier.send(alert)
+			}
+		}
+	}
+}
+
+// evaluate closes the window ending at now and returns the rates that
+// spiked in it. Spiking windows are left out of the baseline, so an ongoing
+// incident alerts again once the cooldown is over.
+func (d *Detector) evaluate(now time.Time) []Alert {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var alerts []Alert
+	for key, s := range d.series {
+		if s.samples < d.minSamples(key.metric) || s.den <= 0 {
+			s.num, s.den, s.samples = 0, 0, 0
+			s.idle++
+			if s.idle >= idleWindows {
+				delete(d.series, key)
+			}
+			continue
+		}
+
+		rate := s.num / s.den
+		threshold := d.threshold(key.metric)
+		spiked := rate >= threshold && rate > 0 &&
+			(s.windows == 0 || rate >= s.baseline*d.cfg.SpikeFactor)
+		switch {
+		case !spiked:
+			if s.windows == 0 {
+				s.baseline = rate
+			} else {
+				s.baseline += d.alpha * (rate - s.baseline)
+			}
+			s.windows++
+		case now.Sub(s.alertedAt) >= d.cfg.Cooldown:
+			s.alertedAt = now
+			alerts = append(alerts, Alert{
+				Tenant:          key.tenant,
+				Metric:          key.metric,
+				Dimension:       key.dimension,
+				Value:           key.value,
+				Rate:            rate,
+				Baseline:        s.baseline,
+				BaselineWindows: min(s.windows, d.cfg.BaselineWindows),
+				Threshold:       threshold,
+				Samples:         s.samples,
+				From:            d.from,
+				To:              now,
+			})
+		}
+		s.num, s.den, s.samples, s.idle = 0, 0, 0, 0
+	}
+	d.from = now
+	return alerts
+}
+
+func (d *Detector) minSamples(metric string) int {
+	if metric == MetricErrorRate {
+		return d.cfg.MinEvents
+	}
+	return d.cfg.MinSessions
+}
+
+func (d *Detector) threshold(metric string) float64 {
+	switch metric {
+	case MetricErrorRate:
+		return d.cfg.ErrorRate
+	case MetricRebufferRatio:
+		return d.cfg.RebufferRatio
+	}
+	return d.cfg.EBVSRate
+}
+
+// Close stops judging windows and waits for the alerts already raised to
+// be delivered
+func (d *Detector) Close() {
+	close(d.stop)
+	<-d.done
+	d.notifier.close()
+}
@@ -0,0 +1,135 @@
+package anomaly
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+)
+
+const (
+	// queueSize is how many alerts may wait for delivery; more are dropped
+	queueSize = 100
+	// deliveryAttempts is how often an alert is posted to a failing webhook
+	deliveryAttempts = 3
+	// deliveryTimeout bounds one attempt
+	deliveryTimeout = 10 * time.Second
+)
+
+// notifier posts alerts to the webhooks in the background, one at a time
+type notifier struct {
+	webhooks []Webhook
+	client   *http.Client
+	queue    chan Alert
+	done     chan struct{}
+}
+
+func newNotifier(webhooks []Webhook) *notifier {
+	n := &notifier{
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: deliveryTimeout},
+		queue:    make(chan Alert, queueSize),
+		done:     make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// send queues alert for delivery, dropping it when the queue is full
+func (n *notifier) send(alert Alert) {
+	if len(n.webhooks) == 0 {
+		return
+	}
+	select {
+	case n.queue <- alert:
+	default:
+		slog.Error("Dropping alert, too many waiting for delivery", "metric", alert.Metric, "value", alert.Value)
+	}
+}
+
+func (n *notifier) run() {
+	defer close(n.done)
+	for alert := range n.queue {
+		for _, webhook := range n.webhooks {
+			if err := n.deliver(webhook, alert); err != nil {
+				slog.Error("Error delivering alert", "format", webhook.format(), "error", err)
+				metrics.AlertDeliveryFailures.WithLabelValues(webhook.format()).Inc()
+			}
+		}
+	}
+}
+
+// deliver posts alert to webhook, retrying failures with a growing delay
+func (n *notifier) deliver(webhook Webhook, alert Alert) error {
+	body, err := json.Marshal(webhook.payload(alert))
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = n.post(webhook.endpoint(), body)
+		if err == nil || attempt == deliveryAttempts {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+func (n *notifier) post(url string, body []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// close delivers the queued alerts and stops
+func (n *notifier) close() {
+	close(n.queue)
+	<-n.done
+}
+
+func (w Webhook) format() string {
+	if w.Format == "" {
+		return FormatJSON
+	}
+	return w.Format
+}
+
+func (w Webhook) endpoint() string {
+	if w.URL == "" && w.Format == FormatPagerDuty {
+		return pagerDutyURL
+	}
+	return w.URL
+}
+
+// payload is the body posted for alert in the webhook's format
+func (w Webhook) payload(alert Alert) any {
+	switch w.Format {
+	case FormatSlack:
+		return map[string]string{"text": ":rotating_light: " + alert.Summary()}
+	case FormatPagerDuty:
+		return map[string]any{
+			"routing_key":  w.RoutingKey,
+			"event_action": "trigger",
+			// Alerts of the same rate are grouped into one incident
+			"dedup_key": fmt.Sprintf("esv:%s:%s:%s:%s", alert.Tenant, alert.Metric, alert.Dimension, alert.Value),
+			"payload": map[string]any{
+				"summary":        alert.Summary(),
+				"source":         "event-stream-video",
+				"severity":       "error",
+				"timestamp":      alert.To.UTC().Format(time.RFC3339),
+				"custom_details": alert,
+			},
+		}
+	}
+	return alert
+}
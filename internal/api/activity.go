@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/activity"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+)
+
+const (
+	// defaultActivityWindow is the window of the activity endpoints
+	defaultActivityWindow = 5 * time.Minute
+	// defaultActivityLimit is how many videos or errors are listed
+	defaultActivityLimit = 10
+)
+
+// ActivityHandler serves the recent ingestion activity of the caller's
+// tenant, as shown by the dashboard
+type ActivityHandler struct {
+	recorder *activity.Recorder
+}
+
+func NewActivityHandler(recorder *activity.Recorder) *ActivityHandler {
+	return &ActivityHandler{recorder: recorder}
+}
+
+// HandleGetIngestion returns the events, batches and errors of every second
+// of the window given in the window query parameter, 5m by default
+func (h *ActivityHandler) HandleGetIngestion(w http.ResponseWriter, r *http.Request) {
+	window, ok := queryWindow(w, r, defaultActivityWindow)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, h.recorder.Rate(auth.TenantFromContext(r.Context()), window))
+}
+
+// HandleGetTopVideos returns the videos with the most stored events over
+// the window, 5m by default, up to the limit query parameter
+func (h *ActivityHandler) HandleGetTopVideos(w http.ResponseWriter, r *http.Request) {
+	window, ok := queryWindow(w, r, defaultActivityWindow)
+	if !ok {
+		return
+	}
+	limit, ok := queryLimit(w, r, defaultActivityLimit)
+	if !ok {
+		return
+	}
+	videos := h.recorder.TopVideos(auth.TenantFromContext(r.Context()), window, limit)
+	writeJSON(w, http.StatusOK, map[string]any{"videos": videos})
+}
+
+// HandleGetErrors returns the latest rejected batches and player errors,
+// newest first, up to the limit query parameter
+func (h *ActivityHandler) HandleGetErrors(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r, defaultActivityLimit)
+	if !ok {
+		return
+	}
+	errors := h.recorder.Errors(auth.TenantFromContext(r.Context()), limit)
+	writeJSON(w, http.StatusOK, map[string]any{"errors": errors})
+}
+
+// queryWindow parses the window query parameter as a duration, writing a
+// 400 response and returning false when it is invalid
+func queryWindow(w http.ResponseWriter, r *http.Request, fallback time.Duration) (time.Duration, bool) {
+	param := r.URL.Query().Get("window")
+	if param == "" {
+		return fallback, true
+	}
+	window, err := time.ParseDuration(param)
+	if err != nil || window <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": "window must be a positive duration such as 1h or 15m",
+		})
+		return 0, false
+	}
+	return window, true
+}
+
+// queryLimit parses the limit query parameter, writing a 400 response and
+// returning false when it is invalid
+func queryLimit(w http.ResponseWriter, r *http.Request, fallback int) (int, bool) {
+	param := r.URL.Query().Get("limit")
+	if param == "" {
+		return fallback, true
+	}
+	limit, err := strconv.Atoi(param)
+	if err != nil || limit <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": "limit must be a positive integer",
+		})
+		return 0, false
+	}
+	return limit, true
+}
@@ -0,0 +1,445 @@
+package api
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/dimensions"
+	"github.com/adtyap26/event-stream-video/internal/ratelimit"
+	"github.com/adtyap26/event-stream-video/internal/sampling"
+	"github.com/adtyap26/event-stream-video/internal/sink/toggle"
+)
+
+// maxAdminBodySize caps admin request bodies
+const maxAdminBodySize = 64 << 10
+
+// AdminMiddleware rejects requests that don't carry token as a Bearer token
+func AdminMiddleware(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(bearer)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AdminHandler changes the collector's configuration at runtime. Every
+// dependency is optional; endpoints for a missing one answer 409.
+type AdminHandler struct {
+	keys    auth.KeyManager
+	sampler *sampling.Sampler
+	limiter *ratelimit.Limiter
+	sinks   []*toggle.Sink
+	// dimensions holds the tenants' custom dimensions
+	dimensions *dimensions.Registry
+}
+
+func NewAdminHandler(keys auth.KeyManager, sampler *sampling.Sampler, limiter *ratelimit.Limiter, sinks []*toggle.Sink) *AdminHandler {
+	return &AdminHandler{keys: keys, sampler: sampler, limiter: limiter, sinks: sinks}
+}
+
+// HandleGetSampling returns the current sampling rules
+func (h *AdminHandler) HandleGetSampling(w http.ResponseWriter, r *http.Request) {
+	if h.sampler == nil {
+		writeAdminError(w, http.StatusConflict, "Sampling is disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rules": h.sampler.Rules()})
+}
+
+// HandlePutSampling replaces all sampling rules
+func (h *AdminHandler) HandlePutSampling(w http.ResponseWriter, r *http.Request) {
+	if h.sampler == nil {
+		writeAdminError(w, http.StatusConflict, "Sampling is disabled")
+		return
+	}
+
+	var req struct {
+		Rules []sampling.Rule `json:"rules"`
+	}
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if err := h.sampler.SetRules(req.Rules); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Admin replaced sampling rules", "rules", len(req.Rules))
+	writeJSON(w, http.StatusOK, map[string]any{"rules": h.sampler.Rules()})
+}
+
+// adminKey describes an API key without revealing it
+type adminKey struct {
+	Key      string `json:"key"`
+	Tenant   string `json:"tenant"`
+	ClientID string `json:"clientId"`
+}
+
+// HandleListKeys returns all API keys, masked
+func (h *AdminHandler) HandleListKeys(w http.ResponseWriter, r *http.Request) {
+	if h.keys == nil {
+		writeAdminError(w, http.StatusConflict, "API keys can't be managed at runtime")
+		return
+	}
+
+	keys := make([]adminKey, 0)
+	for key, client := range h.keys.Keys() {
+		keys = append(keys, adminKey{Key: maskKey(key), Tenant: client.Tenant, ClientID: client.ClientID})
+	}
+	slices.SortFunc(keys, func(a, b adminKey) int {
+		return cmp.Or(strings.Compare(a.Tenant, b.Tenant), strings.Compare(a.ClientID, b.ClientID), strings.Compare(a.Key, b.Key))
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys, "persistent": h.keys.Persistent()})
+}
+
+// HandleCreateKey issues an API key for a client. The key is generated
+// unless the request names one.
+func (h *AdminHandler) HandleCreateKey(w http.ResponseWriter, r *http.Request) {
+	if h.keys == nil {
+		writeAdminError(w, http.StatusConflict, "API keys can't be managed at runtime")
+		return
+	}
+
+	var req adminKey
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Key == "" {
+		req.Key = auth.NewAPIKey()
+	}
+	if _, err := h.keys.Lookup(r.Context(), req.Key); err == nil {
+		writeAdminError(w, http.StatusConflict, "API key already exists")
+		return
+	}
+
+	h.keys.Set(req.Key, auth.Client{Tenant: req.Tenant, ClientID: req.ClientID})
+	if !h.saveKeys(w, r) {
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Admin created API key", "tenant", req.Tenant, "clientId", req.ClientID)
+	writeJSON(w, http.StatusCreated, req)
+}
+
+// HandleRotateKey replaces an API key with a newly generated one for the
+// same client. The old key stops working straight away; to roll clients
+// over gradually, create a second key first and revoke the old one later.
+func (h *AdminHandler) HandleRotateKey(w http.ResponseWriter, r *http.Request) {
+	if h.keys == nil {
+		writeAdminError(w, http.StatusConflict, "API keys can't be managed at runtime")
+		return
+	}
+
+	var req struct {
+		Key string `json:"key"`
+	}
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	client, err := h.keys.Lookup(r.Context(), req.Key)
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+
+	key := auth.NewAPIKey()
+	h.keys.Set(key, client)
+	h.keys.Delete(req.Key)
+	if !h.saveKeys(w, r) {
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Admin rotated API key", "tenant", client.Tenant, "clientId", client.ClientID)
+	writeJSON(w, http.StatusOK, adminKey{Key: key, Tenant: client.Tenant, ClientID: client.ClientID})
+}
+
+// HandleRevokeKey deletes an API key
+func (h *AdminHandler) HandleRevokeKey(w http.ResponseWriter, r *http.Request) {
+	if h.keys == nil {
+		writeAdminError(w, http.StatusConflict, "API keys can't be managed at runtime")
+		return
+	}
+
+	var req struct {
+		Key string `json:"key"`
+	}
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	client, err := h.keys.Lookup(r.Context(), req.Key)
+	if err != nil {
+		writeAdminError(w, http.StatusNotFound, "API key not found")
+		return
+	}
+
+	h.keys.Delete(req.Key)
+	if !h.saveKeys(w, r) {
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Admin revoked API key", "tenant", client.Tenant, "clientId", client.ClientID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveKeys persists a key change. The change already applies in memory,
+// so a failure is reported but not rolled back.
+func (h *AdminHandler) saveKeys(w http.ResponseWriter, r *http.Request) bool {
+	if err := h.keys.Save(); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving API keys", "error", err)
+		writeAdminError(w, http.StatusInternalServerError, "API keys changed but could not be saved")
+		return false
+	}
+	return true
+}
+
+// maskKey keeps just enough of key to tell keys apart
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "****"
+}
+
+// HandleGetRateLimits returns the per-client and per-tenant rate limits
+func (h *AdminHandler) HandleGetRateLimits(w http.ResponseWriter, r *http.Request) {
+	if h.limiter == nil {
+		writeAdminError(w, http.StatusConflict, "Rate limiting is disabled")
+		return
+	}
+	h.writeRateLimits(w)
+}
+
+// HandlePutRateLimit changes the per-client rate limit
+func (h *AdminHandler) HandlePutRateLimit(w http.ResponseWriter, r *http.Request) {
+	if h.limiter == nil {
+		writeAdminError(w, http.StatusConflict, "Rate limiting is disabled")
+		return
+	}
+
+	var req ratelimit.Rate
+	if !decodeRate(w, r, &req) {
+		return
+	}
+	h.limiter.SetRate(req.RequestsPerSecond, req.Burst)
+
+	slog.InfoContext(r.Context(), "Admin changed rate limit", "requestsPerSecond", req.RequestsPerSecond, "burst", req.Burst)
+	h.writeRateLimits(w)
+}
+
+// HandlePutTenantRateLimit sets the shared rate limit of the tenant named
+// in the path
+func (h *AdminHandler) HandlePutTenantRateLimit(w http.ResponseWriter, r *http.Request) {
+	if h.limiter == nil {
+		writeAdminError(w, http.StatusConflict, "Rate limiting is disabled")
+		return
+	}
+
+	var req ratelimit.Rate
+	if !decodeRate(w, r, &req) {
+		return
+	}
+	tenant := r.PathValue("tenant")
+	h.limiter.SetTenantLimit(tenant, req.RequestsPerSecond, req.Burst)
+
+	slog.InfoContext(r.Context(), "Admin changed tenant rate limit", "tenant", tenant,
+		"requestsPerSecond", req.RequestsPerSecond, "burst", req.Burst)
+	h.writeRateLimits(w)
+}
+
+// HandleDeleteTenantRateLimit lifts the shared rate limit of the tenant
+// named in the path
+func (h *AdminHandler) HandleDeleteTenantRateLimit(w http.ResponseWriter, r *http.Request) {
+	if h.limiter == nil {
+		writeAdminError(w, http.StatusConflict, "Rate limiting is disabled")
+		return
+	}
+
+	tenant := r.PathValue("tenant")
+	h.limiter.RemoveTenantLimit(tenant)
+
+	slog.InfoContext(r.Context(), "Admin removed tenant rate limit", "tenant", tenant)
+	h.writeRateLimits(w)
+}
+
+func (h *AdminHandler) writeRateLimits(w http.ResponseWriter) {
+	rate := h.limiter.Rate()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"requestsPerSecond": rate.RequestsPerSecond,
+		"burst":             rate.Burst,
+		"tenants":           h.limiter.TenantRates(),
+	})
+}
+
+// decodeRate decodes a rate and rejects ones that would block all requests
+func decodeRate(w http.ResponseWriter, r *http.Request, rate *ratelimit.Rate) bool {
+	if !decodeAdminRequest(w, r, rate) {
+		return false
+	}
+	if rate.RequestsPerSecond <= 0 {
+		writeAdminError(w, http.StatusBadRequest, "requestsPerSecond must be positive")
+		return false
+	}
+	return true
+}
+
+// adminSink describes whether a sink receives batches
+type adminSink struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// HandleListSinks returns the sinks and whether they are enabled
+func (h *AdminHandler) HandleListSinks(w http.ResponseWriter, r *http.Request) {
+	sinks := make([]adminSink, 0, len(h.sinks))
+	for _, s := range h.sinks {
+		sinks = append(sinks, adminSink{Name: s.Name(), Enabled: s.Enabled()})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sinks": sinks})
+}
+
+// HandlePutSink enables or disables the sink named in the path. Batches
+// sent to a disabled sink are dead-lettered when the dead-letter queue is
+// enabled, and rejected otherwise.
+func (h *AdminHandler) HandlePutSink(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	i := slices.IndexFunc(h.sinks, func(s *toggle.Sink) bool { return s.Name() == name })
+	if i < 0 {
+		writeAdminError(w, http.StatusNotFound, "Sink not found")
+		return
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		writeAdminError(w, http.StatusBadRequest, "enabled is required")
+		return
+	}
+	h.sinks[i].SetEnabled(*req.Enabled)
+
+	slog.InfoContext(r.Context(), "Admin toggled sink", "sink", name, "enabled", *req.Enabled)
+	writeJSON(w, http.StatusOK, adminSink{Name: name, Enabled: *req.Enabled})
+}
+
+// HandleListDimensions returns the custom dimensions of the tenant named in
+// the path
+func (h *AdminHandler) HandleListDimensions(w http.ResponseWriter, r *http.Request) {
+	if h.dimensions == nil {
+		writeAdminError(w, http.StatusConflict, "Custom dimensions are disabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"dimensions": h.dimensions.List(r.PathValue("tenant")),
+		"max":        h.dimensions.Max(),
+		"persistent": h.dimensions.Persistent(),
+	})
+}
+
+// HandlePutDimension registers the custom dimension named in the path for
+// the tenant named in the path, or changes its type or cardinality. Events
+// already stored aren't checked again.
+func (h *AdminHandler) HandlePutDimension(w http.ResponseWriter, r *http.Request) {
+	if h.dimensions == nil {
+		writeAdminError(w, http.StatusConflict, "Custom dimensions are disabled")
+		return
+	}
+
+	var req struct {
+		Type        string `json:"type"`
+		Cardinality string `json:"cardinality"`
+	}
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	tenant := r.PathValue("tenant")
+	d, created, err := h.dimensions.Set(tenant, dimensions.Dimension{
+		Name:        r.PathValue("name"),
+		Type:        req.Type,
+		Cardinality: req.Cardinality,
+	})
+	if errors.Is(err, dimensions.ErrLimit) {
+		writeAdminError(w, http.StatusConflict, fmt.Sprintf("Tenant already has %d dimensions", h.dimensions.Max()))
+		return
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !h.saveDimensions(w, r) {
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Admin set custom dimension", "tenant", tenant, "dimension", d.Name,
+		"type", d.Type, "cardinality", d.Cardinality)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, d)
+}
+
+// HandleDeleteDimension removes the custom dimension named in the path of
+// the tenant named in the path
+func (h *AdminHandler) HandleDeleteDimension(w http.ResponseWriter, r *http.Request) {
+	if h.dimensions == nil {
+		writeAdminError(w, http.StatusConflict, "Custom dimensions are disabled")
+		return
+	}
+
+	tenant, name := r.PathValue("tenant"), r.PathValue("name")
+	if err := h.dimensions.Delete(tenant, name); err != nil {
+		writeAdminError(w, http.StatusNotFound, "Dimension not found")
+		return
+	}
+	if !h.saveDimensions(w, r) {
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Admin removed custom dimension", "tenant", tenant, "dimension", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveDimensions persists a dimension change, which like key changes
+// already applies in memory
+func (h *AdminHandler) saveDimensions(w http.ResponseWriter, r *http.Request) bool {
+	if err := h.dimensions.Save(); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving custom dimensions", "error", err)
+		writeAdminError(w, http.StatusInternalServerError, "Dimensions changed but could not be saved")
+		return false
+	}
+	return true
+}
+
+// decodeAdminRequest decodes the JSON body of r into v, answering 400 and
+// returning false when it can't
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdminBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeBodyError(w, err)
+		return false
+	}
+	return true
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"status":  "error",
+		"message": message,
+	})
+}
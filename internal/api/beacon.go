@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// beaconRoute receives batches sent with navigator.sendBeacon or, from
+// clients without it, as image requests
+const beaconRoute = "/api/v1/events/beacon"
+
+// beaconFields are the form and query fields a beacon payload is looked for
+// in, in that order
+var beaconFields = []string{"data", "payload", "d"}
+
+// pixel is a transparent 1x1 GIF, the answer to GET beacons loaded as images
+var pixel = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// errNoBeaconPayload is returned for GET beacons and forms without a payload
+var errNoBeaconPayload = errors.New("no beacon payload in data, payload or d")
+
+// BeaconMiddleware turns the bodies old browsers and sendBeacon fallbacks
+// send into the JSON or protobuf body the ingestion handlers decode, before
+// authentication looks for an apiKey in it:
+//
+//   - GET requests carry the batch as base64 JSON in the data query parameter
+//   - url-encoded and multipart forms carry it in a data field, as JSON or
+//     base64 JSON
+//   - text/plain, application/octet-stream and untyped bodies (sendBeacon
+//     with a string or Blob) may be JSON or base64 JSON
+func BeaconMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && isProtobuf(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := beaconPayload(r)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Type", "application/json")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// beaconPayload returns the JSON batch of a beacon request
+func beaconPayload(r *http.Request) ([]byte, error) {
+	if r.Method == http.MethodGet {
+		return formPayload(r.URL.Query().Get)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		return formPayload(r.PostForm.Get)
+	case "multipart/form-data":
+		// The body limit already bounds what is read
+		if err := r.ParseMultipartForm(64 << 10); err != nil {
+			return nil, err
+		}
+		defer r.MultipartForm.RemoveAll()
+		return formPayload(r.FormValue)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return jsonOrBase64(body), nil
+}
+
+// formPayload returns the first beacon field get finds
+func formPayload(get func(string) string) ([]byte, error) {
+	for _, field := range beaconFields {
+		if value := get(field); value != "" {
+			return jsonOrBase64([]byte(value)), nil
+		}
+	}
+	return nil, errNoBeaconPayload
+}
+
+// jsonOrBase64 returns data decoded from base64, standard or URL-safe and
+// with or without padding, unless it already looks like a JSON object.
+// Anything that isn't valid base64 is returned as is for the JSON decoder
+// to report.
+func jsonOrBase64(data []byte) []byte {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] == '{' {
+		return data
+	}
+
+	// Query strings turn an unescaped + into a space
+	encoded := strings.TrimRight(strings.ReplaceAll(string(trimmed), " ", "+"), "=")
+	for _, encoding := range []*base64.Encoding{base64.RawStdEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(encoded); err == nil {
+			return decoded
+		}
+	}
+	return data
+}
+
+// writePixel answers a GET beacon with the transparent GIF
+func writePixel(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, max-age=0")
+	w.WriteHeader(http.StatusOK)
+	w.Write(pixel)
+}
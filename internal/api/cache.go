@@ -0,0 +1,77 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/cache"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+)
+
+// CacheMiddleware answers GET requests from responses c keeps for the
+// tenant, path and query parameters of the request, computing them with
+// next on a miss. Identical requests arriving during a miss wait for its
+// response. The X-Cache header tells hits, coalesced requests and misses
+// apart. It runs after authentication so tenants never share responses.
+func CacheMiddleware(c *cache.Cache, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := auth.TenantFromContext(r.Context()) + "\x00" + r.URL.Path + "?" + r.URL.Query().Encode()
+		response, outcome := c.Do(key, func() cache.Response {
+			recorder := &responseRecorder{header: make(http.Header)}
+			next.ServeHTTP(recorder, r)
+			return recorder.response()
+		})
+		metrics.CacheRequests.WithLabelValues(r.Pattern, string(outcome)).Inc()
+
+		if response.Status == 0 {
+			// The request computing it panicked
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		for name, values := range response.Header {
+			w.Header()[name] = slices.Clone(values)
+		}
+		w.Header().Set("X-Cache", string(outcome))
+		w.WriteHeader(response.Status)
+		w.Write(response.Body)
+	})
+}
+
+// responseRecorder keeps a response in memory for the cache
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) response() cache.Response {
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return cache.Response{Status: status, Header: r.header, Body: r.body.Bytes()}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// ConcurrencyHandler serves the number of viewers watching right now, for
+// live event operations
+type ConcurrencyHandler struct {
+	tracker *session.Tracker
+}
+
+func NewConcurrencyHandler(tracker *session.Tracker) *ConcurrencyHandler {
+	return &ConcurrencyHandler{tracker: tracker}
+}
+
+// HandleGetConcurrents returns the caller's concurrent viewers and the
+// videos they watch, most watched first, up to the limit query parameter
+func (h *ConcurrencyHandler) HandleGetConcurrents(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r, defaultActivityLimit)
+	if !ok {
+		return
+	}
+	concurrency := h.tracker.Concurrency(auth.TenantFromContext(r.Context()))
+	if len(concurrency.Videos) > limit {
+		concurrency.Videos = concurrency.Videos[:limit]
+	}
+	writeJSON(w, http.StatusOK, concurrency)
+}
+
+// HandleGetVideoConcurrents returns the concurrent viewers of the video
+// named in the path
+func (h *ConcurrencyHandler) HandleGetVideoConcurrents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.tracker.VideoConcurrency(auth.TenantFromContext(r.Context()), r.PathValue("id")))
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/pb/eventsv1"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+)
+
+// isProtobuf reports whether r carries a protobuf-encoded body
+func isProtobuf(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		return true
+	}
+	return false
+}
+
+// decodeBatch reads an event batch from the request body. Protobuf bodies
+// are selected by Content-Type; everything else is decoded as JSON.
+func decodeBatch(r *http.Request) (models.EventBatch, error) {
+	if isProtobuf(r) {
+		pbBatch, err := decodeProtoBatch(r.Body)
+		if err != nil {
+			return models.EventBatch{}, err
+		}
+		batch := pbBatch.ToModel()
+		schema.UpgradeBatch(&batch)
+		return batch, nil
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return models.EventBatch{}, err
+	}
+	return schema.DecodeBatch(data)
+}
+
+func decodeProtoBatch(body io.Reader) (*eventsv1.EventBatch, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var batch eventsv1.EventBatch
+	if err := proto.Unmarshal(data, &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
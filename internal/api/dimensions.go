@@ -0,0 +1,119 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/analytics"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/dimensions"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+const (
+	// defaultBreakdownWindow is how far back breakdowns look without a
+	// from query parameter
+	defaultBreakdownWindow = 24 * time.Hour
+	// defaultBreakdownLimit is how many values a breakdown returns without
+	// a limit query parameter
+	defaultBreakdownLimit = 20
+	// maxBreakdownEvents caps the events a breakdown reads from the sink
+	maxBreakdownEvents = 1_000_000
+)
+
+// DimensionHandler serves the custom dimensions of the caller's tenant and
+// breaks its stored events down by them
+type DimensionHandler struct {
+	registry *dimensions.Registry
+	// querier reads the events broken down; breakdowns aren't served
+	// without one
+	querier sink.Querier
+}
+
+func NewDimensionHandler(registry *dimensions.Registry, querier sink.Querier) *DimensionHandler {
+	return &DimensionHandler{registry: registry, querier: querier}
+}
+
+// HandleListDimensions returns the caller's custom dimensions
+func (h *DimensionHandler) HandleListDimensions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"dimensions": h.registry.List(auth.TenantFromContext(r.Context())),
+	})
+}
+
+// HandleGetBreakdown counts the caller's stored events, sessions and unique
+// viewers by the value of the low cardinality dimension named in the path,
+// over the events matching the filters of HandleQueryEvents. The time range
+// defaults to the last 24 hours. Up to limit values are returned, most
+// events first; the events without the dimension and those of the values
+// left out are counted apart. truncated is set when the range held more
+// events than are read for one breakdown.
+func (h *DimensionHandler) HandleGetBreakdown(w http.ResponseWriter, r *http.Request) {
+	tenant := auth.TenantFromContext(r.Context())
+	d, ok := h.registry.Get(tenant, r.PathValue("name"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{"status": "error", "message": "Dimension not found"})
+		return
+	}
+	if d.Cardinality == dimensions.High {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": "High cardinality dimensions can't be broken down by",
+		})
+		return
+	}
+	limit, ok := queryLimit(w, r, defaultBreakdownLimit)
+	if !ok {
+		return
+	}
+	query, ok := queryFilters(w, r)
+	if !ok {
+		return
+	}
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-defaultBreakdownWindow)
+	}
+	query.Limit = maxQueryLimit
+
+	breakdown := analytics.NewBreakdown()
+	read := 0
+	truncated := false
+	for {
+		result, err := h.querier.QueryEvents(r.Context(), query)
+		if !writeQueryError(w, query, err) {
+			return
+		}
+		for _, record := range result.Events {
+			value, ok := dimensions.Value(d, record.CustomData)
+			breakdown.Observe(record, value, ok)
+		}
+		read += len(result.Events)
+		if result.NextCursor == "" {
+			break
+		}
+		if read >= maxBreakdownEvents {
+			slog.WarnContext(r.Context(), "Breakdown truncated", "tenant", tenant, "dimension", d.Name, "events", read)
+			truncated = true
+			break
+		}
+		query.Cursor = result.NextCursor
+	}
+
+	groups, missing, other := breakdown.Groups(min(limit, analytics.MaxBreakdownGroups))
+	response := map[string]any{
+		"dimension": d,
+		"from":      query.From,
+		"to":        query.To,
+		"values":    groups,
+		"missing":   missing,
+		"other":     other,
+	}
+	if truncated {
+		response["truncated"] = true
+	}
+	writeJSON(w, http.StatusOK, response)
+}
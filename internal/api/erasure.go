@@ -0,0 +1,77 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/erasure"
+	"github.com/adtyap26/event-stream-video/internal/identity"
+	"github.com/adtyap26/event-stream-video/internal/scrub"
+)
+
+// ErasureHandler serves right-to-erasure requests. It sits behind the
+// admin token.
+type ErasureHandler struct {
+	manager  *erasure.Manager
+	scrubber *scrub.Scrubber
+	// identities, if set, forgets the links of erased users
+	identities *identity.Graph
+}
+
+// NewErasureHandler creates a handler queueing jobs on manager. scrubber,
+// if set, maps user IDs to how they are stored.
+func NewErasureHandler(manager *erasure.Manager, scrubber *scrub.Scrubber) *ErasureHandler {
+	return &ErasureHandler{manager: manager, scrubber: scrubber}
+}
+
+// HandleDeleteUserEvents queues the erasure of every stored event of the
+// user named in the path, in the tenant given by the tenant query
+// parameter (auth.DefaultTenant when missing), and forgets the user's
+// identity links. It answers 202 with the job and its status URL in
+// Location.
+func (h *ErasureHandler) HandleDeleteUserEvents(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userId")
+	tenant := r.URL.Query().Get("tenant")
+	if tenant == "" {
+		tenant = auth.DefaultTenant
+	}
+
+	if h.scrubber != nil {
+		stored, ok := h.scrubber.StoredUserID(userID)
+		if !ok {
+			writeAdminError(w, http.StatusConflict, "User IDs are dropped before events are stored")
+			return
+		}
+		userID = stored
+	}
+
+	job, err := h.manager.Submit(tenant, userID)
+	if err != nil {
+		if errors.Is(err, erasure.ErrQueueFull) {
+			w.Header().Set("Retry-After", "60")
+		}
+		writeAdminError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Queued erasure job", "jobId", job.ID, "tenant", tenant)
+	if h.identities != nil {
+		if _, err := h.identities.Forget(tenant, userID); err != nil {
+			slog.ErrorContext(r.Context(), "Error forgetting identity links", "jobId", job.ID, "tenant", tenant, "error", err)
+		}
+	}
+	w.Header().Set("Location", "/api/v1/erasures/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// HandleGetJob returns the erasure job named in the path
+func (h *ErasureHandler) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.manager.Job(r.PathValue("id"))
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "Erasure job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/playererror"
+)
+
+// defaultErrorGroupsLimit is how many error groups are listed
+const defaultErrorGroupsLimit = 20
+
+// ErrorGroupsHandler serves the player errors of the caller's tenant,
+// grouped by fingerprint
+type ErrorGroupsHandler struct {
+	groups *playererror.Groups
+}
+
+func NewErrorGroupsHandler(groups *playererror.Groups) *ErrorGroupsHandler {
+	return &ErrorGroupsHandler{groups: groups}
+}
+
+// HandleListGroups returns the error groups that occurred the most over the
+// window query parameter, which defaults to the retention period, up to
+// the limit query parameter. The videoId, player and playerVersion query
+// parameters narrow the occurrences counted down.
+func (h *ErrorGroupsHandler) HandleListGroups(w http.ResponseWriter, r *http.Request) {
+	window, ok := queryWindow(w, r, h.groups.Retention())
+	if !ok {
+		return
+	}
+	limit, ok := queryLimit(w, r, defaultErrorGroupsLimit)
+	if !ok {
+		return
+	}
+
+	groups := h.groups.Top(auth.TenantFromContext(r.Context()), errorFilter(r), window, limit)
+	writeJSON(w, http.StatusOK, map[string]any{"errors": groups})
+}
+
+// HandleGetGroup returns the error group of the fingerprint in the path,
+// with every video and player version it occurred for, filtered like
+// HandleListGroups
+func (h *ErrorGroupsHandler) HandleGetGroup(w http.ResponseWriter, r *http.Request) {
+	window, ok := queryWindow(w, r, h.groups.Retention())
+	if !ok {
+		return
+	}
+
+	group, ok := h.groups.Get(auth.TenantFromContext(r.Context()), r.PathValue("fingerprint"), errorFilter(r), window)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{
+			"status":  "error",
+			"message": "Error group not found",
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, group)
+}
+
+func errorFilter(r *http.Request) playererror.Filter {
+	query := r.URL.Query()
+	return playererror.Filter{
+		VideoID:       query.Get("videoId"),
+		Player:        query.Get("player"),
+		PlayerVersion: query.Get("playerVersion"),
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/export"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/session"
+	"github.com/adtyap26/event-stream-video/pkg/utils"
+)
+
+// eventColumns are the columns of event exports
+var eventColumns = []string{
+	"timestamp", "receivedAt", "clientId", "batchId", "eventId", "eventName", "eventCategory", "videoId", "sessionId",
+	"userId", "anonymousId", "currentTime", "duration", "paused", "playbackRate", "volume", "muted",
+	"fullscreen", "bitrate", "bufferLength", "quality", "liveLatency", "userAgent", "screenResolution",
+	"connectionType", "streamType", "cdn", "edgePop", "pageUrl", "referrer", "country", "region", "city",
+	"deviceType", "os", "browser", "isBot", "botReason", "adId", "adCreativeId", "adPosition", "adQuartile",
+	"interactionElement", "interactionLabel", "interactionTargetUrl", "contentTitle", "contentDuration",
+	"contentCategory", "contentPublisher", "customData",
+}
+
+func eventRow(record models.EventRecord) []any {
+	row := []any{
+		record.Timestamp, record.ReceivedAt, record.ClientID, record.BatchID, record.EventID, record.EventName,
+		record.Category(), record.VideoID, record.SessionID, record.UserID, record.AnonymousID,
+	}
+	if p := record.PlaybackState; p != nil {
+		row = append(row, p.CurrentTime, p.Duration, p.Paused, p.PlaybackRate, p.Volume, p.Muted,
+			p.Fullscreen, omitZero(p.Bitrate), omitZero(p.BufferLength), p.Quality, omitZero(p.LiveLatency))
+	} else {
+		row = append(row, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	}
+	var t models.Technical
+	if record.Technical != nil {
+		t = *record.Technical
+	}
+	var c models.Context
+	var g models.Geo
+	var d models.Device
+	if record.Context != nil {
+		c = *record.Context
+		if c.Geo != nil {
+			g = *c.Geo
+		}
+		if c.Device != nil {
+			d = *c.Device
+		}
+	}
+	var ad models.AdState
+	if record.AdState != nil {
+		ad = *record.AdState
+	}
+	var quartile any
+	if ad.Quartile != 0 {
+		quartile = ad.Quartile
+	}
+	var interaction models.Interaction
+	if record.Interaction != nil {
+		interaction = *record.Interaction
+	}
+	var content models.Content
+	if record.Content != nil {
+		content = *record.Content
+	}
+	return append(row, t.UserAgent, t.ScreenResolution, t.ConnectionType, t.StreamType, t.CDN, t.EdgePOP,
+		c.PageURL, c.Referrer, g.Country, g.Region, g.City, d.Type, d.OS, d.Browser, record.IsBot, record.BotReason,
+		ad.AdID, ad.CreativeID, ad.Position, quartile,
+		interaction.Element, interaction.Label, interaction.TargetURL,
+		content.Title, omitZero(content.Duration), content.Category, content.Publisher, record.CustomData)
+}
+
+// sessionColumns are the columns of session exports, read from the
+// summaries the session tracker stores when sessions end
+var sessionColumns = []string{
+	"sessionId", "clientId", "videoId", "userId", "anonymousId", "cdn", "edgePop", "streamType", "startedAt", "lastEventAt",
+	"lastEvent", "eventCount", "watchTimeSeconds", "pauseCount", "seekCount", "startupTimeSeconds",
+	"rebufferCount", "rebufferTimeSeconds", "errorCount", "lastError", "playbackStarted", "ended",
+	"averageBitrate", "liveLatencySeconds", "adStarts", "adCompletions", "adErrors", "adTimeSeconds",
+}
+
+func sessionRow(s session.State) []any {
+	return []any{
+		s.SessionID, s.ClientID, s.VideoID, s.UserID, s.AnonymousID, s.CDN, s.EdgePOP, s.StreamType, s.StartedAt, s.LastEventAt,
+		s.LastEvent, s.EventCount, s.WatchTimeSeconds, s.PauseCount, s.SeekCount, omitZero(s.StartupTimeSeconds),
+		s.RebufferCount, s.RebufferTimeSeconds, s.ErrorCount, s.LastError, s.PlaybackStarted, s.Ended,
+		omitZero(s.AverageBitrate), omitZero(s.LiveLatencySeconds), s.Ads.Starts, s.Ads.Completions, s.Ads.Errors, s.Ads.TimeSeconds,
+	}
+}
+
+// omitZero leaves optional measurements that weren't reported empty
+func omitZero(v float64) any {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+// HandleExport streams the caller's stored events matching the same
+// filters as HandleQueryEvents as a file in the format query parameter,
+// csv (the default) or xlsx. With type=sessions it exports the summaries
+// of ended sessions instead, one row per session. Events are read from the
+// sink a page at a time, so exports of any size use little memory.
+func (h *QueryHandler) HandleExport(w http.ResponseWriter, r *http.Request) {
+	format, err := export.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"status": "error", "message": err.Error()})
+		return
+	}
+	query, ok := queryFilters(w, r)
+	if !ok {
+		return
+	}
+	query.Limit = maxQueryLimit
+
+	table := r.URL.Query().Get("type")
+	columns, row := eventColumns, func(record models.EventRecord) ([]any, bool) {
+		return eventRow(record), true
+	}
+	switch table {
+	case "", "events":
+		table = "events"
+	case "sessions":
+		query.EventName = session.SummaryEventName
+		columns, row = sessionColumns, func(record models.EventRecord) ([]any, bool) {
+			var state session.State
+			if err := json.Unmarshal([]byte(record.CustomData), &state); err != nil {
+				return nil, false
+			}
+			return sessionRow(state), true
+		}
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": "type must be events or sessions",
+		})
+		return
+	}
+
+	// The first page is read before anything is written, so a failing
+	// query still gets an error response
+	result, err := h.querier.QueryEvents(r.Context(), query)
+	if !writeQueryError(w, query, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`,
+		table, utils.FileTimestamp(time.Now()), format))
+	w.WriteHeader(http.StatusOK)
+
+	out, err := export.NewWriter(format, w, columns)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error writing export", "error", err)
+		return
+	}
+	rows := 0
+	for {
+		for _, record := range result.Events {
+			values, ok := row(record)
+			if !ok {
+				continue
+			}
+			if err := out.WriteRow(values...); err != nil {
+				slog.WarnContext(r.Context(), "Export aborted", "rows", rows, "error", err)
+				return
+			}
+			rows++
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		if err := out.Flush(); err != nil {
+			slog.WarnContext(r.Context(), "Export aborted", "rows", rows, "error", err)
+			return
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		query.Cursor = result.NextCursor
+		if result, err = h.querier.QueryEvents(r.Context(), query); err != nil {
+			// Too late for an error response; the file is left truncated
+			slog.ErrorContext(r.Context(), "Error querying events for export", "rows", rows, "error", err)
+			return
+		}
+	}
+	if err := out.Close(); err != nil {
+		slog.WarnContext(r.Context(), "Export aborted", "rows", rows, "error", err)
+	}
+}
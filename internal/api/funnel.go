@@ -0,0 +1,83 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/analytics"
+)
+
+const (
+	// defaultFunnelWindow is how far back funnels look without a from
+	// query parameter
+	defaultFunnelWindow = 24 * time.Hour
+	// maxFunnelEvents caps the events a funnel reads from the sink
+	maxFunnelEvents = 1_000_000
+)
+
+// HandleFunnel computes the conversion funnel of the steps query
+// parameter, a comma-separated list of event names and percentages of the
+// video watched (analytics.DefaultFunnelSteps by default), over the
+// caller's stored events matching the filters of HandleQueryEvents. The
+// time range defaults to the last 24 hours. A session must reach the steps
+// in order; truncated is set when the range held more events than are
+// read for one funnel.
+func (h *QueryHandler) HandleFunnel(w http.ResponseWriter, r *http.Request) {
+	steps := analytics.DefaultFunnelSteps
+	if param := r.URL.Query().Get("steps"); param != "" {
+		steps = strings.Split(param, ",")
+	}
+	funnel, err := analytics.NewFunnel(steps)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"status": "error", "message": err.Error()})
+		return
+	}
+	query, ok := queryFilters(w, r)
+	if !ok {
+		return
+	}
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-defaultFunnelWindow)
+	}
+	query.Limit = maxQueryLimit
+
+	read := 0
+	truncated := false
+	for {
+		result, err := h.querier.QueryEvents(r.Context(), query)
+		if !writeQueryError(w, query, err) {
+			return
+		}
+		for _, record := range result.Events {
+			funnel.Observe(record)
+		}
+		read += len(result.Events)
+		if result.NextCursor == "" {
+			break
+		}
+		if read >= maxFunnelEvents {
+			slog.WarnContext(r.Context(), "Funnel truncated", "tenant", query.Tenant, "events", read)
+			truncated = true
+			break
+		}
+		query.Cursor = result.NextCursor
+	}
+
+	response := map[string]any{
+		"from":  query.From,
+		"to":    query.To,
+		"steps": funnel.Steps(),
+	}
+	if query.VideoID != "" {
+		response["videoId"] = query.VideoID
+	}
+	if truncated {
+		response["truncated"] = true
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -1,84 +1,602 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
-	"github.com/adtyap26/event-stream-video/internal/logger"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/adtyap26/event-stream-video/internal/activity"
+	"github.com/adtyap26/event-stream-video/internal/anomaly"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/bots"
+	"github.com/adtyap26/event-stream-video/internal/checksum"
+	"github.com/adtyap26/event-stream-video/internal/dedup"
+	"github.com/adtyap26/event-stream-video/internal/dimensions"
+	"github.com/adtyap26/event-stream-video/internal/enrich"
+	"github.com/adtyap26/event-stream-video/internal/identity"
+	"github.com/adtyap26/event-stream-video/internal/logging"
+	"github.com/adtyap26/event-stream-video/internal/metadata"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
 	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/pipeline"
+	"github.com/adtyap26/event-stream-video/internal/playererror"
+	"github.com/adtyap26/event-stream-video/internal/quota"
+	"github.com/adtyap26/event-stream-video/internal/sampling"
+	"github.com/adtyap26/event-stream-video/internal/scrub"
+	"github.com/adtyap26/event-stream-video/internal/session"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/stream"
+	"github.com/adtyap26/event-stream-video/internal/taxonomy"
+	"github.com/adtyap26/event-stream-video/internal/timestamps"
+	"github.com/adtyap26/event-stream-video/internal/tracing"
+	"github.com/adtyap26/event-stream-video/internal/transform"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+	"github.com/adtyap26/event-stream-video/internal/webhook"
 )
 
 type EventHandler struct {
-	logger *logger.EventLogger
+	sink   sink.EventSink
+	limits validation.Limits
+	dedup  *dedup.Deduplicator
+	// eventDedup drops events whose eventId was already stored
+	eventDedup *dedup.Deduplicator
+	// ledger tells retried batches what of them was already stored
+	ledger    *dedup.Ledger
+	sessions  *session.Tracker
+	broker    *stream.Broker
+	enricher  *enrich.Enricher
+	sampler   *sampling.Sampler
+	scrubber  *scrub.Scrubber
+	bots      *bots.Filter
+	metadata  *metadata.Resolver
+	activity  *activity.Recorder
+	anomalies *anomaly.Detector
+	// errorGroups groups the classified errors of stored events
+	errorGroups *playererror.Groups
+	webhooks    *webhook.Dispatcher
+	// quotas counts the events stored for each API client
+	quotas *quota.Tracker
+	// taxonomy rejects or quarantines events of unknown names or without
+	// the fields their name requires
+	taxonomy *taxonomy.Taxonomy
+	// dimensions rejects events whose customData doesn't match their
+	// tenant's custom dimensions
+	dimensions *dimensions.Registry
+	// transforms reshape events by the transformation rules of their tenant
+	transforms *transform.Engine
+	// identities links the anonymous and user IDs of stored events
+	identities *identity.Graph
+
+	// pipeline processes batches before they reach the sink
+	pipeline *pipeline.Pipeline
+
+	// timestamps normalizes client timestamps at ingest
+	timestamps timestamps.Normalizer
+	now        func() time.Time
+
+	// maxFrameSize limits WebSocket frames
+	maxFrameSize int64
 }
 
-func NewEventHandler(logger *logger.EventLogger) *EventHandler {
-	return &EventHandler{
-		logger: logger,
+func NewEventHandler(eventSink sink.EventSink, limits validation.Limits) *EventHandler {
+	h := &EventHandler{
+		sink:   eventSink,
+		limits: limits,
+		now:    time.Now,
 	}
+	h.pipeline = h.newPipeline(pipeline.DefaultOrder())
+	return h
 }
 
+// errDuplicateBatch is returned by ingest for batches that were already stored
+var errDuplicateBatch = errors.New("duplicate batch")
+
+// checksumMismatchCode is the error code of batches whose events don't
+// match their checksum. They were corrupted on the way and, unlike other
+// invalid batches, are worth sending again.
+const checksumMismatchCode = "checksum_mismatch"
+
 func (h *EventHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var batch models.EventBatch
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&batch); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	batch, err := decodeBatch(r)
+	if err != nil {
+		h.recordError(r.Context(), batch, err)
+		writeBodyError(w, err)
 		return
-
 	}
 
-	if err := h.logger.LogBatch(batch); err != nil {
-		log.Printf("Error logging batch: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	ctx := batchContext(r.Context(), batch)
+	result, err := h.ingest(ctx, batch)
+	if err != nil {
+		// Replays of a batch we already stored are acknowledged but not logged again
+		if errors.Is(err, errDuplicateBatch) {
+			slog.InfoContext(ctx, "Ignoring duplicate batch", "retry", batch.IsRetry)
+			ack := ingestAck{
+				Status:    "success",
+				Message:   "Duplicate batch ignored",
+				BatchID:   batch.BatchID,
+				Rejected:  []validation.Rejection{},
+				Duplicate: true,
+			}
+			if batch.IsRetry {
+				// The retried batch was fully stored by an earlier attempt
+				ack.Status = "duplicate"
+				ack.Message = "Batch was already stored"
+			}
+			writeJSON(w, http.StatusOK, ack)
+			return
+		}
+		writeIngestError(ctx, w, batch.BatchID, err)
 		return
 	}
 
-	// Log to console
-	log.Printf("Received batch with %d events from client %s (Session: %s)",
-		len(batch.Events), batch.ClientID, batch.SessionID)
+	slog.DebugContext(ctx, "Received batch", "events", result.accepted, "rejected", len(result.rejected),
+		"latency", result.latency.total())
 
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]any{
-		"status":  "success",
-		"message": fmt.Sprintf("Processed %d events", len(batch.Events)),
-	})
+	result.latency.setHeaders(w)
+	ack := ingestAck{
+		Status:          "success",
+		Message:         fmt.Sprintf("Accepted %d events", result.accepted),
+		BatchID:         batch.BatchID,
+		Accepted:        result.accepted,
+		Rejected:        result.rejected,
+		DuplicateEvents: result.duplicates,
+		Quarantined:     result.quarantined,
+	}
+	if len(result.rejected) > 0 {
+		ack.Status = "partial"
+		ack.Message = fmt.Sprintf("Accepted %d of %d events", result.accepted, len(batch.Events))
+	} else {
+		ack.Rejected = []validation.Rejection{}
+	}
+	writeJSON(w, http.StatusOK, ack)
+}
+
+// ingestAck is the response to a batch POSTed to /api/v1/events. Rejected
+// lists the invalid events that were left out; the client should not send
+// them again. DuplicateEvents counts the accepted events that were already
+// stored under their eventId, and Quarantined those set aside because they
+// are outside the event taxonomy.
+type ingestAck struct {
+	Status          string                 `json:"status"`
+	Message         string                 `json:"message"`
+	BatchID         string                 `json:"batchId"`
+	Accepted        int                    `json:"accepted"`
+	Rejected        []validation.Rejection `json:"rejected"`
+	Duplicate       bool                   `json:"duplicate,omitempty"`
+	DuplicateEvents int                    `json:"duplicateEvents,omitempty"`
+	Quarantined     int                    `json:"quarantined,omitempty"`
 }
 
-// HandleBeacons processes beacon event batches (no response)
+// HandleBeacons processes beacon event batches (no response). GET beacons,
+// sent as image requests, are answered with a 1x1 GIF.
 func (h *EventHandler) HandleBeacons(w http.ResponseWriter, r *http.Request) {
-	// Only accept POST requests
-	if r.Method != http.MethodPost {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	accepted := func() {
+		if r.Method == http.MethodGet {
+			writePixel(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
 
 	// Parse the request body
-	var batch models.EventBatch
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&batch); err != nil {
-		log.Printf("Error decoding beacon: %v", err)
+	batch, err := decodeBatch(r)
+	if err != nil {
+		h.recordError(r.Context(), batch, err)
+		slog.WarnContext(r.Context(), "Error decoding beacon", "error", err)
 		return
 	}
 
-	// Log the batch
-	if err := h.logger.LogBatch(batch); err != nil {
-		log.Printf("Error logging beacon batch: %v", err)
+	ctx := batchContext(r.Context(), batch)
+	result, err := h.ingest(ctx, batch)
+	if err != nil {
+		if errors.Is(err, errDuplicateBatch) {
+			slog.InfoContext(ctx, "Ignoring duplicate beacon")
+			accepted()
+			return
+		}
+		slog.WarnContext(ctx, "Dropping beacon", "error", err)
+		writeIngestError(ctx, w, batch.BatchID, err)
 		return
 	}
 
-	// Log to console
-	log.Printf("Received beacon with %d events from client %s (Session: %s)",
-		len(batch.Events), batch.ClientID, batch.SessionID)
+	slog.DebugContext(ctx, "Received beacon", "events", result.accepted, "rejected", len(result.rejected))
 
 	// Return 204 No Content for beacons
-	w.WriteHeader(http.StatusNoContent)
+	accepted()
+}
+
+// batchContext adds the batch identifiers to the log fields of ctx and to
+// the attributes of its span
+func batchContext(ctx context.Context, batch models.EventBatch) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(
+		tracing.AttrClientID.String(batch.ClientID),
+		tracing.AttrBatchID.String(batch.BatchID),
+		tracing.AttrEvents.Int(len(batch.Events)),
+	)
+	return logging.With(ctx, "clientId", batch.ClientID, "sessionId", batch.SessionID, "batchId", batch.BatchID)
+}
+
+// ingestResult is what ingest made of a batch
+type ingestResult struct {
+	// accepted counts the events the client need not send again, including
+	// those sampled out
+	accepted int
+	// rejected are the invalid events left out of the batch
+	rejected []validation.Rejection
+	// duplicates counts the events left out because their eventId was
+	// already stored; they are accepted
+	duplicates int
+	// quarantined counts the events outside the taxonomy that were set
+	// aside instead of stored; they are accepted
+	quarantined int
+	// latency is how long the batch took to get through each stage
+	latency batchLatency
+	// ledgered are the event IDs the dedup processor records in the batch
+	// ledger once the batch is stored
+	ledgered []string
+}
+
+type resultKey struct{}
+
+// resultFromContext returns the ingestResult the validate processor reports
+// rejected events in, or nil outside of ingest
+func resultFromContext(ctx context.Context) *ingestResult {
+	result, _ := ctx.Value(resultKey{}).(*ingestResult)
+	return result
+}
+
+// ingest runs a decoded batch through the processing pipeline and stores
+// what is left of it in the sink. It is shared by every transport. Invalid
+// events are left out and reported in the result, unless the whole batch
+// is invalid.
+//
+// Rejection indexes refer to the batch as sent as long as no processor
+// before validate drops events.
+func (h *EventHandler) ingest(ctx context.Context, batch models.EventBatch) (ingestResult, error) {
+	batch.Tenant = auth.TenantFromContext(ctx)
+	batch.AssignEventIDs()
+	trace.SpanFromContext(ctx).SetAttributes(tracing.AttrTenant.String(batch.Tenant))
+
+	result := &ingestResult{}
+	ctx = context.WithValue(ctx, resultKey{}, result)
+	sent := len(batch.Events)
+	started := time.Now()
+	result.latency.receive = started.Sub(receivedFromContext(ctx, started))
+
+	stored := 0
+	err := h.pipeline.Run(ctx, &batch, func(batch models.EventBatch) error {
+		queued := time.Now()
+		result.latency.queue = queued.Sub(started)
+		if len(batch.Events) == 0 {
+			return nil
+		}
+		_, span := tracing.Start(ctx, "sink.write", tracing.AttrEvents.Int(len(batch.Events)))
+		err := h.sink.LogBatch(ctx, batch)
+		tracing.End(span, err)
+		if err != nil {
+			return &sinkError{err: err, requestID: RequestIDFromContext(ctx)}
+		}
+		result.latency.sink = time.Since(queued)
+		stored = len(batch.Events)
+		people := withoutBots(batch)
+		if h.activity != nil {
+			h.activity.Record(people)
+		}
+		if h.anomalies != nil {
+			h.anomalies.Observe(people)
+		}
+		if h.errorGroups != nil {
+			h.errorGroups.Record(people)
+		}
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errDuplicateBatch) {
+			h.recordError(ctx, batch, err)
+		}
+		return ingestResult{}, err
+	}
+	if len(result.rejected) > 0 {
+		first := result.rejected[0]
+		h.recordError(ctx, batch, fmt.Errorf("rejected %d of %d events, first at index %d: %s",
+			len(result.rejected), sent, first.Index, strings.Join(first.Reasons, ", ")))
+	}
+	metrics.BatchesReceived.WithLabelValues(batch.Tenant).Inc()
+	metrics.EventsReceived.WithLabelValues(batch.Tenant).Add(float64(stored))
+	metrics.EventsRejected.WithLabelValues(batch.Tenant).Add(float64(len(result.rejected)))
+	metrics.EventsDuplicate.WithLabelValues(batch.Tenant).Add(float64(result.duplicates))
+	if stored > 0 {
+		result.latency.observe()
+	}
+	h.countUsage(ctx, stored)
+
+	result.accepted = sent - len(result.rejected)
+	return *result, nil
+}
+
+// quarantine sets the events of batch that are outside the taxonomy aside
+// and reports them in the ingestResult of ctx. When the quarantine can't be
+// written the events are stored as they are rather than lost.
+func (h *EventHandler) quarantine(ctx context.Context, batch *models.EventBatch) {
+	quarantined, err := h.taxonomy.Quarantine(batch)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error quarantining events", "error", err)
+		return
+	}
+	if quarantined == 0 {
+		return
+	}
+	slog.InfoContext(ctx, "Quarantined events outside the taxonomy", "events", quarantined)
+	if result := resultFromContext(ctx); result != nil {
+		result.quarantined += quarantined
+	}
+}
+
+// countUsage adds the events stored for the API client of ctx to its daily
+// usage, warning once the client goes past its soft quota
+func (h *EventHandler) countUsage(ctx context.Context, stored int) {
+	if h.quotas == nil {
+		return
+	}
+	client, ok := auth.ClientFromContext(ctx)
+	if !ok {
+		return
+	}
+	status, warn := h.quotas.Add(quotaClient(client), int64(stored))
+	if warn {
+		slog.WarnContext(ctx, "API client went past its soft event quota", "used", status.Used, "soft", status.Limit.Soft)
+		metrics.QuotaWarnings.WithLabelValues(auth.TenantFromContext(ctx)).Inc()
+	}
+}
+
+// sinkError wraps failures to store a batch, as opposed to problems with
+// the batch itself
+type sinkError struct {
+	err       error
+	requestID string
+}
+
+func (e *sinkError) Error() string {
+	if e.requestID != "" {
+		return fmt.Sprintf("failed to store batch (request %s): %v", e.requestID, e.err)
+	}
+	return fmt.Sprintf("failed to store batch: %v", e.err)
+}
+
+func (e *sinkError) Unwrap() error {
+	return e.err
+}
+
+// recordError keeps a batch that was rejected or could not be stored for
+// the dashboard. batch is empty when the request body couldn't be decoded.
+func (h *EventHandler) recordError(ctx context.Context, batch models.EventBatch, err error) {
+	var mismatch *checksum.MismatchError
+	if errors.As(err, &mismatch) {
+		metrics.ChecksumMismatches.WithLabelValues(auth.TenantFromContext(ctx)).Inc()
+	}
+	if h.activity == nil {
+		return
+	}
+	batch.Tenant = auth.TenantFromContext(ctx)
+	h.activity.RecordError(batch, err)
+}
+
+// isDuplicate reports whether the batch was already accepted and marks it as
+// seen otherwise. Dedup failures are logged and treated as new batches, so
+// a broken store never causes events to be dropped.
+func (h *EventHandler) isDuplicate(ctx context.Context, batch models.EventBatch) bool {
+	if h.dedup == nil {
+		return false
+	}
+
+	duplicate, err := h.dedup.CheckAndMark(dedup.BatchKey(batch.Tenant, batch.ClientID, batch.BatchID))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error recording batch for dedup", "error", err)
+	}
+	return duplicate
+}
+
+// forget unmarks a batch that could not be stored so a retry is accepted
+func (h *EventHandler) forget(ctx context.Context, batch models.EventBatch) {
+	if h.dedup == nil {
+		return
+	}
+	if err := h.dedup.Forget(dedup.BatchKey(batch.Tenant, batch.ClientID, batch.BatchID)); err != nil {
+		slog.ErrorContext(ctx, "Error forgetting batch", "error", err)
+	}
+}
+
+// dropDuplicateEvents leaves the events whose eventId was already seen out
+// of batch and marks the others as seen. It returns how many it dropped.
+// Like isDuplicate, a broken store lets events through.
+func (h *EventHandler) dropDuplicateEvents(ctx context.Context, batch *models.EventBatch) int {
+	if h.eventDedup == nil {
+		return 0
+	}
+
+	var kept []models.Event
+	for i, event := range batch.Events {
+		duplicate, err := h.eventDedup.CheckAndMark(dedup.EventKey(batch.Tenant, event.EventID))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error recording event for dedup", "eventId", event.EventID, "error", err)
+		}
+		if duplicate && kept == nil {
+			// A new slice, since the caller's batch shares the old one
+			kept = append(make([]models.Event, 0, len(batch.Events)), batch.Events[:i]...)
+		}
+		if !duplicate && kept != nil {
+			kept = append(kept, event)
+		}
+	}
+	if kept == nil {
+		return 0
+	}
+	dropped := len(batch.Events) - len(kept)
+	batch.Events = kept
+	return dropped
+}
+
+// forgetEvents unmarks the events of a batch that could not be stored
+func (h *EventHandler) forgetEvents(ctx context.Context, batch models.EventBatch) {
+	if h.eventDedup == nil {
+		return
+	}
+	for _, event := range batch.Events {
+		if err := h.eventDedup.Forget(dedup.EventKey(batch.Tenant, event.EventID)); err != nil {
+			slog.ErrorContext(ctx, "Error forgetting event", "eventId", event.EventID, "error", err)
+		}
+	}
+}
+
+// retriesFromLedger reports whether batch is a retry checked against the
+// batch ledger rather than the batch deduplicator
+func (h *EventHandler) retriesFromLedger(batch models.EventBatch) bool {
+	return h.ledger != nil && batch.IsRetry && batch.BatchID != ""
+}
+
+// claimRetry checks a retried batch against the ledger. It reports whether
+// every event was already stored, and otherwise leaves the stored events
+// out of batch, returning how many it dropped. The events left are claimed
+// and returned in claimed, to be committed or released when the batch
+// ends.
+// A broken shared ledger lets the whole batch through, like isDuplicate.
+func (h *EventHandler) claimRetry(ctx context.Context, batch *models.EventBatch) (duplicate bool, dropped int, claimed []string) {
+	ids := eventIDs(batch.Events)
+	seen, err := h.ledger.Claim(dedup.BatchKey(batch.Tenant, batch.ClientID, batch.BatchID), ids)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking batch against ledger", "error", err)
+		return false, 0, ids
+	}
+	if len(ids) > 0 && !slices.Contains(seen, false) {
+		return true, 0, nil
+	}
+
+	// A new slice, since the caller's batch shares the old one
+	kept := make([]models.Event, 0, len(batch.Events))
+	for i, event := range batch.Events {
+		if seen[i] {
+			continue
+		}
+		kept = append(kept, event)
+		claimed = append(claimed, ids[i])
+	}
+	dropped = len(batch.Events) - len(kept)
+	if dropped > 0 {
+		batch.Events = kept
+	}
+	return false, dropped, claimed
+}
+
+// commitLedger records the events of a stored batch in the ledger. Like
+// isDuplicate, a broken store is logged and otherwise ignored.
+func (h *EventHandler) commitLedger(ctx context.Context, batch models.EventBatch, ids []string) {
+	if err := h.ledger.Commit(dedup.BatchKey(batch.Tenant, batch.ClientID, batch.BatchID), ids); err != nil {
+		slog.ErrorContext(ctx, "Error recording batch in ledger", "error", err)
+	}
+}
+
+func eventIDs(events []models.Event) []string {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.EventID
+	}
+	return ids
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeBodyError reports a request body that could not be read or decoded
+func writeBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{
+			"status":  "error",
+			"message": "Request body too large",
+			"limit":   maxBytesErr.Limit,
+		})
+		return
+	}
+	var mismatch *checksum.MismatchError
+	if errors.As(err, &mismatch) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"code":    checksumMismatchCode,
+			"message": "The events don't match the batch checksum, send the batch again",
+		})
+		return
+	}
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
+
+// writeIngestError maps an error from ingest to an HTTP response
+func writeIngestError(ctx context.Context, w http.ResponseWriter, batchID string, err error) {
+	var tooMany *validation.TooManyEventsError
+	var sinkErr *sinkError
+	switch {
+	case ctx.Err() != nil:
+		// The client went away or the server gave up draining it; the batch
+		// may be sent again
+		slog.WarnContext(ctx, "Request ended before the batch was stored", "error", err)
+		http.Error(w, "Request cancelled", http.StatusServiceUnavailable)
+	case errors.As(err, &tooMany):
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]any{
+			"status":  "error",
+			"message": err.Error(),
+			"batchId": batchID,
+		})
+	case errors.Is(err, sink.ErrUnavailable):
+		slog.WarnContext(ctx, "Sink refused batch for now", "error", err)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service unavailable, send the batch again", http.StatusServiceUnavailable)
+	case errors.As(err, &sinkErr):
+		slog.ErrorContext(ctx, "Error logging batch", "error", sinkErr.err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	default:
+		writeValidationError(w, batchID, err)
+	}
+}
+
+// writeValidationError reports which events of a batch failed validation
+// when none of it could be accepted
+func writeValidationError(w http.ResponseWriter, batchID string, err error) {
+	var validationErr *validation.Error
+	if !errors.As(err, &validationErr) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rejected, _ := validationErr.Rejections()
+	if rejected == nil {
+		rejected = []validation.Rejection{}
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+		"status":   "error",
+		"message":  "Batch failed validation",
+		"batchId":  batchID,
+		"accepted": 0,
+		"rejected": rejected,
+		"errors":   validationErr.Problems,
+	})
 }
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/deadletter"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink/clickhouse"
+)
+
+// TestFullClickHouseBuffer checks that batches reaching a full ClickHouse
+// buffer are refused with 503 for the client to retry, not dead-lettered
+func TestFullClickHouseBuffer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	events, err := clickhouse.New(clickhouse.Config{
+		URL:           server.URL,
+		BatchSize:     1000,
+		FlushInterval: time.Hour,
+		MaxPending:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+	// Fills the buffer until the next flush, an hour away
+	err = events.LogBatch(context.Background(), models.EventBatch{
+		ClientID: "web", BatchID: "b0", Events: []models.Event{{EventName: "play", SessionID: "s", VideoID: "v"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := deadletter.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := SetupRoutes(deadletter.Wrap(events, queue), WithStaticDir(t.TempDir()))
+
+	body := `{"batchId":"b1","clientId":"web","timestamp":"2026-10-14T00:00:00Z",` +
+		`"events":[{"eventName":"play","sessionId":"s","videoId":"v","timestamp":"2026-10-14T00:00:00Z"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/events", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, http.StatusServiceUnavailable, rec.Body)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("503 without Retry-After")
+	}
+	entries, err := queue.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d batches were dead-lettered, want none", len(entries))
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds how long all readiness checks may take together
+const readinessTimeout = 3 * time.Second
+
+// ReadinessCheck reports whether a dependency can currently serve requests
+type ReadinessCheck func(ctx context.Context) error
+
+// HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	checks map[string]ReadinessCheck
+}
+
+func NewHealthHandler(checks map[string]ReadinessCheck) *HealthHandler {
+	return &HealthHandler{checks: checks}
+}
+
+// HandleHealthz reports that the process is up. It never checks
+// dependencies, so a broken sink doesn't get the pod restarted.
+func (h *HealthHandler) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+// HandleReadyz runs every readiness check and answers 503 if any fails, so
+// load balancers stop sending traffic before writes start failing
+func (h *HealthHandler) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]string, len(h.checks))
+	ready := true
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				results[name] = err.Error()
+				ready = false
+				return
+			}
+			results[name] = "ok"
+		}()
+	}
+	wg.Wait()
+
+	if !ready {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+			"status": "unavailable",
+			"checks": results,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status": "ready",
+		"checks": results,
+	})
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/identity"
+	"github.com/adtyap26/event-stream-video/internal/scrub"
+)
+
+const (
+	// defaultIdentityLimit is how many links a listing returns without a
+	// limit query parameter, and maxIdentityLimit how many it may return
+	defaultIdentityLimit = 100
+	maxIdentityLimit     = 1000
+)
+
+// IdentityHandler serves the links between the anonymous and user IDs of
+// the caller's viewers, for joining the events they sent before logging in
+// with those they sent after
+type IdentityHandler struct {
+	graph *identity.Graph
+	// scrubber, if set, maps user IDs to how they are stored
+	scrubber *scrub.Scrubber
+}
+
+func NewIdentityHandler(graph *identity.Graph, scrubber *scrub.Scrubber) *IdentityHandler {
+	return &IdentityHandler{graph: graph, scrubber: scrubber}
+}
+
+// HandleGetIdentities returns the user the anonymousId query parameter is
+// linked to, or the anonymous IDs linked to the userId query parameter.
+// Without either it lists the links made since the since query parameter
+// (RFC 3339), oldest first, up to limit of them; truncated is set when more
+// were left out, which passing the last linkedAt as since fetches. User IDs
+// are answered as stored, i.e. hashed if the scrubber hashes them, so they
+// match the stored events.
+func (h *IdentityHandler) HandleGetIdentities(w http.ResponseWriter, r *http.Request) {
+	tenant := auth.TenantFromContext(r.Context())
+	query := r.URL.Query()
+	anonymousID, userID := query.Get("anonymousId"), query.Get("userId")
+
+	switch {
+	case anonymousID != "" && userID != "":
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": "Pass anonymousId or userId, not both",
+		})
+	case anonymousID != "":
+		link, ok := h.graph.UserOf(tenant, anonymousID)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]any{
+				"status":  "error",
+				"message": "Anonymous ID not linked to a user",
+			})
+			return
+		}
+		link.Tenant = ""
+		writeJSON(w, http.StatusOK, link)
+	case userID != "":
+		if h.scrubber != nil {
+			stored, ok := h.scrubber.StoredUserID(userID)
+			if !ok {
+				writeJSON(w, http.StatusConflict, map[string]any{
+					"status":  "error",
+					"message": "User IDs are dropped before events are stored",
+				})
+				return
+			}
+			userID = stored
+		}
+		links := h.graph.AnonymousOf(tenant, userID)
+		for i := range links {
+			links[i].Tenant = ""
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"userId": userID,
+			"links":  links,
+		})
+	default:
+		var since time.Time
+		if !queryTime(w, r, "since", &since) {
+			return
+		}
+		limit, ok := queryLimit(w, r, defaultIdentityLimit)
+		if !ok {
+			return
+		}
+		links, truncated := h.graph.List(tenant, since, min(limit, maxIdentityLimit))
+		for i := range links {
+			links[i].Tenant = ""
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"links":     links,
+			"truncated": truncated,
+		})
+	}
+}
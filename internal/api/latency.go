@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+)
+
+// ProcessingTimeHeader carries the milliseconds the collector spent on a
+// batch, from receiving the request to the sink accepting the events, so
+// SDKs can grow their batches while the collector keeps up and shrink them
+// when it slows down
+const ProcessingTimeHeader = "X-Processing-Time"
+
+// Ingestion stages, as labelled in the latency metric and Server-Timing
+const (
+	// stageReceive is reading and decoding the request body
+	stageReceive = "receive"
+	// stageQueue is the processing pipeline, until the sink is called
+	stageQueue = "queue"
+	// stageSink is the sink storing the batch, or queueing it for sinks
+	// that write in the background
+	stageSink  = "sink"
+	stageTotal = "total"
+)
+
+// batchLatency is how long a stored batch spent in each stage of ingestion
+type batchLatency struct {
+	receive time.Duration
+	queue   time.Duration
+	sink    time.Duration
+}
+
+func (l batchLatency) total() time.Duration {
+	return l.receive + l.queue + l.sink
+}
+
+// observe adds the batch's stages to the latency metric
+func (l batchLatency) observe() {
+	metrics.IngestLatency.WithLabelValues(stageReceive).Observe(l.receive.Seconds())
+	metrics.IngestLatency.WithLabelValues(stageQueue).Observe(l.queue.Seconds())
+	metrics.IngestLatency.WithLabelValues(stageSink).Observe(l.sink.Seconds())
+	metrics.IngestLatency.WithLabelValues(stageTotal).Observe(l.total().Seconds())
+}
+
+// setHeaders reports the latency in the ProcessingTimeHeader and, stage by
+// stage, in Server-Timing
+func (l batchLatency) setHeaders(w http.ResponseWriter) {
+	w.Header().Set(ProcessingTimeHeader, milliseconds(l.total()))
+	w.Header().Set("Server-Timing", fmt.Sprintf("%s;dur=%s, %s;dur=%s, %s;dur=%s, %s;dur=%s",
+		stageReceive, milliseconds(l.receive), stageQueue, milliseconds(l.queue),
+		stageSink, milliseconds(l.sink), stageTotal, milliseconds(l.total())))
+}
+
+// milliseconds formats d in milliseconds with microsecond precision
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64)
+}
+
+type receivedKey struct{}
+
+// withReceived returns a copy of ctx recording when its batch arrived
+func withReceived(ctx context.Context, at time.Time) context.Context {
+	return context.WithValue(ctx, receivedKey{}, at)
+}
+
+// receivedFromContext returns when the batch of ctx arrived, or fallback
+// when it wasn't recorded
+func receivedFromContext(ctx context.Context, fallback time.Time) time.Time {
+	if at, ok := ctx.Value(receivedKey{}).(time.Time); ok {
+		return at
+	}
+	return fallback
+}
+
+// receivedMiddleware records when each request arrived, before any other
+// middleware runs, for the receive stage of the latency metric
+func receivedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withReceived(r.Context(), time.Now())))
+	})
+}
@@ -1,28 +1,486 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/cors"
+	"github.com/adtyap26/event-stream-video/internal/logging"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/quota"
+	"github.com/adtyap26/event-stream-video/internal/ratelimit"
+	"github.com/adtyap26/event-stream-video/internal/signing"
+	"github.com/adtyap26/event-stream-video/internal/sink"
 )
 
-// CORSMiddleware adds CORS headers to responses
-func CORSMiddleware(next http.Handler) http.Handler {
+// CORSMiddleware applies policy to cross-origin requests. Preflights are
+// answered directly and requests from origins the policy rejects get 403.
+func CORSMiddleware(policy *cors.Policy, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Analytics-Client, X-Retry-Attempt")
+		switch policy.Apply(w, r) {
+		case cors.Preflight:
+			w.WriteHeader(http.StatusNoContent)
+		case cors.Forbidden:
+			writeJSON(w, http.StatusForbidden, map[string]any{
+				"status":  "error",
+				"message": "Origin not allowed",
+			})
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
 
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
+func LoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.DefaultServeMux.ServeHTTP(w, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AuthMiddleware rejects requests whose API key is not known to store and
+// attaches the resolved client to the request context. The key is taken
+// from the X-API-Key header, a Bearer token, the apiKey query parameter
+// (for WebSocket clients, which can't set headers) or the batch's apiKey field.
+func AuthMiddleware(store auth.KeyStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey, err := requestAPIKey(r)
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if apiKey == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
+		client, err := store.Lookup(r.Context(), apiKey)
+		if errors.Is(err, auth.ErrUnknownKey) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error looking up API key", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		ctx := auth.WithClient(r.Context(), client)
+		ctx = logging.With(ctx, "tenant", client.Tenant)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CertificateMiddleware attaches the client of a verified client
+// certificate whose common name certs maps to a tenant and passes the
+// request on to next without an API key. Other requests go to fallback.
+func CertificateMiddleware(certs *auth.Certificates, next, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := certs.Lookup(r.TLS)
+		if !ok {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		ctx := auth.WithClient(r.Context(), client)
+		ctx = logging.With(ctx, "tenant", client.Tenant)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// signatureCode is the error code of batches whose signature is missing or
+// doesn't match
+const signatureCode = "invalid_signature"
+
+// SignatureMiddleware rejects the requests of tenants with a signing secret
+// whose body doesn't match the signature in the X-Signature header or, for
+// beacons that can't set headers, the signature query parameter. It runs
+// after authentication to know the tenant. WebSocket frames can't be
+// signed, so these tenants can't ingest over WebSocket.
+func SignatureMiddleware(verifier *signing.Verifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := auth.TenantFromContext(r.Context())
+		if !verifier.Required(tenant) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			writeJSON(w, http.StatusForbidden, map[string]any{
+				"status":  "error",
+				"code":    signatureCode,
+				"message": "Batches of this tenant must be signed, send them by POST",
+			})
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		signature := r.Header.Get(signing.Header)
+		if signature == "" {
+			signature = r.URL.Query().Get("signature")
+		}
+		if err := verifier.Verify(tenant, body, signature); err != nil {
+			reason := "mismatch"
+			if errors.Is(err, signing.ErrMissing) {
+				reason = "missing"
+			}
+			metrics.SignatureRejected.WithLabelValues(tenant, reason).Inc()
+			writeJSON(w, http.StatusUnauthorized, map[string]any{
+				"status":  "error",
+				"code":    signatureCode,
+				"message": "Invalid batch signature: " + err.Error(),
+			})
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func LoggingMiddleware(next http.Handler) http.Handler {
+// requestAPIKey finds the API key for r. When it has to look inside the
+// body, the body is buffered and restored so handlers can still decode it.
+func requestAPIKey(r *http.Request) (string, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, nil
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer), nil
+	}
+	if key := r.URL.Query().Get("apiKey"); key != "" {
+		return key, nil
+	}
+	if r.Body == nil {
+		return "", nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if len(bytes.TrimSpace(body)) == 0 {
+		return "", nil
+	}
+	if isProtobuf(r) {
+		batch, err := decodeProtoBatch(bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		return batch.GetApiKey(), nil
+	}
+
+	var envelope struct {
+		APIKey string `json:"apiKey"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return "", err
+	}
+	return envelope.APIKey, nil
+}
+
+// DefaultMaxBodySize caps request bodies on endpoints without their own limit
+const DefaultMaxBodySize = 1 << 20
+
+// BodyLimitMiddleware rejects request bodies larger than maxSize bytes.
+// Handlers see an *http.MaxBytesError when reading past the limit.
+func BodyLimitMiddleware(maxSize int64, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.DefaultServeMux.ServeHTTP(w, r)
+		if r.ContentLength > maxSize {
+			writeBodyError(w, &http.MaxBytesError{Limit: maxSize})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// DefaultMaxDecompressedSize caps the size of a decompressed request body
+const DefaultMaxDecompressedSize = 10 << 20
+
+// DecompressMiddleware transparently decompresses gzip and deflate request
+// bodies, limiting the decompressed size to maxSize bytes. Other encodings
+// are rejected with 415.
+func DecompressMiddleware(maxSize int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+		var body io.ReadCloser
+		switch encoding {
+		case "", "identity":
+			next.ServeHTTP(w, r)
+			return
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "Invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			body = gz
+		case "deflate":
+			body = newDeflateReader(r.Body)
+		default:
+			http.Error(w, "Unsupported Content-Encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		r.Body = http.MaxBytesReader(w, body, maxSize)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newDeflateReader accepts both zlib-wrapped deflate, which is what the
+// HTTP spec means by "deflate", and the raw deflate some clients send
+func newDeflateReader(r io.Reader) io.ReadCloser {
+	buffered := bufio.NewReader(r)
+	if header, err := buffered.Peek(2); err == nil && isZlibHeader(header) {
+		if zr, err := zlib.NewReader(buffered); err == nil {
+			return zr
+		}
+	}
+	return flate.NewReader(buffered)
+}
+
+// isZlibHeader reports whether b starts a zlib stream (RFC 1950)
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// RateLimitMiddleware rejects requests with 429 once their bucket in
+// limiter is empty. Authenticated requests are limited per API client,
+// anonymous ones per remote IP, and tenants with a limit of their own are
+// also limited as a whole. route labels the throttling metric.
+func RateLimitMiddleware(limiter *ratelimit.Limiter, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := auth.TenantFromContext(r.Context())
+		keyType, key := "ip", "ip:"+clientIP(r)
+		if client, ok := auth.ClientFromContext(r.Context()); ok {
+			keyType, key = "key", "key:"+client.Tenant+"/"+client.ClientID
+		}
+
+		allowed, retryAfter := limiter.AllowTenant(tenant)
+		if !allowed {
+			keyType = "tenant"
+		} else {
+			allowed, retryAfter = limiter.Allow(key)
+		}
+		if !allowed {
+			metrics.RateLimited.WithLabelValues(route, tenant, keyType).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeJSON(w, http.StatusTooManyRequests, map[string]any{
+				"status":  "error",
+				"message": "Rate limit exceeded",
+			})
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// QuotaMiddleware rejects requests with 429 once the API client used up its
+// daily event quota, until the quota resets at midnight UTC. Clients past
+// their soft quota get an X-Quota-Warning header. Requests without an API
+// client are let through, since usage is counted per API key. route labels
+// the rejection metric.
+func QuotaMiddleware(tracker *quota.Tracker, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := auth.ClientFromContext(r.Context())
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		status := tracker.Check(quotaClient(client))
+		reset := strconv.Itoa(int(math.Ceil(time.Until(status.ResetsAt).Seconds())))
+		if status.Limit.Hard > 0 {
+			w.Header().Set("X-Quota-Limit", strconv.FormatInt(status.Limit.Hard, 10))
+			w.Header().Set("X-Quota-Remaining", strconv.FormatInt(status.Remaining(), 10))
+			w.Header().Set("X-Quota-Reset", reset)
+		}
+
+		switch status.State {
+		case quota.StateExceeded:
+			metrics.QuotaRejected.WithLabelValues(route, auth.TenantFromContext(r.Context())).Inc()
+			w.Header().Set("Retry-After", reset)
+			writeJSON(w, http.StatusTooManyRequests, map[string]any{
+				"status":  "error",
+				"message": "Daily event quota exceeded",
+			})
+			return
+		case quota.StateWarning:
+			w.Header().Set("X-Quota-Warning", fmt.Sprintf("%d of %d daily events used", status.Used, status.Limit.Soft))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// quotaClient is what the usage of an API client is counted under. Keys
+// without a tenant belong to auth.DefaultTenant.
+func quotaClient(client auth.Client) quota.Client {
+	return quota.Client{Tenant: cmp.Or(client.Tenant, auth.DefaultTenant), ClientID: client.ClientID}
+}
+
+// LoadShedMiddleware rejects requests with 503 while the sink's queue is at
+// least threshold full (0 to 1), telling clients to come back after
+// retryAfter. It runs before the body is read, so shed requests cost next
+// to no memory. route labels the shedding metric.
+func LoadShedMiddleware(backlogger sink.Backlogger, threshold float64, retryAfter time.Duration, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queued, capacity := backlogger.Backlog()
+		if capacity > 0 {
+			ratio := float64(queued) / float64(capacity)
+			metrics.SinkBacklog.Set(ratio)
+			if ratio >= threshold {
+				metrics.ShedRequests.WithLabelValues(route).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+					"status":  "error",
+					"message": "Server overloaded, retry later",
+				})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// TimeoutMiddleware sets the read and write deadlines of each request's
+// connection from the timeouts timeout returns for its path, and cancels
+// the request's context at the write deadline. Zero clears a deadline, so
+// long-lived streams don't inherit one from an earlier request on the
+// same connection.
+func TimeoutMiddleware(timeout func(path string) (read, write time.Duration), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		read, write := timeout(r.URL.Path)
+		now := time.Now()
+		rc := http.NewResponseController(w)
+
+		var readDeadline, writeDeadline time.Time
+		if read > 0 {
+			readDeadline = now.Add(read)
+		}
+		if write > 0 {
+			writeDeadline = now.Add(write)
+			ctx, cancel := context.WithDeadline(r.Context(), writeDeadline)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		// Writers that can't take deadlines, e.g. in tests, go without
+		rc.SetReadDeadline(readDeadline)
+		rc.SetWriteDeadline(writeDeadline)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the remote address of r without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type remoteKey struct{}
+
+// remote describes the client that sent a request
+type remote struct {
+	ip        string
+	userAgent string
+}
+
+// remoteMiddleware records the client address and user agent in the
+// request context for enrichment, which runs far from the request
+func remoteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), remoteKey{}, remote{ip: clientIP(r), userAgent: r.UserAgent()})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// remoteFromContext returns the client recorded by remoteMiddleware
+func remoteFromContext(ctx context.Context) remote {
+	client, _ := ctx.Value(remoteKey{}).(remote)
+	return client
+}
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds client-provided request IDs
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestIDMiddleware assigns every request an ID, echoes it in the
+// X-Request-ID response header and adds it to the request's log fields. A
+// well-formed ID sent by the client is reused so a batch can be traced from
+// the SDK to the server logs.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = logging.With(ctx, "requestId", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns the ID assigned by RequestIDMiddleware
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID accepts short IDs made of printable ASCII without spaces,
+// so client IDs can't inject anything into headers or logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
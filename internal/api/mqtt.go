@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/mqtt"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+	"github.com/adtyap26/event-stream-video/internal/tracing"
+)
+
+// HandleMQTT ingests one EventBatch JSON message the MQTT bridge received
+// for tenant. The broker authenticated the device, so the topic's tenant
+// stands in for an API key's. Devices get no acknowledgement: batches the
+// sink failed to store come back wrapping mqtt.ErrRetry, for the broker to
+// deliver again, and invalid ones are dropped.
+func (h *EventHandler) HandleMQTT(ctx context.Context, tenant string, payload []byte) error {
+	ctx = withReceived(ctx, time.Now())
+	ctx = auth.WithClient(ctx, auth.Client{Tenant: tenant})
+
+	batch, err := schema.DecodeBatch(payload)
+	if err != nil {
+		h.recordError(ctx, models.EventBatch{}, err)
+		return fmt.Errorf("invalid batch: %w", err)
+	}
+
+	ctx, span := tracing.Start(ctx, "mqtt.message")
+	defer span.End()
+
+	ctx = batchContext(ctx, batch)
+	result, err := h.ingest(ctx, batch)
+	var sinkErr *sinkError
+	switch {
+	case err == nil:
+		slog.DebugContext(ctx, "Received MQTT batch", "events", result.accepted, "rejected", len(result.rejected))
+		return nil
+	case errors.Is(err, errDuplicateBatch):
+		return nil
+	case errors.As(err, &sinkErr):
+		return fmt.Errorf("%w: %w", mqtt.ErrRetry, sinkErr)
+	default:
+		return err
+	}
+}
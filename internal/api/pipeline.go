@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/adtyap26/event-stream-video/internal/dedup"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/pipeline"
+	"github.com/adtyap26/event-stream-video/internal/taxonomy"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+)
+
+// newPipeline builds the processors named in names, in that order. The
+// processors read the handler's dependencies when they run, so a stage
+// whose component isn't configured passes batches through untouched.
+// Unknown names are logged and skipped.
+func (h *EventHandler) newPipeline(names []string) *pipeline.Pipeline {
+	stages := make([]pipeline.Stage, 0, len(names))
+	for _, name := range names {
+		processor := h.processor(name)
+		if processor == nil {
+			slog.Warn("Skipping unknown pipeline processor", "processor", name)
+			continue
+		}
+		stages = append(stages, pipeline.Stage{Name: name, Processor: processor})
+	}
+	return pipeline.New(stages...)
+}
+
+// processor returns the built-in processor called name, or nil
+func (h *EventHandler) processor(name string) pipeline.Processor {
+	switch name {
+	case pipeline.Transform:
+		return pipeline.ProcessorFunc(func(_ context.Context, batch *models.EventBatch) error {
+			if h.transforms != nil {
+				h.transforms.Transform(batch)
+			}
+			return nil
+		})
+	case pipeline.Timestamps:
+		return pipeline.ProcessorFunc(func(_ context.Context, batch *models.EventBatch) error {
+			h.timestamps.Normalize(batch, h.now())
+			return nil
+		})
+	case pipeline.Validate:
+		return pipeline.ProcessorFunc(func(ctx context.Context, batch *models.EventBatch) error {
+			if err := validation.CheckBatchSize(*batch, h.limits); err != nil {
+				return err
+			}
+			err := validation.ValidateBatch(*batch, h.limits)
+			if h.taxonomy != nil && h.taxonomy.Action() == taxonomy.Reject {
+				err = addProblems(err, h.taxonomy.Check(*batch))
+			}
+			if h.dimensions != nil {
+				err = addProblems(err, h.dimensions.Check(*batch))
+			}
+			if err := rejectInvalid(ctx, batch, err); err != nil {
+				return err
+			}
+			if h.taxonomy != nil && h.taxonomy.Action() == taxonomy.Quarantine {
+				h.quarantine(ctx, batch)
+			}
+			return nil
+		})
+	case pipeline.Dedup:
+		return dedupProcessor{h}
+	case pipeline.Enrich:
+		return pipeline.ProcessorFunc(func(ctx context.Context, batch *models.EventBatch) error {
+			if h.enricher != nil {
+				client := remoteFromContext(ctx)
+				h.enricher.Enrich(batch, client.ip, client.userAgent)
+			}
+			return nil
+		})
+	case pipeline.Bots:
+		return pipeline.ProcessorFunc(func(ctx context.Context, batch *models.EventBatch) error {
+			if h.bots != nil {
+				client := remoteFromContext(ctx)
+				h.bots.Filter(batch, client.ip, client.userAgent)
+			}
+			return nil
+		})
+	case pipeline.Metadata:
+		return pipeline.ProcessorFunc(func(ctx context.Context, batch *models.EventBatch) error {
+			if h.metadata != nil {
+				h.metadata.Enrich(ctx, batch)
+			}
+			return nil
+		})
+	case pipeline.Scrub:
+		return pipeline.ProcessorFunc(func(_ context.Context, batch *models.EventBatch) error {
+			if h.scrubber != nil {
+				h.scrubber.Scrub(batch)
+			}
+			return nil
+		})
+	case pipeline.Identities:
+		return identitiesProcessor{h}
+	case pipeline.Sessions:
+		return sessionsProcessor{h}
+	case pipeline.Sample:
+		return pipeline.ProcessorFunc(func(_ context.Context, batch *models.EventBatch) error {
+			*batch = h.sample(*batch)
+			return nil
+		})
+	case pipeline.Stream:
+		return streamProcessor{h}
+	case pipeline.Webhooks:
+		return webhooksProcessor{h}
+	}
+	return nil
+}
+
+// addProblems adds problems to the validation error err, which is nil for
+// a valid batch, keeping the problems in batch order
+func addProblems(err error, problems []validation.Problem) error {
+	if len(problems) == 0 {
+		return err
+	}
+	var validationErr *validation.Error
+	if err != nil && !errors.As(err, &validationErr) {
+		return err
+	}
+	if validationErr == nil {
+		return &validation.Error{Problems: problems}
+	}
+	all := append(slices.Clone(validationErr.Problems), problems...)
+	slices.SortStableFunc(all, func(a, b validation.Problem) int { return a.Index - b.Index })
+	return &validation.Error{Problems: all}
+}
+
+// rejectInvalid leaves the events with problems out of batch and reports
+// them in the ingestResult of ctx. The batch as a whole is rejected with err
+// when the batch itself has problems or no event is valid.
+func rejectInvalid(ctx context.Context, batch *models.EventBatch, err error) error {
+	var validationErr *validation.Error
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+	rejections, ok := validationErr.Rejections()
+	if !ok || len(rejections) == len(batch.Events) {
+		return err
+	}
+
+	rejected := make(map[int]bool, len(rejections))
+	for _, rejection := range rejections {
+		rejected[rejection.Index] = true
+	}
+	// A new slice, since the caller's batch shares the old one
+	kept := make([]models.Event, 0, len(batch.Events)-len(rejections))
+	for i, event := range batch.Events {
+		if !rejected[i] {
+			kept = append(kept, event)
+		}
+	}
+	batch.Events = kept
+
+	if result := resultFromContext(ctx); result != nil {
+		result.rejected = append(result.rejected, rejections...)
+	}
+	return nil
+}
+
+// dedupProcessor rejects replayed batches with errDuplicateBatch, leaves
+// out events whose eventId was already stored and unmarks batches that fail
+// later, so the client's retry is accepted. With a batch ledger, retried
+// batches are checked against it instead: they are only rejected once all
+// of their events were stored, and otherwise store the missing events.
+type dedupProcessor struct{ h *EventHandler }
+
+func (p dedupProcessor) Process(ctx context.Context, batch *models.EventBatch) error {
+	result := resultFromContext(ctx)
+	if p.h.retriesFromLedger(*batch) {
+		duplicate, dropped, claimed := p.h.claimRetry(ctx, batch)
+		if duplicate {
+			return errDuplicateBatch
+		}
+		if result != nil {
+			result.duplicates += dropped
+			result.ledgered = claimed
+		}
+	} else if p.h.isDuplicate(ctx, *batch) {
+		return errDuplicateBatch
+	} else if p.h.ledger != nil && batch.BatchID != "" && result != nil {
+		result.ledgered = eventIDs(batch.Events)
+	}
+
+	duplicates := p.h.dropDuplicateEvents(ctx, batch)
+	if result != nil {
+		result.duplicates += duplicates
+	}
+	return nil
+}
+
+func (p dedupProcessor) Finish(ctx context.Context, batch models.EventBatch, err error) {
+	var ledgered []string
+	if result := resultFromContext(ctx); result != nil {
+		ledgered = result.ledgered
+	}
+	if err != nil {
+		if p.h.retriesFromLedger(batch) {
+			if err := p.h.ledger.Release(dedup.BatchKey(batch.Tenant, batch.ClientID, batch.BatchID), ledgered); err != nil {
+				slog.ErrorContext(ctx, "Error releasing batch in ledger", "error", err)
+			}
+		} else {
+			p.h.forget(ctx, batch)
+		}
+		p.h.forgetEvents(ctx, batch)
+		return
+	}
+	if p.h.ledger != nil {
+		p.h.commitLedger(ctx, batch, ledgered)
+	}
+}
+
+// identitiesProcessor links the IDs of the events of stored batches that
+// carry both an anonymous and a user ID. Events flagged as bots' are left
+// out.
+type identitiesProcessor struct{ h *EventHandler }
+
+func (identitiesProcessor) Process(context.Context, *models.EventBatch) error {
+	return nil
+}
+
+func (p identitiesProcessor) Finish(ctx context.Context, batch models.EventBatch, err error) {
+	if err != nil || p.h.identities == nil {
+		return
+	}
+	if err := p.h.identities.Observe(withoutBots(batch)); err != nil {
+		slog.ErrorContext(ctx, "Error recording identity links", "error", err)
+	}
+}
+
+// sessionsProcessor feeds stored batches to the session tracker. It sees
+// the batch as it was when the processor ran, so placing it before sample
+// keeps sampling from skewing session metrics. Events flagged as bots' are
+// left out.
+type sessionsProcessor struct{ h *EventHandler }
+
+func (sessionsProcessor) Process(context.Context, *models.EventBatch) error {
+	return nil
+}
+
+func (p sessionsProcessor) Finish(_ context.Context, batch models.EventBatch, err error) {
+	if err == nil && p.h.sessions != nil {
+		p.h.sessions.Observe(withoutBots(batch))
+	}
+}
+
+// streamProcessor publishes stored events to the live feed
+type streamProcessor struct{ h *EventHandler }
+
+func (streamProcessor) Process(context.Context, *models.EventBatch) error {
+	return nil
+}
+
+func (p streamProcessor) Finish(_ context.Context, batch models.EventBatch, err error) {
+	if err == nil && p.h.broker != nil && len(batch.Events) > 0 {
+		p.h.broker.Publish(batch)
+	}
+}
+
+// webhooksProcessor hands stored events to the webhook dispatcher
+type webhooksProcessor struct{ h *EventHandler }
+
+func (webhooksProcessor) Process(context.Context, *models.EventBatch) error {
+	return nil
+}
+
+func (p webhooksProcessor) Finish(_ context.Context, batch models.EventBatch, err error) {
+	if err == nil && p.h.webhooks != nil && len(batch.Events) > 0 {
+		p.h.webhooks.Dispatch(batch)
+	}
+}
+
+// withoutBots returns batch without the events flagged as bots', which are
+// stored but kept out of the session, QoE and dashboard metrics
+func withoutBots(batch models.EventBatch) models.EventBatch {
+	if !slices.ContainsFunc(batch.Events, func(e models.Event) bool { return e.IsBot }) {
+		return batch
+	}
+	batch.Events = slices.DeleteFunc(slices.Clone(batch.Events), func(e models.Event) bool { return e.IsBot })
+	return batch
+}
+
+// sample applies the sampling rules, returning the part of the batch to store
+func (h *EventHandler) sample(batch models.EventBatch) models.EventBatch {
+	if h.sampler == nil {
+		return batch
+	}
+	stored, dropped := h.sampler.Sample(batch)
+	for _, d := range dropped {
+		metrics.SampledOut.WithLabelValues(batch.Tenant, d.EventName).Add(float64(d.Count))
+	}
+	return stored
+}
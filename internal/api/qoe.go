@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/analytics"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// defaultCDNWindow is the window CDNs are compared over by default
+const defaultCDNWindow = time.Hour
+
+// QoEHandler serves quality of experience metrics of sessions, videos and
+// CDNs
+type QoEHandler struct {
+	tracker    *session.Tracker
+	aggregator *analytics.Aggregator
+}
+
+func NewQoEHandler(tracker *session.Tracker, aggregator *analytics.Aggregator) *QoEHandler {
+	return &QoEHandler{tracker: tracker, aggregator: aggregator}
+}
+
+// HandleGetSession returns the QoE so far of the live session named in the path
+func (h *QoEHandler) HandleGetSession(w http.ResponseWriter, r *http.Request) {
+	state, ok := h.tracker.Get(auth.TenantFromContext(r.Context()), r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{
+			"status":  "error",
+			"message": "Session not found",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, analytics.ForSession(state, false))
+}
+
+// HandleGetVideo returns the QoE of the ended sessions of the video named in
+// the path
+func (h *QoEHandler) HandleGetVideo(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.aggregator.Video(auth.TenantFromContext(r.Context()), r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{
+			"status":  "error",
+			"message": "No ended sessions for video",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, video)
+}
+
+// HandleListCDNs compares the QoE of the CDNs that delivered the caller's
+// sessions, ended or not, over the window query parameter (default 1h).
+// With by=pop each edge POP of a CDN is reported on its own.
+func (h *QoEHandler) HandleListCDNs(w http.ResponseWriter, r *http.Request) {
+	window, ok := queryWindow(w, r, defaultCDNWindow)
+	if !ok {
+		return
+	}
+	var byPOP bool
+	switch r.URL.Query().Get("by") {
+	case "", "cdn":
+	case "pop":
+		byPOP = true
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": "by must be cdn or pop",
+		})
+		return
+	}
+
+	cdns := h.aggregator.CDNs(auth.TenantFromContext(r.Context()), window, byPOP, h.tracker.Active())
+	writeJSON(w, http.StatusOK, map[string]any{"cdns": cdns})
+}
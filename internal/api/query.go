@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+// maxQueryLimit caps the limit query parameter of event queries
+const maxQueryLimit = 1000
+
+// QueryHandler searches the events stored by the sink
+type QueryHandler struct {
+	querier sink.Querier
+}
+
+func NewQueryHandler(querier sink.Querier) *QueryHandler {
+	return &QueryHandler{querier: querier}
+}
+
+// HandleQueryEvents returns the caller's stored events matching the from
+// and to (RFC 3339), sessionId, videoId, eventName, eventCategory and
+// clientId query parameters, oldest first. Up to limit events are returned; nextCursor,
+// passed back as cursor, fetches the next page.
+func (h *QueryHandler) HandleQueryEvents(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r, sink.DefaultQueryLimit)
+	if !ok {
+		return
+	}
+	query, ok := queryFilters(w, r)
+	if !ok {
+		return
+	}
+	query.Limit = min(limit, maxQueryLimit)
+	query.Cursor = r.URL.Query().Get("cursor")
+
+	result, err := h.querier.QueryEvents(r.Context(), query)
+	if !writeQueryError(w, query, err) {
+		return
+	}
+
+	response := map[string]any{"events": result.Events}
+	if result.NextCursor != "" {
+		response["nextCursor"] = result.NextCursor
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// queryFilters reads the caller's tenant and the filter query parameters,
+// writing a 400 response and returning false when one is invalid
+func queryFilters(w http.ResponseWriter, r *http.Request) (sink.Query, bool) {
+	params := r.URL.Query()
+	query := sink.Query{
+		Tenant:    auth.TenantFromContext(r.Context()),
+		SessionID: params.Get("sessionId"),
+		VideoID:   params.Get("videoId"),
+		EventName: params.Get("eventName"),
+		ClientID:  params.Get("clientId"),
+
+		EventCategory: params.Get("eventCategory"),
+	}
+	if !queryTime(w, r, "from", &query.From) || !queryTime(w, r, "to", &query.To) {
+		return sink.Query{}, false
+	}
+	return query, true
+}
+
+// writeQueryError writes the response for a failed query and returns false,
+// or returns true when err is nil
+func writeQueryError(w http.ResponseWriter, query sink.Query, err error) bool {
+	switch {
+	case errors.Is(err, sink.ErrInvalidCursor):
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": "cursor must be the nextCursor of a previous response",
+		})
+	case errors.Is(err, sink.ErrQueryUnsupported):
+		writeJSON(w, http.StatusNotImplemented, map[string]any{
+			"status":  "error",
+			"message": "The configured sink can't be queried",
+		})
+	case err != nil:
+		slog.Error("Error querying events", "tenant", query.Tenant, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]any{
+			"status":  "error",
+			"message": "Failed to query events",
+		})
+	default:
+		return true
+	}
+	return false
+}
+
+// queryTime parses the named query parameter as an RFC 3339 time into t,
+// writing a 400 response and returning false when it is invalid
+func queryTime(w http.ResponseWriter, r *http.Request, name string, t *time.Time) bool {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return true
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, param)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": name + " must be an RFC 3339 time such as 2024-05-01T12:00:00Z",
+		})
+		return false
+	}
+	*t = parsed
+	return true
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/enrich"
+	"github.com/adtyap26/event-stream-video/internal/regions"
+)
+
+// RegionHandler tells players which regional collector to send to
+type RegionHandler struct {
+	router *regions.Router
+	// enricher locates clients; without GeoIP every client gets the
+	// default region
+	enricher *enrich.Enricher
+}
+
+// NewRegionHandler creates a RegionHandler routing with router
+func NewRegionHandler(router *regions.Router, enricher *enrich.Enricher) *RegionHandler {
+	return &RegionHandler{router: router, enricher: enricher}
+}
+
+// HandleConfig answers GET /api/v1/config with the collector nearest to
+// the client. The hint depends on the client's address, so only the
+// client may cache it.
+func (h *RegionHandler) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	var country string
+	if h.enricher != nil {
+		country = h.enricher.Country(clientIP(r))
+	}
+	hint := h.router.Route(country)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", hint.TTL))
+	writeJSON(w, http.StatusOK, hint)
+}
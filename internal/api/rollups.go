@@ -0,0 +1,86 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/rollup"
+)
+
+// defaultRollupWindow is how far back rollups are read without a from
+// query parameter
+const defaultRollupWindow = 24 * time.Hour
+
+// RollupHandler serves the metric rollups of the caller's videos
+type RollupHandler struct {
+	engine *rollup.Engine
+}
+
+func NewRollupHandler(engine *rollup.Engine) *RollupHandler {
+	return &RollupHandler{engine: engine}
+}
+
+// HandleGetRollups returns the plays, watch time and rebuffering of the
+// videoId query parameter, or of all videos, per bucket between from and
+// to (RFC 3339, the last 24 hours by default). The resolution query
+// parameter picks the 1m, 1h or 1d tier; by default the finest tier still
+// kept at from that fits the range in 1500 buckets is read.
+func (h *RollupHandler) HandleGetRollups(w http.ResponseWriter, r *http.Request) {
+	query := rollup.Query{
+		Tenant:  auth.TenantFromContext(r.Context()),
+		VideoID: r.URL.Query().Get("videoId"),
+	}
+	if !queryTime(w, r, "from", &query.From) || !queryTime(w, r, "to", &query.To) {
+		return
+	}
+	if query.To.IsZero() {
+		query.To = time.Now()
+	}
+	if query.From.IsZero() {
+		query.From = query.To.Add(-defaultRollupWindow)
+	}
+	if !query.From.Before(query.To) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": "from must be before to",
+		})
+		return
+	}
+
+	query.Tier = r.URL.Query().Get("resolution")
+	switch query.Tier {
+	case "", "auto":
+		query.Tier = h.engine.TierFor(query.From, query.To)
+	case rollup.Minute, rollup.Hour, rollup.Day:
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]any{
+			"status":  "error",
+			"message": "resolution must be 1m, 1h, 1d or auto",
+		})
+		return
+	}
+
+	points, err := h.engine.Query(r.Context(), query)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to query rollups", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]any{
+			"status":  "error",
+			"message": "Failed to query rollups",
+		})
+		return
+	}
+
+	response := map[string]any{
+		"resolution": query.Tier,
+		"from":       query.From,
+		"to":         query.To,
+		"total":      rollup.Total(points),
+		"points":     points,
+	}
+	if query.VideoID != "" {
+		response["videoId"] = query.VideoID
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -2,25 +2,854 @@ package api
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
-	"github.com/adtyap26/event-stream-video/internal/logger"
+	"github.com/adtyap26/event-stream-video/internal/activity"
+	"github.com/adtyap26/event-stream-video/internal/analytics"
+	"github.com/adtyap26/event-stream-video/internal/anomaly"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/bots"
+	"github.com/adtyap26/event-stream-video/internal/cache"
+	"github.com/adtyap26/event-stream-video/internal/cors"
+	"github.com/adtyap26/event-stream-video/internal/dashboard"
+	"github.com/adtyap26/event-stream-video/internal/dedup"
+	"github.com/adtyap26/event-stream-video/internal/dimensions"
+	"github.com/adtyap26/event-stream-video/internal/enrich"
+	"github.com/adtyap26/event-stream-video/internal/erasure"
+	"github.com/adtyap26/event-stream-video/internal/identity"
+	"github.com/adtyap26/event-stream-video/internal/metadata"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/mqtt"
+	"github.com/adtyap26/event-stream-video/internal/playererror"
+	"github.com/adtyap26/event-stream-video/internal/quota"
+	"github.com/adtyap26/event-stream-video/internal/ratelimit"
+	"github.com/adtyap26/event-stream-video/internal/regions"
+	"github.com/adtyap26/event-stream-video/internal/rollup"
+	"github.com/adtyap26/event-stream-video/internal/sampling"
+	"github.com/adtyap26/event-stream-video/internal/scrub"
+	"github.com/adtyap26/event-stream-video/internal/sdkconfig"
+	"github.com/adtyap26/event-stream-video/internal/session"
+	"github.com/adtyap26/event-stream-video/internal/signing"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/sink/toggle"
+	"github.com/adtyap26/event-stream-video/internal/stream"
+	"github.com/adtyap26/event-stream-video/internal/taxonomy"
+	"github.com/adtyap26/event-stream-video/internal/timestamps"
+	"github.com/adtyap26/event-stream-video/internal/transform"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+	"github.com/adtyap26/event-stream-video/internal/webhook"
 )
 
+// routeOptions holds optional dependencies for SetupRoutes
+type routeOptions struct {
+	keyStore          auth.KeyStore
+	certificates      *auth.Certificates
+	signatures        *signing.Verifier
+	limits            validation.Limits
+	staticDir         string
+	maxDecompressSize int64
+	maxBodySize       int64
+	bodyLimits        map[string]int64
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	routeTimeouts     map[string]time.Duration
+	dedup             *dedup.Deduplicator
+	eventDedup        *dedup.Deduplicator
+	ledger            *dedup.Ledger
+	sessions          *session.Tracker
+	broker            *stream.Broker
+	mqtt              *mqtt.Bridge
+	rateLimiter       *ratelimit.Limiter
+	quotas            *quota.Tracker
+	metrics           bool
+	tracing           bool
+	timestamps        timestamps.Normalizer
+	readiness         map[string]ReadinessCheck
+	qoe               *analytics.Aggregator
+	videoStats        *analytics.StatsStore
+	rollups           *rollup.Engine
+	activity          *activity.Recorder
+	anomalies         *anomaly.Detector
+	errorGroups       *playererror.Groups
+	webhooks          *webhook.Dispatcher
+	cors              *cors.Policy
+	enricher          *enrich.Enricher
+	regions           *regions.Router
+	sdkConfig         *sdkconfig.Store
+	sampler           *sampling.Sampler
+	scrubber          *scrub.Scrubber
+	bots              *bots.Filter
+	metadata          *metadata.Resolver
+	transforms        *transform.Engine
+	identities        *identity.Graph
+	taxonomy          *taxonomy.Taxonomy
+	dimensions        *dimensions.Registry
+	cache             *cache.Cache
+	pipeline          []string
+	adminToken        string
+	sinkToggles       []*toggle.Sink
+	erasure           *erasure.Manager
+	backlog           sink.Backlogger
+	shedThreshold     float64
+	shedRetryAfter    time.Duration
+	v2                bool
+	deprecations      map[string]Deprecation
+}
+
+// Option configures SetupRoutes
+type Option func(*routeOptions)
+
+// WithKeyStore enables API key authentication on the event endpoints
+func WithKeyStore(store auth.KeyStore) Option {
+	return func(o *routeOptions) {
+		o.keyStore = store
+	}
+}
+
+// WithClientCertificates authenticates the event endpoints' callers whose
+// verified client certificate certs maps to a tenant, ahead of API keys
+func WithClientCertificates(certs *auth.Certificates) Option {
+	return func(o *routeOptions) {
+		o.certificates = certs
+	}
+}
+
+// WithSignatures rejects the batches of tenants with a signing secret
+// unless they are signed with it
+func WithSignatures(verifier *signing.Verifier) Option {
+	return func(o *routeOptions) {
+		o.signatures = verifier
+	}
+}
+
+// WithValidationLimits overrides the default batch validation limits
+func WithValidationLimits(limits validation.Limits) Option {
+	return func(o *routeOptions) {
+		o.limits = limits
+	}
+}
+
+// WithStaticDir sets the directory served for non-API paths
+func WithStaticDir(dir string) Option {
+	return func(o *routeOptions) {
+		o.staticDir = dir
+	}
+}
+
+// WithMaxDecompressedSize limits how large a compressed body may grow
+func WithMaxDecompressedSize(size int64) Option {
+	return func(o *routeOptions) {
+		o.maxDecompressSize = size
+	}
+}
+
+// WithMaxBodySize sets the default request body limit for ingestion endpoints
+func WithMaxBodySize(size int64) Option {
+	return func(o *routeOptions) {
+		o.maxBodySize = size
+	}
+}
+
+// WithBodyLimit overrides the request body limit for a single route, e.g.
+// "/api/v1/events/beacon"
+func WithBodyLimit(route string, size int64) Option {
+	return func(o *routeOptions) {
+		o.bodyLimits[route] = size
+	}
+}
+
+// WithTimeouts bounds reading requests and writing responses on routes
+// without their own timeout. Zero disables a timeout.
+func WithTimeouts(read, write time.Duration) Option {
+	return func(o *routeOptions) {
+		o.readTimeout = read
+		o.writeTimeout = write
+	}
+}
+
+// WithRouteTimeout replaces the read and write timeouts for a route path,
+// or for every path under it when it ends in /. Zero disables them.
+func WithRouteTimeout(route string, timeout time.Duration) Option {
+	return func(o *routeOptions) {
+		o.routeTimeouts[route] = timeout
+	}
+}
+
+// WithDeduplicator drops replayed batches that were already stored
+func WithDeduplicator(d *dedup.Deduplicator) Option {
+	return func(o *routeOptions) {
+		o.dedup = d
+	}
+}
+
+// WithEventDeduplicator drops events whose eventId was already stored, for
+// clients that resend events in new batches
+func WithEventDeduplicator(d *dedup.Deduplicator) Option {
+	return func(o *routeOptions) {
+		o.eventDedup = d
+	}
+}
+
+// WithBatchLedger records which events of each batch were stored, so
+// retried batches that were fully stored are acknowledged as duplicates and
+// partially stored ones only store the missing events
+func WithBatchLedger(l *dedup.Ledger) Option {
+	return func(o *routeOptions) {
+		o.ledger = l
+	}
+}
+
+// WithSessionTracker aggregates stored events into per-session state,
+// served under /api/v1/sessions together with the concurrent viewers at
+// /api/v1/concurrents and /api/v1/videos/{id}/concurrents
+func WithSessionTracker(tracker *session.Tracker) Option {
+	return func(o *routeOptions) {
+		o.sessions = tracker
+	}
+}
+
+// WithQoE serves the QoE metrics of sessions, videos and CDNs under
+// /api/v1/qoe. It needs a session tracker.
+func WithQoE(aggregator *analytics.Aggregator) Option {
+	return func(o *routeOptions) {
+		o.qoe = aggregator
+	}
+}
+
+// WithVideoStats serves per-video viewing stats at
+// /api/v1/videos/{id}/stats and those of all of the caller's videos at
+// /api/v1/stats. It needs a session tracker.
+func WithVideoStats(stats *analytics.StatsStore) Option {
+	return func(o *routeOptions) {
+		o.videoStats = stats
+	}
+}
+
+// WithRollups serves the metric rollups of engine at /api/v1/rollups. It
+// needs a session tracker feeding the engine.
+func WithRollups(engine *rollup.Engine) Option {
+	return func(o *routeOptions) {
+		o.rollups = engine
+	}
+}
+
+// WithDashboard records recent ingestion activity in recorder, serves it
+// under /api/v1/activity and serves the embedded dashboard at /dashboard/,
+// which / redirects to. Other paths are still served from the static
+// directory.
+func WithDashboard(recorder *activity.Recorder) Option {
+	return func(o *routeOptions) {
+		o.activity = recorder
+	}
+}
+
+// WithAnomalyDetector feeds stored events to detector so it can alert on
+// error spikes
+func WithAnomalyDetector(detector *anomaly.Detector) Option {
+	return func(o *routeOptions) {
+		o.anomalies = detector
+	}
+}
+
+// WithErrorGroups groups the classified player errors of stored events in
+// groups, served under /api/v1/errors. It needs enrichment to classify
+// errors.
+func WithErrorGroups(groups *playererror.Groups) Option {
+	return func(o *routeOptions) {
+		o.errorGroups = groups
+	}
+}
+
+// WithEnricher adds GeoIP and device information to events before they
+// are stored
+func WithEnricher(enricher *enrich.Enricher) Option {
+	return func(o *routeOptions) {
+		o.enricher = enricher
+	}
+}
+
+// WithRegions serves GET /api/v1/config, pointing players at the regional
+// collector of their country, located by the enricher's GeoIP database
+func WithRegions(router *regions.Router) Option {
+	return func(o *routeOptions) {
+		o.regions = router
+	}
+}
+
+// WithSDKConfig serves GET /api/v1/sdk/config, the SDK settings of the
+// tenant of the API key asking
+func WithSDKConfig(store *sdkconfig.Store) Option {
+	return func(o *routeOptions) {
+		o.sdkConfig = store
+	}
+}
+
+// WithBotFilter flags or drops the events of crawlers, headless browsers
+// and datacenter clients in the bots stage of the pipeline
+func WithBotFilter(f *bots.Filter) Option {
+	return func(o *routeOptions) {
+		o.bots = f
+	}
+}
+
+// WithMetadata adds the CMS metadata of their videos to events in the
+// metadata stage of the pipeline
+func WithMetadata(r *metadata.Resolver) Option {
+	return func(o *routeOptions) {
+		o.metadata = r
+	}
+}
+
+// WithTransforms renames, coerces and derives event fields by the rules of
+// e in the transform stage of the pipeline
+func WithTransforms(e *transform.Engine) Option {
+	return func(o *routeOptions) {
+		o.transforms = e
+	}
+}
+
+// WithIdentities links the anonymous and user IDs of stored events, in the
+// identities stage of the pipeline, serves the links of the caller's tenant
+// at /api/v1/identities and forgets those of users whose events are erased
+func WithIdentities(g *identity.Graph) Option {
+	return func(o *routeOptions) {
+		o.identities = g
+	}
+}
+
+// WithTaxonomy rejects or quarantines events, in the validate stage of the
+// pipeline, whose name is not in the taxonomy or that lack the fields their
+// name requires
+func WithTaxonomy(t *taxonomy.Taxonomy) Option {
+	return func(o *routeOptions) {
+		o.taxonomy = t
+	}
+}
+
+// WithDimensions rejects events, in the validate stage of the pipeline,
+// whose customData doesn't match their tenant's custom dimensions, serves
+// the caller's dimensions at /api/v1/dimensions and, when the sink can be
+// queried, breakdowns of its events by them at /api/v1/dimensions/{name}.
+// The admin API manages them per tenant.
+func WithDimensions(registry *dimensions.Registry) Option {
+	return func(o *routeOptions) {
+		o.dimensions = registry
+	}
+}
+
+// WithCache answers the aggregation endpoints, funnels, breakdowns, QoE,
+// stats, rollups, activity and error groups, from responses c keeps for a
+// few seconds, and has identical requests share one computation
+func WithCache(c *cache.Cache) Option {
+	return func(o *routeOptions) {
+		o.cache = c
+	}
+}
+
+// WithSampler drops events according to the sampler's rules before they
+// are stored
+func WithSampler(sampler *sampling.Sampler) Option {
+	return func(o *routeOptions) {
+		o.sampler = sampler
+	}
+}
+
+// WithScrubber hashes or drops personal data before events are stored
+func WithScrubber(scrubber *scrub.Scrubber) Option {
+	return func(o *routeOptions) {
+		o.scrubber = scrubber
+	}
+}
+
+// WithPipeline sets the processors batches pass through before they are
+// stored, by pipeline name and in the order given. It defaults to
+// pipeline.DefaultOrder.
+func WithPipeline(processors []string) Option {
+	return func(o *routeOptions) {
+		o.pipeline = processors
+	}
+}
+
+// WithEventStream enables the live event feed at /api/v1/events/stream
+func WithEventStream(broker *stream.Broker) Option {
+	return func(o *routeOptions) {
+		o.broker = broker
+	}
+}
+
+// WithMQTT ingests the messages bridge receives from the MQTT broker
+// through the same pipeline as POSTed batches
+func WithMQTT(bridge *mqtt.Bridge) Option {
+	return func(o *routeOptions) {
+		o.mqtt = bridge
+	}
+}
+
+// WithWebhooks posts stored events to the dispatcher's webhooks
+func WithWebhooks(dispatcher *webhook.Dispatcher) Option {
+	return func(o *routeOptions) {
+		o.webhooks = dispatcher
+	}
+}
+
+// WithRateLimiter throttles ingestion requests that exceed the limiter's rate
+func WithRateLimiter(limiter *ratelimit.Limiter) Option {
+	return func(o *routeOptions) {
+		o.rateLimiter = limiter
+	}
+}
+
+// WithQuotas counts the events each API client ingests per day in tracker,
+// rejects ingestion requests of clients over their daily quota and serves
+// the caller's usage at /api/v1/usage
+func WithQuotas(tracker *quota.Tracker) Option {
+	return func(o *routeOptions) {
+		o.quotas = tracker
+	}
+}
+
+// WithMetrics exposes Prometheus metrics at /metrics
+func WithMetrics() Option {
+	return func(o *routeOptions) {
+		o.metrics = true
+	}
+}
+
+// WithTracing starts an OpenTelemetry span for every request, exported by
+// the globally installed tracer provider
+func WithTracing() Option {
+	return func(o *routeOptions) {
+		o.tracing = true
+	}
+}
+
+// WithTimestampNormalizer configures how client timestamps are normalized
+func WithTimestampNormalizer(n timestamps.Normalizer) Option {
+	return func(o *routeOptions) {
+		o.timestamps = n
+	}
+}
+
+// WithLoadShedding answers ingestion requests with 503 and Retry-After
+// while the sink's queue is at least threshold full (0 to 1). It has no
+// effect for sinks that don't implement sink.Backlogger.
+func WithLoadShedding(threshold float64, retryAfter time.Duration) Option {
+	return func(o *routeOptions) {
+		o.shedThreshold = threshold
+		o.shedRetryAfter = retryAfter
+	}
+}
+
+// WithCORSPolicy replaces the default policy, which allows any origin
+func WithCORSPolicy(policy *cors.Policy) Option {
+	return func(o *routeOptions) {
+		o.cors = policy
+	}
+}
+
+// WithAdmin serves the admin API under /admin/v1 to requests carrying
+// token as a Bearer token. It manages the sampler, rate limiter, key store
+// and custom dimensions given with the other options, and sinks added with
+// WithSinkToggle.
+func WithAdmin(token string) Option {
+	return func(o *routeOptions) {
+		o.adminToken = token
+	}
+}
+
+// WithSinkToggle lets the admin API switch s on and off
+func WithSinkToggle(s *toggle.Sink) Option {
+	return func(o *routeOptions) {
+		o.sinkToggles = append(o.sinkToggles, s)
+	}
+}
+
+// WithErasure serves DELETE /api/v1/users/{userId}/events and the status
+// of its jobs at /api/v1/erasures/{id}, both behind the admin token given
+// with WithAdmin
+func WithErasure(manager *erasure.Manager) Option {
+	return func(o *routeOptions) {
+		o.erasure = manager
+	}
+}
+
+// WithReadinessCheck adds a named check to /readyz. The sink is checked
+// automatically when it implements sink.HealthChecker.
+func WithReadinessCheck(name string, check ReadinessCheck) Option {
+	return func(o *routeOptions) {
+		o.readiness[name] = check
+	}
+}
+
+// WithV2 serves the v2 API under /api/v2. It is under development and its
+// contract may still change.
+func WithV2() Option {
+	return func(o *routeOptions) {
+		o.v2 = true
+	}
+}
+
+// WithDeprecation marks an API version as deprecated. Its routes keep
+// working but warn callers with Deprecation and Sunset headers.
+func WithDeprecation(version string, d Deprecation) Option {
+	return func(o *routeOptions) {
+		o.deprecations[version] = d
+	}
+}
+
 // SetupRoutes configures all API routes
-func SetupRoutes(eventLogger *logger.EventLogger) http.Handler {
+func SetupRoutes(eventSink sink.EventSink, opts ...Option) http.Handler {
+	options := routeOptions{
+		limits:            validation.DefaultLimits(),
+		staticDir:         "./static",
+		maxDecompressSize: DefaultMaxDecompressedSize,
+		maxBodySize:       DefaultMaxBodySize,
+		bodyLimits: map[string]int64{
+			// sendBeacon payloads are capped at 64 KiB by browsers
+			beaconRoute: 64 << 10,
+		},
+		readTimeout:  DefaultReadTimeout,
+		writeTimeout: DefaultWriteTimeout,
+		routeTimeouts: map[string]time.Duration{
+			// Streams stay open for as long as the client listens
+			"/api/v1/events/ws":     0,
+			"/api/v1/events/stream": 0,
+			// Exports are streamed for as long as there are rows
+			"/api/v1/export": 0,
+			// Funnels read every event of their time range
+			"/api/v1/funnels": 5 * time.Minute,
+			// So do breakdowns
+			"/api/v1/dimensions/": 5 * time.Minute,
+		},
+		readiness:    make(map[string]ReadinessCheck),
+		deprecations: make(map[string]Deprecation),
+	}
+	options.cors, _ = cors.New(cors.DefaultConfig())
+	if checker, ok := eventSink.(sink.HealthChecker); ok {
+		options.readiness["sink"] = checker.CheckHealth
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if backlogger, ok := eventSink.(sink.Backlogger); ok && options.shedThreshold > 0 {
+		options.backlog = backlogger
+	}
+
 	// Create event handler
-	eventHandler := NewEventHandler(eventLogger)
+	eventHandler := NewEventHandler(eventSink, options.limits)
+	eventHandler.dedup = options.dedup
+	eventHandler.eventDedup = options.eventDedup
+	eventHandler.ledger = options.ledger
+	eventHandler.sessions = options.sessions
+	eventHandler.broker = options.broker
+	eventHandler.enricher = options.enricher
+	eventHandler.sampler = options.sampler
+	eventHandler.scrubber = options.scrubber
+	eventHandler.bots = options.bots
+	eventHandler.metadata = options.metadata
+	eventHandler.transforms = options.transforms
+	eventHandler.identities = options.identities
+	eventHandler.taxonomy = options.taxonomy
+	eventHandler.dimensions = options.dimensions
+	eventHandler.activity = options.activity
+	eventHandler.anomalies = options.anomalies
+	eventHandler.errorGroups = options.errorGroups
+	eventHandler.webhooks = options.webhooks
+	eventHandler.quotas = options.quotas
+	eventHandler.timestamps = options.timestamps
+	eventHandler.maxFrameSize = options.bodyLimit("/api/v1/events/ws")
+	if options.pipeline != nil {
+		eventHandler.pipeline = eventHandler.newPipeline(options.pipeline)
+	}
+	if options.mqtt != nil {
+		options.mqtt.Handle(eventHandler.HandleMQTT)
+	}
 
 	// Set up routes
 	mux := http.NewServeMux()
 
 	// Event endpoints
-	mux.Handle("/api/v1/events", CORSMiddleware(http.HandlerFunc(eventHandler.HandleEvents)))
-	mux.Handle("/api/v1/events/beacon", CORSMiddleware(http.HandlerFunc(eventHandler.HandleBeacons)))
+	mux.Handle("/api/v1/events", options.ingest("/api/v1/events", http.HandlerFunc(eventHandler.HandleEvents)))
+	mux.Handle(beaconRoute, options.ingest(beaconRoute, http.HandlerFunc(eventHandler.HandleBeacons)))
+	mux.Handle(validateRoute, CORSMiddleware(options.cors, BodyLimitMiddleware(options.bodyLimit(validateRoute),
+		DecompressMiddleware(options.maxDecompressSize, options.authenticate(
+			options.rateLimit(validateRoute, http.HandlerFunc(eventHandler.HandleValidate)))))))
+	schemaHandler := NewSchemaHandler(options.limits)
+	mux.Handle("GET /api/v1/schema", CORSMiddleware(options.cors, http.HandlerFunc(schemaHandler.HandleBatchSchema)))
+	mux.Handle("GET /api/v1/schema/event", CORSMiddleware(options.cors, http.HandlerFunc(schemaHandler.HandleEventSchema)))
+	mux.Handle("GET /api/v1/schema/versions", CORSMiddleware(options.cors, http.HandlerFunc(HandleSchemaVersions)))
+	if options.regions != nil {
+		regionHandler := NewRegionHandler(options.regions, options.enricher)
+		mux.Handle("GET /api/v1/config", CORSMiddleware(options.cors, http.HandlerFunc(regionHandler.HandleConfig)))
+	}
+	if options.sdkConfig != nil {
+		sdkConfigHandler := NewSDKConfigHandler(options.sdkConfig)
+		sdkConfigRoute := CORSMiddleware(options.cors, options.authenticate(
+			options.rateLimit("/api/v1/sdk/config", http.HandlerFunc(sdkConfigHandler.HandleGetSDKConfig))))
+		mux.Handle("GET /api/v1/sdk/config", sdkConfigRoute)
+		// SDKs send their API key, so browsers preflight the request
+		mux.Handle("OPTIONS /api/v1/sdk/config", sdkConfigRoute)
+	}
+	mux.Handle("/api/v1/events/ws", CORSMiddleware(options.cors, options.shed("/api/v1/events/ws", options.authenticate(options.verifySignature(
+		options.rateLimit("/api/v1/events/ws", options.quota("/api/v1/events/ws", http.HandlerFunc(eventHandler.HandleWebSocket))))))))
+
+	// Versions under development get routes as their contract takes shape;
+	// the rest of the API is only served under v1 so far
+	versions := options.apiVersions()
+	mux.Handle("GET /api/versions", CORSMiddleware(options.cors, http.HandlerFunc(NewVersionsHandler(versions).HandleListVersions)))
+	if options.v2 {
+		mux.Handle("/api/v2/events", options.ingest("/api/v2/events", http.HandlerFunc(eventHandler.HandleEventsV2)))
+	}
+
+	// Read endpoints. read registers the preflight of a route too, as
+	// callers sending an API key are preflighted and OPTIONS requests would
+	// otherwise fall through to the static file server.
+	read := func(path string, handler http.Handler) {
+		route := CORSMiddleware(options.cors, options.authenticate(handler))
+		mux.Handle("GET "+path, route)
+		mux.Handle("OPTIONS "+path, route)
+	}
+	if querier, ok := eventSink.(sink.Querier); ok {
+		queryHandler := NewQueryHandler(querier)
+		// The ingestion route answers the preflights of /api/v1/events
+		mux.Handle("GET /api/v1/events", CORSMiddleware(options.cors, options.authenticate(http.HandlerFunc(queryHandler.HandleQueryEvents))))
+		read("/api/v1/export", http.HandlerFunc(queryHandler.HandleExport))
+		read("/api/v1/funnels", options.cached(http.HandlerFunc(queryHandler.HandleFunnel)))
+		read("/api/v1/sessions/{id}/timeline", http.HandlerFunc(queryHandler.HandleSessionTimeline))
+	}
+	if options.dimensions != nil {
+		querier, _ := eventSink.(sink.Querier)
+		dimensionHandler := NewDimensionHandler(options.dimensions, querier)
+		read("/api/v1/dimensions", http.HandlerFunc(dimensionHandler.HandleListDimensions))
+		if querier != nil {
+			read("/api/v1/dimensions/{name}", options.cached(http.HandlerFunc(dimensionHandler.HandleGetBreakdown)))
+		}
+	}
+	if options.identities != nil {
+		identityHandler := NewIdentityHandler(options.identities, options.scrubber)
+		read("/api/v1/identities", http.HandlerFunc(identityHandler.HandleGetIdentities))
+	}
+	if options.broker != nil {
+		streamHandler := NewStreamHandler(options.broker)
+		read("/api/v1/events/stream", http.HandlerFunc(streamHandler.HandleStream))
+	}
+	if options.sessions != nil {
+		sessionHandler := NewSessionHandler(options.sessions)
+		read("/api/v1/sessions", http.HandlerFunc(sessionHandler.HandleListSessions))
+		read("/api/v1/sessions/{id}", http.HandlerFunc(sessionHandler.HandleGetSession))
+
+		concurrencyHandler := NewConcurrencyHandler(options.sessions)
+		read("/api/v1/concurrents", http.HandlerFunc(concurrencyHandler.HandleGetConcurrents))
+		read("/api/v1/videos/{id}/concurrents", http.HandlerFunc(concurrencyHandler.HandleGetVideoConcurrents))
+	}
+	if options.sessions != nil && options.qoe != nil {
+		qoeHandler := NewQoEHandler(options.sessions, options.qoe)
+		read("/api/v1/qoe/sessions/{id}", http.HandlerFunc(qoeHandler.HandleGetSession))
+		read("/api/v1/qoe/videos/{id}", options.cached(http.HandlerFunc(qoeHandler.HandleGetVideo)))
+		read("/api/v1/qoe/cdns", options.cached(http.HandlerFunc(qoeHandler.HandleListCDNs)))
+	}
+	if options.sessions != nil && options.videoStats != nil {
+		videoHandler := NewVideoHandler(options.sessions, options.videoStats)
+		read("/api/v1/videos/{id}/stats", options.cached(http.HandlerFunc(videoHandler.HandleGetStats)))
+		read("/api/v1/stats", options.cached(http.HandlerFunc(videoHandler.HandleGetTenantStats)))
+	}
+	if options.rollups != nil {
+		rollupHandler := NewRollupHandler(options.rollups)
+		read("/api/v1/rollups", options.cached(http.HandlerFunc(rollupHandler.HandleGetRollups)))
+	}
+
+	if options.quotas != nil {
+		usageHandler := NewUsageHandler(options.quotas)
+		read("/api/v1/usage", http.HandlerFunc(usageHandler.HandleGetUsage))
+	}
+
+	if options.activity != nil {
+		activityHandler := NewActivityHandler(options.activity)
+		read("/api/v1/activity/ingestion", options.cached(http.HandlerFunc(activityHandler.HandleGetIngestion)))
+		read("/api/v1/activity/videos", options.cached(http.HandlerFunc(activityHandler.HandleGetTopVideos)))
+		read("/api/v1/activity/errors", options.cached(http.HandlerFunc(activityHandler.HandleGetErrors)))
+	}
+
+	if options.errorGroups != nil {
+		errorGroupsHandler := NewErrorGroupsHandler(options.errorGroups)
+		read("/api/v1/errors", options.cached(http.HandlerFunc(errorGroupsHandler.HandleListGroups)))
+		read("/api/v1/errors/{fingerprint}", http.HandlerFunc(errorGroupsHandler.HandleGetGroup))
+	}
+
+	// Admin endpoints
+	if options.adminToken != "" {
+		keys, _ := options.keyStore.(auth.KeyManager)
+		adminHandler := NewAdminHandler(keys, options.sampler, options.rateLimiter, options.sinkToggles)
+		adminHandler.dimensions = options.dimensions
+		admin := func(pattern string, handler http.HandlerFunc) {
+			mux.Handle(pattern, AdminMiddleware(options.adminToken, handler))
+		}
+		admin("GET /admin/v1/sampling", adminHandler.HandleGetSampling)
+		admin("PUT /admin/v1/sampling", adminHandler.HandlePutSampling)
+		admin("GET /admin/v1/keys", adminHandler.HandleListKeys)
+		admin("POST /admin/v1/keys", adminHandler.HandleCreateKey)
+		admin("POST /admin/v1/keys/rotate", adminHandler.HandleRotateKey)
+		admin("POST /admin/v1/keys/revoke", adminHandler.HandleRevokeKey)
+		admin("GET /admin/v1/ratelimits", adminHandler.HandleGetRateLimits)
+		admin("PUT /admin/v1/ratelimits", adminHandler.HandlePutRateLimit)
+		admin("PUT /admin/v1/ratelimits/tenants/{tenant}", adminHandler.HandlePutTenantRateLimit)
+		admin("DELETE /admin/v1/ratelimits/tenants/{tenant}", adminHandler.HandleDeleteTenantRateLimit)
+		admin("GET /admin/v1/sinks", adminHandler.HandleListSinks)
+		admin("PUT /admin/v1/sinks/{name}", adminHandler.HandlePutSink)
+		admin("GET /admin/v1/tenants/{tenant}/dimensions", adminHandler.HandleListDimensions)
+		admin("PUT /admin/v1/tenants/{tenant}/dimensions/{name}", adminHandler.HandlePutDimension)
+		admin("DELETE /admin/v1/tenants/{tenant}/dimensions/{name}", adminHandler.HandleDeleteDimension)
+
+		if options.erasure != nil {
+			erasureHandler := NewErasureHandler(options.erasure, options.scrubber)
+			erasureHandler.identities = options.identities
+			admin("DELETE /api/v1/users/{userId}/events", erasureHandler.HandleDeleteUserEvents)
+			admin("GET /api/v1/erasures/{id}", erasureHandler.HandleGetJob)
+		}
+	}
+
+	// Probes
+	healthHandler := NewHealthHandler(options.readiness)
+	mux.HandleFunc("GET /healthz", healthHandler.HandleHealthz)
+	mux.HandleFunc("GET /readyz", healthHandler.HandleReadyz)
+
+	if options.metrics {
+		mux.Handle("GET /metrics", metrics.Handler())
+	}
+
+	// The dashboard's page is public; the APIs it polls need an API key
+	// when keys are configured
+	if options.activity != nil {
+		mux.Handle("GET /dashboard/", http.StripPrefix("/dashboard", dashboard.Handler()))
+		mux.Handle("GET /{$}", http.RedirectHandler("/dashboard/", http.StatusFound))
+	}
 
 	// Serve static files
-	fs := http.FileServer(http.Dir("./"))
+	fs := http.FileServer(http.Dir(options.staticDir))
 	mux.Handle("/", fs)
 
-	return mux
+	handler := VersionMiddleware(versions, mux)
+
+	// Deadlines go on the server's own ResponseWriter, outside every wrapper
+	// but the one taking the receive time
+	if !options.tracing {
+		return receivedMiddleware(TimeoutMiddleware(options.timeout, RequestIDMiddleware(remoteMiddleware(handler))))
+	}
+	return receivedMiddleware(TimeoutMiddleware(options.timeout,
+		TracingMiddleware(RequestIDMiddleware(remoteMiddleware(routeSpanMiddleware(handler))))))
+}
+
+// ingest wraps the ingestion handler for route with the shared middleware chain
+func (o routeOptions) ingest(route string, next http.Handler) http.Handler {
+	handler := o.authenticate(o.verifySignature(o.rateLimit(route, o.quota(route, next))))
+	if route == beaconRoute {
+		// Beacon fallbacks wrap the batch, apiKey included, in encodings
+		// authentication can't look into
+		handler = BeaconMiddleware(handler)
+	}
+	return CORSMiddleware(o.cors,
+		o.shed(route,
+			BodyLimitMiddleware(o.bodyLimit(route),
+				DecompressMiddleware(o.maxDecompressSize, handler))))
+}
+
+// verifySignature wraps next with SignatureMiddleware when tenants sign
+// their batches. It runs after authentication to know the tenant and ahead
+// of the rate limiter and quotas, which spoofed batches shouldn't use up.
+func (o routeOptions) verifySignature(next http.Handler) http.Handler {
+	if o.signatures == nil {
+		return next
+	}
+	return SignatureMiddleware(o.signatures, next)
+}
+
+// cached wraps next with CacheMiddleware when the response cache is enabled.
+// It runs after authentication, which its keys include the tenant of.
+func (o routeOptions) cached(next http.Handler) http.Handler {
+	if o.cache == nil {
+		return next
+	}
+	return CacheMiddleware(o.cache, next)
+}
+
+// shed wraps next with LoadShedMiddleware when load shedding is configured
+// and the sink reports its backlog
+func (o routeOptions) shed(route string, next http.Handler) http.Handler {
+	if o.backlog == nil {
+		return next
+	}
+	return LoadShedMiddleware(o.backlog, o.shedThreshold, o.shedRetryAfter, route, next)
+}
+
+// bodyLimit returns the request body limit for route
+func (o routeOptions) bodyLimit(route string) int64 {
+	if limit, ok := o.bodyLimits[route]; ok && limit > 0 {
+		return limit
+	}
+	return o.maxBodySize
+}
+
+// Default request timeouts, for routes without their own
+const (
+	DefaultReadTimeout  = 30 * time.Second
+	DefaultWriteTimeout = 30 * time.Second
+)
+
+// timeout returns the read and write timeouts for path: those of the route
+// matching it exactly, else those of the longest route ending in / that it
+// is under, else the defaults
+func (o routeOptions) timeout(path string) (read, write time.Duration) {
+	if timeout, ok := o.routeTimeouts[path]; ok {
+		return timeout, timeout
+	}
+	longest := ""
+	for route := range o.routeTimeouts {
+		if strings.HasSuffix(route, "/") && strings.HasPrefix(path, route) && len(route) > len(longest) {
+			longest = route
+		}
+	}
+	if longest != "" {
+		timeout := o.routeTimeouts[longest]
+		return timeout, timeout
+	}
+	return o.readTimeout, o.writeTimeout
+}
+
+// rateLimit wraps next with RateLimitMiddleware when a limiter is configured.
+// It runs after authentication so buckets can be keyed on the API client.
+func (o routeOptions) rateLimit(route string, next http.Handler) http.Handler {
+	if o.rateLimiter == nil {
+		return next
+	}
+	return RateLimitMiddleware(o.rateLimiter, route, next)
+}
+
+// quota wraps next with QuotaMiddleware when quotas are configured. Like the
+// rate limiter, it runs after authentication to know the API client.
+func (o routeOptions) quota(route string, next http.Handler) http.Handler {
+	if o.quotas == nil {
+		return next
+	}
+	return QuotaMiddleware(o.quotas, route, next)
+}
+
+// authenticate wraps next with CertificateMiddleware when client
+// certificates are mapped to tenants and with AuthMiddleware when a key
+// store is configured. With certificates only, requests without one are
+// unauthorized.
+func (o routeOptions) authenticate(next http.Handler) http.Handler {
+	if o.keyStore == nil && o.certificates == nil {
+		return next
+	}
+	keys := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	if o.keyStore != nil {
+		keys = AuthMiddleware(o.keyStore, next)
+	}
+	if o.certificates == nil {
+		return keys
+	}
+	return CertificateMiddleware(o.certificates, next, keys)
 }
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/cors"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// discardSink accepts every batch
+type discardSink struct{}
+
+func (discardSink) LogBatch(context.Context, models.EventBatch) error { return nil }
+func (discardSink) Close() error                                      { return nil }
+
+// TestReadRoutePreflight checks that the browser preflight of an
+// authenticated read route is answered by the CORS policy, not by the
+// static file server
+func TestReadRoutePreflight(t *testing.T) {
+	policy, err := cors.New(cors.Config{AllowedOrigins: []string{"https://dashboard.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := session.NewTracker(discardSink{}, time.Minute, time.Minute)
+	defer tracker.Close()
+	keys := auth.NewStaticStore(map[string]auth.Client{"key": {Tenant: "beta", ClientID: "web"}})
+	handler := SetupRoutes(discardSink{},
+		WithKeyStore(keys),
+		WithCORSPolicy(policy),
+		WithSessionTracker(tracker),
+		WithStaticDir(t.TempDir()),
+	)
+
+	for _, path := range []string{"/api/v1/sessions", "/api/v1/sessions/abc", "/api/v1/concurrents", "/api/v1/videos/v1/concurrents"} {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, path, nil)
+			req.Header.Set("Origin", "https://dashboard.example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			req.Header.Set("Access-Control-Request-Headers", "X-API-Key")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("got status %d, want %d", rec.Code, http.StatusNoContent)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://dashboard.example.com" {
+				t.Errorf("got Access-Control-Allow-Origin %q", got)
+			}
+
+			// The request it was a preflight of
+			req = httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Origin", "https://dashboard.example.com")
+			req.Header.Set("X-API-Key", "key")
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code == http.StatusNotFound && rec.Header().Get("Access-Control-Allow-Origin") == "" {
+				t.Errorf("GET %s wasn't served by its route", path)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/schema"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+)
+
+// HandleSchemaVersions lists the event schema versions the collector
+// accepts, so players can check theirs before sending
+func HandleSchemaVersions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"current":  schema.Current,
+		"versions": schema.Versions(),
+	})
+}
+
+// SchemaHandler serves the JSON Schemas of what the collector ingests,
+// generated once from the models and the validation rules
+type SchemaHandler struct {
+	batch []byte
+	event []byte
+}
+
+// NewSchemaHandler creates a SchemaHandler for batches limited by limits
+func NewSchemaHandler(limits validation.Limits) *SchemaHandler {
+	// Plain maps of strings, numbers and slices, which always encode
+	batch, _ := json.MarshalIndent(validation.BatchSchema(limits), "", "  ")
+	event, _ := json.MarshalIndent(validation.EventSchema(), "", "  ")
+	return &SchemaHandler{batch: batch, event: event}
+}
+
+// HandleBatchSchema serves the schema of the batches POST /api/v1/events
+// accepts, which defines events too
+func (h *SchemaHandler) HandleBatchSchema(w http.ResponseWriter, r *http.Request) {
+	writeSchema(w, h.batch)
+}
+
+// HandleEventSchema serves the schema of a single event
+func (h *SchemaHandler) HandleEventSchema(w http.ResponseWriter, r *http.Request) {
+	writeSchema(w, h.event)
+}
+
+func writeSchema(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
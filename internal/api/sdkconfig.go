@@ -0,0 +1,27 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/sdkconfig"
+)
+
+// SDKConfigHandler serves player SDKs the settings of their tenant
+type SDKConfigHandler struct {
+	store *sdkconfig.Store
+}
+
+// NewSDKConfigHandler creates an SDKConfigHandler serving store's settings
+func NewSDKConfigHandler(store *sdkconfig.Store) *SDKConfigHandler {
+	return &SDKConfigHandler{store: store}
+}
+
+// HandleGetSDKConfig answers GET /api/v1/sdk/config?apiKey=... with the
+// settings of the key's tenant
+func (h *SDKConfigHandler) HandleGetSDKConfig(w http.ResponseWriter, r *http.Request) {
+	settings := h.store.For(auth.TenantFromContext(r.Context()))
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", settings.TTL))
+	writeJSON(w, http.StatusOK, settings)
+}
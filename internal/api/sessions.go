@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// SessionHandler serves the aggregated state of live sessions
+type SessionHandler struct {
+	tracker *session.Tracker
+}
+
+func NewSessionHandler(tracker *session.Tracker) *SessionHandler {
+	return &SessionHandler{tracker: tracker}
+}
+
+// HandleGetSession returns the current state of the session named in the path
+func (h *SessionHandler) HandleGetSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	state, ok := h.tracker.Get(auth.TenantFromContext(r.Context()), id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{
+			"status":  "error",
+			"message": "Session not found",
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, state)
+}
+
+// HandleListSessions returns how many sessions of the caller's tenant are
+// active and the most recent of them, up to the limit query parameter
+func (h *SessionHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r, defaultActivityLimit)
+	if !ok {
+		return
+	}
+
+	tenant := auth.TenantFromContext(r.Context())
+	sessions := []session.State{}
+	active := 0
+	for _, state := range h.tracker.Active() {
+		if state.Tenant != tenant {
+			continue
+		}
+		active++
+		if len(sessions) < limit {
+			sessions = append(sessions, state)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"active":   active,
+		"sessions": sessions,
+	})
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/stream"
+)
+
+// streamKeepAlive is how often an idle stream sends a comment so proxies
+// don't close the connection
+const streamKeepAlive = 15 * time.Second
+
+// StreamHandler serves a live feed of incoming events as Server-Sent Events
+type StreamHandler struct {
+	broker *stream.Broker
+}
+
+func NewStreamHandler(broker *stream.Broker) *StreamHandler {
+	return &StreamHandler{broker: broker}
+}
+
+// HandleStream streams events matching the clientId, sessionId, eventName
+// and eventCategory query parameters until the client disconnects
+func (h *StreamHandler) HandleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	sub := h.broker.Subscribe(stream.Filter{
+		// Subscribers only ever see their own tenant's events
+		Tenant:    auth.TenantFromContext(r.Context()),
+		ClientID:  query.Get("clientId"),
+		SessionID: query.Get("sessionId"),
+		EventName: query.Get("eventName"),
+
+		EventCategory: query.Get("eventCategory"),
+	})
+	defer h.broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			if dropped := sub.Dropped(); dropped > 0 {
+				slog.WarnContext(r.Context(), "Live stream subscriber missed events", "dropped", dropped)
+			}
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case record, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(record)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error encoding streamed event", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", sseEventName(record.EventName), data)
+			flusher.Flush()
+		}
+	}
+}
+
+// sseEventName keeps event names from breaking the SSE framing
+func sseEventName(name string) string {
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\n' || name[i] == '\r' {
+			return "event"
+		}
+	}
+	if name == "" {
+		return "event"
+	}
+	return name
+}
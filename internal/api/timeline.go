@@ -0,0 +1,83 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/analytics"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+// maxTimelineEvents caps the events a timeline reads from the sink
+const maxTimelineEvents = 100_000
+
+// HandleSessionTimeline returns the timeline of the session named in the
+// path, reconstructed from its stored events: joins, plays, pauses, stalls,
+// seeks, quality switches, ads, errors and the gaps between events longer
+// than the gap query parameter (30s by default). The from and to query
+// parameters bound the events read; truncated is set when the session had
+// more events than are read for one timeline.
+func (h *QueryHandler) HandleSessionTimeline(w http.ResponseWriter, r *http.Request) {
+	gap := analytics.DefaultTimelineGap
+	if param := r.URL.Query().Get("gap"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"status":  "error",
+				"message": "gap must be a positive duration such as 30s or 1m",
+			})
+			return
+		}
+		gap = parsed
+	}
+	query := sink.Query{
+		Tenant:    auth.TenantFromContext(r.Context()),
+		SessionID: r.PathValue("id"),
+		Limit:     maxQueryLimit,
+	}
+	if !queryTime(w, r, "from", &query.From) || !queryTime(w, r, "to", &query.To) {
+		return
+	}
+
+	builder := analytics.NewTimelineBuilder(query.SessionID, gap)
+	read := 0
+	truncated := false
+	for {
+		result, err := h.querier.QueryEvents(r.Context(), query)
+		if !writeQueryError(w, query, err) {
+			return
+		}
+		for _, record := range result.Events {
+			builder.Observe(record)
+		}
+		read += len(result.Events)
+		if result.NextCursor == "" {
+			break
+		}
+		if read >= maxTimelineEvents {
+			slog.WarnContext(r.Context(), "Session timeline truncated", "tenant", query.Tenant, "session", query.SessionID, "events", read)
+			truncated = true
+			break
+		}
+		query.Cursor = result.NextCursor
+	}
+
+	timeline, ok := builder.Timeline()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{
+			"status":  "error",
+			"message": "No stored events for session",
+		})
+		return
+	}
+	if truncated {
+		writeJSON(w, http.StatusOK, struct {
+			analytics.Timeline
+			Truncated bool `json:"truncated"`
+		}{timeline, true})
+		return
+	}
+	writeJSON(w, http.StatusOK, timeline)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/adtyap26/event-stream-video/internal/logging"
+)
+
+// TracingMiddleware starts a span for every request except probes and
+// metrics scrapes, continuing the trace of an incoming traceparent header
+func TracingMiddleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			switch r.URL.Path {
+			case "/healthz", "/readyz", "/metrics":
+				return false
+			}
+			return true
+		}),
+	)
+}
+
+// routeSpanMiddleware wraps the ServeMux, which records the route pattern
+// on the request it is given, and names the request's span after the
+// route once it is known. It also adds the trace ID to the log fields, so
+// log lines of a slow request can be found from its trace.
+func routeSpanMiddleware(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		spanContext := span.SpanContext()
+		if !spanContext.IsValid() {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		span.SetAttributes(attribute.String("esv.request.id", RequestIDFromContext(r.Context())))
+		r = r.WithContext(logging.With(r.Context(), "traceId", spanContext.TraceID().String()))
+		mux.ServeHTTP(w, r)
+
+		if r.Pattern == "" {
+			return
+		}
+		// Patterns without a method match any, e.g. "/api/v1/events"
+		route := r.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(attribute.String("http.route", route))
+	})
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/quota"
+)
+
+// UsageHandler serves a tenant's event usage against its daily quota, so
+// tenant owners can see how close their API keys are to the limits
+type UsageHandler struct {
+	tracker *quota.Tracker
+}
+
+func NewUsageHandler(tracker *quota.Tracker) *UsageHandler {
+	return &UsageHandler{tracker: tracker}
+}
+
+// HandleGetUsage returns the caller's usage of today per API client and
+// the usage of the earlier days kept
+func (h *UsageHandler) HandleGetUsage(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.tracker.Report(auth.TenantFromContext(r.Context())))
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/checksum"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+)
+
+// batchV2 is the batch contract of POST /api/v2/events. Unlike v1, the
+// batch's own fields are grouped under batch, retries are counted in
+// attempt rather than flagged, the API key is only read from headers and
+// events must follow the current event schema.
+type batchV2 struct {
+	Batch  batchHeaderV2  `json:"batch"`
+	Events []models.Event `json:"events"`
+}
+
+type batchHeaderV2 struct {
+	ID        string `json:"id"`
+	ClientID  string `json:"clientId"`
+	SessionID string `json:"sessionId"`
+	// SentAt is when the client sent the batch, RFC 3339
+	SentAt string `json:"sentAt"`
+	// Attempt counts the times the batch was sent before, 0 the first time
+	Attempt int `json:"attempt"`
+	// Checksum, when set, is the checksum of the events array as sent, e.g.
+	// xxh64:9c2f0f3a1b7d5e42
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// model converts the batch to the collector's own, which ingest works on
+func (b batchV2) model() models.EventBatch {
+	return models.EventBatch{
+		ClientID:      b.Batch.ClientID,
+		SessionID:     b.Batch.SessionID,
+		BatchID:       b.Batch.ID,
+		Events:        b.Events,
+		Timestamp:     b.Batch.SentAt,
+		IsRetry:       b.Batch.Attempt > 0,
+		SchemaVersion: schema.Current,
+	}
+}
+
+// ingestAckV2 answers a v2 batch. Status is accepted, partial when some
+// events were rejected, or duplicate when the batch was already stored.
+type ingestAckV2 struct {
+	BatchID  string                 `json:"batchId"`
+	Status   string                 `json:"status"`
+	Accepted int                    `json:"accepted"`
+	Rejected []validation.Rejection `json:"rejected"`
+	// Duplicates counts the accepted events that were already stored
+	Duplicates int `json:"duplicates"`
+	// Quarantined counts the accepted events set aside because they are
+	// outside the event taxonomy
+	Quarantined int `json:"quarantined"`
+}
+
+// errorV2 is the body of every v2 error response
+type errorV2 struct {
+	Error errorDetailV2 `json:"error"`
+}
+
+type errorDetailV2 struct {
+	// Code is stable and meant for programs; Message is for people
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	RequestID string                 `json:"requestId,omitempty"`
+	BatchID   string                 `json:"batchId,omitempty"`
+	Rejected  []validation.Rejection `json:"rejected,omitempty"`
+	Problems  []validation.Problem   `json:"problems,omitempty"`
+}
+
+// HandleEventsV2 ingests a batch sent in the v2 contract. It runs through
+// the same pipeline as v1 batches.
+func (h *EventHandler) HandleEventsV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeErrorV2(w, r, http.StatusMethodNotAllowed, errorDetailV2{Code: "method_not_allowed", Message: "Use POST"})
+		return
+	}
+
+	batch, err := decodeBatchV2(r)
+	if err != nil {
+		h.recordError(r.Context(), batch, err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeErrorV2(w, r, http.StatusRequestEntityTooLarge, errorDetailV2{
+				Code:    "body_too_large",
+				Message: fmt.Sprintf("Request body is larger than %d bytes", maxBytesErr.Limit),
+			})
+			return
+		}
+		var mismatch *checksum.MismatchError
+		if errors.As(err, &mismatch) {
+			writeErrorV2(w, r, http.StatusBadRequest, errorDetailV2{
+				Code:    checksumMismatchCode,
+				Message: "The events don't match the batch checksum, send the batch again",
+			})
+			return
+		}
+		writeErrorV2(w, r, http.StatusBadRequest, errorDetailV2{Code: "invalid_body", Message: err.Error()})
+		return
+	}
+
+	ctx := batchContext(r.Context(), batch)
+	result, err := h.ingest(ctx, batch)
+	var tooMany *validation.TooManyEventsError
+	var validationErr *validation.Error
+	var sinkErr *sinkError
+	switch {
+	case err == nil:
+		slog.DebugContext(ctx, "Received batch", "events", result.accepted, "rejected", len(result.rejected),
+			"latency", result.latency.total())
+		result.latency.setHeaders(w)
+		ack := ingestAckV2{
+			BatchID:     batch.BatchID,
+			Status:      "accepted",
+			Accepted:    result.accepted,
+			Rejected:    result.rejected,
+			Duplicates:  result.duplicates,
+			Quarantined: result.quarantined,
+		}
+		if len(result.rejected) > 0 {
+			ack.Status = "partial"
+		} else {
+			ack.Rejected = []validation.Rejection{}
+		}
+		writeJSON(w, http.StatusOK, ack)
+	case errors.Is(err, errDuplicateBatch):
+		slog.InfoContext(ctx, "Ignoring duplicate batch", "retry", batch.IsRetry)
+		writeJSON(w, http.StatusOK, ingestAckV2{
+			BatchID:  batch.BatchID,
+			Status:   "duplicate",
+			Rejected: []validation.Rejection{},
+		})
+	case errors.As(err, &tooMany):
+		writeErrorV2(w, r, http.StatusRequestEntityTooLarge, errorDetailV2{
+			Code: "too_many_events", Message: err.Error(), BatchID: batch.BatchID,
+		})
+	case errors.As(err, &validationErr):
+		rejected, _ := validationErr.Rejections()
+		writeErrorV2(w, r, http.StatusUnprocessableEntity, errorDetailV2{
+			Code:     "invalid_batch",
+			Message:  "Batch failed validation",
+			BatchID:  batch.BatchID,
+			Rejected: rejected,
+			Problems: validationErr.Problems,
+		})
+	case ctx.Err() != nil:
+		slog.WarnContext(ctx, "Request ended before the batch was stored", "error", err)
+		writeErrorV2(w, r, http.StatusServiceUnavailable, errorDetailV2{
+			Code: "cancelled", Message: "The request ended before the batch was stored, retry it", BatchID: batch.BatchID,
+		})
+	case errors.Is(err, sink.ErrUnavailable):
+		slog.WarnContext(ctx, "Sink refused batch for now", "error", err)
+		w.Header().Set("Retry-After", "1")
+		writeErrorV2(w, r, http.StatusServiceUnavailable, errorDetailV2{
+			Code: "unavailable", Message: "The batch can't be stored right now, retry it", BatchID: batch.BatchID,
+		})
+	case errors.As(err, &sinkErr):
+		slog.ErrorContext(ctx, "Error logging batch", "error", sinkErr.err)
+		writeErrorV2(w, r, http.StatusInternalServerError, errorDetailV2{
+			Code: "storage_failed", Message: "The batch could not be stored, retry it", BatchID: batch.BatchID,
+		})
+	default:
+		writeErrorV2(w, r, http.StatusBadRequest, errorDetailV2{
+			Code: "invalid_batch", Message: err.Error(), BatchID: batch.BatchID,
+		})
+	}
+}
+
+// decodeBatchV2 reads a v2 batch from the request body. Events are taken
+// as they are; v2 has no older event schemas to upgrade from.
+func decodeBatchV2(r *http.Request) (models.EventBatch, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return models.EventBatch{}, err
+	}
+	var batch batchV2
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return models.EventBatch{}, err
+	}
+	if batch.Batch.Checksum != "" {
+		var raw struct {
+			Events json.RawMessage `json:"events"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return models.EventBatch{}, err
+		}
+		if err := checksum.Verify(raw.Events, batch.Batch.Checksum); err != nil {
+			return models.EventBatch{}, err
+		}
+	}
+	for i, event := range batch.Events {
+		if event.SchemaVersion != 0 && event.SchemaVersion != schema.Current {
+			return models.EventBatch{}, fmt.Errorf("event %d: schemaVersion must be %d", i, schema.Current)
+		}
+		batch.Events[i].SchemaVersion = schema.Current
+	}
+	return batch.model(), nil
+}
+
+func writeErrorV2(w http.ResponseWriter, r *http.Request, status int, detail errorDetailV2) {
+	detail.RequestID = RequestIDFromContext(r.Context())
+	writeJSON(w, status, errorV2{Error: detail})
+}
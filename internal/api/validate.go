@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/taxonomy"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+)
+
+// validateRoute checks batches without storing them
+const validateRoute = "/api/v1/events/validate"
+
+// validationReport is the response of the validate endpoint: what ingest
+// would make of the batch
+type validationReport struct {
+	// Status is valid, partial when some events would be rejected, or
+	// invalid when the whole batch would be
+	Status   string                 `json:"status"`
+	Message  string                 `json:"message"`
+	BatchID  string                 `json:"batchId"`
+	Accepted int                    `json:"accepted"`
+	Rejected []validation.Rejection `json:"rejected"`
+	// Quarantined are the accepted events that would be quarantined for
+	// being outside the taxonomy
+	Quarantined []validation.Rejection `json:"quarantined"`
+	// Errors are problems with the batch itself, which reject all of it
+	Errors []validation.Problem `json:"errors"`
+}
+
+// HandleValidate runs a batch through the checks of ingest, body decoding,
+// timestamp normalization, validation, the event taxonomy and custom
+// dimensions, and reports the outcome. Nothing is stored, deduplicated or counted, so players can be
+// tried out against a production collector.
+func (h *EventHandler) HandleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	batch, err := decodeBatch(r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	batch.Tenant = auth.TenantFromContext(r.Context())
+	h.timestamps.Normalize(&batch, h.now())
+	writeJSON(w, http.StatusOK, h.validate(batch))
+}
+
+// validate checks batch the way the Validate stage does
+func (h *EventHandler) validate(batch models.EventBatch) validationReport {
+	err := validation.ValidateBatch(batch, h.limits)
+	var quarantine []validation.Problem
+	if h.taxonomy != nil {
+		switch h.taxonomy.Action() {
+		case taxonomy.Reject:
+			err = addProblems(err, h.taxonomy.Problems(batch))
+		case taxonomy.Quarantine:
+			quarantine = h.taxonomy.Problems(batch)
+		}
+	}
+	if h.dimensions != nil {
+		err = addProblems(err, h.dimensions.Problems(batch))
+	}
+
+	report := validationReport{
+		Status:      "valid",
+		Message:     "Batch is valid",
+		BatchID:     batch.BatchID,
+		Accepted:    len(batch.Events),
+		Rejected:    []validation.Rejection{},
+		Quarantined: []validation.Rejection{},
+		Errors:      []validation.Problem{},
+	}
+	var eventProblems []validation.Problem
+	var validationErr *validation.Error
+	if errors.As(err, &validationErr) {
+		for _, problem := range validationErr.Problems {
+			if problem.Index == validation.BatchIndex {
+				report.Errors = append(report.Errors, problem)
+			} else {
+				eventProblems = append(eventProblems, problem)
+			}
+		}
+	}
+	rejections, _ := (&validation.Error{Problems: eventProblems}).Rejections()
+	switch {
+	case len(report.Errors) > 0 || len(rejections) == len(batch.Events):
+		report.Status = "invalid"
+		report.Message = "Batch would be rejected"
+		report.Accepted = 0
+	case len(rejections) > 0:
+		report.Status = "partial"
+		report.Message = fmt.Sprintf("%d of %d events would be rejected", len(rejections), len(batch.Events))
+		report.Accepted -= len(rejections)
+	}
+	rejected := make(map[int]bool, len(rejections))
+	for _, rejection := range rejections {
+		report.Rejected = append(report.Rejected, rejection)
+		rejected[rejection.Index] = true
+	}
+	if report.Status == "invalid" {
+		return report
+	}
+
+	// Rejected events never reach the quarantine
+	quarantined, _ := (&validation.Error{Problems: quarantine}).Rejections()
+	for _, rejection := range quarantined {
+		if !rejected[rejection.Index] {
+			report.Quarantined = append(report.Quarantined, rejection)
+		}
+	}
+	return report
+}
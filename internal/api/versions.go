@@ -0,0 +1,140 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+)
+
+// The major versions of the HTTP API, served under /api/<version>
+const (
+	V1 = "v1"
+	V2 = "v2"
+)
+
+// Version statuses. A frozen version's request and response models no
+// longer change, so existing SDKs keep working; changes to the contract go
+// into a version under development, which may change without notice until
+// it is frozen.
+const (
+	VersionFrozen      = "frozen"
+	VersionDevelopment = "development"
+)
+
+// APIVersionHeader names the version that served a request
+const APIVersionHeader = "API-Version"
+
+// APIVersion describes a version of the API to clients
+type APIVersion struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Deprecation is set once the version is deprecated
+	Deprecation *Deprecation `json:"deprecation,omitempty"`
+}
+
+// Deprecation announces that a version is going away. Requests to it are
+// answered with Deprecation and Sunset headers (RFC 9745 and RFC 8594) and
+// a Link to the migration notes and the successor version.
+type Deprecation struct {
+	// At is when the version was or will be deprecated
+	At time.Time `json:"at"`
+	// Sunset is when the version will stop being served, if decided
+	Sunset time.Time `json:"sunset,omitzero"`
+	// Link points at the migration notes
+	Link string `json:"link,omitempty"`
+}
+
+// apiVersions returns the versions served with options, oldest first
+func (o routeOptions) apiVersions() []APIVersion {
+	versions := []APIVersion{{Name: V1, Status: VersionFrozen}}
+	if o.v2 {
+		versions = append(versions, APIVersion{Name: V2, Status: VersionDevelopment})
+	}
+	for i := range versions {
+		if d, ok := o.deprecations[versions[i].Name]; ok {
+			versions[i].Deprecation = &d
+		}
+	}
+	return versions
+}
+
+// IsAPIVersion reports whether name is a version of the API
+func IsAPIVersion(name string) bool {
+	return name == V1 || name == V2
+}
+
+// VersionMiddleware labels responses under /api/<version>/ with the version
+// that served them and warns callers of deprecated versions in the
+// Deprecation, Sunset and Link headers. Requests to deprecated versions are
+// counted, so operators can tell when SDKs have moved on.
+func VersionMiddleware(versions []APIVersion, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := pathVersion(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		i := slices.IndexFunc(versions, func(v APIVersion) bool { return v.Name == name })
+		if i < 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set(APIVersionHeader, name)
+		if d := versions[i].Deprecation; d != nil {
+			header.Set("Deprecation", fmt.Sprintf("@%d", d.At.Unix()))
+			if !d.Sunset.IsZero() {
+				header.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if d.Link != "" {
+				header.Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, d.Link))
+			}
+			if successor := successorVersion(versions, i); successor != "" {
+				header.Add("Link", fmt.Sprintf(`</api/%s>; rel="successor-version"`, successor))
+			}
+			metrics.DeprecatedRequests.WithLabelValues(name).Inc()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// pathVersion returns the version of an /api/<version>/ path
+func pathVersion(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/api/")
+	if !ok {
+		return "", false
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	return name, IsAPIVersion(name)
+}
+
+// successorVersion returns the oldest version after versions[i] that is not
+// deprecated itself, or ""
+func successorVersion(versions []APIVersion, i int) string {
+	for _, v := range versions[i+1:] {
+		if v.Deprecation == nil {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+// VersionsHandler serves the API versions at /api/versions
+type VersionsHandler struct {
+	versions []APIVersion
+}
+
+func NewVersionsHandler(versions []APIVersion) *VersionsHandler {
+	return &VersionsHandler{versions: versions}
+}
+
+// HandleListVersions lists the versions of the API with their status and
+// deprecation, so SDKs can check theirs is still supported
+func (h *VersionsHandler) HandleListVersions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"versions": h.versions})
+}
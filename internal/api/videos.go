@@ -0,0 +1,44 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/adtyap26/event-stream-video/internal/analytics"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// VideoHandler serves aggregated viewing stats of videos
+type VideoHandler struct {
+	tracker *session.Tracker
+	stats   *analytics.StatsStore
+}
+
+func NewVideoHandler(tracker *session.Tracker, stats *analytics.StatsStore) *VideoHandler {
+	return &VideoHandler{tracker: tracker, stats: stats}
+}
+
+// HandleGetStats returns the stats of the video named in the path over the
+// window given as a duration in the window query parameter, e.g. ?window=1h.
+// The window defaults to, and is capped at, the store's retention period.
+func (h *VideoHandler) HandleGetStats(w http.ResponseWriter, r *http.Request) {
+	window, ok := queryWindow(w, r, h.stats.Retention())
+	if !ok {
+		return
+	}
+
+	stats := h.stats.Query(auth.TenantFromContext(r.Context()), r.PathValue("id"), window, h.tracker.Active())
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// HandleGetTenantStats returns the stats of all the caller's videos over the
+// window query parameter, like HandleGetStats does one
+func (h *VideoHandler) HandleGetTenantStats(w http.ResponseWriter, r *http.Request) {
+	window, ok := queryWindow(w, r, h.stats.Retention())
+	if !ok {
+		return
+	}
+
+	stats := h.stats.QueryTenant(auth.TenantFromContext(r.Context()), window, h.tracker.Active())
+	writeJSON(w, http.StatusOK, stats)
+}
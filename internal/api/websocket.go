@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/adtyap26/event-stream-video/internal/checksum"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/tracing"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+)
+
+// batchAck is sent back over the WebSocket for every batch frame
+type batchAck struct {
+	Type    string `json:"type"`
+	BatchID string `json:"batchId"`
+	Status  string `json:"status"`
+	// Code is set on errors SDKs act on, such as checksumMismatchCode
+	Code            string                 `json:"code,omitempty"`
+	Message         string                 `json:"message,omitempty"`
+	Accepted        int                    `json:"accepted"`
+	Rejected        []validation.Rejection `json:"rejected,omitempty"`
+	DuplicateEvents int                    `json:"duplicateEvents,omitempty"`
+	Errors          []validation.Problem   `json:"errors,omitempty"`
+	// ProcessingTime is the milliseconds the batch took from arriving to
+	// being stored, as ProcessingTimeHeader reports it for POSTed batches
+	ProcessingTime float64 `json:"processingTime,omitempty"`
+}
+
+// HandleWebSocket accepts a WebSocket connection and reads EventBatch JSON
+// frames from it. Each batch goes through the same pipeline as POSTed batches
+// and is acknowledged with its batchId.
+func (h *EventHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		// Origins are governed by the CORS policy, same as the POST endpoints
+		OriginPatterns: []string{"*"},
+	})
+	if err != nil {
+		slog.WarnContext(r.Context(), "Error accepting WebSocket", "error", err)
+		return
+	}
+	defer conn.CloseNow()
+
+	if h.maxFrameSize > 0 {
+		conn.SetReadLimit(h.maxFrameSize)
+	}
+
+	ctx := r.Context()
+	for {
+		msgType, data, err := conn.Read(ctx)
+		if err != nil {
+			switch websocket.CloseStatus(err) {
+			case websocket.StatusNormalClosure, websocket.StatusGoingAway:
+			default:
+				if ctx.Err() == nil {
+					slog.WarnContext(ctx, "WebSocket read error", "error", err)
+				}
+			}
+			return
+		}
+
+		ack := h.handleFrame(ctx, msgType, data)
+		if err := wsjson.Write(ctx, conn, ack); err != nil {
+			slog.WarnContext(ctx, "WebSocket write error", "error", err)
+			return
+		}
+	}
+}
+
+// handleFrame ingests one WebSocket frame and builds its acknowledgement
+func (h *EventHandler) handleFrame(ctx context.Context, msgType websocket.MessageType, data []byte) batchAck {
+	ctx = withReceived(ctx, time.Now())
+	if msgType != websocket.MessageText {
+		return batchAck{Type: "ack", Status: "error", Message: "Expected a JSON text frame"}
+	}
+
+	batch, err := schema.DecodeBatch(data)
+	if err != nil {
+		h.recordError(ctx, models.EventBatch{}, err)
+		var mismatch *checksum.MismatchError
+		if errors.As(err, &mismatch) {
+			return batchAck{Type: "ack", Status: "error", Code: checksumMismatchCode,
+				Message: "The events don't match the batch checksum, send the batch again"}
+		}
+		return batchAck{Type: "ack", Status: "error", Message: "Invalid batch"}
+	}
+
+	ack := batchAck{Type: "ack", BatchID: batch.BatchID}
+
+	// Every frame is a batch of its own; its span is a child of the
+	// connection's request span
+	ctx, span := tracing.Start(ctx, "websocket.frame")
+	defer span.End()
+
+	ctx = batchContext(ctx, batch)
+	result, err := h.ingest(ctx, batch)
+	var validationErr *validation.Error
+	var sinkErr *sinkError
+	switch {
+	case err == nil && len(result.rejected) > 0:
+		slog.DebugContext(ctx, "Received WebSocket batch", "events", result.accepted, "rejected", len(result.rejected))
+		ack.Status = "partial"
+		ack.Message = fmt.Sprintf("Accepted %d of %d events", result.accepted, len(batch.Events))
+		ack.Accepted = result.accepted
+		ack.Rejected = result.rejected
+		ack.DuplicateEvents = result.duplicates
+		ack.ProcessingTime = float64(result.latency.total().Microseconds()) / 1000
+	case err == nil:
+		slog.DebugContext(ctx, "Received WebSocket batch", "events", result.accepted)
+		ack.Status = "success"
+		ack.Message = fmt.Sprintf("Accepted %d events", result.accepted)
+		ack.Accepted = result.accepted
+		ack.DuplicateEvents = result.duplicates
+		ack.ProcessingTime = float64(result.latency.total().Microseconds()) / 1000
+	case errors.Is(err, errDuplicateBatch):
+		ack.Status = "duplicate"
+		ack.Message = "Duplicate batch ignored"
+	case errors.As(err, &validationErr):
+		ack.Status = "error"
+		ack.Message = "Batch failed validation"
+		ack.Rejected, _ = validationErr.Rejections()
+		ack.Errors = validationErr.Problems
+	case errors.Is(err, sink.ErrUnavailable):
+		slog.WarnContext(ctx, "Sink refused WebSocket batch for now", "error", err)
+		ack.Status = "error"
+		ack.Message = "Service unavailable, send the batch again"
+	case errors.As(err, &sinkErr):
+		slog.ErrorContext(ctx, "Error logging WebSocket batch", "error", sinkErr.err)
+		ack.Status = "error"
+		ack.Message = "Internal server error"
+	default:
+		ack.Status = "error"
+		ack.Message = err.Error()
+	}
+	return ack
+}
@@ -0,0 +1,371 @@
+// Package archive moves old event files to cold storage. Rotated file sink
+// logs and Parquet partition files are uploaded to an S3 or GCS bucket in a
+// cold storage class once they are old enough, then removed locally, and
+// deleted from the bucket once they are older still. A manifest in the
+// bucket lists what is archived, for cmd/replay to find it again.
+//
+// Erasure only rewrites local files: archived files keep the events of
+// users erased after they were archived until they are deleted. Copies
+// counts them, for erasure jobs to report the copies still pending.
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+)
+
+// Sources of files to archive
+const (
+	// SourceLogs is the rotated logs of the file sink
+	SourceLogs = "logs"
+	// SourceParquet is the files of the Parquet sink's date partitions
+	SourceParquet = "parquet"
+)
+
+// Config configures archival
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Provider is "s3" or "gcs"
+	Provider string `yaml:"provider"`
+	Bucket   string `yaml:"bucket"`
+	// Prefix is prepended to every object key. Collectors archiving to the
+	// same bucket need prefixes of their own, as each keeps one manifest.
+	Prefix string `yaml:"prefix"`
+	// Region and Endpoint are used by the S3 provider. Endpoint allows
+	// S3-compatible stores such as MinIO.
+	Region   string `yaml:"region"`
+	Endpoint string `yaml:"endpoint"`
+	// StorageClass of archived files, GLACIER for S3 and COLDLINE for GCS
+	// when unset
+	StorageClass string `yaml:"storageClass"`
+
+	// Sources are the files archived: logs, parquet or both
+	Sources []string `yaml:"sources"`
+	// After is how long after it was last written a file is archived
+	After time.Duration `yaml:"after"`
+	// DeleteAfter is how long after it was last written an archived file is
+	// deleted, 0 to keep archived files
+	DeleteAfter time.Duration `yaml:"deleteAfter"`
+	// Interval is how often files are looked for
+	Interval time.Duration `yaml:"interval"`
+}
+
+// DefaultConfig archives logs after 30 days and deletes them after a year
+func DefaultConfig() Config {
+	return Config{
+		Provider:    "s3",
+		Prefix:      "archive",
+		Sources:     []string{SourceLogs},
+		After:       30 * 24 * time.Hour,
+		DeleteAfter: 365 * 24 * time.Hour,
+		Interval:    time.Hour,
+	}
+}
+
+// Validate checks the bucket, sources and ages of enabled archival
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Bucket == "" {
+		return errors.New("archive bucket is required")
+	}
+	if c.Provider != "s3" && c.Provider != "gcs" {
+		return fmt.Errorf("unknown archive provider %q", c.Provider)
+	}
+	if len(c.Sources) == 0 {
+		return errors.New("archive needs at least one source")
+	}
+	for _, source := range c.Sources {
+		if source != SourceLogs && source != SourceParquet {
+			return fmt.Errorf("unknown archive source %q, must be %s or %s", source, SourceLogs, SourceParquet)
+		}
+	}
+	if c.After <= 0 {
+		return fmt.Errorf("invalid archive after %v, must be positive", c.After)
+	}
+	if c.DeleteAfter != 0 && c.DeleteAfter <= c.After {
+		return fmt.Errorf("archive deleteAfter %v must be longer than after %v", c.DeleteAfter, c.After)
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("invalid archive interval %v, must be positive", c.Interval)
+	}
+	return nil
+}
+
+// Source is a directory whose files are archived
+type Source struct {
+	Name string
+	Dir  string
+	// Match reports whether a file of the directory, or of one below it, is
+	// finished and can be archived
+	Match func(name string) bool
+}
+
+// Archiver periodically archives and deletes the files of its sources
+type Archiver struct {
+	cfg     Config
+	store   Store
+	sources []Source
+	now     func() time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New starts archiving the files of sources to store
+func New(cfg Config, store Store, sources []Source) *Archiver {
+	defaults := DefaultConfig()
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaults.Interval
+	}
+	if cfg.StorageClass == "" {
+		cfg.StorageClass = DefaultStorageClass(cfg.Provider)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Archiver{
+		cfg:     cfg,
+		store:   store,
+		sources: sources,
+		now:     time.Now,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go a.run(ctx)
+	return a
+}
+
+func (a *Archiver) run(ctx context.Context) {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Failed to archive event files", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archivedFile is a local file uploaded by a run
+type archivedFile struct {
+	source Source
+	path   string
+}
+
+// RunOnce archives the files old enough to be and deletes the archived ones
+// old enough to be. Local files are removed only once the manifest lists
+// them, so files of a run that failed are uploaded again by the next one.
+func (a *Archiver) RunOnce(ctx context.Context) error {
+	manifest, err := LoadManifest(ctx, a.store, a.cfg.Prefix)
+	if err != nil {
+		return err
+	}
+
+	now := a.now()
+	archiveBefore := now.Add(-a.cfg.After)
+	var deleteBefore time.Time
+	if a.cfg.DeleteAfter > 0 {
+		deleteBefore = now.Add(-a.cfg.DeleteAfter)
+	}
+
+	changed := false
+	var archived []archivedFile
+	for _, source := range a.sources {
+		files, err := a.oldFiles(source, archiveBefore)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if file.modifiedAt.Before(deleteBefore) {
+				// Past its deletion before it was ever archived
+				if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				removeEmptyDirs(source.Dir, filepath.Dir(file.path))
+				metrics.ArchiveFiles.WithLabelValues(source.Name, "deleted").Inc()
+				continue
+			}
+			entry, err := a.upload(ctx, source, file)
+			if err != nil {
+				slog.Error("Failed to archive event file", "file", file.path, "error", err)
+				metrics.ArchiveFiles.WithLabelValues(source.Name, "failed").Inc()
+				continue
+			}
+			manifest.add(entry)
+			archived = append(archived, archivedFile{source: source, path: file.path})
+			changed = true
+		}
+	}
+
+	if !deleteBefore.IsZero() {
+		kept := manifest.Files[:0]
+		for _, entry := range manifest.Files {
+			if !entry.ModifiedAt.Before(deleteBefore) {
+				kept = append(kept, entry)
+				continue
+			}
+			if err := a.store.Delete(ctx, entry.Key); err != nil && !errors.Is(err, ErrNotFound) {
+				slog.Error("Failed to delete archived event file", "key", entry.Key, "error", err)
+				kept = append(kept, entry)
+				continue
+			}
+			metrics.ArchiveFiles.WithLabelValues(entry.Source, "deleted").Inc()
+			changed = true
+		}
+		manifest.Files = kept
+	}
+
+	if !changed {
+		return nil
+	}
+	if err := SaveManifest(ctx, a.store, a.cfg.Prefix, manifest); err != nil {
+		return err
+	}
+	for _, file := range archived {
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to remove archived event file", "file", file.path, "error", err)
+			continue
+		}
+		removeEmptyDirs(file.source.Dir, filepath.Dir(file.path))
+		metrics.ArchiveFiles.WithLabelValues(file.source.Name, "archived").Inc()
+	}
+	if len(archived) > 0 {
+		slog.Info("Archived event files", "files", len(archived), "bucket", a.cfg.Bucket)
+	}
+	return nil
+}
+
+// Copies counts the files archived before before that may hold events of
+// tenant, named as its directories are (tenant.SafeName), and returns when
+// the last of them is deleted, zero when archived files are kept. Files of
+// sources without tenant directories may hold the events of any tenant.
+func (a *Archiver) Copies(ctx context.Context, tenant string, before time.Time) (int, time.Time, error) {
+	manifest, err := LoadManifest(ctx, a.store, a.cfg.Prefix)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	files := 0
+	var last time.Time
+	for _, entry := range manifest.Files {
+		if !entry.ArchivedAt.Before(before) {
+			continue
+		}
+		if dir := entry.tenantDir(); dir != "" && dir != tenant {
+			continue
+		}
+		files++
+		if entry.ModifiedAt.After(last) {
+			last = entry.ModifiedAt
+		}
+	}
+	if files == 0 || a.cfg.DeleteAfter == 0 {
+		return files, time.Time{}, nil
+	}
+	return files, last.Add(a.cfg.DeleteAfter), nil
+}
+
+// localFile is a file of a source
+type localFile struct {
+	path       string
+	size       int64
+	modifiedAt time.Time
+}
+
+// oldFiles lists the files of source last written before before, oldest
+// first
+func (a *Archiver) oldFiles(source Source, before time.Time) ([]localFile, error) {
+	var files []localFile
+	err := filepath.WalkDir(source.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == source.Dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || !source.Match(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if info.ModTime().Before(before) {
+			files = append(files, localFile{path: p, size: info.Size(), modifiedAt: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s files to archive: %w", source.Name, err)
+	}
+	slices.SortFunc(files, func(x, y localFile) int { return x.modifiedAt.Compare(y.modifiedAt) })
+	return files, nil
+}
+
+// upload writes file to the store under its path in source
+func (a *Archiver) upload(ctx context.Context, source Source, file localFile) (Entry, error) {
+	rel, err := filepath.Rel(source.Dir, file.path)
+	if err != nil {
+		return Entry{}, err
+	}
+	rel = filepath.ToSlash(rel)
+	key := path.Join(a.cfg.Prefix, source.Name, rel)
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer f.Close()
+	if err := a.store.Put(ctx, key, f, a.cfg.StorageClass); err != nil {
+		return Entry{}, fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return Entry{
+		Source:       source.Name,
+		Path:         rel,
+		Key:          key,
+		Size:         file.size,
+		ModifiedAt:   file.modifiedAt.UTC(),
+		ArchivedAt:   a.now().UTC(),
+		StorageClass: a.cfg.StorageClass,
+	}, nil
+}
+
+// removeEmptyDirs removes dir and its parents up to, but not including,
+// root while they are empty, such as Parquet partitions whose files were
+// all archived
+func removeEmptyDirs(root, dir string) {
+	for dir != root && len(dir) > len(root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// Close stops archiving, cancelling a run in progress
+func (a *Archiver) Close() {
+	a.cancel()
+	<-a.done
+}
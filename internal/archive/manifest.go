@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// manifestName is the object listing the archived files, under the prefix
+const manifestName = "manifest.json"
+
+// Manifest lists the files in the archive, oldest first
+type Manifest struct {
+	Files []Entry `json:"files"`
+}
+
+// Entry is an archived file
+type Entry struct {
+	Source string `json:"source"`
+	// Path is where the file was in its source's directory, such as
+	// acme/events-2026-10-14-08-00-00.ndjson.gz
+	Path string `json:"path"`
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	// ModifiedAt is when the file was last written, which its age is
+	// counted from
+	ModifiedAt   time.Time `json:"modifiedAt"`
+	ArchivedAt   time.Time `json:"archivedAt"`
+	StorageClass string    `json:"storageClass,omitempty"`
+}
+
+// tenantDir returns the tenant whose directory the file was in, as the
+// tenant router lays them out, or "" when it wasn't in one
+func (e Entry) tenantDir() string {
+	dir, _, ok := strings.Cut(e.Path, "/")
+	if !ok {
+		return ""
+	}
+	switch e.Source {
+	case SourceLogs:
+		return dir
+	case SourceParquet:
+		if tenant, ok := strings.CutPrefix(dir, "tenant="); ok {
+			return tenant
+		}
+	}
+	return ""
+}
+
+// add records entry, replacing an earlier upload of the same file
+func (m *Manifest) add(entry Entry) {
+	m.Files = slices.DeleteFunc(m.Files, func(e Entry) bool { return e.Key == entry.Key })
+	m.Files = append(m.Files, entry)
+	slices.SortStableFunc(m.Files, func(a, b Entry) int { return a.ModifiedAt.Compare(b.ModifiedAt) })
+}
+
+// Of returns the entries of source, oldest first
+func (m Manifest) Of(source string) []Entry {
+	var entries []Entry
+	for _, entry := range m.Files {
+		if entry.Source == source {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// LoadManifest reads the manifest of the archive under prefix, which is
+// empty before anything was archived
+func LoadManifest(ctx context.Context, store Store, prefix string) (Manifest, error) {
+	body, err := store.Get(ctx, path.Join(prefix, manifestName))
+	if errors.Is(err, ErrNotFound) {
+		return Manifest{}, nil
+	}
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read archive manifest: %w", err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read archive manifest: %w", err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse archive manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// SaveManifest replaces the manifest of the archive under prefix. It is
+// kept in the bucket's default storage class, to stay readable at once.
+func SaveManifest(ctx context.Context, store Store, prefix string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := store.Put(ctx, path.Join(prefix, manifestName), bytes.NewReader(data), ""); err != nil {
+		return fmt.Errorf("failed to write archive manifest: %w", err)
+	}
+	return nil
+}
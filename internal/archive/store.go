@@ -0,0 +1,48 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrNotFound is returned for objects that aren't in the bucket
+	ErrNotFound = errors.New("archived object not found")
+	// ErrRestoring is returned for objects that have to be restored from
+	// cold storage before they can be read. The restore has been requested;
+	// the object is readable once it completes, hours later for Glacier.
+	ErrRestoring = errors.New("archived object is being restored from cold storage")
+)
+
+// Store is the bucket of an archive
+type Store interface {
+	// Put writes body to key in storageClass, or in the bucket's default
+	// class when storageClass is empty
+	Put(ctx context.Context, key string, body io.Reader, storageClass string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// DefaultStorageClass is the cold storage class of provider
+func DefaultStorageClass(provider string) string {
+	if provider == "gcs" {
+		return "COLDLINE"
+	}
+	return "GLACIER"
+}
+
+// NewStore connects to the bucket of cfg
+func NewStore(ctx context.Context, cfg Config) (Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("archive bucket is required")
+	}
+	switch cfg.Provider {
+	case "", "s3":
+		return NewS3Store(ctx, cfg)
+	case "gcs":
+		return NewGCSStore(ctx, cfg)
+	}
+	return nil, fmt.Errorf("unknown archive provider %q", cfg.Provider)
+}
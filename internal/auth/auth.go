@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+)
+
+// DefaultTenant owns requests whose API key has no tenant, and every request
+// when authentication is disabled
+const DefaultTenant = "default"
+
+// ErrUnknownKey is returned by a KeyStore when an API key is not registered
+var ErrUnknownKey = errors.New("unknown API key")
+
+// Client is the identity an API key resolves to
+type Client struct {
+	Tenant   string `json:"tenant"`
+	ClientID string `json:"clientId"`
+}
+
+// KeyStore resolves API keys to clients
+type KeyStore interface {
+	// Lookup returns the client for apiKey, or ErrUnknownKey
+	Lookup(ctx context.Context, apiKey string) (Client, error)
+}
+
+// KeyManager is a KeyStore whose keys can be changed at runtime
+type KeyManager interface {
+	KeyStore
+	Keys() map[string]Client
+	Set(apiKey string, client Client)
+	Delete(apiKey string)
+	// Save persists the current keys, if the store is backed by storage
+	Save() error
+	// Persistent reports whether Save keeps the keys across restarts
+	Persistent() bool
+}
+
+// NewAPIKey generates a random API key
+func NewAPIKey() string {
+	return rand.Text()
+}
+
+type clientKey struct{}
+
+// WithClient returns a copy of ctx carrying the resolved client
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFromContext returns the client attached by the auth middleware
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	client, ok := ctx.Value(clientKey{}).(Client)
+	return client, ok
+}
+
+// TenantFromContext returns the tenant of the authenticated client, or
+// DefaultTenant when there is none
+func TenantFromContext(ctx context.Context) string {
+	if client, ok := ClientFromContext(ctx); ok && client.Tenant != "" {
+		return client.Tenant
+	}
+	return DefaultTenant
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"crypto/tls"
+	"maps"
+)
+
+// Certificates resolves verified client certificates to clients by their
+// subject common name, for server-to-server callers authenticating with
+// mTLS instead of API keys
+type Certificates struct {
+	tenants map[string]string
+}
+
+// NewCertificates creates a resolver from a map of common name to tenant
+func NewCertificates(tenants map[string]string) *Certificates {
+	return &Certificates{tenants: maps.Clone(tenants)}
+}
+
+// Lookup returns the client of the certificate state presented, which the
+// TLS handshake verified against the client CAs. The client ID is the
+// common name. It reports false without a verified certificate or when the
+// common name isn't mapped to a tenant.
+func (c *Certificates) Lookup(state *tls.ConnectionState) (Client, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return Client{}, false
+	}
+	name := state.VerifiedChains[0][0].Subject.CommonName
+	tenant, ok := c.tenants[name]
+	if !ok || name == "" {
+		return Client{}, false
+	}
+	return Client{Tenant: tenant, ClientID: name}, true
+}
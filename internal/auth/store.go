@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var _ KeyManager = (*StaticStore)(nil)
+
+// StaticStore is an in-memory KeyStore
+type StaticStore struct {
+	mu   sync.RWMutex
+	keys map[string]Client
+	// path is the key file the store was loaded from, written back by Save
+	path string
+}
+
+// NewStaticStore creates a StaticStore from a map of API key to client
+func NewStaticStore(keys map[string]Client) *StaticStore {
+	copied := make(map[string]Client, len(keys))
+	for key, client := range keys {
+		copied[key] = client
+	}
+	return &StaticStore{keys: copied}
+}
+
+func (s *StaticStore) Lookup(_ context.Context, apiKey string) (Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client, ok := s.keys[apiKey]
+	if !ok {
+		return Client{}, ErrUnknownKey
+	}
+	return client, nil
+}
+
+// Set registers or replaces an API key
+func (s *StaticStore) Set(apiKey string, client Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[apiKey] = client
+}
+
+// Delete removes an API key
+func (s *StaticStore) Delete(apiKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, apiKey)
+}
+
+// Keys returns a copy of all API keys and their clients
+func (s *StaticStore) Keys() map[string]Client {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	copied := make(map[string]Client, len(s.keys))
+	for key, client := range s.keys {
+		copied[key] = client
+	}
+	return copied
+}
+
+// Persistent reports whether Save writes the keys anywhere
+func (s *StaticStore) Persistent() bool {
+	return s.path != ""
+}
+
+// Save writes the keys back to the file the store was loaded from, so
+// changes survive a restart. Stores not loaded with LoadFileStore only
+// live in memory and Save does nothing.
+func (s *StaticStore) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	var file keyFile
+	for key, client := range s.Keys() {
+		file.Keys = append(file.Keys, keyEntry{Key: key, Tenant: client.Tenant, ClientID: client.ClientID})
+	}
+	slices.SortFunc(file.Keys, func(a, b keyEntry) int {
+		return cmp.Or(strings.Compare(a.Tenant, b.Tenant), strings.Compare(a.ClientID, b.ClientID), strings.Compare(a.Key, b.Key))
+	})
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write a temporary file and rename it so a crash never leaves a
+	// truncated key file behind
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write API key file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write API key file: %w", err)
+	}
+	return nil
+}
+
+// keyFile is the on-disk format read by LoadFileStore
+type keyFile struct {
+	Keys []keyEntry `json:"keys"`
+}
+
+type keyEntry struct {
+	Key      string `json:"key"`
+	Tenant   string `json:"tenant"`
+	ClientID string `json:"clientId"`
+}
+
+// LoadFileStore reads API keys from a JSON file of the form
+//
+//	{"keys": [{"key": "abc", "tenant": "acme", "clientId": "web"}]}
+func LoadFileStore(path string) (*StaticStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse API key file: %w", err)
+	}
+
+	keys := make(map[string]Client, len(file.Keys))
+	for _, entry := range file.Keys {
+		if entry.Key == "" {
+			return nil, errors.New("API key file contains an empty key")
+		}
+		keys[entry.Key] = Client{Tenant: entry.Tenant, ClientID: entry.ClientID}
+	}
+	store := NewStaticStore(keys)
+	store.path = path
+	return store, nil
+}
+
+// ParseKeyList builds a StaticStore from a comma separated list of
+// key[:tenant[:clientId]] entries, as used by the ESV_API_KEYS environment variable
+func ParseKeyList(list string) (*StaticStore, error) {
+	keys := make(map[string]Client)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid API key entry %q", entry)
+		}
+
+		client := Client{}
+		if len(parts) > 1 {
+			client.Tenant = parts[1]
+		}
+		if len(parts) > 2 {
+			client.ClientID = parts[2]
+		}
+		keys[parts[0]] = client
+	}
+	return NewStaticStore(keys), nil
+}
+
+// DefaultKeyQuery is the query used by SQLStore when none is given. It
+// must return the tenant and client ID for the key passed as its only argument.
+const DefaultKeyQuery = "SELECT tenant, client_id FROM api_keys WHERE api_key = $1 AND revoked = FALSE"
+
+// SQLStore looks API keys up in a database table
+type SQLStore struct {
+	db    *sql.DB
+	query string
+}
+
+// NewSQLStore creates a KeyStore backed by db. An empty query uses DefaultKeyQuery.
+func NewSQLStore(db *sql.DB, query string) *SQLStore {
+	if query == "" {
+		query = DefaultKeyQuery
+	}
+	return &SQLStore{db: db, query: query}
+}
+
+func (s *SQLStore) Lookup(ctx context.Context, apiKey string) (Client, error) {
+	var client Client
+	err := s.db.QueryRowContext(ctx, s.query, apiKey).Scan(&client.Tenant, &client.ClientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Client{}, ErrUnknownKey
+	}
+	if err != nil {
+		return Client{}, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	return client, nil
+}
+
+// NewKeyStore builds a KeyStore from a key file or a key list, preferring
+// the file. It returns nil when neither is set, which disables authentication.
+func NewKeyStore(keysFile, keyList string) (KeyStore, error) {
+	if keysFile != "" {
+		return LoadFileStore(keysFile)
+	}
+	if keyList != "" {
+		return ParseKeyList(keyList)
+	}
+	return nil, nil
+}
@@ -0,0 +1,290 @@
+// Package bots recognizes events sent by crawlers, headless browsers and
+// clients in datacenters, so they don't inflate the analytics of embedded
+// players
+package bots
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/mssola/useragent"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// Action is what happens to the events of a bot
+type Action string
+
+const (
+	// Flag stores the events with isBot set
+	Flag Action = "flag"
+	// Drop leaves the events out of the batch
+	Drop Action = "drop"
+)
+
+// Reasons an event is taken for a bot's, as reported in metrics and
+// Event.BotReason
+const (
+	ReasonUserAgent  = "userAgent"
+	ReasonHeadless   = "headless"
+	ReasonDatacenter = "datacenter"
+)
+
+// defaultUserAgents are substrings, compared case-insensitively, of the user
+// agents of crawlers and HTTP libraries that useragent.Bot misses
+var defaultUserAgents = []string{
+	"bot", "crawler", "spider", "slurp", "facebookexternalhit", "embedly",
+	"python-requests", "python-urllib", "go-http-client", "okhttp", "wget",
+	"libwww-perl", "java/", "httpclient", "scrapy",
+}
+
+// headlessUserAgents are substrings of the user agents of headless and
+// automated browsers
+var headlessUserAgents = []string{
+	"headlesschrome", "phantomjs", "puppeteer", "playwright", "selenium",
+	"webdriver", "lighthouse",
+}
+
+// Config configures bot filtering
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Action is flag or drop
+	Action Action `yaml:"action"`
+
+	// UserAgents are more user agent substrings, compared
+	// case-insensitively, that mark a bot
+	UserAgents []string `yaml:"userAgents"`
+	// DefaultUserAgents matches the built-in lists of crawler and headless
+	// browser user agents
+	DefaultUserAgents bool `yaml:"defaultUserAgents"`
+
+	// DatacenterRanges are CIDR ranges of hosting providers; requests from
+	// them rarely come from people
+	DatacenterRanges []string `yaml:"datacenterRanges"`
+	// DatacenterRangesFile holds more ranges, one per line; # starts a
+	// comment
+	DatacenterRangesFile string `yaml:"datacenterRangesFile"`
+	// DatacenterASNs are autonomous systems of hosting providers. They are
+	// matched against the ASN enrichment adds, which needs an ASN database.
+	DatacenterASNs []uint `yaml:"datacenterAsns"`
+}
+
+// DefaultConfig flags events from known crawlers and headless browsers
+func DefaultConfig() Config {
+	return Config{Action: Flag, DefaultUserAgents: true}
+}
+
+// Validate checks the action and the ranges listed in the config; the
+// ranges file is read by New
+func (c Config) Validate() error {
+	switch c.Action {
+	case "", Flag, Drop:
+	default:
+		return fmt.Errorf("unknown bot action %q, must be flag or drop", c.Action)
+	}
+	for _, r := range c.DatacenterRanges {
+		if _, err := parseRange(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filter marks or drops the events of bots. It is safe for concurrent use.
+type Filter struct {
+	action     Action
+	userAgents []string
+	headless   []string
+	// builtin also asks the user agent parser
+	builtin bool
+	ranges  []netip.Prefix
+	asns    map[uint]bool
+}
+
+// New builds the filter of cfg, reading the ranges file. It returns nil
+// when filtering is disabled.
+func New(cfg Config) (*Filter, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	f := &Filter{action: cfg.Action, asns: make(map[uint]bool, len(cfg.DatacenterASNs))}
+	if f.action == "" {
+		f.action = Flag
+	}
+
+	for _, ua := range cfg.UserAgents {
+		if ua = strings.ToLower(strings.TrimSpace(ua)); ua != "" {
+			f.userAgents = append(f.userAgents, ua)
+		}
+	}
+	if cfg.DefaultUserAgents {
+		f.userAgents = append(f.userAgents, defaultUserAgents...)
+		f.headless = headlessUserAgents
+		f.builtin = true
+	}
+
+	ranges := slices.Clone(cfg.DatacenterRanges)
+	if cfg.DatacenterRangesFile != "" {
+		fileRanges, err := readRanges(cfg.DatacenterRangesFile)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, fileRanges...)
+	}
+	for _, r := range ranges {
+		prefix, err := parseRange(r)
+		if err != nil {
+			return nil, err
+		}
+		f.ranges = append(f.ranges, prefix)
+	}
+	for _, asn := range cfg.DatacenterASNs {
+		f.asns[asn] = true
+	}
+	return f, nil
+}
+
+// Filter sets IsBot and BotReason on every event of batch, which came from
+// clientIP, and leaves the bots' events out when the action is drop. Events
+// without a user agent are judged by the request's userAgent. Values the
+// client sent are replaced.
+func (f *Filter) Filter(batch *models.EventBatch, clientIP, userAgent string) {
+	fromDatacenter := f.inDatacenter(clientIP)
+	reasons := make(map[string]string)
+
+	// A new slice, since the caller's batch shares the old one
+	kept := make([]models.Event, 0, len(batch.Events))
+	for _, event := range batch.Events {
+		ua := userAgent
+		if event.Technical != nil && event.Technical.UserAgent != "" {
+			ua = event.Technical.UserAgent
+		}
+		reason, ok := reasons[ua]
+		if !ok {
+			reason = f.userAgentReason(ua)
+			reasons[ua] = reason
+		}
+		if reason == "" {
+			reason = f.eventReason(event, fromDatacenter)
+		}
+
+		event.IsBot, event.BotReason = false, ""
+		if reason == "" {
+			kept = append(kept, event)
+			continue
+		}
+		metrics.BotEvents.WithLabelValues(batch.Tenant, reason, string(f.action)).Inc()
+		if f.action == Flag {
+			event.IsBot, event.BotReason = true, reason
+			kept = append(kept, event)
+		}
+	}
+	batch.Events = kept
+}
+
+// userAgentReason returns why ua is a bot's, or ""
+func (f *Filter) userAgentReason(ua string) string {
+	if ua == "" {
+		return ""
+	}
+	lower := strings.ToLower(ua)
+	switch {
+	case containsAny(lower, f.headless):
+		return ReasonHeadless
+	case containsAny(lower, f.userAgents), f.builtin && useragent.New(ua).Bot():
+		return ReasonUserAgent
+	}
+	return ""
+}
+
+// eventReason returns why event is a bot's judging by what the player and
+// enrichment tell about the client, or ""
+func (f *Filter) eventReason(event models.Event, fromDatacenter bool) string {
+	if event.Technical != nil {
+		// navigator.webdriver is set in browsers under automation
+		if webdriver, _ := event.Technical.Extra["webdriver"].(bool); webdriver {
+			return ReasonHeadless
+		}
+	}
+	if fromDatacenter {
+		return ReasonDatacenter
+	}
+	if event.Context != nil && event.Context.Geo != nil && f.asns[event.Context.Geo.ASN] {
+		return ReasonDatacenter
+	}
+	return ""
+}
+
+// inDatacenter reports whether ip is in one of the datacenter ranges
+func (f *Filter) inDatacenter(ip string) bool {
+	if len(f.ranges) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range f.ranges {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses a CIDR range or a single address
+func parseRange(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid datacenter range %q: %w", s, err)
+		}
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid datacenter range %q: %w", s, err)
+	}
+	return prefix.Masked(), nil
+}
+
+// readRanges reads the ranges of a file, skipping blank lines and comments
+func readRanges(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open datacenter ranges: %w", err)
+	}
+	defer file.Close()
+
+	var ranges []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			ranges = append(ranges, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read datacenter ranges: %w", err)
+	}
+	return ranges, nil
+}
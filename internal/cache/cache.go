@@ -0,0 +1,169 @@
+// Package cache keeps the responses of aggregation endpoints for a few
+// seconds, so dashboards refreshing the same views every few seconds don't
+// each scan the same windows of data. Identical requests arriving while a
+// response is being computed wait for it instead of computing it again.
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults
+const (
+	DefaultTTL        = 10 * time.Second
+	DefaultMaxEntries = 10000
+)
+
+// Outcome tells how a response was obtained, for metrics and the X-Cache
+// header
+type Outcome string
+
+const (
+	// Hit responses were stored
+	Hit Outcome = "hit"
+	// Coalesced responses were being computed for another request
+	Coalesced Outcome = "coalesced"
+	// Miss responses were computed for this request
+	Miss Outcome = "miss"
+)
+
+// Config configures the response cache
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// TTL is how long a response is served from the cache. Windows ending
+	// now lag behind by up to this long.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxEntries caps the responses kept; the ones closest to expiring
+	// are dropped beyond it
+	MaxEntries int `yaml:"maxEntries"`
+}
+
+// DefaultConfig keeps the cache off; the other fields are the defaults
+// once it is enabled
+func DefaultConfig() Config {
+	return Config{TTL: DefaultTTL, MaxEntries: DefaultMaxEntries}
+}
+
+// Validate checks the TTL and capacity
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.TTL <= 0 {
+		return fmt.Errorf("invalid cache ttl %v", c.TTL)
+	}
+	if c.MaxEntries <= 0 {
+		return fmt.Errorf("invalid cache maxEntries %d", c.MaxEntries)
+	}
+	return nil
+}
+
+// Response is a computed response
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// entry is a response being computed, or computed once done is closed
+type entry struct {
+	done     chan struct{}
+	response Response
+	expires  time.Time
+}
+
+// Cache holds responses by key. It is safe for concurrent use.
+type Cache struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// New creates the cache of cfg, or returns nil when it is disabled
+func New(cfg Config) (*Cache, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Cache{ttl: cfg.TTL, max: cfg.MaxEntries, now: time.Now, entries: make(map[string]*entry)}, nil
+}
+
+// TTL is how long responses are kept
+func (c *Cache) TTL() time.Duration {
+	return c.ttl
+}
+
+// Do returns the response stored under key or, when there is none, the
+// one compute returns. Concurrent calls for the same key share a single
+// compute. Only 200 responses are stored; others are just shared with the
+// calls waiting for them.
+func (c *Cache) Do(key string, compute func() Response) (Response, Outcome) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		select {
+		case <-e.done:
+			if c.now().Before(e.expires) {
+				c.mu.Unlock()
+				return e.response, Hit
+			}
+		default:
+			c.mu.Unlock()
+			<-e.done
+			return e.response, Coalesced
+		}
+	}
+	e := &entry{done: make(chan struct{})}
+	c.entries[key] = e
+	c.mu.Unlock()
+
+	// Waiters are released even if compute panics, with an empty
+	// response that isn't stored
+	defer func() {
+		c.mu.Lock()
+		if e.response.Status == http.StatusOK {
+			e.expires = c.now().Add(c.ttl)
+			c.evict()
+		} else if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		close(e.done)
+	}()
+	e.response = compute()
+	return e.response, Miss
+}
+
+// evict drops expired responses and, beyond the capacity, those expiring
+// first. c.mu must be held.
+func (c *Cache) evict() {
+	if len(c.entries) <= c.max {
+		return
+	}
+	now := c.now()
+	var first string
+	var firstExpires time.Time
+	for key, e := range c.entries {
+		if e.expires.IsZero() {
+			// Still being computed
+			continue
+		}
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if firstExpires.IsZero() || e.expires.Before(firstExpires) {
+			first, firstExpires = key, e.expires
+		}
+	}
+	if len(c.entries) > c.max && first != "" {
+		delete(c.entries, first)
+	}
+}
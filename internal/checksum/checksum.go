@@ -0,0 +1,55 @@
+// Package checksum verifies the checksums clients send with their batches,
+// so events corrupted on the way, as happens on flaky mobile networks, are
+// rejected instead of stored
+package checksum
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Algorithm is the only supported checksum, the 64-bit xxHash
+const Algorithm = "xxh64"
+
+// Sum returns the checksum of data as clients send it: the algorithm, a
+// colon and the hash in 16 lowercase hex digits
+func Sum(data []byte) string {
+	return fmt.Sprintf("%s:%016x", Algorithm, xxhash.Sum64(data))
+}
+
+// MismatchError is returned by Verify when data doesn't hash to the
+// checksum it came with
+type MismatchError struct {
+	// Expected is the checksum the client sent
+	Expected string
+	// Actual is the checksum of what arrived
+	Actual string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch: sent %s, received %s", e.Expected, e.Actual)
+}
+
+// Verify checks data against checksum, which is "xxh64:" followed by the
+// hash in hex. The algorithm may be left out.
+func Verify(data []byte, checksum string) error {
+	algorithm, digest, found := strings.Cut(checksum, ":")
+	if !found {
+		algorithm, digest = Algorithm, checksum
+	}
+	if !strings.EqualFold(algorithm, Algorithm) {
+		return fmt.Errorf("unsupported checksum algorithm %q, must be %s", algorithm, Algorithm)
+	}
+	expected, err := strconv.ParseUint(digest, 16, 64)
+	if err != nil || len(digest) > 16 {
+		return errors.New("checksum must be a 64-bit hex number")
+	}
+	if actual := xxhash.Sum64(data); actual != expected {
+		return &MismatchError{Expected: checksum, Actual: Sum(data)}
+	}
+	return nil
+}
@@ -0,0 +1,1332 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/adtyap26/event-stream-video/internal/activity"
+	"github.com/adtyap26/event-stream-video/internal/analytics"
+	"github.com/adtyap26/event-stream-video/internal/anomaly"
+	"github.com/adtyap26/event-stream-video/internal/api"
+	"github.com/adtyap26/event-stream-video/internal/archive"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/bots"
+	"github.com/adtyap26/event-stream-video/internal/cache"
+	"github.com/adtyap26/event-stream-video/internal/coordination"
+	"github.com/adtyap26/event-stream-video/internal/cors"
+	"github.com/adtyap26/event-stream-video/internal/deadletter"
+	"github.com/adtyap26/event-stream-video/internal/dedup"
+	"github.com/adtyap26/event-stream-video/internal/dimensions"
+	"github.com/adtyap26/event-stream-video/internal/encryption"
+	"github.com/adtyap26/event-stream-video/internal/enrich"
+	"github.com/adtyap26/event-stream-video/internal/erasure"
+	"github.com/adtyap26/event-stream-video/internal/identity"
+	"github.com/adtyap26/event-stream-video/internal/logger"
+	"github.com/adtyap26/event-stream-video/internal/logging"
+	"github.com/adtyap26/event-stream-video/internal/metadata"
+	"github.com/adtyap26/event-stream-video/internal/mqtt"
+	"github.com/adtyap26/event-stream-video/internal/pipeline"
+	"github.com/adtyap26/event-stream-video/internal/playererror"
+	"github.com/adtyap26/event-stream-video/internal/quota"
+	"github.com/adtyap26/event-stream-video/internal/ratelimit"
+	"github.com/adtyap26/event-stream-video/internal/regions"
+	"github.com/adtyap26/event-stream-video/internal/rollup"
+	"github.com/adtyap26/event-stream-video/internal/sampling"
+	"github.com/adtyap26/event-stream-video/internal/scrub"
+	"github.com/adtyap26/event-stream-video/internal/sdkconfig"
+	"github.com/adtyap26/event-stream-video/internal/session"
+	"github.com/adtyap26/event-stream-video/internal/signing"
+	"github.com/adtyap26/event-stream-video/internal/sink/bigquery"
+	"github.com/adtyap26/event-stream-video/internal/sink/clickhouse"
+	"github.com/adtyap26/event-stream-video/internal/sink/kinesis"
+	"github.com/adtyap26/event-stream-video/internal/sink/natsjs"
+	"github.com/adtyap26/event-stream-video/internal/sink/objectstore"
+	"github.com/adtyap26/event-stream-video/internal/sink/parquet"
+	"github.com/adtyap26/event-stream-video/internal/sink/pubsub"
+	"github.com/adtyap26/event-stream-video/internal/sink/redisstream"
+	"github.com/adtyap26/event-stream-video/internal/sink/split"
+	"github.com/adtyap26/event-stream-video/internal/sink/sqldb"
+	"github.com/adtyap26/event-stream-video/internal/sink/wal"
+	"github.com/adtyap26/event-stream-video/internal/taxonomy"
+	"github.com/adtyap26/event-stream-video/internal/timestamps"
+	"github.com/adtyap26/event-stream-video/internal/tracing"
+	"github.com/adtyap26/event-stream-video/internal/transform"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+	"github.com/adtyap26/event-stream-video/internal/webhook"
+)
+
+// Config is the complete collector configuration
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Logger     LoggerConfig     `yaml:"logger"`
+	Sink       SinkConfig       `yaml:"sink"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Validation ValidationConfig `yaml:"validation"`
+	Dedup      DedupConfig      `yaml:"dedup"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	Sessions   SessionsConfig   `yaml:"sessions"`
+	QoE        QoEConfig        `yaml:"qoe"`
+	VideoStats VideoStatsConfig `yaml:"videoStats"`
+	Rollups    rollup.Config    `yaml:"rollups"`
+	Stream     StreamConfig     `yaml:"stream"`
+	MQTT       mqtt.Config      `yaml:"mqtt"`
+	Dashboard  DashboardConfig  `yaml:"dashboard"`
+	Alerts     anomaly.Config   `yaml:"alerts"`
+	Errors     ErrorsConfig     `yaml:"errorGroups"`
+	Webhooks   []webhook.Config `yaml:"webhooks"`
+	RateLimit  RateLimitConfig  `yaml:"rateLimit"`
+	Quotas     QuotaConfig      `yaml:"quotas"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Tracing    tracing.Config   `yaml:"tracing"`
+	Timestamps TimestampsConfig `yaml:"timestamps"`
+	Tenancy    TenancyConfig    `yaml:"tenancy"`
+	DeadLetter DeadLetterConfig `yaml:"deadLetter"`
+	Buffer     BufferConfig     `yaml:"buffer"`
+	WAL        wal.Config       `yaml:"wal"`
+	CORS       cors.Config      `yaml:"cors"`
+	Enrichment enrich.Config    `yaml:"enrichment"`
+	Regions    regions.Config   `yaml:"regions"`
+	SDK        sdkconfig.Config `yaml:"sdk"`
+	// Coordination shares dedup, session state and rate limits between
+	// the collectors of a deployment
+	Coordination coordination.Config `yaml:"coordination"`
+	Sampling     SamplingConfig      `yaml:"sampling"`
+	Scrubbing    scrub.Config        `yaml:"scrubbing"`
+	Bots         bots.Config         `yaml:"bots"`
+	Taxonomy     taxonomy.Config     `yaml:"taxonomy"`
+	API          APIConfig           `yaml:"api"`
+	Admin        AdminConfig         `yaml:"admin"`
+	Erasure      ErasureConfig       `yaml:"erasure"`
+	Archive      archive.Config      `yaml:"archive"`
+	LoadShed     LoadShedConfig      `yaml:"loadShedding"`
+	Pipeline     PipelineConfig      `yaml:"pipeline"`
+	// Signing makes tenants sign their batches with a secret
+	Signing signing.Config `yaml:"signing"`
+	// Dimensions lets tenants register typed custom dimensions
+	Dimensions dimensions.Config `yaml:"dimensions"`
+	// Cache keeps the responses of aggregation endpoints for a few seconds
+	Cache cache.Config `yaml:"cache"`
+	// Metadata looks the title, duration, category and publisher of videos
+	// up in a CMS
+	Metadata metadata.Config `yaml:"metadata"`
+	// Transforms reshape the events of odd player payloads by rules
+	Transforms transform.Config `yaml:"transforms"`
+	// Identities links the anonymous IDs of viewers to the user IDs they
+	// log in with
+	Identities identity.Config `yaml:"identities"`
+}
+
+// ServerConfig configures the HTTP server
+type ServerConfig struct {
+	Port            int           `yaml:"port"`
+	StaticDir       string        `yaml:"staticDir"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+
+	// MaxDecompressedSize limits gzip/deflate request bodies after decompression
+	MaxDecompressedSize int64 `yaml:"maxDecompressedSize"`
+	// MaxHeaderBytes limits the size of request headers
+	MaxHeaderBytes int            `yaml:"maxHeaderBytes"`
+	Timeouts       TimeoutsConfig `yaml:"timeouts"`
+
+	// LogLevel is the minimum level of operational log records
+	LogLevel string `yaml:"logLevel"`
+	// LogFormat is text or json
+	LogFormat string `yaml:"logFormat"`
+
+	TLS TLSConfig `yaml:"tls"`
+	// HTTP2Cleartext accepts HTTP/2 without TLS (h2c), for load balancers
+	// that speak HTTP/2 to their backends. HTTP/2 is always on with TLS.
+	HTTP2Cleartext bool `yaml:"http2Cleartext"`
+}
+
+// LoggerConfig configures the file event logger
+type LoggerConfig struct {
+	Dir           string        `yaml:"dir"`
+	Format        string        `yaml:"format"`
+	BufferSize    int           `yaml:"bufferSize"`
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	MaxSize       int64         `yaml:"maxSize"`
+	MaxAge        time.Duration `yaml:"maxAge"`
+	MaxFiles      int           `yaml:"maxFiles"`
+	Compress      bool          `yaml:"compress"`
+	// MinFreeSpace fails readiness when the log disk has less free bytes
+	MinFreeSpace int64 `yaml:"minFreeSpace"`
+	// Encryption encrypts the logs of tenants with a key
+	Encryption encryption.Config `yaml:"encryption"`
+}
+
+// LimitsConfig configures request body size limits
+type LimitsConfig struct {
+	// MaxBodySize is the default limit in bytes for ingestion endpoints
+	MaxBodySize int64 `yaml:"maxBodySize"`
+	// Endpoints overrides MaxBodySize per route path
+	Endpoints map[string]int64 `yaml:"endpoints"`
+}
+
+// SessionsConfig configures session aggregation
+type SessionsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Timeout ends a session after this long without events
+	Timeout time.Duration `yaml:"timeout"`
+	// ConcurrencyWindow is how long after its last heartbeat a session
+	// counts as a concurrent viewer
+	ConcurrencyWindow time.Duration `yaml:"concurrencyWindow"`
+}
+
+// QoEConfig configures the quality of experience metrics derived from
+// sessions. It has no effect when sessions are disabled.
+type QoEConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxVideos caps how many videos per-video totals are kept for
+	MaxVideos int `yaml:"maxVideos"`
+}
+
+// VideoStatsConfig configures the per-video stats endpoint, which sums
+// ended and live sessions. It has no effect when sessions are disabled.
+type VideoStatsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Retention is the longest window stats can be queried for
+	Retention time.Duration `yaml:"retention"`
+	// MaxVideos caps how many videos stats are kept for
+	MaxVideos int `yaml:"maxVideos"`
+}
+
+// DashboardConfig configures the embedded dashboard and the activity
+// endpoints behind it
+type DashboardConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Retention is how far back ingestion rates and top videos are kept
+	Retention time.Duration `yaml:"retention"`
+	// MaxErrors is how many recent errors are kept per tenant
+	MaxErrors int `yaml:"maxErrors"`
+}
+
+// ErrorsConfig configures the grouping of player errors by fingerprint
+// served under /api/v1/errors
+type ErrorsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Retention is how far back error occurrences are kept
+	Retention time.Duration `yaml:"retention"`
+	// MaxGroups caps how many error groups are kept across tenants
+	MaxGroups int `yaml:"maxGroups"`
+}
+
+// StreamConfig configures the live event feed
+type StreamConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// RateLimitConfig configures per-client request throttling on the
+// ingestion endpoints
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// RequestsPerSecond is the sustained rate allowed per API client or IP
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	// Burst is how many requests may arrive at once before throttling
+	Burst int `yaml:"burst"`
+	// Tenants caps the combined rate of all clients of a tenant
+	Tenants map[string]TenantRateLimit `yaml:"tenants"`
+}
+
+// TenantRateLimit is the shared request budget of one tenant
+type TenantRateLimit struct {
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	Burst             int     `yaml:"burst"`
+}
+
+// QuotaConfig configures daily event quotas per API key. Events are counted
+// per UTC day once stored; clients past the soft quota are warned and those
+// at the hard quota are rejected with 429 until midnight UTC. Quotas need
+// API keys.
+type QuotaConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Daily is the quota of every API key
+	Daily quota.Limit `yaml:"daily"`
+	// Tenants replaces the quota of the keys of a tenant
+	Tenants map[string]quota.Limit `yaml:"tenants"`
+	// Retention is how many days of usage /api/v1/usage reports
+	Retention int `yaml:"retention"`
+	// File keeps usage across restarts when set
+	File string `yaml:"file"`
+}
+
+// LoadShedConfig configures how ingestion requests are turned away while
+// the sink falls behind. Only sinks that queue writes in memory (the file
+// and ClickHouse sinks) report a backlog.
+type LoadShedConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Threshold is how full the sink's queue may get, from 0 to 1, before
+	// requests are answered with 503
+	Threshold float64 `yaml:"threshold"`
+	// RetryAfter is sent to shed clients in the Retry-After header
+	RetryAfter time.Duration `yaml:"retryAfter"`
+}
+
+// DeadLetterConfig configures where batches the sink rejects are kept
+type DeadLetterConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+}
+
+// BufferConfig configures buffering batches in a Redis stream ahead of the
+// sink. Collectors sharing the stream and consumer group share the work of
+// storing it, so a batch one of them accepted is stored even if it crashes.
+type BufferConfig struct {
+	Enabled bool               `yaml:"enabled"`
+	Redis   redisstream.Config `yaml:"redis"`
+}
+
+// SamplingConfig configures server-side sampling of high-volume events
+type SamplingConfig struct {
+	Enabled bool            `yaml:"enabled"`
+	Rules   []sampling.Rule `yaml:"rules"`
+}
+
+// PipelineConfig configures the processors batches pass through before
+// they are stored
+type PipelineConfig struct {
+	// Processors are run in the order listed; leaving one out disables it
+	Processors []string `yaml:"processors"`
+}
+
+// TenancyConfig configures multi-tenant isolation. Tenants come from the
+// API key; requests without one belong to auth.DefaultTenant.
+type TenancyConfig struct {
+	// Enabled gives every tenant its own sink: a log directory per tenant,
+	// a ClickHouse or BigQuery table suffixed with the tenant, an object
+	// prefix, a Parquet directory or a SQLite file per tenant. Postgres
+	// tenants share the database and are told apart by the tenant column.
+	Enabled bool `yaml:"enabled"`
+}
+
+// TimestampsConfig configures timestamp normalization at ingest
+type TimestampsConfig struct {
+	// CorrectSkew shifts event times by the client clock's offset from the
+	// server, measured from the batch timestamp
+	CorrectSkew bool `yaml:"correctSkew"`
+	// SkewThreshold is the smallest offset that is corrected
+	SkewThreshold time.Duration `yaml:"skewThreshold"`
+}
+
+// MetricsConfig configures the Prometheus endpoint
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// DedupConfig configures batch and event deduplication
+type DedupConfig struct {
+	Enabled  bool `yaml:"enabled"`
+	Capacity int  `yaml:"capacity"`
+	// File persists seen batch keys across restarts when set
+	File string `yaml:"file"`
+
+	// Events drops events whose eventId was already stored, remembering up
+	// to EventCapacity IDs, persisted in EventFile when set
+	Events        bool   `yaml:"events"`
+	EventCapacity int    `yaml:"eventCapacity"`
+	EventFile     string `yaml:"eventFile"`
+
+	// Ledger records which events of up to LedgerCapacity batches were
+	// stored, persisted in LedgerFile when set. Retried batches are checked
+	// against it: fully stored ones are acknowledged as duplicates and
+	// partially stored ones only store the missing events.
+	Ledger         bool   `yaml:"ledger"`
+	LedgerCapacity int    `yaml:"ledgerCapacity"`
+	LedgerFile     string `yaml:"ledgerFile"`
+}
+
+// SinkConfig selects where events are stored
+type SinkConfig struct {
+	Type string `yaml:"type"`
+	// Fanout lists more sinks every batch is written to, each configured by
+	// its section below
+	Fanout      []FanoutConfig     `yaml:"fanout"`
+	ClickHouse  clickhouse.Config  `yaml:"clickhouse"`
+	BigQuery    bigquery.Config    `yaml:"bigquery"`
+	Kinesis     kinesis.Config     `yaml:"kinesis"`
+	ObjectStore objectstore.Config `yaml:"objectStore"`
+	Parquet     parquet.Config     `yaml:"parquet"`
+	SQL         sqldb.Config       `yaml:"sql"`
+	NATS        natsjs.Config      `yaml:"nats"`
+	PubSub      pubsub.Config      `yaml:"pubsub"`
+	// Redis only adds batches to the stream; its consumer group settings
+	// apply to the buffer
+	Redis redisstream.Config `yaml:"redis"`
+	// MaxBatchBytes and MaxBatchEvents cap the JSON size and events of a
+	// single write, such as a broker's maximum message size; larger batches
+	// are written in parts. Zero is unlimited.
+	MaxBatchBytes  int `yaml:"maxBatchBytes"`
+	MaxBatchEvents int `yaml:"maxBatchEvents"`
+}
+
+// SplitLimits are the limits larger batches are split at
+func (s SinkConfig) SplitLimits() split.Limits {
+	return split.Limits{MaxBytes: s.MaxBatchBytes, MaxEvents: s.MaxBatchEvents}
+}
+
+// FanoutConfig adds a sink of Type to the one of SinkConfig.Type. Optional
+// sinks are written in the background from a queue of their own, so their
+// outages only delay them; required ones fail ingestion like the main sink.
+type FanoutConfig struct {
+	Type     string `yaml:"type"`
+	Required bool   `yaml:"required"`
+	// QueueSize is how many batches may wait for an optional sink; more
+	// are dropped
+	QueueSize int `yaml:"queueSize"`
+	// MaxAttempts is how often an optional sink is tried with a batch
+	MaxAttempts int `yaml:"maxAttempts"`
+}
+
+// AuthConfig configures API key authentication. Authentication is
+// disabled when neither KeysFile nor Keys is set.
+type AuthConfig struct {
+	KeysFile string `yaml:"keysFile"`
+	Keys     string `yaml:"keys"`
+	// Certificates maps the common names of client certificates verified
+	// by server.tls.clientCAFile to tenants. Callers presenting one need no
+	// API key.
+	Certificates map[string]string `yaml:"certificates"`
+}
+
+// APIConfig configures the versions of the HTTP API. v1 is frozen and
+// always served.
+type APIConfig struct {
+	// V2 serves the v2 API under /api/v2. It is under development and its
+	// contract may still change.
+	V2 bool `yaml:"v2"`
+	// Deprecations announce versions that are going away to their callers
+	Deprecations []DeprecationConfig `yaml:"deprecations"`
+}
+
+// DeprecationConfig marks an API version as deprecated from Date. Sunset
+// is when it will stop being served, and Link points at the migration
+// notes; both are optional.
+type DeprecationConfig struct {
+	Version string    `yaml:"version"`
+	Date    time.Time `yaml:"date"`
+	Sunset  time.Time `yaml:"sunset"`
+	Link    string    `yaml:"link"`
+}
+
+// validate reports unknown versions and sunsets before deprecation
+func (c APIConfig) validate() error {
+	seen := make(map[string]bool, len(c.Deprecations))
+	for _, d := range c.Deprecations {
+		if !api.IsAPIVersion(d.Version) {
+			return fmt.Errorf("invalid api deprecation: unknown version %q", d.Version)
+		}
+		if seen[d.Version] {
+			return fmt.Errorf("invalid api deprecation: version %s is listed twice", d.Version)
+		}
+		seen[d.Version] = true
+		if d.Date.IsZero() {
+			return fmt.Errorf("invalid api deprecation of %s: date is required", d.Version)
+		}
+		if !d.Sunset.IsZero() && d.Sunset.Before(d.Date) {
+			return fmt.Errorf("invalid api deprecation of %s: sunset is before the deprecation date", d.Version)
+		}
+	}
+	return nil
+}
+
+// AdminConfig configures the runtime admin API at /admin/v1. It is
+// disabled when Token is empty.
+type AdminConfig struct {
+	// Token must be sent as a Bearer token on every admin request
+	Token string `yaml:"token"`
+}
+
+// ErasureConfig configures right-to-erasure requests at
+// DELETE /api/v1/users/{userId}/events. They need the admin token.
+type ErasureConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Timeout bounds one erasure job, which rewrites every log file of the
+	// file sink
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// minAdminTokenLength keeps admin tokens from being guessable
+const minAdminTokenLength = 16
+
+// ValidationConfig configures batch validation
+type ValidationConfig struct {
+	MaxEvents int `yaml:"maxEvents"`
+}
+
+// Sink types selectable with sink.type or ESV_SINK
+const (
+	// SinkFile writes events to local log files
+	SinkFile = "file"
+	// SinkClickHouse inserts events into a ClickHouse table
+	SinkClickHouse = "clickhouse"
+	// SinkBigQuery appends events to a partitioned BigQuery table
+	SinkBigQuery = "bigquery"
+	// SinkKinesis puts events to a Kinesis data stream or Firehose
+	// delivery stream
+	SinkKinesis = "kinesis"
+	// SinkObjectStore uploads partitioned NDJSON objects to S3 or GCS
+	SinkObjectStore = "objectstore"
+	// SinkParquet writes date-partitioned Parquet files to a local directory
+	SinkParquet = "parquet"
+	// SinkSQL stores batches and events in SQLite or Postgres tables
+	SinkSQL = "sql"
+	// SinkNATS publishes events to a NATS JetStream stream
+	SinkNATS = "nats"
+	// SinkPubSub publishes events to a Google Cloud Pub/Sub topic
+	SinkPubSub = "pubsub"
+	// SinkRedis adds batches to a Redis stream for downstream consumers
+	SinkRedis = "redis"
+)
+
+func validSinkType(typ string) bool {
+	switch typ {
+	case SinkFile, SinkClickHouse, SinkBigQuery, SinkKinesis, SinkObjectStore, SinkParquet, SinkSQL, SinkNATS, SinkPubSub, SinkRedis:
+		return true
+	}
+	return false
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() Config {
+	loggerOpts := logger.DefaultOptions()
+	return Config{
+		Server: ServerConfig{
+			Port:                8080,
+			StaticDir:           "./static",
+			ShutdownTimeout:     15 * time.Second,
+			MaxDecompressedSize: 10 << 20,
+			MaxHeaderBytes:      64 << 10,
+			LogLevel:            "info",
+			LogFormat:           string(logging.FormatText),
+			Timeouts: TimeoutsConfig{
+				ReadHeader: 10 * time.Second,
+				Read:       30 * time.Second,
+				Write:      30 * time.Second,
+				Idle:       2 * time.Minute,
+			},
+			TLS: TLSConfig{
+				AutocertCacheDir: "autocert",
+			},
+		},
+		Logger: LoggerConfig{
+			Dir:           loggerOpts.Dir,
+			Format:        string(loggerOpts.Format),
+			BufferSize:    loggerOpts.BufferSize,
+			FlushInterval: loggerOpts.FlushInterval,
+			MinFreeSpace:  loggerOpts.MinFreeSpace,
+		},
+		Sink: SinkConfig{
+			Type:        SinkFile,
+			ClickHouse:  clickhouse.DefaultConfig(),
+			BigQuery:    bigquery.DefaultConfig(),
+			Kinesis:     kinesis.DefaultConfig(),
+			ObjectStore: objectstore.DefaultConfig(),
+			Parquet:     parquet.DefaultConfig(),
+			SQL:         sqldb.DefaultConfig(),
+			NATS:        natsjs.DefaultConfig(),
+			PubSub:      pubsub.DefaultConfig(),
+			Redis:       redisstream.DefaultConfig(),
+		},
+		Validation: ValidationConfig{
+			MaxEvents: validation.DefaultMaxEvents,
+		},
+		Dedup: DedupConfig{
+			Enabled:        true,
+			Capacity:       dedup.DefaultCapacity,
+			EventCapacity:  dedup.DefaultEventCapacity,
+			LedgerCapacity: dedup.DefaultLedgerCapacity,
+		},
+		Limits: LimitsConfig{
+			MaxBodySize: 1 << 20,
+		},
+		Sessions: SessionsConfig{
+			Enabled:           true,
+			Timeout:           session.DefaultTimeout,
+			ConcurrencyWindow: session.DefaultConcurrencyWindow,
+		},
+		QoE: QoEConfig{
+			Enabled:   true,
+			MaxVideos: analytics.DefaultMaxVideos,
+		},
+		VideoStats: VideoStatsConfig{
+			Enabled:   true,
+			Retention: analytics.DefaultStatsRetention,
+			MaxVideos: analytics.DefaultMaxVideos,
+		},
+		Rollups: rollup.DefaultConfig(),
+		Stream: StreamConfig{
+			Enabled: true,
+		},
+		MQTT: mqtt.DefaultConfig(),
+		Dashboard: DashboardConfig{
+			Enabled:   true,
+			Retention: activity.DefaultRetention,
+			MaxErrors: activity.DefaultMaxErrors,
+		},
+		Alerts: anomaly.DefaultConfig(),
+		Errors: ErrorsConfig{
+			Enabled:   true,
+			Retention: playererror.DefaultRetention,
+			MaxGroups: playererror.DefaultMaxGroups,
+		},
+		RateLimit: RateLimitConfig{
+			RequestsPerSecond: 20,
+			Burst:             40,
+		},
+		Quotas: QuotaConfig{
+			Retention: quota.DefaultRetention,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		LoadShed: LoadShedConfig{
+			Enabled:    true,
+			Threshold:  0.8,
+			RetryAfter: 5 * time.Second,
+		},
+		Erasure: ErasureConfig{
+			Enabled: true,
+			Timeout: erasure.DefaultTimeout,
+		},
+		Archive: archive.DefaultConfig(),
+		Pipeline: PipelineConfig{
+			Processors: pipeline.DefaultOrder(),
+		},
+		DeadLetter: DeadLetterConfig{
+			Enabled: true,
+			Dir:     "deadletter",
+		},
+		Buffer: BufferConfig{
+			Redis: redisstream.DefaultConfig(),
+		},
+		WAL: wal.DefaultConfig(),
+		Timestamps: TimestampsConfig{
+			SkewThreshold: timestamps.DefaultSkewThreshold,
+		},
+		CORS:         cors.DefaultConfig(),
+		Enrichment:   enrich.DefaultConfig(),
+		Regions:      regions.DefaultConfig(),
+		SDK:          sdkconfig.DefaultConfig(),
+		Coordination: coordination.DefaultConfig(),
+		Bots:         bots.DefaultConfig(),
+		Taxonomy:     taxonomy.DefaultConfig(),
+		Tracing:      tracing.DefaultConfig(),
+		Dimensions:   dimensions.DefaultConfig(),
+		Cache:        cache.DefaultConfig(),
+		Metadata:     metadata.DefaultConfig(),
+		Identities:   identity.DefaultConfig(),
+	}
+}
+
+// Load reads the configuration file at path, if any, on top of the
+// defaults and then applies ESV_* environment variable overrides
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Validate reports configuration values that cannot work
+func (c Config) Validate() error {
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("invalid server port %d", c.Server.Port)
+	}
+	if err := c.Server.TLS.validate(); err != nil {
+		return err
+	}
+	if len(c.Auth.Certificates) > 0 && c.Server.TLS.ClientCAFile == "" {
+		return errors.New("auth.certificates needs server.tls.clientCAFile")
+	}
+	for name, tenant := range c.Auth.Certificates {
+		if name == "" || tenant == "" {
+			return fmt.Errorf("auth.certificates: common name %q and tenant %q must not be empty", name, tenant)
+		}
+	}
+	if err := c.Signing.Validate(); err != nil {
+		return err
+	}
+	if err := c.Server.Timeouts.validate(); err != nil {
+		return err
+	}
+	if err := c.API.validate(); err != nil {
+		return err
+	}
+	if c.Server.MaxHeaderBytes < 0 {
+		return fmt.Errorf("invalid server maxHeaderBytes %d", c.Server.MaxHeaderBytes)
+	}
+	if err := c.validateStaticDir(); err != nil {
+		return err
+	}
+	if _, err := logging.ParseLevel(c.Server.LogLevel); err != nil {
+		return err
+	}
+	if _, err := logging.ParseFormat(c.Server.LogFormat); err != nil {
+		return err
+	}
+	if _, err := logger.ParseFormat(c.Logger.Format); err != nil {
+		return err
+	}
+	if err := c.Logger.Encryption.Validate(); err != nil {
+		return err
+	}
+	if c.Logger.Encryption.Enabled && c.Logger.Format != string(logger.FormatNDJSON) {
+		// Text logs don't record the tenant whose key an erasure has to
+		// encrypt a rewritten file with
+		return errors.New("log encryption needs the ndjson logger format")
+	}
+	if !validSinkType(c.Sink.Type) {
+		return fmt.Errorf("unknown sink type %q", c.Sink.Type)
+	}
+	fanoutTypes := map[string]bool{c.Sink.Type: true}
+	for _, f := range c.Sink.Fanout {
+		if !validSinkType(f.Type) {
+			return fmt.Errorf("unknown fanout sink type %q", f.Type)
+		}
+		if fanoutTypes[f.Type] {
+			return fmt.Errorf("sink %s is configured twice", f.Type)
+		}
+		fanoutTypes[f.Type] = true
+		if f.QueueSize < 0 || f.MaxAttempts < 0 {
+			return fmt.Errorf("invalid queue size or attempts for fanout sink %s", f.Type)
+		}
+	}
+	if c.Sink.MaxBatchBytes < 0 || c.Sink.MaxBatchEvents < 0 {
+		return fmt.Errorf("invalid sink maxBatchBytes %d or maxBatchEvents %d", c.Sink.MaxBatchBytes, c.Sink.MaxBatchEvents)
+	}
+	if _, err := parquet.ParseCompression(c.Sink.Parquet.Compression); err != nil {
+		return err
+	}
+	if err := sqldb.ValidateDriver(c.Sink.SQL.Driver); err != nil {
+		return err
+	}
+	if _, err := natsjs.ParseStorage(c.Sink.NATS.Storage); err != nil {
+		return err
+	}
+	if err := c.Sink.Kinesis.Validate(); err != nil {
+		return err
+	}
+	if err := c.Sink.PubSub.Validate(); err != nil {
+		return err
+	}
+	if c.Buffer.Enabled && c.Sink.Type == SinkRedis {
+		return fmt.Errorf("buffer is enabled but sink type %s is not a durable sink to store the buffer in", SinkRedis)
+	}
+	if c.Buffer.Enabled && c.WAL.Enabled {
+		return fmt.Errorf("buffer and wal can't both be enabled")
+	}
+	if c.WAL.Enabled && c.WAL.MaxSize < 0 {
+		return fmt.Errorf("invalid wal maxSize %d", c.WAL.MaxSize)
+	}
+	if _, err := cors.New(c.CORS); err != nil {
+		return err
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	if err := c.Rollups.Validate(); err != nil {
+		return err
+	}
+	if err := c.Archive.Validate(); err != nil {
+		return err
+	}
+	if err := c.MQTT.Validate(); err != nil {
+		return err
+	}
+	if err := c.Alerts.Validate(); err != nil {
+		return err
+	}
+	if err := pipeline.ValidateOrder(c.Pipeline.Processors); err != nil {
+		return err
+	}
+	for _, rule := range c.Sampling.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	scrubber, err := scrub.New(c.Scrubbing)
+	if err != nil {
+		return fmt.Errorf("invalid scrubbing config: %w", err)
+	}
+	// Personal data must not reach the sink because the stage was left out
+	if scrubber != nil && !slices.Contains(c.Pipeline.Processors, pipeline.Scrub) {
+		return fmt.Errorf("scrubbing is configured but the pipeline has no %s processor", pipeline.Scrub)
+	}
+	if err := c.Enrichment.Validate(); err != nil {
+		return fmt.Errorf("invalid enrichment config: %w", err)
+	}
+	if err := c.Regions.Validate(); err != nil {
+		return err
+	}
+	if err := c.SDK.Validate(c.Validation.MaxEvents); err != nil {
+		return err
+	}
+	if err := c.Coordination.Validate(); err != nil {
+		return err
+	}
+	if err := c.Bots.Validate(); err != nil {
+		return fmt.Errorf("invalid bots config: %w", err)
+	}
+	if c.Bots.Enabled && !slices.Contains(c.Pipeline.Processors, pipeline.Bots) {
+		return fmt.Errorf("bot filtering is enabled but the pipeline has no %s processor", pipeline.Bots)
+	}
+	if err := c.Taxonomy.Validate(); err != nil {
+		return fmt.Errorf("invalid taxonomy config: %w", err)
+	}
+	if c.Taxonomy.Enabled && !slices.Contains(c.Pipeline.Processors, pipeline.Validate) {
+		return fmt.Errorf("the taxonomy is enabled but the pipeline has no %s processor", pipeline.Validate)
+	}
+	if err := c.Cache.Validate(); err != nil {
+		return err
+	}
+	if err := c.Metadata.Validate(); err != nil {
+		return err
+	}
+	if c.Metadata.Enabled && !slices.Contains(c.Pipeline.Processors, pipeline.Metadata) {
+		return fmt.Errorf("video metadata is enabled but the pipeline has no %s processor", pipeline.Metadata)
+	}
+	if err := c.Transforms.Validate(); err != nil {
+		return fmt.Errorf("invalid transforms: %w", err)
+	}
+	if c.Transforms.HasRules() && !slices.Contains(c.Pipeline.Processors, pipeline.Transform) {
+		return fmt.Errorf("transforms are configured but the pipeline has no %s processor", pipeline.Transform)
+	}
+	if err := c.Identities.Validate(); err != nil {
+		return err
+	}
+	if c.Identities.Enabled && !slices.Contains(c.Pipeline.Processors, pipeline.Identities) {
+		return fmt.Errorf("identities are enabled but the pipeline has no %s processor", pipeline.Identities)
+	}
+	if err := c.Dimensions.Validate(); err != nil {
+		return fmt.Errorf("invalid dimensions config: %w", err)
+	}
+	if c.Dimensions.Enabled && !slices.Contains(c.Pipeline.Processors, pipeline.Validate) {
+		return fmt.Errorf("custom dimensions are enabled but the pipeline has no %s processor", pipeline.Validate)
+	}
+	names := make(map[string]bool, len(c.Webhooks))
+	for _, hook := range c.Webhooks {
+		if err := hook.Validate(); err != nil {
+			return err
+		}
+		if names[hook.Name] {
+			return fmt.Errorf("webhook %s is configured twice", hook.Name)
+		}
+		names[hook.Name] = true
+	}
+	if len(c.Webhooks) > 0 && !slices.Contains(c.Pipeline.Processors, pipeline.Webhooks) {
+		return fmt.Errorf("webhooks are configured but the pipeline has no %s processor", pipeline.Webhooks)
+	}
+	if c.LoadShed.Enabled && (c.LoadShed.Threshold <= 0 || c.LoadShed.Threshold > 1) {
+		return fmt.Errorf("invalid load shedding threshold %v, must be between 0 and 1", c.LoadShed.Threshold)
+	}
+	if c.Admin.Token != "" && len(c.Admin.Token) < minAdminTokenLength {
+		return fmt.Errorf("admin token must be at least %d characters", minAdminTokenLength)
+	}
+	if c.RateLimit.Enabled && c.RateLimit.RequestsPerSecond <= 0 {
+		return fmt.Errorf("invalid rate limit %v requests per second", c.RateLimit.RequestsPerSecond)
+	}
+	for tenant, limit := range c.RateLimit.Tenants {
+		if limit.RequestsPerSecond <= 0 {
+			return fmt.Errorf("invalid rate limit %v requests per second for tenant %s", limit.RequestsPerSecond, tenant)
+		}
+	}
+	if err := validateQuota(c.Quotas.Daily); err != nil {
+		return err
+	}
+	for tenant, limit := range c.Quotas.Tenants {
+		if err := validateQuota(limit); err != nil {
+			return fmt.Errorf("%w for tenant %s", err, tenant)
+		}
+	}
+	return nil
+}
+
+// validateQuota checks that a quota's levels are not negative and that the
+// soft quota warns before the hard one rejects
+func validateQuota(limit quota.Limit) error {
+	if limit.Soft < 0 || limit.Hard < 0 {
+		return fmt.Errorf("invalid quota %d soft, %d hard events per day", limit.Soft, limit.Hard)
+	}
+	if limit.Soft > 0 && limit.Hard > 0 && limit.Soft >= limit.Hard {
+		return fmt.Errorf("soft quota %d must be below hard quota %d", limit.Soft, limit.Hard)
+	}
+	return nil
+}
+
+// validateStaticDir refuses files the collector keeps under the static
+// directory, which is served to anyone without authentication
+func (c Config) validateStaticDir() error {
+	static, err := filepath.Abs(c.Server.StaticDir)
+	if err != nil {
+		return fmt.Errorf("invalid server staticDir: %w", err)
+	}
+	type setting struct{ name, path string }
+	private := []setting{
+		{"logger.dir", c.Logger.Dir},
+		{"auth.keysFile", c.Auth.KeysFile},
+		{"server.tls.keyFile", c.Server.TLS.KeyFile},
+	}
+	if c.Logger.Encryption.Enabled {
+		private = append(private, setting{"logger.encryption.keyring", c.Logger.Encryption.Keyring})
+	}
+	sql := c.Sink.Type == SinkSQL || slices.ContainsFunc(c.Sink.Fanout, func(f FanoutConfig) bool { return f.Type == SinkSQL })
+	if sql && !sqldb.IsPostgres(c.Sink.SQL.Driver) {
+		file, _, _ := strings.Cut(strings.TrimPrefix(c.Sink.SQL.DSN, "file:"), "?")
+		if file != ":memory:" {
+			private = append(private, setting{"sink.sql.dsn", file})
+		}
+	}
+	if c.DeadLetter.Enabled {
+		private = append(private, setting{"deadLetter.dir", c.DeadLetter.Dir})
+	}
+	if c.WAL.Enabled {
+		private = append(private, setting{"wal.dir", c.WAL.Dir})
+	}
+	for _, s := range private {
+		if s.path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(s.path)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", s.name, err)
+		}
+		if rel, err := filepath.Rel(static, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s %s is under server.staticDir %s, which is served without authentication", s.name, s.path, c.Server.StaticDir)
+		}
+	}
+	return nil
+}
+
+// ServerLogger builds the operational logger described by the server section
+func (c Config) ServerLogger(w io.Writer) *slog.Logger {
+	level, _ := logging.ParseLevel(c.Server.LogLevel)
+	format, _ := logging.ParseFormat(c.Server.LogFormat)
+	return logging.New(w, level, format)
+}
+
+// LoggerOptions converts the logger section into logger.Options
+func (c Config) LoggerOptions() logger.Options {
+	format, _ := logger.ParseFormat(c.Logger.Format)
+	return logger.Options{
+		Dir:           c.Logger.Dir,
+		Format:        format,
+		BufferSize:    c.Logger.BufferSize,
+		FlushInterval: c.Logger.FlushInterval,
+		MaxSize:       c.Logger.MaxSize,
+		MaxAge:        c.Logger.MaxAge,
+		MaxFiles:      c.Logger.MaxFiles,
+		Compress:      c.Logger.Compress,
+		MinFreeSpace:  c.Logger.MinFreeSpace,
+	}
+}
+
+// ValidationLimits converts the validation section into validation.Limits
+func (c Config) ValidationLimits() validation.Limits {
+	return validation.Limits{MaxEvents: c.Validation.MaxEvents}
+}
+
+// TimestampNormalizer converts the timestamps section into a timestamps.Normalizer
+func (c Config) TimestampNormalizer() timestamps.Normalizer {
+	return timestamps.Normalizer{
+		CorrectSkew:   c.Timestamps.CorrectSkew,
+		SkewThreshold: c.Timestamps.SkewThreshold,
+	}
+}
+
+// CORSPolicy compiles the cors section
+func (c Config) CORSPolicy() (*cors.Policy, error) {
+	return cors.New(c.CORS)
+}
+
+// NewEnricher opens the databases of the enrichment section, or returns
+// nil when enrichment is disabled
+func (c Config) NewEnricher() (*enrich.Enricher, error) {
+	return enrich.New(c.Enrichment)
+}
+
+// NewRegionRouter routes players to regional collectors, or returns nil
+// when none are configured
+func (c Config) NewRegionRouter() (*regions.Router, error) {
+	return regions.New(c.Regions)
+}
+
+// NewSDKConfig serves the SDK settings of the sdk section, or returns nil
+// when their delivery is disabled
+func (c Config) NewSDKConfig() *sdkconfig.Store {
+	return sdkconfig.New(c.SDK)
+}
+
+// NewScrubber builds the scrubber described by the scrubbing section, or
+// returns nil when it scrubs nothing
+func (c Config) NewScrubber() (*scrub.Scrubber, error) {
+	return scrub.New(c.Scrubbing)
+}
+
+// NewTaxonomy builds the event taxonomy described by the taxonomy section,
+// or returns nil when it is disabled
+func (c Config) NewTaxonomy() (*taxonomy.Taxonomy, error) {
+	return taxonomy.New(c.Taxonomy)
+}
+
+// NewDimensionRegistry loads the custom dimensions described by the
+// dimensions section, or returns nil when they are disabled
+func (c Config) NewDimensionRegistry() (*dimensions.Registry, error) {
+	return dimensions.New(c.Dimensions)
+}
+
+// NewCache builds the response cache described by the cache section, or
+// returns nil when it is disabled
+func (c Config) NewCache() (*cache.Cache, error) {
+	return cache.New(c.Cache)
+}
+
+// NewTransforms compiles the transformation rules, or returns nil when
+// there are none
+func (c Config) NewTransforms() (*transform.Engine, error) {
+	return transform.New(c.Transforms)
+}
+
+// NewIdentityGraph loads the identity links described by the identities
+// section, or returns nil when stitching is disabled
+func (c Config) NewIdentityGraph() (*identity.Graph, error) {
+	return identity.New(c.Identities)
+}
+
+// NewMetadataResolver builds the video metadata lookups of the metadata
+// section, or returns nil when they are disabled
+func (c Config) NewMetadataResolver() (*metadata.Resolver, error) {
+	return metadata.New(c.Metadata)
+}
+
+// NewBotFilter builds the filter described by the bots section, or returns
+// nil when bot filtering is disabled
+func (c Config) NewBotFilter() (*bots.Filter, error) {
+	return bots.New(c.Bots)
+}
+
+// NewSampler builds the sampler described by the sampling section, or
+// returns nil when sampling is disabled
+func (c Config) NewSampler() (*sampling.Sampler, error) {
+	if !c.Sampling.Enabled {
+		return nil, nil
+	}
+	return sampling.New(c.Sampling.Rules)
+}
+
+// NewRateLimiter builds the limiter described by the rateLimit section,
+// with its buckets in coord when it isn't nil, or returns nil when rate
+// limiting is disabled
+func (c Config) NewRateLimiter(coord *coordination.Redis) *ratelimit.Limiter {
+	if !c.RateLimit.Enabled {
+		return nil
+	}
+	limiter := ratelimit.New(c.RateLimit.RequestsPerSecond, c.RateLimit.Burst)
+	for tenant, limit := range c.RateLimit.Tenants {
+		limiter.SetTenantLimit(tenant, limit.RequestsPerSecond, limit.Burst)
+	}
+	if coord != nil {
+		limiter.ShareBuckets(coord.Buckets())
+	}
+	return limiter
+}
+
+// NewQuotaTracker builds the usage tracker described by the quotas
+// section, or returns nil when quotas are disabled
+func (c Config) NewQuotaTracker() (*quota.Tracker, error) {
+	if !c.Quotas.Enabled {
+		return nil, nil
+	}
+	tracker, err := quota.New(c.Quotas.Daily, c.Quotas.Retention, c.Quotas.File)
+	if err != nil {
+		return nil, err
+	}
+	for tenant, limit := range c.Quotas.Tenants {
+		tracker.SetTenantLimit(tenant, limit)
+	}
+	return tracker, nil
+}
+
+// NewQoEAggregator builds the aggregator described by the qoe section, or
+// returns nil when QoE metrics or sessions are disabled
+func (c Config) NewQoEAggregator() *analytics.Aggregator {
+	if !c.QoE.Enabled || !c.Sessions.Enabled {
+		return nil
+	}
+	return analytics.NewAggregator(c.QoE.MaxVideos)
+}
+
+// NewVideoStats builds the store described by the videoStats section, or
+// returns nil when video stats or sessions are disabled
+func (c Config) NewVideoStats() *analytics.StatsStore {
+	if !c.VideoStats.Enabled || !c.Sessions.Enabled {
+		return nil
+	}
+	return analytics.NewStatsStore(c.VideoStats.Retention, c.VideoStats.MaxVideos)
+}
+
+// NewRollups starts the rollup engine described by the rollups section,
+// keeping rollups in store or, when store is nil, in memory. It returns nil
+// when rollups or sessions are disabled.
+func (c Config) NewRollups(store rollup.Store) *rollup.Engine {
+	if !c.Rollups.Enabled || !c.Sessions.Enabled {
+		return nil
+	}
+	return rollup.New(c.Rollups, store)
+}
+
+// NewActivityRecorder builds the recorder behind the dashboard, or returns
+// nil when the dashboard is disabled
+func (c Config) NewActivityRecorder() *activity.Recorder {
+	if !c.Dashboard.Enabled {
+		return nil
+	}
+	return activity.NewRecorder(c.Dashboard.Retention, c.Dashboard.MaxErrors)
+}
+
+// NewAnomalyDetector builds the detector described by the alerts section,
+// or returns nil when alerting is disabled. Without sessions it only
+// watches error rates.
+func (c Config) NewAnomalyDetector() *anomaly.Detector {
+	if !c.Alerts.Enabled {
+		return nil
+	}
+	return anomaly.New(c.Alerts)
+}
+
+// NewErrorGroups builds the store described by the errorGroups section, or
+// returns nil when it or the classification of errors by enrichment is
+// disabled
+func (c Config) NewErrorGroups() *playererror.Groups {
+	if !c.Errors.Enabled || !c.Enrichment.Errors {
+		return nil
+	}
+	return playererror.NewGroups(c.Errors.Retention, c.Errors.MaxGroups)
+}
+
+// NewWebhooks starts delivering to the configured webhooks, or returns nil
+// when there are none
+func (c Config) NewWebhooks() (*webhook.Dispatcher, error) {
+	if len(c.Webhooks) == 0 {
+		return nil, nil
+	}
+	return webhook.New(c.Webhooks)
+}
+
+// NewKeyring loads the keys of log encryption, or returns nil when it is
+// disabled
+func (c Config) NewKeyring() (*encryption.Keyring, error) {
+	if !c.Logger.Encryption.Enabled {
+		return nil, nil
+	}
+	return encryption.New(context.Background(), c.Logger.Encryption)
+}
+
+// NewArchiver starts archiving old event files to cold storage, or returns
+// nil when archival is disabled
+func (c Config) NewArchiver() (*archive.Archiver, error) {
+	if !c.Archive.Enabled {
+		return nil, nil
+	}
+	store, err := c.NewArchiveStore()
+	if err != nil {
+		return nil, err
+	}
+	var sources []archive.Source
+	for _, name := range c.Archive.Sources {
+		switch name {
+		case archive.SourceLogs:
+			sources = append(sources, archive.Source{Name: name, Dir: c.Logger.Dir, Match: logger.IsRotated})
+		case archive.SourceParquet:
+			sources = append(sources, archive.Source{Name: name, Dir: c.Sink.Parquet.Dir, Match: parquet.IsDataFile})
+		}
+	}
+	return archive.New(c.Archive, store, sources), nil
+}
+
+// NewArchiveStore connects to the bucket of the archive, whether or not
+// archival is still enabled
+func (c Config) NewArchiveStore() (archive.Store, error) {
+	return archive.NewStore(context.Background(), c.Archive)
+}
+
+// NewMQTTBridge builds the bridge from the MQTT broker, or returns nil when
+// it is disabled
+func (c Config) NewMQTTBridge() (*mqtt.Bridge, error) {
+	if !c.MQTT.Enabled {
+		return nil, nil
+	}
+	return mqtt.New(c.MQTT)
+}
+
+// ForTenant returns a copy of the configuration whose sink settings write to
+// tenant's own location. tenant must be safe for paths and table names.
+func (c Config) ForTenant(tenant string) Config {
+	c.Logger.Dir = filepath.Join(c.Logger.Dir, tenant)
+	c.Sink.ClickHouse.Table = c.Sink.ClickHouse.Table + "_" + tenant
+	c.Sink.BigQuery.Table = c.Sink.BigQuery.Table + "_" + tenant
+	c.Sink.ObjectStore.Prefix = path.Join(c.Sink.ObjectStore.Prefix, "tenant="+tenant)
+	c.Sink.Parquet.Dir = filepath.Join(c.Sink.Parquet.Dir, "tenant="+tenant)
+	c.Sink.Redis.Stream = c.Sink.Redis.Stream + ":" + tenant
+	if sqldb.ValidateDriver(c.Sink.SQL.Driver) == nil && !sqldb.IsPostgres(c.Sink.SQL.Driver) {
+		c.Sink.SQL.DSN = tenantFile(c.Sink.SQL.DSN, tenant)
+	}
+	return c
+}
+
+// tenantFile inserts tenant before the extension of a SQLite database
+// path, keeping any ?query parameters
+func tenantFile(dsn, tenant string) string {
+	file, query, hasQuery := strings.Cut(dsn, "?")
+	ext := filepath.Ext(file)
+	file = strings.TrimSuffix(file, ext) + "_" + tenant + ext
+	if hasQuery {
+		return file + "?" + query
+	}
+	return file
+}
+
+// LogDir is a directory of the file sink's logs
+type LogDir struct {
+	Path string
+	// Tenant is the tenant whose logs the directory holds, as safe for
+	// paths, or "" for the logger directory itself
+	Tenant string
+}
+
+// LogDirs lists the directories of the file sink's logs: the logger
+// directory and, with tenancy, the directory of every tenant in it, laid
+// out by ForTenant
+func (c Config) LogDirs() ([]LogDir, error) {
+	dirs := []LogDir{{Path: c.Logger.Dir}}
+	if !c.Tenancy.Enabled {
+		return dirs, nil
+	}
+	entries, err := os.ReadDir(c.Logger.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return dirs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, LogDir{Path: filepath.Join(c.Logger.Dir, entry.Name()), Tenant: entry.Name()})
+		}
+	}
+	return dirs, nil
+}
+
+// OpenDeadLetterQueue opens the queue described by the deadLetter section,
+// or returns nil when dead-lettering is disabled
+func (c Config) OpenDeadLetterQueue() (*deadletter.Queue, error) {
+	if !c.DeadLetter.Enabled {
+		return nil, nil
+	}
+	return deadletter.Open(c.DeadLetter.Dir)
+}
+
+// NewClientCertificates resolves client certificates to tenants by the
+// auth.certificates map, or returns nil when it is empty
+func (c Config) NewClientCertificates() *auth.Certificates {
+	if len(c.Auth.Certificates) == 0 {
+		return nil
+	}
+	return auth.NewCertificates(c.Auth.Certificates)
+}
+
+// NewSignatureVerifier checks the batch signatures of the tenants with a
+// signing secret, or returns nil when none have one
+func (c Config) NewSignatureVerifier() (*signing.Verifier, error) {
+	return signing.New(c.Signing)
+}
+
+// NewCoordinator connects to the coordination store, or returns nil when
+// collectors keep their state to themselves
+func (c Config) NewCoordinator() (*coordination.Redis, error) {
+	return coordination.New(c.Coordination)
+}
+
+// NewDeduplicator builds the deduplicator described by the dedup section,
+// keeping its keys in coord when it isn't nil, or returns nil when
+// deduplication is disabled
+func (c Config) NewDeduplicator(coord *coordination.Redis) (*dedup.Deduplicator, error) {
+	if !c.Dedup.Enabled {
+		return nil, nil
+	}
+	if coord != nil {
+		return dedup.NewShared(coord.Keys("batches")), nil
+	}
+	return newDeduplicator(c.Dedup.Capacity, c.Dedup.File)
+}
+
+// NewEventDeduplicator builds the deduplicator of event IDs, or returns nil
+// when event deduplication is disabled
+func (c Config) NewEventDeduplicator(coord *coordination.Redis) (*dedup.Deduplicator, error) {
+	if !c.Dedup.Events {
+		return nil, nil
+	}
+	if coord != nil {
+		return dedup.NewShared(coord.Keys("events")), nil
+	}
+	return newDeduplicator(c.Dedup.EventCapacity, c.Dedup.EventFile)
+}
+
+// NewBatchLedger builds the ledger of stored batches, or returns nil when
+// it is disabled
+func (c Config) NewBatchLedger(coord *coordination.Redis) (*dedup.Ledger, error) {
+	if !c.Dedup.Ledger {
+		return nil, nil
+	}
+	if coord != nil {
+		return dedup.NewSharedLedger(coord.Ledger()), nil
+	}
+	var store dedup.Store
+	if c.Dedup.LedgerFile != "" {
+		fileStore, err := dedup.OpenFileStore(c.Dedup.LedgerFile, c.Dedup.LedgerCapacity)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+	return dedup.NewLedger(c.Dedup.LedgerCapacity, store)
+}
+
+// newDeduplicator remembers capacity keys, persisted in file unless it is
+// empty
+func newDeduplicator(capacity int, file string) (*dedup.Deduplicator, error) {
+	var store dedup.Store
+	if file != "" {
+		fileStore, err := dedup.OpenFileStore(file, capacity)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	}
+	return dedup.New(capacity, store)
+}
@@ -0,0 +1,536 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/anomaly"
+)
+
+// applyEnv overrides cfg with any ESV_* environment variables that are set
+func applyEnv(cfg *Config) error {
+	if err := envInt("ESV_PORT", &cfg.Server.Port); err != nil {
+		return err
+	}
+	envString("ESV_STATIC_DIR", &cfg.Server.StaticDir)
+	if err := envDuration("ESV_SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout); err != nil {
+		return err
+	}
+
+	if err := envInt64("ESV_MAX_DECOMPRESSED_SIZE", &cfg.Server.MaxDecompressedSize); err != nil {
+		return err
+	}
+	if err := envInt("ESV_MAX_HEADER_BYTES", &cfg.Server.MaxHeaderBytes); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_READ_HEADER_TIMEOUT", &cfg.Server.Timeouts.ReadHeader); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_READ_TIMEOUT", &cfg.Server.Timeouts.Read); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_WRITE_TIMEOUT", &cfg.Server.Timeouts.Write); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_IDLE_TIMEOUT", &cfg.Server.Timeouts.Idle); err != nil {
+		return err
+	}
+
+	envString("ESV_TLS_CERT_FILE", &cfg.Server.TLS.CertFile)
+	envString("ESV_TLS_KEY_FILE", &cfg.Server.TLS.KeyFile)
+	envList("ESV_TLS_AUTOCERT_HOSTS", &cfg.Server.TLS.AutocertHosts)
+	envString("ESV_TLS_AUTOCERT_CACHE_DIR", &cfg.Server.TLS.AutocertCacheDir)
+	envString("ESV_TLS_AUTOCERT_EMAIL", &cfg.Server.TLS.AutocertEmail)
+	if err := envInt("ESV_TLS_HTTP_PORT", &cfg.Server.TLS.HTTPPort); err != nil {
+		return err
+	}
+	envString("ESV_TLS_CLIENT_CA_FILE", &cfg.Server.TLS.ClientCAFile)
+	envString("ESV_TLS_CLIENT_AUTH", &cfg.Server.TLS.ClientAuth)
+	if err := envBool("ESV_HTTP2_CLEARTEXT", &cfg.Server.HTTP2Cleartext); err != nil {
+		return err
+	}
+
+	envString("ESV_SERVER_LOG_LEVEL", &cfg.Server.LogLevel)
+	envString("ESV_SERVER_LOG_FORMAT", &cfg.Server.LogFormat)
+
+	envString("ESV_LOG_DIR", &cfg.Logger.Dir)
+	envString("ESV_LOG_FORMAT", &cfg.Logger.Format)
+	if err := envInt64("ESV_LOG_MAX_SIZE", &cfg.Logger.MaxSize); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_LOG_MAX_AGE", &cfg.Logger.MaxAge); err != nil {
+		return err
+	}
+	if err := envInt("ESV_LOG_MAX_FILES", &cfg.Logger.MaxFiles); err != nil {
+		return err
+	}
+	if err := envBool("ESV_LOG_COMPRESS", &cfg.Logger.Compress); err != nil {
+		return err
+	}
+	if err := envInt64("ESV_LOG_MIN_FREE_SPACE", &cfg.Logger.MinFreeSpace); err != nil {
+		return err
+	}
+	if err := envBool("ESV_LOG_ENCRYPTION", &cfg.Logger.Encryption.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_LOG_KEYRING", &cfg.Logger.Encryption.Keyring)
+	envString("ESV_LOG_KMS_REGION", &cfg.Logger.Encryption.KMS.Region)
+
+	envString("ESV_SINK", &cfg.Sink.Type)
+	// ESV_SINK_FANOUT lists optional fanout sinks by type
+	if _, ok := os.LookupEnv("ESV_SINK_FANOUT"); ok {
+		var types []string
+		envList("ESV_SINK_FANOUT", &types)
+		cfg.Sink.Fanout = nil
+		for _, typ := range types {
+			cfg.Sink.Fanout = append(cfg.Sink.Fanout, FanoutConfig{Type: typ})
+		}
+	}
+	if err := envInt("ESV_SINK_MAX_BATCH_BYTES", &cfg.Sink.MaxBatchBytes); err != nil {
+		return err
+	}
+	if err := envInt("ESV_SINK_MAX_BATCH_EVENTS", &cfg.Sink.MaxBatchEvents); err != nil {
+		return err
+	}
+	envString("ESV_CLICKHOUSE_URL", &cfg.Sink.ClickHouse.URL)
+	envString("ESV_CLICKHOUSE_DATABASE", &cfg.Sink.ClickHouse.Database)
+	envString("ESV_CLICKHOUSE_TABLE", &cfg.Sink.ClickHouse.Table)
+	envString("ESV_CLICKHOUSE_USERNAME", &cfg.Sink.ClickHouse.Username)
+	envString("ESV_CLICKHOUSE_PASSWORD", &cfg.Sink.ClickHouse.Password)
+	envString("ESV_BIGQUERY_PROJECT", &cfg.Sink.BigQuery.Project)
+	envString("ESV_BIGQUERY_DATASET", &cfg.Sink.BigQuery.Dataset)
+	envString("ESV_BIGQUERY_TABLE", &cfg.Sink.BigQuery.Table)
+	envString("ESV_BIGQUERY_LOCATION", &cfg.Sink.BigQuery.Location)
+	envString("ESV_KINESIS_SERVICE", &cfg.Sink.Kinesis.Service)
+	envString("ESV_KINESIS_STREAM", &cfg.Sink.Kinesis.Stream)
+	envString("ESV_KINESIS_REGION", &cfg.Sink.Kinesis.Region)
+	envString("ESV_KINESIS_ENDPOINT", &cfg.Sink.Kinesis.Endpoint)
+	envString("ESV_KINESIS_PARTITION_KEY", &cfg.Sink.Kinesis.PartitionKey)
+	if err := envBool("ESV_KINESIS_AGGREGATE", &cfg.Sink.Kinesis.Aggregate); err != nil {
+		return err
+	}
+	envString("ESV_OBJECTSTORE_PROVIDER", &cfg.Sink.ObjectStore.Provider)
+	envString("ESV_OBJECTSTORE_BUCKET", &cfg.Sink.ObjectStore.Bucket)
+	envString("ESV_OBJECTSTORE_PREFIX", &cfg.Sink.ObjectStore.Prefix)
+	envString("ESV_OBJECTSTORE_REGION", &cfg.Sink.ObjectStore.Region)
+	envString("ESV_OBJECTSTORE_ENDPOINT", &cfg.Sink.ObjectStore.Endpoint)
+	envString("ESV_PARQUET_DIR", &cfg.Sink.Parquet.Dir)
+	envString("ESV_PARQUET_COMPRESSION", &cfg.Sink.Parquet.Compression)
+	envString("ESV_SQL_DRIVER", &cfg.Sink.SQL.Driver)
+	envString("ESV_SQL_DSN", &cfg.Sink.SQL.DSN)
+	envString("ESV_NATS_URL", &cfg.Sink.NATS.URL)
+	envString("ESV_NATS_CREDENTIALS_FILE", &cfg.Sink.NATS.CredentialsFile)
+	envString("ESV_NATS_TOKEN", &cfg.Sink.NATS.Token)
+	envString("ESV_NATS_SUBJECT_PREFIX", &cfg.Sink.NATS.SubjectPrefix)
+	envString("ESV_NATS_STREAM", &cfg.Sink.NATS.Stream)
+	if err := envBool("ESV_NATS_CREATE_STREAM", &cfg.Sink.NATS.CreateStream); err != nil {
+		return err
+	}
+	if err := envInt("ESV_NATS_REPLICAS", &cfg.Sink.NATS.Replicas); err != nil {
+		return err
+	}
+	envString("ESV_PUBSUB_PROJECT", &cfg.Sink.PubSub.Project)
+	envString("ESV_PUBSUB_TOPIC", &cfg.Sink.PubSub.Topic)
+	envString("ESV_PUBSUB_ORDERING_KEY", &cfg.Sink.PubSub.OrderingKey)
+	envString("ESV_PUBSUB_DEAD_LETTER_TOPIC", &cfg.Sink.PubSub.DeadLetterTopic)
+	envString("ESV_REDIS_ADDR", &cfg.Sink.Redis.Addr)
+	envString("ESV_REDIS_USERNAME", &cfg.Sink.Redis.Username)
+	envString("ESV_REDIS_PASSWORD", &cfg.Sink.Redis.Password)
+	envString("ESV_REDIS_STREAM", &cfg.Sink.Redis.Stream)
+	if err := envInt64("ESV_REDIS_MAX_LEN", &cfg.Sink.Redis.MaxLen); err != nil {
+		return err
+	}
+
+	envString("ESV_API_KEYS_FILE", &cfg.Auth.KeysFile)
+	envString("ESV_API_KEYS", &cfg.Auth.Keys)
+	envString("ESV_ADMIN_TOKEN", &cfg.Admin.Token)
+	if err := envBool("ESV_API_V2", &cfg.API.V2); err != nil {
+		return err
+	}
+
+	if err := envInt("ESV_MAX_BATCH_EVENTS", &cfg.Validation.MaxEvents); err != nil {
+		return err
+	}
+
+	if err := envInt64("ESV_MAX_BODY_SIZE", &cfg.Limits.MaxBodySize); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_SESSIONS", &cfg.Sessions.Enabled); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_SESSION_TIMEOUT", &cfg.Sessions.Timeout); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_SESSION_CONCURRENCY_WINDOW", &cfg.Sessions.ConcurrencyWindow); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_QOE", &cfg.QoE.Enabled); err != nil {
+		return err
+	}
+	if err := envInt("ESV_QOE_MAX_VIDEOS", &cfg.QoE.MaxVideos); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_VIDEO_STATS", &cfg.VideoStats.Enabled); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_VIDEO_STATS_RETENTION", &cfg.VideoStats.Retention); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_ROLLUPS", &cfg.Rollups.Enabled); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_ROLLUPS_FLUSH_INTERVAL", &cfg.Rollups.FlushInterval); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_ROLLUPS_RETENTION_MINUTE", &cfg.Rollups.Retention.Minute); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_ROLLUPS_RETENTION_HOUR", &cfg.Rollups.Retention.Hour); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_ROLLUPS_RETENTION_DAY", &cfg.Rollups.Retention.Day); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_STREAM", &cfg.Stream.Enabled); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_MQTT", &cfg.MQTT.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_MQTT_BROKER", &cfg.MQTT.Broker)
+	envString("ESV_MQTT_CLIENT_ID", &cfg.MQTT.ClientID)
+	envString("ESV_MQTT_USERNAME", &cfg.MQTT.Username)
+	envString("ESV_MQTT_PASSWORD", &cfg.MQTT.Password)
+	envString("ESV_MQTT_TOPIC", &cfg.MQTT.Topic)
+
+	if err := envBool("ESV_DASHBOARD", &cfg.Dashboard.Enabled); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_DASHBOARD_RETENTION", &cfg.Dashboard.Retention); err != nil {
+		return err
+	}
+	if err := envInt("ESV_DASHBOARD_MAX_ERRORS", &cfg.Dashboard.MaxErrors); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_ERROR_GROUPS", &cfg.Errors.Enabled); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_ERROR_GROUPS_RETENTION", &cfg.Errors.Retention); err != nil {
+		return err
+	}
+	if err := envInt("ESV_ERROR_GROUPS_MAX_GROUPS", &cfg.Errors.MaxGroups); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_RATE_LIMIT", &cfg.RateLimit.Enabled); err != nil {
+		return err
+	}
+	if err := envFloat("ESV_RATE_LIMIT_RPS", &cfg.RateLimit.RequestsPerSecond); err != nil {
+		return err
+	}
+	if err := envInt("ESV_RATE_LIMIT_BURST", &cfg.RateLimit.Burst); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_QUOTAS", &cfg.Quotas.Enabled); err != nil {
+		return err
+	}
+	if err := envInt64("ESV_QUOTA_SOFT", &cfg.Quotas.Daily.Soft); err != nil {
+		return err
+	}
+	if err := envInt64("ESV_QUOTA_HARD", &cfg.Quotas.Daily.Hard); err != nil {
+		return err
+	}
+	envString("ESV_QUOTA_FILE", &cfg.Quotas.File)
+
+	if err := envBool("ESV_ERASURE", &cfg.Erasure.Enabled); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_ERASURE_TIMEOUT", &cfg.Erasure.Timeout); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_ARCHIVE", &cfg.Archive.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_ARCHIVE_PROVIDER", &cfg.Archive.Provider)
+	envString("ESV_ARCHIVE_BUCKET", &cfg.Archive.Bucket)
+	envString("ESV_ARCHIVE_PREFIX", &cfg.Archive.Prefix)
+	envString("ESV_ARCHIVE_STORAGE_CLASS", &cfg.Archive.StorageClass)
+	envList("ESV_ARCHIVE_SOURCES", &cfg.Archive.Sources)
+	if err := envDuration("ESV_ARCHIVE_AFTER", &cfg.Archive.After); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_ARCHIVE_DELETE_AFTER", &cfg.Archive.DeleteAfter); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_LOAD_SHEDDING", &cfg.LoadShed.Enabled); err != nil {
+		return err
+	}
+	if err := envFloat("ESV_LOAD_SHEDDING_THRESHOLD", &cfg.LoadShed.Threshold); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_LOAD_SHEDDING_RETRY_AFTER", &cfg.LoadShed.RetryAfter); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_CORRECT_CLOCK_SKEW", &cfg.Timestamps.CorrectSkew); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_CLOCK_SKEW_THRESHOLD", &cfg.Timestamps.SkewThreshold); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_DEAD_LETTER", &cfg.DeadLetter.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_DEAD_LETTER_DIR", &cfg.DeadLetter.Dir)
+
+	if err := envBool("ESV_BUFFER", &cfg.Buffer.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_BUFFER_REDIS_ADDR", &cfg.Buffer.Redis.Addr)
+	envString("ESV_BUFFER_REDIS_USERNAME", &cfg.Buffer.Redis.Username)
+	envString("ESV_BUFFER_REDIS_PASSWORD", &cfg.Buffer.Redis.Password)
+	envString("ESV_BUFFER_STREAM", &cfg.Buffer.Redis.Stream)
+	envString("ESV_BUFFER_GROUP", &cfg.Buffer.Redis.Group)
+	envString("ESV_BUFFER_CONSUMER", &cfg.Buffer.Redis.Consumer)
+
+	envString("ESV_COORDINATION_BACKEND", &cfg.Coordination.Backend)
+	envString("ESV_COORDINATION_ADDR", &cfg.Coordination.Addr)
+	envString("ESV_COORDINATION_PASSWORD", &cfg.Coordination.Password)
+
+	if err := envBool("ESV_WAL", &cfg.WAL.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_WAL_DIR", &cfg.WAL.Dir)
+
+	if err := envBool("ESV_ALERTS", &cfg.Alerts.Enabled); err != nil {
+		return err
+	}
+	// A webhook given in the environment is added to those of the file
+	if url := os.Getenv("ESV_ALERTS_WEBHOOK_URL"); url != "" {
+		cfg.Alerts.Webhooks = append(cfg.Alerts.Webhooks, anomaly.Webhook{
+			URL:        url,
+			Format:     os.Getenv("ESV_ALERTS_WEBHOOK_FORMAT"),
+			RoutingKey: os.Getenv("ESV_ALERTS_PAGERDUTY_ROUTING_KEY"),
+		})
+	}
+
+	if err := envBool("ESV_TENANCY", &cfg.Tenancy.Enabled); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_METRICS", &cfg.Metrics.Enabled); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_TRACING", &cfg.Tracing.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_TRACING_ENDPOINT", &cfg.Tracing.Endpoint)
+	envString("ESV_TRACING_PROTOCOL", &cfg.Tracing.Protocol)
+	if err := envBool("ESV_TRACING_INSECURE", &cfg.Tracing.Insecure); err != nil {
+		return err
+	}
+	envString("ESV_TRACING_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	if err := envFloat("ESV_TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_DEDUP", &cfg.Dedup.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_DEDUP_FILE", &cfg.Dedup.File)
+	if err := envBool("ESV_DEDUP_EVENTS", &cfg.Dedup.Events); err != nil {
+		return err
+	}
+	envString("ESV_DEDUP_EVENT_FILE", &cfg.Dedup.EventFile)
+	if err := envBool("ESV_DEDUP_LEDGER", &cfg.Dedup.Ledger); err != nil {
+		return err
+	}
+	envString("ESV_DEDUP_LEDGER_FILE", &cfg.Dedup.LedgerFile)
+
+	if err := envBool("ESV_SAMPLING", &cfg.Sampling.Enabled); err != nil {
+		return err
+	}
+
+	envString("ESV_SCRUB_SALT", &cfg.Scrubbing.Salt)
+	envString("ESV_SCRUB_USER_ID", &cfg.Scrubbing.UserID)
+	envString("ESV_SCRUB_IP", &cfg.Scrubbing.IP)
+	envList("ESV_SCRUB_CUSTOM_DATA_KEYS", &cfg.Scrubbing.CustomDataKeys)
+	envString("ESV_SCRUB_CUSTOM_DATA", &cfg.Scrubbing.CustomData)
+
+	if err := envBool("ESV_BOTS", &cfg.Bots.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_BOTS_ACTION", (*string)(&cfg.Bots.Action))
+	envList("ESV_BOTS_USER_AGENTS", &cfg.Bots.UserAgents)
+	envList("ESV_BOTS_DATACENTER_RANGES", &cfg.Bots.DatacenterRanges)
+	envString("ESV_BOTS_DATACENTER_RANGES_FILE", &cfg.Bots.DatacenterRangesFile)
+
+	if err := envBool("ESV_TAXONOMY", &cfg.Taxonomy.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_TAXONOMY_ACTION", (*string)(&cfg.Taxonomy.Action))
+	envString("ESV_TAXONOMY_QUARANTINE_DIR", &cfg.Taxonomy.QuarantineDir)
+
+	if err := envBool("ESV_DIMENSIONS", &cfg.Dimensions.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_DIMENSIONS_FILE", &cfg.Dimensions.File)
+
+	if err := envBool("ESV_CACHE", &cfg.Cache.Enabled); err != nil {
+		return err
+	}
+	if err := envDuration("ESV_CACHE_TTL", &cfg.Cache.TTL); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_METADATA", &cfg.Metadata.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_METADATA_URL", &cfg.Metadata.URL)
+	// ESV_METADATA_AUTHORIZATION is the Authorization header of lookups
+	if value, ok := os.LookupEnv("ESV_METADATA_AUTHORIZATION"); ok {
+		if cfg.Metadata.Headers == nil {
+			cfg.Metadata.Headers = make(map[string]string)
+		}
+		cfg.Metadata.Headers["Authorization"] = value
+	}
+	if err := envDuration("ESV_METADATA_TTL", &cfg.Metadata.TTL); err != nil {
+		return err
+	}
+
+	if err := envBool("ESV_IDENTITIES", &cfg.Identities.Enabled); err != nil {
+		return err
+	}
+	envString("ESV_IDENTITIES_FILE", &cfg.Identities.File)
+	if err := envBool("ESV_IDENTITIES_MERGE_SESSIONS", &cfg.Identities.MergeSessions); err != nil {
+		return err
+	}
+
+	envList("ESV_PIPELINE", &cfg.Pipeline.Processors)
+
+	envString("ESV_GEOIP_DATABASE", &cfg.Enrichment.GeoIPDatabase)
+	envString("ESV_ASN_DATABASE", &cfg.Enrichment.ASNDatabase)
+	if err := envBool("ESV_ENRICH_USER_AGENT", &cfg.Enrichment.UserAgent); err != nil {
+		return err
+	}
+	if err := envBool("ESV_ENRICH_CDN", &cfg.Enrichment.CDN); err != nil {
+		return err
+	}
+	if err := envBool("ESV_ENRICH_RENDITIONS", &cfg.Enrichment.Renditions); err != nil {
+		return err
+	}
+	if err := envBool("ESV_ENRICH_ERRORS", &cfg.Enrichment.Errors); err != nil {
+		return err
+	}
+	envString("ESV_REGION", &cfg.Regions.Self)
+	if err := envBool("ESV_SDK_CONFIG", &cfg.SDK.Enabled); err != nil {
+		return err
+	}
+
+	envList("ESV_CORS_ORIGINS", &cfg.CORS.AllowedOrigins)
+	if err := envBool("ESV_CORS_CREDENTIALS", &cfg.CORS.AllowCredentials); err != nil {
+		return err
+	}
+	return nil
+}
+
+func envString(name string, target *string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*target = value
+	}
+}
+
+// envList reads a comma-separated list
+func envList(name string, target *[]string) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*target = items
+}
+
+func envInt(name string, target *int) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func envInt64(name string, target *int64) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func envFloat(name string, target *float64) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func envBool(name string, target *bool) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
+
+func envDuration(name string, target *time.Duration) error {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*target = parsed
+	return nil
+}
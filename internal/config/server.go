@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TimeoutsConfig bounds how long a client may take to send a request and
+// read the response, so slow or stalled clients can't hold connections and
+// goroutines forever. Zero disables a timeout.
+type TimeoutsConfig struct {
+	// ReadHeader bounds reading the request line and headers
+	ReadHeader time.Duration `yaml:"readHeader"`
+	// Read bounds reading the whole request, body included
+	Read time.Duration `yaml:"read"`
+	// Write bounds handling the request and writing the response
+	Write time.Duration `yaml:"write"`
+	// Idle bounds how long a keep-alive connection waits for its next
+	// request
+	Idle time.Duration `yaml:"idle"`
+
+	// Routes replaces Read and Write for a route path, or for every path
+	// under it when it ends in /. The event stream and WebSocket routes
+	// have no timeouts unless they are listed.
+	Routes map[string]time.Duration `yaml:"routes"`
+}
+
+func (t TimeoutsConfig) validate() error {
+	for name, d := range map[string]time.Duration{
+		"readHeader": t.ReadHeader, "read": t.Read, "write": t.Write, "idle": t.Idle,
+	} {
+		if d < 0 {
+			return fmt.Errorf("server timeout %s must not be negative", name)
+		}
+	}
+	for route, d := range t.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("server timeout route %q must start with /", route)
+		}
+		if d < 0 {
+			return fmt.Errorf("server timeout for %s must not be negative", route)
+		}
+	}
+	return nil
+}
+
+// NewServer returns a server for handler on addr with the header timeout,
+// idle timeout and header size limit applied. The read and write timeouts
+// depend on the route and are applied by the API's handler, since a
+// server-wide write timeout would cut off event streams.
+func (c Config) NewServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: c.Server.Timeouts.ReadHeader,
+		IdleTimeout:       c.Server.Timeouts.Idle,
+		MaxHeaderBytes:    c.Server.MaxHeaderBytes,
+	}
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adtyap26/event-stream-video/internal/deadletter"
+	"github.com/adtyap26/event-stream-video/internal/logger"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/sink/bigquery"
+	"github.com/adtyap26/event-stream-video/internal/sink/clickhouse"
+	"github.com/adtyap26/event-stream-video/internal/sink/fanout"
+	"github.com/adtyap26/event-stream-video/internal/sink/kinesis"
+	"github.com/adtyap26/event-stream-video/internal/sink/natsjs"
+	"github.com/adtyap26/event-stream-video/internal/sink/objectstore"
+	"github.com/adtyap26/event-stream-video/internal/sink/parquet"
+	"github.com/adtyap26/event-stream-video/internal/sink/pubsub"
+	"github.com/adtyap26/event-stream-video/internal/sink/redisstream"
+	"github.com/adtyap26/event-stream-video/internal/sink/sqldb"
+	"github.com/adtyap26/event-stream-video/internal/sink/tenant"
+	"github.com/adtyap26/event-stream-video/internal/sink/wal"
+)
+
+// NewSink creates the event sink selected in the sink section. With
+// tenancy enabled, each tenant gets its own sink on its first batch, except
+// with the message streams, where one connection publishes every tenant and
+// events carry their tenant.
+func (c Config) NewSink() (sink.EventSink, error) {
+	if c.Tenancy.Enabled && !sharesTenants(c.Sink.Type) {
+		return tenant.NewRouter(func(name string) (sink.EventSink, error) {
+			return c.ForTenant(name).newBaseSink()
+		}), nil
+	}
+	return c.newBaseSink()
+}
+
+// sharesTenants reports whether one sink of type typ publishes the events
+// of every tenant
+func sharesTenants(typ string) bool {
+	switch typ {
+	case SinkNATS, SinkKinesis, SinkPubSub:
+		return true
+	}
+	return false
+}
+
+// newBaseSink creates a sink of the configured type, writing to the fanout
+// sinks too when there are any
+func (c Config) newBaseSink() (sink.EventSink, error) {
+	primary, err := c.newSinkOfType(c.Sink.Type)
+	if err != nil || len(c.Sink.Fanout) == 0 {
+		return primary, err
+	}
+
+	outputs := []fanout.Output{{Name: c.Sink.Type, Sink: primary, Required: true}}
+	closeAll := func() {
+		for _, output := range outputs {
+			output.Sink.Close()
+		}
+	}
+	for _, f := range c.Sink.Fanout {
+		s, err := c.newSinkOfType(f.Type)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("fanout sink %s: %w", f.Type, err)
+		}
+		outputs = append(outputs, fanout.Output{
+			Name:        f.Type,
+			Sink:        s,
+			Required:    f.Required,
+			QueueSize:   f.QueueSize,
+			MaxAttempts: f.MaxAttempts,
+		})
+	}
+	fanoutSink, err := fanout.New(outputs...)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+	return fanoutSink, nil
+}
+
+// newSinkOfType creates a single sink of type typ from its section
+func (c Config) newSinkOfType(typ string) (sink.EventSink, error) {
+	switch typ {
+	case SinkFile:
+		opts := c.LoggerOptions()
+		keys, err := c.NewKeyring()
+		if err != nil {
+			return nil, err
+		}
+		opts.Keys = keys
+		eventLogger, err := logger.NewEventLoggerWithOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return eventLogger, nil
+	case SinkClickHouse:
+		chSink, err := clickhouse.New(c.Sink.ClickHouse)
+		if err != nil {
+			return nil, err
+		}
+		return chSink, nil
+	case SinkBigQuery:
+		bqSink, err := bigquery.New(context.Background(), c.Sink.BigQuery)
+		if err != nil {
+			return nil, err
+		}
+		return bqSink, nil
+	case SinkKinesis:
+		kinesisSink, err := kinesis.New(context.Background(), c.Sink.Kinesis)
+		if err != nil {
+			return nil, err
+		}
+		return kinesisSink, nil
+	case SinkObjectStore:
+		objSink, err := objectstore.NewFromConfig(context.Background(), c.Sink.ObjectStore)
+		if err != nil {
+			return nil, err
+		}
+		return objSink, nil
+	case SinkParquet:
+		parquetSink, err := parquet.New(c.Sink.Parquet)
+		if err != nil {
+			return nil, err
+		}
+		return parquetSink, nil
+	case SinkSQL:
+		sqlSink, err := sqldb.New(c.Sink.SQL)
+		if err != nil {
+			return nil, err
+		}
+		return sqlSink, nil
+	case SinkNATS:
+		natsSink, err := natsjs.New(c.Sink.NATS)
+		if err != nil {
+			return nil, err
+		}
+		return natsSink, nil
+	case SinkPubSub:
+		pubsubSink, err := pubsub.New(context.Background(), c.Sink.PubSub)
+		if err != nil {
+			return nil, err
+		}
+		return pubsubSink, nil
+	case SinkRedis:
+		redisSink, err := redisstream.New(c.Sink.Redis)
+		if err != nil {
+			return nil, err
+		}
+		return redisSink, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", typ)
+	}
+}
+
+// NewBuffer starts buffering batches in Redis ahead of target, with
+// deadLetters taking the batches target keeps rejecting, or returns nil
+// when buffering is disabled
+func (c Config) NewBuffer(target sink.EventSink, deadLetters *deadletter.Queue) (*redisstream.Buffer, error) {
+	if !c.Buffer.Enabled {
+		return nil, nil
+	}
+	return redisstream.NewBuffer(c.Buffer.Redis, target, deadLetters)
+}
+
+// OpenWAL opens the write-ahead log and starts shipping it to target, with
+// deadLetters taking the batches target keeps rejecting, or returns nil
+// when the log is disabled
+func (c Config) OpenWAL(target sink.EventSink, deadLetters *deadletter.Queue) (*wal.Log, error) {
+	if !c.WAL.Enabled {
+		return nil, nil
+	}
+	return wal.Open(c.WAL, target, deadLetters)
+}
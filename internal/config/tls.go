@@ -0,0 +1,159 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig configures TLS termination in the server. TLS is disabled
+// unless a certificate and key or autocert hosts are set.
+type TLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+
+	// AutocertHosts obtains certificates from Let's Encrypt for these
+	// hostnames instead of reading them from files
+	AutocertHosts []string `yaml:"autocertHosts"`
+	// AutocertCacheDir keeps issued certificates across restarts
+	AutocertCacheDir string `yaml:"autocertCacheDir"`
+	// AutocertEmail is given to Let's Encrypt for expiry notices
+	AutocertEmail string `yaml:"autocertEmail"`
+	// HTTPPort redirects plain HTTP to HTTPS and, with autocert, answers
+	// ACME http-01 challenges. 0 disables it; autocert then relies on
+	// tls-alpn-01 on the TLS port, which must be 443.
+	HTTPPort int `yaml:"httpPort"`
+
+	// ClientCAFile verifies client certificates against the CAs in this PEM
+	// file, for mTLS callers mapped to tenants by auth.certificates
+	ClientCAFile string `yaml:"clientCAFile"`
+	// ClientAuth is "verify" to verify the certificates clients present,
+	// leaving the others to API keys, or "require" to refuse connections
+	// without a valid one. It defaults to verify.
+	ClientAuth string `yaml:"clientAuth"`
+}
+
+// Client authentication modes
+const (
+	ClientAuthVerify  = "verify"
+	ClientAuthRequire = "require"
+)
+
+// Enabled reports whether the server should terminate TLS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || len(t.AutocertHosts) > 0
+}
+
+func (t TLSConfig) validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("tls certFile and keyFile must be set together")
+	}
+	if t.CertFile != "" && len(t.AutocertHosts) > 0 {
+		return errors.New("tls certFile and autocertHosts are mutually exclusive")
+	}
+	if t.HTTPPort < 0 || t.HTTPPort > 65535 {
+		return fmt.Errorf("invalid tls httpPort %d", t.HTTPPort)
+	}
+	switch t.ClientAuth {
+	case "", ClientAuthVerify, ClientAuthRequire:
+	default:
+		return fmt.Errorf("invalid tls clientAuth %q, must be %s or %s", t.ClientAuth, ClientAuthVerify, ClientAuthRequire)
+	}
+	if t.ClientAuth != "" && t.ClientCAFile == "" {
+		return errors.New("tls clientAuth needs clientCAFile")
+	}
+	if t.ClientCAFile != "" && !t.Enabled() {
+		return errors.New("tls clientCAFile needs a certificate or autocert hosts")
+	}
+	return nil
+}
+
+// NewTLS builds the server's TLS configuration, or returns nil when TLS is
+// disabled. When an HTTP port is set it also returns the handler for that
+// port.
+func (c Config) NewTLS() (*tls.Config, http.Handler, error) {
+	t := c.Server.TLS
+	if !t.Enabled() {
+		return nil, nil, nil
+	}
+
+	var redirect http.Handler
+	if t.HTTPPort > 0 {
+		redirect = httpsRedirect(c.Server.Port)
+	}
+
+	if len(t.AutocertHosts) == 0 {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		tlsConfig := &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		}
+		if err := t.verifyClients(tlsConfig); err != nil {
+			return nil, nil, err
+		}
+		return tlsConfig, redirect, nil
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(t.AutocertHosts...),
+		Cache:      autocert.DirCache(t.AutocertCacheDir),
+		Email:      t.AutocertEmail,
+	}
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	if err := t.verifyClients(tlsConfig); err != nil {
+		return nil, nil, err
+	}
+
+	if redirect != nil {
+		redirect = manager.HTTPHandler(redirect)
+	}
+	return tlsConfig, redirect, nil
+}
+
+// verifyClients has tlsConfig verify client certificates against the
+// client CAs, if any are configured
+func (t TLSConfig) verifyClients(tlsConfig *tls.Config) error {
+	if t.ClientCAFile == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(t.ClientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CAs: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", t.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if t.ClientAuth == ClientAuthRequire {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return nil
+}
+
+// httpsRedirect sends requests to the same URL on the TLS port
+func httpsRedirect(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,77 @@
+package coordination
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/adtyap26/event-stream-video/internal/ratelimit"
+)
+
+var _ ratelimit.Shared = (*Buckets)(nil)
+
+// Buckets keeps each token bucket as a hash of its tokens and when they
+// were counted, by the Redis clock so collectors' clocks needn't agree
+type Buckets struct {
+	r *Redis
+}
+
+// Buckets returns the shared rate limit buckets
+func (r *Redis) Buckets() *Buckets {
+	return &Buckets{r: r}
+}
+
+// takeTokenScript refills the bucket at ARGV[1] tokens per second up to
+// ARGV[2] and takes a token, returning whether it could and otherwise how
+// many milliseconds until it can. Idle buckets expire once full.
+var takeTokenScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(bucket[1]) or burst
+local at = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * limit / 1000)
+
+local allowed, wait = 0, 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+elseif limit > 0 then
+  wait = math.ceil((1 - tokens) * 1000 / limit)
+else
+  wait = 1000
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', now)
+local idle = 3600000
+if limit > 0 then
+  idle = math.ceil(burst * 1000 / limit) + 1000
+end
+redis.call('PEXPIRE', KEYS[1], idle)
+return {allowed, wait}
+`)
+
+// Take takes a token from the bucket of key
+func (b *Buckets) Take(key string, limit float64, burst int) (bool, time.Duration, error) {
+	if math.IsInf(limit, 1) {
+		return true, 0, nil
+	}
+
+	ctx, cancel := b.r.context()
+	defer cancel()
+	reply, err := takeTokenScript.Run(ctx, b.r.client, []string{b.r.key("ratelimit", key)},
+		strconv.FormatFloat(limit, 'g', -1, 64), burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(reply) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	return reply[0] == 1, time.Duration(reply[1]) * time.Millisecond, nil
+}
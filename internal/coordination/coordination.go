@@ -0,0 +1,133 @@
+// Package coordination shares state between the collectors of a deployment
+// behind a load balancer: batch and event dedup, the batch ledger, session
+// state and rate limit buckets. Without it each collector keeps its own, so
+// a batch retried against another collector is stored twice and a session
+// whose events reach several collectors is split between them.
+package coordination
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// BackendRedis keeps the shared state in Redis
+const BackendRedis = "redis"
+
+// Config configures the coordination store
+type Config struct {
+	// Backend is where the state is shared, "redis"; empty keeps state
+	// per collector
+	Backend string `yaml:"backend"`
+	// Addr is the host:port of the Redis server
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	TLS      bool   `yaml:"tls"`
+
+	// Prefix starts every key, so deployments can share a server
+	Prefix string `yaml:"prefix"`
+	// Timeout bounds each Redis command
+	Timeout time.Duration `yaml:"timeout"`
+	// DedupTTL is how long batch and event keys and ledger batches are
+	// remembered, which takes the place of the dedup capacities
+	DedupTTL time.Duration `yaml:"dedupTTL"`
+}
+
+// DefaultConfig keeps state per collector; the other fields are the
+// defaults once a backend is set
+func DefaultConfig() Config {
+	return Config{
+		Addr:     "localhost:6379",
+		Prefix:   "esv",
+		Timeout:  2 * time.Second,
+		DedupTTL: 24 * time.Hour,
+	}
+}
+
+// Validate checks the backend and durations
+func (c Config) Validate() error {
+	switch c.Backend {
+	case "":
+		return nil
+	case BackendRedis:
+	default:
+		return fmt.Errorf("unknown coordination backend %q, must be %s", c.Backend, BackendRedis)
+	}
+	if c.Addr == "" {
+		return errors.New("coordination.addr is required")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("invalid coordination timeout %v", c.Timeout)
+	}
+	if c.DedupTTL <= 0 {
+		return fmt.Errorf("invalid coordination dedupTTL %v", c.DedupTTL)
+	}
+	return nil
+}
+
+// Redis holds the shared state in a Redis server. Its views are safe for
+// concurrent use, by any number of collectors.
+type Redis struct {
+	client   *redis.Client
+	prefix   string
+	timeout  time.Duration
+	dedupTTL time.Duration
+}
+
+// New connects to the store of cfg, or returns nil when no backend is
+// configured
+func New(cfg Config) (*Redis, error) {
+	if cfg.Backend == "" {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts := &redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to coordination Redis: %w", err)
+	}
+	return &Redis{client: client, prefix: cfg.Prefix, timeout: cfg.Timeout, dedupTTL: cfg.DedupTTL}, nil
+}
+
+// key prefixes the parts of a key
+func (r *Redis) key(parts ...string) string {
+	k := r.prefix
+	for _, part := range parts {
+		if k != "" {
+			k += ":"
+		}
+		k += part
+	}
+	return k
+}
+
+// context bounds a command by the timeout
+func (r *Redis) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.timeout)
+}
+
+// Close closes the connection
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,135 @@
+package coordination
+
+import (
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/adtyap26/event-stream-video/internal/dedup"
+)
+
+var (
+	_ dedup.Shared       = (*KeySet)(nil)
+	_ dedup.SharedLedger = (*Ledger)(nil)
+)
+
+// KeySet is a set of dedup keys, each expiring after the dedup TTL
+type KeySet struct {
+	r    *Redis
+	name string
+}
+
+// Keys returns the key set called name, e.g. "batches"
+func (r *Redis) Keys(name string) *KeySet {
+	return &KeySet{r: r, name: name}
+}
+
+// Mark records key and reports whether it was already recorded
+func (s *KeySet) Mark(key string) (bool, error) {
+	ctx, cancel := s.r.context()
+	defer cancel()
+	added, err := s.r.client.SetNX(ctx, s.r.key("dedup", s.name, key), 1, s.r.dedupTTL).Result()
+	if err != nil {
+		return false, err
+	}
+	return !added, nil
+}
+
+// Unmark forgets key
+func (s *KeySet) Unmark(key string) error {
+	ctx, cancel := s.r.context()
+	defer cancel()
+	return s.r.client.Del(ctx, s.r.key("dedup", s.name, key)).Err()
+}
+
+// Ledger keeps each batch as a hash from event ID to "0" while an attempt
+// stores the event and "1" once it is stored
+type Ledger struct {
+	r *Redis
+}
+
+// Ledger returns the shared batch ledger
+func (r *Redis) Ledger() *Ledger {
+	return &Ledger{r: r}
+}
+
+// claimScript marks the events of ARGV[2:] as in flight unless they are
+// already recorded, returning 1 for each that was, and keeps the batch for
+// ARGV[1] milliseconds
+var claimScript = redis.NewScript(`
+local seen = {}
+for i = 2, #ARGV do
+  seen[i - 1] = 1 - redis.call('HSETNX', KEYS[1], ARGV[i], '0')
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return seen
+`)
+
+// releaseScript drops the events of ARGV that are still in flight
+var releaseScript = redis.NewScript(`
+for i = 1, #ARGV do
+  if redis.call('HGET', KEYS[1], ARGV[i]) == '0' then
+    redis.call('HDEL', KEYS[1], ARGV[i])
+  end
+end
+return 0
+`)
+
+func (l *Ledger) batch(key string) string {
+	return l.r.key("ledger", key)
+}
+
+// Claim reports for each of eventIDs whether it was already stored or is
+// being stored by another attempt, on any collector, and marks the others
+// as in flight
+func (l *Ledger) Claim(key string, eventIDs []string) ([]bool, error) {
+	args := make([]interface{}, 0, len(eventIDs)+1)
+	args = append(args, strconv.FormatInt(l.r.dedupTTL.Milliseconds(), 10))
+	for _, id := range eventIDs {
+		args = append(args, id)
+	}
+
+	ctx, cancel := l.r.context()
+	defer cancel()
+	replies, err := claimScript.Run(ctx, l.r.client, []string{l.batch(key)}, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	seen := make([]bool, len(eventIDs))
+	for i := range seen {
+		seen[i] = i < len(replies) && replies[i] == 1
+	}
+	return seen, nil
+}
+
+// Commit records eventIDs as stored
+func (l *Ledger) Commit(key string, eventIDs []string) error {
+	values := make([]interface{}, 0, 2*len(eventIDs))
+	for _, id := range eventIDs {
+		values = append(values, id, "1")
+	}
+
+	ctx, cancel := l.r.context()
+	defer cancel()
+	_, err := l.r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, l.batch(key), values...)
+		pipe.PExpire(ctx, l.batch(key), l.r.dedupTTL)
+		return nil
+	})
+	return err
+}
+
+// Release drops the claims on eventIDs, keeping the events already stored
+func (l *Ledger) Release(key string, eventIDs []string) error {
+	if len(eventIDs) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(eventIDs))
+	for i, id := range eventIDs {
+		args[i] = id
+	}
+
+	ctx, cancel := l.r.context()
+	defer cancel()
+	return releaseScript.Run(ctx, l.r.client, []string{l.batch(key)}, args...).Err()
+}
@@ -0,0 +1,167 @@
+package coordination
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+var _ session.Store = (*Sessions)(nil)
+
+const (
+	// updateAttempts is how many times an update is tried while other
+	// collectors keep changing the session
+	updateAttempts = 20
+	// readChunk is how many sessions All reads at a time
+	readChunk = 500
+)
+
+// Sessions keeps each session under its own key, with an index of the
+// sessions sorted by when they were last seen
+type Sessions struct {
+	r *Redis
+	// ttl expires the sessions no collector ended, e.g. as all of them were
+	// down
+	ttl time.Duration
+}
+
+// Sessions returns the shared session states of sessions ending after
+// timeout
+func (r *Redis) Sessions(timeout time.Duration) *Sessions {
+	return &Sessions{r: r, ttl: 2*timeout + time.Minute}
+}
+
+// takeScript deletes and returns the session ARGV[1] if it was last seen
+// before ARGV[2]
+var takeScript = redis.NewScript(`
+local seen = redis.call('ZSCORE', KEYS[2], ARGV[1])
+if not seen or tonumber(seen) >= tonumber(ARGV[2]) then
+  return false
+end
+local state = redis.call('GET', KEYS[1])
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[2], ARGV[1])
+return state
+`)
+
+func (s *Sessions) state(key string) string {
+	return s.r.key("session", key)
+}
+
+func (s *Sessions) index() string {
+	return s.r.key("sessions")
+}
+
+// Update replaces the state of key by what fn returns, optimistically: it
+// is tried again when another collector changed the state meanwhile
+func (s *Sessions) Update(key string, fn func(state []byte) ([]byte, time.Time, error)) error {
+	ctx, cancel := s.r.context()
+	defer cancel()
+
+	update := func(tx *redis.Tx) error {
+		state, err := tx.Get(ctx, s.state(key)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			state = nil
+		} else if err != nil {
+			return err
+		}
+		next, lastSeen, err := fn(state)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if next == nil {
+				pipe.Del(ctx, s.state(key))
+				pipe.ZRem(ctx, s.index(), key)
+				return nil
+			}
+			pipe.Set(ctx, s.state(key), next, s.ttl)
+			pipe.ZAdd(ctx, s.index(), redis.Z{Score: float64(lastSeen.UnixMilli()), Member: key})
+			return nil
+		})
+		return err
+	}
+
+	for range updateAttempts {
+		err := s.r.client.Watch(ctx, update, s.state(key))
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return errors.New("session changed by other collectors on every attempt")
+}
+
+// Get returns the state of key
+func (s *Sessions) Get(key string) ([]byte, bool, error) {
+	ctx, cancel := s.r.context()
+	defer cancel()
+	state, err := s.r.client.Get(ctx, s.state(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return state, true, nil
+}
+
+// All returns the states of the indexed sessions
+func (s *Sessions) All() ([][]byte, error) {
+	ctx, cancel := s.r.context()
+	defer cancel()
+	keys, err := s.r.client.ZRange(ctx, s.index(), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var states [][]byte
+	for start := 0; start < len(keys); start += readChunk {
+		chunk := keys[start:min(start+readChunk, len(keys))]
+		stateKeys := make([]string, len(chunk))
+		for i, key := range chunk {
+			stateKeys[i] = s.state(key)
+		}
+		values, err := s.r.client.MGet(ctx, stateKeys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			// Sessions ended since the index was read are nil
+			if state, ok := value.(string); ok {
+				states = append(states, []byte(state))
+			}
+		}
+	}
+	return states, nil
+}
+
+// Expired returns the sessions last seen before cutoff
+func (s *Sessions) Expired(cutoff time.Time) ([]string, error) {
+	ctx, cancel := s.r.context()
+	defer cancel()
+	return s.r.client.ZRangeByScore(ctx, s.index(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(cutoff.UnixMilli(), 10),
+	}).Result()
+}
+
+// Take deletes and returns the state of key if it is still last seen
+// before cutoff. A session whose state expired is dropped from the index
+// and reported as taken by another collector.
+func (s *Sessions) Take(key string, cutoff time.Time) ([]byte, bool, error) {
+	ctx, cancel := s.r.context()
+	defer cancel()
+	state, err := takeScript.Run(ctx, s.r.client, []string{s.state(key), s.index()},
+		key, strconv.FormatInt(cutoff.UnixMilli(), 10)).Text()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(state), true, nil
+}
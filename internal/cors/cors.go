@@ -0,0 +1,295 @@
+// Package cors evaluates the cross-origin policy of the collector's
+// browser-facing endpoints
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config is a CORS policy as written in the configuration file
+type Config struct {
+	// AllowedOrigins lists origins allowed to call the API. An entry is "*"
+	// for any origin, an exact origin such as https://example.com, a
+	// wildcard such as https://*.example.com, or a regular expression
+	// prefixed with "regex:" that must match the whole origin.
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+	// AllowedMethods are answered to preflight requests
+	AllowedMethods []string `yaml:"allowedMethods"`
+	// AllowedHeaders are the request headers browsers may send; "*" allows
+	// whatever the preflight asks for
+	AllowedHeaders []string `yaml:"allowedHeaders"`
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string `yaml:"exposedHeaders"`
+	// AllowCredentials lets browsers send cookies and client certificates.
+	// It needs a list of origins: with "*" any site could make credentialed
+	// requests.
+	AllowCredentials bool `yaml:"allowCredentials"`
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// DefaultConfig allows any origin to use the ingestion and stream endpoints
+func DefaultConfig() Config {
+	return Config{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodOptions},
+		AllowedHeaders: []string{
+			"Content-Type", "Content-Encoding", "Authorization", "X-API-Key",
+			"X-Analytics-Client", "X-Retry-Attempt", "X-Request-ID",
+		},
+		ExposedHeaders: []string{
+			"Retry-After", "X-Request-ID", "API-Version", "Deprecation", "Sunset", "Link",
+			"X-Quota-Limit", "X-Quota-Remaining", "X-Quota-Reset", "X-Quota-Warning",
+			"X-Processing-Time", "Server-Timing",
+		},
+		MaxAge: 10 * time.Minute,
+	}
+}
+
+// Policy is a compiled Config. It is safe for concurrent use, and Update
+// replaces it while requests are being checked against it.
+type Policy struct {
+	rules atomic.Pointer[rules]
+}
+
+// rules is the compiled form of one Config
+type rules struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	patterns    []*regexp.Regexp
+	methods     map[string]bool
+	anyHeader   bool
+	headers     map[string]bool
+	credentials bool
+
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+// New compiles cfg. Empty lists fall back to DefaultConfig.
+func New(cfg Config) (*Policy, error) {
+	r, err := compile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	p.rules.Store(r)
+	return p, nil
+}
+
+// Update replaces the policy with cfg. The policy is left as it was when
+// cfg doesn't compile.
+func (p *Policy) Update(cfg Config) error {
+	r, err := compile(cfg)
+	if err != nil {
+		return err
+	}
+	p.rules.Store(r)
+	return nil
+}
+
+func compile(cfg Config) (*rules, error) {
+	defaults := DefaultConfig()
+	if len(cfg.AllowedOrigins) == 0 {
+		cfg.AllowedOrigins = defaults.AllowedOrigins
+	}
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = defaults.AllowedMethods
+	}
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = defaults.AllowedHeaders
+	}
+	if cfg.MaxAge < 0 {
+		return nil, fmt.Errorf("invalid CORS max age %s", cfg.MaxAge)
+	}
+
+	p := &rules{
+		origins:     make(map[string]bool),
+		methods:     make(map[string]bool),
+		headers:     make(map[string]bool),
+		credentials: cfg.AllowCredentials,
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		switch {
+		case origin == "*" && cfg.AllowCredentials:
+			return nil, errors.New(`CORS allowCredentials can't be combined with the "*" origin`)
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.HasPrefix(origin, "regex:"):
+			pattern, err := regexp.Compile("^(?:" + strings.TrimPrefix(origin, "regex:") + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid CORS origin pattern %q: %w", origin, err)
+			}
+			p.patterns = append(p.patterns, pattern)
+		case strings.Contains(origin, "*"):
+			p.patterns = append(p.patterns, wildcard(strings.ToLower(origin)))
+		default:
+			p.origins[strings.ToLower(origin)] = true
+		}
+	}
+
+	methods := make([]string, 0, len(cfg.AllowedMethods))
+	for _, method := range cfg.AllowedMethods {
+		method = strings.ToUpper(method)
+		p.methods[method] = true
+		methods = append(methods, method)
+	}
+	p.allowMethods = strings.Join(methods, ", ")
+
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+			continue
+		}
+		p.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	p.allowHeaders = strings.Join(cfg.AllowedHeaders, ", ")
+	p.exposeHeaders = strings.Join(cfg.ExposedHeaders, ", ")
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge / time.Second))
+	}
+	return p, nil
+}
+
+// wildcard turns an origin with * into a pattern where * matches one or
+// more characters of the host, e.g. https://*.example.com
+func wildcard(origin string) *regexp.Regexp {
+	parts := strings.Split(origin, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, "[^/]+") + "$")
+}
+
+// AllowsOrigin reports whether origin may call the API
+func (p *Policy) AllowsOrigin(origin string) bool {
+	return p.rules.Load().allowsOrigin(origin)
+}
+
+func (p *rules) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether origin is the host the request was sent to
+func sameOrigin(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// allowsHeaders reports whether every header in a preflight's
+// Access-Control-Request-Headers list is allowed
+func (p *rules) allowsHeaders(requested string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !p.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// Result is the outcome of checking a request against the policy
+type Result int
+
+const (
+	// Continue means the request should be handled normally
+	Continue Result = iota
+	// Preflight means the response is complete and has no body
+	Preflight
+	// Forbidden means the origin, method or headers aren't allowed
+	Forbidden
+)
+
+// Apply sets the CORS response headers for r and tells the caller how to
+// proceed. Requests without an Origin header, or from the collector's own
+// origin such as its test page, aren't cross-origin browser requests and
+// always continue.
+func (p *Policy) Apply(w http.ResponseWriter, r *http.Request) Result {
+	return p.rules.Load().apply(w, r)
+}
+
+func (p *rules) apply(w http.ResponseWriter, r *http.Request) Result {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if origin == "" {
+		if r.Method == http.MethodOptions {
+			header.Set("Allow", p.allowMethods)
+			return Preflight
+		}
+		return Continue
+	}
+	if sameOrigin(origin, r) && !preflight {
+		return Continue
+	}
+	if !p.allowsOrigin(origin) {
+		return Forbidden
+	}
+	requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		if !p.methods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] || !p.allowsHeaders(requestedHeaders) {
+			return Forbidden
+		}
+	}
+
+	if p.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if p.exposeHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+		}
+		if r.Method == http.MethodOptions {
+			header.Set("Allow", p.allowMethods)
+			return Preflight
+		}
+		return Continue
+	}
+
+	header.Set("Access-Control-Allow-Methods", p.allowMethods)
+	if p.anyHeader && requestedHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", requestedHeaders)
+	} else {
+		header.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	}
+	if p.maxAge != "" {
+		header.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	return Preflight
+}
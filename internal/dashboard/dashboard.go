@@ -0,0 +1,23 @@
+// Package dashboard embeds the collector's web dashboard, a single page
+// that polls the activity, session and video read APIs
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard's files. Mount it with http.StripPrefix so
+// that the page is at the root of the handler.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		// static is embedded at build time, so the directory always exists
+		panic(err)
+	}
+	return http.FileServerFS(files)
+}
@@ -0,0 +1,199 @@
+/**
+ * Collector dashboard
+ * Polls the read APIs and renders the tenant's recent activity
+ */
+(function (window, document) {
+  "use strict";
+
+  const POLL_INTERVAL = 2000; // 2 seconds
+  const API_KEY_STORAGE = "esv.dashboard.apiKey";
+
+  const windowSelect = document.getElementById("window");
+  const apiKeyInput = document.getElementById("api-key");
+  const statusLine = document.getElementById("status");
+  const chart = document.getElementById("rate-chart");
+
+  apiKeyInput.value = window.localStorage.getItem(API_KEY_STORAGE) || "";
+  apiKeyInput.addEventListener("change", function () {
+    window.localStorage.setItem(API_KEY_STORAGE, apiKeyInput.value);
+    refresh();
+  });
+  windowSelect.addEventListener("change", refresh);
+
+  /**
+   * Fetch a read API as JSON. Resolves to null for endpoints that are
+   * disabled in the collector's config.
+   * @param {string} path - API path and query
+   */
+  async function get(path) {
+    const headers = {};
+    if (apiKeyInput.value) {
+      headers["X-API-Key"] = apiKeyInput.value;
+    }
+    const response = await fetch(path, { headers: headers });
+    if (response.status === 404) {
+      return null;
+    }
+    if (response.status === 401) {
+      throw new Error("The collector requires an API key");
+    }
+    if (!response.ok) {
+      throw new Error(path + " answered " + response.status);
+    }
+    return response.json();
+  }
+
+  async function refresh() {
+    const win = windowSelect.value;
+    try {
+      const [rate, videos, sessions, errors] = await Promise.all([
+        get("/api/v1/activity/ingestion?window=" + win),
+        get("/api/v1/activity/videos?window=" + win),
+        get("/api/v1/sessions?limit=10"),
+        get("/api/v1/activity/errors?limit=20"),
+      ]);
+      statusLine.hidden = true;
+
+      if (rate) {
+        renderRate(rate);
+      }
+      if (videos) {
+        renderVideos(videos.videos);
+      }
+      renderSessions(sessions);
+      if (errors) {
+        renderErrors(errors.errors);
+      }
+    } catch (err) {
+      statusLine.textContent = err.message;
+      statusLine.hidden = false;
+    }
+  }
+
+  function renderRate(rate) {
+    const recent = rate.points.slice(-10);
+    const recentEvents = recent.reduce((sum, p) => sum + p.events, 0);
+    setText("current-rate", (recentEvents / Math.max(recent.length, 1)).toFixed(1));
+    setText("window-events", rate.events.toLocaleString());
+    setText("window-errors", rate.errors.toLocaleString());
+    drawChart(rate.points);
+  }
+
+  /**
+   * Draw events per second as bars, with errors in red on top
+   * @param {Array} points - One point per second, oldest first
+   */
+  function drawChart(points) {
+    const ratio = window.devicePixelRatio || 1;
+    chart.width = chart.clientWidth * ratio;
+    chart.height = chart.clientHeight * ratio;
+
+    const ctx = chart.getContext("2d");
+    ctx.scale(ratio, ratio);
+    const width = chart.clientWidth;
+    const height = chart.clientHeight;
+    ctx.clearRect(0, 0, width, height);
+
+    const max = Math.max(1, ...points.map((p) => p.events + p.errors));
+    const barWidth = width / Math.max(points.length, 1);
+
+    points.forEach(function (point, i) {
+      const x = i * barWidth;
+      const eventHeight = (point.events / max) * (height - 16);
+      const errorHeight = (point.errors / max) * (height - 16);
+      ctx.fillStyle = "#3b73b9";
+      ctx.fillRect(x, height - eventHeight, Math.max(barWidth - 1, 1), eventHeight);
+      ctx.fillStyle = "#d0342c";
+      ctx.fillRect(x, height - eventHeight - errorHeight, Math.max(barWidth - 1, 1), errorHeight);
+    });
+
+    ctx.fillStyle = "#666";
+    ctx.font = "11px sans-serif";
+    ctx.fillText("max " + max + " / s", 4, 12);
+  }
+
+  function renderVideos(videos) {
+    renderRows("top-videos", 3, videos, function (video) {
+      return [cell(video.videoId), cell(video.events, "num"), cell(video.plays, "num")];
+    });
+  }
+
+  function renderSessions(sessions) {
+    if (!sessions) {
+      setText("active-sessions", "off");
+      renderRows("sessions", 4, [], null, "Session tracking is disabled");
+      return;
+    }
+    setText("active-sessions", sessions.active.toLocaleString());
+    renderRows("sessions", 4, sessions.sessions, function (session) {
+      return [
+        cell(session.sessionId),
+        cell(session.videoId || ""),
+        cell(session.lastEvent + " " + formatTime(session.lastEventAt)),
+        cell(formatSeconds(session.watchTimeSeconds), "num"),
+      ];
+    });
+  }
+
+  function renderErrors(errors) {
+    renderRows("errors", 5, errors, function (error) {
+      return [
+        cell(formatTime(error.at)),
+        cell(error.source, "source-" + error.source),
+        cell(error.clientId || ""),
+        cell(error.batchId || error.sessionId || ""),
+        cell(error.message),
+      ];
+    });
+  }
+
+  /**
+   * Replace the rows of a table body
+   * @param {string} id - tbody element ID
+   * @param {number} columns - Column count, for the empty row
+   * @param {Array} items - Items to render
+   * @param {Function} toCells - Maps an item to its cells
+   * @param {string} emptyText - Shown when there are no items
+   */
+  function renderRows(id, columns, items, toCells, emptyText) {
+    const body = document.getElementById(id);
+    const rows = items.map(function (item) {
+      const row = document.createElement("tr");
+      row.append(...toCells(item));
+      return row;
+    });
+    if (rows.length === 0) {
+      const row = document.createElement("tr");
+      const empty = cell(emptyText || "Nothing yet", "empty");
+      empty.colSpan = columns;
+      row.append(empty);
+      rows.push(row);
+    }
+    body.replaceChildren(...rows);
+  }
+
+  function cell(text, className) {
+    const td = document.createElement("td");
+    td.textContent = text;
+    if (className) {
+      td.className = className;
+    }
+    return td;
+  }
+
+  function setText(id, text) {
+    document.getElementById(id).textContent = text;
+  }
+
+  function formatTime(value) {
+    return new Date(value).toLocaleTimeString();
+  }
+
+  function formatSeconds(seconds) {
+    const s = Math.round(seconds || 0);
+    return Math.floor(s / 60) + ":" + String(s % 60).padStart(2, "0");
+  }
+
+  refresh();
+  window.setInterval(refresh, POLL_INTERVAL);
+})(window, document);
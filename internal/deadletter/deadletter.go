@@ -0,0 +1,303 @@
+// Package deadletter keeps batches the sink failed to store so they can be
+// replayed once the sink is healthy again
+package deadletter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+// Entry is a dead-lettered batch together with why it could not be stored
+type Entry struct {
+	FailedAt time.Time         `json:"failedAt"`
+	Reason   string            `json:"reason"`
+	Batch    models.EventBatch `json:"batch"`
+
+	// File is the entry's path in the queue directory
+	File string `json:"-"`
+}
+
+// Queue stores dead-lettered batches as one JSON file each in a directory
+type Queue struct {
+	dir string
+	now func() time.Time
+}
+
+// Open creates the queue directory if needed
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create dead letter directory: %w", err)
+	}
+	return &Queue{dir: dir, now: time.Now}, nil
+}
+
+// Add persists batch with the error that made the sink reject it. The API
+// key is not written to disk.
+func (q *Queue) Add(batch models.EventBatch, reason error) error {
+	batch.APIKey = ""
+	entry := Entry{FailedAt: q.now().UTC(), Reason: reason.Error(), Batch: batch}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := fmt.Sprintf("%d-%s.json", entry.FailedAt.UnixNano(), hex.EncodeToString(suffix))
+	path := filepath.Join(q.dir, name)
+
+	// Write under a temporary name so List never sees a partial entry
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+// List returns the queued entries, oldest first
+func (q *Queue) List() ([]Entry, error) {
+	dirEntries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() && strings.HasSuffix(dirEntry.Name(), ".json") {
+			names = append(names, dirEntry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	entries := make([]Entry, 0, len(names))
+	for _, name := range names {
+		entry, err := readEntry(filepath.Join(q.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func readEntry(path string) (Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return Entry{}, fmt.Errorf("failed to decode dead letter %s: %w", filepath.Base(path), err)
+	}
+	entry.File = path
+	return entry, nil
+}
+
+// ReplayResult summarizes a Replay run
+type ReplayResult struct {
+	Replayed int `json:"replayed"`
+	Failed   int `json:"failed"`
+}
+
+// Replay sends every queued batch to target, oldest first, and removes the
+// entries that were stored. It stops at the first failure since the sink is
+// most likely still unavailable. Replay bypasses deduplication, so only one
+// replay should run at a time.
+func (q *Queue) Replay(ctx context.Context, target sink.EventSink) (ReplayResult, error) {
+	var result ReplayResult
+
+	entries, err := q.List()
+	if err != nil {
+		return result, err
+	}
+
+	for i, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			result.Failed = len(entries) - i
+			return result, err
+		}
+		if err := target.LogBatch(ctx, entry.Batch); err != nil {
+			result.Failed = len(entries) - i
+			return result, fmt.Errorf("failed to replay batch %s: %w", entry.Batch.BatchID, err)
+		}
+		if err := os.Remove(entry.File); err != nil {
+			slog.Error("Error removing replayed dead letter", "file", entry.File, "error", err)
+		}
+		result.Replayed++
+	}
+	return result, nil
+}
+
+// EraseUser removes the events of userID in tenant from every queued
+// entry. Entries left without events are deleted.
+func (q *Queue) EraseUser(ctx context.Context, tenant, userID string) (int64, error) {
+	entries, err := q.List()
+	if err != nil {
+		return 0, err
+	}
+
+	var erased int64
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return erased, err
+		}
+		if entry.Batch.Tenant != tenant {
+			continue
+		}
+		kept := entry.Batch.Events[:0:0]
+		for _, event := range entry.Batch.Events {
+			if event.UserID != userID {
+				kept = append(kept, event)
+			}
+		}
+		removed := len(entry.Batch.Events) - len(kept)
+		if removed == 0 {
+			continue
+		}
+
+		if len(kept) == 0 {
+			err = os.Remove(entry.File)
+		} else {
+			entry.Batch.Events = kept
+			err = q.rewrite(entry)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return erased, err
+		}
+		erased += int64(removed)
+	}
+	return erased, nil
+}
+
+// rewrite replaces the file of entry with its current contents
+func (q *Queue) rewrite(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	tmpPath := entry.File + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	if err := os.Rename(tmpPath, entry.File); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.Flusher       = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+	_ sink.Backlogger    = (*Sink)(nil)
+	_ sink.Eraser        = (*Sink)(nil)
+	_ sink.Querier       = (*Sink)(nil)
+)
+
+// Sink wraps another sink and moves batches it rejects to a Queue instead of
+// failing the request
+type Sink struct {
+	next  sink.EventSink
+	queue *Queue
+}
+
+// Wrap returns a sink that dead-letters the batches next fails to store
+func Wrap(next sink.EventSink, queue *Queue) *Sink {
+	return &Sink{next: next, queue: queue}
+}
+
+// LogBatch stores batch in the wrapped sink. When that fails the batch is
+// dead-lettered; an error is only returned if that fails too, or if the
+// sink is unavailable for now (sink.ErrUnavailable), which the client is
+// to retry rather than have the batch dead-lettered.
+func (s *Sink) LogBatch(ctx context.Context, batch models.EventBatch) error {
+	err := s.next.LogBatch(ctx, batch)
+	if err == nil || errors.Is(err, sink.ErrUnavailable) {
+		return err
+	}
+
+	if dlqErr := s.queue.Add(batch, err); dlqErr != nil {
+		return errors.Join(err, dlqErr)
+	}
+	metrics.DeadLettered.WithLabelValues(batch.Tenant).Inc()
+	slog.Warn("Dead-lettered batch", "clientId", batch.ClientID, "batchId", batch.BatchID, "error", err)
+	return nil
+}
+
+// Flush flushes the wrapped sink if it buffers writes
+func (s *Sink) Flush() error {
+	if flusher, ok := s.next.(sink.Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// CheckHealth checks the wrapped sink if it supports health checks
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	if checker, ok := s.next.(sink.HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// Backlog reports the wrapped sink's backlog if it queues writes
+func (s *Sink) Backlog() (queued, capacity int) {
+	if backlogger, ok := s.next.(sink.Backlogger); ok {
+		return backlogger.Backlog()
+	}
+	return 0, 0
+}
+
+// EraseUser erases from the wrapped sink and from batches waiting in the
+// queue, which would otherwise bring the events back on replay
+func (s *Sink) EraseUser(ctx context.Context, tenant, userID string) (int64, error) {
+	var erased int64
+	var err error
+	if eraser, ok := s.next.(sink.Eraser); ok {
+		erased, err = eraser.EraseUser(ctx, tenant, userID)
+	} else {
+		err = sink.ErrEraseUnsupported
+	}
+
+	queued, queueErr := s.queue.EraseUser(ctx, tenant, userID)
+	if queueErr != nil {
+		queueErr = fmt.Errorf("failed to erase dead letters: %w", queueErr)
+	}
+	return erased + queued, errors.Join(err, queueErr)
+}
+
+// QueryEvents queries the wrapped sink. Batches waiting in the queue
+// aren't stored yet and aren't searched.
+func (s *Sink) QueryEvents(ctx context.Context, q sink.Query) (sink.QueryResult, error) {
+	if querier, ok := s.next.(sink.Querier); ok {
+		return querier.QueryEvents(ctx, q)
+	}
+	return sink.QueryResult{}, sink.ErrQueryUnsupported
+}
+
+func (s *Sink) Close() error {
+	return s.next.Close()
+}
@@ -0,0 +1,155 @@
+package dedup
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCapacity is the number of batch keys remembered when none is configured
+const DefaultCapacity = 100000
+
+// DefaultEventCapacity is the number of event IDs remembered when none is
+// configured
+const DefaultEventCapacity = 1000000
+
+// Store persists seen keys so deduplication survives restarts
+type Store interface {
+	// Load returns previously recorded keys, oldest first
+	Load() ([]string, error)
+	// Add records a key
+	Add(key string) error
+	// Remove forgets a key
+	Remove(key string) error
+	Close() error
+}
+
+// Shared is a set of keys shared by the collectors of a deployment, so a
+// batch retried against another collector is still recognized. Keys are
+// forgotten after a while instead of by capacity.
+type Shared interface {
+	// Mark records key and reports whether it was already recorded
+	Mark(key string) (bool, error)
+	// Unmark forgets key
+	Unmark(key string) error
+}
+
+// Deduplicator remembers recently seen batch keys in an LRU, optionally
+// backed by a persistent Store, or in a Shared set
+type Deduplicator struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+	store    Store
+	shared   Shared
+}
+
+// New creates a Deduplicator holding up to capacity keys. store may be nil.
+// Keys already in the store are loaded into memory.
+func New(capacity int, store Store) (*Deduplicator, error) {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+
+	d := &Deduplicator{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		store:    store,
+	}
+
+	if store != nil {
+		keys, err := store.Load()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			d.add(key)
+		}
+	}
+	return d, nil
+}
+
+// NewShared creates a Deduplicator keeping its keys in shared only, so
+// collectors don't take a key another one forgot for seen
+func NewShared(shared Shared) *Deduplicator {
+	return &Deduplicator{shared: shared}
+}
+
+// BatchKey identifies a batch for deduplication. Batch IDs are only unique
+// per client, and clients only per tenant.
+func BatchKey(tenant, clientID, batchID string) string {
+	return tenant + "\x00" + clientID + "\x00" + batchID
+}
+
+// EventKey identifies an event for deduplication by its eventId, which
+// clients only generate uniquely per tenant
+func EventKey(tenant, eventID string) string {
+	return tenant + "\x00" + eventID
+}
+
+// CheckAndMark reports whether key was already seen and marks it as seen
+func (d *Deduplicator) CheckAndMark(key string) (bool, error) {
+	if d.shared != nil {
+		return d.shared.Mark(key)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.entries[key]; ok {
+		d.order.MoveToFront(elem)
+		return true, nil
+	}
+
+	d.add(key)
+	if d.store != nil {
+		if err := d.store.Add(key); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Forget removes key, e.g. when storing the batch failed and a retry
+// should be accepted
+func (d *Deduplicator) Forget(key string) error {
+	if d.shared != nil {
+		return d.shared.Unmark(key)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.entries[key]; ok {
+		d.order.Remove(elem)
+		delete(d.entries, key)
+	}
+	if d.store != nil {
+		return d.store.Remove(key)
+	}
+	return nil
+}
+
+// Close closes the backing store
+func (d *Deduplicator) Close() error {
+	if d.store != nil {
+		return d.store.Close()
+	}
+	return nil
+}
+
+// add inserts key at the front of the LRU, evicting the oldest key when full
+func (d *Deduplicator) add(key string) {
+	if elem, ok := d.entries[key]; ok {
+		d.order.MoveToFront(elem)
+		return
+	}
+
+	d.entries[key] = d.order.PushFront(key)
+	for d.order.Len() > d.capacity {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(string))
+	}
+}
@@ -0,0 +1,195 @@
+package dedup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FileStore persists keys in an append-only file. Removals are written as
+// tombstones and the file is compacted once it holds twice as many lines
+// as the keys worth keeping.
+type FileStore struct {
+	mu    sync.Mutex
+	path  string
+	keep  int
+	file  *os.File
+	lines int
+}
+
+// OpenFileStore opens or creates the key file at path. keep is the number of
+// most recent keys retained on compaction and should match the LRU capacity.
+func OpenFileStore(path string, keep int) (*FileStore, error) {
+	if keep <= 0 {
+		keep = DefaultCapacity
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dedup store: %w", err)
+	}
+	return &FileStore{path: path, keep: keep, file: file}, nil
+}
+
+// Load replays the file and returns the keys still present, oldest first
+func (s *FileStore) Load() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, lines, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	s.lines = lines
+
+	if len(keys) > s.keep {
+		keys = keys[len(keys)-s.keep:]
+	}
+	return keys, nil
+}
+
+func (s *FileStore) Add(key string) error {
+	return s.append("+" + escape(key))
+}
+
+func (s *FileStore) Remove(key string) error {
+	return s.append("-" + escape(key))
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func (s *FileStore) append(line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to write dedup store: %w", err)
+	}
+	s.lines++
+
+	if s.lines > 2*s.keep {
+		return s.compact()
+	}
+	return nil
+}
+
+// read parses the whole file, applying tombstones
+func (s *FileStore) read() ([]string, int, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read dedup store: %w", err)
+	}
+	defer f.Close()
+
+	var order []string
+	present := make(map[string]bool)
+	lines := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 2 {
+			continue
+		}
+		lines++
+
+		key := unescape(line[1:])
+		switch line[0] {
+		case '+':
+			if !present[key] {
+				order = append(order, key)
+			}
+			present[key] = true
+		case '-':
+			present[key] = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read dedup store: %w", err)
+	}
+
+	keys := order[:0]
+	for _, key := range order {
+		if present[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys, lines, nil
+}
+
+// compact rewrites the file with only the most recent keys. It writes a
+// temporary file and renames it over the original.
+func (s *FileStore) compact() error {
+	keys, _, err := s.read()
+	if err != nil {
+		return err
+	}
+	if len(keys) > s.keep {
+		keys = keys[len(keys)-s.keep:]
+	}
+
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to compact dedup store: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, key := range keys {
+		w.WriteString("+" + escape(key) + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact dedup store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact dedup store: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to compact dedup store: %w", err)
+	}
+
+	s.file.Close()
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen dedup store: %w", err)
+	}
+	s.file = file
+	s.lines = len(keys)
+	return nil
+}
+
+// Keys contain a NUL separator and may contain newlines, so both are escaped
+var keyEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\x00", `\0`)
+
+func escape(key string) string {
+	return keyEscaper.Replace(key)
+}
+
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case '0':
+			b.WriteByte(0)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
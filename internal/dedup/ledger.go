@@ -0,0 +1,192 @@
+package dedup
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// DefaultLedgerCapacity is the number of batches the ledger remembers when
+// none is configured. Each batch is kept with the IDs of its events.
+const DefaultLedgerCapacity = 10000
+
+// Ledger remembers which events of recent batches were stored, so a retried
+// batch can be told apart from a new one: when all of its events were
+// stored it was fully processed, otherwise only the missing events need
+// storing. Batches are kept in an LRU, optionally backed by a Store that
+// receives one record per stored attempt, or in a SharedLedger.
+type Ledger struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+	store    Store
+	shared   SharedLedger
+}
+
+// SharedLedger is a ledger shared by the collectors of a deployment, with
+// the methods of Ledger
+type SharedLedger interface {
+	Claim(key string, eventIDs []string) ([]bool, error)
+	Commit(key string, eventIDs []string) error
+	Release(key string, eventIDs []string) error
+}
+
+// ledgerEntry is a batch in the ledger. events maps event IDs to true once
+// they were stored, and to false while an attempt storing them is in flight.
+type ledgerEntry struct {
+	key    string
+	events map[string]bool
+}
+
+// NewLedger creates a Ledger holding up to capacity batches. store may be
+// nil. Batches already in the store are loaded into memory.
+func NewLedger(capacity int, store Store) (*Ledger, error) {
+	if capacity <= 0 {
+		capacity = DefaultLedgerCapacity
+	}
+
+	l := &Ledger{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		store:    store,
+	}
+
+	if store != nil {
+		records, err := store.Load()
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			key, eventIDs, ok := parseLedgerRecord(record)
+			if !ok {
+				continue
+			}
+			entry := l.entry(key)
+			for _, id := range eventIDs {
+				entry.events[id] = true
+			}
+		}
+	}
+	return l, nil
+}
+
+// NewSharedLedger creates a Ledger keeping its batches in shared only
+func NewSharedLedger(shared SharedLedger) *Ledger {
+	return &Ledger{shared: shared}
+}
+
+// Claim reports for each of eventIDs whether it was already stored as part
+// of the batch key, or is being stored by another attempt, and marks the
+// others as in flight. The caller must Commit or Release what it claimed.
+// Only a SharedLedger fails.
+func (l *Ledger) Claim(key string, eventIDs []string) ([]bool, error) {
+	if l.shared != nil {
+		return l.shared.Claim(key, eventIDs)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.entry(key)
+	seen := make([]bool, len(eventIDs))
+	for i, id := range eventIDs {
+		if _, ok := entry.events[id]; ok {
+			seen[i] = true
+			continue
+		}
+		entry.events[id] = false
+	}
+	return seen, nil
+}
+
+// Commit records eventIDs of the batch key as stored
+func (l *Ledger) Commit(key string, eventIDs []string) error {
+	if len(eventIDs) == 0 {
+		return nil
+	}
+	if l.shared != nil {
+		return l.shared.Commit(key, eventIDs)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry := l.entry(key)
+	for _, id := range eventIDs {
+		entry.events[id] = true
+	}
+	if l.store != nil {
+		return l.store.Add(ledgerRecord(key, eventIDs))
+	}
+	return nil
+}
+
+// Release drops the claims on eventIDs of the batch key, e.g. when storing
+// them failed and a retry should be accepted. Events that were already
+// stored stay recorded. Only a SharedLedger fails.
+func (l *Ledger) Release(key string, eventIDs []string) error {
+	if l.shared != nil {
+		return l.shared.Release(key, eventIDs)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*ledgerEntry)
+	for _, id := range eventIDs {
+		if stored, ok := entry.events[id]; ok && !stored {
+			delete(entry.events, id)
+		}
+	}
+	if len(entry.events) == 0 {
+		l.order.Remove(elem)
+		delete(l.entries, key)
+	}
+	return nil
+}
+
+// Close closes the backing store
+func (l *Ledger) Close() error {
+	if l.store != nil {
+		return l.store.Close()
+	}
+	return nil
+}
+
+// entry returns the batch key, moved to the front of the LRU or added there,
+// evicting the oldest batch when full
+func (l *Ledger) entry(key string) *ledgerEntry {
+	if elem, ok := l.entries[key]; ok {
+		l.order.MoveToFront(elem)
+		return elem.Value.(*ledgerEntry)
+	}
+
+	entry := &ledgerEntry{key: key, events: make(map[string]bool)}
+	l.entries[key] = l.order.PushFront(entry)
+	for l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*ledgerEntry).key)
+	}
+	return entry
+}
+
+// A ledger record is the batch key followed by the event IDs, NUL separated
+// like the parts of the key
+func ledgerRecord(key string, eventIDs []string) string {
+	return key + "\x00" + strings.Join(eventIDs, "\x00")
+}
+
+func parseLedgerRecord(record string) (key string, eventIDs []string, ok bool) {
+	parts := strings.Split(record, "\x00")
+	if len(parts) < 4 {
+		return "", nil, false
+	}
+	return strings.Join(parts[:3], "\x00"), parts[3:], true
+}
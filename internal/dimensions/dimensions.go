@@ -0,0 +1,384 @@
+// Package dimensions holds the custom dimensions tenants register for the
+// customData of their events. Registered keys are checked at ingest, so
+// customData carries typed values that aggregations can break results down
+// by, instead of an opaque string only the tenant knows how to read.
+package dimensions
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+)
+
+// DefaultMaxPerTenant is how many dimensions a tenant may register when no
+// limit is configured
+const DefaultMaxPerTenant = 20
+
+// Types of dimension values, named after their JSON types
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+)
+
+// Cardinality hints
+const (
+	// Low cardinality dimensions take a few values, such as a plan or an
+	// experiment arm, and can be broken down by
+	Low = "low"
+	// High cardinality dimensions take many, such as an article ID; they
+	// are checked and stored but not broken down by
+	High = "high"
+)
+
+var (
+	// ErrLimit is returned when a tenant registered as many dimensions as
+	// it may
+	ErrLimit = errors.New("too many dimensions")
+	// ErrNotFound is returned for a dimension that isn't registered
+	ErrNotFound = errors.New("dimension not found")
+)
+
+// namePattern keeps dimension names usable as column and query parameter
+// names
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// Config configures custom dimensions
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxPerTenant caps the dimensions a tenant may register
+	MaxPerTenant int `yaml:"maxPerTenant"`
+	// File keeps the registered dimensions across restarts; without it
+	// they only live until the collector stops
+	File string `yaml:"file"`
+	// Strict rejects the events of tenants with dimensions whose
+	// customData has keys that aren't registered. Otherwise only the
+	// registered keys are checked.
+	Strict bool `yaml:"strict"`
+}
+
+// DefaultConfig allows DefaultMaxPerTenant dimensions per tenant
+func DefaultConfig() Config {
+	return Config{MaxPerTenant: DefaultMaxPerTenant}
+}
+
+// Validate checks the limit
+func (c Config) Validate() error {
+	if c.Enabled && c.MaxPerTenant <= 0 {
+		return fmt.Errorf("invalid dimensions maxPerTenant %d", c.MaxPerTenant)
+	}
+	return nil
+}
+
+// Dimension is a customData key of a tenant's events
+type Dimension struct {
+	Name string `json:"name"`
+	// Type is string, number or boolean
+	Type string `json:"type"`
+	// Cardinality is low or high, low by default
+	Cardinality string `json:"cardinality"`
+}
+
+// validate checks d and fills in its default cardinality
+func (d *Dimension) validate() error {
+	if !namePattern.MatchString(d.Name) {
+		return fmt.Errorf("invalid dimension name %q, must be letters, digits and underscores", d.Name)
+	}
+	switch d.Type {
+	case TypeString, TypeNumber, TypeBoolean:
+	default:
+		return fmt.Errorf("invalid dimension type %q, must be string, number or boolean", d.Type)
+	}
+	switch d.Cardinality {
+	case "":
+		d.Cardinality = Low
+	case Low, High:
+	default:
+		return fmt.Errorf("invalid dimension cardinality %q, must be low or high", d.Cardinality)
+	}
+	return nil
+}
+
+// Registry holds the dimensions of every tenant. It is safe for concurrent
+// use.
+type Registry struct {
+	max    int
+	strict bool
+	path   string
+
+	mu sync.RWMutex
+	// tenants holds the dimensions of each tenant, sorted by name
+	tenants map[string][]Dimension
+}
+
+// New creates the registry of cfg, loading File when it exists, or returns
+// nil when dimensions are disabled
+func New(cfg Config) (*Registry, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	r := &Registry{max: cfg.MaxPerTenant, strict: cfg.Strict, path: cfg.File, tenants: make(map[string][]Dimension)}
+	if r.path == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dimensions file: %w", err)
+	}
+	var file map[string][]Dimension
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse dimensions file: %w", err)
+	}
+	for tenant, dimensions := range file {
+		for _, d := range dimensions {
+			if err := d.validate(); err != nil {
+				return nil, fmt.Errorf("dimensions file, tenant %s: %w", tenant, err)
+			}
+			r.tenants[tenant] = insert(r.tenants[tenant], d)
+		}
+	}
+	return r, nil
+}
+
+// Max is how many dimensions a tenant may register
+func (r *Registry) Max() int {
+	return r.max
+}
+
+// Persistent reports whether Save keeps the dimensions across restarts
+func (r *Registry) Persistent() bool {
+	return r.path != ""
+}
+
+// List returns the dimensions of tenant, sorted by name
+func (r *Registry) List(tenant string) []Dimension {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return slices.Clone(r.tenants[tenant])
+}
+
+// Get returns the dimension of tenant called name
+func (r *Registry) Get(tenant, name string) (Dimension, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return find(r.tenants[tenant], name)
+}
+
+// Set registers d for tenant or replaces the dimension of its name. It
+// returns d with its defaults filled in and whether it is new, or ErrLimit
+// when tenant already has as many dimensions as it may.
+func (r *Registry) Set(tenant string, d Dimension) (Dimension, bool, error) {
+	if err := d.validate(); err != nil {
+		return Dimension{}, false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dimensions := r.tenants[tenant]
+	_, exists := find(dimensions, d.Name)
+	if !exists && len(dimensions) >= r.max {
+		return Dimension{}, false, ErrLimit
+	}
+	r.tenants[tenant] = insert(dimensions, d)
+	return d, !exists, nil
+}
+
+// Delete removes the dimension of tenant called name. Stored customData
+// keeps the key; it is no longer checked.
+func (r *Registry) Delete(tenant, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dimensions := r.tenants[tenant]
+	i := slices.IndexFunc(dimensions, func(d Dimension) bool { return d.Name == name })
+	if i < 0 {
+		return ErrNotFound
+	}
+	dimensions = slices.Delete(slices.Clone(dimensions), i, i+1)
+	if len(dimensions) == 0 {
+		delete(r.tenants, tenant)
+	} else {
+		r.tenants[tenant] = dimensions
+	}
+	return nil
+}
+
+// Save writes the dimensions to File, if one is configured
+func (r *Registry) Save() error {
+	if r.path == "" {
+		return nil
+	}
+	r.mu.RLock()
+	data, err := json.MarshalIndent(r.tenants, "", "  ")
+	r.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	// Write a temporary file and rename it so a crash never leaves a
+	// truncated file behind
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write dimensions file: %w", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write dimensions file: %w", err)
+	}
+	return nil
+}
+
+// Check returns the problems of the customData of the events of batch,
+// labelled with their index in the batch, and counts the events as
+// rejected
+func (r *Registry) Check(batch models.EventBatch) []validation.Problem {
+	problems, rejected := r.problems(batch)
+	if rejected > 0 {
+		metrics.DimensionViolations.WithLabelValues(batch.Tenant).Add(float64(rejected))
+	}
+	return problems
+}
+
+// Problems returns the problems Check would, without counting anything, for
+// batches that are only being tried out
+func (r *Registry) Problems(batch models.EventBatch) []validation.Problem {
+	problems, _ := r.problems(batch)
+	return problems
+}
+
+// problems returns the problems of the events of batch and how many events
+// have any. The events of tenants without dimensions have none.
+func (r *Registry) problems(batch models.EventBatch) ([]validation.Problem, int) {
+	dimensions := r.List(batch.Tenant)
+	if len(dimensions) == 0 {
+		return nil, 0
+	}
+
+	var problems []validation.Problem
+	events := 0
+	for i, event := range batch.Events {
+		eventProblems := r.checkEvent(i, event.CustomData, dimensions)
+		if len(eventProblems) > 0 {
+			problems = append(problems, eventProblems...)
+			events++
+		}
+	}
+	return problems, events
+}
+
+func (r *Registry) checkEvent(index int, customData string, dimensions []Dimension) []validation.Problem {
+	if strings.TrimSpace(customData) == "" {
+		return nil
+	}
+	fields, ok := decode(customData)
+	if !ok {
+		return []validation.Problem{{Index: index, Field: "customData", Reason: "must be a JSON object of custom dimensions"}}
+	}
+
+	var problems []validation.Problem
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		d, ok := find(dimensions, key)
+		if !ok {
+			if r.strict {
+				problems = append(problems, validation.Problem{Index: index, Field: "customData." + key, Reason: "is not a registered dimension"})
+			}
+			continue
+		}
+		if _, ok := typed(d, fields[key]); !ok {
+			problems = append(problems, validation.Problem{Index: index, Field: "customData." + key, Reason: "must be a " + d.Type})
+		}
+	}
+	return problems
+}
+
+// Value returns the value of d in customData, reporting false when it
+// isn't set or isn't of d's type
+func Value(d Dimension, customData string) (any, bool) {
+	fields, ok := decode(customData)
+	if !ok {
+		return nil, false
+	}
+	raw, ok := fields[d.Name]
+	if !ok {
+		return nil, false
+	}
+	return typed(d, raw)
+}
+
+// decode reads customData as a JSON object
+func decode(customData string) (map[string]json.RawMessage, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(customData), &fields); err != nil || fields == nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// typed decodes raw as a value of d's type. null counts as unset.
+func typed(d Dimension, raw json.RawMessage) (any, bool) {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, false
+	}
+	var value any
+	var err error
+	switch d.Type {
+	case TypeString:
+		var s string
+		err = json.Unmarshal(raw, &s)
+		value = s
+	case TypeNumber:
+		var n float64
+		err = json.Unmarshal(raw, &n)
+		value = n
+	case TypeBoolean:
+		var b bool
+		err = json.Unmarshal(raw, &b)
+		value = b
+	}
+	return value, err == nil
+}
+
+func find(dimensions []Dimension, name string) (Dimension, bool) {
+	i, ok := slices.BinarySearchFunc(dimensions, name, func(d Dimension, name string) int {
+		return strings.Compare(d.Name, name)
+	})
+	if !ok {
+		return Dimension{}, false
+	}
+	return dimensions[i], true
+}
+
+// insert returns dimensions with d added or replacing the dimension of its
+// name, in a new slice so lists handed out stay as they were
+func insert(dimensions []Dimension, d Dimension) []Dimension {
+	dimensions = slices.Clone(dimensions)
+	i, ok := slices.BinarySearchFunc(dimensions, d, func(a, b Dimension) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	if ok {
+		dimensions[i] = d
+		return dimensions
+	}
+	return slices.Insert(dimensions, i, d)
+}
@@ -0,0 +1,269 @@
+// Package encryption encrypts the log files of the file sink with AES-GCM
+// under keys of their tenant. Every file gets a random data key per tenant,
+// which is wrapped with the tenant's key from a local keyring or AWS KMS and
+// stored in the file before the tenant's first batch; each batch is then
+// sealed on a line of its own. Files stay line-oriented, so the batches of
+// tenants without a key, and files from before encryption was enabled, are
+// read alongside encrypted ones.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default is the keyring and KMS entry of tenants without one of their own
+const Default = "*"
+
+// KeySize is the size of the AES-256 keys of keyrings and of data keys
+const KeySize = 32
+
+// Prefixes of the key references stored with wrapped data keys
+const (
+	localPrefix = "local:"
+	kmsPrefix   = "kms:"
+)
+
+// Config configures encryption of the file sink's logs
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Keyring is a YAML file of local keys and the tenants using them
+	Keyring string `yaml:"keyring"`
+	// KMS wraps data keys with AWS KMS keys. Tenants with a KMS key use it
+	// rather than one from the keyring.
+	KMS KMSConfig `yaml:"kms"`
+}
+
+// KMSConfig configures AWS KMS
+type KMSConfig struct {
+	Region string `yaml:"region"`
+	// Endpoint overrides the KMS endpoint, e.g. for LocalStack
+	Endpoint string `yaml:"endpoint"`
+	// Keys maps tenants to the ID, ARN or alias of their KMS key, with
+	// Default for every other tenant
+	Keys map[string]string `yaml:"keys"`
+}
+
+// Validate checks that enabled encryption has keys to encrypt with
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Keyring == "" && len(c.KMS.Keys) == 0 {
+		return errors.New("log encryption needs a keyring or KMS keys")
+	}
+	for tenant, key := range c.KMS.Keys {
+		if key == "" || strings.ContainsAny(key, " \t\n") {
+			return fmt.Errorf("invalid KMS key %q for tenant %q", key, tenant)
+		}
+	}
+	return nil
+}
+
+// keyringFile is the format of a local keyring:
+//
+//	keys:
+//	  acme-2026-10: <base64 of 32 random bytes>
+//	  shared-1: <base64 of 32 random bytes>
+//	tenants:
+//	  acme: acme-2026-10
+//	  "*": shared-1
+//
+// Files record the ID of the key their data keys are wrapped with, so a key
+// must stay in the keyring as long as the files it encrypted are kept.
+// Rotating a tenant's key is adding a new one and pointing the tenant at it.
+type keyringFile struct {
+	Keys    map[string]string `yaml:"keys"`
+	Tenants map[string]string `yaml:"tenants"`
+}
+
+// Keyring wraps and unwraps the data keys of log files with the keys of
+// their tenants. It is safe for concurrent use.
+type Keyring struct {
+	local   map[string]cipher.AEAD
+	tenants map[string]string
+	kms     *awsKMS
+	kmsKeys map[string]string
+
+	mu sync.Mutex
+	// unwrapped caches data keys, as the files holding them are read again
+	// by every query
+	unwrapped map[string][]byte
+}
+
+// New loads the keyring of cfg, and connects to KMS when cfg configures it
+func New(ctx context.Context, cfg Config) (*Keyring, error) {
+	k := &Keyring{
+		local:     make(map[string]cipher.AEAD),
+		tenants:   make(map[string]string),
+		kmsKeys:   cfg.KMS.Keys,
+		unwrapped: make(map[string][]byte),
+	}
+	if cfg.Keyring != "" {
+		if err := k.load(cfg.Keyring); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.KMS.Keys) > 0 || cfg.KMS.Region != "" {
+		client, err := newAWSKMS(ctx, cfg.KMS)
+		if err != nil {
+			return nil, err
+		}
+		k.kms = client
+	}
+	return k, nil
+}
+
+// load reads the local keyring at path
+func (k *Keyring) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyring: %w", err)
+	}
+	var file keyringFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse keyring %s: %w", path, err)
+	}
+	for id, encoded := range file.Keys {
+		if id == "" || strings.ContainsAny(id, " \t\n") {
+			return fmt.Errorf("keyring %s: invalid key ID %q", path, id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != KeySize {
+			return fmt.Errorf("keyring %s: key %s must be %d bytes in base64", path, id, KeySize)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		k.local[id] = aead
+	}
+	for tenant, id := range file.Tenants {
+		if _, ok := k.local[id]; !ok {
+			return fmt.Errorf("keyring %s: tenant %q uses unknown key %q", path, tenant, id)
+		}
+		k.tenants[tenant] = id
+	}
+	return nil
+}
+
+// NewKey returns a random key for a keyring, in base64
+func NewKey() string {
+	return base64.StdEncoding.EncodeToString(randomBytes(KeySize))
+}
+
+// Encrypts reports whether the batches of tenant are encrypted
+func (k *Keyring) Encrypts(tenant string) bool {
+	_, ok := k.keyRef(tenant)
+	return ok
+}
+
+// keyRef names the key the data keys of tenant are wrapped with. A key of
+// the tenant's own wins over a default one, from KMS over the keyring.
+func (k *Keyring) keyRef(tenant string) (string, bool) {
+	for _, name := range []string{tenant, Default} {
+		if key, ok := k.kmsKeys[name]; ok {
+			return kmsPrefix + key, true
+		}
+		if id, ok := k.tenants[name]; ok {
+			return localPrefix + id, true
+		}
+	}
+	return "", false
+}
+
+// wrap encrypts the data key dataKeyID with the key ref names. The data key
+// ID is authenticated along with it, so wrapped keys can't be swapped.
+func (k *Keyring) wrap(ctx context.Context, ref, dataKeyID string, dataKey []byte) ([]byte, error) {
+	if key, ok := strings.CutPrefix(ref, kmsPrefix); ok {
+		if k.kms == nil {
+			return nil, fmt.Errorf("key %s is in KMS, which isn't configured", ref)
+		}
+		return k.kms.encrypt(ctx, key, dataKey, dataKeyID)
+	}
+	aead, err := k.localKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	nonce := randomBytes(aead.NonceSize())
+	return aead.Seal(nonce, nonce, dataKey, []byte(dataKeyID)), nil
+}
+
+// unwrap decrypts a data key wrapped by wrap
+func (k *Keyring) unwrap(ctx context.Context, ref, dataKeyID string, wrapped []byte) ([]byte, error) {
+	cacheKey := ref + " " + dataKeyID + " " + string(wrapped)
+	k.mu.Lock()
+	dataKey, ok := k.unwrapped[cacheKey]
+	k.mu.Unlock()
+	if ok {
+		return dataKey, nil
+	}
+
+	if key, ok := strings.CutPrefix(ref, kmsPrefix); ok {
+		if k.kms == nil {
+			return nil, fmt.Errorf("data key %s is wrapped with %s, but KMS isn't configured", dataKeyID, ref)
+		}
+		var err error
+		if dataKey, err = k.kms.decrypt(ctx, key, wrapped, dataKeyID); err != nil {
+			return nil, err
+		}
+	} else {
+		aead, err := k.localKey(ref)
+		if err != nil {
+			return nil, err
+		}
+		if len(wrapped) < aead.NonceSize() {
+			return nil, fmt.Errorf("data key %s is truncated", dataKeyID)
+		}
+		nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+		if dataKey, err = aead.Open(nil, nonce, sealed, []byte(dataKeyID)); err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key %s with %s: %w", dataKeyID, ref, err)
+		}
+	}
+	if len(dataKey) != KeySize {
+		return nil, fmt.Errorf("data key %s has %d bytes, want %d", dataKeyID, len(dataKey), KeySize)
+	}
+
+	k.mu.Lock()
+	k.unwrapped[cacheKey] = dataKey
+	k.mu.Unlock()
+	return dataKey, nil
+}
+
+func (k *Keyring) localKey(ref string) (cipher.AEAD, error) {
+	id, ok := strings.CutPrefix(ref, localPrefix)
+	if !ok {
+		return nil, fmt.Errorf("unknown key reference %q", ref)
+	}
+	aead, ok := k.local[id]
+	if !ok {
+		return nil, fmt.Errorf("key %s is not in the keyring", id)
+	}
+	return aead, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// randomBytes returns n bytes from the system's random source, which never
+// fails on supported platforms
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
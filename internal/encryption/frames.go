@@ -0,0 +1,197 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// framePrefix starts the lines of encrypted files that hold a wrapped data
+// key or a sealed batch, which neither log format's lines start with:
+//
+//	!esv1 key <data key ID> <key reference> <wrapped data key>
+//	!esv1 data <data key ID> <nonce and sealed batch>
+//
+// Binary values are in standard base64.
+const framePrefix = "!esv1 "
+
+// dataKey is the key sealing the batches of one tenant in one file
+type dataKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Writer seals the batches written to one log file. It is not safe for
+// concurrent use.
+type Writer struct {
+	keys *Keyring
+	w    io.Writer
+	// dataKeys are the keys of the tenants written so far, nil for tenants
+	// without encryption
+	dataKeys map[string]*dataKey
+}
+
+// NewWriter returns a Writer for a log file written to w, from its start or
+// from where a previous Writer stopped. Data keys are made as the tenants'
+// first batches come, and written before them.
+func (k *Keyring) NewWriter(w io.Writer) *Writer {
+	return &Writer{keys: k, w: w, dataKeys: make(map[string]*dataKey)}
+}
+
+// WriteBatch writes one formatted batch of tenant, sealed when the tenant's
+// batches are encrypted and as it is otherwise
+func (w *Writer) WriteBatch(ctx context.Context, tenant string, batch []byte) error {
+	key, err := w.dataKey(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		_, err := w.w.Write(batch)
+		return err
+	}
+	nonce := randomBytes(key.aead.NonceSize())
+	sealed := key.aead.Seal(nonce, nonce, batch, []byte(key.id))
+	_, err = fmt.Fprintf(w.w, "%sdata %s %s\n", framePrefix, key.id, base64.StdEncoding.EncodeToString(sealed))
+	return err
+}
+
+func (w *Writer) dataKey(ctx context.Context, tenant string) (*dataKey, error) {
+	if key, ok := w.dataKeys[tenant]; ok {
+		return key, nil
+	}
+	ref, ok := w.keys.keyRef(tenant)
+	if !ok {
+		w.dataKeys[tenant] = nil
+		return nil, nil
+	}
+
+	raw := randomBytes(KeySize)
+	id := hex.EncodeToString(randomBytes(8))
+	wrapped, err := w.keys.wrap(ctx, ref, id, raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make data key for tenant %q: %w", tenant, err)
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(w.w, "%skey %s %s %s\n", framePrefix, id, ref,
+		base64.StdEncoding.EncodeToString(wrapped)); err != nil {
+		return nil, err
+	}
+	key := &dataKey{id: id, aead: aead}
+	w.dataKeys[tenant] = key
+	return key, nil
+}
+
+// ErrNoKeyring is returned when reading an encrypted file without a keyring
+var ErrNoKeyring = errors.New("file is encrypted, but no keyring is configured")
+
+// NewReader returns the plaintext of a log file read from r: sealed batches
+// are opened with the data keys stored before them and other lines are
+// passed through. keys may be nil for files that aren't encrypted.
+func NewReader(ctx context.Context, r io.Reader, keys *Keyring) io.Reader {
+	return &reader{
+		ctx:      ctx,
+		src:      bufio.NewReaderSize(r, 64<<10),
+		keys:     keys,
+		dataKeys: make(map[string]cipher.AEAD),
+	}
+}
+
+type reader struct {
+	ctx      context.Context
+	src      *bufio.Reader
+	keys     *Keyring
+	dataKeys map[string]cipher.AEAD
+	line     int
+	pending  []byte
+	err      error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.next()
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// next reads the following line into pending
+func (r *reader) next() {
+	line, err := r.src.ReadBytes('\n')
+	if len(line) > 0 {
+		r.line++
+		plain, lineErr := r.open(line)
+		if lineErr != nil {
+			r.err = fmt.Errorf("line %d: %w", r.line, lineErr)
+			return
+		}
+		r.pending = plain
+	}
+	if err != nil {
+		r.err = err
+	}
+}
+
+// open returns the plaintext of line, nothing for key lines
+func (r *reader) open(line []byte) ([]byte, error) {
+	frame, ok := bytes.CutPrefix(line, []byte(framePrefix))
+	if !ok {
+		return line, nil
+	}
+	if r.keys == nil {
+		return nil, ErrNoKeyring
+	}
+
+	fields := strings.Fields(string(frame))
+	switch {
+	case len(fields) == 4 && fields[0] == "key":
+		id, ref := fields[1], fields[2]
+		wrapped, err := base64.StdEncoding.DecodeString(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid data key %s: %w", id, err)
+		}
+		raw, err := r.keys.unwrap(r.ctx, ref, id, wrapped)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+		r.dataKeys[id] = aead
+		return nil, nil
+	case len(fields) == 3 && fields[0] == "data":
+		id := fields[1]
+		aead, ok := r.dataKeys[id]
+		if !ok {
+			return nil, fmt.Errorf("data key %s is used before it is stored", id)
+		}
+		sealed, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sealed batch: %w", err)
+		}
+		if len(sealed) < aead.NonceSize() {
+			return nil, errors.New("sealed batch is truncated")
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plain, err := aead.Open(nil, nonce, ciphertext, []byte(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open sealed batch: %w", err)
+		}
+		return plain, nil
+	}
+	return nil, errors.New("invalid encryption frame")
+}
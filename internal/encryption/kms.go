@@ -0,0 +1,61 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// dataKeyContext is the KMS encryption context key binding a wrapped data
+// key to its ID
+const dataKeyContext = "esv-data-key"
+
+// awsKMS wraps data keys with KMS keys. Data keys are generated locally and
+// encrypted by KMS, so their plaintext is never sent anywhere but to KMS.
+type awsKMS struct {
+	client *kms.Client
+}
+
+func newAWSKMS(ctx context.Context, cfg KMSConfig) (*awsKMS, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	client := kms.NewFromConfig(awsCfg, func(o *kms.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+	})
+	return &awsKMS{client: client}, nil
+}
+
+func (k *awsKMS) encrypt(ctx context.Context, keyID string, dataKey []byte, dataKeyID string) ([]byte, error) {
+	out, err := k.client.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(keyID),
+		Plaintext:         dataKey,
+		EncryptionContext: map[string]string{dataKeyContext: dataKeyID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key with KMS key %s: %w", keyID, err)
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k *awsKMS) decrypt(ctx context.Context, keyID string, wrapped []byte, dataKeyID string) ([]byte, error) {
+	out, err := k.client.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(keyID),
+		CiphertextBlob:    wrapped,
+		EncryptionContext: map[string]string{dataKeyContext: dataKeyID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key %s with KMS key %s: %w", dataKeyID, keyID, err)
+	}
+	return out.Plaintext, nil
+}
@@ -0,0 +1,96 @@
+package enrich
+
+import (
+	"strings"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// responseHeadersKey is the key of Technical under which players echo the
+// response headers of the media segments they load
+const responseHeadersKey = "responseHeaders"
+
+// cdnRule recognizes a CDN by a response header it sets. match reports
+// whether a value of the header is the CDN's and returns the edge POP it
+// names, if any.
+type cdnRule struct {
+	header string
+	cdn    string
+	match  func(value string) (pop string, ok bool)
+}
+
+// cdnRules are tried in order; headers naming the POP come first
+var cdnRules = []cdnRule{
+	// x-amz-cf-pop: FRA56-P4
+	{"x-amz-cf-pop", "cloudfront", func(v string) (string, bool) { return v, true }},
+	// cf-ray: 8a1b2c3d4e5f6789-FRA
+	{"cf-ray", "cloudflare", func(v string) (string, bool) { return afterLast(v, "-"), true }},
+	// x-served-by: cache-iad-kiad7000025-IAD, cache-fra-etou8220036-FRA,
+	// the last of which is the edge
+	{"x-served-by", "fastly", func(v string) (string, bool) {
+		hops := strings.Split(v, ",")
+		edge := strings.TrimSpace(hops[len(hops)-1])
+		if !strings.HasPrefix(edge, "cache-") {
+			return "", false
+		}
+		return afterLast(edge, "-"), true
+	}},
+	// server: BunnyCDN-DE1-1037
+	{"server", "bunny", func(v string) (string, bool) {
+		parts := strings.Split(v, "-")
+		if len(parts) < 2 || !strings.EqualFold(parts[0], "BunnyCDN") {
+			return "", false
+		}
+		return parts[1], true
+	}},
+	{"x-akamai-request-id", "akamai", func(string) (string, bool) { return "", true }},
+	{"server", "cloudflare", func(v string) (string, bool) { return "", strings.EqualFold(v, "cloudflare") }},
+	{"via", "cloudfront", func(v string) (string, bool) { return "", strings.Contains(v, "(CloudFront)") }},
+	{"via", "google", func(v string) (string, bool) { return "", strings.HasSuffix(v, " google") }},
+}
+
+// afterLast returns the part of s after the last sep, or "" without one
+func afterLast(s, sep string) string {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[i+len(sep):]
+	}
+	return ""
+}
+
+// attributeCDN fills in the CDN and edge POP the player didn't report from
+// the response headers it echoed
+func attributeCDN(event *models.Event) {
+	t := event.Technical
+	if t == nil || (t.CDN != "" && t.EdgePOP != "") {
+		return
+	}
+	echoed, ok := t.Extra[responseHeadersKey].(map[string]interface{})
+	if !ok {
+		return
+	}
+	headers := make(map[string]string, len(echoed))
+	for name, value := range echoed {
+		if value, ok := value.(string); ok {
+			headers[strings.ToLower(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	for _, rule := range cdnRules {
+		value, ok := headers[rule.header]
+		if !ok || value == "" {
+			continue
+		}
+		pop, ok := rule.match(value)
+		if !ok {
+			continue
+		}
+		if t.CDN == "" {
+			t.CDN = rule.cdn
+		}
+		// A POP named by another CDN's header would be wrong
+		if t.EdgePOP == "" && strings.EqualFold(t.CDN, rule.cdn) {
+			t.EdgePOP = pop
+		}
+		return
+	}
+}
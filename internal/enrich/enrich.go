@@ -0,0 +1,294 @@
+// Package enrich adds server-side knowledge about the client, its location
+// and device, and about the stream being played to the events of a batch
+package enrich
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/mssola/useragent"
+	"github.com/oschwald/geoip2-golang"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/playererror"
+)
+
+// Config configures enrichment
+type Config struct {
+	// GeoIPDatabase is a MaxMind City or Country database (.mmdb) used for
+	// the country and region; empty disables the lookup
+	GeoIPDatabase string `yaml:"geoipDatabase"`
+	// ASNDatabase is a MaxMind ASN database; empty disables the lookup
+	ASNDatabase string `yaml:"asnDatabase"`
+	// UserAgent parses user agents into device, OS and browser fields
+	UserAgent bool `yaml:"userAgent"`
+	// CDN derives the CDN and edge POP of events whose player doesn't
+	// report them from the media response headers it echoes
+	CDN bool `yaml:"cdn"`
+	// Renditions parses the quality ladders players expose, such as hls.js
+	// levels, into Technical.Renditions
+	Renditions bool `yaml:"renditions"`
+	// Errors classifies the errors of error events into PlayerError, with
+	// the fingerprints grouping them
+	Errors bool `yaml:"errors"`
+	// StreamTypes classify the videos of events whose player doesn't
+	// report a stream type; the first rule matching the video ID wins
+	StreamTypes []StreamTypeRule `yaml:"streamTypes"`
+}
+
+// StreamTypeRule gives videos whose ID matches Pattern, a regular
+// expression, the stream type Type: live, vod or dvr
+type StreamTypeRule struct {
+	Pattern string `yaml:"pattern"`
+	Type    string `yaml:"type"`
+}
+
+type streamTypeRule struct {
+	pattern    *regexp.Regexp
+	streamType string
+}
+
+// DefaultConfig parses user agents, response headers, quality ladders and
+// player errors; GeoIP needs databases to be configured
+func DefaultConfig() Config {
+	return Config{UserAgent: true, CDN: true, Renditions: true, Errors: true}
+}
+
+// Validate checks the stream type rules
+func (c Config) Validate() error {
+	for i, rule := range c.StreamTypes {
+		if !models.IsStreamType(rule.Type) {
+			return fmt.Errorf("streamTypes[%d]: type must be live, vod or dvr", i)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("streamTypes[%d]: invalid pattern: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Enricher fills in Context.Geo and Context.Device of every event, and the
+// stream type, CDN, edge POP and quality ladder of events whose player
+// didn't report them, and classifies the errors of error events.
+// It is safe for concurrent use.
+type Enricher struct {
+	geo         *geoip2.Reader
+	geoCity     bool
+	asn         *geoip2.Reader
+	userAgent   bool
+	cdn         bool
+	renditions  bool
+	errors      bool
+	streamTypes []streamTypeRule
+}
+
+// New opens the configured databases. It returns nil when cfg enables
+// nothing.
+func New(cfg Config) (*Enricher, error) {
+	if cfg.GeoIPDatabase == "" && cfg.ASNDatabase == "" && !cfg.UserAgent && !cfg.CDN && !cfg.Renditions &&
+		!cfg.Errors && len(cfg.StreamTypes) == 0 {
+		return nil, nil
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	e := &Enricher{userAgent: cfg.UserAgent, cdn: cfg.CDN, renditions: cfg.Renditions, errors: cfg.Errors}
+	for _, rule := range cfg.StreamTypes {
+		e.streamTypes = append(e.streamTypes, streamTypeRule{
+			pattern:    regexp.MustCompile(rule.Pattern),
+			streamType: rule.Type,
+		})
+	}
+	if cfg.GeoIPDatabase != "" {
+		reader, err := geoip2.Open(cfg.GeoIPDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+		}
+		e.geo = reader
+		e.geoCity = strings.Contains(reader.Metadata().DatabaseType, "City")
+	}
+	if cfg.ASNDatabase != "" {
+		reader, err := geoip2.Open(cfg.ASNDatabase)
+		if err != nil {
+			e.Close()
+			return nil, fmt.Errorf("failed to open ASN database: %w", err)
+		}
+		e.asn = reader
+	}
+	return e, nil
+}
+
+// Enrich sets the location of clientIP and the device described by each
+// event's user agent, falling back to the request's userAgent. Values sent
+// by the client are replaced so they can't be spoofed.
+func (e *Enricher) Enrich(batch *models.EventBatch, clientIP, userAgent string) {
+	geo := e.lookup(clientIP)
+	devices := make(map[string]*models.Device)
+
+	for i := range batch.Events {
+		event := &batch.Events[i]
+		if event.Context == nil {
+			event.Context = &models.Context{}
+		}
+		event.Context.Geo = geo
+		e.classifyStream(event)
+		if e.cdn {
+			attributeCDN(event)
+		}
+		if e.renditions {
+			parseRenditions(event)
+		}
+		if e.errors {
+			classifyError(event)
+		}
+
+		if !e.userAgent {
+			continue
+		}
+		ua := userAgent
+		if event.Technical != nil && event.Technical.UserAgent != "" {
+			ua = event.Technical.UserAgent
+		}
+		device, ok := devices[ua]
+		if !ok {
+			device = parseUserAgent(ua)
+			devices[ua] = device
+		}
+		event.Context.Device = device
+	}
+}
+
+// classifyStream sets the stream type of an event whose player didn't
+// report one: live when the player sent isLive with its technical data,
+// otherwise the type of the first rule matching the video ID
+func (e *Enricher) classifyStream(event *models.Event) {
+	if event.StreamType() != "" {
+		return
+	}
+	streamType := ""
+	if event.Technical != nil && event.Technical.Extra["isLive"] == true {
+		streamType = models.StreamLive
+	} else {
+		for _, rule := range e.streamTypes {
+			if rule.pattern.MatchString(event.VideoID) {
+				streamType = rule.streamType
+				break
+			}
+		}
+	}
+	if streamType == "" {
+		return
+	}
+	if event.Technical == nil {
+		event.Technical = &models.Technical{}
+	}
+	event.Technical.StreamType = streamType
+}
+
+// lookup returns the location of ip, or nil when it's unknown
+func (e *Enricher) lookup(clientIP string) *models.Geo {
+	if e.geo == nil && e.asn == nil {
+		return nil
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return nil
+	}
+
+	var geo models.Geo
+	if e.geo != nil {
+		if e.geoCity {
+			city, err := e.geo.City(ip)
+			if err != nil {
+				slog.Warn("GeoIP lookup failed", "ip", clientIP, "error", err)
+			} else {
+				geo.Country = city.Country.IsoCode
+				geo.City = city.City.Names["en"]
+				if len(city.Subdivisions) > 0 {
+					geo.Region = city.Subdivisions[0].Names["en"]
+					geo.RegionCode = city.Subdivisions[0].IsoCode
+				}
+			}
+		} else {
+			country, err := e.geo.Country(ip)
+			if err != nil {
+				slog.Warn("GeoIP lookup failed", "ip", clientIP, "error", err)
+			} else {
+				geo.Country = country.Country.IsoCode
+			}
+		}
+	}
+	if e.asn != nil {
+		asn, err := e.asn.ASN(ip)
+		if err != nil {
+			slog.Warn("ASN lookup failed", "ip", clientIP, "error", err)
+		} else {
+			geo.ASN = asn.AutonomousSystemNumber
+			geo.ASOrg = asn.AutonomousSystemOrganization
+		}
+	}
+
+	if geo == (models.Geo{}) {
+		return nil
+	}
+	return &geo
+}
+
+// Country returns the ISO code of the country clientIP is in, or "" when
+// it is unknown or no GeoIP database is configured
+func (e *Enricher) Country(clientIP string) string {
+	if e.geo == nil {
+		return ""
+	}
+	if geo := e.lookup(clientIP); geo != nil {
+		return geo.Country
+	}
+	return ""
+}
+
+// parseUserAgent describes the device behind a user agent string
+func parseUserAgent(s string) *models.Device {
+	if s == "" {
+		return nil
+	}
+	ua := useragent.New(s)
+	device := &models.Device{Type: "desktop"}
+	switch {
+	case ua.Bot():
+		device.Type = "bot"
+	case ua.Mobile():
+		device.Type = "mobile"
+	}
+	os := ua.OSInfo()
+	device.OS, device.OSVersion = os.Name, os.Version
+	device.Browser, device.BrowserVersion = ua.Browser()
+	return device
+}
+
+// classifyError sets the PlayerError of error events. A classification
+// sent by the client is replaced, as it would split the error's group.
+func classifyError(event *models.Event) {
+	event.PlayerError = nil
+	if e, ok := playererror.Classify(*event); ok {
+		event.PlayerError = &e
+	}
+}
+
+// Close releases the GeoIP databases
+func (e *Enricher) Close() error {
+	var err error
+	if e.geo != nil {
+		err = e.geo.Close()
+	}
+	if e.asn != nil {
+		if asnErr := e.asn.Close(); err == nil {
+			err = asnErr
+		}
+	}
+	return err
+}
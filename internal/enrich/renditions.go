@@ -0,0 +1,92 @@
+package enrich
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// ladderKeys are the keys of Technical under which players expose the
+// renditions of the manifest, as their APIs name them: hls.js levels,
+// dash.js getBitrateInfoListFor, Shaka getVariantTracks, video.js
+// qualityLevels, and a generic bitrateLadder
+var ladderKeys = []string{"levels", "bitrateInfoList", "variantTracks", "qualityLevels", "bitrateLadder"}
+
+// parseRenditions fills in the quality ladder the player didn't report
+// from the first player-specific ladder it sent, which is then dropped
+// from Technical's extra keys
+func parseRenditions(event *models.Event) {
+	t := event.Technical
+	if t == nil || len(t.Renditions) > 0 {
+		return
+	}
+	for _, key := range ladderKeys {
+		entries, ok := t.Extra[key].([]interface{})
+		if !ok {
+			continue
+		}
+		renditions := make([]models.Rendition, 0, len(entries))
+		for _, entry := range entries {
+			if fields, ok := entry.(map[string]interface{}); ok {
+				if rendition, ok := parseRendition(fields); ok {
+					renditions = append(renditions, rendition)
+				}
+			}
+		}
+		if len(renditions) == 0 {
+			continue
+		}
+		slices.SortStableFunc(renditions, func(a, b models.Rendition) int {
+			return cmp.Or(cmp.Compare(a.Bitrate, b.Bitrate), cmp.Compare(a.Height, b.Height))
+		})
+		t.Renditions = slices.Compact(renditions)
+		delete(t.Extra, key)
+		return
+	}
+}
+
+// parseRendition reads one ladder entry. Entries of audio tracks and
+// entries with neither a bitrate nor a size are skipped.
+func parseRendition(fields map[string]interface{}) (models.Rendition, bool) {
+	if mediaType, _ := fields["mediaType"].(string); mediaType != "" && mediaType != "video" {
+		return models.Rendition{}, false
+	}
+
+	r := models.Rendition{
+		Bitrate:   firstNumber(fields, "bitrate", "bandwidth"),
+		Width:     int(firstNumber(fields, "width")),
+		Height:    int(firstNumber(fields, "height")),
+		FrameRate: firstNumber(fields, "frameRate"),
+		Label:     firstString(fields, "label", "name"),
+		Codecs:    firstString(fields, "codecs", "videoCodec", "codecSet"),
+	}
+	if r.Bitrate <= 0 && r.Height <= 0 {
+		return models.Rendition{}, false
+	}
+	if r.Label == "" && r.Height > 0 {
+		r.Label = fmt.Sprintf("%dp", r.Height)
+	}
+	return r, true
+}
+
+// firstNumber returns the first positive number among keys of fields
+func firstNumber(fields map[string]interface{}, keys ...string) float64 {
+	for _, key := range keys {
+		if n, ok := fields[key].(float64); ok && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// firstString returns the first non-empty string among keys of fields
+func firstString(fields map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if s, ok := fields[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,271 @@
+// Package erasure runs right-to-erasure requests against the sink in the
+// background and keeps their status for the API to report
+package erasure
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/sink/tenant"
+)
+
+const (
+	// DefaultTimeout bounds how long one job may run
+	DefaultTimeout = time.Hour
+	// maxQueued is how many jobs may wait for the worker
+	maxQueued = 100
+	// maxJobs is how many jobs are remembered; the oldest finished ones are
+	// forgotten first
+	maxJobs = 1000
+)
+
+var (
+	// ErrQueueFull is returned by Submit while too many jobs are waiting
+	ErrQueueFull = errors.New("too many erasure jobs queued")
+	// ErrClosed is returned by Submit after Close
+	ErrClosed = errors.New("erasure manager is closed")
+)
+
+// Status is the state of a job
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	// StatusArchivedCopiesPending is that of a job that erased the events
+	// of the sink, but not those of archived files, which keep them until
+	// they are deleted. The job completes then.
+	StatusArchivedCopiesPending Status = "archived_copies_pending"
+)
+
+// Archive is the cold storage of old event files, which erasure doesn't
+// rewrite
+type Archive interface {
+	// Copies counts the files archived before before that may hold events
+	// of tenant, and returns when the last of them is deleted, zero when
+	// never
+	Copies(ctx context.Context, tenant string, before time.Time) (int, time.Time, error)
+}
+
+// Job is an erasure request and how far it got
+type Job struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant"`
+	// UserID is the user ID as stored, i.e. hashed if the scrubber hashes
+	// user IDs
+	UserID string `json:"userId"`
+	Status Status `json:"status"`
+	// Erased counts the events deleted so far, including by a failed job
+	Erased int64  `json:"erased"`
+	Error  string `json:"error,omitempty"`
+	// ArchivedCopies counts the archived files that may still hold the
+	// user's events, until ArchivedUntil or for good without it
+	ArchivedCopies int        `json:"archivedCopies,omitempty"`
+	ArchivedUntil  *time.Time `json:"archivedUntil,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Manager runs jobs one at a time, oldest first, so erasures never rewrite
+// the same files concurrently. Jobs live in memory: a collector restarted
+// before a job finished reports it unknown, and the request must be sent
+// again.
+type Manager struct {
+	eraser  sink.Eraser
+	archive Archive
+	timeout time.Duration
+	now     func() time.Time
+
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	order  []string
+	closed bool
+
+	queue  chan string
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewManager starts a worker erasing from eraser. Jobs report the copies
+// of archive, if set, they leave. Each job is given up to timeout,
+// DefaultTimeout when zero.
+func NewManager(eraser sink.Eraser, archive Archive, timeout time.Duration) *Manager {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		eraser:  eraser,
+		archive: archive,
+		timeout: timeout,
+		now:     time.Now,
+		jobs:    make(map[string]*Job),
+		queue:   make(chan string, maxQueued),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// Submit queues the erasure of userID's events in tenant
+func (m *Manager) Submit(tenant, userID string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return Job{}, ErrClosed
+	}
+
+	job := &Job{
+		ID:        uuid.NewString(),
+		Tenant:    tenant,
+		UserID:    userID,
+		Status:    StatusPending,
+		CreatedAt: m.now().UTC(),
+	}
+	select {
+	case m.queue <- job.ID:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	m.jobs[job.ID] = job
+	m.order = append(m.order, job.ID)
+	m.forgetOldest()
+	return *job, nil
+}
+
+// Job returns the job with id
+func (m *Manager) Job(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	if job.Status == StatusArchivedCopiesPending && job.ArchivedUntil != nil && !m.now().Before(*job.ArchivedUntil) {
+		job.Status = StatusCompleted
+	}
+	return *job, true
+}
+
+// forgetOldest drops finished jobs beyond maxJobs. Queued and running jobs
+// are always kept.
+func (m *Manager) forgetOldest() {
+	excess := len(m.order) - maxJobs
+	if excess <= 0 {
+		return
+	}
+	kept := m.order[:0]
+	for _, id := range m.order {
+		job := m.jobs[id]
+		if excess > 0 && job.finished() {
+			delete(m.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	m.order = kept
+}
+
+// finished reports whether the job is done with the sink
+func (j *Job) finished() bool {
+	switch j.Status {
+	case StatusCompleted, StatusFailed, StatusArchivedCopiesPending:
+		return true
+	}
+	return false
+}
+
+func (m *Manager) run() {
+	defer close(m.done)
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case id := <-m.queue:
+			m.runJob(id)
+		}
+	}
+}
+
+func (m *Manager) runJob(id string) {
+	m.mu.Lock()
+	job := m.jobs[id]
+	started := m.now().UTC()
+	job.Status = StatusRunning
+	job.StartedAt = &started
+	tenantName, userID := job.Tenant, job.UserID
+	m.mu.Unlock()
+
+	slog.Info("Erasing user events", "jobId", id, "tenant", tenantName)
+
+	ctx, cancel := context.WithTimeout(m.ctx, m.timeout)
+	erased, err := m.eraser.EraseUser(ctx, tenantName, userID)
+	finished := m.now().UTC()
+	var copies int
+	var until time.Time
+	var archiveErr error
+	if err == nil && m.archive != nil {
+		copies, until, archiveErr = m.archive.Copies(ctx, tenant.SafeName(tenantName), finished)
+	}
+	cancel()
+
+	m.mu.Lock()
+	job.Erased = erased
+	job.FinishedAt = &finished
+	switch {
+	case err != nil:
+		job.Status = StatusFailed
+		job.Error = err.Error()
+	case archiveErr != nil:
+		// Copies may be pending; the job can't tell when they are gone
+		job.Status = StatusArchivedCopiesPending
+		job.Error = "failed to look for archived copies: " + archiveErr.Error()
+	case copies > 0:
+		job.Status = StatusArchivedCopiesPending
+		job.ArchivedCopies = copies
+		if !until.IsZero() {
+			until = until.UTC()
+			job.ArchivedUntil = &until
+		}
+	default:
+		job.Status = StatusCompleted
+	}
+	m.mu.Unlock()
+
+	switch {
+	case err != nil:
+		slog.Error("Error erasing user events", "jobId", id, "tenant", tenantName, "erased", erased, "error", err)
+	case archiveErr != nil:
+		slog.Error("Error looking for archived copies of erased events", "jobId", id, "tenant", tenantName, "erased", erased, "error", archiveErr)
+	default:
+		slog.Info("Erased user events", "jobId", id, "tenant", tenantName, "erased", erased, "archivedCopies", copies)
+	}
+}
+
+// Close cancels the running job and stops the worker. Queued jobs are
+// left pending.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	m.cancel()
+	<-m.done
+}
@@ -0,0 +1,368 @@
+// Package identity stitches the anonymous IDs viewers send before logging in
+// to the user IDs they send after, so analytics can join both halves of a
+// viewer's history
+package identity
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// DefaultMaxLinks is how many links are kept when no limit is configured
+const DefaultMaxLinks = 1000000
+
+// minCompactLines keeps small files from being rewritten on every few links
+const minCompactLines = 1000
+
+// Config configures identity stitching
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxLinks caps the links kept; the least recently linked are dropped
+	// beyond it
+	MaxLinks int `yaml:"maxLinks"`
+	// File keeps the links across restarts; without it they only live
+	// until the collector stops
+	File string `yaml:"file"`
+	// MergeSessions folds the active sessions a viewer watched a video in
+	// anonymously into the session they continue it in after logging in,
+	// for players that start a new session on login. It has no effect when
+	// sessions are disabled.
+	MergeSessions bool `yaml:"mergeSessions"`
+}
+
+// DefaultConfig keeps stitching off; the other fields are the defaults once
+// it is enabled
+func DefaultConfig() Config {
+	return Config{MaxLinks: DefaultMaxLinks, MergeSessions: true}
+}
+
+// Validate checks the limit
+func (c Config) Validate() error {
+	if c.Enabled && c.MaxLinks <= 0 {
+		return fmt.Errorf("invalid identities maxLinks %d", c.MaxLinks)
+	}
+	return nil
+}
+
+// Link ties the anonymous ID of a tenant's viewer to their user ID. IDs are
+// as stored, i.e. hashed if the scrubber hashes them.
+type Link struct {
+	Tenant      string    `json:"tenant,omitempty"`
+	AnonymousID string    `json:"anonymousId"`
+	UserID      string    `json:"userId"`
+	LinkedAt    time.Time `json:"linkedAt"`
+}
+
+// Graph holds the links of every tenant. An anonymous ID links to one user,
+// the one who logged in with it last; a user may have many anonymous IDs,
+// one per device or browser. It is safe for concurrent use.
+type Graph struct {
+	max  int
+	path string
+	now  func() time.Time
+
+	mu sync.Mutex
+	// order holds the links, most recently linked first, which is also
+	// newest LinkedAt first
+	order *list.List
+	// links indexes order by tenant and anonymous ID, and users by tenant
+	// and user ID
+	links map[string]*list.Element
+	users map[string][]string
+	file  *os.File
+	lines int
+}
+
+// New creates the graph of cfg, loading File when it exists, or returns nil
+// when stitching is disabled
+func New(cfg Config) (*Graph, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	g := &Graph{
+		max:   cfg.MaxLinks,
+		path:  cfg.File,
+		now:   time.Now,
+		order: list.New(),
+		links: make(map[string]*list.Element),
+		users: make(map[string][]string),
+	}
+	if g.path == "" {
+		return g, nil
+	}
+	if err := g.load(); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(g.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identities file: %w", err)
+	}
+	g.file = file
+	return g, nil
+}
+
+// load replays the links of File, later lines replacing earlier ones
+func (g *Graph) load() error {
+	f, err := os.Open(g.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read identities file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var link Link
+		if err := json.Unmarshal(line, &link); err != nil {
+			return fmt.Errorf("failed to parse identities file, line %d: %w", g.lines+1, err)
+		}
+		g.lines++
+		g.put(link)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read identities file: %w", err)
+	}
+	return nil
+}
+
+func linkKey(tenant, id string) string {
+	return tenant + "\x00" + id
+}
+
+// Link records that the viewer of tenant known by anonymousID is userID,
+// reporting whether that is new: the anonymous ID wasn't linked, or was
+// linked to another user
+func (g *Graph) Link(tenant, anonymousID, userID string) (bool, error) {
+	if anonymousID == "" || userID == "" {
+		return false, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	outcome := "linked"
+	if elem, ok := g.links[linkKey(tenant, anonymousID)]; ok {
+		if elem.Value.(Link).UserID == userID {
+			return false, nil
+		}
+		outcome = "relinked"
+	}
+	link := Link{Tenant: tenant, AnonymousID: anonymousID, UserID: userID, LinkedAt: g.now().UTC()}
+	g.put(link)
+	metrics.IdentityLinks.WithLabelValues(tenant, outcome).Inc()
+	return true, g.append(link)
+}
+
+// Observe links the IDs of the events of batch that carry both
+func (g *Graph) Observe(batch models.EventBatch) error {
+	var err error
+	seen := make(map[string]bool)
+	for _, event := range batch.Events {
+		if event.AnonymousID == "" || event.UserID == "" {
+			continue
+		}
+		key := event.AnonymousID + "\x00" + event.UserID
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if _, linkErr := g.Link(batch.Tenant, event.AnonymousID, event.UserID); linkErr != nil {
+			err = linkErr
+		}
+	}
+	return err
+}
+
+// UserOf returns the link of the anonymous ID of tenant
+func (g *Graph) UserOf(tenant, anonymousID string) (Link, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	elem, ok := g.links[linkKey(tenant, anonymousID)]
+	if !ok {
+		return Link{}, false
+	}
+	return elem.Value.(Link), true
+}
+
+// AnonymousOf returns the links of the user of tenant, oldest first
+func (g *Graph) AnonymousOf(tenant, userID string) []Link {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	links := []Link{}
+	for _, anonymousID := range g.users[linkKey(tenant, userID)] {
+		links = append(links, g.links[linkKey(tenant, anonymousID)].Value.(Link))
+	}
+	sortLinks(links)
+	return links
+}
+
+// List returns the links of tenant made at or after since, oldest first,
+// up to limit of them, and whether more were left out
+func (g *Graph) List(tenant string, since time.Time, limit int) ([]Link, bool) {
+	g.mu.Lock()
+	links := []Link{}
+	for elem := g.order.Back(); elem != nil; elem = elem.Prev() {
+		link := elem.Value.(Link)
+		if link.Tenant == tenant && !link.LinkedAt.Before(since) {
+			links = append(links, link)
+		}
+	}
+	g.mu.Unlock()
+
+	if len(links) > limit {
+		return links[:limit], true
+	}
+	return links, false
+}
+
+func sortLinks(links []Link) {
+	slices.SortStableFunc(links, func(a, b Link) int {
+		return a.LinkedAt.Compare(b.LinkedAt)
+	})
+}
+
+// Forget drops the links of the user of tenant, e.g. when their events are
+// erased, and rewrites File without them. It returns how many were dropped.
+func (g *Graph) Forget(tenant, userID string) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	anonymousIDs := slices.Clone(g.users[linkKey(tenant, userID)])
+	for _, anonymousID := range anonymousIDs {
+		g.remove(linkKey(tenant, anonymousID))
+	}
+	if len(anonymousIDs) == 0 {
+		return 0, nil
+	}
+	return len(anonymousIDs), g.compact()
+}
+
+// Close closes File
+func (g *Graph) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.file == nil {
+		return nil
+	}
+	return g.file.Close()
+}
+
+// put stores link as the most recent one, replacing the link of its
+// anonymous ID and dropping the least recent links beyond the cap. g.mu
+// must be held.
+func (g *Graph) put(link Link) {
+	key := linkKey(link.Tenant, link.AnonymousID)
+	g.remove(key)
+	g.links[key] = g.order.PushFront(link)
+	user := linkKey(link.Tenant, link.UserID)
+	g.users[user] = append(g.users[user], link.AnonymousID)
+
+	for g.order.Len() > g.max {
+		oldest := g.order.Back().Value.(Link)
+		g.remove(linkKey(oldest.Tenant, oldest.AnonymousID))
+	}
+}
+
+// remove drops the link stored under key. g.mu must be held.
+func (g *Graph) remove(key string) {
+	elem, ok := g.links[key]
+	if !ok {
+		return
+	}
+	link := elem.Value.(Link)
+	g.order.Remove(elem)
+	delete(g.links, key)
+
+	user := linkKey(link.Tenant, link.UserID)
+	anonymousIDs := slices.DeleteFunc(g.users[user], func(id string) bool { return id == link.AnonymousID })
+	if len(anonymousIDs) == 0 {
+		delete(g.users, user)
+	} else {
+		g.users[user] = anonymousIDs
+	}
+}
+
+// append writes link to File, compacting it once it holds twice as many
+// lines as there are links. g.mu must be held.
+func (g *Graph) append(link Link) error {
+	if g.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	if _, err := g.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write identities file: %w", err)
+	}
+	g.lines++
+	if g.lines > 2*max(g.order.Len(), minCompactLines) {
+		return g.compact()
+	}
+	return nil
+}
+
+// compact rewrites File with the links kept, oldest first. It writes a
+// temporary file and renames it over the original, so a crash never leaves
+// a truncated file behind. g.mu must be held.
+func (g *Graph) compact() error {
+	if g.file == nil {
+		return nil
+	}
+	tmpPath := g.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to compact identities file: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	for elem := g.order.Back(); elem != nil; elem = elem.Prev() {
+		data, err := json.Marshal(elem.Value.(Link))
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact identities file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact identities file: %w", err)
+	}
+	if err := os.Rename(tmpPath, g.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to compact identities file: %w", err)
+	}
+
+	g.file.Close()
+	file, err := os.OpenFile(g.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		g.file = nil
+		return fmt.Errorf("failed to reopen identities file: %w", err)
+	}
+	g.file = file
+	g.lines = g.order.Len()
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package logger
+
+// freeSpace is not implemented on this platform; the disk space check is
+// skipped
+func freeSpace(dir string) (uint64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,187 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/adtyap26/event-stream-video/internal/encryption"
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// eraseRequest asks the background writer to erase a user's events
+type eraseRequest struct {
+	ctx    context.Context
+	tenant string
+	userID string
+	reply  chan eraseResult
+}
+
+type eraseResult struct {
+	erased int64
+	err    error
+}
+
+// EraseUser rewrites the active and rotated log files without the events
+// of userID. Text logs don't record the tenant, so there the user's events
+// are erased whatever tenant they belong to. The background writer does
+// the rewrite; batches logged meanwhile wait in the queue.
+func (l *EventLogger) EraseUser(ctx context.Context, tenant, userID string) (int64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return 0, ErrClosed
+	}
+
+	reply := make(chan eraseResult, 1)
+	l.eraseReq <- eraseRequest{ctx: ctx, tenant: tenant, userID: userID, reply: reply}
+	result := <-reply
+	return result.erased, result.err
+}
+
+// erase runs on the background writer, after the queue has been drained
+func (l *EventLogger) erase(ctx context.Context, tenant, userID string) (int64, error) {
+	if err := l.flush(); err != nil {
+		return 0, fmt.Errorf("failed to flush log file: %w", err)
+	}
+	// Rotated files may still be being compressed
+	l.archiver.Wait()
+
+	files, err := RotatedFiles(l.logDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var erased int64
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return erased, err
+		}
+		n, err := eraseFile(path, tenant, userID, l.keys)
+		erased += n
+		if err != nil {
+			return erased, err
+		}
+	}
+
+	n, err := l.eraseActive(tenant, userID)
+	return erased + n, err
+}
+
+// eraseActive closes the active file, rewrites it and opens it again
+func (l *EventLogger) eraseActive(tenant, userID string) (int64, error) {
+	openedAt := l.openedAt
+	if err := l.closeFile(); err != nil {
+		return 0, fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	erased, eraseErr := eraseFile(l.activePath(), tenant, userID, l.keys)
+
+	// Always reopen so writes can continue even if the rewrite failed
+	if err := l.openFile(); err != nil {
+		return erased, errors.Join(eraseErr, err)
+	}
+	// The file is the same one as far as rotation by age is concerned
+	l.openedAt = openedAt
+	return erased, eraseErr
+}
+
+// erases reports whether event is one of userID's in tenant. Batches read
+// from text logs have no tenant and match any.
+func erases(batch models.EventBatch, event models.Event, tenant, userID string) bool {
+	return event.UserID == userID && (batch.Tenant == "" || batch.Tenant == tenant)
+}
+
+// eraseFile rewrites a log file without the user's events, dropping
+// batches left without any. Files holding none of them are not touched.
+// The new file is written under a temporary name and renamed into place,
+// encrypted with new data keys when keys is set.
+func eraseFile(path, tenant, userID string, keys *encryption.Keyring) (int64, error) {
+	var erased int64
+	err := ReadFileWithKeys(path, keys, func(batch models.EventBatch) error {
+		for _, event := range batch.Events {
+			if erases(batch, event, tenant, userID) {
+				erased++
+			}
+		}
+		return nil
+	})
+	if err != nil || erased == 0 {
+		return 0, err
+	}
+
+	format := FormatText
+	if strings.HasSuffix(strings.TrimSuffix(path, ".gz"), "."+FormatNDJSON.extension()) {
+		format = FormatNDJSON
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	fail := func(err error) (int64, error) {
+		f.Close()
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to rewrite %s: %w", path, err)
+	}
+
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	buffered := bufio.NewWriter(w)
+	write := func(batch models.EventBatch) error {
+		return format.writeBatch(buffered, batch)
+	}
+	if keys != nil {
+		sealer := keys.NewWriter(buffered)
+		write = func(batch models.EventBatch) error {
+			return sealBatch(sealer, format, batch)
+		}
+	}
+
+	err = ReadFileWithKeys(path, keys, func(batch models.EventBatch) error {
+		kept := batch.Events[:0]
+		for _, event := range batch.Events {
+			if !erases(batch, event, tenant, userID) {
+				kept = append(kept, event)
+			}
+		}
+		if len(kept) == 0 {
+			return nil
+		}
+		batch.Events = kept
+		return write(batch)
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fail(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fail(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to rewrite %s: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("failed to rewrite %s: %w", path, err)
+	}
+	// The offsets of an index no longer hold
+	os.Remove(IndexPath(path))
+	return erased, nil
+}
@@ -1,18 +1,144 @@
 package logger
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
+	"github.com/adtyap26/event-stream-video/internal/encryption"
 	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
 )
 
+const (
+	// DefaultBufferSize is the number of batches that can be queued before LogBatch blocks
+	DefaultBufferSize = 1024
+	// DefaultFlushInterval is how often buffered writes are flushed to disk
+	DefaultFlushInterval = time.Second
+)
+
+// ErrClosed is returned when a batch is logged after Close
+var ErrClosed = errors.New("event logger is closed")
+
+// Format selects how events are written to the log file
+type Format string
+
+const (
+	// FormatText writes a batch header followed by pretty-printed events
+	FormatText Format = "text"
+	// FormatNDJSON writes one compact JSON record per line, including the
+	// batch metadata on every event
+	FormatNDJSON Format = "ndjson"
+)
+
+// ParseFormat converts a format name into a Format
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatNDJSON:
+		return FormatNDJSON, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q", name)
+	}
+}
+
+// extension returns the file extension used for log files in this format
+func (f Format) extension() string {
+	if f == FormatNDJSON {
+		return "ndjson"
+	}
+	return "log"
+}
+
+// Options configures an EventLogger
+type Options struct {
+	Dir           string
+	Format        Format
+	BufferSize    int
+	FlushInterval time.Duration
+
+	// MaxSize rotates the active file once it reaches this many bytes
+	MaxSize int64
+	// MaxAge rotates the active file once it has been open this long
+	MaxAge time.Duration
+	// MaxFiles is the number of rotated files kept in Dir
+	MaxFiles int
+	// Compress gzips rotated files
+	Compress bool
+
+	// MinFreeSpace is the free disk space in bytes below which health
+	// checks fail
+	MinFreeSpace int64
+
+	// Keys, when set, encrypts the batches of tenants that have a key and
+	// decrypts the files read back
+	Keys *encryption.Keyring
+}
+
+// DefaultOptions returns the options used by NewEventLogger
+func DefaultOptions() Options {
+	return Options{
+		Dir:           "logs",
+		Format:        FormatText,
+		BufferSize:    DefaultBufferSize,
+		FlushInterval: DefaultFlushInterval,
+		MinFreeSpace:  DefaultMinFreeSpace,
+	}
+}
+
+var (
+	_ sink.EventSink     = (*EventLogger)(nil)
+	_ sink.Flusher       = (*EventLogger)(nil)
+	_ sink.HealthChecker = (*EventLogger)(nil)
+	_ sink.Backlogger    = (*EventLogger)(nil)
+	_ sink.Eraser        = (*EventLogger)(nil)
+	_ sink.Querier       = (*EventLogger)(nil)
+)
+
+// EventLogger writes event batches to a log file. Batches are queued and
+// written by a background goroutine so callers don't wait on disk I/O.
+//
+// All methods are safe for concurrent use. Only the background goroutine
+// touches the file and its buffered writer, so concurrent LogBatch calls
+// never interleave: every batch is written whole, in the order it was
+// queued, and rotation only happens between batches.
 type EventLogger struct {
-	logFile *os.File
-	logDir  string
+	// logFile and writer are nil while the active file couldn't be opened
+	// again after a rotation; the next write retries
+	logFile  *os.File
+	writer   *bufio.Writer
+	logDir   string
+	format   Format
+	rotation Options
+	size     int64
+	openedAt time.Time
+	archiver sync.WaitGroup
+	// archiving runs the compression and pruning of rotated files one
+	// rotation at a time, so no file is pruned while being compressed
+	archiving sync.Mutex
+	index     fileIndex
+	keys      *encryption.Keyring
+	// sealer encrypts what is written to the active file, nil without keys
+	sealer *encryption.Writer
+
+	flushInterval time.Duration
+	queue         chan models.EventBatch
+	flushReq      chan chan error
+	eraseReq      chan eraseRequest
+	queryReq      chan chan querySnapshot
+	done          chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
 }
 
 func NewEventLogger() (*EventLogger, error) {
@@ -20,53 +146,150 @@ func NewEventLogger() (*EventLogger, error) {
 }
 
 func NewEventLoggerWithDir(logDir string) (*EventLogger, error) {
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	opts := DefaultOptions()
+	opts.Dir = logDir
+	return NewEventLoggerWithOptions(opts)
+}
+
+// NewEventLoggerWithOptions creates an EventLogger and starts its background writer
+func NewEventLoggerWithOptions(opts Options) (*EventLogger, error) {
+	if opts.Dir == "" {
+		opts.Dir = "logs"
+	}
+	if opts.Format == "" {
+		opts.Format = FormatText
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
 		return nil, fmt.Errorf("Failed to create log: %w", err)
 	}
 
-	timestamp := time.Now().Format("2006-01-02-15-04-05")
-	logPath := filepath.Join(logDir, fmt.Sprintf("events-%s.log", timestamp))
+	l := &EventLogger{
+		logDir:        opts.Dir,
+		format:        opts.Format,
+		rotation:      opts,
+		keys:          opts.Keys,
+		flushInterval: opts.FlushInterval,
+		queue:         make(chan models.EventBatch, opts.BufferSize),
+		flushReq:      make(chan chan error),
+		eraseReq:      make(chan eraseRequest),
+		queryReq:      make(chan chan querySnapshot),
+		done:          make(chan struct{}),
+	}
 
-	logFile, err := os.Create(logPath)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create log file: %w", err)
+	// Keep one file per run: anything left over from a previous run is
+	// rotated out before the new active file is opened
+	if err := l.rotateStale(); err != nil {
+		return nil, err
 	}
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	go l.run()
 
-	return &EventLogger{
-		logFile: logFile,
-		logDir:  logDir,
-	}, nil
+	return l, nil
 }
 
-func (l *EventLogger) logEvent(event models.Event) error {
-	eventJSON, err := json.MarshalIndent(event, "", " ")
-	if err != nil {
-		return fmt.Errorf("Failed to marshal event: %w, err")
+// run is the background writer. It owns the file and the buffered writer.
+func (l *EventLogger) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case batch, ok := <-l.queue:
+			if !ok {
+				if err := l.flush(); err != nil {
+					slog.Error("Error flushing event log", "error", err)
+				}
+				return
+			}
+			if err := l.writeBatch(batch); err != nil {
+				slog.Error("Error writing batch", "batchId", batch.BatchID, "error", err)
+			}
+			l.rotateIfNeeded()
+		case reply := <-l.flushReq:
+			l.drain()
+			reply <- l.flush()
+		case req := <-l.eraseReq:
+			l.drain()
+			erased, err := l.erase(req.ctx, req.tenant, req.userID)
+			req.reply <- eraseResult{erased: erased, err: err}
+		case reply := <-l.queryReq:
+			l.drain()
+			reply <- l.snapshot()
+		case <-ticker.C:
+			if err := l.flush(); err != nil {
+				slog.Error("Error flushing event log", "error", err)
+			}
+			l.rotateIfNeeded()
+		}
 	}
+}
 
-	_, err = l.logFile.Write(eventJSON)
-	if err != nil {
-		return fmt.Errorf("Failed to write event JSON: %w", err)
+// drain writes every batch currently waiting in the queue without blocking
+func (l *EventLogger) drain() {
+	for {
+		select {
+		case batch, ok := <-l.queue:
+			if !ok {
+				return
+			}
+			if err := l.writeBatch(batch); err != nil {
+				slog.Error("Error writing batch", "batchId", batch.BatchID, "error", err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *EventLogger) writeBatch(batch models.EventBatch) error {
+	if err := l.ensureOpen(); err != nil {
+		return err
+	}
+	if l.sealer != nil {
+		return sealBatch(l.sealer, l.format, batch)
 	}
+	return l.format.writeBatch(l.writer, batch)
+}
 
-	_, err = l.logFile.WriteString("\n\n")
-	if err != nil {
-		return fmt.Errorf("Failed to write line breaks: %w", err)
+// sealBatch writes batch in format f through sealer
+func sealBatch(sealer *encryption.Writer, f Format, batch models.EventBatch) error {
+	var plain bytes.Buffer
+	if err := f.writeBatch(&plain, batch); err != nil {
+		return err
+	}
+	if err := sealer.WriteBatch(context.Background(), batch.Tenant, plain.Bytes()); err != nil {
+		return fmt.Errorf("failed to write encrypted batch: %w", err)
 	}
 	return nil
 }
 
-func (l *EventLogger) LogBatch(batch models.EventBatch) error {
+// writeBatch writes batch to w in format f
+func (f Format) writeBatch(w io.Writer, batch models.EventBatch) error {
+	if f == FormatNDJSON {
+		return writeRecords(w, batch)
+	}
+
 	batchInfo := fmt.Sprintf("--- Batch from client %s (Session: %s, Batch: %s) ---\n",
 		batch.ClientID, batch.SessionID, batch.BatchID)
 
-	_, err := l.logFile.WriteString(batchInfo)
+	_, err := io.WriteString(w, batchInfo)
 	if err != nil {
 		return fmt.Errorf("failed to write batch header: %w", err)
 	}
 
 	for _, event := range batch.Events {
-		if err := l.logEvent(event); err != nil {
+		if err := logEvent(w, event); err != nil {
 			return err
 		}
 	}
@@ -74,7 +297,89 @@ func (l *EventLogger) LogBatch(batch models.EventBatch) error {
 	return nil
 }
 
-// Close closes the log file
+func logEvent(w io.Writer, event models.Event) error {
+	eventJSON, err := json.MarshalIndent(event, "", " ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal event: %w", err)
+	}
+
+	_, err = w.Write(eventJSON)
+	if err != nil {
+		return fmt.Errorf("Failed to write event JSON: %w", err)
+	}
+
+	_, err = io.WriteString(w, "\n\n")
+	if err != nil {
+		return fmt.Errorf("Failed to write line breaks: %w", err)
+	}
+	return nil
+}
+
+// writeRecords writes the batch as newline-delimited JSON records
+func writeRecords(w io.Writer, batch models.EventBatch) error {
+	encoder := json.NewEncoder(w)
+	for _, record := range batch.Records() {
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("failed to write event record: %w", err)
+		}
+	}
+	return nil
+}
+
+// LogBatch queues a batch for the background writer. It only blocks when
+// the queue is full, until ctx is done.
+func (l *EventLogger) LogBatch(ctx context.Context, batch models.EventBatch) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	select {
+	case l.queue <- batch:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for room in the write queue: %w", ctx.Err())
+	}
+}
+
+// Backlog reports how many batches wait for the background writer and how
+// many fit in the queue before LogBatch blocks
+func (l *EventLogger) Backlog() (queued, capacity int) {
+	return len(l.queue), cap(l.queue)
+}
+
+// Flush forces buffered data to be written to the log file. Batches still
+// waiting in the queue are written first.
+func (l *EventLogger) Flush() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+
+	reply := make(chan error, 1)
+	l.flushReq <- reply
+	return <-reply
+}
+
+// Close drains the queue, flushes pending writes and closes the log file
 func (l *EventLogger) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	<-l.done
+	l.archiver.Wait()
+	if l.logFile == nil {
+		return nil
+	}
 	return l.logFile.Close()
 }
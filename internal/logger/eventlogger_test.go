@@ -0,0 +1,164 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// TestConcurrentLogBatch logs batches from many goroutines while others
+// flush, with rotations between batches, and checks that every batch reads
+// back whole and in the order each goroutine logged it. Run it with -race.
+func TestConcurrentLogBatch(t *testing.T) {
+	for _, format := range []Format{FormatText, FormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			testConcurrentLogBatch(t, format)
+		})
+	}
+}
+
+func testConcurrentLogBatch(t *testing.T, format Format) {
+	const (
+		writers   = 8
+		batches   = 50
+		perBatch  = 5
+		flushers  = 2
+		batchSize = 4 << 10
+	)
+
+	dir := t.TempDir()
+	opts := DefaultOptions()
+	opts.Dir = dir
+	opts.Format = format
+	opts.BufferSize = 16
+	opts.FlushInterval = time.Millisecond
+	// A few batches per file, so rotations happen while batches are queued
+	opts.MaxSize = batchSize
+	l, err := NewEventLoggerWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	var writing, flushing sync.WaitGroup
+	for w := range writers {
+		writing.Go(func() {
+			for b := range batches {
+				if err := l.LogBatch(ctx, testBatch(w, b, perBatch)); err != nil {
+					t.Errorf("writer %d, batch %d: %v", w, b, err)
+					return
+				}
+			}
+		})
+	}
+	stop := make(chan struct{})
+	for range flushers {
+		flushing.Go(func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := l.Flush(); err != nil {
+					t.Errorf("flush: %v", err)
+					return
+				}
+			}
+		})
+	}
+	writing.Wait()
+	close(stop)
+	flushing.Wait()
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Close flushes the active file; rotated files are read as they are
+	l.archiver.Wait()
+
+	files, err := RotatedFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("got %d rotated files, want the test to rotate", len(files))
+	}
+	files = append(files, filepath.Join(dir, "events."+format.extension()))
+
+	next := make([]int, writers)
+	for _, file := range files {
+		err := ReadFile(file, func(batch models.EventBatch) error {
+			w, b, err := parseTestBatchID(batch.BatchID)
+			if err != nil {
+				return err
+			}
+			if b != next[w] {
+				return fmt.Errorf("batch %s read after batch %d of its writer", batch.BatchID, next[w]-1)
+			}
+			next[w]++
+			if len(batch.Events) != perBatch {
+				return fmt.Errorf("batch %s has %d events, want %d", batch.BatchID, len(batch.Events), perBatch)
+			}
+			for i, event := range batch.Events {
+				if want := testEventID(w, b, i); event.EventID != want {
+					return fmt.Errorf("batch %s event %d is %s, want %s", batch.BatchID, i, event.EventID, want)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", filepath.Base(file), err)
+		}
+	}
+	for w, n := range next {
+		if n != batches {
+			t.Errorf("read %d batches of writer %d, want %d", n, w, batches)
+		}
+	}
+}
+
+func testBatch(w, b, events int) models.EventBatch {
+	batch := models.EventBatch{
+		ClientID:  "client",
+		SessionID: fmt.Sprintf("session-%d", w),
+		BatchID:   fmt.Sprintf("w%d-b%d", w, b),
+		Timestamp: "2026-10-14T00:00:00Z",
+	}
+	for i := range events {
+		batch.Events = append(batch.Events, models.Event{
+			EventID:   testEventID(w, b, i),
+			EventName: "heartbeat",
+			VideoID:   "video",
+			SessionID: batch.SessionID,
+			Timestamp: "2026-10-14T00:00:00Z",
+			// Padding makes batches big enough to rotate every few
+			CustomData: strings.Repeat("x", 256),
+		})
+	}
+	return batch
+}
+
+func testEventID(w, b, i int) string {
+	return fmt.Sprintf("w%d-b%d-e%d", w, b, i)
+}
+
+func parseTestBatchID(id string) (w, b int, err error) {
+	writer, batch, ok := strings.Cut(id, "-")
+	if !ok || !strings.HasPrefix(writer, "w") || !strings.HasPrefix(batch, "b") {
+		return 0, 0, fmt.Errorf("unexpected batch ID %q", id)
+	}
+	if w, err = strconv.Atoi(writer[1:]); err != nil {
+		return 0, 0, err
+	}
+	if b, err = strconv.Atoi(batch[1:]); err != nil {
+		return 0, 0, err
+	}
+	return w, b, nil
+}
@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// DefaultMinFreeSpace is the free disk space below which the logger reports
+// itself unhealthy
+const DefaultMinFreeSpace = 64 << 20
+
+// CheckHealth verifies that the log directory is writable and has at least
+// MinFreeSpace bytes available
+func (l *EventLogger) CheckHealth(ctx context.Context) error {
+	l.mu.RLock()
+	closed := l.closed
+	l.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	probe, err := os.CreateTemp(l.logDir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("log directory is not writable: %w", err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if l.rotation.MinFreeSpace <= 0 {
+		return nil
+	}
+	free, ok, err := freeSpace(l.logDir)
+	if err != nil {
+		return fmt.Errorf("failed to check free disk space: %w", err)
+	}
+	if ok && free < uint64(l.rotation.MinFreeSpace) {
+		return fmt.Errorf("only %d bytes free in %s, need %d", free, l.logDir, l.rotation.MinFreeSpace)
+	}
+	return nil
+}
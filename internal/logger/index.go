@@ -0,0 +1,289 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/encryption"
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+const (
+	// indexExtension is appended to the name of an indexed log file to name
+	// its index
+	indexExtension = ".idx"
+	// indexBlockSize is how many bytes of records a time block of an index
+	// spans at least
+	indexBlockSize = 256 << 10
+)
+
+// Index locates the events of an uncompressed, unencrypted NDJSON log file
+// by session and by time, so queries read only the lines that may match
+// instead of the whole file. It is kept next to the file, under its name
+// followed by .idx.
+type Index struct {
+	// Size and ModTime are those of the file when it was indexed; once the
+	// file changes its index is ignored
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	First   time.Time `json:"first"`
+	Last    time.Time `json:"last"`
+	// Sessions holds the extents of the records of every session ID
+	Sessions map[string][]Extent `json:"sessions"`
+	// Blocks cover the file in order, each at least indexBlockSize long
+	Blocks []Extent `json:"blocks"`
+}
+
+// Extent is a run of whole lines of a log file and the range of the event
+// times of the records in it
+type Extent struct {
+	Offset int64     `json:"offset"`
+	Length int64     `json:"length"`
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+}
+
+// IndexPath returns the path of the index of the log file at path
+func IndexPath(path string) string {
+	return path + indexExtension
+}
+
+// LoadIndex reads the index of the log file at path, whose current state
+// is info. It returns nil without an error when the file has no index or
+// changed since it was indexed.
+func LoadIndex(path string, info os.FileInfo) (*Index, error) {
+	data, err := os.ReadFile(IndexPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid index of %s: %w", path, err)
+	}
+	if index.Size != info.Size() || !index.ModTime.Equal(info.ModTime()) {
+		return nil, nil
+	}
+	return &index, nil
+}
+
+// Extents returns the extents that may hold events of sessionID, or of any
+// session when it is empty, with event times in [from, to)
+func (x *Index) Extents(sessionID string, from, to time.Time) []Extent {
+	extents := x.Blocks
+	if sessionID != "" {
+		extents = x.Sessions[sessionID]
+	}
+	var overlapping []Extent
+	for _, extent := range extents {
+		if !from.IsZero() && extent.Last.Before(from) || !to.IsZero() && !extent.First.Before(to) {
+			continue
+		}
+		overlapping = append(overlapping, extent)
+	}
+	return overlapping
+}
+
+func (e *Extent) extend(t time.Time) {
+	if e.First.IsZero() && e.Last.IsZero() || t.Before(e.First) {
+		e.First = t
+	}
+	if t.After(e.Last) {
+		e.Last = t
+	}
+}
+
+// indexer builds the index of a file from the records written to it
+type indexer struct {
+	index  Index
+	offset int64
+	block  *Extent
+}
+
+func newIndexer() *indexer {
+	return &indexer{index: Index{Sessions: make(map[string][]Extent)}}
+}
+
+// add records that the line of record, length bytes long, was written at
+// the end of the file
+func (x *indexer) add(record models.EventRecord, length int64) {
+	t := recordTime(record)
+	if len(x.index.Blocks) == 0 {
+		x.index.First, x.index.Last = t, t
+	}
+	if t.Before(x.index.First) {
+		x.index.First = t
+	}
+	if t.After(x.index.Last) {
+		x.index.Last = t
+	}
+
+	if x.block == nil || x.block.Length >= indexBlockSize {
+		x.index.Blocks = append(x.index.Blocks, Extent{Offset: x.offset, First: t, Last: t})
+		x.block = &x.index.Blocks[len(x.index.Blocks)-1]
+	}
+	x.block.Length += length
+	x.block.extend(t)
+
+	// Consecutive records of a session share an extent
+	extents := x.index.Sessions[record.SessionID]
+	if last := len(extents) - 1; last >= 0 && extents[last].Offset+extents[last].Length == x.offset {
+		extents[last].Length += length
+		extents[last].extend(t)
+	} else {
+		extents = append(extents, Extent{Offset: x.offset, Length: length, First: t, Last: t})
+	}
+	x.index.Sessions[record.SessionID] = extents
+	x.offset += length
+}
+
+// write saves the index of the log file at path, whose final state is info
+func (x *indexer) write(path string, info os.FileInfo) error {
+	x.index.Size, x.index.ModTime = info.Size(), info.ModTime()
+	data, err := json.Marshal(x.index)
+	if err != nil {
+		return err
+	}
+	tmpPath := IndexPath(path) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, IndexPath(path)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// IsText reports whether the log file at path is a text log, gzipped or not
+func IsText(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, ".gz"), "."+FormatText.extension())
+}
+
+// ConvertText rewrites the text log file at path, optionally gzipped, as
+// an NDJSON file of the same name next to it and indexes the new file. The
+// batches of text logs don't record a tenant; they get tenant. Encrypted
+// batches are decrypted with keys, and when keys encrypt tenant's batches
+// the new file is encrypted too, and not indexed. The text file is removed
+// once the NDJSON file is in place, and the path of that is returned.
+func ConvertText(path, tenant string, keys *encryption.Keyring) (string, error) {
+	if !IsText(path) {
+		return "", fmt.Errorf("%s is not a text log file", path)
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), "."+FormatText.extension())
+	out := base + "." + FormatNDJSON.extension()
+	if fileExists(out) || fileExists(out+".gz") {
+		return "", fmt.Errorf("%s already exists", filepath.Base(out))
+	}
+
+	tmpPath := out + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", err
+	}
+	fail := func(err error) (string, error) {
+		f.Close()
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to convert %s: %w", path, err)
+	}
+
+	buffered := bufio.NewWriter(f)
+	index := newIndexer()
+	// Indexes locate the lines of plain files only
+	var sealer *encryption.Writer
+	if keys != nil && keys.Encrypts(tenant) {
+		sealer = keys.NewWriter(buffered)
+	}
+	err = ReadFileWithKeys(path, keys, func(batch models.EventBatch) error {
+		if batch.Tenant == "" {
+			batch.Tenant = tenant
+		}
+		if sealer != nil {
+			return sealBatch(sealer, FormatNDJSON, batch)
+		}
+		for _, record := range batch.Records() {
+			line, err := json.Marshal(record)
+			if err != nil {
+				return fmt.Errorf("failed to write event record: %w", err)
+			}
+			line = append(line, '\n')
+			if _, err := buffered.Write(line); err != nil {
+				return err
+			}
+			index.add(record, int64(len(line)))
+		}
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to convert %s: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, out); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to convert %s: %w", path, err)
+	}
+	// Both files hold the same events; queries must not read them twice
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+	if sealer != nil {
+		return out, nil
+	}
+
+	info, err := os.Stat(out)
+	if err != nil {
+		return "", err
+	}
+	if err := index.write(out, info); err != nil {
+		return "", fmt.Errorf("failed to index %s: %w", out, err)
+	}
+	return out, nil
+}
+
+// readExtents reads the records in extents of the log file f
+func readExtents(f io.ReaderAt, extents []Extent, fn func(models.EventBatch) error) error {
+	for _, extent := range extents {
+		if err := readRecords(io.NewSectionReader(f, extent.Offset, extent.Length), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStaleIndexes removes the indexes in dir whose log file is gone,
+// e.g. pruned or archived
+func removeStaleIndexes(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, indexExtension) {
+			continue
+		}
+		if fileExists(filepath.Join(dir, strings.TrimSuffix(name, indexExtension))) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
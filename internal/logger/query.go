@@ -0,0 +1,299 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+// querySnapshot is what the background writer hands a query once the
+// queue is drained: the rotated files and the active file up to the last
+// batch written
+type querySnapshot struct {
+	rotated []string
+	active  *os.File
+	size    int64
+	err     error
+}
+
+// snapshot runs on the background writer, where rotation happens, so the
+// files it lists are consistent with each other
+func (l *EventLogger) snapshot() querySnapshot {
+	if err := l.flush(); err != nil {
+		return querySnapshot{err: fmt.Errorf("failed to flush log file: %w", err)}
+	}
+	rotated, err := RotatedFiles(l.logDir)
+	if err != nil {
+		return querySnapshot{err: err}
+	}
+	active, err := os.Open(l.activePath())
+	if err != nil {
+		return querySnapshot{err: err}
+	}
+	return querySnapshot{rotated: rotated, active: active, size: l.size}
+}
+
+// fileSpan is the range of event times in a rotated file, which doesn't
+// change until the file is compressed or rewritten by an erasure
+type fileSpan struct {
+	size    int64
+	modTime time.Time
+	first   time.Time
+	last    time.Time
+	// index, set for files converted by ConvertText, locates their events
+	index *Index
+}
+
+// fileIndex remembers the spans of rotated files, so queries skip files
+// without events in their time range instead of reading them
+type fileIndex struct {
+	mu    sync.Mutex
+	spans map[string]fileSpan
+}
+
+func (x *fileIndex) get(path string, info os.FileInfo) (fileSpan, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	span, ok := x.spans[path]
+	if !ok || span.size != info.Size() || !span.modTime.Equal(info.ModTime()) {
+		return fileSpan{}, false
+	}
+	return span, true
+}
+
+func (x *fileIndex) set(path string, span fileSpan) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.spans == nil {
+		x.spans = make(map[string]fileSpan)
+	}
+	x.spans[path] = span
+}
+
+// retain forgets the spans of files that were pruned or compressed
+func (x *fileIndex) retain(paths map[string]bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for path := range x.spans {
+		if !paths[path] {
+			delete(x.spans, path)
+		}
+	}
+}
+
+// QueryEvents searches the rotated and active log files. Every file whose
+// time range may hold matching events is read, so a query costs a scan of
+// those files; narrow time ranges keep it cheap. Of files indexed by
+// ConvertText only the lines of the session or time range queried are
+// read. Text logs don't record the tenant, so there events match whatever
+// tenant they belong to.
+func (l *EventLogger) QueryEvents(ctx context.Context, q sink.Query) (sink.QueryResult, error) {
+	cursor, err := sink.ParseCursor(q.Cursor)
+	if err != nil {
+		return sink.QueryResult{}, err
+	}
+	if q.Limit <= 0 {
+		q.Limit = sink.DefaultQueryLimit
+	}
+
+	l.mu.RLock()
+	if l.closed {
+		l.mu.RUnlock()
+		return sink.QueryResult{}, ErrClosed
+	}
+	reply := make(chan querySnapshot, 1)
+	l.queryReq <- reply
+	l.mu.RUnlock()
+
+	snap := <-reply
+	if snap.err != nil {
+		return sink.QueryResult{}, snap.err
+	}
+	defer snap.active.Close()
+
+	// Events at the cursor's time may still follow it
+	from := q.From
+	if q.Cursor != "" && cursor.Time.After(from) {
+		from = cursor.Time
+	}
+
+	page := &queryPage{limit: q.Limit}
+	collect := func(span *fileSpan) func(models.EventBatch) error {
+		return func(batch models.EventBatch) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for i, record := range batch.Records() {
+				t := recordTime(record)
+				if span != nil {
+					span.extend(t)
+				}
+				key := recordKey(record, i)
+				if q.Matches(record, t) && (q.Cursor == "" || cursor.After(t, key)) {
+					page.add(record, t, key)
+				}
+			}
+			return nil
+		}
+	}
+
+	seen := make(map[string]bool, len(snap.rotated))
+	for _, path := range snap.rotated {
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Compressed or pruned since the snapshot
+			path += ".gz"
+			f, err = os.Open(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+		}
+		if err != nil {
+			return sink.QueryResult{}, err
+		}
+		seen[path] = true
+
+		err = l.queryFile(path, f, q.SessionID, from, q.To, collect)
+		f.Close()
+		if err != nil {
+			return sink.QueryResult{}, err
+		}
+	}
+	l.index.retain(seen)
+
+	active := io.NewSectionReader(snap.active, 0, snap.size)
+	if err := readLog(snap.active.Name(), active, l.keys, collect(nil)); err != nil {
+		return sink.QueryResult{}, err
+	}
+	return page.result(), nil
+}
+
+// queryFile reads a rotated file unless its span is known to lie outside
+// [from, to), and records its span once it was read whole. Of indexed
+// files only the extents that may hold events of sessionID in the range
+// are read.
+func (l *EventLogger) queryFile(path string, f *os.File, sessionID string, from, to time.Time,
+	collect func(*fileSpan) func(models.EventBatch) error) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	span, ok := l.index.get(path, info)
+	if !ok {
+		index, err := LoadIndex(path, info)
+		if err != nil {
+			slog.Warn("Failed to load log file index, reading the file whole", "file", path, "error", err)
+		}
+		if index != nil {
+			span = fileSpan{size: info.Size(), modTime: info.ModTime(), first: index.First, last: index.Last, index: index}
+			l.index.set(path, span)
+			ok = true
+		}
+	}
+	if ok {
+		if !from.IsZero() && span.last.Before(from) || !to.IsZero() && !span.first.Before(to) {
+			return nil
+		}
+		if span.index != nil {
+			return readExtents(f, span.index.Extents(sessionID, from, to), collect(nil))
+		}
+		return readLog(path, f, l.keys, collect(nil))
+	}
+
+	full := &fileSpan{size: info.Size(), modTime: info.ModTime()}
+	if err := readLog(path, f, l.keys, collect(full)); err != nil {
+		return err
+	}
+	l.index.set(path, *full)
+	return nil
+}
+
+func (s *fileSpan) extend(t time.Time) {
+	if s.first.IsZero() && s.last.IsZero() || t.Before(s.first) {
+		s.first = t
+	}
+	if t.After(s.last) {
+		s.last = t
+	}
+}
+
+// recordTime is the event time of record, falling back to when its batch
+// was received
+func recordTime(record models.EventRecord) time.Time {
+	for _, value := range []string{record.Timestamp, record.ReceivedAt} {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// recordKey orders events of the same time: by event ID, or by position
+// for events logged before the collector assigned IDs
+func recordKey(record models.EventRecord, index int) string {
+	if record.EventID != "" {
+		return record.EventID
+	}
+	return record.BatchID + "/" + strconv.Itoa(index)
+}
+
+// queryPage keeps the earliest events found so far, one more than the
+// limit to tell whether there is a next page
+type queryPage struct {
+	limit   int
+	entries []pageEntry
+}
+
+type pageEntry struct {
+	time   time.Time
+	key    string
+	record models.EventRecord
+}
+
+func (p *queryPage) add(record models.EventRecord, t time.Time, key string) {
+	p.entries = append(p.entries, pageEntry{time: t, key: key, record: record})
+	if len(p.entries) >= 4*(p.limit+1) {
+		p.trim()
+	}
+}
+
+func (p *queryPage) trim() {
+	slices.SortFunc(p.entries, func(a, b pageEntry) int {
+		if c := a.time.Compare(b.time); c != 0 {
+			return c
+		}
+		if a.key < b.key {
+			return -1
+		}
+		if a.key > b.key {
+			return 1
+		}
+		return 0
+	})
+	if len(p.entries) > p.limit+1 {
+		p.entries = p.entries[:p.limit+1]
+	}
+}
+
+func (p *queryPage) result() sink.QueryResult {
+	p.trim()
+	result := sink.QueryResult{Events: make([]models.EventRecord, 0, min(len(p.entries), p.limit))}
+	for _, entry := range p.entries[:min(len(p.entries), p.limit)] {
+		result.Events = append(result.Events, entry.record)
+	}
+	if len(p.entries) > p.limit {
+		last := p.entries[p.limit-1]
+		result.NextCursor = sink.Cursor{Time: last.time, Key: last.key}.Encode()
+	}
+	return result
+}
@@ -0,0 +1,171 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/adtyap26/event-stream-video/internal/encryption"
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// batchHeader matches the line written before each batch in FormatText
+var batchHeader = regexp.MustCompile(`^--- Batch from client (.*) \(Session: (.*), Batch: (.*)\) ---$`)
+
+// maxLineSize is the longest line ReadFile accepts, enough for any batch a
+// collector would have accepted
+const maxLineSize = 16 << 20
+
+// ReadFile reads a log file written by an EventLogger and calls fn for
+// every batch in it, in file order. The format is taken from the file
+// extension: .ndjson or .log, optionally followed by .gz.
+//
+// NDJSON records carry all batch metadata. Text logs only record the
+// client, session and batch IDs, so batches read from them have no batch
+// timestamp, tenant or receive time.
+func ReadFile(path string, fn func(models.EventBatch) error) error {
+	return ReadFileWithKeys(path, nil, fn)
+}
+
+// ReadFileWithKeys reads a log file like ReadFile, decrypting its encrypted
+// batches with keys
+func ReadFileWithKeys(path string, keys *encryption.Keyring, fn func(models.EventBatch) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readLog(path, f, keys, fn)
+}
+
+// readLog reads the log file named path from r
+func readLog(path string, r io.Reader, keys *encryption.Keyring, fn func(models.EventBatch) error) error {
+	name := path
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer gz.Close()
+		r = gz
+		name = strings.TrimSuffix(name, ".gz")
+	}
+	r = encryption.NewReader(context.Background(), r, keys)
+
+	var err error
+	switch {
+	case strings.HasSuffix(name, "."+FormatNDJSON.extension()):
+		err = readRecords(r, fn)
+	case strings.HasSuffix(name, "."+FormatText.extension()):
+		err = readText(r, fn)
+	default:
+		return fmt.Errorf("unknown log file type %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// readRecords regroups consecutive NDJSON records of the same batch
+func readRecords(r io.Reader, fn func(models.EventBatch) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+
+	var batch *models.EventBatch
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var record models.EventRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if batch != nil && (batch.Tenant != record.Tenant || batch.ClientID != record.ClientID || batch.BatchID != record.BatchID) {
+			if err := fn(*batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+		if batch == nil {
+			batch = &models.EventBatch{
+				ClientID:   record.ClientID,
+				SessionID:  record.BatchSessionID,
+				BatchID:    record.BatchID,
+				Timestamp:  record.BatchTimestamp,
+				IsRetry:    record.IsRetry,
+				ReceivedAt: record.ReceivedAt,
+				Tenant:     record.Tenant,
+			}
+		}
+		batch.Events = append(batch.Events, record.Event)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if batch != nil {
+		return fn(*batch)
+	}
+	return nil
+}
+
+// readText parses headers and the pretty-printed events following them
+func readText(r io.Reader, fn func(models.EventBatch) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLineSize)
+
+	var batch *models.EventBatch
+	var body bytes.Buffer
+	flush := func() error {
+		if batch == nil {
+			return nil
+		}
+		decoder := json.NewDecoder(&body)
+		for {
+			var event models.Event
+			err := decoder.Decode(&event)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("batch %s: %w", batch.BatchID, err)
+			}
+			batch.Events = append(batch.Events, event)
+		}
+		body.Reset()
+		return fn(*batch)
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := batchHeader.FindStringSubmatch(line); m != nil {
+			if err := flush(); err != nil {
+				return err
+			}
+			batch = &models.EventBatch{ClientID: m[1], SessionID: m[2], BatchID: m[3]}
+			continue
+		}
+		if batch == nil {
+			continue
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,351 @@
+package logger
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/pkg/utils"
+)
+
+// countingWriter tracks how many bytes have reached the active file
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// activePath is the file currently being written to
+func (l *EventLogger) activePath() string {
+	return filepath.Join(l.logDir, "events."+l.format.extension())
+}
+
+// openFile opens the active log file for appending
+func (l *EventLogger) openFile() error {
+	logFile, err := os.OpenFile(l.activePath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to create log file: %w", err)
+	}
+
+	info, err := logFile.Stat()
+	if err != nil {
+		logFile.Close()
+		return fmt.Errorf("Failed to stat log file: %w", err)
+	}
+
+	l.logFile = logFile
+	l.size = info.Size()
+	l.openedAt = time.Now()
+	l.writer = bufio.NewWriter(countingWriter{w: logFile, n: &l.size})
+	if l.keys != nil {
+		// New data keys for every file, stored in it before they are used
+		l.sealer = l.keys.NewWriter(l.writer)
+	}
+	return nil
+}
+
+// ensureOpen opens the active file again if a rotation closed it but
+// failed to open a fresh one
+func (l *EventLogger) ensureOpen() error {
+	if l.writer != nil {
+		return nil
+	}
+	return l.openFile()
+}
+
+// flush writes the buffered data to the active file
+func (l *EventLogger) flush() error {
+	if err := l.ensureOpen(); err != nil {
+		return err
+	}
+	return l.writer.Flush()
+}
+
+// closeFile closes the active file. Until it is opened again, writes
+// retry opening it.
+func (l *EventLogger) closeFile() error {
+	err := l.logFile.Close()
+	l.logFile, l.writer, l.sealer = nil, nil, nil
+	return err
+}
+
+// rotateStale moves a non-empty active file left by a previous run aside
+func (l *EventLogger) rotateStale() error {
+	info, err := os.Stat(l.activePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to stat log file: %w", err)
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	return l.archive(info.ModTime())
+}
+
+// rotateIfNeeded rotates the active file when it exceeds MaxSize or MaxAge
+func (l *EventLogger) rotateIfNeeded() {
+	if l.writer == nil {
+		return
+	}
+	size := l.size + int64(l.writer.Buffered())
+	if size == 0 {
+		return
+	}
+
+	tooBig := l.rotation.MaxSize > 0 && size >= l.rotation.MaxSize
+	tooOld := l.rotation.MaxAge > 0 && time.Since(l.openedAt) >= l.rotation.MaxAge
+	if !tooBig && !tooOld {
+		return
+	}
+
+	if err := l.rotate(); err != nil {
+		slog.Error("Error rotating event log", "error", err)
+	}
+}
+
+// rotate closes the active file, renames it and opens a fresh one
+func (l *EventLogger) rotate() error {
+	if err := l.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush log file: %w", err)
+	}
+	if err := l.closeFile(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	archiveErr := l.archive(time.Now())
+
+	// Always reopen so writes can continue even if the rename failed; if
+	// this fails too, the next write tries again
+	if err := l.openFile(); err != nil {
+		return err
+	}
+	return archiveErr
+}
+
+// archive renames the active file to a timestamped name and, if enabled,
+// compresses it in the background. Old files beyond MaxFiles are removed.
+func (l *EventLogger) archive(stamp time.Time) error {
+	rotatedPath := l.rotatedPath(stamp)
+	if err := os.Rename(l.activePath(), rotatedPath); err != nil {
+		return fmt.Errorf("failed to rename log file: %w", err)
+	}
+
+	l.archiver.Add(1)
+	go func() {
+		defer l.archiver.Done()
+		l.archiving.Lock()
+		defer l.archiving.Unlock()
+
+		// A later rotation may have pruned the file already
+		if l.rotation.Compress && fileExists(rotatedPath) {
+			if err := compressFile(rotatedPath); err != nil {
+				slog.Error("Error compressing event log", "file", rotatedPath, "error", err)
+			}
+		}
+		if err := l.prune(); err != nil {
+			slog.Error("Error removing old event logs", "error", err)
+		}
+	}()
+	return nil
+}
+
+// rotatedPath picks an unused timestamped file name for a rotated file
+func (l *EventLogger) rotatedPath(stamp time.Time) string {
+	base := "events-" + utils.FileTimestamp(stamp)
+	ext := "." + l.format.extension()
+
+	path := filepath.Join(l.logDir, base+ext)
+	for i := 1; fileExists(path) || fileExists(path+".gz"); i++ {
+		path = filepath.Join(l.logDir, fmt.Sprintf("%s-%d%s", base, i, ext))
+	}
+	return path
+}
+
+// RotatedFiles lists the rotated log files in dir, oldest first
+func RotatedFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !IsRotated(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	slices.SortFunc(files, compareRotated)
+	return files, nil
+}
+
+// compareRotated orders rotated files by their stamp and then by the
+// number rotatedPath appends when a stamp is taken, which comparing names
+// gets wrong: "-" sorts before "." and "-10" before "-2"
+func compareRotated(a, b string) int {
+	stampA, nA, okA := rotatedStamp(filepath.Base(a))
+	stampB, nB, okB := rotatedStamp(filepath.Base(b))
+	if !okA || !okB {
+		return strings.Compare(a, b)
+	}
+	return cmp.Or(stampA.Compare(stampB), cmp.Compare(nA, nB), strings.Compare(a, b))
+}
+
+// rotatedStamp parses the name of a rotated file into its stamp and
+// collision number, 0 for the first file of a stamp
+func rotatedStamp(name string) (time.Time, int, bool) {
+	rest, ok := strings.CutPrefix(name, "events-")
+	if !ok || len(rest) < len(utils.FileTimestampLayout) {
+		return time.Time{}, 0, false
+	}
+	stamp, err := time.Parse(utils.FileTimestampLayout, rest[:len(utils.FileTimestampLayout)])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	rest = rest[len(utils.FileTimestampLayout):]
+	suffix, ok := strings.CutPrefix(rest, "-")
+	if !ok {
+		return stamp, 0, true
+	}
+	digits, _, _ := strings.Cut(suffix, ".")
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return stamp, n, true
+}
+
+// IsRotated reports whether name is that of a complete rotated log file
+func IsRotated(name string) bool {
+	return strings.HasPrefix(name, "events-") && !strings.HasSuffix(name, ".tmp") && !strings.HasSuffix(name, indexExtension)
+}
+
+// prune removes the oldest rotated files beyond MaxFiles
+func (l *EventLogger) prune() error {
+	if _, err := pruneDir(l.logDir, l.rotation.MaxFiles); err != nil {
+		return err
+	}
+	return removeStaleIndexes(l.logDir)
+}
+
+// pruneDir removes the oldest rotated files in dir beyond maxFiles, keeping
+// all of them when maxFiles is zero, and returns how many it removed
+func pruneDir(dir string, maxFiles int) (int, error) {
+	if maxFiles <= 0 {
+		return 0, nil
+	}
+
+	files, err := RotatedFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for len(files) > maxFiles {
+		if err := os.Remove(files[0]); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		os.Remove(IndexPath(files[0]))
+		removed++
+		files = files[1:]
+	}
+	return removed, nil
+}
+
+// CompressRotated does to the rotated log files in dir what a collector
+// with compression enabled does on rotation: it gzips the files that
+// aren't yet and removes the oldest beyond maxFiles, keeping all of them
+// when maxFiles is zero. It returns how many files it compressed and
+// removed. The active file of a running collector isn't touched, nor are
+// files indexed by ConvertText, which queries read in place.
+func CompressRotated(dir string, maxFiles int) (compressed, removed int, err error) {
+	files, err := RotatedFiles(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, ".gz") || indexed(file) {
+			continue
+		}
+		if err := compressFile(file); err != nil {
+			return compressed, 0, fmt.Errorf("failed to compress %s: %w", file, err)
+		}
+		compressed++
+	}
+	removed, err = pruneDir(dir, maxFiles)
+	if err != nil {
+		return compressed, removed, err
+	}
+	return compressed, removed, removeStaleIndexes(dir)
+}
+
+// indexed reports whether the file at path has an index, which compressing
+// it would make useless
+func indexed(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	index, _ := LoadIndex(path, info)
+	return index != nil
+}
+
+// compressFile gzips path into path.gz and removes the original. The
+// compressed file is written under a temporary name and renamed into place
+// so readers never see a partial archive.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := path + ".gz.tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path+".gz"); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,366 @@
+// Package metadata joins the video IDs of events with the title, duration,
+// category and publisher a content management system holds for them, so
+// reports can name videos instead of keying them by opaque IDs
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// Placeholders of Config.URL
+const (
+	PlaceholderVideoID = "{videoId}"
+	PlaceholderTenant  = "{tenant}"
+)
+
+// Defaults
+const (
+	DefaultTimeout    = 2 * time.Second
+	DefaultTTL        = time.Hour
+	DefaultMissingTTL = time.Minute
+	DefaultMaxEntries = 100000
+	// maxResponseBytes caps the CMS responses read
+	maxResponseBytes = 1 << 20
+)
+
+// Config configures the metadata lookups
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// URL is the CMS endpoint describing one video, with PlaceholderVideoID
+	// and optionally PlaceholderTenant in it, e.g.
+	// https://cms.example.com/api/videos/{videoId}
+	URL string `yaml:"url"`
+	// Headers are sent with every lookup, e.g. an Authorization token
+	Headers map[string]string `yaml:"headers"`
+	// Timeout bounds one lookup, which holds up the batches of its video
+	Timeout time.Duration `yaml:"timeout"`
+	// TTL is how long the metadata of a video is kept before it is looked
+	// up again
+	TTL time.Duration `yaml:"ttl"`
+	// MissingTTL is how long videos the CMS doesn't know, or failed to
+	// answer for, go without metadata before they are looked up again
+	MissingTTL time.Duration `yaml:"missingTtl"`
+	// MaxEntries caps the videos kept; the ones closest to expiring are
+	// dropped beyond it
+	MaxEntries int `yaml:"maxEntries"`
+	// Fields name the fields of the CMS response holding each value
+	Fields Fields `yaml:"fields"`
+}
+
+// Fields are paths into the JSON object the CMS answers with, with dots
+// between the keys of nested objects, e.g. data.attributes.title. Empty
+// fields aren't read.
+type Fields struct {
+	Title string `yaml:"title"`
+	// Duration is read as seconds, from a number or a numeric string
+	Duration  string `yaml:"duration"`
+	Category  string `yaml:"category"`
+	Publisher string `yaml:"publisher"`
+}
+
+// DefaultConfig keeps lookups off; the other fields are the defaults once
+// they are enabled
+func DefaultConfig() Config {
+	return Config{
+		Timeout:    DefaultTimeout,
+		TTL:        DefaultTTL,
+		MissingTTL: DefaultMissingTTL,
+		MaxEntries: DefaultMaxEntries,
+		Fields:     Fields{Title: "title", Duration: "duration", Category: "category", Publisher: "publisher"},
+	}
+}
+
+// Validate checks the URL, timeouts and capacity
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid metadata url %q", c.URL)
+	}
+	if !strings.Contains(c.URL, PlaceholderVideoID) {
+		return fmt.Errorf("metadata url has no %s placeholder", PlaceholderVideoID)
+	}
+	if c.Timeout <= 0 || c.TTL <= 0 || c.MissingTTL < 0 {
+		return fmt.Errorf("invalid metadata timeout %v, ttl %v or missingTtl %v", c.Timeout, c.TTL, c.MissingTTL)
+	}
+	if c.MaxEntries <= 0 {
+		return fmt.Errorf("invalid metadata maxEntries %d", c.MaxEntries)
+	}
+	if c.Fields == (Fields{}) {
+		return errors.New("metadata fields name no field to read")
+	}
+	return nil
+}
+
+// entry is the metadata of a video being looked up, or looked up once done
+// is closed. content is nil for videos without metadata.
+type entry struct {
+	done    chan struct{}
+	content *models.Content
+	expires time.Time
+}
+
+// Resolver looks up and keeps the metadata of videos. It is safe for
+// concurrent use.
+type Resolver struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// New creates the resolver of cfg, or returns nil when it is disabled
+func New(cfg Config) (*Resolver, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Resolver{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		now:     time.Now,
+		entries: make(map[string]*entry),
+	}, nil
+}
+
+// Enrich sets the Content of every event with a video ID to the metadata of
+// its video, looking the batch's videos up concurrently. Events of videos
+// without metadata get none; content sent by the client is replaced either
+// way, so it can't be spoofed.
+func (r *Resolver) Enrich(ctx context.Context, batch *models.EventBatch) {
+	contents := make(map[string]*models.Content)
+	for _, event := range batch.Events {
+		if event.VideoID != "" {
+			contents[event.VideoID] = nil
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for videoID := range contents {
+		wg.Go(func() {
+			content := r.Resolve(ctx, batch.Tenant, videoID)
+			mu.Lock()
+			contents[videoID] = content
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	for i := range batch.Events {
+		event := &batch.Events[i]
+		event.Content = nil
+		if content := contents[event.VideoID]; content != nil {
+			// Each event gets a copy, so later stages can't change the
+			// metadata kept for the video
+			c := *content
+			event.Content = &c
+		}
+	}
+}
+
+// Resolve returns the metadata of the video of tenant, or nil when the CMS
+// has none. Lookups of a video arriving while it is looked up wait for that
+// lookup instead of repeating it.
+func (r *Resolver) Resolve(ctx context.Context, tenant, videoID string) *models.Content {
+	key := tenant + "\x00" + videoID
+	r.mu.Lock()
+	if e, ok := r.entries[key]; ok {
+		select {
+		case <-e.done:
+			if r.now().Before(e.expires) {
+				r.mu.Unlock()
+				metrics.MetadataLookups.WithLabelValues("cached").Inc()
+				return e.content
+			}
+		default:
+			r.mu.Unlock()
+			select {
+			case <-e.done:
+				return e.content
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	e := &entry{done: make(chan struct{})}
+	r.entries[key] = e
+	r.mu.Unlock()
+
+	// The lookup outlives a caller that goes away, for the others waiting
+	// on it; the client's timeout bounds it
+	content, err := r.fetch(context.WithoutCancel(ctx), tenant, videoID)
+	ttl := r.cfg.TTL
+	switch {
+	case err != nil:
+		slog.WarnContext(ctx, "Video metadata lookup failed", "videoId", videoID, "error", err)
+		metrics.MetadataLookups.WithLabelValues("failed").Inc()
+		ttl = r.cfg.MissingTTL
+	case content == nil:
+		metrics.MetadataLookups.WithLabelValues("missing").Inc()
+		ttl = r.cfg.MissingTTL
+	default:
+		metrics.MetadataLookups.WithLabelValues("found").Inc()
+	}
+
+	r.mu.Lock()
+	e.content = content
+	e.expires = r.now().Add(ttl)
+	r.evict()
+	r.mu.Unlock()
+	close(e.done)
+	return content
+}
+
+// fetch asks the CMS for the metadata of a video; it returns nil without an
+// error when the CMS doesn't know the video
+func (r *Resolver) fetch(ctx context.Context, tenant, videoID string) (*models.Content, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url(tenant, videoID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range r.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		io.Copy(io.Discard, resp.Body)
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var doc map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	content := models.Content{
+		Title:     text(lookup(doc, r.cfg.Fields.Title)),
+		Duration:  seconds(lookup(doc, r.cfg.Fields.Duration)),
+		Category:  text(lookup(doc, r.cfg.Fields.Category)),
+		Publisher: text(lookup(doc, r.cfg.Fields.Publisher)),
+	}
+	if content == (models.Content{}) {
+		return nil, nil
+	}
+	return &content, nil
+}
+
+// url fills the placeholders of the configured URL in, escaped for the
+// part of the URL they are in
+func (r *Resolver) url(tenant, videoID string) string {
+	path, query, hasQuery := strings.Cut(r.cfg.URL, "?")
+	path = strings.NewReplacer(
+		PlaceholderVideoID, url.PathEscape(videoID),
+		PlaceholderTenant, url.PathEscape(tenant),
+	).Replace(path)
+	if !hasQuery {
+		return path
+	}
+	return path + "?" + strings.NewReplacer(
+		PlaceholderVideoID, url.QueryEscape(videoID),
+		PlaceholderTenant, url.QueryEscape(tenant),
+	).Replace(query)
+}
+
+// evict drops expired videos and, beyond the capacity, the one expiring
+// first. r.mu must be held.
+func (r *Resolver) evict() {
+	if len(r.entries) <= r.cfg.MaxEntries {
+		return
+	}
+	now := r.now()
+	var first string
+	var firstExpires time.Time
+	for key, e := range r.entries {
+		if e.expires.IsZero() {
+			// Still being looked up
+			continue
+		}
+		if !now.Before(e.expires) {
+			delete(r.entries, key)
+			continue
+		}
+		if firstExpires.IsZero() || e.expires.Before(firstExpires) {
+			first, firstExpires = key, e.expires
+		}
+	}
+	if len(r.entries) > r.cfg.MaxEntries && first != "" {
+		delete(r.entries, first)
+	}
+}
+
+// lookup returns the value at the dotted path in doc, or nil
+func lookup(doc map[string]any, path string) any {
+	if path == "" {
+		return nil
+	}
+	var value any = doc
+	for key := range strings.SplitSeq(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// text returns a string value, or the first string of a list such as the
+// categories of a video
+func text(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// seconds returns a finite, non-negative number or numeric string, or 0
+func seconds(value any) float64 {
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case string:
+		f, _ = strconv.ParseFloat(v, 64)
+	}
+	if f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return f
+}
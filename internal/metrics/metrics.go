@@ -0,0 +1,379 @@
+// Package metrics defines the Prometheus metrics exported by the collector
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every metric name
+const Namespace = "esv"
+
+// RateLimited counts requests rejected by the rate limiter, by route, tenant
+// and whether the bucket was keyed on an API client, a remote IP or the
+// tenant as a whole
+var RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "rate_limited_requests_total",
+	Help:      "Requests rejected with 429 by the rate limiter.",
+}, []string{"route", "tenant", "key_type"})
+
+// QuotaRejected counts ingestion requests rejected with 429 because the
+// API client used up its daily event quota, by route and tenant
+var QuotaRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "quota_rejected_requests_total",
+	Help:      "Ingestion requests rejected with 429 because the daily event quota was used up.",
+}, []string{"route", "tenant"})
+
+// QuotaWarnings counts the API clients that went past their soft daily
+// event quota, by tenant
+var QuotaWarnings = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "quota_warnings_total",
+	Help:      "API clients that went past their soft daily event quota.",
+}, []string{"tenant"})
+
+// SignatureRejected counts the requests of signing tenants rejected with
+// 401 because their signature was missing or didn't match, by tenant and
+// reason
+var SignatureRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "signature_rejected_requests_total",
+	Help:      "Requests of signing tenants rejected with 401 for a missing or mismatched signature.",
+}, []string{"tenant", "reason"})
+
+// ShedRequests counts ingestion requests rejected with 503 because the
+// sink's queue was over the load shedding threshold, by route
+var ShedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "shed_requests_total",
+	Help:      "Ingestion requests rejected with 503 while the sink was falling behind.",
+}, []string{"route"})
+
+// DeprecatedRequests counts requests to deprecated API versions, by version
+var DeprecatedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "deprecated_api_requests_total",
+	Help:      "Requests to deprecated versions of the API.",
+}, []string{"version"})
+
+// SinkBacklog is how full the sink's queue was at the last ingestion
+// request, from 0 to 1
+var SinkBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "sink_backlog_ratio",
+	Help:      "Share of the sink's queue in use, as seen by the last ingestion request.",
+})
+
+// SinkOutputBatches counts batches written to each sink of a fanout, by
+// outcome: written, failed (one attempt) or dropped (given up on)
+var SinkOutputBatches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "sink_output_batches_total",
+	Help:      "Batches written to, failed by or dropped for each sink of a fanout.",
+}, []string{"sink", "outcome"})
+
+// SplitBatches counts batches too large for the sink in one write that
+// were written in parts, by tenant
+var SplitBatches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "split_batches_total",
+	Help:      "Batches over the sink's size limits written in several parts.",
+}, []string{"tenant"})
+
+// KinesisRetriedRecords counts records put again after Kinesis or Firehose
+// throttled or failed them, by service: streams or firehose
+var KinesisRetriedRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "kinesis_retried_records_total",
+	Help:      "Records put again after the stream throttled or failed them.",
+}, []string{"service"})
+
+// PubSubDeadLettered counts events published to the Pub/Sub dead letter
+// topic after the topic rejected them
+var PubSubDeadLettered = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "pubsub_dead_lettered_total",
+	Help:      "Events published to the Pub/Sub dead letter topic.",
+})
+
+// MQTTMessages counts messages the MQTT bridge received, by outcome:
+// stored, rejected, unknown_tenant, too_large or failed
+var MQTTMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "mqtt_messages_total",
+	Help:      "Messages received from the MQTT broker, by outcome.",
+}, []string{"outcome"})
+
+// BatchesReceived counts stored batches per tenant
+var BatchesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "batches_received_total",
+	Help:      "Event batches accepted and stored.",
+}, []string{"tenant"})
+
+// EventsReceived counts stored events per tenant
+var EventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "events_received_total",
+	Help:      "Events accepted and stored.",
+}, []string{"tenant"})
+
+// IngestLatency is how long stored batches spent in each stage of
+// ingestion: receive (reading and decoding the request), queue (the
+// processing pipeline), sink (storing or, for sinks writing in the
+// background, queueing the batch) and total
+var IngestLatency = promauto.NewSummaryVec(prometheus.SummaryOpts{
+	Namespace:  Namespace,
+	Name:       "ingest_latency_seconds",
+	Help:       "Time stored batches spent in each stage of ingestion.",
+	Objectives: map[float64]float64{0.5: 0.05, 0.95: 0.01, 0.99: 0.001},
+}, []string{"stage"})
+
+// EventsDuplicate counts events dropped because an event with the same
+// eventId was already stored, per tenant
+var EventsDuplicate = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "duplicate_events_total",
+	Help:      "Events dropped because their eventId was already stored.",
+}, []string{"tenant"})
+
+// EventsRejected counts invalid events left out of otherwise valid batches
+// per tenant
+var EventsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "rejected_events_total",
+	Help:      "Invalid events dropped from batches whose other events were stored.",
+}, []string{"tenant"})
+
+// DeadLettered counts batches moved to the dead letter queue per tenant
+var DeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "dead_lettered_batches_total",
+	Help:      "Batches the sink rejected that were saved to the dead letter queue.",
+}, []string{"tenant"})
+
+// WALPending is the number of batches in the write-ahead log that were not
+// shipped to the sink yet
+var WALPending = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "wal_pending_batches",
+	Help:      "Batches in the write-ahead log not yet shipped to the sink.",
+})
+
+// ChecksumMismatches counts batches rejected because their events didn't
+// match the checksum they were sent with, by tenant
+var ChecksumMismatches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "checksum_mismatches_total",
+	Help:      "Batches whose events were corrupted on the way to the collector.",
+}, []string{"tenant"})
+
+// TaxonomyViolations counts events outside the event taxonomy, by tenant
+// and whether they were rejected or quarantined
+var TaxonomyViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "taxonomy_violations_total",
+	Help:      "Events with an unknown name or missing required fields.",
+}, []string{"tenant", "action"})
+
+// DimensionViolations counts events rejected because their customData
+// didn't match the custom dimensions of their tenant, by tenant
+var DimensionViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "dimension_violations_total",
+	Help:      "Events whose customData has mistyped or, in strict mode, unregistered dimensions.",
+}, []string{"tenant"})
+
+// CacheRequests counts the requests of cached endpoints, by route pattern
+// and whether the response was stored, being computed for another request
+// or computed for this one
+var CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "cache_requests_total",
+	Help:      "Requests of cached aggregation endpoints, by cache outcome.",
+}, []string{"route", "outcome"})
+
+// SampledOut counts events dropped by sampling rules, by tenant and the
+// event name of the rule (empty for catch-all rules)
+var SampledOut = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "sampled_out_events_total",
+	Help:      "Events dropped by server-side sampling rules.",
+}, []string{"tenant", "event"})
+
+// BotEvents counts events the bot filter recognized, by tenant, reason and
+// whether they were flagged or dropped
+var BotEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "bot_events_total",
+	Help:      "Events recognized as sent by bots, headless browsers or datacenter clients.",
+}, []string{"tenant", "reason", "action"})
+
+// TransformedEvents counts events the transformation rules applied to, by
+// tenant and outcome: transformed, or failed when the result didn't fit
+// the event model and the event was kept as sent
+var TransformedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "transformed_events_total",
+	Help:      "Events reshaped by the transformation rules, or kept as sent when that failed.",
+}, []string{"tenant", "outcome"})
+
+// MetadataLookups counts video metadata lookups by outcome: cached, found,
+// missing (the CMS doesn't know the video) or failed
+var MetadataLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "metadata_lookups_total",
+	Help:      "Video metadata lookups, answered from the cache or by the CMS.",
+}, []string{"outcome"})
+
+// IdentityLinks counts anonymous IDs linked to a user ID by tenant and
+// outcome: linked the first time, or relinked to another user, as on a
+// shared device
+var IdentityLinks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "identity_links_total",
+	Help:      "Anonymous IDs linked to the user ID of a viewer who logged in.",
+}, []string{"tenant", "outcome"})
+
+// MergedSessions counts sessions of anonymous viewers merged into the
+// session they continued after logging in
+var MergedSessions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "merged_sessions_total",
+	Help:      "Sessions of anonymous viewers merged into their session after logging in.",
+}, []string{"tenant"})
+
+// ConcurrentViewers is the number of sessions of each tenant that sent a
+// heartbeat within the concurrency window
+var ConcurrentViewers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: Namespace,
+	Name:      "concurrent_viewers",
+	Help:      "Sessions with a recent heartbeat, by tenant.",
+}, []string{"tenant"})
+
+// QoESessions counts ended sessions that tried to play, by outcome: played,
+// exit_before_start or start_failure
+var QoESessions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Subsystem: "qoe",
+	Name:      "sessions_total",
+	Help:      "Ended sessions that attempted playback, by outcome.",
+}, []string{"tenant", "outcome"})
+
+// QoETimeToFirstFrame is the distribution of startup times of played sessions
+var QoETimeToFirstFrame = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: Namespace,
+	Subsystem: "qoe",
+	Name:      "time_to_first_frame_seconds",
+	Help:      "Time from load or play to the first frame.",
+	Buckets:   []float64{0.25, 0.5, 1, 2, 3, 5, 10, 20},
+}, []string{"tenant"})
+
+// QoELiveJoinTime is the distribution of startup times of played sessions of
+// live streams
+var QoELiveJoinTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: Namespace,
+	Subsystem: "qoe",
+	Name:      "live_join_time_seconds",
+	Help:      "Time from load or play to the first frame of live streams.",
+	Buckets:   []float64{0.25, 0.5, 1, 2, 3, 5, 10, 20},
+}, []string{"tenant"})
+
+// QoELiveLatency is the distribution of per-session mean distances from the
+// live edge
+var QoELiveLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: Namespace,
+	Subsystem: "qoe",
+	Name:      "live_latency_seconds",
+	Help:      "Mean distance from the live edge per live session.",
+	Buckets:   []float64{1, 2, 3, 5, 10, 20, 30, 60},
+}, []string{"tenant"})
+
+// QoERebufferRatio is the distribution of per-session rebuffering ratios
+var QoERebufferRatio = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: Namespace,
+	Subsystem: "qoe",
+	Name:      "rebuffer_ratio",
+	Help:      "Share of playback time spent rebuffering per session.",
+	Buckets:   []float64{0, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5},
+}, []string{"tenant"})
+
+// QoEAdEvents counts the ad events of ended sessions by event: start,
+// first_quartile, midpoint, third_quartile, complete or error
+var QoEAdEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Subsystem: "qoe",
+	Name:      "ad_events_total",
+	Help:      "Ad events of ended sessions, by event.",
+}, []string{"tenant", "event"})
+
+// QoEAverageBitrate is the distribution of per-session average bitrates, in
+// the unit reported by the player (usually bits per second)
+var QoEAverageBitrate = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: Namespace,
+	Subsystem: "qoe",
+	Name:      "average_bitrate",
+	Help:      "Watch-time weighted average bitrate per session.",
+	Buckets:   prometheus.ExponentialBuckets(250e3, 2, 8),
+}, []string{"tenant"})
+
+// Alerts counts anomalies detected, by tenant, metric and dimension
+var Alerts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "alerts_total",
+	Help:      "Spikes in error, rebuffer or exit before video start rates that raised an alert.",
+}, []string{"tenant", "metric", "dimension"})
+
+// AlertDeliveryFailures counts alerts a webhook didn't accept after every
+// attempt, by webhook format
+var AlertDeliveryFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "alert_delivery_failures_total",
+	Help:      "Alerts that could not be delivered to a webhook.",
+}, []string{"format"})
+
+// WebhookDeliveries counts deliveries of matching events to webhooks, by
+// webhook and outcome: delivered, failed once every attempt was used up, or
+// dropped because the webhook's queue was full
+var WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "webhook_deliveries_total",
+	Help:      "Batches of matching events posted to webhooks, by outcome.",
+}, []string{"webhook", "outcome"})
+
+// ConfigReloads counts reloads of the configuration on SIGHUP, by outcome:
+// applied, or failed when the new configuration was invalid and the
+// running one was kept
+var ConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "config_reloads_total",
+	Help:      "Reloads of the configuration file, by outcome.",
+}, []string{"outcome"})
+
+// RollupFlushes counts the flushes of metric rollups to their store, by
+// tier and outcome: stored, or failed when the rollups were kept to be
+// retried at the next flush
+var RollupFlushes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "rollup_flushes_total",
+	Help:      "Flushes of metric rollups to their store, by tier and outcome.",
+}, []string{"tier", "outcome"})
+
+// ArchiveFiles counts the event files of the archive, by source and
+// outcome: archived to cold storage, deleted once past their retention, or
+// failed to upload and retried at the next run
+var ArchiveFiles = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: Namespace,
+	Name:      "archive_files_total",
+	Help:      "Event files archived to or deleted from cold storage, by source and outcome.",
+}, []string{"source", "outcome"})
+
+// Handler serves the registered metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
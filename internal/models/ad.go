@@ -0,0 +1,50 @@
+package models
+
+// Standard ad events. Players send them with an AdState describing the ad.
+const (
+	AdStart    = "ad_start"
+	AdQuartile = "ad_quartile"
+	AdComplete = "ad_complete"
+	AdError    = "ad_error"
+)
+
+// Ad break positions
+const (
+	AdPreroll  = "preroll"
+	AdMidroll  = "midroll"
+	AdPostroll = "postroll"
+)
+
+// IsAdEvent reports whether name is one of the standard ad events
+func IsAdEvent(name string) bool {
+	switch name {
+	case AdStart, AdQuartile, AdComplete, AdError:
+		return true
+	}
+	return false
+}
+
+// AdState describes the ad an ad event is about
+type AdState struct {
+	AdID       string `json:"adId"`
+	CreativeID string `json:"creativeId,omitempty"`
+	// Position is the ad break: preroll, midroll or postroll
+	Position string `json:"position,omitempty"`
+	// Quartile is how much of the ad played, 1 to 4 for 25% to 100%, as
+	// reported with ad_quartile
+	Quartile  int  `json:"quartile,omitempty"`
+	Skippable bool `json:"skippable,omitempty"`
+
+	// Extra holds unknown or mistyped keys, see PlaybackState.Extra
+	Extra map[string]interface{} `json:"-"`
+}
+
+type adState AdState
+
+func (a *AdState) UnmarshalJSON(data []byte) error {
+	return decodeLenient(data, (*adState)(a), &a.Extra)
+}
+
+func (a AdState) MarshalJSON() ([]byte, error) {
+	return encodeWithExtra(adState(a), a.Extra)
+}
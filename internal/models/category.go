@@ -0,0 +1,72 @@
+package models
+
+// Event categories. Events without an eventCategory are video events,
+// unless their name is one of the standard events of another category.
+const (
+	CategoryVideo       = "video"
+	CategoryPage        = "page"
+	CategoryInteraction = "interaction"
+)
+
+// Standard non-video events
+const (
+	// PageView is sent when a page is shown, with the page in Context
+	PageView = "page_view"
+	// CTAClick is sent when a call to action is clicked, with the element
+	// in Interaction
+	CTAClick = "cta_click"
+)
+
+// IsCategory reports whether category is one of the event categories
+func IsCategory(category string) bool {
+	switch category {
+	case CategoryVideo, CategoryPage, CategoryInteraction:
+		return true
+	}
+	return false
+}
+
+// CategoryOf is the category events named name belong to when they don't
+// set one
+func CategoryOf(name string) string {
+	switch name {
+	case PageView:
+		return CategoryPage
+	case CTAClick:
+		return CategoryInteraction
+	}
+	return CategoryVideo
+}
+
+// Category is the category of the event, as set by the client or implied
+// by its name
+func (e Event) Category() string {
+	if e.EventCategory != "" {
+		return e.EventCategory
+	}
+	return CategoryOf(e.EventName)
+}
+
+// Interaction describes the element an interaction event is about
+type Interaction struct {
+	// Element identifies the element, e.g. its ID or a data attribute such
+	// as "hero-signup"
+	Element string `json:"element"`
+	// Label is the element's visible text
+	Label string `json:"label,omitempty"`
+	// TargetURL is where the element leads, for links
+	TargetURL string `json:"targetUrl,omitempty"`
+
+	// Extra holds unknown or mistyped keys, see PlaybackState.Extra
+	Extra map[string]interface{} `json:"-"`
+}
+
+type interaction Interaction
+
+func (i *Interaction) UnmarshalJSON(data []byte) error {
+	return decodeLenient(data, (*interaction)(i), &i.Extra)
+}
+
+func (i Interaction) MarshalJSON() ([]byte, error) {
+	return encodeWithExtra(interaction(i), i.Extra)
+}
@@ -0,0 +1,33 @@
+package models
+
+// CDNDelivery is the event synthesized from a CDN access log record of a
+// media segment request. Its Technical carries the CDN and edge POP and its
+// CustomData a Delivery.
+const CDNDelivery = "cdn_delivery"
+
+// Cache statuses of a Delivery, normalized from each CDN's own
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CachePass  = "pass"
+	CacheError = "error"
+)
+
+// Delivery describes how the CDN served one segment request
+type Delivery struct {
+	// RequestID is the CDN's ID of the request
+	RequestID string `json:"requestId,omitempty"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	// Bytes is what the edge sent the viewer, headers included where the
+	// CDN counts them
+	Bytes int64 `json:"bytes"`
+	// CacheStatus is hit, miss, pass or error, or the CDN's own status when
+	// it maps to none of them
+	CacheStatus string `json:"cacheStatus,omitempty"`
+	// TTFB and Duration are the milliseconds until the edge sent the first
+	// byte and the whole response
+	TTFB     float64 `json:"ttfbMs,omitempty"`
+	Duration float64 `json:"durationMs,omitempty"`
+	ClientIP string  `json:"clientIp,omitempty"`
+}
@@ -1,18 +1,86 @@
 package models
 
-import "time"
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// eventIDNamespace derives the IDs AssignEventIDs gives events
+var eventIDNamespace = uuid.MustParse("5f0b7f3e-2a4c-4d7e-9f61-3c9a8e1d2b47")
 
 type Event struct {
-	EventName     string                 `json:"eventName"`
-	VideoID       string                 `json:"videoId"`
-	Timestamp     string                 `json:"timestamp"`
-	SessionID     string                 `json:"sessionId"`
-	UserID        string                 `json:"userId"`
-	AnonymousID   string                 `json:"anonymousId"`
-	PlaybackState map[string]interface{} `json:"playbackState"`
-	Technical     map[string]interface{} `json:"technical"`
-	Context       map[string]interface{} `json:"context"`
-	CustomData    string                 `json:"customData,omitempty"`
+	// EventID identifies the event across retries and replays. Clients
+	// should generate it; the collector assigns one to events without.
+	EventID string `json:"eventId,omitempty"`
+	// SchemaVersion is the version of the event contract the event follows.
+	// Ingest upgrades events to the current version.
+	SchemaVersion int    `json:"schemaVersion,omitempty"`
+	EventName     string `json:"eventName"`
+	// EventCategory is video, page or interaction, see Category
+	EventCategory string         `json:"eventCategory,omitempty"`
+	VideoID       string         `json:"videoId"`
+	Timestamp     string         `json:"timestamp"`
+	SessionID     string         `json:"sessionId"`
+	UserID        string         `json:"userId"`
+	AnonymousID   string         `json:"anonymousId"`
+	PlaybackState *PlaybackState `json:"playbackState,omitempty"`
+	Technical     *Technical     `json:"technical,omitempty"`
+	Context       *Context       `json:"context,omitempty"`
+	// AdState describes the ad of ad events
+	AdState *AdState `json:"adState,omitempty"`
+	// Interaction describes the element of interaction events
+	Interaction *Interaction `json:"interaction,omitempty"`
+	CustomData  string       `json:"customData,omitempty"`
+
+	// ClientTimestamp is the timestamp as reported by the device when the
+	// server corrected Timestamp for clock skew
+	ClientTimestamp string `json:"clientTimestamp,omitempty"`
+
+	// Sampled marks events kept by a server-side sampling rule, which kept
+	// SampleRate of the sessions sending them
+	Sampled    bool    `json:"sampled,omitempty"`
+	SampleRate float64 `json:"sampleRate,omitempty"`
+
+	// IsBot marks events the bot filter took for a crawler's, a headless
+	// browser's or a datacenter client's, for the reason in BotReason
+	IsBot     bool   `json:"isBot,omitempty"`
+	BotReason string `json:"botReason,omitempty"`
+
+	// PlayerError is the classified error of error events, set by the
+	// server's enrichment stage
+	PlayerError *PlayerError `json:"playerError,omitempty"`
+
+	// Content describes the video as the content management system knows
+	// it, set by the server's metadata stage
+	Content *Content `json:"content,omitempty"`
+}
+
+// Content is the metadata of a video, resolved from its VideoID
+type Content struct {
+	Title string `json:"title,omitempty"`
+	// Duration is the length of the video in seconds, zero when the CMS
+	// doesn't know it, e.g. for live streams
+	Duration  float64 `json:"duration,omitempty"`
+	Category  string  `json:"category,omitempty"`
+	Publisher string  `json:"publisher,omitempty"`
+}
+
+// PlayerError is a player error normalized into the collector's error
+// taxonomy. Errors of the same kind share a Fingerprint however their
+// messages differ in URLs, numbers or IDs.
+type PlayerError struct {
+	// Category is one of the playererror categories, such as network or
+	// decode
+	Category string `json:"category"`
+	// Code is the player's code of the error, e.g. MEDIA_ERR_NETWORK,
+	// shaka 1001 or hls.js manifestLoadError
+	Code        string `json:"code,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+	// Fatal is set when the player reported that playback can't go on
+	Fatal bool `json:"fatal,omitempty"`
 }
 
 type EventBatch struct {
@@ -23,6 +91,18 @@ type EventBatch struct {
 	Events    []Event `json:"events"`
 	Timestamp string  `json:"timestamp"`
 	IsRetry   bool    `json:"isRetry,omitempty"`
+	// SchemaVersion is the version of the events that don't name their own
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+
+	// ReceivedAt is the server time the batch arrived, set at ingest
+	ReceivedAt string `json:"receivedAt,omitempty"`
+	// Tenant owns the batch. It is resolved from the API key at ingest and
+	// never taken from the client.
+	Tenant string `json:"tenant,omitempty"`
+	// Part and Parts number the writes a batch too large for the sink was
+	// split into, from 1. Both are zero for batches written whole.
+	Part  int `json:"part,omitempty"`
+	Parts int `json:"parts,omitempty"`
 }
 
 func NewEventBatch(clientID, apiKey, sessionID, batchID string, events []Event) EventBatch {
@@ -35,3 +115,60 @@ func NewEventBatch(clientID, apiKey, sessionID, batchID string, events []Event)
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
 }
+
+// AssignEventIDs gives an ID to the events sent without one. The ID is
+// derived from the tenant, client, batch ID and the event's position, so a
+// retried batch gets the same IDs again; events of batches without a batch
+// ID get random ones. Events is copied before it is changed, since callers
+// may share it.
+func (b *EventBatch) AssignEventIDs() {
+	var events []Event
+	for i, event := range b.Events {
+		if event.EventID != "" {
+			continue
+		}
+		if events == nil {
+			events = append([]Event(nil), b.Events...)
+		}
+		if b.BatchID == "" {
+			events[i].EventID = uuid.NewString()
+		} else {
+			key := b.Tenant + "\x00" + b.ClientID + "\x00" + b.BatchID + "\x00" + strconv.Itoa(i)
+			events[i].EventID = uuid.NewSHA1(eventIDNamespace, []byte(key)).String()
+		}
+	}
+	if events != nil {
+		b.Events = events
+	}
+}
+
+// EventRecord is a single event flattened together with the metadata of the
+// batch it arrived in, so it can be stored and processed on its own
+type EventRecord struct {
+	ClientID       string `json:"clientId"`
+	BatchID        string `json:"batchId"`
+	BatchSessionID string `json:"batchSessionId,omitempty"`
+	BatchTimestamp string `json:"batchTimestamp"`
+	IsRetry        bool   `json:"isRetry,omitempty"`
+	ReceivedAt     string `json:"receivedAt,omitempty"`
+	Tenant         string `json:"tenant,omitempty"`
+	Event
+}
+
+// Records flattens the batch into one record per event
+func (b EventBatch) Records() []EventRecord {
+	records := make([]EventRecord, 0, len(b.Events))
+	for _, event := range b.Events {
+		records = append(records, EventRecord{
+			ClientID:       b.ClientID,
+			BatchID:        b.BatchID,
+			BatchSessionID: b.SessionID,
+			BatchTimestamp: b.Timestamp,
+			IsRetry:        b.IsRetry,
+			ReceivedAt:     b.ReceivedAt,
+			Tenant:         b.Tenant,
+			Event:          event,
+		})
+	}
+	return records
+}
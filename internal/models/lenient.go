@@ -0,0 +1,85 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// decodeLenient decodes a JSON object into the struct pointed to by target
+// field by field. Keys without a matching field, and values that don't fit
+// their field's type, are collected into extra instead of failing the whole
+// event, so odd player payloads are never lost.
+func decodeLenient(data []byte, target any, extra *map[string]interface{}) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	value := reflect.ValueOf(target).Elem()
+	fields := jsonFields(value.Type())
+
+	for key, rawValue := range raw {
+		if index, ok := fields[key]; ok {
+			field := value.Field(index)
+			decoded := reflect.New(field.Type())
+			if err := json.Unmarshal(rawValue, decoded.Interface()); err == nil {
+				field.Set(decoded.Elem())
+				continue
+			}
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(rawValue, &v); err != nil {
+			return err
+		}
+		if *extra == nil {
+			*extra = make(map[string]interface{})
+		}
+		(*extra)[key] = v
+	}
+	return nil
+}
+
+// encodeWithExtra marshals v, which must not implement json.Marshaler
+// itself, and merges the extra keys into the resulting object
+func encodeWithExtra(v any, extra map[string]interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	var merged map[string]interface{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		merged[key] = value
+	}
+	return json.Marshal(merged)
+}
+
+// jsonFields maps JSON keys to struct field indexes
+func jsonFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
@@ -0,0 +1,189 @@
+package models
+
+// PlaybackState is the player state captured with an event
+type PlaybackState struct {
+	CurrentTime  float64 `json:"currentTime"`
+	Duration     float64 `json:"duration"`
+	Paused       bool    `json:"paused"`
+	Ended        bool    `json:"ended"`
+	PlaybackRate float64 `json:"playbackRate"`
+	Volume       float64 `json:"volume"`
+	Muted        bool    `json:"muted"`
+	Fullscreen   bool    `json:"fullscreen"`
+	NetworkState int     `json:"networkState"`
+	ReadyState   int     `json:"readyState"`
+	Bitrate      float64 `json:"bitrate,omitempty"`
+	BufferLength float64 `json:"bufferLength,omitempty"`
+	Quality      string  `json:"quality,omitempty"`
+	// LiveLatency is how far behind the live edge playback is, in seconds,
+	// as reported by players of live streams
+	LiveLatency float64 `json:"liveLatency,omitempty"`
+
+	// Extra holds keys the server doesn't know about and values that
+	// didn't match their field's type
+	Extra map[string]interface{} `json:"-"`
+}
+
+type playbackState PlaybackState
+
+func (p *PlaybackState) UnmarshalJSON(data []byte) error {
+	return decodeLenient(data, (*playbackState)(p), &p.Extra)
+}
+
+func (p PlaybackState) MarshalJSON() ([]byte, error) {
+	return encodeWithExtra(playbackState(p), p.Extra)
+}
+
+// Stream types
+const (
+	StreamLive = "live"
+	StreamVOD  = "vod"
+	StreamDVR  = "dvr"
+)
+
+// IsStreamType reports whether s is one of the stream types
+func IsStreamType(s string) bool {
+	switch s {
+	case StreamLive, StreamVOD, StreamDVR:
+		return true
+	}
+	return false
+}
+
+// IsLiveStream reports whether a stream of type s is live, with or without
+// a seekable window
+func IsLiveStream(s string) bool {
+	return s == StreamLive || s == StreamDVR
+}
+
+// Technical describes the device and player environment
+type Technical struct {
+	UserAgent        string `json:"userAgent"`
+	ScreenResolution string `json:"screenResolution"`
+	ViewportSize     string `json:"viewportSize"`
+	PlayerSize       string `json:"playerSize"`
+	ConnectionType   string `json:"connectionType"`
+	// StreamType is live, vod or dvr (a live stream with a seekable
+	// window). Players may report it; enrichment fills it in otherwise.
+	StreamType string `json:"streamType,omitempty"`
+	// CDN is the CDN that delivered the media and EdgePOP the point of
+	// presence of its edge server. Players may report them; enrichment
+	// derives them from response headers the player echoes otherwise.
+	CDN     string `json:"cdn,omitempty"`
+	EdgePOP string `json:"edgePop,omitempty"`
+	// Player and PlayerVersion name the player library, e.g. video.js
+	// 8.10.0, so errors can be grouped by the version raising them
+	Player        string `json:"player,omitempty"`
+	PlayerVersion string `json:"playerVersion,omitempty"`
+	// Renditions is the quality ladder of the stream, from its DASH or HLS
+	// manifest, lowest bitrate first. Players may report it; enrichment
+	// parses the ladders common players expose otherwise.
+	Renditions []Rendition `json:"renditions,omitempty"`
+
+	// Extra holds unknown or mistyped keys, see PlaybackState.Extra
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Rendition is one video quality of an adaptive stream: a DASH
+// Representation or an HLS variant stream
+type Rendition struct {
+	// Label names the rendition, as the manifest or player does or from
+	// its height, e.g. 720p
+	Label string `json:"label,omitempty"`
+	// Bitrate is the bandwidth the manifest declares, in bits per second
+	Bitrate   float64 `json:"bitrate,omitempty"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	Codecs    string  `json:"codecs,omitempty"`
+	FrameRate float64 `json:"frameRate,omitempty"`
+}
+
+type technical Technical
+
+func (t *Technical) UnmarshalJSON(data []byte) error {
+	return decodeLenient(data, (*technical)(t), &t.Extra)
+}
+
+func (t Technical) MarshalJSON() ([]byte, error) {
+	return encodeWithExtra(technical(t), t.Extra)
+}
+
+// Context describes the page the player is embedded in
+type Context struct {
+	PageURL   string `json:"pageUrl"`
+	Referrer  string `json:"referrer"`
+	PageTitle string `json:"pageTitle"`
+
+	// Geo and Device are filled in by the server's enrichment stage
+	Geo    *Geo    `json:"geo,omitempty"`
+	Device *Device `json:"device,omitempty"`
+
+	// Extra holds unknown or mistyped keys, see PlaybackState.Extra
+	Extra map[string]interface{} `json:"-"`
+}
+
+type eventContext Context
+
+// Geo is where the client IP is located according to the GeoIP databases
+type Geo struct {
+	// Country is the ISO 3166-1 alpha-2 code
+	Country string `json:"country,omitempty"`
+	// Region is the name of the largest subdivision, e.g. a state
+	Region string `json:"region,omitempty"`
+	// RegionCode is the ISO 3166-2 code of Region without the country
+	RegionCode string `json:"regionCode,omitempty"`
+	City       string `json:"city,omitempty"`
+	// ASN is the autonomous system number of the client's network
+	ASN   uint   `json:"asn,omitempty"`
+	ASOrg string `json:"asOrg,omitempty"`
+}
+
+// Device is what the user agent string says about the client
+type Device struct {
+	// Type is desktop, mobile or bot
+	Type           string `json:"type,omitempty"`
+	OS             string `json:"os,omitempty"`
+	OSVersion      string `json:"osVersion,omitempty"`
+	Browser        string `json:"browser,omitempty"`
+	BrowserVersion string `json:"browserVersion,omitempty"`
+}
+
+func (c *Context) UnmarshalJSON(data []byte) error {
+	return decodeLenient(data, (*eventContext)(c), &c.Extra)
+}
+
+func (c Context) MarshalJSON() ([]byte, error) {
+	return encodeWithExtra(eventContext(c), c.Extra)
+}
+
+// CDN returns the CDN delivering the event's media, from Technical or a cdn
+// key of PlaybackState, or ""
+func (e Event) CDN() string {
+	if e.Technical != nil && e.Technical.CDN != "" {
+		return e.Technical.CDN
+	}
+	if e.PlaybackState != nil {
+		if cdn, ok := e.PlaybackState.Extra["cdn"].(string); ok {
+			return cdn
+		}
+	}
+	return ""
+}
+
+// EdgePOP returns the point of presence of the CDN edge server that
+// delivered the event's media, or ""
+func (e Event) EdgePOP() string {
+	if e.Technical != nil {
+		return e.Technical.EdgePOP
+	}
+	return ""
+}
+
+// StreamType returns the stream type of the event's video, or "" when it
+// is unknown
+func (e Event) StreamType() string {
+	if e.Technical != nil {
+		return e.Technical.StreamType
+	}
+	return ""
+}
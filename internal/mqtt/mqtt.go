@@ -0,0 +1,277 @@
+// Package mqtt bridges an MQTT broker into ingestion: smart TVs, set-top
+// boxes and other embedded players publish event batches to the broker,
+// and the bridge subscribes to their topics and hands every message to the
+// same pipeline as POSTed batches
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+)
+
+// ErrRetry is wrapped by Handler errors for batches worth delivering again,
+// such as those the sink failed to store
+var ErrRetry = errors.New("batch can be retried")
+
+// Handler ingests the batch in payload for tenant. tenant is empty when
+// the topic names none. Errors wrapping ErrRetry leave the message
+// unacknowledged; any other error drops it.
+type Handler func(ctx context.Context, tenant string, payload []byte) error
+
+// Config configures the bridge
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Broker is the broker's URL: tcp://, ssl:// or ws://, wss://
+	Broker   string `yaml:"broker"`
+	ClientID string `yaml:"clientId"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Topic is the filter subscribed to. The level matched by its first +
+	// wildcard names the tenant, so devices of tenant acme publish to
+	// esv/acme/events with the default filter. Without a + every message
+	// belongs to the default tenant.
+	Topic string `yaml:"topic"`
+	// Tenants maps topic levels to tenant names, for devices publishing
+	// under another name than their tenant's. When set, messages on levels
+	// it doesn't list are dropped.
+	Tenants map[string]string `yaml:"tenants"`
+	// QoS is the subscription's quality of service: 0, 1 or 2. From 1 on,
+	// messages are only acknowledged once their batch is stored, and the
+	// session outlives reconnects, so the broker delivers again what the
+	// sink failed to store.
+	QoS byte `yaml:"qos"`
+	// MaxPayloadSize drops larger messages undecoded
+	MaxPayloadSize int `yaml:"maxPayloadSize"`
+	// ConnectTimeout bounds the first connection at startup
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+	// InsecureSkipVerify accepts any certificate of an ssl:// or wss://
+	// broker
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		Broker:         "tcp://localhost:1883",
+		ClientID:       "esv-bridge",
+		Topic:          "esv/+/events",
+		QoS:            1,
+		MaxPayloadSize: 1 << 20,
+		ConnectTimeout: 10 * time.Second,
+	}
+}
+
+// Validate reports a topic filter or QoS the broker would refuse
+func (c Config) Validate() error {
+	if c.QoS > 2 {
+		return fmt.Errorf("invalid mqtt qos %d, must be 0, 1 or 2", c.QoS)
+	}
+	levels := strings.Split(c.Topic, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return fmt.Errorf("invalid mqtt topic %q, # must be its last level", c.Topic)
+		case level != "+" && level != "#" && strings.ContainsAny(level, "+#"):
+			return fmt.Errorf("invalid mqtt topic %q, wildcards must fill a whole level", c.Topic)
+		}
+	}
+	return nil
+}
+
+// Bridge subscribes to the device topics and ingests their messages
+type Bridge struct {
+	cfg Config
+	// tenantLevel is the topic level naming the tenant, or -1
+	tenantLevel int
+	client      paho.Client
+
+	handler Handler
+
+	// mu keeps messages from being ingested once Close started, and
+	// inflight tracks those being ingested for Close to wait for
+	mu       sync.Mutex
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+// New builds a bridge from cfg, filling unset fields with the defaults.
+// It connects once Start is called.
+func New(cfg Config) (*Bridge, error) {
+	defaults := DefaultConfig()
+	if cfg.Broker == "" {
+		cfg.Broker = defaults.Broker
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = defaults.ClientID
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = defaults.Topic
+	}
+	if cfg.MaxPayloadSize <= 0 {
+		cfg.MaxPayloadSize = defaults.MaxPayloadSize
+	}
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = defaults.ConnectTimeout
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	b := &Bridge{cfg: cfg, tenantLevel: -1}
+	for i, level := range strings.Split(cfg.Topic, "/") {
+		if level == "+" {
+			b.tenantLevel = i
+			break
+		}
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(cfg.QoS == 0).
+		SetAutoAckDisabled(true).
+		SetOrderMatters(false).
+		SetAutoReconnect(true).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetOnConnectHandler(b.subscribe).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			slog.Warn("Lost connection to MQTT broker, reconnecting", "broker", cfg.Broker, "error", err)
+		})
+	if cfg.InsecureSkipVerify {
+		opts.SetTLSConfig(&tls.Config{InsecureSkipVerify: true})
+	}
+	b.client = paho.NewClient(opts)
+	return b, nil
+}
+
+// Handle sets the handler messages are ingested with. It must be called
+// before Start.
+func (b *Bridge) Handle(handler Handler) {
+	b.handler = handler
+}
+
+// Start connects to the broker and subscribes to the topic, resubscribing
+// after every reconnect
+func (b *Bridge) Start() error {
+	if b.handler == nil {
+		return errors.New("mqtt bridge has no handler")
+	}
+	token := b.client.Connect()
+	if !token.WaitTimeout(b.cfg.ConnectTimeout) {
+		b.client.Disconnect(0)
+		return fmt.Errorf("timed out connecting to MQTT broker %s", b.cfg.Broker)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker %s: %w", b.cfg.Broker, err)
+	}
+	return nil
+}
+
+func (b *Bridge) subscribe(client paho.Client) {
+	token := client.Subscribe(b.cfg.Topic, b.cfg.QoS, b.handleMessage)
+	if token.WaitTimeout(b.cfg.ConnectTimeout) && token.Error() == nil {
+		slog.Info("Subscribed to MQTT topic", "broker", b.cfg.Broker, "topic", b.cfg.Topic, "qos", b.cfg.QoS)
+		return
+	}
+	err := token.Error()
+	if err == nil {
+		err = errors.New("timed out")
+	}
+	// Without the subscription nothing arrives; reconnecting subscribes again
+	slog.Error("Error subscribing to MQTT topic", "topic", b.cfg.Topic, "error", err)
+	client.Disconnect(0)
+}
+
+func (b *Bridge) handleMessage(_ paho.Client, msg paho.Message) {
+	b.mu.Lock()
+	if b.closed {
+		// Left unacknowledged for the session to deliver after a restart
+		b.mu.Unlock()
+		return
+	}
+	b.inflight.Add(1)
+	b.mu.Unlock()
+	defer b.inflight.Done()
+
+	outcome := b.ingest(msg)
+	metrics.MQTTMessages.WithLabelValues(outcome).Inc()
+	if outcome != "failed" {
+		msg.Ack()
+	}
+}
+
+// ingest hands msg to the handler and returns the outcome it's counted
+// under: stored, rejected, unknown_tenant, too_large or failed
+func (b *Bridge) ingest(msg paho.Message) string {
+	tenant, ok := b.tenant(msg.Topic())
+	if !ok {
+		slog.Warn("Dropping MQTT message for unknown tenant", "topic", msg.Topic())
+		return "unknown_tenant"
+	}
+	if len(msg.Payload()) > b.cfg.MaxPayloadSize {
+		slog.Warn("Dropping oversized MQTT message", "topic", msg.Topic(), "bytes", len(msg.Payload()))
+		return "too_large"
+	}
+
+	err := b.handler(context.Background(), tenant, msg.Payload())
+	switch {
+	case err == nil:
+		return "stored"
+	case errors.Is(err, ErrRetry):
+		slog.Error("Error ingesting MQTT message, leaving it for redelivery", "topic", msg.Topic(), "error", err)
+		return "failed"
+	default:
+		slog.Debug("Rejected MQTT message", "topic", msg.Topic(), "error", err)
+		return "rejected"
+	}
+}
+
+// tenant returns the tenant topic names, mapped through the tenants
+// setting, and whether it's one the bridge accepts
+func (b *Bridge) tenant(topic string) (string, bool) {
+	if b.tenantLevel < 0 {
+		return "", true
+	}
+	levels := strings.Split(topic, "/")
+	if b.tenantLevel >= len(levels) {
+		return "", false
+	}
+	level := levels[b.tenantLevel]
+	if len(b.cfg.Tenants) == 0 {
+		return level, level != ""
+	}
+	tenant, ok := b.cfg.Tenants[level]
+	return tenant, ok
+}
+
+// CheckHealth reports whether the bridge is connected to the broker
+func (b *Bridge) CheckHealth(context.Context) error {
+	if !b.client.IsConnectionOpen() {
+		return fmt.Errorf("not connected to MQTT broker %s", b.cfg.Broker)
+	}
+	return nil
+}
+
+// Close stops ingesting, waits for the messages being ingested and
+// disconnects, so no message is ingested after the sink closes. The
+// subscription stays in the broker's session, which keeps the messages
+// published meanwhile at QoS 1 and 2.
+func (b *Bridge) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.inflight.Wait()
+	b.client.Disconnect(250)
+}
@@ -0,0 +1,122 @@
+package eventsv1
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// ToModel converts a protobuf batch into the model used by the pipeline
+func (b *EventBatch) ToModel() models.EventBatch {
+	events := make([]models.Event, 0, len(b.GetEvents()))
+	for _, event := range b.GetEvents() {
+		events = append(events, event.ToModel())
+	}
+
+	return models.EventBatch{
+		ClientID:  b.GetClientId(),
+		APIKey:    b.GetApiKey(),
+		SessionID: b.GetSessionId(),
+		BatchID:   b.GetBatchId(),
+		Events:    events,
+		Timestamp: formatTimestamp(b.GetTimestamp()),
+		IsRetry:   b.GetIsRetry(),
+
+		SchemaVersion: int(b.GetSchemaVersion()),
+	}
+}
+
+// ToModel converts a protobuf event into the model used by the pipeline
+func (e *Event) ToModel() models.Event {
+	event := models.Event{
+		EventID:       e.GetEventId(),
+		SchemaVersion: int(e.GetSchemaVersion()),
+		EventName:     e.GetEventName(),
+		EventCategory: e.GetEventCategory(),
+		VideoID:       e.GetVideoId(),
+		Timestamp:     formatTimestamp(e.GetTimestamp()),
+		SessionID:     e.GetSessionId(),
+		UserID:        e.GetUserId(),
+		AnonymousID:   e.GetAnonymousId(),
+		CustomData:    e.GetCustomData(),
+	}
+
+	if p := e.GetPlaybackState(); p != nil {
+		event.PlaybackState = &models.PlaybackState{
+			CurrentTime:  p.GetCurrentTime(),
+			Duration:     p.GetDuration(),
+			Paused:       p.GetPaused(),
+			Ended:        p.GetEnded(),
+			PlaybackRate: p.GetPlaybackRate(),
+			Volume:       p.GetVolume(),
+			Muted:        p.GetMuted(),
+			Fullscreen:   p.GetFullscreen(),
+			NetworkState: int(p.GetNetworkState()),
+			ReadyState:   int(p.GetReadyState()),
+			Bitrate:      p.GetBitrate(),
+			BufferLength: p.GetBufferLength(),
+			Quality:      p.GetQuality(),
+			LiveLatency:  p.GetLiveLatency(),
+			Extra:        structToMap(p.GetExtra()),
+		}
+	}
+	if t := e.GetTechnical(); t != nil {
+		event.Technical = &models.Technical{
+			UserAgent:        t.GetUserAgent(),
+			ScreenResolution: t.GetScreenResolution(),
+			ViewportSize:     t.GetViewportSize(),
+			PlayerSize:       t.GetPlayerSize(),
+			ConnectionType:   t.GetConnectionType(),
+			StreamType:       t.GetStreamType(),
+			CDN:              t.GetCdn(),
+			EdgePOP:          t.GetEdgePop(),
+			Extra:            structToMap(t.GetExtra()),
+		}
+	}
+	if c := e.GetContext(); c != nil {
+		event.Context = &models.Context{
+			PageURL:   c.GetPageUrl(),
+			Referrer:  c.GetReferrer(),
+			PageTitle: c.GetPageTitle(),
+			Extra:     structToMap(c.GetExtra()),
+		}
+	}
+	if a := e.GetAdState(); a != nil {
+		event.AdState = &models.AdState{
+			AdID:       a.GetAdId(),
+			CreativeID: a.GetCreativeId(),
+			Position:   a.GetPosition(),
+			Quartile:   int(a.GetQuartile()),
+			Skippable:  a.GetSkippable(),
+			Extra:      structToMap(a.GetExtra()),
+		}
+	}
+	if i := e.GetInteraction(); i != nil {
+		event.Interaction = &models.Interaction{
+			Element:   i.GetElement(),
+			Label:     i.GetLabel(),
+			TargetURL: i.GetTargetUrl(),
+			Extra:     structToMap(i.GetExtra()),
+		}
+	}
+	return event
+}
+
+// formatTimestamp renders a protobuf timestamp in the RFC3339 form used by
+// JSON clients. Unset timestamps become empty strings.
+func formatTimestamp(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return ts.AsTime().UTC().Format(time.RFC3339Nano)
+}
+
+func structToMap(s *structpb.Struct) map[string]interface{} {
+	if s == nil || len(s.GetFields()) == 0 {
+		return nil
+	}
+	return s.AsMap()
+}
@@ -0,0 +1,5 @@
+// Package eventsv1 holds the protobuf encoding of event batches and its
+// conversion to the internal models.
+package eventsv1
+
+//go:generate protoc -I ../../../proto --go_out=../../.. --go_opt=module=github.com/adtyap26/event-stream-video events/v1/events.proto
@@ -0,0 +1,923 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: events/v1/events.proto
+
+package eventsv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type EventBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ClientId      string                 `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	ApiKey        string                 `protobuf:"bytes,2,opt,name=api_key,json=apiKey,proto3" json:"api_key,omitempty"`
+	SessionId     string                 `protobuf:"bytes,3,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	BatchId       string                 `protobuf:"bytes,4,opt,name=batch_id,json=batchId,proto3" json:"batch_id,omitempty"`
+	Events        []*Event               `protobuf:"bytes,5,rep,name=events,proto3" json:"events,omitempty"`
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	IsRetry       bool                   `protobuf:"varint,7,opt,name=is_retry,json=isRetry,proto3" json:"is_retry,omitempty"`
+	SchemaVersion int32                  `protobuf:"varint,8,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EventBatch) Reset() {
+	*x = EventBatch{}
+	mi := &file_events_v1_events_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EventBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EventBatch) ProtoMessage() {}
+
+func (x *EventBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_events_v1_events_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EventBatch.ProtoReflect.Descriptor instead.
+func (*EventBatch) Descriptor() ([]byte, []int) {
+	return file_events_v1_events_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *EventBatch) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *EventBatch) GetApiKey() string {
+	if x != nil {
+		return x.ApiKey
+	}
+	return ""
+}
+
+func (x *EventBatch) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *EventBatch) GetBatchId() string {
+	if x != nil {
+		return x.BatchId
+	}
+	return ""
+}
+
+func (x *EventBatch) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+func (x *EventBatch) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *EventBatch) GetIsRetry() bool {
+	if x != nil {
+		return x.IsRetry
+	}
+	return false
+}
+
+func (x *EventBatch) GetSchemaVersion() int32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	EventName     string                 `protobuf:"bytes,1,opt,name=event_name,json=eventName,proto3" json:"event_name,omitempty"`
+	VideoId       string                 `protobuf:"bytes,2,opt,name=video_id,json=videoId,proto3" json:"video_id,omitempty"`
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	SessionId     string                 `protobuf:"bytes,4,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	UserId        string                 `protobuf:"bytes,5,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	AnonymousId   string                 `protobuf:"bytes,6,opt,name=anonymous_id,json=anonymousId,proto3" json:"anonymous_id,omitempty"`
+	PlaybackState *PlaybackState         `protobuf:"bytes,7,opt,name=playback_state,json=playbackState,proto3" json:"playback_state,omitempty"`
+	Technical     *Technical             `protobuf:"bytes,8,opt,name=technical,proto3" json:"technical,omitempty"`
+	Context       *Context               `protobuf:"bytes,9,opt,name=context,proto3" json:"context,omitempty"`
+	CustomData    string                 `protobuf:"bytes,10,opt,name=custom_data,json=customData,proto3" json:"custom_data,omitempty"`
+	EventId       string                 `protobuf:"bytes,11,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	SchemaVersion int32                  `protobuf:"varint,12,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	AdState       *AdState               `protobuf:"bytes,13,opt,name=ad_state,json=adState,proto3" json:"ad_state,omitempty"`
+	EventCategory string                 `protobuf:"bytes,14,opt,name=event_category,json=eventCategory,proto3" json:"event_category,omitempty"`
+	Interaction   *Interaction           `protobuf:"bytes,15,opt,name=interaction,proto3" json:"interaction,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_events_v1_events_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_events_v1_events_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_events_v1_events_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Event) GetEventName() string {
+	if x != nil {
+		return x.EventName
+	}
+	return ""
+}
+
+func (x *Event) GetVideoId() string {
+	if x != nil {
+		return x.VideoId
+	}
+	return ""
+}
+
+func (x *Event) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Event) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *Event) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Event) GetAnonymousId() string {
+	if x != nil {
+		return x.AnonymousId
+	}
+	return ""
+}
+
+func (x *Event) GetPlaybackState() *PlaybackState {
+	if x != nil {
+		return x.PlaybackState
+	}
+	return nil
+}
+
+func (x *Event) GetTechnical() *Technical {
+	if x != nil {
+		return x.Technical
+	}
+	return nil
+}
+
+func (x *Event) GetContext() *Context {
+	if x != nil {
+		return x.Context
+	}
+	return nil
+}
+
+func (x *Event) GetCustomData() string {
+	if x != nil {
+		return x.CustomData
+	}
+	return ""
+}
+
+func (x *Event) GetEventId() string {
+	if x != nil {
+		return x.EventId
+	}
+	return ""
+}
+
+func (x *Event) GetSchemaVersion() int32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+func (x *Event) GetAdState() *AdState {
+	if x != nil {
+		return x.AdState
+	}
+	return nil
+}
+
+func (x *Event) GetEventCategory() string {
+	if x != nil {
+		return x.EventCategory
+	}
+	return ""
+}
+
+func (x *Event) GetInteraction() *Interaction {
+	if x != nil {
+		return x.Interaction
+	}
+	return nil
+}
+
+type PlaybackState struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CurrentTime   float64                `protobuf:"fixed64,1,opt,name=current_time,json=currentTime,proto3" json:"current_time,omitempty"`
+	Duration      float64                `protobuf:"fixed64,2,opt,name=duration,proto3" json:"duration,omitempty"`
+	Paused        bool                   `protobuf:"varint,3,opt,name=paused,proto3" json:"paused,omitempty"`
+	Ended         bool                   `protobuf:"varint,4,opt,name=ended,proto3" json:"ended,omitempty"`
+	PlaybackRate  float64                `protobuf:"fixed64,5,opt,name=playback_rate,json=playbackRate,proto3" json:"playback_rate,omitempty"`
+	Volume        float64                `protobuf:"fixed64,6,opt,name=volume,proto3" json:"volume,omitempty"`
+	Muted         bool                   `protobuf:"varint,7,opt,name=muted,proto3" json:"muted,omitempty"`
+	Fullscreen    bool                   `protobuf:"varint,8,opt,name=fullscreen,proto3" json:"fullscreen,omitempty"`
+	NetworkState  int32                  `protobuf:"varint,9,opt,name=network_state,json=networkState,proto3" json:"network_state,omitempty"`
+	ReadyState    int32                  `protobuf:"varint,10,opt,name=ready_state,json=readyState,proto3" json:"ready_state,omitempty"`
+	Bitrate       float64                `protobuf:"fixed64,11,opt,name=bitrate,proto3" json:"bitrate,omitempty"`
+	BufferLength  float64                `protobuf:"fixed64,12,opt,name=buffer_length,json=bufferLength,proto3" json:"buffer_length,omitempty"`
+	Quality       string                 `protobuf:"bytes,13,opt,name=quality,proto3" json:"quality,omitempty"`
+	LiveLatency   float64                `protobuf:"fixed64,14,opt,name=live_latency,json=liveLatency,proto3" json:"live_latency,omitempty"`
+	Extra         *structpb.Struct       `protobuf:"bytes,15,opt,name=extra,proto3" json:"extra,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PlaybackState) Reset() {
+	*x = PlaybackState{}
+	mi := &file_events_v1_events_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PlaybackState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PlaybackState) ProtoMessage() {}
+
+func (x *PlaybackState) ProtoReflect() protoreflect.Message {
+	mi := &file_events_v1_events_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PlaybackState.ProtoReflect.Descriptor instead.
+func (*PlaybackState) Descriptor() ([]byte, []int) {
+	return file_events_v1_events_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *PlaybackState) GetCurrentTime() float64 {
+	if x != nil {
+		return x.CurrentTime
+	}
+	return 0
+}
+
+func (x *PlaybackState) GetDuration() float64 {
+	if x != nil {
+		return x.Duration
+	}
+	return 0
+}
+
+func (x *PlaybackState) GetPaused() bool {
+	if x != nil {
+		return x.Paused
+	}
+	return false
+}
+
+func (x *PlaybackState) GetEnded() bool {
+	if x != nil {
+		return x.Ended
+	}
+	return false
+}
+
+func (x *PlaybackState) GetPlaybackRate() float64 {
+	if x != nil {
+		return x.PlaybackRate
+	}
+	return 0
+}
+
+func (x *PlaybackState) GetVolume() float64 {
+	if x != nil {
+		return x.Volume
+	}
+	return 0
+}
+
+func (x *PlaybackState) GetMuted() bool {
+	if x != nil {
+		return x.Muted
+	}
+	return false
+}
+
+func (x *PlaybackState) GetFullscreen() bool {
+	if x != nil {
+		return x.Fullscreen
+	}
+	return false
+}
+
+func (x *PlaybackState) GetNetworkState() int32 {
+	if x != nil {
+		return x.NetworkState
+	}
+	return 0
+}
+
+func (x *PlaybackState) GetReadyState() int32 {
+	if x != nil {
+		return x.ReadyState
+	}
+	return 0
+}
+
+func (x *PlaybackState) GetBitrate() float64 {
+	if x != nil {
+		return x.Bitrate
+	}
+	return 0
+}
+
+func (x *PlaybackState) GetBufferLength() float64 {
+	if x != nil {
+		return x.BufferLength
+	}
+	return 0
+}
+
+func (x *PlaybackState) GetQuality() string {
+	if x != nil {
+		return x.Quality
+	}
+	return ""
+}
+
+func (x *PlaybackState) GetLiveLatency() float64 {
+	if x != nil {
+		return x.LiveLatency
+	}
+	return 0
+}
+
+func (x *PlaybackState) GetExtra() *structpb.Struct {
+	if x != nil {
+		return x.Extra
+	}
+	return nil
+}
+
+type Technical struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	UserAgent        string                 `protobuf:"bytes,1,opt,name=user_agent,json=userAgent,proto3" json:"user_agent,omitempty"`
+	ScreenResolution string                 `protobuf:"bytes,2,opt,name=screen_resolution,json=screenResolution,proto3" json:"screen_resolution,omitempty"`
+	ViewportSize     string                 `protobuf:"bytes,3,opt,name=viewport_size,json=viewportSize,proto3" json:"viewport_size,omitempty"`
+	PlayerSize       string                 `protobuf:"bytes,4,opt,name=player_size,json=playerSize,proto3" json:"player_size,omitempty"`
+	ConnectionType   string                 `protobuf:"bytes,5,opt,name=connection_type,json=connectionType,proto3" json:"connection_type,omitempty"`
+	StreamType       string                 `protobuf:"bytes,6,opt,name=stream_type,json=streamType,proto3" json:"stream_type,omitempty"`
+	Cdn              string                 `protobuf:"bytes,7,opt,name=cdn,proto3" json:"cdn,omitempty"`
+	EdgePop          string                 `protobuf:"bytes,8,opt,name=edge_pop,json=edgePop,proto3" json:"edge_pop,omitempty"`
+	Extra            *structpb.Struct       `protobuf:"bytes,15,opt,name=extra,proto3" json:"extra,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Technical) Reset() {
+	*x = Technical{}
+	mi := &file_events_v1_events_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Technical) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Technical) ProtoMessage() {}
+
+func (x *Technical) ProtoReflect() protoreflect.Message {
+	mi := &file_events_v1_events_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Technical.ProtoReflect.Descriptor instead.
+func (*Technical) Descriptor() ([]byte, []int) {
+	return file_events_v1_events_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Technical) GetUserAgent() string {
+	if x != nil {
+		return x.UserAgent
+	}
+	return ""
+}
+
+func (x *Technical) GetScreenResolution() string {
+	if x != nil {
+		return x.ScreenResolution
+	}
+	return ""
+}
+
+func (x *Technical) GetViewportSize() string {
+	if x != nil {
+		return x.ViewportSize
+	}
+	return ""
+}
+
+func (x *Technical) GetPlayerSize() string {
+	if x != nil {
+		return x.PlayerSize
+	}
+	return ""
+}
+
+func (x *Technical) GetConnectionType() string {
+	if x != nil {
+		return x.ConnectionType
+	}
+	return ""
+}
+
+func (x *Technical) GetStreamType() string {
+	if x != nil {
+		return x.StreamType
+	}
+	return ""
+}
+
+func (x *Technical) GetCdn() string {
+	if x != nil {
+		return x.Cdn
+	}
+	return ""
+}
+
+func (x *Technical) GetEdgePop() string {
+	if x != nil {
+		return x.EdgePop
+	}
+	return ""
+}
+
+func (x *Technical) GetExtra() *structpb.Struct {
+	if x != nil {
+		return x.Extra
+	}
+	return nil
+}
+
+type Context struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PageUrl       string                 `protobuf:"bytes,1,opt,name=page_url,json=pageUrl,proto3" json:"page_url,omitempty"`
+	Referrer      string                 `protobuf:"bytes,2,opt,name=referrer,proto3" json:"referrer,omitempty"`
+	PageTitle     string                 `protobuf:"bytes,3,opt,name=page_title,json=pageTitle,proto3" json:"page_title,omitempty"`
+	Extra         *structpb.Struct       `protobuf:"bytes,15,opt,name=extra,proto3" json:"extra,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Context) Reset() {
+	*x = Context{}
+	mi := &file_events_v1_events_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Context) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Context) ProtoMessage() {}
+
+func (x *Context) ProtoReflect() protoreflect.Message {
+	mi := &file_events_v1_events_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Context.ProtoReflect.Descriptor instead.
+func (*Context) Descriptor() ([]byte, []int) {
+	return file_events_v1_events_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Context) GetPageUrl() string {
+	if x != nil {
+		return x.PageUrl
+	}
+	return ""
+}
+
+func (x *Context) GetReferrer() string {
+	if x != nil {
+		return x.Referrer
+	}
+	return ""
+}
+
+func (x *Context) GetPageTitle() string {
+	if x != nil {
+		return x.PageTitle
+	}
+	return ""
+}
+
+func (x *Context) GetExtra() *structpb.Struct {
+	if x != nil {
+		return x.Extra
+	}
+	return nil
+}
+
+type AdState struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AdId          string                 `protobuf:"bytes,1,opt,name=ad_id,json=adId,proto3" json:"ad_id,omitempty"`
+	CreativeId    string                 `protobuf:"bytes,2,opt,name=creative_id,json=creativeId,proto3" json:"creative_id,omitempty"`
+	Position      string                 `protobuf:"bytes,3,opt,name=position,proto3" json:"position,omitempty"`
+	Quartile      int32                  `protobuf:"varint,4,opt,name=quartile,proto3" json:"quartile,omitempty"`
+	Skippable     bool                   `protobuf:"varint,5,opt,name=skippable,proto3" json:"skippable,omitempty"`
+	Extra         *structpb.Struct       `protobuf:"bytes,15,opt,name=extra,proto3" json:"extra,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AdState) Reset() {
+	*x = AdState{}
+	mi := &file_events_v1_events_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AdState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AdState) ProtoMessage() {}
+
+func (x *AdState) ProtoReflect() protoreflect.Message {
+	mi := &file_events_v1_events_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AdState.ProtoReflect.Descriptor instead.
+func (*AdState) Descriptor() ([]byte, []int) {
+	return file_events_v1_events_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *AdState) GetAdId() string {
+	if x != nil {
+		return x.AdId
+	}
+	return ""
+}
+
+func (x *AdState) GetCreativeId() string {
+	if x != nil {
+		return x.CreativeId
+	}
+	return ""
+}
+
+func (x *AdState) GetPosition() string {
+	if x != nil {
+		return x.Position
+	}
+	return ""
+}
+
+func (x *AdState) GetQuartile() int32 {
+	if x != nil {
+		return x.Quartile
+	}
+	return 0
+}
+
+func (x *AdState) GetSkippable() bool {
+	if x != nil {
+		return x.Skippable
+	}
+	return false
+}
+
+func (x *AdState) GetExtra() *structpb.Struct {
+	if x != nil {
+		return x.Extra
+	}
+	return nil
+}
+
+type Interaction struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Element       string                 `protobuf:"bytes,1,opt,name=element,proto3" json:"element,omitempty"`
+	Label         string                 `protobuf:"bytes,2,opt,name=label,proto3" json:"label,omitempty"`
+	TargetUrl     string                 `protobuf:"bytes,3,opt,name=target_url,json=targetUrl,proto3" json:"target_url,omitempty"`
+	Extra         *structpb.Struct       `protobuf:"bytes,15,opt,name=extra,proto3" json:"extra,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Interaction) Reset() {
+	*x = Interaction{}
+	mi := &file_events_v1_events_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Interaction) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Interaction) ProtoMessage() {}
+
+func (x *Interaction) ProtoReflect() protoreflect.Message {
+	mi := &file_events_v1_events_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Interaction.ProtoReflect.Descriptor instead.
+func (*Interaction) Descriptor() ([]byte, []int) {
+	return file_events_v1_events_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Interaction) GetElement() string {
+	if x != nil {
+		return x.Element
+	}
+	return ""
+}
+
+func (x *Interaction) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *Interaction) GetTargetUrl() string {
+	if x != nil {
+		return x.TargetUrl
+	}
+	return ""
+}
+
+func (x *Interaction) GetExtra() *structpb.Struct {
+	if x != nil {
+		return x.Extra
+	}
+	return nil
+}
+
+var File_events_v1_events_proto protoreflect.FileDescriptor
+
+const file_events_v1_events_proto_rawDesc = "" +
+	"\n" +
+	"\x16events/v1/events.proto\x12\resv.events.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa6\x02\n" +
+	"\n" +
+	"EventBatch\x12\x1b\n" +
+	"\tclient_id\x18\x01 \x01(\tR\bclientId\x12\x17\n" +
+	"\aapi_key\x18\x02 \x01(\tR\x06apiKey\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x03 \x01(\tR\tsessionId\x12\x19\n" +
+	"\bbatch_id\x18\x04 \x01(\tR\abatchId\x12,\n" +
+	"\x06events\x18\x05 \x03(\v2\x14.esv.events.v1.EventR\x06events\x128\n" +
+	"\ttimestamp\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x19\n" +
+	"\bis_retry\x18\a \x01(\bR\aisRetry\x12%\n" +
+	"\x0eschema_version\x18\b \x01(\x05R\rschemaVersion\"\x80\x05\n" +
+	"\x05Event\x12\x1d\n" +
+	"\n" +
+	"event_name\x18\x01 \x01(\tR\teventName\x12\x19\n" +
+	"\bvideo_id\x18\x02 \x01(\tR\avideoId\x128\n" +
+	"\ttimestamp\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x04 \x01(\tR\tsessionId\x12\x17\n" +
+	"\auser_id\x18\x05 \x01(\tR\x06userId\x12!\n" +
+	"\fanonymous_id\x18\x06 \x01(\tR\vanonymousId\x12C\n" +
+	"\x0eplayback_state\x18\a \x01(\v2\x1c.esv.events.v1.PlaybackStateR\rplaybackState\x126\n" +
+	"\ttechnical\x18\b \x01(\v2\x18.esv.events.v1.TechnicalR\ttechnical\x120\n" +
+	"\acontext\x18\t \x01(\v2\x16.esv.events.v1.ContextR\acontext\x12\x1f\n" +
+	"\vcustom_data\x18\n" +
+	" \x01(\tR\n" +
+	"customData\x12\x19\n" +
+	"\bevent_id\x18\v \x01(\tR\aeventId\x12%\n" +
+	"\x0eschema_version\x18\f \x01(\x05R\rschemaVersion\x121\n" +
+	"\bad_state\x18\r \x01(\v2\x16.esv.events.v1.AdStateR\aadState\x12%\n" +
+	"\x0eevent_category\x18\x0e \x01(\tR\reventCategory\x12<\n" +
+	"\vinteraction\x18\x0f \x01(\v2\x1a.esv.events.v1.InteractionR\vinteraction\"\xe0\x03\n" +
+	"\rPlaybackState\x12!\n" +
+	"\fcurrent_time\x18\x01 \x01(\x01R\vcurrentTime\x12\x1a\n" +
+	"\bduration\x18\x02 \x01(\x01R\bduration\x12\x16\n" +
+	"\x06paused\x18\x03 \x01(\bR\x06paused\x12\x14\n" +
+	"\x05ended\x18\x04 \x01(\bR\x05ended\x12#\n" +
+	"\rplayback_rate\x18\x05 \x01(\x01R\fplaybackRate\x12\x16\n" +
+	"\x06volume\x18\x06 \x01(\x01R\x06volume\x12\x14\n" +
+	"\x05muted\x18\a \x01(\bR\x05muted\x12\x1e\n" +
+	"\n" +
+	"fullscreen\x18\b \x01(\bR\n" +
+	"fullscreen\x12#\n" +
+	"\rnetwork_state\x18\t \x01(\x05R\fnetworkState\x12\x1f\n" +
+	"\vready_state\x18\n" +
+	" \x01(\x05R\n" +
+	"readyState\x12\x18\n" +
+	"\abitrate\x18\v \x01(\x01R\abitrate\x12#\n" +
+	"\rbuffer_length\x18\f \x01(\x01R\fbufferLength\x12\x18\n" +
+	"\aquality\x18\r \x01(\tR\aquality\x12!\n" +
+	"\flive_latency\x18\x0e \x01(\x01R\vliveLatency\x12-\n" +
+	"\x05extra\x18\x0f \x01(\v2\x17.google.protobuf.StructR\x05extra\"\xc3\x02\n" +
+	"\tTechnical\x12\x1d\n" +
+	"\n" +
+	"user_agent\x18\x01 \x01(\tR\tuserAgent\x12+\n" +
+	"\x11screen_resolution\x18\x02 \x01(\tR\x10screenResolution\x12#\n" +
+	"\rviewport_size\x18\x03 \x01(\tR\fviewportSize\x12\x1f\n" +
+	"\vplayer_size\x18\x04 \x01(\tR\n" +
+	"playerSize\x12'\n" +
+	"\x0fconnection_type\x18\x05 \x01(\tR\x0econnectionType\x12\x1f\n" +
+	"\vstream_type\x18\x06 \x01(\tR\n" +
+	"streamType\x12\x10\n" +
+	"\x03cdn\x18\a \x01(\tR\x03cdn\x12\x19\n" +
+	"\bedge_pop\x18\b \x01(\tR\aedgePop\x12-\n" +
+	"\x05extra\x18\x0f \x01(\v2\x17.google.protobuf.StructR\x05extra\"\x8e\x01\n" +
+	"\aContext\x12\x19\n" +
+	"\bpage_url\x18\x01 \x01(\tR\apageUrl\x12\x1a\n" +
+	"\breferrer\x18\x02 \x01(\tR\breferrer\x12\x1d\n" +
+	"\n" +
+	"page_title\x18\x03 \x01(\tR\tpageTitle\x12-\n" +
+	"\x05extra\x18\x0f \x01(\v2\x17.google.protobuf.StructR\x05extra\"\xc4\x01\n" +
+	"\aAdState\x12\x13\n" +
+	"\x05ad_id\x18\x01 \x01(\tR\x04adId\x12\x1f\n" +
+	"\vcreative_id\x18\x02 \x01(\tR\n" +
+	"creativeId\x12\x1a\n" +
+	"\bposition\x18\x03 \x01(\tR\bposition\x12\x1a\n" +
+	"\bquartile\x18\x04 \x01(\x05R\bquartile\x12\x1c\n" +
+	"\tskippable\x18\x05 \x01(\bR\tskippable\x12-\n" +
+	"\x05extra\x18\x0f \x01(\v2\x17.google.protobuf.StructR\x05extra\"\x8b\x01\n" +
+	"\vInteraction\x12\x18\n" +
+	"\aelement\x18\x01 \x01(\tR\aelement\x12\x14\n" +
+	"\x05label\x18\x02 \x01(\tR\x05label\x12\x1d\n" +
+	"\n" +
+	"target_url\x18\x03 \x01(\tR\ttargetUrl\x12-\n" +
+	"\x05extra\x18\x0f \x01(\v2\x17.google.protobuf.StructR\x05extraB=Z;github.com/adtyap26/event-stream-video/internal/pb/eventsv1b\x06proto3"
+
+var (
+	file_events_v1_events_proto_rawDescOnce sync.Once
+	file_events_v1_events_proto_rawDescData []byte
+)
+
+func file_events_v1_events_proto_rawDescGZIP() []byte {
+	file_events_v1_events_proto_rawDescOnce.Do(func() {
+		file_events_v1_events_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_events_v1_events_proto_rawDesc), len(file_events_v1_events_proto_rawDesc)))
+	})
+	return file_events_v1_events_proto_rawDescData
+}
+
+var file_events_v1_events_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_events_v1_events_proto_goTypes = []any{
+	(*EventBatch)(nil),            // 0: esv.events.v1.EventBatch
+	(*Event)(nil),                 // 1: esv.events.v1.Event
+	(*PlaybackState)(nil),         // 2: esv.events.v1.PlaybackState
+	(*Technical)(nil),             // 3: esv.events.v1.Technical
+	(*Context)(nil),               // 4: esv.events.v1.Context
+	(*AdState)(nil),               // 5: esv.events.v1.AdState
+	(*Interaction)(nil),           // 6: esv.events.v1.Interaction
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+	(*structpb.Struct)(nil),       // 8: google.protobuf.Struct
+}
+var file_events_v1_events_proto_depIdxs = []int32{
+	1,  // 0: esv.events.v1.EventBatch.events:type_name -> esv.events.v1.Event
+	7,  // 1: esv.events.v1.EventBatch.timestamp:type_name -> google.protobuf.Timestamp
+	7,  // 2: esv.events.v1.Event.timestamp:type_name -> google.protobuf.Timestamp
+	2,  // 3: esv.events.v1.Event.playback_state:type_name -> esv.events.v1.PlaybackState
+	3,  // 4: esv.events.v1.Event.technical:type_name -> esv.events.v1.Technical
+	4,  // 5: esv.events.v1.Event.context:type_name -> esv.events.v1.Context
+	5,  // 6: esv.events.v1.Event.ad_state:type_name -> esv.events.v1.AdState
+	6,  // 7: esv.events.v1.Event.interaction:type_name -> esv.events.v1.Interaction
+	8,  // 8: esv.events.v1.PlaybackState.extra:type_name -> google.protobuf.Struct
+	8,  // 9: esv.events.v1.Technical.extra:type_name -> google.protobuf.Struct
+	8,  // 10: esv.events.v1.Context.extra:type_name -> google.protobuf.Struct
+	8,  // 11: esv.events.v1.AdState.extra:type_name -> google.protobuf.Struct
+	8,  // 12: esv.events.v1.Interaction.extra:type_name -> google.protobuf.Struct
+	13, // [13:13] is the sub-list for method output_type
+	13, // [13:13] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_events_v1_events_proto_init() }
+func file_events_v1_events_proto_init() {
+	if File_events_v1_events_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_events_v1_events_proto_rawDesc), len(file_events_v1_events_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_events_v1_events_proto_goTypes,
+		DependencyIndexes: file_events_v1_events_proto_depIdxs,
+		MessageInfos:      file_events_v1_events_proto_msgTypes,
+	}.Build()
+	File_events_v1_events_proto = out.File
+	file_events_v1_events_proto_goTypes = nil
+	file_events_v1_events_proto_depIdxs = nil
+}
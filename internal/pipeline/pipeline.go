@@ -0,0 +1,163 @@
+// Package pipeline runs event batches through a configurable chain of
+// processors between the ingestion handlers and the sink
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/tracing"
+)
+
+// Processor names accepted in the pipeline section of the config
+const (
+	// Transform renames, coerces and derives fields by the transformation
+	// rules
+	Transform = "transform"
+	// Timestamps normalizes client timestamps and corrects clock skew
+	Timestamps = "timestamps"
+	// Validate rejects batches that are too large or malformed
+	Validate = "validate"
+	// Dedup acknowledges replayed batches without storing them again and
+	// drops events whose eventId was already stored
+	Dedup = "dedup"
+	// Enrich adds GeoIP and device information
+	Enrich = "enrich"
+	// Bots flags or drops the events of crawlers, headless browsers and
+	// datacenter clients
+	Bots = "bots"
+	// Metadata adds the title, duration, category and publisher of videos
+	// from the CMS
+	Metadata = "metadata"
+	// Scrub hashes or drops personal data
+	Scrub = "scrub"
+	// Identities links the anonymous and user IDs of stored events that
+	// carry both
+	Identities = "identities"
+	// Sessions feeds the session tracker once the batch is stored
+	Sessions = "sessions"
+	// Sample drops events according to the sampling rules
+	Sample = "sample"
+	// Stream publishes stored events to the live feed
+	Stream = "stream"
+	// Webhooks posts stored events to the webhooks whose filter they match
+	Webhooks = "webhooks"
+)
+
+// Names lists every processor name, in the default order
+var Names = []string{Transform, Timestamps, Validate, Dedup, Enrich, Bots, Metadata, Scrub, Identities, Sessions, Sample, Stream, Webhooks}
+
+// DefaultOrder returns the processors run when the config doesn't say.
+// Transform runs first, so the others see events in the shape of the
+// model. Bots runs after enrichment, whose ASN it reads, and metadata
+// after bots, so the videos of dropped events aren't looked up. Scrub runs
+// before anything that keeps or publishes events, so identities links IDs
+// as they are stored, and sessions before sampling so sampled-out events
+// still count towards session metrics.
+func DefaultOrder() []string {
+	return slices.Clone(Names)
+}
+
+// ValidateOrder reports unknown or repeated processor names
+func ValidateOrder(names []string) error {
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !slices.Contains(Names, name) {
+			return fmt.Errorf("unknown pipeline processor %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("pipeline processor %q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// Processor transforms a batch on its way to the sink. It may change the
+// batch in place, drop events from it or reject it with an error, which
+// stops the pipeline.
+type Processor interface {
+	Process(ctx context.Context, batch *models.EventBatch) error
+}
+
+// ProcessorFunc adapts a function to the Processor interface
+type ProcessorFunc func(ctx context.Context, batch *models.EventBatch) error
+
+func (f ProcessorFunc) Process(ctx context.Context, batch *models.EventBatch) error {
+	return f(ctx, batch)
+}
+
+// Finisher is implemented by processors that need to know how the batch
+// ended, e.g. to undo a side effect when it could not be stored
+type Finisher interface {
+	Processor
+	// Finish receives the batch as the processor left it and the error the
+	// pipeline or the sink failed with, nil once the batch was stored
+	Finish(ctx context.Context, batch models.EventBatch, err error)
+}
+
+// Stage is a processor with the name it was configured under
+type Stage struct {
+	Name      string
+	Processor Processor
+}
+
+// Pipeline runs its stages in order
+type Pipeline struct {
+	stages []Stage
+}
+
+func New(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Stages returns the names of the stages in the order they run
+func (p *Pipeline) Stages() []string {
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.Name
+	}
+	return names
+}
+
+// finishing is a finisher waiting for the outcome, with its view of the batch
+type finishing struct {
+	finisher Finisher
+	batch    models.EventBatch
+}
+
+// Run passes batch through every stage and then to store. Stages that are
+// Finishers are told the outcome afterwards, last stage first.
+func (p *Pipeline) Run(ctx context.Context, batch *models.EventBatch, store func(models.EventBatch) error) (err error) {
+	var pending []finishing
+	defer func() {
+		for _, f := range slices.Backward(pending) {
+			f.finisher.Finish(ctx, f.batch, err)
+		}
+	}()
+
+	for _, stage := range p.stages {
+		// A client that went away or a server that stopped draining ends
+		// the batch between stages
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Each stage gets a span, so slow batches show which one held them up
+		stageCtx, span := tracing.Start(ctx, "pipeline."+stage.Name)
+		stageErr := stage.Processor.Process(stageCtx, batch)
+		span.SetAttributes(tracing.AttrEvents.Int(len(batch.Events)))
+		tracing.End(span, stageErr)
+		if stageErr != nil {
+			return stageErr
+		}
+		if finisher, ok := stage.Processor.(Finisher); ok {
+			// Later stages may drop or rewrite events, keep this stage's view
+			snapshot := *batch
+			snapshot.Events = slices.Clone(batch.Events)
+			pending = append(pending, finishing{finisher: finisher, batch: snapshot})
+		}
+	}
+	return store(*batch)
+}
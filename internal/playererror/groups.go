@@ -0,0 +1,348 @@
+package playererror
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+const (
+	// DefaultRetention is how far back error occurrences are kept
+	DefaultRetention = 24 * time.Hour
+	// DefaultMaxGroups caps how many error groups are kept across tenants;
+	// the least recently seen are forgotten first
+	DefaultMaxGroups = 10000
+	// groupBucket is the resolution of occurrence counts
+	groupBucket = time.Hour
+	// maxBreakdowns caps the videos and player versions counted per group
+	// and bucket. Occurrences past it are counted under no video or
+	// version, so totals stay right while filters miss them.
+	maxBreakdowns = 256
+	// maxSessions caps the sessions remembered per group to count each
+	// once; sessions past it are no longer counted
+	maxSessions = 10000
+	// topBreakdowns is how many videos and player versions Top lists per
+	// group
+	topBreakdowns = 5
+)
+
+// Filter narrows occurrences down to a video and a player version. Empty
+// fields match everything.
+type Filter struct {
+	VideoID       string
+	Player        string
+	PlayerVersion string
+}
+
+// Breakdown is how often an error group occurred for one video or player
+// version
+type Breakdown struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Group is an error, as identified by its fingerprint, and its occurrences
+// over a window
+type Group struct {
+	Fingerprint string `json:"fingerprint"`
+	Category    string `json:"category"`
+	Code        string `json:"code,omitempty"`
+	// Message is the message of the group's first occurrence
+	Message   string    `json:"message,omitempty"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	Count int `json:"count"`
+	// Fatal counts occurrences that stopped playback
+	Fatal int `json:"fatal"`
+	// Sessions counts the sessions that first hit the error in the window
+	Sessions int `json:"sessions"`
+
+	// Videos and PlayerVersions are the ones the error occurred for most,
+	// most first. Player versions read "video.js 8.10.0".
+	Videos         []Breakdown `json:"videos"`
+	PlayerVersions []Breakdown `json:"playerVersions"`
+}
+
+// occurrences are the counts of a group for one video and player version
+// in one bucket
+type occurrences struct {
+	count    int
+	fatal    int
+	sessions int
+}
+
+type breakdownKey struct {
+	videoID       string
+	player        string
+	playerVersion string
+}
+
+func (k breakdownKey) matches(f Filter) bool {
+	return (f.VideoID == "" || f.VideoID == k.videoID) &&
+		(f.Player == "" || f.Player == k.player) &&
+		(f.PlayerVersion == "" || f.PlayerVersion == k.playerVersion)
+}
+
+func (k breakdownKey) version() string {
+	switch {
+	case k.player == "":
+		return k.playerVersion
+	case k.playerVersion == "":
+		return k.player
+	}
+	return k.player + " " + k.playerVersion
+}
+
+type groupKey struct {
+	tenant      string
+	fingerprint string
+}
+
+// group is the history of one error group
+type group struct {
+	category  string
+	code      string
+	message   string
+	firstSeen time.Time
+	lastSeen  time.Time
+	// buckets holds the occurrences by the start of their bucket
+	buckets  map[int64]map[breakdownKey]*occurrences
+	sessions map[string]struct{}
+}
+
+// Groups counts the classified errors of stored events by fingerprint,
+// together with the videos and player versions they occurred for, like
+// the issue grouping of error trackers. It is safe for concurrent use.
+type Groups struct {
+	mu        sync.Mutex
+	groups    map[groupKey]*group
+	retention time.Duration
+	maxGroups int
+	now       func() time.Time
+}
+
+// NewGroups keeps retention of occurrences of up to maxGroups groups. Zero
+// values select the defaults.
+func NewGroups(retention time.Duration, maxGroups int) *Groups {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if maxGroups <= 0 {
+		maxGroups = DefaultMaxGroups
+	}
+	return &Groups{
+		groups:    make(map[groupKey]*group),
+		retention: retention,
+		maxGroups: maxGroups,
+		now:       time.Now,
+	}
+}
+
+// Retention is the longest window Top and Get answer
+func (g *Groups) Retention() time.Duration {
+	return g.retention
+}
+
+// Record counts the classified errors of a stored batch
+func (g *Groups) Record(batch models.EventBatch) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	start := now.Truncate(groupBucket).Unix()
+	touched := make(map[*group]struct{})
+	for _, event := range batch.Events {
+		e := event.PlayerError
+		if e == nil {
+			continue
+		}
+		grp := g.group(groupKey{tenant: batch.Tenant, fingerprint: e.Fingerprint}, *e, now)
+		grp.lastSeen = now
+		touched[grp] = struct{}{}
+
+		key := breakdownKey{videoID: event.VideoID}
+		if event.Technical != nil {
+			key.player, key.playerVersion = event.Technical.Player, event.Technical.PlayerVersion
+		}
+		bucket, ok := grp.buckets[start]
+		if !ok {
+			bucket = make(map[breakdownKey]*occurrences)
+			grp.buckets[start] = bucket
+		}
+		counts, ok := bucket[key]
+		if !ok {
+			if len(bucket) >= maxBreakdowns {
+				key = breakdownKey{}
+			}
+			if counts, ok = bucket[key]; !ok {
+				counts = &occurrences{}
+				bucket[key] = counts
+			}
+		}
+		counts.count++
+		if e.Fatal {
+			counts.fatal++
+		}
+		if _, seen := grp.sessions[event.SessionID]; !seen && event.SessionID != "" && len(grp.sessions) < maxSessions {
+			grp.sessions[event.SessionID] = struct{}{}
+			counts.sessions++
+		}
+	}
+
+	// Drop buckets that fell out of the retention period
+	expired := g.expired(now)
+	for grp := range touched {
+		for start := range grp.buckets {
+			if start < expired {
+				delete(grp.buckets, start)
+			}
+		}
+	}
+}
+
+// group returns the group of key, creating it from e. g.mu must be held.
+func (g *Groups) group(key groupKey, e models.PlayerError, now time.Time) *group {
+	grp, ok := g.groups[key]
+	if ok {
+		return grp
+	}
+	if len(g.groups) >= g.maxGroups {
+		g.forgetOldest()
+	}
+	grp = &group{
+		category:  e.Category,
+		code:      e.Code,
+		message:   e.Message,
+		firstSeen: now,
+		buckets:   make(map[int64]map[breakdownKey]*occurrences),
+		sessions:  make(map[string]struct{}),
+	}
+	g.groups[key] = grp
+	return grp
+}
+
+// forgetOldest drops the least recently seen group. g.mu must be held.
+func (g *Groups) forgetOldest() {
+	var oldest groupKey
+	var oldestAt time.Time
+	for key, grp := range g.groups {
+		if oldestAt.IsZero() || grp.lastSeen.Before(oldestAt) {
+			oldest, oldestAt = key, grp.lastSeen
+		}
+	}
+	delete(g.groups, oldest)
+}
+
+// expired is the start of the newest bucket past the retention period
+func (g *Groups) expired(now time.Time) int64 {
+	return now.Add(-g.retention - groupBucket).Unix()
+}
+
+// Top returns up to limit groups of tenant that occurred the most matching
+// filter over the window ending now, rounded out to whole hours and capped
+// at the retention period
+func (g *Groups) Top(tenant string, filter Filter, window time.Duration, limit int) []Group {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	groups := []Group{}
+	cutoff := g.now().Add(-g.retention - groupBucket)
+	for key, grp := range g.groups {
+		if grp.lastSeen.Before(cutoff) {
+			// Nothing of it is left to report
+			delete(g.groups, key)
+			continue
+		}
+		if key.tenant != tenant {
+			continue
+		}
+		if summary, ok := g.summarize(key, grp, filter, window, topBreakdowns); ok {
+			groups = append(groups, summary)
+		}
+	}
+	slices.SortFunc(groups, func(a, b Group) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), b.LastSeen.Compare(a.LastSeen), cmp.Compare(a.Fingerprint, b.Fingerprint))
+	})
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+	return groups
+}
+
+// Get returns the group of tenant with fingerprint, with every video and
+// player version it occurred for matching filter over the window. It
+// reports false when the group has no such occurrences.
+func (g *Groups) Get(tenant, fingerprint string, filter Filter, window time.Duration) (Group, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := groupKey{tenant: tenant, fingerprint: fingerprint}
+	grp, ok := g.groups[key]
+	if !ok {
+		return Group{}, false
+	}
+	return g.summarize(key, grp, filter, window, maxBreakdowns)
+}
+
+// summarize totals the occurrences of grp matching filter over the window,
+// listing up to breakdowns videos and player versions. g.mu must be held.
+func (g *Groups) summarize(key groupKey, grp *group, filter Filter, window time.Duration, breakdowns int) (Group, bool) {
+	now := g.now()
+	first := now.Add(-min(window, g.retention)).Truncate(groupBucket).Unix()
+	expired := g.expired(now)
+
+	summary := Group{
+		Fingerprint: key.fingerprint,
+		Category:    grp.category,
+		Code:        grp.code,
+		Message:     grp.message,
+		FirstSeen:   grp.firstSeen,
+		LastSeen:    grp.lastSeen,
+	}
+	videos := make(map[string]int)
+	versions := make(map[string]int)
+	for start, bucket := range grp.buckets {
+		if start < first || start < expired {
+			continue
+		}
+		for k, counts := range bucket {
+			if !k.matches(filter) {
+				continue
+			}
+			summary.Count += counts.count
+			summary.Fatal += counts.fatal
+			summary.Sessions += counts.sessions
+			if k.videoID != "" {
+				videos[k.videoID] += counts.count
+			}
+			if version := k.version(); version != "" {
+				versions[version] += counts.count
+			}
+		}
+	}
+	if summary.Count == 0 {
+		return Group{}, false
+	}
+	summary.Videos = top(videos, breakdowns)
+	summary.PlayerVersions = top(versions, breakdowns)
+	return summary, true
+}
+
+// top returns up to limit of counts, most first
+func top(counts map[string]int, limit int) []Breakdown {
+	breakdowns := make([]Breakdown, 0, len(counts))
+	for value, count := range counts {
+		breakdowns = append(breakdowns, Breakdown{Value: value, Count: count})
+	}
+	slices.SortFunc(breakdowns, func(a, b Breakdown) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Value, b.Value))
+	})
+	if len(breakdowns) > limit {
+		breakdowns = breakdowns[:limit]
+	}
+	return breakdowns
+}
@@ -0,0 +1,313 @@
+// Package playererror normalizes the error events of different players,
+// HTML5 MediaError codes, Shaka errors and hls.js error data, into one
+// error taxonomy, and fingerprints them so that occurrences of the same
+// error group together however their messages differ
+package playererror
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// Error categories
+const (
+	// Network is a failure to fetch media, e.g. a segment answered with 404
+	Network = "network"
+	// Manifest is a DASH or HLS manifest that couldn't be loaded or parsed
+	Manifest = "manifest"
+	// Decode is media the browser failed to decode
+	Decode = "decode"
+	// Media is a failure to buffer, demux or append media, or of text tracks
+	Media = "media"
+	// SourceNotSupported is media the browser can't play at all
+	SourceNotSupported = "source_not_supported"
+	// DRM is a failure to get or use a content decryption key
+	DRM = "drm"
+	// Aborted is playback the viewer or the page cut short
+	Aborted = "aborted"
+	// Ads is a failure of the ad integration of the player
+	Ads = "ads"
+	// Player is an error of the player itself, such as a bad configuration
+	Player = "player"
+	// Unknown is an error nothing above fits
+	Unknown = "unknown"
+)
+
+// EventName is the name of the events Classify reads errors from
+const EventName = "error"
+
+// maxMessageLength caps the message kept of an error
+const maxMessageLength = 1024
+
+// mediaErrors are the HTML5 MediaError codes, with the one Video.js adds
+// for encrypted media
+var mediaErrors = map[int]struct{ code, category string }{
+	1: {"MEDIA_ERR_ABORTED", Aborted},
+	2: {"MEDIA_ERR_NETWORK", Network},
+	3: {"MEDIA_ERR_DECODE", Decode},
+	4: {"MEDIA_ERR_SRC_NOT_SUPPORTED", SourceNotSupported},
+	5: {"MEDIA_ERR_ENCRYPTED", DRM},
+}
+
+// shakaCategories maps the thousands of Shaka error codes, which are its
+// error categories, to the taxonomy
+var shakaCategories = map[int]string{
+	1:  Network,
+	2:  Media, // TEXT
+	3:  Media,
+	4:  Manifest,
+	5:  Media, // STREAMING
+	6:  DRM,
+	7:  Player,
+	8:  Player, // CAST
+	9:  Player, // STORAGE
+	10: Ads,
+}
+
+// hlsTypes maps the error types of hls.js to the taxonomy
+var hlsTypes = map[string]string{
+	"networkError":   Network,
+	"mediaError":     Media,
+	"muxError":       Media,
+	"keySystemError": DRM,
+	"otherError":     Player,
+}
+
+// shakaPattern matches the string form of Shaka errors, e.g.
+// "Shaka Error NETWORK.BAD_HTTP_STATUS (...)" or "Shaka Error 1001"
+var shakaPattern = regexp.MustCompile(`(?i)shaka error ([A-Z_]+\.[A-Z_]+|\d{4,5})`)
+
+// Classify normalizes the error reported by an error event, read from
+// playbackState.error, technical.error or customData, as a string or an
+// object with fields such as code, message, type and details. It reports
+// false for events of other names.
+func Classify(event models.Event) (models.PlayerError, bool) {
+	if event.EventName != EventName {
+		return models.PlayerError{}, false
+	}
+	fields := reported(event)
+
+	var e models.PlayerError
+	message := firstString(fields, "message", "msg", "reason")
+	switch {
+	case classifyHLS(&e, fields):
+	case classifyShaka(&e, fields, message):
+	case classifyMediaError(&e, fields):
+	default:
+		e.Code = firstString(fields, "code", "name")
+		e.Category = categoryOf(message + " " + e.Code)
+	}
+	if fatal, ok := fields["fatal"].(bool); ok {
+		e.Fatal = fatal
+	}
+	if message == "" {
+		message = firstString(fields, "details")
+	}
+	if len(message) > maxMessageLength {
+		message = message[:maxMessageLength]
+	}
+	e.Message = message
+	e.Fingerprint = Fingerprint(e.Category, e.Code, message)
+	return e, true
+}
+
+// reported returns the error fields of event. A plain string is taken as
+// the message.
+func reported(event models.Event) map[string]interface{} {
+	var candidates []interface{}
+	if event.PlaybackState != nil {
+		candidates = append(candidates, event.PlaybackState.Extra["error"])
+	}
+	if event.Technical != nil {
+		candidates = append(candidates, event.Technical.Extra["error"])
+	}
+	if event.CustomData != "" {
+		var object map[string]interface{}
+		if json.Unmarshal([]byte(event.CustomData), &object) == nil {
+			if nested, ok := object["error"].(map[string]interface{}); ok {
+				object = nested
+			}
+			candidates = append(candidates, object)
+		} else {
+			candidates = append(candidates, event.CustomData)
+		}
+	}
+
+	for _, candidate := range candidates {
+		switch value := candidate.(type) {
+		case map[string]interface{}:
+			if len(value) > 0 {
+				return value
+			}
+		case string:
+			if value != "" {
+				return map[string]interface{}{"message": value}
+			}
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// classifyHLS recognizes hls.js error data by its type and details
+func classifyHLS(e *models.PlayerError, fields map[string]interface{}) bool {
+	errorType, _ := fields["type"].(string)
+	details, _ := fields["details"].(string)
+	category, ok := hlsTypes[errorType]
+	if !ok {
+		return false
+	}
+	e.Category = category
+	e.Code = "hls." + errorType
+	if details != "" {
+		e.Code = "hls." + details
+		// Playlists are loaded over the network, but a broken one is the
+		// packager's problem rather than the viewer's connection
+		lower := strings.ToLower(details)
+		if strings.HasPrefix(lower, "manifest") || strings.HasPrefix(lower, "level") {
+			e.Category = Manifest
+		}
+	}
+	return true
+}
+
+// classifyShaka recognizes Shaka errors by their numeric code, which is
+// between 1000 and 10999, or by their string form in message
+func classifyShaka(e *models.PlayerError, fields map[string]interface{}, message string) bool {
+	code, ok := number(fields["code"])
+	if ok && code >= 1000 && code < 11000 {
+		if category, ok := shakaCategories[code/1000]; ok {
+			e.Category = category
+			e.Code = "shaka." + strconv.Itoa(code)
+			if severity, ok := number(fields["severity"]); ok {
+				// Shaka's CRITICAL severity stops playback
+				e.Fatal = severity == 2
+			}
+			return true
+		}
+	}
+
+	match := shakaPattern.FindStringSubmatch(message)
+	if match == nil {
+		return false
+	}
+	e.Code = "shaka." + match[1]
+	if code, err := strconv.Atoi(match[1]); err == nil {
+		e.Category = shakaCategories[code/1000]
+	} else {
+		category, _, _ := strings.Cut(match[1], ".")
+		e.Category = categoryOf(strings.ToLower(category))
+	}
+	if e.Category == "" {
+		e.Category = Unknown
+	}
+	return true
+}
+
+// classifyMediaError recognizes HTML5 MediaError codes, as numbers or by
+// their names
+func classifyMediaError(e *models.PlayerError, fields map[string]interface{}) bool {
+	if code, ok := number(fields["code"]); ok {
+		if known, ok := mediaErrors[code]; ok {
+			e.Code, e.Category = known.code, known.category
+			return true
+		}
+	}
+	name := strings.ToUpper(firstString(fields, "code", "name"))
+	for _, known := range mediaErrors {
+		if name == known.code {
+			e.Code, e.Category = known.code, known.category
+			return true
+		}
+	}
+	return false
+}
+
+// categoryKeywords guess the category of errors without a known code from
+// their message, in order
+var categoryKeywords = []struct {
+	category string
+	words    []string
+}{
+	{DRM, []string{"drm", "license", "key system", "keysystem", "eme", "widevine", "fairplay", "playready", "decrypt"}},
+	{Manifest, []string{"manifest", "playlist", "m3u8", "mpd"}},
+	{SourceNotSupported, []string{"not supported", "no compatible source", "unsupported"}},
+	{Decode, []string{"decode", "codec"}},
+	{Network, []string{"network", "timeout", "timed out", "xhr", "http", "cors", "fetch", "offline"}},
+	{Media, []string{"buffer", "append", "demux", "media", "text"}},
+	{Aborted, []string{"abort"}},
+	{Ads, []string{"ads", "vast", "vpaid", "ima"}},
+}
+
+func categoryOf(text string) string {
+	text = strings.ToLower(text)
+	for _, keywords := range categoryKeywords {
+		for _, word := range keywords.words {
+			if strings.Contains(text, word) {
+				return keywords.category
+			}
+		}
+	}
+	return Unknown
+}
+
+var (
+	urlPattern    = regexp.MustCompile(`[a-z][a-z0-9+.-]*://\S+`)
+	idPattern     = regexp.MustCompile(`\b[0-9a-f]{8}(?:-[0-9a-f]{4}){3}-[0-9a-f]{12}\b|\b[0-9a-f]{8,}\b`)
+	numberPattern = regexp.MustCompile(`\d+(?:\.\d+)?`)
+	spacePattern  = regexp.MustCompile(`\s+`)
+)
+
+// Normalize strips what varies between occurrences of the same error from
+// message: URLs, hex IDs and numbers become placeholders
+func Normalize(message string) string {
+	message = strings.ToLower(message)
+	message = urlPattern.ReplaceAllString(message, "<url>")
+	message = idPattern.ReplaceAllString(message, "<id>")
+	message = numberPattern.ReplaceAllString(message, "<n>")
+	return strings.TrimSpace(spacePattern.ReplaceAllString(message, " "))
+}
+
+// Fingerprint identifies an error by its category, code and normalized
+// message
+func Fingerprint(category, code, message string) string {
+	sum := sha1.Sum([]byte(category + "\x00" + code + "\x00" + Normalize(message)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// number reads an integer JSON number or numeric string
+func number(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), true
+		}
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// firstString returns the first non-empty string among keys of fields.
+// Numbers are formatted, so numeric codes of unknown players are kept.
+func firstString(fields map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch v := fields[key].(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				return v
+			}
+		case float64:
+			return fmt.Sprint(v)
+		}
+	}
+	return ""
+}
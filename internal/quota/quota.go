@@ -0,0 +1,386 @@
+// Package quota counts the events each API client ingests per UTC day and
+// checks the counts against daily quotas
+package quota
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRetention is how many days of usage are kept, today included
+const DefaultRetention = 31
+
+// DefaultSaveInterval is how often usage is written to the usage file
+const DefaultSaveInterval = time.Minute
+
+// dayLayout formats the UTC days usage is counted by
+const dayLayout = time.DateOnly
+
+// Limit is a daily event quota. Zero leaves a level unlimited.
+type Limit struct {
+	// Soft is how many events a client may send per day before it is warned
+	Soft int64 `json:"soft" yaml:"soft"`
+	// Hard is how many events a client may send per day before its requests
+	// are rejected
+	Hard int64 `json:"hard" yaml:"hard"`
+}
+
+// Client identifies the API client usage is counted for
+type Client struct {
+	Tenant   string
+	ClientID string
+}
+
+// State is where a client stands against its quota
+type State string
+
+const (
+	StateOK       State = "ok"
+	StateWarning  State = "warning"
+	StateExceeded State = "exceeded"
+)
+
+// Status is a client's usage of the current day against its limit
+type Status struct {
+	Used  int64
+	Limit Limit
+	State State
+	// ResetsAt is the end of the day, when usage starts over
+	ResetsAt time.Time
+}
+
+// Remaining is how many events the client may still send today before the
+// hard limit, or -1 without one
+func (s Status) Remaining() int64 {
+	if s.Limit.Hard <= 0 {
+		return -1
+	}
+	return max(0, s.Limit.Hard-s.Used)
+}
+
+// Usage is how many events one client ingested on one day
+type Usage struct {
+	Day      string `json:"day"`
+	ClientID string `json:"clientId"`
+	Events   int64  `json:"events"`
+}
+
+// Tracker counts ingested events per client and UTC day. Counts of the
+// last retention days are kept, and saved to a file when one is given.
+type Tracker struct {
+	mu        sync.Mutex
+	limit     Limit
+	tenants   map[string]Limit
+	days      map[string]map[Client]int64
+	retention int
+	path      string
+	dirty     bool
+	now       func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a Tracker enforcing limit on every client, keeping retention
+// days of usage. When path is set, the usage saved there is loaded and the
+// usage is saved back every DefaultSaveInterval and on Close.
+func New(limit Limit, retention int, path string) (*Tracker, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	t := &Tracker{
+		limit:     limit,
+		tenants:   make(map[string]Limit),
+		days:      make(map[string]map[Client]int64),
+		retention: retention,
+		path:      path,
+		now:       time.Now,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if path != "" {
+		if err := t.load(); err != nil {
+			return nil, err
+		}
+	}
+	go t.run()
+	return t, nil
+}
+
+// SetTenantLimit replaces the limit for the clients of tenant
+func (t *Tracker) SetTenantLimit(tenant string, limit Limit) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tenants[tenant] = limit
+}
+
+// Limit returns the limit the clients of tenant are held to
+func (t *Tracker) Limit(tenant string) Limit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.limitFor(tenant)
+}
+
+func (t *Tracker) limitFor(tenant string) Limit {
+	if limit, ok := t.tenants[tenant]; ok {
+		return limit
+	}
+	return t.limit
+}
+
+// Check returns the client's usage of today against its limit
+func (t *Tracker) Check(client Client) Status {
+	now := t.now().UTC()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status(client, t.days[now.Format(dayLayout)][client], now)
+}
+
+// Add counts events ingested by client today. It returns the client's new
+// status and whether these events took it past its soft limit.
+func (t *Tracker) Add(client Client, events int64) (Status, bool) {
+	now := t.now().UTC()
+	day := now.Format(dayLayout)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts, ok := t.days[day]
+	if !ok {
+		counts = make(map[Client]int64)
+		t.days[day] = counts
+		t.prune(now)
+	}
+	before := counts[client]
+	counts[client] = before + events
+	if events > 0 {
+		t.dirty = true
+	}
+
+	status := t.status(client, counts[client], now)
+	soft := status.Limit.Soft
+	return status, soft > 0 && before <= soft && counts[client] > soft
+}
+
+func (t *Tracker) status(client Client, used int64, now time.Time) Status {
+	status := Status{
+		Used:     used,
+		Limit:    t.limitFor(client.Tenant),
+		State:    StateOK,
+		ResetsAt: endOfDay(now),
+	}
+	switch {
+	case status.Limit.Hard > 0 && used >= status.Limit.Hard:
+		status.State = StateExceeded
+	case status.Limit.Soft > 0 && used > status.Limit.Soft:
+		status.State = StateWarning
+	}
+	return status
+}
+
+// Usage returns the daily usage of the clients of tenant, latest day first
+func (t *Tracker) Usage(tenant string) []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var usage []Usage
+	for day, counts := range t.days {
+		for client, events := range counts {
+			if client.Tenant == tenant {
+				usage = append(usage, Usage{Day: day, ClientID: client.ClientID, Events: events})
+			}
+		}
+	}
+	slices.SortFunc(usage, func(a, b Usage) int {
+		return cmp.Or(strings.Compare(b.Day, a.Day), strings.Compare(a.ClientID, b.ClientID))
+	})
+	return usage
+}
+
+// Report is a tenant's usage against its quota
+type Report struct {
+	Tenant   string    `json:"tenant"`
+	Day      string    `json:"day"`
+	ResetsAt time.Time `json:"resetsAt"`
+	Limit    Limit     `json:"limit"`
+	// Clients is the usage of each client today, most events first
+	Clients []ClientUsage `json:"clients"`
+	// History is the usage of the earlier retained days, latest first
+	History []Usage `json:"history"`
+}
+
+// ClientUsage is a client's usage of today
+type ClientUsage struct {
+	ClientID string `json:"clientId"`
+	Events   int64  `json:"events"`
+	// Remaining is left out without a hard limit
+	Remaining *int64 `json:"remaining,omitempty"`
+	State     State  `json:"state"`
+}
+
+// Report returns the usage of the clients of tenant against its limit
+func (t *Tracker) Report(tenant string) Report {
+	now := t.now().UTC()
+	today := now.Format(dayLayout)
+	report := Report{
+		Tenant:   tenant,
+		Day:      today,
+		ResetsAt: endOfDay(now),
+		Limit:    t.Limit(tenant),
+		Clients:  []ClientUsage{},
+		History:  []Usage{},
+	}
+
+	for _, usage := range t.Usage(tenant) {
+		if usage.Day != today {
+			report.History = append(report.History, usage)
+			continue
+		}
+		t.mu.Lock()
+		status := t.status(Client{Tenant: tenant, ClientID: usage.ClientID}, usage.Events, now)
+		t.mu.Unlock()
+		client := ClientUsage{ClientID: usage.ClientID, Events: usage.Events, State: status.State}
+		if remaining := status.Remaining(); remaining >= 0 {
+			client.Remaining = &remaining
+		}
+		report.Clients = append(report.Clients, client)
+	}
+	slices.SortStableFunc(report.Clients, func(a, b ClientUsage) int {
+		return cmp.Compare(b.Events, a.Events)
+	})
+	return report
+}
+
+// prune drops the days that fell out of the retention window
+func (t *Tracker) prune(now time.Time) {
+	oldest := now.AddDate(0, 0, 1-t.retention).Format(dayLayout)
+	for day := range t.days {
+		if day < oldest {
+			delete(t.days, day)
+		}
+	}
+}
+
+// endOfDay returns the UTC midnight after now
+func endOfDay(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
+
+// Close stops the save loop and saves the usage a last time
+func (t *Tracker) Close() error {
+	close(t.stop)
+	<-t.done
+	return t.save()
+}
+
+func (t *Tracker) run() {
+	defer close(t.done)
+	if t.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(DefaultSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			if err := t.save(); err != nil {
+				slog.Error("Error saving quota usage", "error", err)
+			}
+		}
+	}
+}
+
+// usageFile is the on-disk format of the usage file
+type usageFile struct {
+	Days map[string][]usageEntry `json:"days"`
+}
+
+type usageEntry struct {
+	Tenant   string `json:"tenant"`
+	ClientID string `json:"clientId"`
+	Events   int64  `json:"events"`
+}
+
+func (t *Tracker) load() error {
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read quota usage file: %w", err)
+	}
+	var file usageFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse quota usage file: %w", err)
+	}
+	for day, entries := range file.Days {
+		counts := make(map[Client]int64, len(entries))
+		for _, entry := range entries {
+			counts[Client{Tenant: entry.Tenant, ClientID: entry.ClientID}] = entry.Events
+		}
+		t.days[day] = counts
+	}
+	t.prune(t.now().UTC())
+	return nil
+}
+
+// save writes the usage to the usage file if it changed since the last save
+func (t *Tracker) save() error {
+	t.mu.Lock()
+	if t.path == "" || !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	file := usageFile{Days: make(map[string][]usageEntry, len(t.days))}
+	for day, counts := range t.days {
+		entries := make([]usageEntry, 0, len(counts))
+		for client, events := range counts {
+			entries = append(entries, usageEntry{Tenant: client.Tenant, ClientID: client.ClientID, Events: events})
+		}
+		slices.SortFunc(entries, func(a, b usageEntry) int {
+			return cmp.Or(strings.Compare(a.Tenant, b.Tenant), strings.Compare(a.ClientID, b.ClientID))
+		})
+		file.Days[day] = entries
+	}
+	t.dirty = false
+	t.mu.Unlock()
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write a temporary file and rename it so a crash never leaves a
+	// truncated usage file behind
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		t.markDirty()
+		return fmt.Errorf("failed to write quota usage file: %w", err)
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		os.Remove(tmp)
+		t.markDirty()
+		return fmt.Errorf("failed to write quota usage file: %w", err)
+	}
+	return nil
+}
+
+// markDirty makes the next save retry one that failed
+func (t *Tracker) markDirty() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dirty = true
+}
@@ -0,0 +1,239 @@
+// Package ratelimit implements keyed token-bucket rate limiting
+package ratelimit
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultIdleTimeout is how long an unused bucket is kept before it is dropped
+const DefaultIdleTimeout = 10 * time.Minute
+
+// Limiter keeps one token bucket per key. Each bucket refills at a fixed
+// rate and holds at most burst tokens; every request takes one token.
+// Tenants can additionally be given a shared bucket of their own.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	limit   rate.Limit
+	burst   int
+	tenants map[string]tenantLimit
+	idle    time.Duration
+	now     func() time.Time
+	shared  Shared
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+type tenantLimit struct {
+	limit rate.Limit
+	burst int
+}
+
+// New creates a Limiter allowing requestsPerSecond sustained requests per key
+// with bursts of up to burst requests, and starts its cleanup loop
+func New(requestsPerSecond float64, burst int) *Limiter {
+	if burst <= 0 {
+		burst = max(1, int(requestsPerSecond))
+	}
+
+	l := &Limiter{
+		buckets: make(map[string]*bucket),
+		limit:   rate.Limit(requestsPerSecond),
+		burst:   burst,
+		tenants: make(map[string]tenantLimit),
+		idle:    DefaultIdleTimeout,
+		now:     time.Now,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Shared keeps token buckets for the collectors of a deployment, so limits
+// hold for the deployment instead of for each collector
+type Shared interface {
+	// Take takes a token from the bucket of key, which refills at limit
+	// tokens per second and holds at most burst. When the bucket is empty
+	// it reports false and how long until a token is available.
+	Take(key string, limit float64, burst int) (bool, time.Duration, error)
+}
+
+// ShareBuckets takes tokens from shared buckets instead of local ones. It
+// must be called before the limiter is used. While shared fails, requests
+// are limited by the local buckets.
+func (l *Limiter) ShareBuckets(shared Shared) {
+	l.shared = shared
+}
+
+// Rate is a sustained request rate and the burst allowed on top of it
+type Rate struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+}
+
+// Rate returns the per-key rate
+func (l *Limiter) Rate() Rate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Rate{RequestsPerSecond: float64(l.limit), Burst: l.burst}
+}
+
+// SetRate changes the per-key rate, including for keys that already have
+// a bucket
+func (l *Limiter) SetRate(requestsPerSecond float64, burst int) {
+	if burst <= 0 {
+		burst = max(1, int(requestsPerSecond))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = rate.Limit(requestsPerSecond)
+	l.burst = burst
+	for key, b := range l.buckets {
+		if !strings.HasPrefix(key, tenantPrefix) {
+			b.limiter.SetLimit(l.limit)
+			b.limiter.SetBurst(burst)
+		}
+	}
+}
+
+// TenantRates returns the limits set with SetTenantLimit
+func (l *Limiter) TenantRates() map[string]Rate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rates := make(map[string]Rate, len(l.tenants))
+	for tenant, limit := range l.tenants {
+		rates[tenant] = Rate{RequestsPerSecond: float64(limit.limit), Burst: limit.burst}
+	}
+	return rates
+}
+
+// SetTenantLimit caps the combined request rate of all clients of tenant
+func (l *Limiter) SetTenantLimit(tenant string, requestsPerSecond float64, burst int) {
+	if burst <= 0 {
+		burst = max(1, int(requestsPerSecond))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tenants[tenant] = tenantLimit{limit: rate.Limit(requestsPerSecond), burst: burst}
+	if b, ok := l.buckets[tenantKey(tenant)]; ok {
+		b.limiter.SetLimit(rate.Limit(requestsPerSecond))
+		b.limiter.SetBurst(burst)
+	}
+}
+
+// RemoveTenantLimit lifts the shared limit of tenant
+func (l *Limiter) RemoveTenantLimit(tenant string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.tenants, tenant)
+	delete(l.buckets, tenantKey(tenant))
+}
+
+// Allow takes a token from the bucket for key. When the bucket is empty it
+// reports false and how long the caller should wait before retrying.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	limit, burst := l.limit, l.burst
+	l.mu.Unlock()
+	return l.take(key, limit, burst)
+}
+
+// AllowTenant takes a token from the tenant's shared bucket. Tenants without
+// a limit of their own are always allowed.
+func (l *Limiter) AllowTenant(tenant string) (bool, time.Duration) {
+	l.mu.Lock()
+	limit, ok := l.tenants[tenant]
+	l.mu.Unlock()
+	if !ok {
+		return true, 0
+	}
+	return l.take(tenantKey(tenant), limit.limit, limit.burst)
+}
+
+// tenantPrefix keeps tenant buckets apart from per-client buckets
+const tenantPrefix = "tenant\x00"
+
+func tenantKey(tenant string) string {
+	return tenantPrefix + tenant
+}
+
+func (l *Limiter) take(key string, limit rate.Limit, burst int) (bool, time.Duration) {
+	if l.shared != nil {
+		allowed, retryAfter, err := l.shared.Take(key, float64(limit), burst)
+		if err == nil {
+			return allowed, retryAfter
+		}
+		slog.Error("Error taking a shared rate limit token, limiting locally", "error", err)
+	}
+
+	now := l.now()
+
+	l.mu.Lock()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(limit, burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	l.mu.Unlock()
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// Give the token back, the request is rejected rather than delayed
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// Close stops the cleanup loop
+func (l *Limiter) Close() {
+	close(l.stop)
+	<-l.done
+}
+
+func (l *Limiter) run() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.idle)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.expire(l.now())
+		}
+	}
+}
+
+// expire drops buckets that have not been used for the idle timeout
+func (l *Limiter) expire(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= l.idle {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package regions points players at the regional collector nearest to
+// them, by the country GeoIP places them in, so beacons don't cross oceans
+package regions
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTTL is how long players may keep a routing hint
+const DefaultTTL = time.Hour
+
+// Config configures region routing
+type Config struct {
+	// Self is the region of this collector
+	Self string `yaml:"self"`
+	// Default is the region of clients in none of the regions' countries,
+	// or whose location is unknown
+	Default string `yaml:"default"`
+	// Collectors are the regional collectors, including this one
+	Collectors []Collector `yaml:"collectors"`
+	// TTL is how long players may cache a hint before asking again
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// Collector is the collector of a region
+type Collector struct {
+	Region string `yaml:"region"`
+	// URL is the collector's base URL, e.g. https://eu.collect.example.com
+	URL string `yaml:"url"`
+	// Countries are the ISO 3166-1 alpha-2 codes of the countries it serves
+	Countries []string `yaml:"countries"`
+}
+
+// DefaultConfig keeps hints for an hour; routing needs collectors to be
+// configured
+func DefaultConfig() Config {
+	return Config{TTL: DefaultTTL}
+}
+
+// Validate checks that the regions have URLs, that no country is served
+// twice, and that Self and Default name configured regions
+func (c Config) Validate() error {
+	if len(c.Collectors) == 0 {
+		return nil
+	}
+	regions := make(map[string]bool, len(c.Collectors))
+	countries := make(map[string]string)
+	for i, collector := range c.Collectors {
+		if collector.Region == "" {
+			return fmt.Errorf("regions.collectors[%d]: region is required", i)
+		}
+		if regions[collector.Region] {
+			return fmt.Errorf("region %s is configured twice", collector.Region)
+		}
+		regions[collector.Region] = true
+		u, err := url.Parse(collector.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("region %s: invalid collector URL %q", collector.Region, collector.URL)
+		}
+		for _, country := range collector.Countries {
+			if len(country) != 2 {
+				return fmt.Errorf("region %s: invalid country code %q", collector.Region, country)
+			}
+			country = strings.ToUpper(country)
+			if other, ok := countries[country]; ok {
+				return fmt.Errorf("country %s is served by both region %s and region %s", country, other, collector.Region)
+			}
+			countries[country] = collector.Region
+		}
+	}
+	if c.Default == "" {
+		return errors.New("regions.default is required with collectors")
+	}
+	if !regions[c.Default] {
+		return fmt.Errorf("default region %s is not configured", c.Default)
+	}
+	if c.Self != "" && !regions[c.Self] {
+		return fmt.Errorf("region %s of this collector is not configured", c.Self)
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("invalid regions ttl %v", c.TTL)
+	}
+	return nil
+}
+
+// Hint tells a player which collector to send its events to
+type Hint struct {
+	Region string `json:"region"`
+	URL    string `json:"url"`
+	// EventsURL is where the region's collector ingests batches
+	EventsURL string `json:"eventsUrl"`
+	// Country is where the client was located, empty when unknown
+	Country string `json:"country,omitempty"`
+	// Current is whether the client already talks to the preferred
+	// collector
+	Current bool `json:"current"`
+	// TTL is how many seconds the hint may be cached for
+	TTL int `json:"ttl"`
+}
+
+// Router picks the collector of a client's country. It is safe for
+// concurrent use.
+type Router struct {
+	self      string
+	def       Collector
+	byCountry map[string]Collector
+	ttl       time.Duration
+}
+
+// New creates a router from cfg, or returns nil when no collectors are
+// configured
+func New(cfg Config) (*Router, error) {
+	if len(cfg.Collectors) == 0 {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = DefaultTTL
+	}
+
+	r := &Router{self: cfg.Self, byCountry: make(map[string]Collector), ttl: cfg.TTL}
+	for _, collector := range cfg.Collectors {
+		collector.URL = strings.TrimSuffix(collector.URL, "/")
+		if collector.Region == cfg.Default {
+			r.def = collector
+		}
+		for _, country := range collector.Countries {
+			r.byCountry[strings.ToUpper(country)] = collector
+		}
+	}
+	return r, nil
+}
+
+// Route returns the hint for a client in country, which is empty when its
+// location is unknown
+func (r *Router) Route(country string) Hint {
+	collector, ok := r.byCountry[strings.ToUpper(country)]
+	if !ok {
+		collector = r.def
+	}
+	return Hint{
+		Region:    collector.Region,
+		URL:       collector.URL,
+		EventsURL: collector.URL + "/api/v1/events",
+		Country:   country,
+		Current:   collector.Region == r.self,
+		TTL:       int(r.ttl / time.Second),
+	}
+}
+
+// TTL is how long hints may be cached
+func (r *Router) TTL() time.Duration {
+	return r.ttl
+}
@@ -0,0 +1,303 @@
+// Package replay reads archived event logs and writes their batches to a
+// sink, e.g. to backfill a new ClickHouse table from file logs. It backs
+// cmd/replay and the replay command of cmd/server.
+package replay
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/time/rate"
+
+	"github.com/adtyap26/event-stream-video/internal/archive"
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/config"
+	"github.com/adtyap26/event-stream-video/internal/encryption"
+	"github.com/adtyap26/event-stream-video/internal/logger"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/sink/split"
+)
+
+// ErrRestoring is returned by Run when archived files were skipped until
+// their restore from cold storage completes
+var ErrRestoring = errors.New("archived files are being restored from cold storage")
+
+// Options select what is replayed and how
+type Options struct {
+	// SinkType replays into this sink instead of the configured ones
+	SinkType string
+	// EventsPerSecond caps the events written per second; 0 is unlimited
+	EventsPerSecond float64
+	// Tenant is the tenant of batches that don't record one (text logs)
+	Tenant string
+	// Archive replays the logs archived to cold storage first
+	Archive bool
+	// DryRun reads and counts batches without writing them
+	DryRun bool
+	// Paths are log files or directories; the configured logger directory
+	// is replayed without any
+	Paths []string
+}
+
+// RegisterFlags adds the flags of o to fs
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.SinkType, "sink", "", "sink type to replay into, overriding the config")
+	fs.Float64Var(&o.EventsPerSecond, "rate", 0, "maximum events written per second, 0 for no limit")
+	fs.StringVar(&o.Tenant, "tenant", "", "tenant for batches that don't record one (text logs)")
+	fs.BoolVar(&o.Archive, "archive", false, "also replay the logs archived to cold storage, before local ones")
+	fs.BoolVar(&o.DryRun, "dry-run", false, "read and count batches without writing them")
+}
+
+// Result counts what a replay read
+type Result struct {
+	Batches int
+	Events  int
+	Files   int
+	// Restoring counts archived files skipped until their restore
+	// completes
+	Restoring int
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("replayed %d batches, %d events from %d files", r.Batches, r.Events, r.Files)
+}
+
+// Run replays the logs opts selects with the sinks, keys and archive of
+// cfg. Batches are written as they were logged; the sink is responsible for
+// skipping ones it already holds. It returns ErrRestoring when archived
+// files are left to replay once their restore completes.
+func Run(ctx context.Context, cfg config.Config, opts Options) (Result, error) {
+	if opts.SinkType != "" {
+		// Only into that sink, not the ones the collector fans out to
+		cfg.Sink.Type = opts.SinkType
+		cfg.Sink.Fanout = nil
+		if err := cfg.Validate(); err != nil {
+			return Result{}, fmt.Errorf("invalid sink: %w", err)
+		}
+	}
+
+	paths := opts.Paths
+	if len(paths) == 0 {
+		paths = []string{cfg.Logger.Dir}
+	}
+	files, err := LogFiles(paths)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to list log files: %w", err)
+	}
+
+	var store archive.Store
+	var archived []archive.Entry
+	if opts.Archive {
+		store, err = cfg.NewArchiveStore()
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to connect to the archive: %w", err)
+		}
+		manifest, err := archive.LoadManifest(ctx, store, cfg.Archive.Prefix)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to read the archive manifest: %w", err)
+		}
+		archived = manifest.Of(archive.SourceLogs)
+	}
+
+	r := &replayer{tenant: opts.Tenant, dryRun: opts.DryRun}
+	r.keys, err = cfg.NewKeyring()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to load log encryption keys: %w", err)
+	}
+	if opts.Tenant == "" && cfg.Tenancy.Enabled {
+		r.tenant = auth.DefaultTenant
+	}
+	if opts.EventsPerSecond > 0 {
+		burst := max(int(opts.EventsPerSecond), 1)
+		r.limiter = rate.NewLimiter(rate.Limit(opts.EventsPerSecond), burst)
+	}
+	if !opts.DryRun {
+		r.sink, err = cfg.NewSink()
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to create event sink: %w", err)
+		}
+		r.sink = split.Wrap(r.sink, cfg.Sink.SplitLimits())
+	}
+
+	err = r.replayArchived(ctx, store, archived)
+	if err == nil {
+		err = r.replayFiles(ctx, files)
+	}
+	if r.sink != nil {
+		if closeErr := closeSink(r.sink); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close event sink: %w", closeErr)
+		}
+	}
+
+	result := Result{
+		Batches:   r.batches,
+		Events:    r.events,
+		Files:     len(archived) - r.restoring + len(files),
+		Restoring: r.restoring,
+	}
+	if err == nil && r.restoring > 0 {
+		err = ErrRestoring
+	}
+	return result, err
+}
+
+// LogFiles expands directories in paths to the rotated log files in them
+func LogFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		rotated, err := logger.RotatedFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, rotated...)
+	}
+	return files, nil
+}
+
+// replayer writes batches read from log files to a sink
+type replayer struct {
+	sink    sink.EventSink
+	keys    *encryption.Keyring
+	limiter *rate.Limiter
+	tenant  string
+	dryRun  bool
+
+	batches int
+	events  int
+	// restoring counts archived files skipped until their restore completes
+	restoring int
+}
+
+// replayArchived replays archived log files, each downloaded to a
+// temporary file under its own name, which says how it is read
+func (r *replayer) replayArchived(ctx context.Context, store archive.Store, entries []archive.Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	dir, err := os.MkdirTemp("", "replay-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	for _, entry := range entries {
+		file := filepath.Join(dir, path.Base(entry.Path))
+		err := download(ctx, store, entry.Key, file)
+		if errors.Is(err, archive.ErrRestoring) {
+			slog.Warn("Archived file is being restored from cold storage, skipping it", "key", entry.Key)
+			r.restoring++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", entry.Key, err)
+		}
+
+		batches, events := r.batches, r.events
+		err = logger.ReadFileWithKeys(file, r.keys, func(batch models.EventBatch) error {
+			return r.replay(ctx, batch)
+		})
+		os.Remove(file)
+		if err != nil {
+			return fmt.Errorf("archived file %s: %w", entry.Key, err)
+		}
+		slog.Info("Replayed archived file", "key", entry.Key, "batches", r.batches-batches, "events", r.events-events)
+	}
+	return nil
+}
+
+// download copies the object at key to file
+func download(ctx context.Context, store archive.Store, key, file string) error {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (r *replayer) replayFiles(ctx context.Context, files []string) error {
+	for _, file := range files {
+		batches, events := r.batches, r.events
+		err := logger.ReadFileWithKeys(file, r.keys, func(batch models.EventBatch) error {
+			return r.replay(ctx, batch)
+		})
+		if err != nil {
+			return err
+		}
+		slog.Info("Replayed file", "file", file, "batches", r.batches-batches, "events", r.events-events)
+	}
+	return nil
+}
+
+// replay writes one batch, waiting for the rate limit first
+func (r *replayer) replay(ctx context.Context, batch models.EventBatch) error {
+	if batch.Tenant == "" {
+		batch.Tenant = r.tenant
+	}
+	if err := r.wait(ctx, len(batch.Events)); err != nil {
+		return err
+	}
+
+	if !r.dryRun {
+		if err := r.sink.LogBatch(ctx, batch); err != nil {
+			return fmt.Errorf("batch %s: %w", batch.BatchID, err)
+		}
+	}
+	r.batches++
+	r.events += len(batch.Events)
+	return nil
+}
+
+// wait blocks until n events may be written, in steps no larger than the
+// limiter's burst
+func (r *replayer) wait(ctx context.Context, n int) error {
+	if r.limiter == nil {
+		return ctx.Err()
+	}
+	for n > 0 {
+		step := min(n, r.limiter.Burst())
+		if err := r.limiter.WaitN(ctx, step); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// closeSink flushes and closes the sink so replayed batches are written
+func closeSink(eventSink sink.EventSink) error {
+	if flusher, ok := eventSink.(sink.Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+	return eventSink.Close()
+}
@@ -0,0 +1,84 @@
+package rollup
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maxMemoryPoints caps the points a MemoryStore keeps per tier. Points of
+// new videos and buckets past it are dropped until pruning makes room;
+// stores of a database have no such limit.
+const maxMemoryPoints = 500_000
+
+// MemoryStore keeps rollups in memory, for sinks that can't store them.
+// They are lost on restart. It is safe for concurrent use.
+type MemoryStore struct {
+	mu     sync.Mutex
+	points map[string]map[rowKey]*Point
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{points: make(map[string]map[rowKey]*Point)}
+}
+
+func (s *MemoryStore) AddRollups(ctx context.Context, tier string, rows []Row) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	points, ok := s.points[tier]
+	if !ok {
+		points = make(map[rowKey]*Point)
+		s.points[tier] = points
+	}
+	dropped := 0
+	for _, row := range rows {
+		key := rowKey{tenant: row.Tenant, videoID: row.VideoID, start: row.Start.Unix()}
+		existing, ok := points[key]
+		if !ok {
+			if len(points) >= maxMemoryPoints {
+				dropped++
+				continue
+			}
+			existing = &Point{VideoID: row.VideoID, Start: row.Start}
+			points[key] = existing
+		}
+		existing.add(row.Point)
+	}
+	if dropped > 0 {
+		slog.Warn("Dropped metric rollups over the in-memory limit", "tier", tier, "rows", dropped, "limit", maxMemoryPoints)
+	}
+	return nil
+}
+
+func (s *MemoryStore) QueryRollups(ctx context.Context, q Query) ([]Point, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var points []Point
+	for key, point := range s.points[q.Tier] {
+		if key.tenant != q.Tenant || (q.VideoID != "" && key.videoID != q.VideoID) ||
+			point.Start.Before(q.From) || !point.Start.Before(q.To) {
+			continue
+		}
+		points = append(points, *point)
+	}
+	return points, nil
+}
+
+func (s *MemoryStore) PruneRollups(ctx context.Context, tier string, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for key, point := range s.points[tier] {
+		if point.Start.Before(before) {
+			delete(s.points[tier], key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,442 @@
+// Package rollup aggregates what viewers watched into per-video metrics
+// at one-minute, one-hour and one-day resolution, each tier kept for its
+// own retention period, so queries over weeks or months read a few hundred
+// rows instead of scanning raw events
+package rollup
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/session"
+)
+
+// Tiers, named by their resolution
+const (
+	Minute = "1m"
+	Hour   = "1h"
+	Day    = "1d"
+)
+
+// Tier is a resolution rollups are kept at
+type Tier struct {
+	Name       string
+	Resolution time.Duration
+}
+
+// Tiers are the tiers, finest first
+var Tiers = []Tier{
+	{Name: Minute, Resolution: time.Minute},
+	{Name: Hour, Resolution: time.Hour},
+	{Name: Day, Resolution: 24 * time.Hour},
+}
+
+// TierByName returns the tier called name
+func TierByName(name string) (Tier, bool) {
+	i := slices.IndexFunc(Tiers, func(t Tier) bool { return t.Name == name })
+	if i < 0 {
+		return Tier{}, false
+	}
+	return Tiers[i], true
+}
+
+// Config configures the rollups
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// FlushInterval is how often the rollups of recent viewing are added
+	// to the store
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// Retention is how long each tier is kept
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// RetentionConfig is the retention period of each tier
+type RetentionConfig struct {
+	Minute time.Duration `yaml:"minute"`
+	Hour   time.Duration `yaml:"hour"`
+	Day    time.Duration `yaml:"day"`
+}
+
+// of returns the retention of tier
+func (r RetentionConfig) of(tier string) time.Duration {
+	switch tier {
+	case Minute:
+		return r.Minute
+	case Hour:
+		return r.Hour
+	default:
+		return r.Day
+	}
+}
+
+// DefaultConfig keeps minutes for two days, hours for 90 days and days for
+// two years
+func DefaultConfig() Config {
+	return Config{
+		Enabled:       true,
+		FlushInterval: 10 * time.Second,
+		Retention: RetentionConfig{
+			Minute: 48 * time.Hour,
+			Hour:   90 * 24 * time.Hour,
+			Day:    730 * 24 * time.Hour,
+		},
+	}
+}
+
+// Validate checks that each tier is kept for at least one of its buckets
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.FlushInterval <= 0 {
+		return fmt.Errorf("invalid rollup flush interval %v, must be positive", c.FlushInterval)
+	}
+	for _, tier := range Tiers {
+		if retention := c.Retention.of(tier.Name); retention < tier.Resolution {
+			return fmt.Errorf("invalid retention %v of the %s rollup tier, must be at least %v", retention, tier.Name, tier.Resolution)
+		}
+	}
+	return nil
+}
+
+// Point is the viewing of a video, or of every video of a tenant, in one
+// bucket of a tier
+type Point struct {
+	VideoID string    `json:"videoId,omitempty"`
+	Start   time.Time `json:"start,omitzero"`
+
+	// Sessions counts sessions that began in the bucket, Plays those whose
+	// first frame played, Completes those that played to the end and
+	// ErrorSessions those that hit their first player error
+	Sessions      int `json:"sessions"`
+	Plays         int `json:"plays"`
+	Completes     int `json:"completes"`
+	ErrorSessions int `json:"errorSessions"`
+
+	WatchTimeSeconds    float64 `json:"watchTimeSeconds"`
+	RebufferTimeSeconds float64 `json:"rebufferTimeSeconds"`
+	Rebuffers           int     `json:"rebuffers"`
+	// RebufferRatio is the share of the time spent watching or rebuffering
+	// that was rebuffering; stores leave it to Engine.Query
+	RebufferRatio float64 `json:"rebufferRatio"`
+}
+
+func (p *Point) add(other Point) {
+	p.Sessions += other.Sessions
+	p.Plays += other.Plays
+	p.Completes += other.Completes
+	p.ErrorSessions += other.ErrorSessions
+	p.WatchTimeSeconds += other.WatchTimeSeconds
+	p.RebufferTimeSeconds += other.RebufferTimeSeconds
+	p.Rebuffers += other.Rebuffers
+}
+
+func (p *Point) computeRatio() {
+	p.RebufferRatio = 0
+	if total := p.WatchTimeSeconds + p.RebufferTimeSeconds; total > 0 {
+		p.RebufferRatio = p.RebufferTimeSeconds / total
+	}
+}
+
+// Total sums points into one, without a start
+func Total(points []Point) Point {
+	var total Point
+	for _, point := range points {
+		total.add(point)
+	}
+	total.computeRatio()
+	return total
+}
+
+// Row is a point of a tenant, as stores keep them
+type Row struct {
+	Tenant string
+	Point
+}
+
+// Query selects the points of a tier of a tenant starting in [From, To).
+// An empty VideoID selects every video.
+type Query struct {
+	Tier    string
+	Tenant  string
+	VideoID string
+	From    time.Time
+	To      time.Time
+}
+
+// Store keeps rollups. AddRollups adds the counts of rows to those of the
+// points already stored for the same tenant, video and start.
+type Store interface {
+	AddRollups(ctx context.Context, tier string, rows []Row) error
+	// QueryRollups returns the points matching q, one per video and start
+	QueryRollups(ctx context.Context, q Query) ([]Point, error)
+	// PruneRollups deletes the points of tier starting before before and
+	// returns how many it deleted
+	PruneRollups(ctx context.Context, tier string, before time.Time) (int64, error)
+}
+
+type rowKey struct {
+	tenant  string
+	videoID string
+	start   int64
+}
+
+// pending holds rollups not in the store yet, by tier
+type pending map[string]map[rowKey]*Point
+
+// Engine rolls up session progress into every tier and periodically adds
+// it to its store, pruning each tier past its retention. It is safe for
+// concurrent use.
+type Engine struct {
+	cfg   Config
+	store Store
+
+	mu      sync.Mutex
+	pending pending
+	// flushing is the rollups being added to the store, still served by
+	// Query until they are
+	flushing pending
+
+	// flushMu serializes flushes
+	flushMu sync.Mutex
+	now     func() time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// New starts rolling up into store, or into a MemoryStore when store is
+// nil
+func New(cfg Config, store Store) *Engine {
+	defaults := DefaultConfig()
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaults.FlushInterval
+	}
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	e := &Engine{
+		cfg:     cfg,
+		store:   store,
+		pending: make(pending),
+		now:     time.Now,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Record rolls up what the events of a batch added to their sessions.
+// Progress without a video is skipped.
+func (e *Engine) Record(progress []session.Progress) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, p := range progress {
+		if p.VideoID == "" {
+			continue
+		}
+		point := Point{
+			WatchTimeSeconds:    p.WatchTimeSeconds,
+			RebufferTimeSeconds: p.RebufferTimeSeconds,
+			Rebuffers:           p.Rebuffers,
+		}
+		if p.Started {
+			point.Sessions = 1
+		}
+		if p.Played {
+			point.Plays = 1
+		}
+		if p.Ended {
+			point.Completes = 1
+		}
+		if p.Errored {
+			point.ErrorSessions = 1
+		}
+		for _, tier := range Tiers {
+			e.pending.add(tier, p.Tenant, p.VideoID, p.At, point)
+		}
+	}
+}
+
+// add adds point to the bucket of tier containing at
+func (p pending) add(tier Tier, tenant, videoID string, at time.Time, point Point) {
+	points, ok := p[tier.Name]
+	if !ok {
+		points = make(map[rowKey]*Point)
+		p[tier.Name] = points
+	}
+	start := at.UTC().Truncate(tier.Resolution)
+	key := rowKey{tenant: tenant, videoID: videoID, start: start.Unix()}
+	existing, ok := points[key]
+	if !ok {
+		existing = &Point{VideoID: videoID, Start: start}
+		points[key] = existing
+	}
+	existing.add(point)
+}
+
+func (e *Engine) run() {
+	defer close(e.done)
+
+	flush := time.NewTicker(e.cfg.FlushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+	e.prune()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-flush.C:
+			e.flush()
+		case <-prune.C:
+			e.prune()
+		}
+	}
+}
+
+// flush adds the pending rollups to the store. Tiers the store failed to
+// add are kept pending for the next flush.
+func (e *Engine) flush() {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	e.mu.Lock()
+	e.flushing, e.pending = e.pending, make(pending)
+	flushing := e.flushing
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	failed := make(pending)
+	for tier, points := range flushing {
+		rows := make([]Row, 0, len(points))
+		for key, point := range points {
+			rows = append(rows, Row{Tenant: key.tenant, Point: *point})
+		}
+		if err := e.store.AddRollups(ctx, tier, rows); err != nil {
+			slog.Error("Failed to store metric rollups, retrying at the next flush", "tier", tier, "rows", len(rows), "error", err)
+			metrics.RollupFlushes.WithLabelValues(tier, "failed").Inc()
+			failed[tier] = points
+			continue
+		}
+		metrics.RollupFlushes.WithLabelValues(tier, "stored").Inc()
+	}
+
+	e.mu.Lock()
+	for tier, points := range failed {
+		resolution, _ := TierByName(tier)
+		for key, point := range points {
+			e.pending.add(resolution, key.tenant, key.videoID, point.Start, *point)
+		}
+	}
+	e.flushing = nil
+	e.mu.Unlock()
+}
+
+// prune deletes what fell out of the retention of each tier
+func (e *Engine) prune() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	now := e.now()
+	for _, tier := range Tiers {
+		before := now.Add(-e.cfg.Retention.of(tier.Name)).Truncate(tier.Resolution)
+		deleted, err := e.store.PruneRollups(ctx, tier.Name, before)
+		if err != nil {
+			slog.Error("Failed to prune metric rollups", "tier", tier.Name, "error", err)
+			continue
+		}
+		if deleted > 0 {
+			slog.Debug("Pruned metric rollups", "tier", tier.Name, "rows", deleted)
+		}
+	}
+}
+
+// Retention returns how long tier is kept
+func (e *Engine) Retention(tier string) time.Duration {
+	return e.cfg.Retention.of(tier)
+}
+
+// maxPoints is how many buckets TierFor lets a query span
+const maxPoints = 1500
+
+// TierFor picks the finest tier still kept at from whose buckets between
+// from and to number at most maxPoints, or the coarsest tier
+func (e *Engine) TierFor(from, to time.Time) string {
+	for _, tier := range Tiers {
+		kept := !from.Before(e.now().Add(-e.cfg.Retention.of(tier.Name)))
+		if kept && to.Sub(from)/tier.Resolution <= maxPoints {
+			return tier.Name
+		}
+	}
+	return Tiers[len(Tiers)-1].Name
+}
+
+// Query returns the points of q from the store and those not stored yet,
+// oldest first. Without a video in q the videos of each bucket are summed.
+func (e *Engine) Query(ctx context.Context, q Query) ([]Point, error) {
+	tier, ok := TierByName(q.Tier)
+	if !ok {
+		return nil, fmt.Errorf("unknown rollup tier %q", q.Tier)
+	}
+	q.From = q.From.UTC().Truncate(tier.Resolution)
+	stored, err := e.store.QueryRollups(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[rowKey]*Point)
+	add := func(videoID string, point Point) {
+		if q.VideoID == "" {
+			videoID = ""
+		}
+		key := rowKey{videoID: videoID, start: point.Start.Unix()}
+		existing, ok := merged[key]
+		if !ok {
+			existing = &Point{VideoID: videoID, Start: point.Start.UTC()}
+			merged[key] = existing
+		}
+		existing.add(point)
+	}
+	for _, point := range stored {
+		add(point.VideoID, point)
+	}
+
+	e.mu.Lock()
+	for _, unstored := range []pending{e.flushing, e.pending} {
+		for key, point := range unstored[q.Tier] {
+			if key.tenant != q.Tenant || (q.VideoID != "" && key.videoID != q.VideoID) ||
+				point.Start.Before(q.From) || !point.Start.Before(q.To) {
+				continue
+			}
+			add(key.videoID, *point)
+		}
+	}
+	e.mu.Unlock()
+
+	points := make([]Point, 0, len(merged))
+	for _, point := range merged {
+		point.computeRatio()
+		points = append(points, *point)
+	}
+	slices.SortFunc(points, func(a, b Point) int {
+		return cmp.Or(a.Start.Compare(b.Start), cmp.Compare(a.VideoID, b.VideoID))
+	})
+	return points, nil
+}
+
+// Close stops the engine and adds what is pending to the store
+func (e *Engine) Close() error {
+	close(e.stop)
+	<-e.done
+	e.flush()
+	return nil
+}
@@ -0,0 +1,163 @@
+// Package sampling drops a deterministic share of high-volume events,
+// keeping or dropping whole sessions so sampled sessions stay complete
+package sampling
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// Rule keeps Rate of the sessions for events matching EventName and Tenant.
+// Empty fields match everything. When several rules match an event, one
+// naming both the tenant and the event wins over one naming only the
+// event, which wins over one naming only the tenant.
+type Rule struct {
+	Tenant    string  `yaml:"tenant" json:"tenant,omitempty"`
+	EventName string  `yaml:"eventName" json:"eventName,omitempty"`
+	Rate      float64 `yaml:"rate" json:"rate"`
+}
+
+// Validate checks that the rate is a fraction
+func (r Rule) Validate() error {
+	if math.IsNaN(r.Rate) || r.Rate < 0 || r.Rate > 1 {
+		return fmt.Errorf("invalid sample rate %v for event %q, tenant %q: must be between 0 and 1", r.Rate, r.EventName, r.Tenant)
+	}
+	return nil
+}
+
+// ruleKey identifies a rule by what it matches
+type ruleKey struct {
+	tenant    string
+	eventName string
+}
+
+// Sampler applies sampling rules to batches. Rules can be replaced while
+// batches are being sampled.
+type Sampler struct {
+	mu    sync.RWMutex
+	rules map[ruleKey]Rule
+}
+
+// New creates a Sampler with rules
+func New(rules []Rule) (*Sampler, error) {
+	s := &Sampler{}
+	if err := s.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetRules replaces every rule. A later rule for the same tenant and event
+// overrides an earlier one.
+func (s *Sampler) SetRules(rules []Rule) error {
+	byKey := make(map[ruleKey]Rule, len(rules))
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+		byKey[ruleKey{tenant: rule.Tenant, eventName: rule.EventName}] = rule
+	}
+
+	s.mu.Lock()
+	s.rules = byKey
+	s.mu.Unlock()
+	return nil
+}
+
+// Rules returns the current rules
+func (s *Sampler) Rules() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]Rule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// rule returns the most specific rule matching an event, if any
+func (s *Sampler) rule(tenant, eventName string) (Rule, bool) {
+	for _, key := range []ruleKey{
+		{tenant: tenant, eventName: eventName},
+		{eventName: eventName},
+		{tenant: tenant},
+		{},
+	} {
+		if rule, ok := s.rules[key]; ok {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Dropped counts the events a rule sampled out of a batch
+type Dropped struct {
+	// EventName is the event name of the rule, empty for catch-all rules
+	EventName string
+	Count     int
+}
+
+// Sample returns the batch with the sampled-out events removed. Kept
+// events of a sampling rule are marked with Sampled and SampleRate so
+// queries can weight them by 1/SampleRate.
+//
+// Whether an event is kept only depends on its tenant, session and rate:
+// a session kept at 10% is also kept at every higher rate, so all of a
+// session's heartbeats are either present or absent. Sampled and
+// SampleRate sent by clients are cleared.
+func (s *Sampler) Sample(batch models.EventBatch) (models.EventBatch, []Dropped) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	kept := make([]models.Event, 0, len(batch.Events))
+	var dropped []Dropped
+	for _, event := range batch.Events {
+		// Only the server decides what was sampled
+		event.Sampled, event.SampleRate = false, 0
+
+		rule, ok := s.rule(batch.Tenant, event.EventName)
+		if !ok || rule.Rate >= 1 {
+			kept = append(kept, event)
+			continue
+		}
+
+		sessionID := event.SessionID
+		if sessionID == "" {
+			sessionID = batch.SessionID
+		}
+		if position(batch.Tenant, sessionID) < rule.Rate {
+			event.Sampled = true
+			event.SampleRate = rule.Rate
+			kept = append(kept, event)
+			continue
+		}
+		dropped = countDropped(dropped, rule.EventName)
+	}
+
+	batch.Events = kept
+	return batch, dropped
+}
+
+func countDropped(dropped []Dropped, eventName string) []Dropped {
+	for i := range dropped {
+		if dropped[i].EventName == eventName {
+			dropped[i].Count++
+			return dropped
+		}
+	}
+	return append(dropped, Dropped{EventName: eventName, Count: 1})
+}
+
+// position maps a session to a stable point in [0, 1)
+func position(tenant, sessionID string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(tenant))
+	h.Write([]byte{0})
+	h.Write([]byte(sessionID))
+	return float64(h.Sum64()>>11) / (1 << 53)
+}
@@ -0,0 +1,220 @@
+// Package schema keeps the registry of event schema versions the collector
+// accepts and upgrades payloads of older versions to the current one at
+// ingest, so the event contract can change without breaking deployed
+// players
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/adtyap26/event-stream-video/internal/checksum"
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+const (
+	// Current is the version of the events the collector stores
+	Current = 2
+	// Unversioned is the version of payloads without a schemaVersion,
+	// which were sent by players that predate versioning
+	Unversioned = 1
+)
+
+// Version is a version of the event contract
+type Version struct {
+	Number int `json:"version"`
+	// Changes says how the version differs from the one before
+	Changes string `json:"changes"`
+
+	// upgrade turns a JSON event of the version before into this version
+	upgrade func(event map[string]json.RawMessage) error
+}
+
+// versions lists every supported version, oldest first. A new version adds
+// an entry whose upgrade converts events of the previous version; the
+// model must be able to hold every version once the upgrades ran.
+var versions = []Version{
+	{
+		Number:  1,
+		Changes: "customData is a string, usually holding JSON",
+	},
+	{
+		Number:  2,
+		Changes: "adds schemaVersion; customData may be any JSON value",
+		upgrade: upgradeCustomData,
+	},
+}
+
+// Versions returns the supported versions, oldest first
+func Versions() []Version {
+	return append([]Version(nil), versions...)
+}
+
+// Supported reports whether events of version can be upgraded to Current
+func Supported(version int) bool {
+	return version >= versions[0].Number && version <= Current
+}
+
+// DecodeBatch decodes a JSON batch, upgrading the batch and each event from
+// the version it declares to Current. Events without a schemaVersion have
+// the batch's. Events of unsupported versions keep their version, for
+// validation to reject. A batch with a checksum is only decoded when its
+// events array, as sent, matches it; see checksum.Verify.
+func DecodeBatch(data []byte) (models.EventBatch, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return models.EventBatch{}, err
+	}
+	if sum, ok := raw["checksum"]; ok {
+		var value string
+		if err := json.Unmarshal(sum, &value); err != nil {
+			return models.EventBatch{}, errors.New("checksum must be a string")
+		}
+		if value != "" {
+			if err := checksum.Verify(raw["events"], value); err != nil {
+				return models.EventBatch{}, err
+			}
+		}
+		delete(raw, "checksum")
+	}
+	batchVersion, err := version(raw, Unversioned)
+	if err != nil {
+		return models.EventBatch{}, err
+	}
+
+	var rawEvents []map[string]json.RawMessage
+	if events, ok := raw["events"]; ok {
+		if err := json.Unmarshal(events, &rawEvents); err != nil {
+			return models.EventBatch{}, err
+		}
+		delete(raw, "events")
+	}
+
+	var batch models.EventBatch
+	if err := unmarshalFields(raw, &batch); err != nil {
+		return models.EventBatch{}, err
+	}
+	batch.SchemaVersion = batchVersion
+
+	batch.Events = make([]models.Event, len(rawEvents))
+	for i, rawEvent := range rawEvents {
+		eventVersion, err := version(rawEvent, batchVersion)
+		if err != nil {
+			return models.EventBatch{}, err
+		}
+		if err := upgrade(rawEvent, eventVersion); err != nil {
+			return models.EventBatch{}, fmt.Errorf("event %d: %w", i, err)
+		}
+		if err := toModel(rawEvent, &batch.Events[i]); err != nil {
+			return models.EventBatch{}, err
+		}
+		batch.Events[i].SchemaVersion = eventVersion
+	}
+
+	UpgradeBatch(&batch)
+	return batch, nil
+}
+
+// UpgradeBatch marks the batch and its events of supported versions as
+// Current. It is all an upgrade takes for batches decoded from protobuf,
+// whose fields mean the same in every version; JSON batches are upgraded by
+// DecodeBatch.
+func UpgradeBatch(batch *models.EventBatch) {
+	if batch.SchemaVersion == 0 {
+		batch.SchemaVersion = Unversioned
+	}
+	for i := range batch.Events {
+		event := &batch.Events[i]
+		if event.SchemaVersion == 0 {
+			event.SchemaVersion = batch.SchemaVersion
+		}
+		if Supported(event.SchemaVersion) {
+			event.SchemaVersion = Current
+		}
+	}
+	if Supported(batch.SchemaVersion) {
+		batch.SchemaVersion = Current
+	}
+}
+
+// version reads the schemaVersion of a JSON object, or fallback when it has
+// none
+func version(object map[string]json.RawMessage, fallback int) (int, error) {
+	raw, ok := object["schemaVersion"]
+	if !ok || isNull(raw) {
+		return fallback, nil
+	}
+	var v int
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return 0, fmt.Errorf("schemaVersion must be an integer: %w", err)
+	}
+	return v, nil
+}
+
+// upgrade runs the upgrades from version to Current on a JSON event.
+// Unsupported versions are left alone.
+func upgrade(event map[string]json.RawMessage, version int) error {
+	if !Supported(version) {
+		return nil
+	}
+	for _, v := range versions {
+		if v.Number <= version || v.upgrade == nil {
+			continue
+		}
+		if err := v.upgrade(event); err != nil {
+			return fmt.Errorf("upgrading to schema version %d: %w", v.Number, err)
+		}
+	}
+	return nil
+}
+
+// upgradeCustomData is the upgrade to version 2. Version 1 players put JSON
+// objects into customData as strings, which version 2 sends as they are.
+// Other strings stay strings.
+func upgradeCustomData(event map[string]json.RawMessage) error {
+	raw, ok := event["customData"]
+	if !ok {
+		return nil
+	}
+	var text string
+	if json.Unmarshal(raw, &text) != nil {
+		// Not a string: sent the version 2 way by a player that didn't say so
+		return nil
+	}
+	if trimmed := bytes.TrimSpace([]byte(text)); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		event["customData"] = trimmed
+	}
+	return nil
+}
+
+// toModel decodes a current JSON event into the model, which keeps
+// customData as its JSON text
+func toModel(event map[string]json.RawMessage, target *models.Event) error {
+	if raw, ok := event["customData"]; ok && !isNull(raw) && raw[0] != '"' {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return err
+		}
+		text, err := json.Marshal(compact.String())
+		if err != nil {
+			return err
+		}
+		event["customData"] = text
+	}
+	return unmarshalFields(event, target)
+}
+
+// unmarshalFields decodes the fields of a JSON object into target
+func unmarshalFields(object map[string]json.RawMessage, target any) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+func isNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
@@ -0,0 +1,253 @@
+// Package scrub removes personal data from events before they are stored,
+// hashing or dropping user IDs, IP addresses and chosen custom data keys
+package scrub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// Action is what happens to a field holding personal data
+type Action string
+
+const (
+	// Keep stores the field as sent
+	Keep Action = ""
+	// Hash replaces the value with a keyed hash, so it still tells users
+	// apart but can't be read back
+	Hash Action = "hash"
+	// Drop removes the field
+	Drop Action = "drop"
+)
+
+// ParseAction validates an action name; "keep" and "" both keep the field
+func ParseAction(s string) (Action, error) {
+	switch Action(strings.ToLower(s)) {
+	case Keep, "keep":
+		return Keep, nil
+	case Hash:
+		return Hash, nil
+	case Drop:
+		return Drop, nil
+	}
+	return Keep, fmt.Errorf("unknown scrub action %q, must be keep, hash or drop", s)
+}
+
+// ipKeys are the keys, compared case-insensitively, under which players
+// report the client's IP address in context, technical or playbackState
+var ipKeys = []string{"ip", "ipAddress", "clientIp", "userIp", "remoteAddr"}
+
+// Config configures scrubbing. The HTTP request's own address is never
+// stored; IP covers addresses the player puts in the event.
+type Config struct {
+	// Salt keys the hashes so they can't be reversed by hashing known
+	// values. It is required when any field is hashed and must stay the
+	// same for hashed IDs to stay stable.
+	Salt string `yaml:"salt"`
+	// UserID applies to Event.UserID
+	UserID string `yaml:"userId"`
+	// IP applies to IP address fields of the event's objects
+	IP string `yaml:"ip"`
+	// CustomDataKeys are path.Match patterns, compared case-insensitively,
+	// of keys anywhere in a customData JSON object, e.g. "email" or "*_name"
+	CustomDataKeys []string `yaml:"customDataKeys"`
+	// CustomData applies to values under CustomDataKeys
+	CustomData string `yaml:"customData"`
+}
+
+// Scrubber applies a Config to batches. It is safe for concurrent use.
+type Scrubber struct {
+	salt       []byte
+	userID     Action
+	ip         Action
+	customKeys []string
+	customData Action
+}
+
+// New validates cfg. It returns nil when cfg scrubs nothing.
+func New(cfg Config) (*Scrubber, error) {
+	s := &Scrubber{salt: []byte(cfg.Salt)}
+	var err error
+	if s.userID, err = ParseAction(cfg.UserID); err != nil {
+		return nil, fmt.Errorf("userId: %w", err)
+	}
+	if s.ip, err = ParseAction(cfg.IP); err != nil {
+		return nil, fmt.Errorf("ip: %w", err)
+	}
+	if s.customData, err = ParseAction(cfg.CustomData); err != nil {
+		return nil, fmt.Errorf("customData: %w", err)
+	}
+	for _, pattern := range cfg.CustomDataKeys {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid custom data key pattern %q: %w", pattern, err)
+		}
+		s.customKeys = append(s.customKeys, pattern)
+	}
+	if len(s.customKeys) > 0 && s.customData == Keep {
+		return nil, fmt.Errorf("custom data keys are set but customData is keep")
+	}
+	if len(s.customKeys) == 0 {
+		s.customData = Keep
+	}
+
+	if s.userID == Keep && s.ip == Keep && s.customData == Keep {
+		return nil, nil
+	}
+	if cfg.Salt == "" && (s.userID == Hash || s.ip == Hash || s.customData == Hash) {
+		return nil, fmt.Errorf("a salt is required to hash personal data")
+	}
+	return s, nil
+}
+
+// Scrub applies the configured actions to every event of batch in place
+func (s *Scrubber) Scrub(batch *models.EventBatch) {
+	for i := range batch.Events {
+		event := &batch.Events[i]
+		switch s.userID {
+		case Hash:
+			if event.UserID != "" {
+				event.UserID = s.hash(event.UserID)
+			}
+		case Drop:
+			event.UserID = ""
+		}
+
+		if s.ip != Keep {
+			if event.Context != nil {
+				s.scrubIP(event.Context.Extra)
+			}
+			if event.Technical != nil {
+				s.scrubIP(event.Technical.Extra)
+			}
+			if event.PlaybackState != nil {
+				s.scrubIP(event.PlaybackState.Extra)
+			}
+		}
+
+		if s.customData != Keep && event.CustomData != "" {
+			event.CustomData = s.scrubCustomData(event.CustomData)
+		}
+	}
+}
+
+// StoredUserID returns userID as Scrub stores it, so stored events can be
+// found by the ID a user is known by. ok is false when user IDs are dropped.
+func (s *Scrubber) StoredUserID(userID string) (stored string, ok bool) {
+	switch s.userID {
+	case Hash:
+		return s.hash(userID), true
+	case Drop:
+		return "", false
+	}
+	return userID, true
+}
+
+// scrubIP applies the IP action to the address keys of extra
+func (s *Scrubber) scrubIP(extra map[string]interface{}) {
+	for key, value := range extra {
+		if !isIPKey(key) {
+			continue
+		}
+		s.apply(extra, key, value, s.ip)
+	}
+}
+
+func isIPKey(key string) bool {
+	for _, ipKey := range ipKeys {
+		if strings.EqualFold(key, ipKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubCustomData rewrites a customData JSON object without the matching
+// keys' values. Custom data that isn't a JSON object has no keys and is
+// kept as sent.
+func (s *Scrubber) scrubCustomData(data string) string {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &object); err != nil || object == nil {
+		return data
+	}
+	if !s.scrubObject(object) {
+		return data
+	}
+	scrubbed, err := json.Marshal(object)
+	if err != nil {
+		return data
+	}
+	return string(scrubbed)
+}
+
+// scrubObject applies the custom data action to matching keys of object
+// and the objects nested in it, reporting whether anything changed
+func (s *Scrubber) scrubObject(object map[string]interface{}) bool {
+	changed := false
+	for key, value := range object {
+		if s.matchesCustomKey(key) {
+			s.apply(object, key, value, s.customData)
+			changed = true
+			continue
+		}
+		changed = s.scrubValue(value) || changed
+	}
+	return changed
+}
+
+func (s *Scrubber) scrubValue(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return s.scrubObject(v)
+	case []interface{}:
+		changed := false
+		for _, item := range v {
+			changed = s.scrubValue(item) || changed
+		}
+		return changed
+	}
+	return false
+}
+
+func (s *Scrubber) matchesCustomKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, pattern := range s.customKeys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// apply hashes or drops object[key]. Values other than strings are hashed
+// in their JSON form.
+func (s *Scrubber) apply(object map[string]interface{}, key string, value interface{}, action Action) {
+	switch action {
+	case Drop:
+		delete(object, key)
+	case Hash:
+		if value == nil {
+			return
+		}
+		text, ok := value.(string)
+		if !ok {
+			encoded, _ := json.Marshal(value)
+			text = string(encoded)
+		}
+		object[key] = s.hash(text)
+	}
+}
+
+// hash returns the first 128 bits of the value's HMAC-SHA256 in hex
+func (s *Scrubber) hash(value string) string {
+	mac := hmac.New(sha256.New, s.salt)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
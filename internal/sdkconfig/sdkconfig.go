@@ -0,0 +1,205 @@
+// Package sdkconfig holds the settings player SDKs fetch at startup, so how
+// players batch, sample and filter events is tuned per tenant on the
+// collector instead of by shipping new player builds
+package sdkconfig
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long SDKs may keep their settings before fetching them
+// again
+const DefaultTTL = 5 * time.Minute
+
+// Settings tune a player SDK. In the settings of a tenant, unset fields
+// keep the defaults', sampling rates are merged with them and a list of
+// events replaces theirs.
+type Settings struct {
+	// BatchSize is how many events the SDK queues before sending them
+	BatchSize int `yaml:"batchSize"`
+	// BatchInterval is how often the SDK sends what it queued
+	BatchInterval time.Duration `yaml:"batchInterval"`
+	// HeartbeatInterval is how often playing players send a heartbeat, 0
+	// for none
+	HeartbeatInterval *time.Duration `yaml:"heartbeatInterval"`
+	// SessionSampleRate is the share of sessions that report at all
+	SessionSampleRate *float64 `yaml:"sessionSampleRate"`
+	// SampleRate is the share of the events of a type that are sent, by
+	// event name; types not listed are all sent
+	SampleRate map[string]float64 `yaml:"sampleRate"`
+	// Events are the event types the SDK sends, empty for all
+	Events []string `yaml:"events"`
+}
+
+// Config configures SDK settings delivery
+type Config struct {
+	Enabled  bool `yaml:"enabled"`
+	Settings `yaml:",inline"`
+	// TTL is how long SDKs may cache their settings
+	TTL time.Duration `yaml:"ttl"`
+	// Tenants adjusts the settings of the players of a tenant
+	Tenants map[string]Settings `yaml:"tenants"`
+}
+
+// DefaultConfig serves the defaults of the bundled SDK
+func DefaultConfig() Config {
+	heartbeat := 10 * time.Second
+	sessions := 1.0
+	return Config{
+		Enabled: true,
+		Settings: Settings{
+			BatchSize:         15,
+			BatchInterval:     5 * time.Second,
+			HeartbeatInterval: &heartbeat,
+			SessionSampleRate: &sessions,
+			SampleRate:        map[string]float64{"timeupdate": 0.2},
+		},
+		TTL: DefaultTTL,
+	}
+}
+
+// Validate checks the defaults and the settings of every tenant. Batches
+// may not be larger than maxEvents, which the collector would reject.
+func (c Config) Validate(maxEvents int) error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.BatchSize <= 0 || c.BatchInterval <= 0 {
+		return errors.New("sdk batchSize and batchInterval must be positive")
+	}
+	if c.HeartbeatInterval == nil || c.SessionSampleRate == nil {
+		return errors.New("sdk heartbeatInterval and sessionSampleRate are required")
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("invalid sdk ttl %v", c.TTL)
+	}
+	if err := c.Settings.validate(maxEvents); err != nil {
+		return fmt.Errorf("sdk: %w", err)
+	}
+	for tenant, settings := range c.Tenants {
+		if err := settings.validate(maxEvents); err != nil {
+			return fmt.Errorf("sdk tenant %s: %w", tenant, err)
+		}
+	}
+	return nil
+}
+
+func (s Settings) validate(maxEvents int) error {
+	if s.BatchSize < 0 || (maxEvents > 0 && s.BatchSize > maxEvents) {
+		return fmt.Errorf("invalid batchSize %d, must be at most %d", s.BatchSize, maxEvents)
+	}
+	if s.BatchInterval < 0 {
+		return fmt.Errorf("invalid batchInterval %v", s.BatchInterval)
+	}
+	if s.HeartbeatInterval != nil && *s.HeartbeatInterval < 0 {
+		return fmt.Errorf("invalid heartbeatInterval %v", *s.HeartbeatInterval)
+	}
+	if s.SessionSampleRate != nil && (*s.SessionSampleRate < 0 || *s.SessionSampleRate > 1) {
+		return fmt.Errorf("invalid sessionSampleRate %v, must be between 0 and 1", *s.SessionSampleRate)
+	}
+	for event, rate := range s.SampleRate {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("invalid sampleRate %v of %s, must be between 0 and 1", rate, event)
+		}
+	}
+	for _, event := range s.Events {
+		if event == "" {
+			return errors.New("events must not be empty")
+		}
+	}
+	return nil
+}
+
+// SDKConfig is what an SDK is sent, named after the SDK's own options with
+// intervals in milliseconds
+type SDKConfig struct {
+	Tenant            string             `json:"tenant"`
+	BatchSize         int                `json:"batchSize"`
+	BatchInterval     int64              `json:"batchInterval"`
+	HeartbeatInterval int64              `json:"heartbeatInterval"`
+	SessionSampleRate float64            `json:"sessionSampleRate"`
+	SampleRate        map[string]float64 `json:"sampleRate"`
+	// EnabledEvents is empty when every event is sent
+	EnabledEvents []string `json:"enabledEvents"`
+	// TTL is how many seconds the settings may be cached for
+	TTL int `json:"ttl"`
+}
+
+// Store serves the settings of each tenant. It is safe for concurrent use.
+type Store struct {
+	mu  sync.RWMutex
+	cfg Config
+}
+
+// New creates a store of cfg's settings, or returns nil when delivery is
+// disabled
+func New(cfg Config) *Store {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Store{cfg: cfg}
+}
+
+// Update replaces the settings, e.g. on a config reload
+func (s *Store) Update(cfg Config) {
+	s.mu.Lock()
+	s.cfg = cfg
+	s.mu.Unlock()
+}
+
+// For returns the settings of tenant's players
+func (s *Store) For(tenant string) SDKConfig {
+	s.mu.RLock()
+	cfg := s.cfg
+	s.mu.RUnlock()
+
+	settings := cfg.Settings
+	settings.SampleRate = maps.Clone(settings.SampleRate)
+	if override, ok := cfg.Tenants[tenant]; ok {
+		if override.BatchSize > 0 {
+			settings.BatchSize = override.BatchSize
+		}
+		if override.BatchInterval > 0 {
+			settings.BatchInterval = override.BatchInterval
+		}
+		if override.HeartbeatInterval != nil {
+			settings.HeartbeatInterval = override.HeartbeatInterval
+		}
+		if override.SessionSampleRate != nil {
+			settings.SessionSampleRate = override.SessionSampleRate
+		}
+		if settings.SampleRate == nil {
+			settings.SampleRate = make(map[string]float64)
+		}
+		maps.Copy(settings.SampleRate, override.SampleRate)
+		if override.Events != nil {
+			settings.Events = override.Events
+		}
+	}
+
+	ttl := cfg.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	sent := SDKConfig{
+		Tenant:            tenant,
+		BatchSize:         settings.BatchSize,
+		BatchInterval:     settings.BatchInterval.Milliseconds(),
+		HeartbeatInterval: settings.HeartbeatInterval.Milliseconds(),
+		SessionSampleRate: *settings.SessionSampleRate,
+		SampleRate:        settings.SampleRate,
+		EnabledEvents:     settings.Events,
+		TTL:               int(ttl / time.Second),
+	}
+	if sent.SampleRate == nil {
+		sent.SampleRate = map[string]float64{}
+	}
+	if sent.EnabledEvents == nil {
+		sent.EnabledEvents = []string{}
+	}
+	return sent
+}
@@ -0,0 +1,111 @@
+package session
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+)
+
+const (
+	// DefaultConcurrencyWindow is how recent a session's last heartbeat
+	// must be for its viewer to count as concurrent
+	DefaultConcurrencyWindow = time.Minute
+	// concurrencyInterval is how often the concurrent viewers gauge is
+	// updated
+	concurrencyInterval = 10 * time.Second
+)
+
+// VideoConcurrency is how many viewers are watching a video
+type VideoConcurrency struct {
+	VideoID string `json:"videoId"`
+	Viewers int    `json:"viewers"`
+}
+
+// Concurrency is how many viewers of a tenant are watching, per video with
+// the most watched first
+type Concurrency struct {
+	At time.Time `json:"at"`
+	// WindowSeconds is how recent the heartbeats of concurrent viewers are
+	WindowSeconds float64 `json:"windowSeconds"`
+
+	Viewers int                `json:"viewers"`
+	Videos  []VideoConcurrency `json:"videos"`
+}
+
+// heartbeatEvents are the events that show a player is still playing
+var heartbeatEvents = map[string]bool{
+	"heartbeat":  true,
+	"timeupdate": true,
+	"playing":    true,
+}
+
+// concurrent reports whether the session's viewer was watching at now
+func (s *State) concurrent(now time.Time, window time.Duration) bool {
+	return !s.Ended && !s.heartbeatSeen.IsZero() && now.Sub(s.heartbeatSeen) <= window
+}
+
+// Concurrency counts the sessions of tenant that sent a heartbeat within
+// the concurrency window and haven't ended
+func (t *Tracker) Concurrency(tenant string) Concurrency {
+	now := t.now()
+	videos := make(map[string]int)
+	total := 0
+
+	t.eachState(func(state *State) {
+		if state.Tenant == tenant && state.concurrent(now, t.concurrencyWindow) {
+			videos[state.VideoID]++
+			total++
+		}
+	})
+
+	c := Concurrency{
+		At:            now,
+		WindowSeconds: t.concurrencyWindow.Seconds(),
+		Viewers:       total,
+		Videos:        make([]VideoConcurrency, 0, len(videos)),
+	}
+	for videoID, viewers := range videos {
+		c.Videos = append(c.Videos, VideoConcurrency{VideoID: videoID, Viewers: viewers})
+	}
+	slices.SortFunc(c.Videos, func(a, b VideoConcurrency) int {
+		return cmp.Or(cmp.Compare(b.Viewers, a.Viewers), cmp.Compare(a.VideoID, b.VideoID))
+	})
+	return c
+}
+
+// VideoConcurrency counts the concurrent viewers of one of tenant's videos
+func (t *Tracker) VideoConcurrency(tenant, videoID string) VideoConcurrency {
+	now := t.now()
+	c := VideoConcurrency{VideoID: videoID}
+
+	t.eachState(func(state *State) {
+		if state.Tenant == tenant && state.VideoID == videoID && state.concurrent(now, t.concurrencyWindow) {
+			c.Viewers++
+		}
+	})
+	return c
+}
+
+// updateConcurrency sets the concurrent viewers gauge of every tenant.
+// Tenants without viewers since the last update are removed from it. With
+// shared sessions every collector reports the viewers of the deployment.
+func (t *Tracker) updateConcurrency(now time.Time) {
+	viewers := make(map[string]int)
+	t.eachState(func(state *State) {
+		if state.concurrent(now, t.concurrencyWindow) {
+			viewers[state.Tenant]++
+		}
+	})
+
+	for tenant := range t.gaugeTenants {
+		if _, ok := viewers[tenant]; !ok {
+			metrics.ConcurrentViewers.DeleteLabelValues(tenant)
+		}
+	}
+	for tenant, n := range viewers {
+		metrics.ConcurrentViewers.WithLabelValues(tenant).Set(float64(n))
+	}
+	t.gaugeTenants = viewers
+}
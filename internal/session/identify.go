@@ -0,0 +1,236 @@
+package session
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+)
+
+// Identity is a session's anonymous ID and the user ID its viewer logged in
+// with
+type Identity struct {
+	Tenant      string
+	SessionID   string
+	VideoID     string
+	AnonymousID string
+	UserID      string
+}
+
+// OnIdentify registers fn to be called when a session with an anonymous ID
+// gets its first user ID, whether from the event that started it or from a
+// later one once the viewer logged in. It must be called before the tracker
+// observes events.
+func (t *Tracker) OnIdentify(fn func(Identity)) {
+	t.onIdentify = append(t.onIdentify, fn)
+}
+
+// MergeIdentified makes a session that gets its first user ID absorb the
+// active sessions of the same video and anonymous ID without one, which
+// players starting a new session when the viewer logs in leave behind. The
+// merged sessions end without summaries of their own; their IDs are listed
+// in the MergedSessionIDs of the session they were merged into. It must be
+// called before the tracker observes events.
+func (t *Tracker) MergeIdentified() {
+	t.mergeIdentified = true
+}
+
+// identified returns the identity of state if the event just applied gave
+// it its first user ID
+func identified(hadUser bool, state *State) (Identity, bool) {
+	if hadUser || state.UserID == "" || state.AnonymousID == "" {
+		return Identity{}, false
+	}
+	return Identity{
+		Tenant:      state.Tenant,
+		SessionID:   state.SessionID,
+		VideoID:     state.VideoID,
+		AnonymousID: state.AnonymousID,
+		UserID:      state.UserID,
+	}, true
+}
+
+// duplicateOf reports whether other is an anonymous session the viewer of
+// id left behind when logging in
+func duplicateOf(id Identity, other *State) bool {
+	return other.SessionID != id.SessionID && other.Tenant == id.Tenant &&
+		other.UserID == "" && other.AnonymousID == id.AnonymousID && other.VideoID == id.VideoID
+}
+
+// mergeDuplicates folds the duplicates of id into the session of key. t.mu
+// must be held.
+func (t *Tracker) mergeDuplicates(key string, id Identity) {
+	state, ok := t.sessions[key]
+	if !ok {
+		// Ended by the same batch
+		return
+	}
+	for otherKey, other := range t.sessions {
+		if duplicateOf(id, other) {
+			state.merge(other)
+			delete(t.sessions, otherKey)
+			metrics.MergedSessions.WithLabelValues(id.Tenant).Inc()
+		}
+	}
+}
+
+// mergeSharedDuplicates takes the shared duplicates of id and folds them
+// into its session
+func (t *Tracker) mergeSharedDuplicates(id Identity, now time.Time) {
+	var duplicates []*State
+	t.eachState(func(state *State) {
+		if duplicateOf(id, state) {
+			duplicates = append(duplicates, state)
+		}
+	})
+	if len(duplicates) == 0 {
+		return
+	}
+
+	// Taking them first keeps another collector from ending them meanwhile
+	var taken []*State
+	for _, duplicate := range duplicates {
+		data, ok, err := t.store.Take(sessionKey(duplicate.Tenant, duplicate.SessionID), now.Add(time.Second))
+		if err != nil {
+			slog.Error("Error taking shared session to merge", "sessionId", duplicate.SessionID, "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		state, err := decodeState(data)
+		if err != nil {
+			slog.Error("Error decoding shared session to merge", "sessionId", duplicate.SessionID, "error", err)
+			continue
+		}
+		taken = append(taken, state)
+	}
+	if len(taken) == 0 {
+		return
+	}
+
+	err := t.store.Update(sessionKey(id.Tenant, id.SessionID), func(data []byte) ([]byte, time.Time, error) {
+		if data == nil {
+			// Ended meanwhile; the duplicates end with it
+			return nil, time.Time{}, nil
+		}
+		state, err := decodeState(data)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		for _, other := range taken {
+			state.merge(other)
+		}
+		next, err := encodeState(state)
+		return next, state.lastSeen, err
+	})
+	if err != nil {
+		slog.Error("Error merging shared sessions", "sessionId", id.SessionID, "error", err)
+		return
+	}
+	metrics.MergedSessions.WithLabelValues(id.Tenant).Add(float64(len(taken)))
+}
+
+// merge folds the earlier session other into s: totals add up, the session
+// started when the first did and the player state stays that of s, which
+// carries on
+func (s *State) merge(other *State) {
+	s.MergedSessionIDs = append(s.MergedSessionIDs, other.SessionID)
+	s.MergedSessionIDs = append(s.MergedSessionIDs, other.MergedSessionIDs...)
+	if other.StartedAt.Before(s.StartedAt) {
+		s.StartedAt = other.StartedAt
+	}
+	if other.LastEventAt.After(s.LastEventAt) {
+		s.LastEventAt = other.LastEventAt
+		s.LastEvent = other.LastEvent
+	}
+	s.CDN, s.EdgePOP, s.StreamType = cmp.Or(s.CDN, other.CDN), cmp.Or(s.EdgePOP, other.EdgePOP), cmp.Or(s.StreamType, other.StreamType)
+	s.EventCount += other.EventCount
+
+	s.WatchTimeSeconds += other.WatchTimeSeconds
+	s.UncountedSeconds += other.UncountedSeconds
+	s.SkippedSeconds += other.SkippedSeconds
+	s.PauseCount += other.PauseCount
+	s.SeekCount += other.SeekCount
+	if other.PlaybackStarted {
+		// Startup happened in the session the viewer started anonymously
+		s.StartupTimeSeconds = other.StartupTimeSeconds
+	}
+	s.PlaybackAttempted = s.PlaybackAttempted || other.PlaybackAttempted
+	s.PlaybackStarted = s.PlaybackStarted || other.PlaybackStarted
+	s.RebufferCount += other.RebufferCount
+	s.RebufferTimeSeconds += other.RebufferTimeSeconds
+	s.ErrorCount += other.ErrorCount
+	if s.LastError == "" {
+		s.LastError = other.LastError
+	}
+	s.Errors = slices.Concat(other.Errors, s.Errors)
+	slices.SortStableFunc(s.Errors, func(a, b PlayerError) int { return a.At.Compare(b.At) })
+	if len(s.Errors) > maxRecentErrors {
+		s.Errors = s.Errors[len(s.Errors)-maxRecentErrors:]
+	}
+
+	s.Ads.Starts += other.Ads.Starts
+	s.Ads.FirstQuartiles += other.Ads.FirstQuartiles
+	s.Ads.Midpoints += other.Ads.Midpoints
+	s.Ads.ThirdQuartiles += other.Ads.ThirdQuartiles
+	s.Ads.Completions += other.Ads.Completions
+	s.Ads.Errors += other.Ads.Errors
+	s.Ads.TimeSeconds += other.Ads.TimeSeconds
+
+	s.bitrateTime += other.bitrateTime
+	s.bitrateSum += other.bitrateSum
+	if samples := s.bitrateSamples + other.bitrateSamples; samples > 0 {
+		s.bitrateMean = (s.bitrateMean*float64(s.bitrateSamples) + other.bitrateMean*float64(other.bitrateSamples)) / float64(samples)
+		s.bitrateSamples = samples
+	}
+	if s.bitrateTime > 0 {
+		s.AverageBitrate = s.bitrateSum / s.bitrateTime
+	} else {
+		s.AverageBitrate = s.bitrateMean
+	}
+	s.latencySum += other.latencySum
+	s.latencySamples += other.latencySamples
+	if s.latencySamples > 0 {
+		s.LiveLatencySeconds = s.latencySum / float64(s.latencySamples)
+	}
+	s.Upshifts += other.Upshifts
+	s.Downshifts += other.Downshifts
+	s.mergeRenditions(other.renditions.times)
+
+	if other.lastSeen.After(s.lastSeen) {
+		s.lastSeen = other.lastSeen
+	}
+	if other.heartbeatSeen.After(s.heartbeatSeen) {
+		s.heartbeatSeen = other.heartbeatSeen
+	}
+	if other.LastHeartbeat.After(s.LastHeartbeat) {
+		s.LastHeartbeat = other.LastHeartbeat
+	}
+}
+
+// mergeRenditions adds the watch time of times to the renditions of s
+func (s *State) mergeRenditions(times []RenditionTime) {
+	r := &s.renditions
+	if len(r.times) == 0 && len(times) > 0 {
+		r.current = -1
+	}
+	for _, t := range times {
+		i := slices.IndexFunc(r.times, func(own RenditionTime) bool { return sameRendition(own.Rendition, t.Rendition) })
+		switch {
+		case i >= 0:
+			r.times[i].Seconds += t.Seconds
+		case len(r.times) < maxRenditions:
+			r.times = append(r.times, t)
+		}
+	}
+	if len(r.times) == 0 {
+		return
+	}
+	s.Renditions = slices.Clone(r.times)
+	slices.SortStableFunc(s.Renditions, func(a, b RenditionTime) int {
+		return compareRenditions(a.Rendition, b.Rendition)
+	})
+}
@@ -0,0 +1,151 @@
+package session
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// RenditionTime is the watch time a session spent at one rendition
+type RenditionTime struct {
+	models.Rendition
+	Seconds float64 `json:"seconds"`
+}
+
+// renditionTracker follows the rendition a session plays, from the quality
+// and bitrate its events report, matched against the quality ladder the
+// player sent when it did
+type renditionTracker struct {
+	ladder []models.Rendition
+	// current is the index in times of the rendition playing, -1 before
+	// the player reported one
+	current int
+	times   []RenditionTime
+}
+
+// observeRendition attributes watched seconds to the rendition played
+// until now, then switches to the rendition event reports, counting the
+// switch as an upshift or downshift
+func (s *State) observeRendition(event models.Event, watched float64) {
+	r := &s.renditions
+	if len(r.times) == 0 {
+		r.current = -1
+	}
+	if r.current >= 0 && watched > 0 {
+		r.times[r.current].Seconds += watched
+	}
+	if event.Technical != nil && len(event.Technical.Renditions) > 0 {
+		r.ladder = event.Technical.Renditions
+	}
+
+	if rendition, ok := r.resolve(event.PlaybackState); ok {
+		next := slices.IndexFunc(r.times, func(t RenditionTime) bool { return sameRendition(t.Rendition, rendition) })
+		if next < 0 && len(r.times) < maxRenditions {
+			r.times = append(r.times, RenditionTime{Rendition: rendition})
+			next = len(r.times) - 1
+		}
+		if next >= 0 && r.current >= 0 && next != r.current {
+			switch compareRenditions(rendition, r.times[r.current].Rendition) {
+			case 1:
+				s.Upshifts++
+			case -1:
+				s.Downshifts++
+			}
+		}
+		if next >= 0 {
+			r.current = next
+		}
+	}
+	if len(r.times) == 0 {
+		return
+	}
+
+	s.Renditions = slices.Clone(r.times)
+	slices.SortStableFunc(s.Renditions, func(a, b RenditionTime) int {
+		return compareRenditions(a.Rendition, b.Rendition)
+	})
+}
+
+// resolve returns the rendition of the quality and bitrate in p: the
+// ladder's rendition of that bitrate or label when there is one, otherwise
+// a rendition of what p reports
+func (r *renditionTracker) resolve(p *models.PlaybackState) (models.Rendition, bool) {
+	if p == nil || (p.Bitrate <= 0 && p.Quality == "") {
+		return models.Rendition{}, false
+	}
+	if p.Bitrate > 0 {
+		if i := closestBitrate(r.ladder, p.Bitrate); i >= 0 {
+			return r.ladder[i], true
+		}
+	}
+	if p.Quality != "" {
+		if i := slices.IndexFunc(r.ladder, func(l models.Rendition) bool { return l.Label == p.Quality }); i >= 0 {
+			return r.ladder[i], true
+		}
+	}
+
+	rendition := models.Rendition{Label: p.Quality, Bitrate: p.Bitrate, Height: heightFromLabel(p.Quality)}
+	if rendition.Label == "" {
+		rendition.Label = fmt.Sprintf("%.0fkbps", p.Bitrate/1000)
+	}
+	return rendition, true
+}
+
+// maxRenditions caps the renditions a session keeps time for; players
+// reporting measured rather than declared bitrates without a ladder could
+// otherwise add one per event. Time at renditions past the cap is counted
+// at the one played before.
+const maxRenditions = 32
+
+// maxBitrateDeviation is how far a reported bitrate may be from the one
+// the manifest declares, as a share of it, to be taken as that rendition.
+// Players report the declared bandwidth or a measured one close to it.
+const maxBitrateDeviation = 0.1
+
+// closestBitrate returns the index of the rendition of ladder whose bitrate
+// is within maxBitrateDeviation of bitrate, closest first, or -1
+func closestBitrate(ladder []models.Rendition, bitrate float64) int {
+	best, bestDeviation := -1, maxBitrateDeviation
+	for i, rendition := range ladder {
+		if rendition.Bitrate <= 0 {
+			continue
+		}
+		if deviation := math.Abs(bitrate-rendition.Bitrate) / rendition.Bitrate; deviation <= bestDeviation {
+			best, bestDeviation = i, deviation
+		}
+	}
+	return best
+}
+
+// heightFromLabel returns the height of quality labels such as 720p, or 0
+func heightFromLabel(label string) int {
+	var height int
+	if _, err := fmt.Sscanf(label, "%dp", &height); err != nil || height <= 0 {
+		return 0
+	}
+	return height
+}
+
+func sameRendition(a, b models.Rendition) bool {
+	return a.Label == b.Label && a.Bitrate == b.Bitrate && a.Height == b.Height
+}
+
+// compareRenditions orders renditions by bitrate, or by height when either
+// bitrate is unknown
+func compareRenditions(a, b models.Rendition) int {
+	if a.Bitrate > 0 && b.Bitrate > 0 && a.Bitrate != b.Bitrate {
+		if a.Bitrate > b.Bitrate {
+			return 1
+		}
+		return -1
+	}
+	switch {
+	case a.Height > b.Height:
+		return 1
+	case a.Height < b.Height:
+		return -1
+	}
+	return 0
+}
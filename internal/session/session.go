@@ -0,0 +1,324 @@
+package session
+
+import (
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// maxRecentErrors is how many player errors a session keeps for debugging
+const maxRecentErrors = 20
+
+// State is the aggregated state of one viewer session
+type State struct {
+	SessionID   string `json:"sessionId"`
+	Tenant      string `json:"tenant,omitempty"`
+	ClientID    string `json:"clientId"`
+	VideoID     string `json:"videoId"`
+	UserID      string `json:"userId,omitempty"`
+	AnonymousID string `json:"anonymousId,omitempty"`
+	// CDN is the CDN the player last reported delivering the video, and
+	// EdgePOP the point of presence of its edge server
+	CDN     string `json:"cdn,omitempty"`
+	EdgePOP string `json:"edgePop,omitempty"`
+	// StreamType is the stream type of the video, live, vod or dvr, as last
+	// reported with the session's events
+	StreamType string `json:"streamType,omitempty"`
+
+	StartedAt     time.Time `json:"startedAt"`
+	LastEventAt   time.Time `json:"lastEventAt"`
+	LastHeartbeat time.Time `json:"lastHeartbeat,omitzero"`
+	LastEvent     string    `json:"lastEvent"`
+	EventCount    int       `json:"eventCount"`
+
+	// WatchTimeSeconds is the time the viewer spent watching, see
+	// watchClock for how it is derived from the events
+	WatchTimeSeconds float64 `json:"watchTimeSeconds"`
+	// UncountedSeconds is time the player seemed to be playing that
+	// wasn't counted as watched: gaps between events the playhead didn't
+	// confirm, such as a background tab that stopped playback
+	UncountedSeconds float64 `json:"uncountedSeconds,omitempty"`
+	// SkippedSeconds is content jumped over by seeking ahead
+	SkippedSeconds      float64 `json:"skippedSeconds,omitempty"`
+	PauseCount          int     `json:"pauseCount"`
+	SeekCount           int     `json:"seekCount"`
+	StartupTimeSeconds  float64 `json:"startupTimeSeconds,omitempty"`
+	RebufferCount       int     `json:"rebufferCount"`
+	RebufferTimeSeconds float64 `json:"rebufferTimeSeconds"`
+	ErrorCount          int     `json:"errorCount"`
+	LastError           string  `json:"lastError,omitempty"`
+	Ended               bool    `json:"ended"`
+
+	// PlaybackAttempted is set once the player started loading or the
+	// viewer pressed play; PlaybackStarted once the first frame played
+	PlaybackAttempted bool `json:"playbackAttempted"`
+	PlaybackStarted   bool `json:"playbackStarted"`
+	// AverageBitrate is the playback bitrate reported by the player,
+	// weighted by the watch time spent at each bitrate
+	AverageBitrate float64 `json:"averageBitrate,omitempty"`
+	// Renditions is the watch time spent at each rendition played, lowest
+	// first, and Upshifts and Downshifts count the switches between them
+	// to a higher or lower bitrate
+	Renditions []RenditionTime `json:"renditions,omitempty"`
+	Upshifts   int             `json:"upshifts,omitempty"`
+	Downshifts int             `json:"downshifts,omitempty"`
+	// LiveLatencySeconds is the mean distance from the live edge reported
+	// by the player of a live stream
+	LiveLatencySeconds float64 `json:"liveLatencySeconds,omitempty"`
+
+	// Errors are the most recent player errors, oldest first
+	Errors []PlayerError `json:"errors,omitempty"`
+
+	// Ads counts the ads shown during the session
+	Ads AdStats `json:"ads,omitzero"`
+
+	// MergedSessionIDs are the sessions the viewer watched the video in
+	// before logging in, merged into this one, see Tracker.MergeIdentified
+	MergedSessionIDs []string `json:"mergedSessionIds,omitempty"`
+
+	// Player state machine, not part of the summary
+	watch          watchClock
+	bitrate        float64
+	bitrateTime    float64
+	bitrateSum     float64
+	bitrateMean    float64
+	bitrateSamples int
+	renditions     renditionTracker
+	latencySum     float64
+	latencySamples int
+	bufferingSince time.Time
+	loadStartedAt  time.Time
+	adStartedAt    time.Time
+	// prerollSeconds is ad time before the first frame of the content,
+	// which doesn't count towards its startup time
+	prerollSeconds float64
+	// lastSeen is the server time of the last event, used for expiry, and
+	// heartbeatSeen the server time of the last sign of playback
+	lastSeen      time.Time
+	heartbeatSeen time.Time
+}
+
+// AdStats counts the ad events of a session. Quartiles follow VAST:
+// FirstQuartiles counts ads that played a quarter of their duration,
+// Midpoints half and ThirdQuartiles three quarters; a fourth quartile is
+// counted by ad_complete.
+type AdStats struct {
+	Starts         int `json:"starts"`
+	FirstQuartiles int `json:"firstQuartiles"`
+	Midpoints      int `json:"midpoints"`
+	ThirdQuartiles int `json:"thirdQuartiles"`
+	Completions    int `json:"completions"`
+	Errors         int `json:"errors"`
+	// TimeSeconds is the time from ad_start to ad_complete or ad_error,
+	// which doesn't count as watch time
+	TimeSeconds float64 `json:"timeSeconds"`
+}
+
+// PlayerError is an error event reported by the player
+type PlayerError struct {
+	At      time.Time `json:"at"`
+	Message string    `json:"message"`
+}
+
+// snapshot returns a copy of the state that shares no memory with s
+func (s *State) snapshot() State {
+	c := *s
+	c.Errors = append([]PlayerError(nil), s.Errors...)
+	c.Renditions = append([]RenditionTime(nil), s.Renditions...)
+	c.MergedSessionIDs = append([]string(nil), s.MergedSessionIDs...)
+	return c
+}
+
+// apply folds one event into the session state. at is the event time.
+func (s *State) apply(event models.Event, at time.Time) {
+	if s.StartedAt.IsZero() || at.Before(s.StartedAt) {
+		s.StartedAt = at
+	}
+	if s.VideoID == "" {
+		s.VideoID = event.VideoID
+	}
+	if event.UserID != "" {
+		s.UserID = event.UserID
+	}
+	if event.AnonymousID != "" {
+		s.AnonymousID = event.AnonymousID
+	}
+	if cdn := event.CDN(); cdn != "" {
+		if cdn != s.CDN {
+			s.EdgePOP = ""
+		}
+		s.CDN = cdn
+	}
+	if pop := event.EdgePOP(); pop != "" {
+		s.EdgePOP = pop
+	}
+	if streamType := event.StreamType(); streamType != "" {
+		s.StreamType = streamType
+	}
+	if p := event.PlaybackState; p != nil && p.LiveLatency > 0 {
+		s.latencySum += p.LiveLatency
+		s.latencySamples++
+		s.LiveLatencySeconds = s.latencySum / float64(s.latencySamples)
+	}
+
+	// Time spent playing since the previous event counts as watch time,
+	// at the bitrate played until now
+	seeking := s.watch.seeking
+	interval := s.watch.observe(event, at)
+	s.WatchTimeSeconds += interval.watched
+	s.UncountedSeconds += interval.uncounted
+	s.SkippedSeconds += interval.skipped
+	if s.bitrate > 0 && interval.watched > 0 {
+		s.bitrateTime += interval.watched
+		s.bitrateSum += s.bitrate * interval.watched
+	}
+	s.observeBitrate(event)
+	s.observeRendition(event, interval.watched)
+
+	switch event.EventName {
+	case "playerInit":
+		if s.loadStartedAt.IsZero() {
+			s.loadStartedAt = at
+		}
+	case "loadstart", "play":
+		if s.loadStartedAt.IsZero() {
+			s.loadStartedAt = at
+		}
+		s.PlaybackAttempted = true
+	case "playing":
+		// Content playing means the ad is over, even if its end was lost
+		s.endAd(at)
+		if !s.PlaybackStarted {
+			s.PlaybackStarted = true
+			s.PlaybackAttempted = true
+			if !s.loadStartedAt.IsZero() {
+				s.StartupTimeSeconds = max(at.Sub(s.loadStartedAt).Seconds()-s.prerollSeconds, 0)
+			}
+		}
+		s.endBuffering(at)
+	case "timeupdate", "heartbeat":
+		s.LastHeartbeat = at
+		if s.watch.playing {
+			s.endBuffering(at)
+		}
+	case "waiting", "stalled":
+		// Buffering during startup or right after a seek isn't a rebuffer
+		if s.PlaybackStarted && !seeking && s.bufferingSince.IsZero() {
+			s.RebufferCount++
+			s.bufferingSince = at
+		}
+	case "seeking":
+		if !seeking {
+			s.SeekCount++
+		}
+	case "pause":
+		s.endBuffering(at)
+		s.PauseCount++
+	case "ended":
+		s.endBuffering(at)
+		s.Ended = true
+	case "error":
+		s.ErrorCount++
+		s.LastError = ErrorMessage(event)
+		s.Errors = append(s.Errors, PlayerError{At: at, Message: s.LastError})
+		if len(s.Errors) > maxRecentErrors {
+			s.Errors = s.Errors[len(s.Errors)-maxRecentErrors:]
+		}
+	case models.AdStart:
+		s.endAd(at)
+		s.endBuffering(at)
+		s.Ads.Starts++
+		s.adStartedAt = at
+	case models.AdQuartile:
+		if event.AdState != nil {
+			switch event.AdState.Quartile {
+			case 1:
+				s.Ads.FirstQuartiles++
+			case 2:
+				s.Ads.Midpoints++
+			case 3:
+				s.Ads.ThirdQuartiles++
+			}
+		}
+	case models.AdComplete:
+		s.Ads.Completions++
+		s.endAd(at)
+	case models.AdError:
+		// Ad failures don't fail the content, so they aren't player errors
+		s.Ads.Errors++
+		s.endAd(at)
+	}
+
+	s.EventCount++
+	s.LastEvent = event.EventName
+	if at.After(s.LastEventAt) {
+		s.LastEventAt = at
+	}
+}
+
+// observeBitrate tracks the bitrate reported with event. The average is
+// weighted by watch time; until any watch time accrues it is the mean of
+// the reported values.
+func (s *State) observeBitrate(event models.Event) {
+	if event.PlaybackState != nil && event.PlaybackState.Bitrate > 0 {
+		s.bitrate = event.PlaybackState.Bitrate
+		s.bitrateSamples++
+		s.bitrateMean += (s.bitrate - s.bitrateMean) / float64(s.bitrateSamples)
+	}
+
+	if s.bitrateTime > 0 {
+		s.AverageBitrate = s.bitrateSum / s.bitrateTime
+	} else {
+		s.AverageBitrate = s.bitrateMean
+	}
+}
+
+// endAd closes the interval of the ad playing
+func (s *State) endAd(at time.Time) {
+	if s.adStartedAt.IsZero() {
+		return
+	}
+	if d := at.Sub(s.adStartedAt).Seconds(); d > 0 {
+		s.Ads.TimeSeconds += d
+		if !s.PlaybackStarted {
+			s.prerollSeconds += d
+		}
+	}
+	s.adStartedAt = time.Time{}
+}
+
+// endBuffering closes an open rebuffering interval
+func (s *State) endBuffering(at time.Time) {
+	if s.bufferingSince.IsZero() {
+		return
+	}
+	if d := at.Sub(s.bufferingSince); d > 0 {
+		s.RebufferTimeSeconds += d.Seconds()
+	}
+	s.bufferingSince = time.Time{}
+}
+
+// ErrorMessage picks the most useful description of an error event
+func ErrorMessage(event models.Event) string {
+	if event.PlayerError != nil && event.PlayerError.Message != "" {
+		return event.PlayerError.Message
+	}
+	if event.CustomData != "" {
+		return event.CustomData
+	}
+	if event.PlaybackState != nil {
+		if msg, ok := event.PlaybackState.Extra["error"].(string); ok {
+			return msg
+		}
+	}
+	return event.EventName
+}
+
+// eventTime is the client timestamp of event, or fallback when it is
+// missing or unparseable
+func eventTime(event models.Event, fallback time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, event.Timestamp); err == nil {
+		return t
+	}
+	return fallback
+}
@@ -0,0 +1,276 @@
+package session
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// Store shares the state of active sessions between the collectors of a
+// deployment, so the events of a session may reach any of them. States
+// are stored as the tracker encodes them.
+type Store interface {
+	// Update calls fn with the state stored under key, nil when there is
+	// none, and replaces it with the state fn returns, last seen at
+	// lastSeen, or deletes it when fn returns nil. fn is called again when
+	// another collector changed the state in between.
+	Update(key string, fn func(state []byte) (next []byte, lastSeen time.Time, err error)) error
+	// Get returns the state stored under key
+	Get(key string) ([]byte, bool, error)
+	// All returns every stored state
+	All() ([][]byte, error)
+	// Expired returns the keys of the states last seen before cutoff
+	Expired(cutoff time.Time) ([]string, error)
+	// Take deletes the state stored under key if it is still last seen
+	// before cutoff and returns it, reporting false when it was seen
+	// since or another collector took it first
+	Take(key string, cutoff time.Time) ([]byte, bool, error)
+}
+
+// ShareState keeps the sessions in store instead of in memory. It must be
+// called before the tracker observes events. Sessions are then only ended
+// by their timeout or a pageUnload, not by Close, since other collectors
+// carry on with them.
+func (t *Tracker) ShareState(store Store) {
+	t.store = store
+}
+
+// observeShared folds the events of a batch into the shared states, one
+// update per session
+func (t *Tracker) observeShared(batch models.EventBatch, now time.Time) {
+	var order []string
+	bySession := make(map[string][]models.Event)
+	for _, event := range batch.Events {
+		if event.Category() != models.CategoryVideo {
+			continue
+		}
+		id := event.SessionID
+		if id == "" {
+			id = batch.SessionID
+		}
+		if id == "" {
+			continue
+		}
+		key := sessionKey(batch.Tenant, id)
+		if _, ok := bySession[key]; !ok {
+			order = append(order, key)
+		}
+		event.SessionID = id
+		bySession[key] = append(bySession[key], event)
+	}
+
+	var ended []State
+	var progress []Progress
+	var identities []Identity
+	for _, key := range order {
+		var sessionEnded []State
+		var sessionProgress []Progress
+		var sessionIdentities []Identity
+		err := t.store.Update(key, func(data []byte) ([]byte, time.Time, error) {
+			// Reset, as a conflicting update runs the events again
+			sessionEnded, sessionProgress, sessionIdentities = nil, nil, nil
+
+			var state *State
+			if data != nil {
+				var err error
+				if state, err = decodeState(data); err != nil {
+					return nil, time.Time{}, err
+				}
+			}
+			for _, event := range bySession[key] {
+				started := state == nil
+				if started {
+					state = &State{SessionID: event.SessionID, Tenant: batch.Tenant, ClientID: batch.ClientID}
+				}
+				before := *state
+				at := eventTime(event, now)
+				state.apply(event, at)
+				state.lastSeen = now
+				if id, ok := identified(before.UserID != "", state); ok {
+					sessionIdentities = append(sessionIdentities, id)
+				}
+				if p, changed := progressOf(&before, state, started, at); changed {
+					sessionProgress = append(sessionProgress, p)
+				}
+				if heartbeatEvents[event.EventName] {
+					state.heartbeatSeen = now
+				}
+				if event.EventName == "pageUnload" {
+					sessionEnded = append(sessionEnded, state.snapshot())
+					state = nil
+				}
+			}
+			if state == nil {
+				return nil, time.Time{}, nil
+			}
+			next, err := encodeState(state)
+			return next, now, err
+		})
+		if err != nil {
+			slog.Error("Error updating shared session", "sessionId", bySession[key][0].SessionID, "error", err)
+			continue
+		}
+		ended = append(ended, sessionEnded...)
+		progress = append(progress, sessionProgress...)
+		identities = append(identities, sessionIdentities...)
+	}
+
+	if len(progress) > 0 {
+		for _, fn := range t.onProgress {
+			fn(progress)
+		}
+	}
+	if t.mergeIdentified {
+		for _, id := range identities {
+			t.mergeSharedDuplicates(id, now)
+		}
+	}
+	t.identify(identities)
+	for _, state := range ended {
+		t.emit(state)
+	}
+}
+
+// expireShared ends the shared sessions inactive longer than the timeout
+// that this collector takes first
+func (t *Tracker) expireShared(now time.Time) {
+	cutoff := now.Add(-t.timeout)
+	keys, err := t.store.Expired(cutoff)
+	if err != nil {
+		slog.Error("Error listing expired shared sessions", "error", err)
+		return
+	}
+	for _, key := range keys {
+		data, ok, err := t.store.Take(key, cutoff)
+		if err != nil {
+			slog.Error("Error ending shared session", "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		state, err := decodeState(data)
+		if err != nil {
+			slog.Error("Error decoding shared session", "error", err)
+			continue
+		}
+		t.emit(*state)
+	}
+}
+
+// eachState calls fn with every active session. fn must not keep state.
+func (t *Tracker) eachState(fn func(state *State)) {
+	if t.store == nil {
+		t.mu.Lock()
+		for _, state := range t.sessions {
+			fn(state)
+		}
+		t.mu.Unlock()
+		return
+	}
+
+	states, err := t.store.All()
+	if err != nil {
+		slog.Error("Error reading shared sessions", "error", err)
+		return
+	}
+	for _, data := range states {
+		state, err := decodeState(data)
+		if err != nil {
+			// Ended by another collector between listing and reading
+			continue
+		}
+		fn(state)
+	}
+}
+
+// storedState is a State with its player state machine, which the summary
+// leaves out, as kept in a Store
+type storedState struct {
+	State
+	Machine storedMachine `json:"machine"`
+}
+
+type storedMachine struct {
+	Playing     bool      `json:"playing,omitempty"`
+	Seeking     bool      `json:"seeking,omitempty"`
+	ClockAt     time.Time `json:"clockAt,omitzero"`
+	Position    float64   `json:"position,omitempty"`
+	HasPosition bool      `json:"hasPosition,omitempty"`
+	Rate        float64   `json:"rate,omitempty"`
+
+	Bitrate        float64 `json:"bitrate,omitempty"`
+	BitrateTime    float64 `json:"bitrateTime,omitempty"`
+	BitrateSum     float64 `json:"bitrateSum,omitempty"`
+	BitrateMean    float64 `json:"bitrateMean,omitempty"`
+	BitrateSamples int     `json:"bitrateSamples,omitempty"`
+
+	Ladder           []models.Rendition `json:"ladder,omitempty"`
+	Rendition        int                `json:"rendition"`
+	RenditionSeconds []RenditionTime    `json:"renditionSeconds,omitempty"`
+
+	LatencySum     float64   `json:"latencySum,omitempty"`
+	LatencySamples int       `json:"latencySamples,omitempty"`
+	BufferingSince time.Time `json:"bufferingSince,omitzero"`
+	LoadStartedAt  time.Time `json:"loadStartedAt,omitzero"`
+	AdStartedAt    time.Time `json:"adStartedAt,omitzero"`
+	PrerollSeconds float64   `json:"prerollSeconds,omitempty"`
+	LastSeen       time.Time `json:"lastSeen"`
+	HeartbeatSeen  time.Time `json:"heartbeatSeen,omitzero"`
+}
+
+func encodeState(s *State) ([]byte, error) {
+	return json.Marshal(storedState{
+		State: *s,
+		Machine: storedMachine{
+			Playing:          s.watch.playing,
+			Seeking:          s.watch.seeking,
+			ClockAt:          s.watch.lastAt,
+			Position:         s.watch.position,
+			HasPosition:      s.watch.hasPosition,
+			Rate:             s.watch.rate,
+			Bitrate:          s.bitrate,
+			BitrateTime:      s.bitrateTime,
+			BitrateSum:       s.bitrateSum,
+			BitrateMean:      s.bitrateMean,
+			BitrateSamples:   s.bitrateSamples,
+			Ladder:           s.renditions.ladder,
+			Rendition:        s.renditions.current,
+			RenditionSeconds: s.renditions.times,
+			LatencySum:       s.latencySum,
+			LatencySamples:   s.latencySamples,
+			BufferingSince:   s.bufferingSince,
+			LoadStartedAt:    s.loadStartedAt,
+			AdStartedAt:      s.adStartedAt,
+			PrerollSeconds:   s.prerollSeconds,
+			LastSeen:         s.lastSeen,
+			HeartbeatSeen:    s.heartbeatSeen,
+		},
+	})
+}
+
+func decodeState(data []byte) (*State, error) {
+	var stored storedState
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	s, m := stored.State, stored.Machine
+	s.watch = watchClock{
+		playing:     m.Playing,
+		seeking:     m.Seeking,
+		lastAt:      m.ClockAt,
+		position:    m.Position,
+		hasPosition: m.HasPosition,
+		rate:        m.Rate,
+	}
+	s.bitrate, s.bitrateTime, s.bitrateSum = m.Bitrate, m.BitrateTime, m.BitrateSum
+	s.bitrateMean, s.bitrateSamples = m.BitrateMean, m.BitrateSamples
+	s.renditions = renditionTracker{ladder: m.Ladder, current: m.Rendition, times: m.RenditionSeconds}
+	s.latencySum, s.latencySamples = m.LatencySum, m.LatencySamples
+	s.bufferingSince, s.loadStartedAt, s.adStartedAt = m.BufferingSince, m.LoadStartedAt, m.AdStartedAt
+	s.prerollSeconds = m.PrerollSeconds
+	s.lastSeen, s.heartbeatSeen = m.LastSeen, m.HeartbeatSeen
+	return &s, nil
+}
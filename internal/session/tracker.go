@@ -0,0 +1,357 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+const (
+	// DefaultTimeout is how long a session may be inactive before it ends
+	DefaultTimeout = 30 * time.Minute
+	// SummaryEventName is the event name used for session summary records
+	SummaryEventName = "sessionSummary"
+)
+
+// Tracker maintains per-session state from incoming events and writes a
+// summary record to the sink when a session ends
+type Tracker struct {
+	mu       sync.Mutex
+	sessions map[string]*State
+	// store holds the sessions instead of sessions when they are shared
+	// with other collectors
+	store   Store
+	sink    sink.EventSink
+	timeout time.Duration
+	now     func() time.Time
+
+	// concurrencyWindow is how recent heartbeats of concurrent viewers
+	// are; gaugeTenants are the tenants last set in the gauge, only used
+	// by the run loop
+	concurrencyWindow time.Duration
+	gaugeTenants      map[string]int
+
+	// onEnd is called with the final state of every session that ends
+	onEnd []func(State)
+	// onProgress is called with what the events of each batch added
+	onProgress []func([]Progress)
+	// onIdentify is called with the sessions whose viewer logged in, and
+	// mergeIdentified merges the sessions they left behind into them
+	onIdentify      []func(Identity)
+	mergeIdentified bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewTracker creates a Tracker and starts its expiry loop. Summaries are
+// written to eventSink, which may be nil to keep state without emitting.
+// Viewers count as concurrent for concurrencyWindow after a heartbeat.
+func NewTracker(eventSink sink.EventSink, timeout, concurrencyWindow time.Duration) *Tracker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if concurrencyWindow <= 0 {
+		concurrencyWindow = DefaultConcurrencyWindow
+	}
+
+	t := &Tracker{
+		sessions:          make(map[string]*State),
+		sink:              eventSink,
+		timeout:           timeout,
+		now:               time.Now,
+		concurrencyWindow: concurrencyWindow,
+		stop:              make(chan struct{}),
+		done:              make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Observe folds a batch into the session states
+func (t *Tracker) Observe(batch models.EventBatch) {
+	now := t.now()
+	if t.store != nil {
+		t.observeShared(batch, now)
+		return
+	}
+
+	var ended []State
+	var progress []Progress
+	var identities []Identity
+	t.mu.Lock()
+	for _, event := range batch.Events {
+		// Page views and clicks aren't part of playback
+		if event.Category() != models.CategoryVideo {
+			continue
+		}
+		id := event.SessionID
+		if id == "" {
+			id = batch.SessionID
+		}
+		if id == "" {
+			continue
+		}
+
+		key := sessionKey(batch.Tenant, id)
+		state, ok := t.sessions[key]
+		if !ok {
+			state = &State{SessionID: id, Tenant: batch.Tenant, ClientID: batch.ClientID}
+			t.sessions[key] = state
+		}
+		var before State
+		if len(t.onProgress) > 0 {
+			before = *state
+		}
+		hadUser := state.UserID != ""
+		at := eventTime(event, now)
+		state.apply(event, at)
+		state.lastSeen = now
+		if len(t.onProgress) > 0 {
+			if p, changed := progressOf(&before, state, !ok, at); changed {
+				progress = append(progress, p)
+			}
+		}
+		// Merged sessions reported their progress already
+		if identity, ok := identified(hadUser, state); ok {
+			identities = append(identities, identity)
+			if t.mergeIdentified {
+				t.mergeDuplicates(key, identity)
+			}
+		}
+		if heartbeatEvents[event.EventName] {
+			state.heartbeatSeen = now
+		}
+
+		// The page going away ends the session immediately
+		if event.EventName == "pageUnload" {
+			ended = append(ended, state.snapshot())
+			delete(t.sessions, key)
+		}
+	}
+	t.mu.Unlock()
+
+	if len(progress) > 0 {
+		for _, fn := range t.onProgress {
+			fn(progress)
+		}
+	}
+	t.identify(identities)
+	for _, state := range ended {
+		t.emit(state)
+	}
+}
+
+// identify passes the sessions whose viewer logged in to the listeners
+func (t *Tracker) identify(identities []Identity) {
+	for _, id := range identities {
+		for _, fn := range t.onIdentify {
+			fn(id)
+		}
+	}
+}
+
+// sessionKey keeps equal session IDs of different tenants apart
+func sessionKey(tenant, id string) string {
+	return tenant + "\x00" + id
+}
+
+// Get returns a snapshot of an active session of tenant
+func (t *Tracker) Get(tenant, id string) (State, bool) {
+	if t.store != nil {
+		data, ok, err := t.store.Get(sessionKey(tenant, id))
+		if err != nil {
+			slog.Error("Error reading shared session", "sessionId", id, "error", err)
+		}
+		if !ok {
+			return State{}, false
+		}
+		state, err := decodeState(data)
+		if err != nil {
+			return State{}, false
+		}
+		return *state, true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.sessions[sessionKey(tenant, id)]
+	if !ok {
+		return State{}, false
+	}
+	return state.snapshot(), true
+}
+
+// Active returns snapshots of all active sessions, most recent first
+func (t *Tracker) Active() []State {
+	states := []State{}
+	t.eachState(func(state *State) {
+		states = append(states, state.snapshot())
+	})
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].LastEventAt.After(states[j].LastEventAt)
+	})
+	return states
+}
+
+func (t *Tracker) run() {
+	defer close(t.done)
+
+	interval := min(t.timeout/4, time.Minute)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	gauge := time.NewTicker(concurrencyInterval)
+	defer gauge.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.expire(t.now())
+		case <-gauge.C:
+			t.updateConcurrency(t.now())
+		}
+	}
+}
+
+// expire ends sessions that have been inactive longer than the timeout
+func (t *Tracker) expire(now time.Time) {
+	if t.store != nil {
+		t.expireShared(now)
+		return
+	}
+
+	var ended []State
+	t.mu.Lock()
+	for key, state := range t.sessions {
+		if now.Sub(state.lastSeen) >= t.timeout {
+			ended = append(ended, state.snapshot())
+			delete(t.sessions, key)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, state := range ended {
+		t.emit(state)
+	}
+}
+
+// Progress is what one event added to the totals of its session, so
+// viewing can be counted when it happened rather than when the session
+// ended
+type Progress struct {
+	Tenant  string
+	VideoID string
+	// At is the event's time
+	At time.Time
+	// Started is set by a session's first event, Played by its first
+	// frame, Ended by the video playing to the end and Errored by its
+	// first player error
+	Started bool
+	Played  bool
+	Ended   bool
+	Errored bool
+
+	WatchTimeSeconds    float64
+	RebufferTimeSeconds float64
+	Rebuffers           int
+}
+
+// progressOf returns what applying an event at at changed from before to
+// after, reporting false when it changed none of the totals of Progress
+func progressOf(before, after *State, started bool, at time.Time) (Progress, bool) {
+	p := Progress{
+		Tenant:              after.Tenant,
+		VideoID:             after.VideoID,
+		At:                  at,
+		Started:             started,
+		Played:              after.PlaybackStarted && !before.PlaybackStarted,
+		Ended:               after.Ended && !before.Ended,
+		Errored:             after.ErrorCount > 0 && before.ErrorCount == 0,
+		WatchTimeSeconds:    after.WatchTimeSeconds - before.WatchTimeSeconds,
+		RebufferTimeSeconds: after.RebufferTimeSeconds - before.RebufferTimeSeconds,
+		Rebuffers:           after.RebufferCount - before.RebufferCount,
+	}
+	changed := p.Started || p.Played || p.Ended || p.Errored ||
+		p.WatchTimeSeconds > 0 || p.RebufferTimeSeconds > 0 || p.Rebuffers > 0
+	return p, changed
+}
+
+// OnProgress registers fn to be called with what the events of each
+// observed batch added to their sessions. It must be called before the
+// tracker observes events.
+func (t *Tracker) OnProgress(fn func([]Progress)) {
+	t.onProgress = append(t.onProgress, fn)
+}
+
+// OnSessionEnd registers fn to be called with the final state of each
+// session that ends. It must be called before the tracker observes events.
+func (t *Tracker) OnSessionEnd(fn func(State)) {
+	t.onEnd = append(t.onEnd, fn)
+}
+
+// emit passes an ended session to the listeners and writes its summary
+// record to the sink
+func (t *Tracker) emit(state State) {
+	for _, fn := range t.onEnd {
+		fn(state)
+	}
+	if t.sink == nil {
+		return
+	}
+
+	summary, err := json.Marshal(state)
+	if err != nil {
+		slog.Error("Error encoding session summary", "sessionId", state.SessionID, "error", err)
+		return
+	}
+
+	batch := models.NewEventBatch(state.ClientID, "", state.SessionID, "summary-"+state.SessionID, []models.Event{{
+		EventName:   SummaryEventName,
+		VideoID:     state.VideoID,
+		Timestamp:   state.LastEventAt.UTC().Format(time.RFC3339Nano),
+		SessionID:   state.SessionID,
+		UserID:      state.UserID,
+		AnonymousID: state.AnonymousID,
+		CustomData:  string(summary),
+	}})
+	batch.Tenant = state.Tenant
+	// One summary per session, so its ID is the same however often it is
+	// written
+	batch.AssignEventIDs()
+	if err := t.sink.LogBatch(context.Background(), batch); err != nil {
+		slog.Error("Error writing session summary", "sessionId", state.SessionID, "error", err)
+	}
+}
+
+// Close stops the expiry loop and emits summaries for all open sessions,
+// unless they are shared. It must be called before the sink is closed.
+func (t *Tracker) Close() error {
+	close(t.stop)
+	<-t.done
+	if t.store != nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	remaining := make([]State, 0, len(t.sessions))
+	for _, state := range t.sessions {
+		remaining = append(remaining, state.snapshot())
+	}
+	t.sessions = make(map[string]*State)
+	t.mu.Unlock()
+
+	for _, state := range remaining {
+		t.emit(state)
+	}
+	return nil
+}
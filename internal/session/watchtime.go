@@ -0,0 +1,155 @@
+package session
+
+import (
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+const (
+	// maxPlayingGap caps how much time between two events counts as watch
+	// time when the playhead can't confirm it. Longer gaps usually mean the
+	// tab was backgrounded or events were lost.
+	maxPlayingGap = 30 * time.Second
+	// playheadTolerance is how far the playhead may drift from the wall
+	// clock before a jump is taken for a seek, at least this or a tenth of
+	// the interval
+	playheadTolerance = 2 * time.Second
+)
+
+// watchClock turns the player events of a session into watch time. Between
+// two events the viewer is credited the time the player was playing. When
+// both events report the playhead, its progress is what counts, so a
+// background tab that stopped playback or a gap without heartbeats is
+// credited only what actually played, and a playhead that jumped further
+// than the wall clock allows is a seek whose skipped content isn't watch
+// time. Without the playhead, intervals up to maxPlayingGap count in full.
+type watchClock struct {
+	playing bool
+	seeking bool
+
+	// lastAt is the time of the latest event, position the playhead it
+	// reported if hasPosition and rate the playback rate
+	lastAt      time.Time
+	position    float64
+	hasPosition bool
+	rate        float64
+}
+
+// watchInterval is what an event tells about the time since the previous one
+type watchInterval struct {
+	// watched is the time credited as watched
+	watched float64
+	// uncounted is the time the player was deemed playing but that the
+	// playhead or the gap cap didn't confirm
+	uncounted float64
+	// skipped is content jumped over by seeking ahead
+	skipped float64
+}
+
+// observe credits the interval ending at event and then updates the
+// clock's player state from it. Events older than the latest one are
+// credited nothing.
+func (c *watchClock) observe(event models.Event, at time.Time) watchInterval {
+	if !c.lastAt.IsZero() && at.Before(c.lastAt) {
+		c.update(event)
+		return watchInterval{}
+	}
+
+	position, hasPosition := playhead(event)
+	var interval watchInterval
+	if c.playing && !c.lastAt.IsZero() {
+		interval = c.credit(event.EventName, at, position, hasPosition)
+	} else if event.EventName == "seeking" && c.hasPosition && hasPosition {
+		// Seeking while paused skips content without watching it
+		interval.skipped = max(position-c.position, 0)
+	}
+
+	c.lastAt = at
+	if hasPosition {
+		c.position = position
+		c.hasPosition = true
+	}
+	if event.PlaybackState != nil && event.PlaybackState.PlaybackRate > 0 {
+		c.rate = event.PlaybackState.PlaybackRate
+	}
+	c.update(event)
+	return interval
+}
+
+// credit splits the wall time since the previous event, while playing, into
+// watched and uncounted time
+func (c *watchClock) credit(name string, at time.Time, position float64, hasPosition bool) watchInterval {
+	wall := at.Sub(c.lastAt).Seconds()
+	if wall <= 0 {
+		return watchInterval{}
+	}
+	capped := min(wall, maxPlayingGap.Seconds())
+	rate := c.rate
+	if rate <= 0 {
+		rate = 1
+	}
+
+	// Across a seek the playhead says where the viewer went, not what
+	// they watched
+	if !hasPosition || !c.hasPosition || c.seeking || isSeekEvent(name) {
+		interval := watchInterval{watched: capped, uncounted: wall - capped}
+		if hasPosition && c.hasPosition {
+			interval.skipped = max(position-c.position-capped*rate, 0)
+		}
+		return interval
+	}
+
+	advanced := (position - c.position) / rate
+	tolerance := max(playheadTolerance.Seconds(), wall/10)
+	switch {
+	case advanced > wall+tolerance:
+		// Seeked ahead without seek events
+		return watchInterval{
+			watched:   capped,
+			uncounted: wall - capped,
+			skipped:   position - c.position - capped*rate,
+		}
+	case advanced < -tolerance:
+		// Seeked back or restarted the video
+		return watchInterval{watched: capped, uncounted: wall - capped}
+	}
+	watched := min(max(advanced, 0), wall)
+	return watchInterval{watched: watched, uncounted: wall - watched}
+}
+
+// update moves the player state machine on for event
+func (c *watchClock) update(event models.Event) {
+	switch event.EventName {
+	case "playing":
+		c.playing = true
+		c.seeking = false
+	case "timeupdate", "heartbeat":
+		// The player reports whether it is paused, which catches pauses
+		// and background tabs whose pause event was lost
+		if event.PlaybackState != nil {
+			c.playing = !event.PlaybackState.Paused && !event.PlaybackState.Ended
+		}
+	case "seeking":
+		c.seeking = true
+	case "seeked":
+		c.seeking = false
+	case "waiting", "stalled", "pause", "ended", "error", "pageUnload", models.AdStart:
+		// Time spent on ads isn't time watching the content
+		c.playing = false
+	}
+}
+
+// isSeekEvent reports whether name marks the start or end of a seek
+func isSeekEvent(name string) bool {
+	return name == "seeking" || name == "seeked"
+}
+
+// playhead returns the position event reports, if any
+func playhead(event models.Event) (float64, bool) {
+	p := event.PlaybackState
+	if p == nil {
+		return 0, false
+	}
+	return p.CurrentTime, true
+}
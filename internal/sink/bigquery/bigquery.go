@@ -0,0 +1,400 @@
+package bigquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"google.golang.org/api/googleapi"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/tracing"
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.Flusher       = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+	_ sink.Backlogger    = (*Sink)(nil)
+)
+
+// ErrClosed is returned when a batch is logged after Close
+var ErrClosed = errors.New("bigquery sink is closed")
+
+// maxAppendBytes keeps append requests under the Storage Write API's limit
+// of 10 MB
+const maxAppendBytes = 8 << 20
+
+// Config configures the BigQuery sink
+type Config struct {
+	// Project is the Google Cloud project of the dataset. Credentials are
+	// Application Default Credentials.
+	Project string `yaml:"project"`
+	Dataset string `yaml:"dataset"`
+	Table   string `yaml:"table"`
+	// Location is where the dataset is created, e.g. US or europe-west1
+	Location string `yaml:"location"`
+
+	// BatchSize is the number of rows sent per append
+	BatchSize int `yaml:"batchSize"`
+	// FlushInterval is the longest rows wait before being appended
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// MaxPending caps rows kept in memory while BigQuery is unavailable
+	MaxPending int `yaml:"maxPending"`
+	// CreateTable creates the dataset and the day-partitioned events table
+	// at startup if they don't exist, and adds columns missing from a table
+	// created by an older version
+	CreateTable bool `yaml:"createTable"`
+	// PartitionExpiration deletes partitions this long after their day.
+	// Zero keeps them; it only applies to tables the sink creates.
+	PartitionExpiration time.Duration `yaml:"partitionExpiration"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		Dataset:       "video_analytics",
+		Table:         "video_events",
+		Location:      "US",
+		BatchSize:     5000,
+		FlushInterval: 5 * time.Second,
+		MaxPending:    100000,
+		CreateTable:   true,
+	}
+}
+
+// Sink batches events into appends to the default stream of a BigQuery
+// table through the Storage Write API. The default stream stores rows at
+// least once: rows of an append retried after a timeout may be stored
+// twice, and are told apart by event_id.
+type Sink struct {
+	cfg     Config
+	client  *bq.Client
+	writer  *managedwriter.Client
+	stream  *managedwriter.ManagedStream
+	encoder *rowEncoder
+
+	mu      sync.Mutex
+	pending []row
+	closed  bool
+
+	// appendMu serializes appends so rows are sent in order
+	appendMu sync.Mutex
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a BigQuery sink using Application Default Credentials and
+// starts its background flusher
+func New(ctx context.Context, cfg Config) (*Sink, error) {
+	defaults := DefaultConfig()
+	if cfg.Project == "" {
+		return nil, errors.New("bigquery project is required")
+	}
+	if cfg.Dataset == "" {
+		cfg.Dataset = defaults.Dataset
+	}
+	if cfg.Table == "" {
+		cfg.Table = defaults.Table
+	}
+	if cfg.Location == "" {
+		cfg.Location = defaults.Location
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaults.FlushInterval
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = defaults.MaxPending
+	}
+
+	encoder, err := newRowEncoder()
+	if err != nil {
+		return nil, err
+	}
+	client, err := bq.NewClient(ctx, cfg.Project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %w", err)
+	}
+	s := &Sink{
+		cfg:     cfg,
+		client:  client,
+		encoder: encoder,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if cfg.CreateTable {
+		if err := s.createTable(ctx); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	s.writer, err = managedwriter.NewClient(ctx, cfg.Project)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create BigQuery write client: %w", err)
+	}
+	s.stream, err = s.writer.NewManagedStream(ctx,
+		managedwriter.WithDestinationTable(managedwriter.TableParentFromParts(cfg.Project, cfg.Dataset, cfg.Table)),
+		managedwriter.WithType(managedwriter.DefaultStream),
+		managedwriter.WithSchemaDescriptor(encoder.descriptor),
+		managedwriter.EnableWriteRetries(true),
+	)
+	if err != nil {
+		s.writer.Close()
+		client.Close()
+		return nil, fmt.Errorf("failed to open BigQuery write stream: %w", err)
+	}
+
+	go s.run()
+	return s, nil
+}
+
+func (s *Sink) table() *bq.Table {
+	return s.client.Dataset(s.cfg.Dataset).Table(s.cfg.Table)
+}
+
+// createTable creates the dataset and table if they don't exist, and adds
+// the columns of tableSchema missing from an existing table
+func (s *Sink) createTable(ctx context.Context) error {
+	dataset := s.client.Dataset(s.cfg.Dataset)
+	if err := dataset.Create(ctx, &bq.DatasetMetadata{Location: s.cfg.Location}); err != nil && !isStatus(err, http.StatusConflict) {
+		return fmt.Errorf("failed to create BigQuery dataset: %w", err)
+	}
+
+	table := s.table()
+	metadata, err := table.Metadata(ctx)
+	if isStatus(err, http.StatusNotFound) {
+		err = table.Create(ctx, &bq.TableMetadata{
+			Schema: tableSchema,
+			TimePartitioning: &bq.TimePartitioning{
+				Type:       bq.DayPartitioningType,
+				Field:      partitionColumn,
+				Expiration: s.cfg.PartitionExpiration,
+			},
+			Clustering: &bq.Clustering{Fields: clusteringColumns},
+		})
+		if err != nil && !isStatus(err, http.StatusConflict) {
+			return fmt.Errorf("failed to create BigQuery table: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read BigQuery table: %w", err)
+	}
+
+	existing := make(map[string]bool, len(metadata.Schema))
+	for _, field := range metadata.Schema {
+		existing[field.Name] = true
+	}
+	schema := metadata.Schema
+	for _, field := range tableSchema {
+		if !existing[field.Name] {
+			// Columns added to an existing table can't be required
+			added := *field
+			added.Required = false
+			schema = append(schema, &added)
+		}
+	}
+	if len(schema) == len(metadata.Schema) {
+		return nil
+	}
+	if _, err := table.Update(ctx, bq.TableMetadataToUpdate{Schema: schema}, metadata.ETag); err != nil {
+		return fmt.Errorf("failed to migrate BigQuery table: %w", err)
+	}
+	return nil
+}
+
+// LogBatch buffers the batch's events for the next append
+func (s *Sink) LogBatch(_ context.Context, batch models.EventBatch) error {
+	receivedAt := time.Now()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	for _, record := range batch.Records() {
+		s.pending = append(s.pending, newRow(record, receivedAt))
+	}
+	full := len(s.pending) >= s.cfg.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		if err := s.Flush(); err != nil {
+			slog.Error("Error appending to BigQuery", "error", err)
+		}
+	}
+}
+
+// Flush appends all pending rows. Rows that fail to append are kept for the
+// next attempt, up to MaxPending.
+func (s *Sink) Flush() error {
+	s.appendMu.Lock()
+	defer s.appendMu.Unlock()
+
+	for {
+		s.mu.Lock()
+		n := min(len(s.pending), s.cfg.BatchSize)
+		rows := s.pending[:n:n]
+		s.pending = s.pending[n:]
+		s.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+
+		if err := s.append(rows); err != nil {
+			s.requeue(rows)
+			return err
+		}
+	}
+}
+
+// requeue puts rows that failed to append back at the front of the buffer,
+// dropping the oldest rows if the buffer is over MaxPending
+func (s *Sink) requeue(rows []row) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = append(rows, s.pending...)
+	if over := len(s.pending) - s.cfg.MaxPending; over > 0 {
+		slog.Warn("BigQuery buffer full, dropping events", "dropped", over)
+		s.pending = s.pending[over:]
+	}
+}
+
+// append sends rows in as few appends under maxAppendBytes as possible and
+// waits until BigQuery stored them. Appends run in the background, so
+// their spans start traces of their own.
+func (s *Sink) append(rows []row) (err error) {
+	ctx, span := tracing.Start(context.Background(), "bigquery.append", tracing.AttrEvents.Int(len(rows)))
+	defer func() { tracing.End(span, err) }()
+
+	var results []*managedwriter.AppendResult
+	var data [][]byte
+	size := 0
+	send := func() error {
+		result, err := s.stream.AppendRows(ctx, data)
+		if err != nil {
+			return fmt.Errorf("failed to append rows: %w", err)
+		}
+		results = append(results, result)
+		data, size = nil, 0
+		return nil
+	}
+	for _, r := range rows {
+		encoded, err := s.encoder.encode(r)
+		if err != nil {
+			return fmt.Errorf("failed to encode row: %w", err)
+		}
+		if size+len(encoded) > maxAppendBytes && len(data) > 0 {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		data = append(data, encoded)
+		size += len(encoded)
+	}
+	if len(data) > 0 {
+		if err := send(); err != nil {
+			return err
+		}
+	}
+
+	for _, result := range results {
+		if _, err := result.GetResult(ctx); err != nil {
+			return fmt.Errorf("failed to append rows: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close stops the flusher, appends any remaining rows and closes the
+// stream
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+	err := s.Flush()
+	if closeErr := s.stream.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to close BigQuery write stream: %w", closeErr)
+	}
+	s.writer.Close()
+	s.client.Close()
+	return err
+}
+
+// Backlog reports how many rows wait to be appended, out of MaxPending
+func (s *Sink) Backlog() (queued, capacity int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending), s.cfg.MaxPending
+}
+
+// CheckHealth reads the table's metadata and fails while rows are backed
+// up close to MaxPending
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	s.mu.Lock()
+	closed := s.closed
+	pending := len(s.pending)
+	s.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if pending >= s.cfg.MaxPending*9/10 {
+		return fmt.Errorf("%d rows waiting to be appended", pending)
+	}
+
+	if _, err := s.table().Metadata(ctx); err != nil {
+		return fmt.Errorf("failed to reach bigquery: %w", err)
+	}
+	return nil
+}
+
+// isStatus reports whether err is a Google API error with HTTP status code
+func isStatus(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
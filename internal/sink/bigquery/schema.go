@@ -0,0 +1,260 @@
+package bigquery
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bq "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// tableSchema is the table layout used by the sink, the columns of the
+// ClickHouse table with BigQuery types. Columns are only ever added to it,
+// at the end, so tables created by an older version can be migrated.
+var tableSchema = bq.Schema{
+	{Name: "event_id", Type: bq.StringFieldType},
+	{Name: "event_name", Type: bq.StringFieldType, Required: true},
+	{Name: "video_id", Type: bq.StringFieldType},
+	{Name: "session_id", Type: bq.StringFieldType},
+	{Name: "user_id", Type: bq.StringFieldType},
+	{Name: "anonymous_id", Type: bq.StringFieldType},
+	{Name: "client_id", Type: bq.StringFieldType},
+	{Name: "batch_id", Type: bq.StringFieldType},
+	{Name: "is_retry", Type: bq.BooleanFieldType},
+	{Name: "event_time", Type: bq.TimestampFieldType, Required: true},
+	{Name: "batch_time", Type: bq.TimestampFieldType},
+	{Name: "received_at", Type: bq.TimestampFieldType},
+	{Name: "current_time", Type: bq.FloatFieldType},
+	{Name: "duration", Type: bq.FloatFieldType},
+	{Name: "paused", Type: bq.BooleanFieldType},
+	{Name: "ended", Type: bq.BooleanFieldType},
+	{Name: "playback_rate", Type: bq.FloatFieldType},
+	{Name: "volume", Type: bq.FloatFieldType},
+	{Name: "muted", Type: bq.BooleanFieldType},
+	{Name: "fullscreen", Type: bq.BooleanFieldType},
+	{Name: "network_state", Type: bq.IntegerFieldType},
+	{Name: "ready_state", Type: bq.IntegerFieldType},
+	{Name: "live_latency", Type: bq.FloatFieldType},
+	{Name: "user_agent", Type: bq.StringFieldType},
+	{Name: "screen_resolution", Type: bq.StringFieldType},
+	{Name: "viewport_size", Type: bq.StringFieldType},
+	{Name: "player_size", Type: bq.StringFieldType},
+	{Name: "connection_type", Type: bq.StringFieldType},
+	{Name: "stream_type", Type: bq.StringFieldType},
+	{Name: "cdn", Type: bq.StringFieldType},
+	{Name: "edge_pop", Type: bq.StringFieldType},
+	{Name: "page_url", Type: bq.StringFieldType},
+	{Name: "referrer", Type: bq.StringFieldType},
+	{Name: "page_title", Type: bq.StringFieldType},
+	{Name: "custom_data", Type: bq.StringFieldType},
+	{Name: "is_bot", Type: bq.BooleanFieldType},
+	{Name: "bot_reason", Type: bq.StringFieldType},
+	{Name: "ad_id", Type: bq.StringFieldType},
+	{Name: "ad_creative_id", Type: bq.StringFieldType},
+	{Name: "ad_position", Type: bq.StringFieldType},
+	{Name: "ad_quartile", Type: bq.IntegerFieldType},
+	{Name: "ad_skippable", Type: bq.BooleanFieldType},
+	{Name: "event_category", Type: bq.StringFieldType},
+	{Name: "interaction_element", Type: bq.StringFieldType},
+	{Name: "interaction_label", Type: bq.StringFieldType},
+	{Name: "interaction_target_url", Type: bq.StringFieldType},
+	{Name: "content_title", Type: bq.StringFieldType},
+	{Name: "content_duration", Type: bq.FloatFieldType},
+	{Name: "content_category", Type: bq.StringFieldType},
+	{Name: "content_publisher", Type: bq.StringFieldType},
+}
+
+// partitionColumn partitions the table by day, and clusteringColumns order
+// the rows of a partition so queries of a video or session scan little
+var (
+	partitionColumn   = "event_time"
+	clusteringColumns = []string{"video_id", "session_id", "event_name"}
+)
+
+// row is one event as appended to the table. Its JSON field names are
+// the columns; timestamps are microseconds since the epoch, as the Storage
+// Write API takes them.
+type row struct {
+	EventID          string  `json:"event_id"`
+	EventName        string  `json:"event_name"`
+	VideoID          string  `json:"video_id"`
+	SessionID        string  `json:"session_id"`
+	UserID           string  `json:"user_id"`
+	AnonymousID      string  `json:"anonymous_id"`
+	ClientID         string  `json:"client_id"`
+	BatchID          string  `json:"batch_id"`
+	IsRetry          bool    `json:"is_retry"`
+	EventTime        int64   `json:"event_time"`
+	BatchTime        int64   `json:"batch_time"`
+	ReceivedAt       int64   `json:"received_at"`
+	CurrentTime      float64 `json:"current_time"`
+	Duration         float64 `json:"duration"`
+	Paused           bool    `json:"paused"`
+	Ended            bool    `json:"ended"`
+	PlaybackRate     float64 `json:"playback_rate"`
+	Volume           float64 `json:"volume"`
+	Muted            bool    `json:"muted"`
+	Fullscreen       bool    `json:"fullscreen"`
+	NetworkState     int64   `json:"network_state"`
+	ReadyState       int64   `json:"ready_state"`
+	LiveLatency      float64 `json:"live_latency"`
+	UserAgent        string  `json:"user_agent"`
+	ScreenResolution string  `json:"screen_resolution"`
+	ViewportSize     string  `json:"viewport_size"`
+	PlayerSize       string  `json:"player_size"`
+	ConnectionType   string  `json:"connection_type"`
+	StreamType       string  `json:"stream_type"`
+	CDN              string  `json:"cdn"`
+	EdgePOP          string  `json:"edge_pop"`
+	PageURL          string  `json:"page_url"`
+	Referrer         string  `json:"referrer"`
+	PageTitle        string  `json:"page_title"`
+	CustomData       string  `json:"custom_data"`
+	IsBot            bool    `json:"is_bot"`
+	BotReason        string  `json:"bot_reason"`
+	AdID             string  `json:"ad_id"`
+	AdCreativeID     string  `json:"ad_creative_id"`
+	AdPosition       string  `json:"ad_position"`
+	AdQuartile       int64   `json:"ad_quartile"`
+	AdSkippable      bool    `json:"ad_skippable"`
+
+	EventCategory        string `json:"event_category"`
+	InteractionElement   string `json:"interaction_element"`
+	InteractionLabel     string `json:"interaction_label"`
+	InteractionTargetURL string `json:"interaction_target_url"`
+
+	ContentTitle     string  `json:"content_title"`
+	ContentDuration  float64 `json:"content_duration"`
+	ContentCategory  string  `json:"content_category"`
+	ContentPublisher string  `json:"content_publisher"`
+}
+
+// newRow flattens a record into a table row
+func newRow(record models.EventRecord, receivedAt time.Time) row {
+	r := row{
+		EventID:     record.EventID,
+		EventName:   record.EventName,
+		VideoID:     record.VideoID,
+		SessionID:   record.SessionID,
+		UserID:      record.UserID,
+		AnonymousID: record.AnonymousID,
+		ClientID:    record.ClientID,
+		BatchID:     record.BatchID,
+		IsRetry:     record.IsRetry,
+		EventTime:   timestampMicros(record.Timestamp, receivedAt),
+		BatchTime:   timestampMicros(record.BatchTimestamp, receivedAt),
+		ReceivedAt:  timestampMicros(record.ReceivedAt, receivedAt),
+		CustomData:  record.CustomData,
+		IsBot:       record.IsBot,
+		BotReason:   record.BotReason,
+
+		EventCategory: record.Category(),
+	}
+
+	if p := record.PlaybackState; p != nil {
+		r.CurrentTime = p.CurrentTime
+		r.Duration = p.Duration
+		r.Paused = p.Paused
+		r.Ended = p.Ended
+		r.PlaybackRate = p.PlaybackRate
+		r.Volume = p.Volume
+		r.Muted = p.Muted
+		r.Fullscreen = p.Fullscreen
+		r.NetworkState = int64(p.NetworkState)
+		r.ReadyState = int64(p.ReadyState)
+		r.LiveLatency = p.LiveLatency
+	}
+	if t := record.Technical; t != nil {
+		r.UserAgent = t.UserAgent
+		r.ScreenResolution = t.ScreenResolution
+		r.ViewportSize = t.ViewportSize
+		r.PlayerSize = t.PlayerSize
+		r.ConnectionType = t.ConnectionType
+		r.StreamType = t.StreamType
+		r.CDN = t.CDN
+		r.EdgePOP = t.EdgePOP
+	}
+	if c := record.Context; c != nil {
+		r.PageURL = c.PageURL
+		r.Referrer = c.Referrer
+		r.PageTitle = c.PageTitle
+	}
+	if a := record.AdState; a != nil {
+		r.AdID = a.AdID
+		r.AdCreativeID = a.CreativeID
+		r.AdPosition = a.Position
+		r.AdQuartile = int64(a.Quartile)
+		r.AdSkippable = a.Skippable
+	}
+	if i := record.Interaction; i != nil {
+		r.InteractionElement = i.Element
+		r.InteractionLabel = i.Label
+		r.InteractionTargetURL = i.TargetURL
+	}
+	if c := record.Content; c != nil {
+		r.ContentTitle = c.Title
+		r.ContentDuration = c.Duration
+		r.ContentCategory = c.Category
+		r.ContentPublisher = c.Publisher
+	}
+	return r
+}
+
+// timestampMicros converts an RFC3339 timestamp to microseconds since the
+// epoch, falling back to fallback when the value is missing or malformed
+func timestampMicros(value string, fallback time.Time) int64 {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t = fallback
+	}
+	return t.UnixMicro()
+}
+
+// rowEncoder serializes rows into the protocol buffer messages the Storage
+// Write API appends, described by the message descriptor of tableSchema
+type rowEncoder struct {
+	message    protoreflect.MessageDescriptor
+	descriptor *descriptorpb.DescriptorProto
+}
+
+func newRowEncoder() (*rowEncoder, error) {
+	storageSchema, err := adapt.BQSchemaToStorageTableSchema(tableSchema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert table schema: %w", err)
+	}
+	descriptor, err := adapt.StorageSchemaToProto2Descriptor(storageSchema, "root")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build row descriptor: %w", err)
+	}
+	message, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("row descriptor is a %T, not a message", descriptor)
+	}
+	normalized, err := adapt.NormalizeDescriptor(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to normalize row descriptor: %w", err)
+	}
+	return &rowEncoder{message: message, descriptor: normalized}, nil
+}
+
+// encode serializes r through its JSON form, whose field names are the
+// columns of the message
+func (e *rowEncoder) encode(r row) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	message := dynamicpb.NewMessage(e.message)
+	if err := protojson.Unmarshal(data, message); err != nil {
+		return nil, fmt.Errorf("failed to convert row: %w", err)
+	}
+	return proto.Marshal(message)
+}
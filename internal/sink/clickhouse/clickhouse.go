@@ -0,0 +1,372 @@
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+	"github.com/adtyap26/event-stream-video/internal/tracing"
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.Flusher       = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+	_ sink.Backlogger    = (*Sink)(nil)
+	_ sink.Eraser        = (*Sink)(nil)
+)
+
+// ErrClosed is returned when a batch is logged after Close. It wraps
+// sink.ErrUnavailable.
+var ErrClosed = fmt.Errorf("clickhouse sink is closed: %w", sink.ErrUnavailable)
+
+// ErrFull is returned while MaxPending rows wait to be inserted. It wraps
+// sink.ErrUnavailable.
+var ErrFull = fmt.Errorf("clickhouse buffer is full: %w", sink.ErrUnavailable)
+
+// Config configures the ClickHouse sink
+type Config struct {
+	// URL is the ClickHouse HTTP interface, e.g. http://localhost:8123
+	URL      string `yaml:"url"`
+	Database string `yaml:"database"`
+	Table    string `yaml:"table"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// BatchSize is the number of rows sent per INSERT
+	BatchSize int `yaml:"batchSize"`
+	// FlushInterval is the longest rows wait before being inserted
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// MaxPending caps rows kept in memory, including those being inserted;
+	// batches are rejected with ErrFull beyond it
+	MaxPending int `yaml:"maxPending"`
+	// CreateTable creates the events table at startup if it doesn't exist
+	// and adds columns missing from a table created by an older version
+	CreateTable bool `yaml:"createTable"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		URL:           "http://localhost:8123",
+		Database:      "default",
+		Table:         "video_events",
+		BatchSize:     5000,
+		FlushInterval: 5 * time.Second,
+		MaxPending:    100000,
+		CreateTable:   true,
+	}
+}
+
+// Sink batches events into ClickHouse INSERTs over the HTTP interface
+type Sink struct {
+	cfg    Config
+	client *http.Client
+
+	mu      sync.Mutex
+	pending []row
+	// inserting counts the rows taken from pending by the running insert
+	inserting int
+	closed    bool
+
+	// insertMu serializes inserts so rows are sent in order
+	insertMu sync.Mutex
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a ClickHouse sink and starts its background flusher
+func New(cfg Config) (*Sink, error) {
+	defaults := DefaultConfig()
+	if cfg.URL == "" {
+		cfg.URL = defaults.URL
+	}
+	if cfg.Database == "" {
+		cfg.Database = defaults.Database
+	}
+	if cfg.Table == "" {
+		cfg.Table = defaults.Table
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaults.FlushInterval
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = defaults.MaxPending
+	}
+
+	s := &Sink{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 30 * time.Second},
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if cfg.CreateTable {
+		if err := s.exec(context.Background(), fmt.Sprintf(createTableSQL, s.tableName()), nil, nil); err != nil {
+			return nil, fmt.Errorf("failed to create ClickHouse table: %w", err)
+		}
+		if err := s.exec(context.Background(), fmt.Sprintf(migrateTableSQL, s.tableName()), nil, nil); err != nil {
+			return nil, fmt.Errorf("failed to migrate ClickHouse table: %w", err)
+		}
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// tableName is the fully qualified, quoted table name
+func (s *Sink) tableName() string {
+	return fmt.Sprintf("`%s`.`%s`", s.cfg.Database, s.cfg.Table)
+}
+
+// LogBatch buffers the batch's events for the next INSERT. Once MaxPending
+// rows are buffered it returns ErrFull instead, so the client sends the
+// batch again later rather than it being dead-lettered or dropped.
+func (s *Sink) LogBatch(_ context.Context, batch models.EventBatch) error {
+	receivedAt := time.Now()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	if len(s.pending)+s.inserting >= s.cfg.MaxPending {
+		s.mu.Unlock()
+		return ErrFull
+	}
+	for _, record := range batch.Records() {
+		s.pending = append(s.pending, newRow(record, receivedAt))
+	}
+	full := len(s.pending) >= s.cfg.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		if err := s.Flush(); err != nil {
+			slog.Error("Error inserting into ClickHouse", "error", err)
+		}
+	}
+}
+
+// Flush inserts all pending rows. Rows that fail to insert are kept for the
+// next attempt.
+func (s *Sink) Flush() error {
+	s.insertMu.Lock()
+	defer s.insertMu.Unlock()
+
+	for {
+		s.mu.Lock()
+		n := min(len(s.pending), s.cfg.BatchSize)
+		rows := s.pending[:n:n]
+		s.pending = s.pending[n:]
+		s.inserting = n
+		s.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+
+		err := s.insert(rows)
+		s.mu.Lock()
+		s.inserting = 0
+		if err != nil {
+			// LogBatch keeps pending and inserting rows within MaxPending,
+			// so nothing needs dropping to put them back
+			s.pending = append(rows, s.pending...)
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// insert sends rows in a single INSERT ... FORMAT JSONEachRow. Inserts
+// run in the background, so their spans start traces of their own.
+func (s *Sink) insert(rows []row) (err error) {
+	ctx, span := tracing.Start(context.Background(), "clickhouse.insert", tracing.AttrEvents.Int(len(rows)))
+	defer func() { tracing.End(span, err) }()
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, r := range rows {
+		if err := encoder.Encode(r); err != nil {
+			return fmt.Errorf("failed to encode row: %w", err)
+		}
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.tableName())
+	return s.exec(ctx, query, nil, &body)
+}
+
+// EraseUser drops the user's rows still waiting to be inserted and deletes
+// the stored ones with a mutation, waiting until every replica applied it.
+// Tenants have tables of their own, so tenant isn't needed to find the rows.
+func (s *Sink) EraseUser(ctx context.Context, tenant, userID string) (int64, error) {
+	// Keep the flusher from inserting rows of the user while they are erased
+	s.insertMu.Lock()
+	defer s.insertMu.Unlock()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return 0, ErrClosed
+	}
+	kept := s.pending[:0]
+	for _, r := range s.pending {
+		if r.UserID != userID {
+			kept = append(kept, r)
+		}
+	}
+	dropped := int64(len(s.pending) - len(kept))
+	clear(s.pending[len(kept):])
+	s.pending = kept
+	s.mu.Unlock()
+
+	params := url.Values{}
+	params.Set("param_userId", userID)
+	out, err := s.query(ctx, fmt.Sprintf("SELECT count() FROM %s WHERE user_id = {userId:String}", s.tableName()), params, nil)
+	if err != nil {
+		return dropped, fmt.Errorf("failed to count events: %w", err)
+	}
+	stored, err := strconv.ParseInt(string(bytes.TrimSpace(out)), 10, 64)
+	if err != nil {
+		return dropped, fmt.Errorf("failed to count events: %w", err)
+	}
+	if stored == 0 {
+		return dropped, nil
+	}
+
+	params.Set("mutations_sync", "2")
+	if err := s.exec(ctx, fmt.Sprintf("ALTER TABLE %s DELETE WHERE user_id = {userId:String}", s.tableName()), params, nil); err != nil {
+		return dropped, fmt.Errorf("failed to erase events: %w", err)
+	}
+	return dropped + stored, nil
+}
+
+// exec runs a query against the HTTP interface, discarding its output
+func (s *Sink) exec(ctx context.Context, query string, params url.Values, body io.Reader) error {
+	_, err := s.query(ctx, query, params, body)
+	return err
+}
+
+// query runs a query against the HTTP interface and returns its output.
+// params holds settings and query parameters; body, if given, is sent as
+// the query's data.
+func (s *Sink) query(ctx context.Context, query string, params url.Values, body io.Reader) ([]byte, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("query", query)
+	params.Set("database", s.cfg.Database)
+
+	if body == nil {
+		body = http.NoBody
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL+"/?"+params.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("clickhouse responded with %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Close stops the flusher and inserts any remaining rows
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
+
+// Backlog reports how many rows wait to be inserted, out of MaxPending
+func (s *Sink) Backlog() (queued, capacity int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending) + s.inserting, s.cfg.MaxPending
+}
+
+// CheckHealth pings the server and fails while rows are backed up close to
+// MaxPending
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	s.mu.Lock()
+	closed := s.closed
+	pending := len(s.pending) + s.inserting
+	s.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if pending >= s.cfg.MaxPending*9/10 {
+		return fmt.Errorf("%d rows waiting to be inserted", pending)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.URL+"/ping", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach clickhouse: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("clickhouse ping responded with %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package clickhouse
+
+import (
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// createTableSQL is the table layout used by the sink. Playback, technical
+// and context fields are flattened into columns so they can be aggregated
+// directly.
+const createTableSQL = `CREATE TABLE IF NOT EXISTS %s (
+	event_id          String,
+	event_name        LowCardinality(String),
+	event_category    LowCardinality(String),
+	video_id          String,
+	session_id        String,
+	user_id           String,
+	anonymous_id      String,
+	client_id         LowCardinality(String),
+	batch_id          String,
+	is_retry          UInt8,
+	event_time        DateTime64(3, 'UTC'),
+	batch_time        DateTime64(3, 'UTC'),
+	received_at       DateTime64(3, 'UTC'),
+	current_time      Float64,
+	duration          Float64,
+	paused            UInt8,
+	ended             UInt8,
+	playback_rate     Float64,
+	volume            Float64,
+	muted             UInt8,
+	fullscreen        UInt8,
+	network_state     Int32,
+	ready_state       Int32,
+	live_latency      Float64,
+	user_agent        String,
+	screen_resolution String,
+	viewport_size     String,
+	player_size       String,
+	connection_type   LowCardinality(String),
+	stream_type       LowCardinality(String),
+	cdn               LowCardinality(String),
+	edge_pop          LowCardinality(String),
+	page_url          String,
+	referrer          String,
+	page_title        String,
+	custom_data       String,
+	is_bot            UInt8,
+	bot_reason        LowCardinality(String),
+	ad_id             String,
+	ad_creative_id    String,
+	ad_position       LowCardinality(String),
+	ad_quartile       UInt8,
+	ad_skippable      UInt8,
+	interaction_element    String,
+	interaction_label      String,
+	interaction_target_url String,
+	content_title     String,
+	content_duration  Float64,
+	content_category  LowCardinality(String),
+	content_publisher LowCardinality(String)
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(event_time)
+ORDER BY (video_id, session_id, event_time)`
+
+// migrateTableSQL adds the columns introduced after a table was created
+const migrateTableSQL = `ALTER TABLE %s
+	ADD COLUMN IF NOT EXISTS event_id String FIRST,
+	ADD COLUMN IF NOT EXISTS is_bot UInt8,
+	ADD COLUMN IF NOT EXISTS bot_reason LowCardinality(String),
+	ADD COLUMN IF NOT EXISTS ad_id String,
+	ADD COLUMN IF NOT EXISTS ad_creative_id String,
+	ADD COLUMN IF NOT EXISTS ad_position LowCardinality(String),
+	ADD COLUMN IF NOT EXISTS ad_quartile UInt8,
+	ADD COLUMN IF NOT EXISTS ad_skippable UInt8,
+	ADD COLUMN IF NOT EXISTS live_latency Float64 AFTER ready_state,
+	ADD COLUMN IF NOT EXISTS stream_type LowCardinality(String) AFTER connection_type,
+	ADD COLUMN IF NOT EXISTS cdn LowCardinality(String) AFTER stream_type,
+	ADD COLUMN IF NOT EXISTS edge_pop LowCardinality(String) AFTER cdn,
+	ADD COLUMN IF NOT EXISTS event_category LowCardinality(String) DEFAULT 'video' AFTER event_name,
+	ADD COLUMN IF NOT EXISTS interaction_element String,
+	ADD COLUMN IF NOT EXISTS interaction_label String,
+	ADD COLUMN IF NOT EXISTS interaction_target_url String,
+	ADD COLUMN IF NOT EXISTS content_title String,
+	ADD COLUMN IF NOT EXISTS content_duration Float64,
+	ADD COLUMN IF NOT EXISTS content_category LowCardinality(String),
+	ADD COLUMN IF NOT EXISTS content_publisher LowCardinality(String)`
+
+// clickhouseTimeLayout is the DateTime64(3) text format
+const clickhouseTimeLayout = "2006-01-02 15:04:05.000"
+
+// row is one event as inserted with FORMAT JSONEachRow
+type row struct {
+	EventID          string  `json:"event_id"`
+	EventName        string  `json:"event_name"`
+	EventCategory    string  `json:"event_category"`
+	VideoID          string  `json:"video_id"`
+	SessionID        string  `json:"session_id"`
+	UserID           string  `json:"user_id"`
+	AnonymousID      string  `json:"anonymous_id"`
+	ClientID         string  `json:"client_id"`
+	BatchID          string  `json:"batch_id"`
+	IsRetry          uint8   `json:"is_retry"`
+	EventTime        string  `json:"event_time"`
+	BatchTime        string  `json:"batch_time"`
+	ReceivedAt       string  `json:"received_at"`
+	CurrentTime      float64 `json:"current_time"`
+	Duration         float64 `json:"duration"`
+	Paused           uint8   `json:"paused"`
+	Ended            uint8   `json:"ended"`
+	PlaybackRate     float64 `json:"playback_rate"`
+	Volume           float64 `json:"volume"`
+	Muted            uint8   `json:"muted"`
+	Fullscreen       uint8   `json:"fullscreen"`
+	NetworkState     int32   `json:"network_state"`
+	ReadyState       int32   `json:"ready_state"`
+	LiveLatency      float64 `json:"live_latency"`
+	UserAgent        string  `json:"user_agent"`
+	ScreenResolution string  `json:"screen_resolution"`
+	ViewportSize     string  `json:"viewport_size"`
+	PlayerSize       string  `json:"player_size"`
+	ConnectionType   string  `json:"connection_type"`
+	StreamType       string  `json:"stream_type"`
+	CDN              string  `json:"cdn"`
+	EdgePOP          string  `json:"edge_pop"`
+	PageURL          string  `json:"page_url"`
+	Referrer         string  `json:"referrer"`
+	PageTitle        string  `json:"page_title"`
+	CustomData       string  `json:"custom_data"`
+	IsBot            uint8   `json:"is_bot"`
+	BotReason        string  `json:"bot_reason"`
+	AdID             string  `json:"ad_id"`
+	AdCreativeID     string  `json:"ad_creative_id"`
+	AdPosition       string  `json:"ad_position"`
+	AdQuartile       uint8   `json:"ad_quartile"`
+	AdSkippable      uint8   `json:"ad_skippable"`
+
+	InteractionElement   string `json:"interaction_element"`
+	InteractionLabel     string `json:"interaction_label"`
+	InteractionTargetURL string `json:"interaction_target_url"`
+
+	ContentTitle     string  `json:"content_title"`
+	ContentDuration  float64 `json:"content_duration"`
+	ContentCategory  string  `json:"content_category"`
+	ContentPublisher string  `json:"content_publisher"`
+}
+
+// newRow flattens a record into a table row
+func newRow(record models.EventRecord, receivedAt time.Time) row {
+	r := row{
+		EventID:       record.EventID,
+		EventName:     record.EventName,
+		EventCategory: record.Category(),
+		VideoID:       record.VideoID,
+		SessionID:     record.SessionID,
+		UserID:        record.UserID,
+		AnonymousID:   record.AnonymousID,
+		ClientID:      record.ClientID,
+		BatchID:       record.BatchID,
+		IsRetry:       boolToUInt8(record.IsRetry),
+		EventTime:     formatTime(record.Timestamp, receivedAt),
+		BatchTime:     formatTime(record.BatchTimestamp, receivedAt),
+		ReceivedAt:    formatTime(record.ReceivedAt, receivedAt),
+		CustomData:    record.CustomData,
+		IsBot:         boolToUInt8(record.IsBot),
+		BotReason:     record.BotReason,
+	}
+
+	if p := record.PlaybackState; p != nil {
+		r.CurrentTime = p.CurrentTime
+		r.Duration = p.Duration
+		r.Paused = boolToUInt8(p.Paused)
+		r.Ended = boolToUInt8(p.Ended)
+		r.PlaybackRate = p.PlaybackRate
+		r.Volume = p.Volume
+		r.Muted = boolToUInt8(p.Muted)
+		r.Fullscreen = boolToUInt8(p.Fullscreen)
+		r.NetworkState = int32(p.NetworkState)
+		r.ReadyState = int32(p.ReadyState)
+		r.LiveLatency = p.LiveLatency
+	}
+	if t := record.Technical; t != nil {
+		r.UserAgent = t.UserAgent
+		r.ScreenResolution = t.ScreenResolution
+		r.ViewportSize = t.ViewportSize
+		r.PlayerSize = t.PlayerSize
+		r.ConnectionType = t.ConnectionType
+		r.StreamType = t.StreamType
+		r.CDN = t.CDN
+		r.EdgePOP = t.EdgePOP
+	}
+	if c := record.Context; c != nil {
+		r.PageURL = c.PageURL
+		r.Referrer = c.Referrer
+		r.PageTitle = c.PageTitle
+	}
+	if a := record.AdState; a != nil {
+		r.AdID = a.AdID
+		r.AdCreativeID = a.CreativeID
+		r.AdPosition = a.Position
+		r.AdQuartile = uint8(a.Quartile)
+		r.AdSkippable = boolToUInt8(a.Skippable)
+	}
+	if i := record.Interaction; i != nil {
+		r.InteractionElement = i.Element
+		r.InteractionLabel = i.Label
+		r.InteractionTargetURL = i.TargetURL
+	}
+	if c := record.Content; c != nil {
+		r.ContentTitle = c.Title
+		r.ContentDuration = c.Duration
+		r.ContentCategory = c.Category
+		r.ContentPublisher = c.Publisher
+	}
+	return r
+}
+
+// formatTime converts an RFC3339 timestamp to ClickHouse's format, falling
+// back to fallback when the value is missing or malformed
+func formatTime(value string, fallback time.Time) string {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t = fallback
+	}
+	return t.UTC().Format(clickhouseTimeLayout)
+}
+
+func boolToUInt8(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
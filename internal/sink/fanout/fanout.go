@@ -0,0 +1,273 @@
+// Package fanout writes every batch to several sinks, isolating them from
+// each other's failures so an outage of one, e.g. a message broker, doesn't
+// stop the others
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+const (
+	// DefaultQueueSize is how many batches may wait for an optional sink
+	// by default
+	DefaultQueueSize = 1000
+	// DefaultMaxAttempts is how often an optional sink is tried with a
+	// batch by default
+	DefaultMaxAttempts = 5
+	// maxBackoff caps the delay between attempts
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.Flusher       = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+	_ sink.Backlogger    = (*Sink)(nil)
+	_ sink.Eraser        = (*Sink)(nil)
+	_ sink.Querier       = (*Sink)(nil)
+)
+
+// Output is one of the sinks batches are written to
+type Output struct {
+	// Name identifies the output in logs and metrics, e.g. its sink type
+	Name string
+	Sink sink.EventSink
+	// Required outputs are written before LogBatch returns, and their
+	// failures fail it. Optional outputs are written in the background from
+	// a queue of their own.
+	Required bool
+	// QueueSize is how many batches may wait for an optional output; more
+	// are dropped
+	QueueSize int
+	// MaxAttempts is how often an optional output is tried with a batch
+	// before it is dropped
+	MaxAttempts int
+}
+
+// Sink writes batches to every output. Batches reach the optional outputs
+// only once every required output stored them, so replays of failed
+// batches don't reach them twice.
+type Sink struct {
+	required []Output
+	optional []*worker
+	wg       sync.WaitGroup
+}
+
+// worker writes the batches queued for an optional output
+type worker struct {
+	Output
+	queue chan models.EventBatch
+
+	// closing ends backoff waits, so Close tries each waiting batch once
+	// more instead of sleeping through its retries
+	closing chan struct{}
+}
+
+// New starts a worker for every optional output. At least one output must
+// be required, so a stored batch is somewhere durable.
+func New(outputs ...Output) (*Sink, error) {
+	s := &Sink{}
+	for _, output := range outputs {
+		if output.Required {
+			s.required = append(s.required, output)
+			continue
+		}
+		if output.QueueSize <= 0 {
+			output.QueueSize = DefaultQueueSize
+		}
+		if output.MaxAttempts <= 0 {
+			output.MaxAttempts = DefaultMaxAttempts
+		}
+		s.optional = append(s.optional, &worker{
+			Output:  output,
+			queue:   make(chan models.EventBatch, output.QueueSize),
+			closing: make(chan struct{}),
+		})
+	}
+	if len(s.required) == 0 {
+		return nil, errors.New("fanout needs at least one required sink")
+	}
+
+	for _, w := range s.optional {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			w.run()
+		}()
+	}
+	return s, nil
+}
+
+// LogBatch writes batch to the required outputs and queues it for the
+// optional ones. It fails when a required output fails.
+func (s *Sink) LogBatch(ctx context.Context, batch models.EventBatch) error {
+	var errs []error
+	for _, output := range s.required {
+		if err := output.Sink.LogBatch(ctx, batch); err != nil {
+			metrics.SinkOutputBatches.WithLabelValues(output.Name, "failed").Inc()
+			errs = append(errs, fmt.Errorf("%s: %w", output.Name, err))
+			continue
+		}
+		metrics.SinkOutputBatches.WithLabelValues(output.Name, "written").Inc()
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	for _, w := range s.optional {
+		select {
+		case w.queue <- batch:
+		default:
+			slog.Warn("Dropping batch for sink, queue is full", "sink", w.Name, "batchId", batch.BatchID,
+				"events", len(batch.Events))
+			metrics.SinkOutputBatches.WithLabelValues(w.Name, "dropped").Inc()
+		}
+	}
+	return nil
+}
+
+func (w *worker) run() {
+	for batch := range w.queue {
+		outcome := "written"
+		if err := w.write(batch); err != nil {
+			slog.Error("Error writing batch to sink, dropping it", "sink", w.Name, "batchId", batch.BatchID,
+				"events", len(batch.Events), "error", err)
+			outcome = "dropped"
+		}
+		metrics.SinkOutputBatches.WithLabelValues(w.Name, outcome).Inc()
+	}
+}
+
+// write tries the output with batch until it is stored or the attempts run
+// out. The writes outlive the request that queued the batch.
+func (w *worker) write(batch models.EventBatch) error {
+	ctx := context.Background()
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := w.Sink.LogBatch(ctx, batch)
+		if err == nil || attempt == w.MaxAttempts {
+			return err
+		}
+		metrics.SinkOutputBatches.WithLabelValues(w.Name, "failed").Inc()
+
+		select {
+		case <-time.After(backoff):
+		case <-w.closing:
+			// Shutting down: one last try, then give up
+			return w.Sink.LogBatch(ctx, batch)
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// outputs returns every output, required ones first
+func (s *Sink) outputs() []Output {
+	outputs := append([]Output(nil), s.required...)
+	for _, w := range s.optional {
+		outputs = append(outputs, w.Output)
+	}
+	return outputs
+}
+
+// Flush flushes every output that buffers writes. Batches still queued for
+// optional outputs are written by Close.
+func (s *Sink) Flush() error {
+	var errs []error
+	for _, output := range s.outputs() {
+		if flusher, ok := output.Sink.(sink.Flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", output.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckHealth checks the required outputs. Optional outputs being down
+// doesn't keep batches from being stored, so it doesn't fail readiness.
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	var errs []error
+	for _, output := range s.required {
+		if checker, ok := output.Sink.(sink.HealthChecker); ok {
+			if err := checker.CheckHealth(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", output.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Backlog reports the backlog of the required output whose queue is
+// fullest. Optional outputs drop batches instead of pushing back.
+func (s *Sink) Backlog() (queued, capacity int) {
+	for _, output := range s.required {
+		backlogger, ok := output.Sink.(sink.Backlogger)
+		if !ok {
+			continue
+		}
+		q, c := backlogger.Backlog()
+		if c > 0 && (capacity == 0 || float64(q)/float64(c) > float64(queued)/float64(capacity)) {
+			queued, capacity = q, c
+		}
+	}
+	return queued, capacity
+}
+
+// EraseUser erases from every output and returns how many events were
+// deleted in total. Outputs that can't erase are reported with
+// sink.ErrEraseUnsupported, since the user's events remain there.
+func (s *Sink) EraseUser(ctx context.Context, tenant, userID string) (int64, error) {
+	var total int64
+	var errs []error
+	for _, output := range s.outputs() {
+		eraser, ok := output.Sink.(sink.Eraser)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %w", output.Name, sink.ErrEraseUnsupported))
+			continue
+		}
+		n, err := eraser.EraseUser(ctx, tenant, userID)
+		total += n
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", output.Name, err))
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+// QueryEvents queries the first output that can, preferring required
+// outputs, which hold every stored batch
+func (s *Sink) QueryEvents(ctx context.Context, q sink.Query) (sink.QueryResult, error) {
+	for _, output := range s.outputs() {
+		if querier, ok := output.Sink.(sink.Querier); ok {
+			return querier.QueryEvents(ctx, q)
+		}
+	}
+	return sink.QueryResult{}, sink.ErrQueryUnsupported
+}
+
+// Close writes the batches still queued for optional outputs, without
+// waiting between attempts, and closes every output
+func (s *Sink) Close() error {
+	for _, w := range s.optional {
+		close(w.closing)
+		close(w.queue)
+	}
+	s.wg.Wait()
+
+	var errs []error
+	for _, output := range s.outputs() {
+		if err := output.Sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", output.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
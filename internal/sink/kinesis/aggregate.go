@@ -0,0 +1,63 @@
+package kinesis
+
+import (
+	"crypto/md5"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// kplMagic starts every record aggregated in the Kinesis Producer Library
+// format, which the Kinesis Client Library and Lambda's Kinesis event
+// source deaggregate
+var kplMagic = []byte{0xf3, 0x89, 0x9a, 0xc2}
+
+// kplOverhead is the bytes an aggregated record adds besides its entries:
+// the magic number, the partition key table and the MD5 checksum
+func kplOverhead(key string) int {
+	return len(kplMagic) + protowire.SizeTag(1) + protowire.SizeBytes(len(key)) + md5.Size
+}
+
+// kplEntrySize is the bytes data adds to an aggregated record
+func kplEntrySize(data []byte) int {
+	record := kplRecordSize(data)
+	return protowire.SizeTag(3) + protowire.SizeBytes(record)
+}
+
+func kplRecordSize(data []byte) int {
+	return protowire.SizeTag(1) + protowire.SizeVarint(0) + protowire.SizeTag(3) + protowire.SizeBytes(len(data))
+}
+
+// aggregateKPL packs the data of records sharing partition key into one
+// AggregatedRecord:
+//
+//	message AggregatedRecord {
+//	  repeated string partition_key_table = 1;
+//	  repeated string explicit_hash_key_table = 2;
+//	  repeated Record records = 3;
+//	}
+//	message Record {
+//	  required uint64 partition_key_index = 1;
+//	  optional uint64 explicit_hash_key_index = 2;
+//	  required bytes data = 3;
+//	}
+//
+// framed by the magic number and the MD5 of the message
+func aggregateKPL(key string, records [][]byte) []byte {
+	var message []byte
+	message = protowire.AppendTag(message, 1, protowire.BytesType)
+	message = protowire.AppendString(message, key)
+	for _, data := range records {
+		message = protowire.AppendTag(message, 3, protowire.BytesType)
+		message = protowire.AppendVarint(message, uint64(kplRecordSize(data)))
+		message = protowire.AppendTag(message, 1, protowire.VarintType)
+		message = protowire.AppendVarint(message, 0)
+		message = protowire.AppendTag(message, 3, protowire.BytesType)
+		message = protowire.AppendBytes(message, data)
+	}
+
+	sum := md5.Sum(message)
+	out := make([]byte, 0, len(kplMagic)+len(message)+len(sum))
+	out = append(out, kplMagic...)
+	out = append(out, message...)
+	return append(out, sum[:]...)
+}
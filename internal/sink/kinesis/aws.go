@@ -0,0 +1,149 @@
+package kinesis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
+	firehosetypes "github.com/aws/aws-sdk-go-v2/service/firehose/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	kinesistypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+func loadAWSConfig(ctx context.Context, cfg Config) (aws.Config, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return awsCfg, nil
+}
+
+// streamsService puts records to a Kinesis data stream with PutRecords
+type streamsService struct {
+	client *kinesis.Client
+	stream string
+}
+
+func newStreamsService(awsCfg aws.Config, cfg Config) *streamsService {
+	client := kinesis.NewFromConfig(awsCfg, func(o *kinesis.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+	})
+	return &streamsService{client: client, stream: cfg.Stream}
+}
+
+func (s *streamsService) put(ctx context.Context, entries []entry) ([]bool, error) {
+	records := make([]kinesistypes.PutRecordsRequestEntry, len(entries))
+	for i, e := range entries {
+		records[i] = kinesistypes.PutRecordsRequestEntry{Data: e.data, PartitionKey: aws.String(e.key)}
+	}
+	out, err := s.client.PutRecords(ctx, &kinesis.PutRecordsInput{
+		StreamName: aws.String(s.stream),
+		Records:    records,
+	})
+	if err != nil {
+		return nil, err
+	}
+	failed := make([]bool, len(entries))
+	for i, result := range out.Records {
+		if i < len(failed) && result.ErrorCode != nil {
+			failed[i] = true
+		}
+	}
+	return failed, nil
+}
+
+// retryable reports throttling of the stream's shards or its KMS key
+func (s *streamsService) retryable(err error) bool {
+	var throughput *kinesistypes.ProvisionedThroughputExceededException
+	var limit *kinesistypes.LimitExceededException
+	var kms *kinesistypes.KMSThrottlingException
+	var internal *kinesistypes.InternalFailureException
+	return errors.As(err, &throughput) || errors.As(err, &limit) || errors.As(err, &kms) || errors.As(err, &internal)
+}
+
+// limits of PutRecords: 500 records and 5 MiB per request, 1 MiB per
+// record including its partition key
+func (s *streamsService) limits() (requestRecords, requestBytes, recordBytes int) {
+	return 500, 5 << 20, 1 << 20
+}
+
+func (s *streamsService) check(ctx context.Context) error {
+	out, err := s.client.DescribeStreamSummary(ctx, &kinesis.DescribeStreamSummaryInput{StreamName: aws.String(s.stream)})
+	if err != nil {
+		return fmt.Errorf("failed to describe Kinesis stream %s: %w", s.stream, err)
+	}
+	if summary := out.StreamDescriptionSummary; summary != nil && !isActive(string(summary.StreamStatus)) {
+		return fmt.Errorf("kinesis stream %s is %s", s.stream, summary.StreamStatus)
+	}
+	return nil
+}
+
+// firehoseService puts records to a Firehose delivery stream with
+// PutRecordBatch
+type firehoseService struct {
+	client *firehose.Client
+	stream string
+}
+
+func newFirehoseService(awsCfg aws.Config, cfg Config) *firehoseService {
+	client := firehose.NewFromConfig(awsCfg, func(o *firehose.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+	})
+	return &firehoseService{client: client, stream: cfg.Stream}
+}
+
+func (s *firehoseService) put(ctx context.Context, entries []entry) ([]bool, error) {
+	records := make([]firehosetypes.Record, len(entries))
+	for i, e := range entries {
+		records[i] = firehosetypes.Record{Data: e.data}
+	}
+	out, err := s.client.PutRecordBatch(ctx, &firehose.PutRecordBatchInput{
+		DeliveryStreamName: aws.String(s.stream),
+		Records:            records,
+	})
+	if err != nil {
+		return nil, err
+	}
+	failed := make([]bool, len(entries))
+	for i, result := range out.RequestResponses {
+		if i < len(failed) && result.ErrorCode != nil {
+			failed[i] = true
+		}
+	}
+	return failed, nil
+}
+
+// retryable reports the delivery stream's throughput being exceeded
+func (s *firehoseService) retryable(err error) bool {
+	var unavailable *firehosetypes.ServiceUnavailableException
+	var limit *firehosetypes.LimitExceededException
+	return errors.As(err, &unavailable) || errors.As(err, &limit)
+}
+
+// limits of PutRecordBatch: 500 records and 4 MiB per request, 1000 KiB
+// per record
+func (s *firehoseService) limits() (requestRecords, requestBytes, recordBytes int) {
+	return 500, 4 << 20, 1000 << 10
+}
+
+func (s *firehoseService) check(ctx context.Context) error {
+	out, err := s.client.DescribeDeliveryStream(ctx, &firehose.DescribeDeliveryStreamInput{DeliveryStreamName: aws.String(s.stream)})
+	if err != nil {
+		return fmt.Errorf("failed to describe Firehose stream %s: %w", s.stream, err)
+	}
+	if d := out.DeliveryStreamDescription; d != nil && !isActive(string(d.DeliveryStreamStatus)) {
+		return fmt.Errorf("firehose stream %s is %s", s.stream, d.DeliveryStreamStatus)
+	}
+	return nil
+}
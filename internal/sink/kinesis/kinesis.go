@@ -0,0 +1,409 @@
+// Package kinesis puts events to an Amazon Kinesis data stream or a
+// Firehose delivery stream, one JSON record per event or aggregated
+package kinesis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+)
+
+// ErrClosed is returned when a batch is logged after Close
+var ErrClosed = errors.New("kinesis sink is closed")
+
+// Services selectable with Config.Service
+const (
+	// ServiceStreams puts records to a Kinesis data stream
+	ServiceStreams = "streams"
+	// ServiceFirehose puts records to a Firehose delivery stream
+	ServiceFirehose = "firehose"
+)
+
+// Partition keys selectable with Config.PartitionKey. Events of a key
+// land on the same shard, in order.
+const (
+	PartitionBySession = "sessionId"
+	PartitionByVideo   = "videoId"
+	PartitionByUser    = "userId"
+	PartitionByClient  = "clientId"
+	// PartitionRandom spreads events over the shards evenly
+	PartitionRandom = "random"
+)
+
+// Retries of throttled records back off exponentially between these
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
+// Config configures the Kinesis sink
+type Config struct {
+	// Service is streams for Kinesis Data Streams or firehose for Amazon
+	// Data Firehose
+	Service string `yaml:"service"`
+	// Stream is the name of the data stream or delivery stream
+	Stream string `yaml:"stream"`
+	Region string `yaml:"region"`
+	// Endpoint overrides the service endpoint, e.g. for LocalStack
+	Endpoint string `yaml:"endpoint"`
+
+	// PartitionKey is the event field records are sharded by: sessionId,
+	// videoId, userId, clientId or random. Events missing the field get a
+	// random key shared by their batch. Firehose ignores it.
+	PartitionKey string `yaml:"partitionKey"`
+	// Aggregate packs the events of a batch sharing a partition key into
+	// as few records as fit, in the Kinesis Producer Library format for
+	// data streams or newline-delimited for Firehose. Consumers of a data
+	// stream must deaggregate, as the KCL and Lambda do.
+	Aggregate bool `yaml:"aggregate"`
+
+	// MaxAttempts is how often records are put before a batch fails while
+	// the stream throttles them
+	MaxAttempts int `yaml:"maxAttempts"`
+	// PutTimeout bounds the puts of one batch, retries included
+	PutTimeout time.Duration `yaml:"putTimeout"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		Service:      ServiceStreams,
+		Stream:       "video-events",
+		PartitionKey: PartitionBySession,
+		Aggregate:    true,
+		MaxAttempts:  8,
+		PutTimeout:   30 * time.Second,
+	}
+}
+
+// Validate reports an unknown service or partition key
+func (c Config) Validate() error {
+	switch c.Service {
+	case "", ServiceStreams, ServiceFirehose:
+	default:
+		return fmt.Errorf("unknown kinesis service %q, must be %s or %s", c.Service, ServiceStreams, ServiceFirehose)
+	}
+	switch c.PartitionKey {
+	case "", PartitionBySession, PartitionByVideo, PartitionByUser, PartitionByClient, PartitionRandom:
+	default:
+		return fmt.Errorf("unknown kinesis partition key %q", c.PartitionKey)
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("invalid kinesis maxAttempts %d", c.MaxAttempts)
+	}
+	return nil
+}
+
+// entry is one record to put
+type entry struct {
+	key  string
+	data []byte
+}
+
+// service puts records to one of the two APIs
+type service interface {
+	// put sends entries in one request and reports which of them failed
+	// and can be put again. A request rejected as a whole is retried when
+	// retryable reports true for its error.
+	put(ctx context.Context, entries []entry) (failed []bool, err error)
+	retryable(err error) bool
+	// limits are the most records and bytes a request and a record hold
+	limits() (requestRecords, requestBytes, recordBytes int)
+	check(ctx context.Context) error
+}
+
+// Sink puts every event of a batch and returns once all of them are
+// stored, so a batch LogBatch accepted is in the stream. Records the
+// stream throttles are put again with exponential backoff; a batch that
+// still fails may have been partly stored.
+type Sink struct {
+	cfg     Config
+	service service
+
+	// mu keeps Close from returning under a batch being put
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New creates a sink using the default AWS credential chain
+func New(ctx context.Context, cfg Config) (*Sink, error) {
+	defaults := DefaultConfig()
+	if cfg.Service == "" {
+		cfg.Service = defaults.Service
+	}
+	if cfg.Stream == "" {
+		cfg.Stream = defaults.Stream
+	}
+	if cfg.PartitionKey == "" {
+		cfg.PartitionKey = defaults.PartitionKey
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaults.MaxAttempts
+	}
+	if cfg.PutTimeout <= 0 {
+		cfg.PutTimeout = defaults.PutTimeout
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	awsCfg, err := loadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sink{cfg: cfg}
+	if cfg.Service == ServiceFirehose {
+		s.service = newFirehoseService(awsCfg, cfg)
+	} else {
+		s.service = newStreamsService(awsCfg, cfg)
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, cfg.PutTimeout)
+	defer cancel()
+	if err := s.service.check(checkCtx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LogBatch puts the batch's events and returns once all of them are stored
+func (s *Sink) LogBatch(ctx context.Context, batch models.EventBatch) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	entries, err := s.entries(batch)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.PutTimeout)
+	defer cancel()
+	return s.putAll(ctx, entries)
+}
+
+// entries encodes the batch's events into records, aggregated when
+// configured
+func (s *Sink) entries(batch models.EventBatch) ([]entry, error) {
+	_, _, recordBytes := s.service.limits()
+	firehose := s.cfg.Service == ServiceFirehose
+
+	// Events without a key share one per batch, so they can still be
+	// aggregated
+	random := uuid.NewString()
+	records := batch.Records()
+	entries := make([]entry, 0, len(records))
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode event: %w", err)
+		}
+		// Firehose concatenates records into its objects and has no
+		// partition keys
+		key := ""
+		if firehose {
+			data = append(data, '\n')
+		} else {
+			key = s.partitionKey(record, random)
+		}
+		if len(data)+len(key) > recordBytes {
+			return nil, fmt.Errorf("event %s of %d bytes is over the %d byte record limit", record.EventID, len(data), recordBytes)
+		}
+		entries = append(entries, entry{key: key, data: data})
+	}
+
+	if !s.cfg.Aggregate || len(entries) < 2 {
+		return entries, nil
+	}
+	if firehose {
+		return concatenate(entries, recordBytes), nil
+	}
+	return aggregate(entries, recordBytes), nil
+}
+
+// partitionKey is the record's value of the configured field, or random
+// when it has none
+func (s *Sink) partitionKey(record models.EventRecord, random string) string {
+	var key string
+	switch s.cfg.PartitionKey {
+	case PartitionBySession:
+		key = record.SessionID
+		if key == "" {
+			key = record.BatchSessionID
+		}
+	case PartitionByVideo:
+		key = record.VideoID
+	case PartitionByUser:
+		key = record.UserID
+		if key == "" {
+			key = record.AnonymousID
+		}
+	case PartitionByClient:
+		key = record.ClientID
+	}
+	if key == "" {
+		return random
+	}
+	// Kinesis takes partition keys of up to 256 characters
+	if len(key) > 256 {
+		key = key[:256]
+	}
+	return key
+}
+
+// concatenate packs consecutive Firehose records into records of up to
+// recordBytes
+func concatenate(entries []entry, recordBytes int) []entry {
+	var out []entry
+	for _, e := range entries {
+		if n := len(out); n > 0 && len(out[n-1].data)+len(e.data) <= recordBytes {
+			out[n-1].data = append(out[n-1].data, e.data...)
+			continue
+		}
+		out = append(out, entry{key: e.key, data: append([]byte(nil), e.data...)})
+	}
+	return out
+}
+
+// aggregate packs the records of each partition key into KPL aggregated
+// records of up to recordBytes, keeping the order of each key's events.
+// Keys with a single record are put as they are.
+func aggregate(entries []entry, recordBytes int) []entry {
+	var keys []string
+	groups := make(map[string][][]byte)
+	for _, e := range entries {
+		if _, ok := groups[e.key]; !ok {
+			keys = append(keys, e.key)
+		}
+		groups[e.key] = append(groups[e.key], e.data)
+	}
+
+	out := make([]entry, 0, len(keys))
+	for _, key := range keys {
+		records := groups[key]
+		flush := func(part [][]byte) {
+			if len(part) == 1 {
+				out = append(out, entry{key: key, data: part[0]})
+				return
+			}
+			out = append(out, entry{key: key, data: aggregateKPL(key, part)})
+		}
+
+		limit := recordBytes - len(key)
+		size := kplOverhead(key)
+		start := 0
+		for i, data := range records {
+			if n := kplEntrySize(data); size+n > limit && i > start {
+				flush(records[start:i])
+				start, size = i, kplOverhead(key)
+			}
+			size += kplEntrySize(data)
+		}
+		flush(records[start:])
+	}
+	return out
+}
+
+// putAll puts entries in requests within the service's limits, putting
+// the throttled and failed ones again with backoff until all are stored
+// or MaxAttempts is reached
+func (s *Sink) putAll(ctx context.Context, entries []entry) error {
+	requestRecords, requestBytes, _ := s.service.limits()
+	backoff := minBackoff
+	for attempt := 1; ; attempt++ {
+		var retry []entry
+		var lastErr error
+		for start := 0; start < len(entries); {
+			end, size := start, 0
+			for end < len(entries) && end-start < requestRecords {
+				n := len(entries[end].data) + len(entries[end].key)
+				if end > start && size+n > requestBytes {
+					break
+				}
+				size += n
+				end++
+			}
+			chunk := entries[start:end]
+			start = end
+
+			failed, err := s.service.put(ctx, chunk)
+			if err != nil {
+				if !s.service.retryable(err) {
+					return fmt.Errorf("failed to put records to %s: %w", s.cfg.Stream, err)
+				}
+				retry, lastErr = append(retry, chunk...), err
+				continue
+			}
+			for i, f := range failed {
+				if f {
+					retry = append(retry, chunk[i])
+				}
+			}
+		}
+		if len(retry) == 0 {
+			return nil
+		}
+
+		metrics.KinesisRetriedRecords.WithLabelValues(s.cfg.Service).Add(float64(len(retry)))
+		if attempt >= s.cfg.MaxAttempts {
+			if lastErr != nil {
+				return fmt.Errorf("%d records of %s still throttled after %d attempts: %w", len(retry), s.cfg.Stream, attempt, lastErr)
+			}
+			return fmt.Errorf("%d records of %s still failed after %d attempts", len(retry), s.cfg.Stream, attempt)
+		}
+		// Full jitter keeps collectors throttled together from retrying
+		// together
+		timer := time.NewTimer(rand.N(backoff) + time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("timed out putting %d records to %s: %w", len(retry), s.cfg.Stream, ctx.Err())
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxBackoff)
+		entries = retry
+	}
+}
+
+// CheckHealth reports whether the stream exists and accepts records
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	return s.service.check(ctx)
+}
+
+// Close waits for batches being put. The AWS clients hold no connections
+// that need closing.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// isActive reports whether a stream status accepts records
+func isActive(status string) bool {
+	return strings.EqualFold(status, "ACTIVE") || strings.EqualFold(status, "UPDATING")
+}
@@ -0,0 +1,286 @@
+// Package natsjs publishes events to NATS JetStream, one message per event
+// on the subject <prefix>.<tenant>.<eventName>
+package natsjs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+)
+
+// ErrClosed is returned when a batch is logged after Close
+var ErrClosed = errors.New("nats sink is closed")
+
+// Config configures the NATS JetStream sink
+type Config struct {
+	// URL lists the NATS servers, comma separated, e.g. nats://localhost:4222
+	URL string `yaml:"url"`
+	// CredentialsFile is a .creds file holding the user JWT and NKey seed
+	CredentialsFile string `yaml:"credentialsFile"`
+	// Token authenticates with a token instead of credentials
+	Token string `yaml:"token"`
+
+	// SubjectPrefix is the first token of every subject
+	SubjectPrefix string `yaml:"subjectPrefix"`
+	// Stream is the JetStream stream capturing <prefix>.>
+	Stream string `yaml:"stream"`
+	// CreateStream creates the stream at startup, or updates it to the
+	// settings below. When false the stream must already exist.
+	CreateStream bool `yaml:"createStream"`
+	// Storage is file or memory
+	Storage  string `yaml:"storage"`
+	Replicas int    `yaml:"replicas"`
+	// MaxAge and MaxBytes limit what the stream keeps; zero is unlimited
+	MaxAge   time.Duration `yaml:"maxAge"`
+	MaxBytes int64         `yaml:"maxBytes"`
+	// DuplicateWindow is how long JetStream remembers message IDs, so that
+	// events published again by a retry within it are stored once
+	DuplicateWindow time.Duration `yaml:"duplicateWindow"`
+
+	// PublishTimeout bounds the wait for the acknowledgements of a batch
+	PublishTimeout time.Duration `yaml:"publishTimeout"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		URL:             nats.DefaultURL,
+		SubjectPrefix:   "events",
+		Stream:          "EVENTS",
+		CreateStream:    true,
+		Storage:         "file",
+		Replicas:        1,
+		DuplicateWindow: 2 * time.Minute,
+		PublishTimeout:  10 * time.Second,
+	}
+}
+
+// ParseStorage maps a storage name to its JetStream type; "" is file
+func ParseStorage(name string) (jetstream.StorageType, error) {
+	switch strings.ToLower(name) {
+	case "", "file":
+		return jetstream.FileStorage, nil
+	case "memory":
+		return jetstream.MemoryStorage, nil
+	}
+	return 0, fmt.Errorf("unknown JetStream storage %q, must be file or memory", name)
+}
+
+// Sink publishes every event of a batch and waits until JetStream has
+// acknowledged all of them, so a batch that LogBatch accepted is stored in
+// the stream. A failed batch may have been partly stored; publishing it
+// again within the duplicate window stores each event once.
+type Sink struct {
+	cfg Config
+	nc  *nats.Conn
+	js  jetstream.JetStream
+
+	// mu keeps Close from closing the connection under a publishing batch
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New connects to NATS and makes sure the stream exists
+func New(cfg Config) (*Sink, error) {
+	defaults := DefaultConfig()
+	if cfg.URL == "" {
+		cfg.URL = defaults.URL
+	}
+	if cfg.SubjectPrefix == "" {
+		cfg.SubjectPrefix = defaults.SubjectPrefix
+	}
+	if cfg.Stream == "" {
+		cfg.Stream = defaults.Stream
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = defaults.Replicas
+	}
+	if cfg.DuplicateWindow <= 0 {
+		cfg.DuplicateWindow = defaults.DuplicateWindow
+	}
+	if cfg.PublishTimeout <= 0 {
+		cfg.PublishTimeout = defaults.PublishTimeout
+	}
+	storage, err := ParseStorage(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []nats.Option{
+		nats.Name("event-stream-video"),
+		// Keep reconnecting; batches fail meanwhile and go to the dead
+		// letter queue
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			slog.Warn("Disconnected from NATS", "error", err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			slog.Info("Reconnected to NATS", "url", nc.ConnectedUrlRedacted())
+		}),
+	}
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredentialsFile))
+	}
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(cfg.Token))
+	}
+	nc, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to open JetStream: %w", err)
+	}
+
+	s := &Sink{cfg: cfg, nc: nc, js: js}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.PublishTimeout)
+	defer cancel()
+	if cfg.CreateStream {
+		_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:       cfg.Stream,
+			Subjects:   []string{cfg.SubjectPrefix + ".>"},
+			Storage:    storage,
+			Replicas:   cfg.Replicas,
+			MaxAge:     cfg.MaxAge,
+			MaxBytes:   cfg.MaxBytes,
+			Duplicates: cfg.DuplicateWindow,
+		})
+		if err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("failed to create JetStream stream %s: %w", cfg.Stream, err)
+		}
+	} else if _, err := js.Stream(ctx, cfg.Stream); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("failed to find JetStream stream %s: %w", cfg.Stream, err)
+	}
+	return s, nil
+}
+
+// LogBatch publishes one message per event and returns once all of them
+// are acknowledged
+func (s *Sink) LogBatch(ctx context.Context, batch models.EventBatch) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	// Publishes made while reconnecting are buffered but can't be
+	// acknowledged in time; fail the batch at once instead
+	if !s.nc.IsConnected() {
+		return fmt.Errorf("not connected to NATS: %s", s.nc.Status())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.PublishTimeout)
+	defer cancel()
+
+	records := batch.Records()
+	acks := make([]jetstream.PubAckFuture, 0, len(records))
+	for i, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		msg := nats.NewMsg(s.subject(batch.Tenant, record.EventName))
+		msg.Data = data
+		var opts []jetstream.PublishOpt
+		if id := messageID(batch, i, record.EventID); id != "" {
+			opts = append(opts, jetstream.WithMsgID(id))
+		}
+		ack, err := s.js.PublishMsgAsync(msg, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to publish event: %w", err)
+		}
+		acks = append(acks, ack)
+	}
+
+	var errs []error
+	for _, ack := range acks {
+		select {
+		case <-ack.Ok():
+		case err := <-ack.Err():
+			errs = append(errs, err)
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for JetStream to acknowledge batch %s: %w", batch.BatchID, ctx.Err())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("JetStream rejected %d of %d events: %w", len(errs), len(acks), errs[0])
+	}
+	return nil
+}
+
+// subject is <prefix>.<tenant>.<eventName>, with each name made safe to
+// use as a single subject token
+func (s *Sink) subject(tenant, eventName string) string {
+	return s.cfg.SubjectPrefix + "." + subjectToken(tenant) + "." + subjectToken(eventName)
+}
+
+// subjectToken replaces the characters NATS gives a meaning in subjects
+func subjectToken(name string) string {
+	if name == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// messageID identifies the index'th event of batch for JetStream's
+// duplicate detection, by its eventId when it has one
+func messageID(batch models.EventBatch, index int, eventID string) string {
+	if eventID != "" {
+		return batch.Tenant + "/" + eventID
+	}
+	if batch.BatchID == "" {
+		return ""
+	}
+	return batch.Tenant + "/" + batch.ClientID + "/" + batch.BatchID + "/" + strconv.Itoa(index)
+}
+
+// CheckHealth reports whether the connection is up and the stream exists
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	if !s.nc.IsConnected() {
+		return fmt.Errorf("not connected to NATS: %s", s.nc.Status())
+	}
+	if _, err := s.js.Stream(ctx, s.cfg.Stream); err != nil {
+		return fmt.Errorf("failed to look up JetStream stream %s: %w", s.cfg.Stream, err)
+	}
+	return nil
+}
+
+// Close waits for batches being published and closes the connection
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.nc.Close()
+	return nil
+}
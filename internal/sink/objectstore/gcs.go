@@ -0,0 +1,41 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+// GCSUploader uploads objects to Google Cloud Storage
+type GCSUploader struct {
+	client *storage.Client
+	bucket string
+}
+
+// NewGCSUploader creates an uploader using Application Default Credentials
+func NewGCSUploader(ctx context.Context, cfg Config) (*GCSUploader, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %w", err)
+	}
+	return &GCSUploader{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (u *GCSUploader) Upload(ctx context.Context, key string, body []byte) error {
+	w := u.client.Bucket(u.bucket).Object(key).NewWriter(ctx)
+	w.ContentType = "application/x-ndjson"
+	w.ContentEncoding = "gzip"
+
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// CheckBucket verifies the bucket exists and is accessible
+func (u *GCSUploader) CheckBucket(ctx context.Context) error {
+	_, err := u.client.Bucket(u.bucket).Attrs(ctx)
+	return err
+}
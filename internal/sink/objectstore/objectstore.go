@@ -0,0 +1,345 @@
+package objectstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.Flusher       = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+)
+
+// ErrClosed is returned when a batch is logged after Close
+var ErrClosed = errors.New("object store sink is closed")
+
+// Uploader writes a finished object to a bucket
+type Uploader interface {
+	Upload(ctx context.Context, key string, body []byte) error
+}
+
+// BucketChecker is implemented by uploaders that can verify the bucket is
+// reachable with the configured credentials
+type BucketChecker interface {
+	CheckBucket(ctx context.Context) error
+}
+
+// Config configures the object storage sink
+type Config struct {
+	// Provider is "s3" or "gcs"
+	Provider string `yaml:"provider"`
+	Bucket   string `yaml:"bucket"`
+	// Prefix is prepended to every object key
+	Prefix string `yaml:"prefix"`
+	// Region and Endpoint are used by the S3 provider. Endpoint allows
+	// S3-compatible stores such as MinIO.
+	Region   string `yaml:"region"`
+	Endpoint string `yaml:"endpoint"`
+
+	// FlushSize uploads a partition once its compressed size reaches this many bytes
+	FlushSize int `yaml:"flushSize"`
+	// FlushInterval uploads every open partition at least this often
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// MaxRetries is how many times a failed upload is retried before the
+	// object is kept for the next flush
+	MaxRetries int `yaml:"maxRetries"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		Provider:      "s3",
+		Prefix:        "events",
+		FlushSize:     16 << 20,
+		FlushInterval: 5 * time.Minute,
+		MaxRetries:    5,
+	}
+}
+
+// partition is an object being built for one date/hour/client
+type partition struct {
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	records int
+}
+
+func newPartition() *partition {
+	p := &partition{}
+	p.gz = gzip.NewWriter(&p.buf)
+	return p
+}
+
+// pendingObject is a finished object waiting to be (re)uploaded
+type pendingObject struct {
+	key  string
+	body []byte
+}
+
+// Sink buffers events into gzipped NDJSON objects partitioned by date,
+// hour and client ID, and uploads them to a bucket
+type Sink struct {
+	cfg      Config
+	uploader Uploader
+	now      func() time.Time
+
+	mu         sync.Mutex
+	partitions map[string]*partition
+	failed     []pendingObject
+	closed     bool
+
+	// uploadMu serializes flushes
+	uploadMu sync.Mutex
+	// uploads tracks partitions uploaded early because they reached FlushSize
+	uploads sync.WaitGroup
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates a sink that uploads through uploader and starts its flusher
+func New(cfg Config, uploader Uploader) *Sink {
+	defaults := DefaultConfig()
+	if cfg.FlushSize <= 0 {
+		cfg.FlushSize = defaults.FlushSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaults.FlushInterval
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+
+	s := &Sink{
+		cfg:        cfg,
+		uploader:   uploader,
+		now:        time.Now,
+		partitions: make(map[string]*partition),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// NewFromConfig creates the uploader for cfg.Provider and the sink using it
+func NewFromConfig(ctx context.Context, cfg Config) (*Sink, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("object store bucket is required")
+	}
+
+	var uploader Uploader
+	var err error
+	switch cfg.Provider {
+	case "", "s3":
+		uploader, err = NewS3Uploader(ctx, cfg)
+	case "gcs":
+		uploader, err = NewGCSUploader(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("unknown object store provider %q", cfg.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg, uploader), nil
+}
+
+// partitionPrefix is the Hive-style key prefix for records received at t
+func (s *Sink) partitionPrefix(t time.Time, clientID string) string {
+	t = t.UTC()
+	if clientID == "" {
+		clientID = "unknown"
+	}
+	return path.Join(s.cfg.Prefix,
+		"date="+t.Format("2006-01-02"),
+		"hour="+t.Format("15"),
+		"client="+clientID)
+}
+
+// LogBatch appends the batch's events to their partition
+func (s *Sink) LogBatch(_ context.Context, batch models.EventBatch) error {
+	prefix := s.partitionPrefix(s.now(), batch.ClientID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	p, ok := s.partitions[prefix]
+	if !ok {
+		p = newPartition()
+		s.partitions[prefix] = p
+	}
+
+	encoder := json.NewEncoder(p.gz)
+	for _, record := range batch.Records() {
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		p.records++
+	}
+
+	if p.buf.Len() >= s.cfg.FlushSize {
+		delete(s.partitions, prefix)
+		object, err := s.finish(prefix, p)
+		if err != nil {
+			return err
+		}
+		s.uploads.Add(1)
+		go func() {
+			defer s.uploads.Done()
+			if err := s.uploadObjects([]pendingObject{object}); err != nil {
+				slog.Error("Error uploading events", "sink", "objectstore", "error", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// finish closes a partition's gzip stream and names the object
+func (s *Sink) finish(prefix string, p *partition) (pendingObject, error) {
+	if err := p.gz.Close(); err != nil {
+		return pendingObject{}, fmt.Errorf("failed to compress partition: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := fmt.Sprintf("%d-%s.ndjson.gz", s.now().UnixNano(), hex.EncodeToString(suffix))
+	return pendingObject{key: path.Join(prefix, name), body: p.buf.Bytes()}, nil
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				slog.Error("Error uploading events", "sink", "objectstore", "error", err)
+			}
+		}
+	}
+}
+
+// Flush uploads every open partition and any objects left over from
+// earlier failed uploads
+func (s *Sink) Flush() error {
+	s.mu.Lock()
+	objects := s.failed
+	s.failed = nil
+	for prefix, p := range s.partitions {
+		object, err := s.finish(prefix, p)
+		if err != nil {
+			slog.Error("Error finishing partition", "partition", prefix, "error", err)
+			continue
+		}
+		objects = append(objects, object)
+	}
+	s.partitions = make(map[string]*partition)
+	s.mu.Unlock()
+
+	return s.uploadObjects(objects)
+}
+
+// uploadObjects uploads objects with retries; objects that still fail are
+// kept for the next flush
+func (s *Sink) uploadObjects(objects []pendingObject) error {
+	s.uploadMu.Lock()
+	defer s.uploadMu.Unlock()
+
+	var failed []pendingObject
+	var lastErr error
+	for _, object := range objects {
+		if err := s.uploadWithRetry(object); err != nil {
+			failed = append(failed, object)
+			lastErr = err
+		}
+	}
+
+	if len(failed) > 0 {
+		s.mu.Lock()
+		s.failed = append(s.failed, failed...)
+		s.mu.Unlock()
+		return fmt.Errorf("%d objects failed to upload: %w", len(failed), lastErr)
+	}
+	return nil
+}
+
+// uploadWithRetry retries with exponential backoff starting at one second
+func (s *Sink) uploadWithRetry(object pendingObject) error {
+	backoff := time.Second
+	var err error
+	for attempt := 0; attempt <= s.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff = min(backoff*2, time.Minute)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		err = s.uploader.Upload(ctx, object.key, object.body)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		slog.Warn("Upload failed", "key", object.key, "attempt", attempt+1, "error", err)
+	}
+	return err
+}
+
+// Close uploads everything still buffered
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+	s.uploads.Wait()
+	return s.Flush()
+}
+
+// CheckHealth fails while uploads are failing and, if the uploader
+// supports it, when the bucket can't be reached
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	s.mu.Lock()
+	closed := s.closed
+	failed := len(s.failed)
+	s.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d objects waiting to be re-uploaded", failed)
+	}
+
+	if checker, ok := s.uploader.(BucketChecker); ok {
+		if err := checker.CheckBucket(ctx); err != nil {
+			return fmt.Errorf("failed to reach bucket %s: %w", s.cfg.Bucket, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Uploader uploads objects to Amazon S3 or an S3-compatible store
+type S3Uploader struct {
+	client *s3.Client
+	bucket string
+}
+
+// NewS3Uploader creates an uploader using the default AWS credential chain
+func NewS3Uploader(ctx context.Context, cfg Config) (*S3Uploader, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &S3Uploader{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (u *S3Uploader) Upload(ctx context.Context, key string, body []byte) error {
+	_, err := u.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:          aws.String(u.bucket),
+		Key:             aws.String(key),
+		Body:            bytes.NewReader(body),
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String("gzip"),
+	})
+	return err
+}
+
+// CheckBucket verifies the bucket exists and is accessible
+func (u *S3Uploader) CheckBucket(ctx context.Context) error {
+	_, err := u.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(u.bucket)})
+	return err
+}
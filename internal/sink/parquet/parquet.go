@@ -0,0 +1,301 @@
+// Package parquet implements a sink writing events to Parquet files
+// partitioned by date, for query engines such as Athena and Spark
+package parquet
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	parquetgo "github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.Flusher       = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+)
+
+// ErrClosed is returned when a batch is logged after Close
+var ErrClosed = errors.New("parquet sink is closed")
+
+// Config configures the Parquet sink
+type Config struct {
+	// Dir is the root of the date=YYYY-MM-DD partition directories
+	Dir string `yaml:"dir"`
+	// Compression is snappy, gzip, zstd or none
+	Compression string `yaml:"compression"`
+	// MaxRows writes a partition's file once it buffers this many events
+	MaxRows int `yaml:"maxRows"`
+	// FlushInterval writes every open partition at least this often
+	FlushInterval time.Duration `yaml:"flushInterval"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		Dir:           "parquet",
+		Compression:   "zstd",
+		MaxRows:       100000,
+		FlushInterval: 5 * time.Minute,
+	}
+}
+
+// ParseCompression maps a compression name to its codec
+func ParseCompression(name string) (compress.Codec, error) {
+	switch name {
+	case "", "zstd":
+		return &parquetgo.Zstd, nil
+	case "snappy":
+		return &parquetgo.Snappy, nil
+	case "gzip":
+		return &parquetgo.Gzip, nil
+	case "none":
+		return &parquetgo.Uncompressed, nil
+	default:
+		return nil, fmt.Errorf("unknown parquet compression %q", name)
+	}
+}
+
+// pendingFile is a set of rows waiting to be written to one partition
+type pendingFile struct {
+	partition string
+	rows      []row
+}
+
+// Sink buffers events per date partition and writes each partition as a
+// new Parquet file when it fills up or the flush interval passes. Files
+// are immutable once written; nothing is appended to them.
+type Sink struct {
+	cfg   Config
+	codec compress.Codec
+	now   func() time.Time
+
+	mu         sync.Mutex
+	partitions map[string][]row
+	failed     []pendingFile
+	closed     bool
+
+	// writeMu serializes file writes
+	writeMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates the sink's directory and starts its flusher
+func New(cfg Config) (*Sink, error) {
+	defaults := DefaultConfig()
+	if cfg.Dir == "" {
+		cfg.Dir = defaults.Dir
+	}
+	if cfg.MaxRows <= 0 {
+		cfg.MaxRows = defaults.MaxRows
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaults.FlushInterval
+	}
+	codec, err := ParseCompression(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create parquet directory: %w", err)
+	}
+
+	s := &Sink{
+		cfg:        cfg,
+		codec:      codec,
+		now:        time.Now,
+		partitions: make(map[string][]row),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// LogBatch adds the batch's events to the partition of the day they were
+// received
+func (s *Sink) LogBatch(_ context.Context, batch models.EventBatch) error {
+	receivedAt := s.now().UTC()
+	partition := "date=" + receivedAt.Format("2006-01-02")
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+
+	rows := s.partitions[partition]
+	for _, record := range batch.Records() {
+		rows = append(rows, newRow(record, receivedAt))
+	}
+
+	var full *pendingFile
+	if len(rows) >= s.cfg.MaxRows {
+		delete(s.partitions, partition)
+		full = &pendingFile{partition: partition, rows: rows}
+	} else {
+		s.partitions[partition] = rows
+	}
+	s.mu.Unlock()
+
+	if full != nil {
+		if err := s.writeFiles([]pendingFile{*full}); err != nil {
+			slog.Error("Error writing parquet file", "sink", "parquet", "error", err)
+		}
+	}
+	return nil
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				slog.Error("Error writing parquet file", "sink", "parquet", "error", err)
+			}
+		}
+	}
+}
+
+// Flush writes every open partition and any rows left over from earlier
+// failed writes
+func (s *Sink) Flush() error {
+	s.mu.Lock()
+	files := s.failed
+	s.failed = nil
+	for partition, rows := range s.partitions {
+		files = append(files, pendingFile{partition: partition, rows: rows})
+	}
+	s.partitions = make(map[string][]row)
+	s.mu.Unlock()
+
+	return s.writeFiles(files)
+}
+
+// writeFiles writes each pending file; files that fail are kept for the
+// next flush
+func (s *Sink) writeFiles(files []pendingFile) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var failed []pendingFile
+	var lastErr error
+	for _, file := range files {
+		if err := s.writeFile(file); err != nil {
+			failed = append(failed, file)
+			lastErr = err
+		}
+	}
+
+	if len(failed) > 0 {
+		s.mu.Lock()
+		s.failed = append(s.failed, failed...)
+		s.mu.Unlock()
+		return fmt.Errorf("%d parquet files failed to write: %w", len(failed), lastErr)
+	}
+	return nil
+}
+
+// IsDataFile reports whether name is that of a complete file of a partition
+func IsDataFile(name string) bool {
+	return strings.HasSuffix(name, ".parquet") && !strings.HasPrefix(name, ".")
+}
+
+// writeFile writes rows to a new file in their partition. The file is
+// built under a dot-prefixed name, which query engines skip, and renamed
+// once complete.
+func (s *Sink) writeFile(file pendingFile) error {
+	dir := filepath.Join(s.cfg.Dir, file.partition)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create partition directory: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := fmt.Sprintf("%d-%s.parquet", s.now().UnixNano(), hex.EncodeToString(suffix))
+	path := filepath.Join(dir, name)
+	tmpPath := filepath.Join(dir, "."+name+".tmp")
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create parquet file: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	writer := parquetgo.NewGenericWriter[row](f, parquetgo.Compression(s.codec))
+	if _, err := writer.Write(file.rows); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write parquet rows: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to finish parquet file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync parquet file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close parquet file: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// Close writes everything still buffered
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+	return s.Flush()
+}
+
+// CheckHealth fails while writes are failing or the directory can't be
+// written
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	s.mu.Lock()
+	closed := s.closed
+	failed := len(s.failed)
+	s.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d parquet files waiting to be rewritten", failed)
+	}
+
+	probe, err := os.CreateTemp(s.cfg.Dir, ".health-*")
+	if err != nil {
+		return fmt.Errorf("parquet directory is not writable: %w", err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
@@ -0,0 +1,199 @@
+package parquet
+
+import (
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// row is one event as written to a Parquet file. Playback, technical and
+// context fields are flattened into top-level columns, mirroring the
+// ClickHouse table, so query engines can filter and aggregate them without
+// parsing JSON. Fields of absent sub-objects are null.
+type row struct {
+	EventID          string     `parquet:"event_id,optional"`
+	EventName        string     `parquet:"event_name,dict"`
+	EventCategory    string     `parquet:"event_category,dict"`
+	VideoID          string     `parquet:"video_id"`
+	SessionID        string     `parquet:"session_id"`
+	UserID           string     `parquet:"user_id,optional"`
+	AnonymousID      string     `parquet:"anonymous_id,optional"`
+	Tenant           string     `parquet:"tenant,dict"`
+	ClientID         string     `parquet:"client_id,dict"`
+	BatchID          string     `parquet:"batch_id"`
+	IsRetry          bool       `parquet:"is_retry"`
+	EventTime        time.Time  `parquet:"event_time,timestamp(millisecond)"`
+	ClientTime       *time.Time `parquet:"client_time,optional,timestamp(millisecond)"`
+	BatchTime        time.Time  `parquet:"batch_time,timestamp(millisecond)"`
+	ReceivedAt       time.Time  `parquet:"received_at,timestamp(millisecond)"`
+	CurrentTime      *float64   `parquet:"current_time,optional"`
+	Duration         *float64   `parquet:"duration,optional"`
+	Paused           *bool      `parquet:"paused,optional"`
+	Ended            *bool      `parquet:"ended,optional"`
+	PlaybackRate     *float64   `parquet:"playback_rate,optional"`
+	Volume           *float64   `parquet:"volume,optional"`
+	Muted            *bool      `parquet:"muted,optional"`
+	Fullscreen       *bool      `parquet:"fullscreen,optional"`
+	NetworkState     *int32     `parquet:"network_state,optional"`
+	ReadyState       *int32     `parquet:"ready_state,optional"`
+	Bitrate          *float64   `parquet:"bitrate,optional"`
+	BufferLength     *float64   `parquet:"buffer_length,optional"`
+	Quality          *string    `parquet:"quality,optional,dict"`
+	LiveLatency      *float64   `parquet:"live_latency,optional"`
+	UserAgent        *string    `parquet:"user_agent,optional,dict"`
+	ScreenResolution *string    `parquet:"screen_resolution,optional,dict"`
+	ViewportSize     *string    `parquet:"viewport_size,optional"`
+	PlayerSize       *string    `parquet:"player_size,optional"`
+	ConnectionType   *string    `parquet:"connection_type,optional,dict"`
+	StreamType       *string    `parquet:"stream_type,optional,dict"`
+	CDN              *string    `parquet:"cdn,optional,dict"`
+	EdgePOP          *string    `parquet:"edge_pop,optional,dict"`
+	PageURL          *string    `parquet:"page_url,optional"`
+	Referrer         *string    `parquet:"referrer,optional"`
+	PageTitle        *string    `parquet:"page_title,optional"`
+	Country          *string    `parquet:"country,optional,dict"`
+	Region           *string    `parquet:"region,optional,dict"`
+	City             *string    `parquet:"city,optional,dict"`
+	ASN              *int64     `parquet:"asn,optional"`
+	ASOrg            *string    `parquet:"as_org,optional,dict"`
+	DeviceType       *string    `parquet:"device_type,optional,dict"`
+	OS               *string    `parquet:"os,optional,dict"`
+	OSVersion        *string    `parquet:"os_version,optional,dict"`
+	Browser          *string    `parquet:"browser,optional,dict"`
+	BrowserVersion   *string    `parquet:"browser_version,optional,dict"`
+	CustomData       string     `parquet:"custom_data,optional"`
+	SampleRate       *float64   `parquet:"sample_rate,optional"`
+	IsBot            bool       `parquet:"is_bot,optional"`
+	BotReason        string     `parquet:"bot_reason,optional,dict"`
+	AdID             *string    `parquet:"ad_id,optional"`
+	AdCreativeID     *string    `parquet:"ad_creative_id,optional"`
+	AdPosition       *string    `parquet:"ad_position,optional,dict"`
+	AdQuartile       *int32     `parquet:"ad_quartile,optional"`
+	AdSkippable      *bool      `parquet:"ad_skippable,optional"`
+
+	InteractionElement   *string `parquet:"interaction_element,optional,dict"`
+	InteractionLabel     *string `parquet:"interaction_label,optional"`
+	InteractionTargetURL *string `parquet:"interaction_target_url,optional"`
+
+	ContentTitle     *string  `parquet:"content_title,optional"`
+	ContentDuration  *float64 `parquet:"content_duration,optional"`
+	ContentCategory  *string  `parquet:"content_category,optional,dict"`
+	ContentPublisher *string  `parquet:"content_publisher,optional,dict"`
+}
+
+// newRow flattens a record into a row
+func newRow(record models.EventRecord, receivedAt time.Time) row {
+	r := row{
+		EventID:       record.EventID,
+		EventName:     record.EventName,
+		EventCategory: record.Category(),
+		VideoID:       record.VideoID,
+		SessionID:     record.SessionID,
+		UserID:        record.UserID,
+		AnonymousID:   record.AnonymousID,
+		Tenant:        record.Tenant,
+		ClientID:      record.ClientID,
+		BatchID:       record.BatchID,
+		IsRetry:       record.IsRetry,
+		EventTime:     parseTime(record.Timestamp, receivedAt),
+		BatchTime:     parseTime(record.BatchTimestamp, receivedAt),
+		ReceivedAt:    parseTime(record.ReceivedAt, receivedAt),
+		CustomData:    record.CustomData,
+		IsBot:         record.IsBot,
+		BotReason:     record.BotReason,
+	}
+	if record.Sampled {
+		r.SampleRate = &record.SampleRate
+	}
+	if record.ClientTimestamp != "" {
+		clientTime := parseTime(record.ClientTimestamp, r.EventTime)
+		r.ClientTime = &clientTime
+	}
+
+	if p := record.PlaybackState; p != nil {
+		networkState, readyState := int32(p.NetworkState), int32(p.ReadyState)
+		r.CurrentTime = &p.CurrentTime
+		r.Duration = &p.Duration
+		r.Paused = &p.Paused
+		r.Ended = &p.Ended
+		r.PlaybackRate = &p.PlaybackRate
+		r.Volume = &p.Volume
+		r.Muted = &p.Muted
+		r.Fullscreen = &p.Fullscreen
+		r.NetworkState = &networkState
+		r.ReadyState = &readyState
+		r.Bitrate = nonZero(p.Bitrate)
+		r.BufferLength = nonZero(p.BufferLength)
+		r.Quality = nonZero(p.Quality)
+		r.LiveLatency = nonZero(p.LiveLatency)
+	}
+	if t := record.Technical; t != nil {
+		r.UserAgent = &t.UserAgent
+		r.ScreenResolution = &t.ScreenResolution
+		r.ViewportSize = &t.ViewportSize
+		r.PlayerSize = &t.PlayerSize
+		r.ConnectionType = &t.ConnectionType
+		r.StreamType = nonZero(t.StreamType)
+		r.CDN = nonZero(t.CDN)
+		r.EdgePOP = nonZero(t.EdgePOP)
+	}
+	if c := record.Context; c != nil {
+		r.PageURL = &c.PageURL
+		r.Referrer = &c.Referrer
+		r.PageTitle = &c.PageTitle
+		if g := c.Geo; g != nil {
+			r.Country = nonZero(g.Country)
+			r.Region = nonZero(g.Region)
+			r.City = nonZero(g.City)
+			r.ASN = nonZero(int64(g.ASN))
+			r.ASOrg = nonZero(g.ASOrg)
+		}
+		if d := c.Device; d != nil {
+			r.DeviceType = nonZero(d.Type)
+			r.OS = nonZero(d.OS)
+			r.OSVersion = nonZero(d.OSVersion)
+			r.Browser = nonZero(d.Browser)
+			r.BrowserVersion = nonZero(d.BrowserVersion)
+		}
+	}
+	if a := record.AdState; a != nil {
+		quartile := int32(a.Quartile)
+		r.AdID = &a.AdID
+		r.AdCreativeID = nonZero(a.CreativeID)
+		r.AdPosition = nonZero(a.Position)
+		r.AdQuartile = nonZero(quartile)
+		r.AdSkippable = &a.Skippable
+	}
+	if i := record.Interaction; i != nil {
+		r.InteractionElement = &i.Element
+		r.InteractionLabel = nonZero(i.Label)
+		r.InteractionTargetURL = nonZero(i.TargetURL)
+	}
+	if c := record.Content; c != nil {
+		r.ContentTitle = nonZero(c.Title)
+		r.ContentDuration = nonZero(c.Duration)
+		r.ContentCategory = nonZero(c.Category)
+		r.ContentPublisher = nonZero(c.Publisher)
+	}
+	return r
+}
+
+// parseTime parses an RFC3339 timestamp, falling back to fallback when the
+// value is missing or malformed
+func parseTime(value string, fallback time.Time) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t = fallback
+	}
+	return t.UTC()
+}
+
+// nonZero returns a pointer to v, or nil for the omitempty fields the
+// player didn't report
+func nonZero[T comparable](v T) *T {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return &v
+}
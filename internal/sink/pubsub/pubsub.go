@@ -0,0 +1,334 @@
+// Package pubsub publishes events to a Google Cloud Pub/Sub topic, one
+// message per event, ordered per session
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	ps "cloud.google.com/go/pubsub/v2"
+	"cloud.google.com/go/pubsub/v2/apiv1/pubsubpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+)
+
+// ErrClosed is returned when a batch is logged after Close
+var ErrClosed = errors.New("pubsub sink is closed")
+
+// Ordering keys selectable with Config.OrderingKey. Subscribers with
+// message ordering enabled receive the events of a key in order.
+const (
+	OrderBySession = "sessionId"
+	OrderByVideo   = "videoId"
+	OrderByUser    = "userId"
+	// OrderNone publishes without ordering keys, which Pub/Sub delivers
+	// with the most throughput
+	OrderNone = "none"
+)
+
+// Config configures the Pub/Sub sink
+type Config struct {
+	// Project is the Google Cloud project of the topics. Credentials are
+	// Application Default Credentials; PUBSUB_EMULATOR_HOST points the
+	// sink at an emulator.
+	Project string `yaml:"project"`
+	Topic   string `yaml:"topic"`
+	// CreateTopic creates the topics at startup if they don't exist
+	CreateTopic bool `yaml:"createTopic"`
+	// OrderingKey is the event field messages are ordered by: sessionId,
+	// videoId, userId or none
+	OrderingKey string `yaml:"orderingKey"`
+
+	// BatchDelay, BatchCount and BatchBytes bound how long, how many and
+	// how large messages are batched before they are sent
+	BatchDelay time.Duration `yaml:"batchDelay"`
+	BatchCount int           `yaml:"batchCount"`
+	BatchBytes int           `yaml:"batchBytes"`
+	// PublishTimeout bounds the wait for Pub/Sub to store a batch
+	PublishTimeout time.Duration `yaml:"publishTimeout"`
+
+	// DeadLetterTopic receives the events the topic rejected, with the
+	// error in their error attribute, instead of failing their batch. A
+	// batch only fails when the dead letter topic rejects them too.
+	DeadLetterTopic string `yaml:"deadLetterTopic"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		Topic:          "video-events",
+		CreateTopic:    true,
+		OrderingKey:    OrderBySession,
+		BatchDelay:     10 * time.Millisecond,
+		BatchCount:     100,
+		BatchBytes:     1 << 20,
+		PublishTimeout: 30 * time.Second,
+	}
+}
+
+// Validate reports an unknown ordering key
+func (c Config) Validate() error {
+	switch c.OrderingKey {
+	case "", OrderBySession, OrderByVideo, OrderByUser, OrderNone:
+		return nil
+	}
+	return fmt.Errorf("unknown pubsub ordering key %q, must be %s, %s, %s or %s",
+		c.OrderingKey, OrderBySession, OrderByVideo, OrderByUser, OrderNone)
+}
+
+// Sink publishes every event of a batch and waits until Pub/Sub stored
+// all of them, so a batch LogBatch accepted is in the topic. Messages
+// carry the tenant, event name, video and session as attributes for
+// subscription filters.
+type Sink struct {
+	cfg        Config
+	client     *ps.Client
+	topic      *ps.Publisher
+	deadLetter *ps.Publisher
+
+	// mu keeps Close from stopping the publishers under a batch
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New connects to Pub/Sub and makes sure the topics exist
+func New(ctx context.Context, cfg Config) (*Sink, error) {
+	defaults := DefaultConfig()
+	if cfg.Project == "" {
+		return nil, errors.New("pubsub project is required")
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = defaults.Topic
+	}
+	if cfg.OrderingKey == "" {
+		cfg.OrderingKey = defaults.OrderingKey
+	}
+	if cfg.BatchDelay <= 0 {
+		cfg.BatchDelay = defaults.BatchDelay
+	}
+	if cfg.BatchCount <= 0 {
+		cfg.BatchCount = defaults.BatchCount
+	}
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = defaults.BatchBytes
+	}
+	if cfg.PublishTimeout <= 0 {
+		cfg.PublishTimeout = defaults.PublishTimeout
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := ps.NewClient(ctx, cfg.Project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	s := &Sink{cfg: cfg, client: client}
+	topics := []string{cfg.Topic}
+	if cfg.DeadLetterTopic != "" {
+		topics = append(topics, cfg.DeadLetterTopic)
+	}
+	for _, topic := range topics {
+		if err := s.ensureTopic(ctx, topic); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	s.topic = client.Publisher(cfg.Topic)
+	s.topic.PublishSettings.DelayThreshold = cfg.BatchDelay
+	s.topic.PublishSettings.CountThreshold = cfg.BatchCount
+	s.topic.PublishSettings.ByteThreshold = cfg.BatchBytes
+	s.topic.PublishSettings.Timeout = cfg.PublishTimeout
+	s.topic.EnableMessageOrdering = cfg.OrderingKey != OrderNone
+	if cfg.DeadLetterTopic != "" {
+		s.deadLetter = client.Publisher(cfg.DeadLetterTopic)
+		s.deadLetter.PublishSettings.Timeout = cfg.PublishTimeout
+	}
+	return s, nil
+}
+
+func (s *Sink) topicName(topic string) string {
+	return fmt.Sprintf("projects/%s/topics/%s", s.cfg.Project, topic)
+}
+
+// ensureTopic creates topic if CreateTopic is set, or checks that it exists
+func (s *Sink) ensureTopic(ctx context.Context, topic string) error {
+	name := s.topicName(topic)
+	if s.cfg.CreateTopic {
+		_, err := s.client.TopicAdminClient.CreateTopic(ctx, &pubsubpb.Topic{Name: name})
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			return fmt.Errorf("failed to create Pub/Sub topic %s: %w", topic, err)
+		}
+		return nil
+	}
+	if _, err := s.client.TopicAdminClient.GetTopic(ctx, &pubsubpb.GetTopicRequest{Topic: name}); err != nil {
+		return fmt.Errorf("failed to find Pub/Sub topic %s: %w", topic, err)
+	}
+	return nil
+}
+
+// LogBatch publishes one message per event and returns once all of them
+// are stored, in the topic or the dead letter topic
+func (s *Sink) LogBatch(ctx context.Context, batch models.EventBatch) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.PublishTimeout)
+	defer cancel()
+
+	records := batch.Records()
+	messages := make([]*ps.Message, len(records))
+	results := make([]*ps.PublishResult, len(records))
+	for i, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		messages[i] = &ps.Message{
+			Data:        data,
+			Attributes:  attributes(record),
+			OrderingKey: s.orderingKey(record),
+		}
+		results[i] = s.topic.Publish(ctx, messages[i])
+	}
+
+	var failed []*ps.Message
+	var errs []error
+	for i, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			failed = append(failed, messages[i])
+			errs = append(errs, err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	// Pub/Sub pauses an ordering key after a failed publish so later
+	// messages can't overtake the failed one. The batch is dead-lettered
+	// or failed as a whole, so publishing the key may go on.
+	for _, msg := range failed {
+		if msg.OrderingKey != "" {
+			s.topic.ResumePublish(msg.OrderingKey)
+		}
+	}
+	// Messages the publish timeout or the caller cut short weren't
+	// rejected, and can't be dead-lettered under the same context anyway
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("publishing %d of %d events to pubsub stopped: %w", len(failed), len(records), err)
+	}
+	if s.deadLetter == nil {
+		return fmt.Errorf("pubsub rejected %d of %d events: %w", len(failed), len(records), errs[0])
+	}
+	return s.publishDeadLetters(ctx, batch, failed, errs)
+}
+
+// publishDeadLetters publishes the messages the topic rejected to the dead
+// letter topic, without ordering keys
+func (s *Sink) publishDeadLetters(ctx context.Context, batch models.EventBatch, messages []*ps.Message, errs []error) error {
+	slog.Warn("Dead-lettering events Pub/Sub rejected",
+		"batchId", batch.BatchID, "events", len(messages), "topic", s.cfg.DeadLetterTopic, "error", errs[0])
+
+	results := make([]*ps.PublishResult, len(messages))
+	for i, msg := range messages {
+		attrs := make(map[string]string, len(msg.Attributes)+1)
+		for k, v := range msg.Attributes {
+			attrs[k] = v
+		}
+		attrs["error"] = errs[i].Error()
+		results[i] = s.deadLetter.Publish(ctx, &ps.Message{Data: msg.Data, Attributes: attrs})
+	}
+	for _, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			return fmt.Errorf("pubsub rejected %d events and the dead letter topic too: %w", len(messages), err)
+		}
+	}
+	metrics.PubSubDeadLettered.Add(float64(len(messages)))
+	return nil
+}
+
+// orderingKey is the record's value of the configured field, or no key
+// when ordering is off or the record has none
+func (s *Sink) orderingKey(record models.EventRecord) string {
+	switch s.cfg.OrderingKey {
+	case OrderBySession:
+		if record.SessionID != "" {
+			return record.SessionID
+		}
+		return record.BatchSessionID
+	case OrderByVideo:
+		return record.VideoID
+	case OrderByUser:
+		if record.UserID != "" {
+			return record.UserID
+		}
+		return record.AnonymousID
+	}
+	return ""
+}
+
+// attributes are the fields subscriptions can filter events on. Empty
+// ones are left out.
+func attributes(record models.EventRecord) map[string]string {
+	attrs := make(map[string]string, 4)
+	for key, value := range map[string]string{
+		"tenant":    record.Tenant,
+		"eventName": record.EventName,
+		"videoId":   record.VideoID,
+		"sessionId": record.SessionID,
+	} {
+		if value != "" {
+			attrs[key] = value
+		}
+	}
+	return attrs
+}
+
+// CheckHealth reports whether the topic can be read
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if _, err := s.client.TopicAdminClient.GetTopic(ctx, &pubsubpb.GetTopicRequest{Topic: s.topicName(s.cfg.Topic)}); err != nil {
+		return fmt.Errorf("failed to look up Pub/Sub topic %s: %w", s.cfg.Topic, err)
+	}
+	return nil
+}
+
+// Close waits for batches being published, stops the publishers and
+// closes the client
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.topic.Stop()
+	if s.deadLetter != nil {
+		s.deadLetter.Stop()
+	}
+	return s.client.Close()
+}
@@ -0,0 +1,120 @@
+package sink
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// DefaultQueryLimit is how many events a query returns when it sets no
+// limit
+const DefaultQueryLimit = 100
+
+// ErrQueryUnsupported is returned by sinks wrapping one that can't search
+// what it stored, such as a message broker
+var ErrQueryUnsupported = errors.New("sink does not support querying events")
+
+// ErrInvalidCursor is returned for a cursor that no query returned
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Query selects stored events of a tenant. Empty filters match every
+// event.
+type Query struct {
+	Tenant string
+	// From and To bound the event time; From is inclusive, To exclusive
+	From, To  time.Time
+	SessionID string
+	VideoID   string
+	EventName string
+	ClientID  string
+	// EventCategory matches events of the category, including those whose
+	// name implies it
+	EventCategory string
+	// Limit is the most events returned
+	Limit int
+	// Cursor continues a previous query from its NextCursor
+	Cursor string
+}
+
+// Matches reports whether record passes the filters of q. Records without
+// a tenant, read from text logs, match any tenant.
+func (q Query) Matches(record models.EventRecord, eventTime time.Time) bool {
+	switch {
+	case record.Tenant != "" && record.Tenant != q.Tenant:
+		return false
+	case q.SessionID != "" && record.SessionID != q.SessionID:
+		return false
+	case q.VideoID != "" && record.VideoID != q.VideoID:
+		return false
+	case q.EventName != "" && record.EventName != q.EventName:
+		return false
+	case q.ClientID != "" && record.ClientID != q.ClientID:
+		return false
+	case q.EventCategory != "" && record.Category() != q.EventCategory:
+		return false
+	case !q.From.IsZero() && eventTime.Before(q.From):
+		return false
+	case !q.To.IsZero() && !eventTime.Before(q.To):
+		return false
+	}
+	return true
+}
+
+// QueryResult is a page of events, ordered by event time
+type QueryResult struct {
+	Events []models.EventRecord
+	// NextCursor continues the query after the last event; it is empty on
+	// the last page
+	NextCursor string
+}
+
+// Querier is implemented by sinks that can search the events they stored
+type Querier interface {
+	// QueryEvents returns the first events matching q after its cursor, in
+	// event time order
+	QueryEvents(ctx context.Context, q Query) (QueryResult, error)
+}
+
+// Cursor is the position of an event in event time order. Key breaks ties
+// between events of the same time, e.g. the event ID or a row ID.
+type Cursor struct {
+	Time time.Time
+	Key  string
+}
+
+// After reports whether an event at t with key comes after the cursor
+func (c Cursor) After(t time.Time, key string) bool {
+	return t.After(c.Time) || t.Equal(c.Time) && key > c.Key
+}
+
+// Encode returns the cursor as an opaque, URL-safe string
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.Time.UnixNano(), 10) + ":" + c.Key))
+}
+
+// ParseCursor decodes a cursor returned by Encode. An empty string is the
+// start of the results.
+func ParseCursor(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	nanos, key, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return Cursor{Time: time.Unix(0, n).UTC(), Key: key}, nil
+}
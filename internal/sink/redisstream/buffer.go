@@ -0,0 +1,361 @@
+package redisstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/adtyap26/event-stream-video/internal/deadletter"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var (
+	_ sink.EventSink     = (*Buffer)(nil)
+	_ sink.Flusher       = (*Buffer)(nil)
+	_ sink.HealthChecker = (*Buffer)(nil)
+	_ sink.Eraser        = (*Buffer)(nil)
+	_ sink.Querier       = (*Buffer)(nil)
+)
+
+const (
+	// readBlock is how long a read waits for new entries, and so how long
+	// Close may wait for the consumer to notice
+	readBlock = 2 * time.Second
+	// retryPause slows the consumer down while Redis is unreachable
+	retryPause = time.Second
+	// erasePage is how many entries EraseUser scans at a time
+	erasePage = 500
+)
+
+// Buffer adds batches to the stream and stores them in the durable sink
+// from a consumer group. A batch is acknowledged, and removed from the
+// stream, only once the durable sink has stored it, so batches survive a
+// collector crash or a sink outage. Collectors sharing the group each store
+// a share of the stream; an entry left unacknowledged for ClaimIdle, by a
+// crashed collector or because the sink failed, is claimed and tried again.
+type Buffer struct {
+	*Sink
+	target      sink.EventSink
+	deadLetters *deadletter.Queue
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewBuffer connects to Redis, creates the consumer group if needed and
+// starts storing the stream in target. Batches target fails to store
+// MaxDeliveries times are moved to deadLetters when it isn't nil.
+func NewBuffer(cfg Config, target sink.EventSink, deadLetters *deadletter.Queue) (*Buffer, error) {
+	if cfg.Consumer == "" {
+		// Restarting under the same name picks up the entries the previous
+		// run had read but not stored
+		host, err := os.Hostname()
+		if err != nil || host == "" {
+			host = uuid.NewString()
+		}
+		cfg.Consumer = host
+	}
+	s, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+	err = s.client.XGroupCreateMkStream(ctx, s.cfg.Stream, s.cfg.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		s.Close()
+		return nil, fmt.Errorf("failed to create consumer group %s: %w", s.cfg.Group, err)
+	}
+
+	b := &Buffer{
+		Sink:        s,
+		target:      target,
+		deadLetters: deadLetters,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go b.consume()
+	return b, nil
+}
+
+// consume stores the entries left pending by an earlier run of this
+// consumer, then new entries, claiming stale ones every half ClaimIdle
+func (b *Buffer) consume() {
+	defer close(b.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-b.stop:
+			cancel()
+		case <-b.done:
+		}
+	}()
+
+	// Reading from an ID rather than ">" returns this consumer's pending
+	// entries after it; move past them until none are left
+	pending := "0"
+	nextClaim := time.Now()
+	for ctx.Err() == nil {
+		if time.Now().After(nextClaim) {
+			b.claim(ctx)
+			nextClaim = time.Now().Add(b.cfg.ClaimIdle / 2)
+		}
+
+		id := ">"
+		if pending != "" {
+			id = pending
+		}
+		streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    b.cfg.Group,
+			Consumer: b.cfg.Consumer,
+			Streams:  []string{b.cfg.Stream, id},
+			Count:    b.cfg.ReadCount,
+			Block:    readBlock,
+		}).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+				slog.Error("Failed to read Redis stream", "stream", b.cfg.Stream, "error", err)
+				b.pause(ctx)
+			}
+			continue
+		}
+
+		read := 0
+		for _, stream := range streams {
+			read += len(stream.Messages)
+			for _, msg := range stream.Messages {
+				b.store(ctx, msg)
+				if pending != "" {
+					pending = msg.ID
+				}
+			}
+		}
+		if pending != "" && read == 0 {
+			pending = ""
+		}
+	}
+}
+
+// claim takes over the entries no consumer acknowledged within ClaimIdle
+// and tries to store them again
+func (b *Buffer) claim(ctx context.Context) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		msgs, next, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   b.cfg.Stream,
+			Group:    b.cfg.Group,
+			Consumer: b.cfg.Consumer,
+			MinIdle:  b.cfg.ClaimIdle,
+			Start:    start,
+			Count:    b.cfg.ReadCount,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("Failed to claim stale Redis stream entries", "stream", b.cfg.Stream, "error", err)
+			}
+			return
+		}
+		for _, msg := range msgs {
+			b.store(ctx, msg)
+		}
+		if next == "0-0" {
+			return
+		}
+		start = next
+	}
+}
+
+// store writes the batch of msg to the target and acknowledges it. A batch
+// the target rejects stays pending until it is claimed again, or is moved
+// to the dead letter queue once it has been delivered MaxDeliveries times.
+func (b *Buffer) store(ctx context.Context, msg redis.XMessage) {
+	batch, err := decodeEntry(msg)
+	if err != nil {
+		// Trying again won't make the entry readable
+		slog.Error("Dropped unreadable Redis stream entry", "stream", b.cfg.Stream, "error", err)
+		b.ack(msg.ID)
+		return
+	}
+
+	err = b.target.LogBatch(ctx, batch)
+	if err == nil {
+		b.ack(msg.ID)
+		return
+	}
+
+	deliveries := b.deliveries(ctx, msg.ID)
+	if b.deadLetters == nil || deliveries < b.cfg.MaxDeliveries {
+		slog.Warn("Failed to store buffered batch, will retry",
+			"clientId", batch.ClientID, "batchId", batch.BatchID, "deliveries", deliveries, "error", err)
+		return
+	}
+	if dlqErr := b.deadLetters.Add(batch, err); dlqErr != nil {
+		slog.Error("Failed to dead-letter buffered batch",
+			"clientId", batch.ClientID, "batchId", batch.BatchID, "error", errors.Join(err, dlqErr))
+		return
+	}
+	metrics.DeadLettered.WithLabelValues(batch.Tenant).Inc()
+	slog.Warn("Dead-lettered buffered batch",
+		"clientId", batch.ClientID, "batchId", batch.BatchID, "deliveries", deliveries, "error", err)
+	b.ack(msg.ID)
+}
+
+// deliveries returns how many times the entry id has been read. It counts
+// as delivered once if Redis can't tell.
+func (b *Buffer) deliveries(ctx context.Context, id string) int64 {
+	pending, err := b.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: b.cfg.Stream,
+		Group:  b.cfg.Group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return 1
+	}
+	return pending[0].RetryCount
+}
+
+// ack acknowledges and deletes the entry id. It doesn't use the consumer's
+// context so that a batch stored during Close is acknowledged too.
+func (b *Buffer) ack(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), b.cfg.Timeout)
+	defer cancel()
+
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, b.cfg.Stream, b.cfg.Group, id)
+		pipe.XDel(ctx, b.cfg.Stream, id)
+		return nil
+	})
+	if err != nil {
+		// The entry is claimed and stored again after ClaimIdle; sinks
+		// that deduplicate by batch ID store it once
+		slog.Error("Failed to acknowledge Redis stream entry", "stream", b.cfg.Stream, "id", id, "error", err)
+	}
+}
+
+// pause waits retryPause or until ctx is cancelled
+func (b *Buffer) pause(ctx context.Context) {
+	timer := time.NewTimer(retryPause)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Flush flushes the durable sink if it buffers writes. Batches still in the
+// stream are not waited for.
+func (b *Buffer) Flush() error {
+	if flusher, ok := b.target.(sink.Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// CheckHealth pings Redis. The durable sink isn't checked: while it is down
+// batches wait in the stream.
+func (b *Buffer) CheckHealth(ctx context.Context) error {
+	return b.Sink.CheckHealth(ctx)
+}
+
+// EraseUser removes the events of userID in tenant from the batches waiting
+// in the stream, then erases them from the durable sink. A waiting batch is
+// replaced by an entry at the end of the stream holding its other events.
+func (b *Buffer) EraseUser(ctx context.Context, tenant, userID string) (int64, error) {
+	var erased int64
+	start := "-"
+	for {
+		msgs, err := b.client.XRangeN(ctx, b.cfg.Stream, start, "+", erasePage).Result()
+		if err != nil {
+			return erased, fmt.Errorf("failed to scan Redis stream: %w", err)
+		}
+		for _, msg := range msgs {
+			removed, err := b.eraseEntry(ctx, msg, tenant, userID)
+			erased += removed
+			if err != nil {
+				return erased, err
+			}
+		}
+		if len(msgs) < erasePage {
+			break
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+
+	eraser, ok := b.target.(sink.Eraser)
+	if !ok {
+		return erased, sink.ErrEraseUnsupported
+	}
+	stored, err := eraser.EraseUser(ctx, tenant, userID)
+	return erased + stored, err
+}
+
+// QueryEvents queries the durable sink. Batches still waiting in the stream
+// aren't searched.
+func (b *Buffer) QueryEvents(ctx context.Context, q sink.Query) (sink.QueryResult, error) {
+	if querier, ok := b.target.(sink.Querier); ok {
+		return querier.QueryEvents(ctx, q)
+	}
+	return sink.QueryResult{}, sink.ErrQueryUnsupported
+}
+
+// eraseEntry removes the events of userID in tenant from the batch of msg
+// and returns how many there were
+func (b *Buffer) eraseEntry(ctx context.Context, msg redis.XMessage, tenant, userID string) (int64, error) {
+	batch, err := decodeEntry(msg)
+	if err != nil || batch.Tenant != tenant {
+		return 0, nil
+	}
+	kept := batch.Events[:0:0]
+	for _, event := range batch.Events {
+		if event.UserID != userID {
+			kept = append(kept, event)
+		}
+	}
+	removed := int64(len(batch.Events) - len(kept))
+	if removed == 0 {
+		return 0, nil
+	}
+
+	batch.Events = kept
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode batch: %w", err)
+	}
+	_, err = b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(kept) > 0 {
+			pipe.XAdd(ctx, &redis.XAddArgs{Stream: b.cfg.Stream, Values: map[string]any{batchField: data}})
+		}
+		pipe.XAck(ctx, b.cfg.Stream, b.cfg.Group, msg.ID)
+		pipe.XDel(ctx, b.cfg.Stream, msg.ID)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to rewrite Redis stream entry %s: %w", msg.ID, err)
+	}
+	return removed, nil
+}
+
+// Close stops taking batches, waits for the batch being stored and closes
+// the durable sink. Batches still in the stream are stored by the other
+// collectors or the next run.
+func (b *Buffer) Close() error {
+	b.once.Do(func() { close(b.stop) })
+	<-b.done
+	return errors.Join(b.target.Close(), b.Sink.Close())
+}
@@ -0,0 +1,199 @@
+// Package redisstream adds batches to a Redis stream. Used as the sink, the
+// stream is for downstream consumers to read; used as a buffer, collectors
+// read it back through a shared consumer group and store the batches in the
+// durable sink.
+package redisstream
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+)
+
+// ErrClosed is returned when a batch is logged after Close
+var ErrClosed = errors.New("redis stream sink is closed")
+
+// batchField is the entry field holding the batch as JSON
+const batchField = "batch"
+
+// Config configures the Redis stream and, for the buffer, how it is read
+type Config struct {
+	// Addr is the host:port of the Redis server
+	Addr     string `yaml:"addr"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	TLS      bool   `yaml:"tls"`
+
+	// Stream is the key of the stream batches are added to
+	Stream string `yaml:"stream"`
+	// MaxLen trims the stream to about this many entries; zero keeps every
+	// entry. Trimming drops the oldest entries whether they were read or not.
+	MaxLen int64 `yaml:"maxLen"`
+	// Timeout bounds each Redis command
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Group is the consumer group the buffer reads with. Collectors sharing
+	// the group split the stream between them.
+	Group string `yaml:"group"`
+	// Consumer names this collector in the group; it defaults to the host
+	// name and must differ between collectors
+	Consumer string `yaml:"consumer"`
+	// ReadCount is how many entries are read at a time
+	ReadCount int64 `yaml:"readCount"`
+	// ClaimIdle is how long an entry may go unacknowledged, e.g. because its
+	// collector crashed or the sink failed, before it is read again
+	ClaimIdle time.Duration `yaml:"claimIdle"`
+	// MaxDeliveries is how many times an entry is tried before its batch is
+	// moved to the dead letter queue. Without a queue it is tried until it
+	// is stored.
+	MaxDeliveries int64 `yaml:"maxDeliveries"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		Addr:          "localhost:6379",
+		Stream:        "esv:batches",
+		Timeout:       5 * time.Second,
+		Group:         "esv-collectors",
+		ReadCount:     100,
+		ClaimIdle:     time.Minute,
+		MaxDeliveries: 10,
+	}
+}
+
+// withDefaults fills the unset fields of cfg
+func (cfg Config) withDefaults() Config {
+	defaults := DefaultConfig()
+	if cfg.Addr == "" {
+		cfg.Addr = defaults.Addr
+	}
+	if cfg.Stream == "" {
+		cfg.Stream = defaults.Stream
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.Group == "" {
+		cfg.Group = defaults.Group
+	}
+	if cfg.ReadCount <= 0 {
+		cfg.ReadCount = defaults.ReadCount
+	}
+	if cfg.ClaimIdle <= 0 {
+		cfg.ClaimIdle = defaults.ClaimIdle
+	}
+	if cfg.MaxDeliveries <= 0 {
+		cfg.MaxDeliveries = defaults.MaxDeliveries
+	}
+	return cfg
+}
+
+// Sink adds each batch to the stream as one entry
+type Sink struct {
+	cfg    Config
+	client *redis.Client
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// New connects to Redis
+func New(cfg Config) (*Sink, error) {
+	cfg = cfg.withDefaults()
+
+	opts := &redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &Sink{cfg: cfg, client: client}, nil
+}
+
+// LogBatch adds batch to the stream
+func (s *Sink) LogBatch(ctx context.Context, batch models.EventBatch) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+	err = s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.cfg.Stream,
+		MaxLen: s.cfg.MaxLen,
+		Approx: s.cfg.MaxLen > 0,
+		Values: map[string]any{batchField: data},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to add batch to Redis stream: %w", err)
+	}
+	return nil
+}
+
+// CheckHealth pings Redis
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to reach Redis: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection once batches being added are done
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.client.Close()
+}
+
+// decodeEntry returns the batch held by a stream entry
+func decodeEntry(msg redis.XMessage) (models.EventBatch, error) {
+	var batch models.EventBatch
+	data, ok := msg.Values[batchField].(string)
+	if !ok {
+		return batch, fmt.Errorf("entry %s has no %s field", msg.ID, batchField)
+	}
+	if err := json.Unmarshal([]byte(data), &batch); err != nil {
+		return batch, fmt.Errorf("failed to decode entry %s: %w", msg.ID, err)
+	}
+	return batch, nil
+}
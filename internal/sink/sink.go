@@ -0,0 +1,55 @@
+package sink
+
+import (
+	"context"
+	"errors"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// EventSink is a destination for event batches. Implementations must be
+// safe for concurrent use by multiple request handlers.
+type EventSink interface {
+	// LogBatch stores a batch of events. Sinks that write before returning
+	// give up when ctx is done; sinks that queue writes only wait on ctx
+	// for room in the queue.
+	LogBatch(ctx context.Context, batch models.EventBatch) error
+	// Close flushes any pending data and releases the sink's resources
+	Close() error
+}
+
+// Flusher is implemented by sinks that buffer writes and can force them out
+type Flusher interface {
+	Flush() error
+}
+
+// HealthChecker is implemented by sinks that can tell whether writes would
+// currently succeed, e.g. because the disk is full or a database is down
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// Backlogger is implemented by sinks that queue writes in memory. Backlog
+// reports how much is queued and how much the queue can hold, in whatever
+// unit the sink queues (batches, rows), so callers can shed load before the
+// queue fills up.
+type Backlogger interface {
+	Backlog() (queued, capacity int)
+}
+
+// ErrUnavailable is wrapped by the errors of sinks that can't take batches
+// for now, such as a full or closing write-ahead log. Such batches are
+// refused rather than dead-lettered, for clients to send them again.
+var ErrUnavailable = errors.New("try again later")
+
+// ErrEraseUnsupported is returned by sinks wrapping one that can't erase,
+// such as the immutable files of the Parquet and object store sinks
+var ErrEraseUnsupported = errors.New("sink does not support erasing events")
+
+// Eraser is implemented by sinks that can delete stored events, to serve
+// right-to-erasure requests
+type Eraser interface {
+	// EraseUser deletes every stored event of userID in tenant and returns
+	// how many were deleted
+	EraseUser(ctx context.Context, tenant, userID string) (int64, error)
+}
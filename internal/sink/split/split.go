@@ -0,0 +1,194 @@
+// Package split wraps a sink so batches larger than it accepts in one
+// write, such as a broker's maximum message size, are written as several
+// parts instead of failing whole
+package split
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var (
+	_ sink.EventSink     = (*Sink)(nil)
+	_ sink.Flusher       = (*Sink)(nil)
+	_ sink.HealthChecker = (*Sink)(nil)
+	_ sink.Backlogger    = (*Sink)(nil)
+	_ sink.Eraser        = (*Sink)(nil)
+	_ sink.Querier       = (*Sink)(nil)
+)
+
+// partFieldBytes leaves room in each part for its part and parts fields
+const partFieldBytes = 32
+
+// Limits are what the wrapped sink takes in one write; zero is unlimited
+type Limits struct {
+	// MaxBytes caps the JSON encoding of a batch
+	MaxBytes int
+	// MaxEvents caps the events of a batch
+	MaxEvents int
+}
+
+// Sink writes batches within the limits to the wrapped sink as they are and
+// splits the others into consecutive parts that are. Parts keep the batch
+// ID, client, session, timestamps and tenant of their batch, and number
+// themselves in Part and Parts. The parts are written in order; a failed
+// part fails the batch, and the parts before it are written again when the
+// batch is retried, with the same event IDs.
+type Sink struct {
+	next   sink.EventSink
+	limits Limits
+}
+
+// Wrap returns next when limits has none, or a Sink in front of next
+func Wrap(next sink.EventSink, limits Limits) sink.EventSink {
+	if limits.MaxBytes <= 0 && limits.MaxEvents <= 0 {
+		return next
+	}
+	return &Sink{next: next, limits: limits}
+}
+
+func (s *Sink) LogBatch(ctx context.Context, batch models.EventBatch) error {
+	parts, err := s.split(batch)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 1 {
+		return s.next.LogBatch(ctx, batch)
+	}
+
+	metrics.SplitBatches.WithLabelValues(batch.Tenant).Inc()
+	slog.DebugContext(ctx, "Splitting batch", "batchId", batch.BatchID, "events", len(batch.Events), "parts", len(parts))
+	for i, part := range parts {
+		if err := s.next.LogBatch(ctx, part); err != nil {
+			return fmt.Errorf("part %d of %d of batch %s: %w", i+1, len(parts), batch.BatchID, err)
+		}
+	}
+	return nil
+}
+
+// split returns the parts of batch within the limits, or batch alone when
+// it already is. An event over MaxBytes on its own gets a part of its own,
+// for the sink to accept or reject.
+func (s *Sink) split(batch models.EventBatch) ([]models.EventBatch, error) {
+	maxEvents := s.limits.MaxEvents
+	if maxEvents <= 0 {
+		maxEvents = len(batch.Events)
+	}
+	if s.limits.MaxBytes <= 0 {
+		if len(batch.Events) <= maxEvents {
+			return []models.EventBatch{batch}, nil
+		}
+		return parts(batch, func(events []models.Event, _ int) bool { return len(events) < maxEvents }), nil
+	}
+
+	envelope := batch
+	envelope.Events = []models.Event{}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode batch: %w", err)
+	}
+	base := len(data) + partFieldBytes
+	sizes := make([]int, len(batch.Events))
+	total := len(data)
+	for i, event := range batch.Events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode event: %w", err)
+		}
+		// Events after the first are preceded by a comma
+		sizes[i] = len(data) + 1
+		total += sizes[i]
+	}
+	if total <= s.limits.MaxBytes && len(batch.Events) <= maxEvents {
+		return []models.EventBatch{batch}, nil
+	}
+
+	size := base
+	return parts(batch, func(events []models.Event, next int) bool {
+		if len(events) == 0 {
+			size = base
+		}
+		fits := len(events) == 0 || (len(events) < maxEvents && size+sizes[next] <= s.limits.MaxBytes)
+		if fits {
+			size += sizes[next]
+		} else {
+			size = base + sizes[next]
+		}
+		return fits
+	}), nil
+}
+
+// parts cuts the events of batch into parts, starting a new part whenever
+// fits reports that the next'th event doesn't fit in the events of the
+// current one. Every part copies the fields of batch.
+func parts(batch models.EventBatch, fits func(events []models.Event, next int) bool) []models.EventBatch {
+	var result []models.EventBatch
+	start := 0
+	for i := range batch.Events {
+		if !fits(batch.Events[start:i], i) {
+			result = append(result, part(batch, batch.Events[start:i:i]))
+			start = i
+		}
+	}
+	result = append(result, part(batch, batch.Events[start:len(batch.Events):len(batch.Events)]))
+	for i := range result {
+		result[i].Part = i + 1
+		result[i].Parts = len(result)
+	}
+	return result
+}
+
+func part(batch models.EventBatch, events []models.Event) models.EventBatch {
+	batch.Events = events
+	return batch
+}
+
+// Flush flushes the wrapped sink if it buffers writes
+func (s *Sink) Flush() error {
+	if flusher, ok := s.next.(sink.Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// CheckHealth checks the wrapped sink if it can tell
+func (s *Sink) CheckHealth(ctx context.Context) error {
+	if checker, ok := s.next.(sink.HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// Backlog reports the wrapped sink's backlog if it queues writes
+func (s *Sink) Backlog() (queued, capacity int) {
+	if backlogger, ok := s.next.(sink.Backlogger); ok {
+		return backlogger.Backlog()
+	}
+	return 0, 0
+}
+
+// EraseUser erases from the wrapped sink if it can
+func (s *Sink) EraseUser(ctx context.Context, tenant, userID string) (int64, error) {
+	if eraser, ok := s.next.(sink.Eraser); ok {
+		return eraser.EraseUser(ctx, tenant, userID)
+	}
+	return 0, sink.ErrEraseUnsupported
+}
+
+// QueryEvents queries the wrapped sink if it can
+func (s *Sink) QueryEvents(ctx context.Context, q sink.Query) (sink.QueryResult, error) {
+	if querier, ok := s.next.(sink.Querier); ok {
+		return querier.QueryEvents(ctx, q)
+	}
+	return sink.QueryResult{}, sink.ErrQueryUnsupported
+}
+
+func (s *Sink) Close() error {
+	return s.next.Close()
+}
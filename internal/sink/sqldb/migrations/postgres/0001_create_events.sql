@@ -0,0 +1,59 @@
+CREATE TABLE batches (
+	id          BIGSERIAL   PRIMARY KEY,
+	tenant      TEXT        NOT NULL DEFAULT '',
+	client_id   TEXT        NOT NULL,
+	batch_id    TEXT        NOT NULL,
+	session_id  TEXT        NOT NULL DEFAULT '',
+	batch_time  TIMESTAMPTZ NOT NULL,
+	received_at TIMESTAMPTZ NOT NULL,
+	is_retry    BOOLEAN     NOT NULL DEFAULT FALSE,
+	event_count INTEGER     NOT NULL,
+	UNIQUE (tenant, client_id, batch_id)
+);
+
+CREATE TABLE events (
+	id           BIGSERIAL   PRIMARY KEY,
+	batch_ref    BIGINT      NOT NULL REFERENCES batches (id) ON DELETE CASCADE,
+	event_index  INTEGER     NOT NULL,
+	event_name   TEXT        NOT NULL,
+	video_id     TEXT        NOT NULL,
+	session_id   TEXT        NOT NULL,
+	user_id      TEXT        NOT NULL DEFAULT '',
+	anonymous_id TEXT        NOT NULL DEFAULT '',
+	event_time   TIMESTAMPTZ NOT NULL,
+	client_time  TIMESTAMPTZ,
+	custom_data  TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX events_video_time ON events (video_id, event_time);
+CREATE INDEX events_session_time ON events (session_id, event_time);
+CREATE INDEX events_batch ON events (batch_ref);
+
+CREATE TABLE playback_states (
+	event_ref     BIGINT           PRIMARY KEY REFERENCES events (id) ON DELETE CASCADE,
+	playhead      DOUBLE PRECISION NOT NULL,
+	duration      DOUBLE PRECISION NOT NULL,
+	paused        BOOLEAN          NOT NULL,
+	ended         BOOLEAN          NOT NULL,
+	playback_rate DOUBLE PRECISION NOT NULL,
+	volume        DOUBLE PRECISION NOT NULL,
+	muted         BOOLEAN          NOT NULL,
+	fullscreen    BOOLEAN          NOT NULL,
+	network_state INTEGER          NOT NULL,
+	ready_state   INTEGER          NOT NULL,
+	bitrate       DOUBLE PRECISION,
+	buffer_length DOUBLE PRECISION,
+	quality       TEXT
+);
+
+CREATE TABLE event_environments (
+	event_ref         BIGINT PRIMARY KEY REFERENCES events (id) ON DELETE CASCADE,
+	user_agent        TEXT   NOT NULL DEFAULT '',
+	screen_resolution TEXT   NOT NULL DEFAULT '',
+	viewport_size     TEXT   NOT NULL DEFAULT '',
+	player_size       TEXT   NOT NULL DEFAULT '',
+	connection_type   TEXT   NOT NULL DEFAULT '',
+	page_url          TEXT   NOT NULL DEFAULT '',
+	referrer          TEXT   NOT NULL DEFAULT '',
+	page_title        TEXT   NOT NULL DEFAULT ''
+);
@@ -0,0 +1 @@
+ALTER TABLE events ADD COLUMN sample_rate DOUBLE PRECISION;
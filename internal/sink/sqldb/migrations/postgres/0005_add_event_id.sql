@@ -0,0 +1,2 @@
+ALTER TABLE events ADD COLUMN event_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX events_event_id ON events (event_id);
@@ -0,0 +1,2 @@
+ALTER TABLE events ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE events ADD COLUMN bot_reason TEXT NOT NULL DEFAULT '';
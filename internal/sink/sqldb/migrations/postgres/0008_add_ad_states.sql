@@ -0,0 +1,10 @@
+CREATE TABLE ad_states (
+	event_ref   BIGINT  PRIMARY KEY REFERENCES events (id) ON DELETE CASCADE,
+	ad_id       TEXT    NOT NULL,
+	creative_id TEXT,
+	position    TEXT,
+	quartile    INTEGER,
+	skippable   BOOLEAN NOT NULL
+);
+
+CREATE INDEX ad_states_ad ON ad_states (ad_id);
@@ -0,0 +1,47 @@
+CREATE TABLE metric_rollups_1m (
+	tenant         TEXT             NOT NULL,
+	video_id       TEXT             NOT NULL,
+	bucket_start   TIMESTAMPTZ      NOT NULL,
+	sessions       BIGINT           NOT NULL,
+	plays          BIGINT           NOT NULL,
+	completes      BIGINT           NOT NULL,
+	error_sessions BIGINT           NOT NULL,
+	watch_time     DOUBLE PRECISION NOT NULL,
+	rebuffer_time  DOUBLE PRECISION NOT NULL,
+	rebuffers      BIGINT           NOT NULL,
+	PRIMARY KEY (tenant, video_id, bucket_start)
+);
+
+CREATE INDEX metric_rollups_1m_time ON metric_rollups_1m (tenant, bucket_start);
+
+CREATE TABLE metric_rollups_1h (
+	tenant         TEXT             NOT NULL,
+	video_id       TEXT             NOT NULL,
+	bucket_start   TIMESTAMPTZ      NOT NULL,
+	sessions       BIGINT           NOT NULL,
+	plays          BIGINT           NOT NULL,
+	completes      BIGINT           NOT NULL,
+	error_sessions BIGINT           NOT NULL,
+	watch_time     DOUBLE PRECISION NOT NULL,
+	rebuffer_time  DOUBLE PRECISION NOT NULL,
+	rebuffers      BIGINT           NOT NULL,
+	PRIMARY KEY (tenant, video_id, bucket_start)
+);
+
+CREATE INDEX metric_rollups_1h_time ON metric_rollups_1h (tenant, bucket_start);
+
+CREATE TABLE metric_rollups_1d (
+	tenant         TEXT             NOT NULL,
+	video_id       TEXT             NOT NULL,
+	bucket_start   TIMESTAMPTZ      NOT NULL,
+	sessions       BIGINT           NOT NULL,
+	plays          BIGINT           NOT NULL,
+	completes      BIGINT           NOT NULL,
+	error_sessions BIGINT           NOT NULL,
+	watch_time     DOUBLE PRECISION NOT NULL,
+	rebuffer_time  DOUBLE PRECISION NOT NULL,
+	rebuffers      BIGINT           NOT NULL,
+	PRIMARY KEY (tenant, video_id, bucket_start)
+);
+
+CREATE INDEX metric_rollups_1d_time ON metric_rollups_1d (tenant, bucket_start);
@@ -0,0 +1,12 @@
+ALTER TABLE events ADD COLUMN event_category TEXT NOT NULL DEFAULT 'video';
+
+CREATE INDEX events_category ON events (event_category, event_time);
+
+CREATE TABLE interactions (
+	event_ref  BIGINT PRIMARY KEY REFERENCES events (id) ON DELETE CASCADE,
+	element    TEXT   NOT NULL,
+	label      TEXT,
+	target_url TEXT
+);
+
+CREATE INDEX interactions_element ON interactions (element);
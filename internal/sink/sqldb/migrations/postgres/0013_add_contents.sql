@@ -0,0 +1,9 @@
+CREATE TABLE contents (
+	event_ref BIGINT           PRIMARY KEY REFERENCES events (id) ON DELETE CASCADE,
+	title     TEXT,
+	duration  DOUBLE PRECISION,
+	category  TEXT,
+	publisher TEXT
+);
+
+CREATE INDEX contents_category ON contents (category);
@@ -0,0 +1,59 @@
+CREATE TABLE batches (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	tenant      TEXT    NOT NULL DEFAULT '',
+	client_id   TEXT    NOT NULL,
+	batch_id    TEXT    NOT NULL,
+	session_id  TEXT    NOT NULL DEFAULT '',
+	batch_time  TEXT    NOT NULL,
+	received_at TEXT    NOT NULL,
+	is_retry    INTEGER NOT NULL DEFAULT 0,
+	event_count INTEGER NOT NULL,
+	UNIQUE (tenant, client_id, batch_id)
+);
+
+CREATE TABLE events (
+	id           INTEGER PRIMARY KEY AUTOINCREMENT,
+	batch_ref    INTEGER NOT NULL REFERENCES batches (id) ON DELETE CASCADE,
+	event_index  INTEGER NOT NULL,
+	event_name   TEXT    NOT NULL,
+	video_id     TEXT    NOT NULL,
+	session_id   TEXT    NOT NULL,
+	user_id      TEXT    NOT NULL DEFAULT '',
+	anonymous_id TEXT    NOT NULL DEFAULT '',
+	event_time   TEXT    NOT NULL,
+	client_time  TEXT,
+	custom_data  TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE INDEX events_video_time ON events (video_id, event_time);
+CREATE INDEX events_session_time ON events (session_id, event_time);
+CREATE INDEX events_batch ON events (batch_ref);
+
+CREATE TABLE playback_states (
+	event_ref     INTEGER PRIMARY KEY REFERENCES events (id) ON DELETE CASCADE,
+	playhead      REAL    NOT NULL,
+	duration      REAL    NOT NULL,
+	paused        INTEGER NOT NULL,
+	ended         INTEGER NOT NULL,
+	playback_rate REAL    NOT NULL,
+	volume        REAL    NOT NULL,
+	muted         INTEGER NOT NULL,
+	fullscreen    INTEGER NOT NULL,
+	network_state INTEGER NOT NULL,
+	ready_state   INTEGER NOT NULL,
+	bitrate       REAL,
+	buffer_length REAL,
+	quality       TEXT
+);
+
+CREATE TABLE event_environments (
+	event_ref         INTEGER PRIMARY KEY REFERENCES events (id) ON DELETE CASCADE,
+	user_agent        TEXT NOT NULL DEFAULT '',
+	screen_resolution TEXT NOT NULL DEFAULT '',
+	viewport_size     TEXT NOT NULL DEFAULT '',
+	player_size       TEXT NOT NULL DEFAULT '',
+	connection_type   TEXT NOT NULL DEFAULT '',
+	page_url          TEXT NOT NULL DEFAULT '',
+	referrer          TEXT NOT NULL DEFAULT '',
+	page_title        TEXT NOT NULL DEFAULT ''
+);
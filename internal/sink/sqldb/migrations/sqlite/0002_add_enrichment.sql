@@ -0,0 +1,12 @@
+ALTER TABLE event_environments ADD COLUMN country TEXT;
+ALTER TABLE event_environments ADD COLUMN region TEXT;
+ALTER TABLE event_environments ADD COLUMN city TEXT;
+ALTER TABLE event_environments ADD COLUMN asn INTEGER;
+ALTER TABLE event_environments ADD COLUMN as_org TEXT;
+ALTER TABLE event_environments ADD COLUMN device_type TEXT;
+ALTER TABLE event_environments ADD COLUMN os TEXT;
+ALTER TABLE event_environments ADD COLUMN os_version TEXT;
+ALTER TABLE event_environments ADD COLUMN browser TEXT;
+ALTER TABLE event_environments ADD COLUMN browser_version TEXT;
+
+CREATE INDEX event_environments_country ON event_environments (country);
@@ -0,0 +1 @@
+CREATE INDEX events_user ON events (user_id);
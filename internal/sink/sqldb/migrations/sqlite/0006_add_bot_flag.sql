@@ -0,0 +1,2 @@
+ALTER TABLE events ADD COLUMN is_bot INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE events ADD COLUMN bot_reason TEXT NOT NULL DEFAULT '';
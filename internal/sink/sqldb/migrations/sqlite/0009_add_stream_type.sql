@@ -0,0 +1,4 @@
+ALTER TABLE playback_states ADD COLUMN live_latency REAL;
+ALTER TABLE event_environments ADD COLUMN stream_type TEXT;
+
+CREATE INDEX event_environments_stream_type ON event_environments (stream_type);
@@ -0,0 +1,4 @@
+ALTER TABLE event_environments ADD COLUMN cdn TEXT;
+ALTER TABLE event_environments ADD COLUMN edge_pop TEXT;
+
+CREATE INDEX event_environments_cdn ON event_environments (cdn, edge_pop);
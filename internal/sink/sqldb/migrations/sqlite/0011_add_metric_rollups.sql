@@ -0,0 +1,47 @@
+CREATE TABLE metric_rollups_1m (
+	tenant         TEXT    NOT NULL,
+	video_id       TEXT    NOT NULL,
+	bucket_start   TEXT    NOT NULL,
+	sessions       INTEGER NOT NULL,
+	plays          INTEGER NOT NULL,
+	completes      INTEGER NOT NULL,
+	error_sessions INTEGER NOT NULL,
+	watch_time     REAL    NOT NULL,
+	rebuffer_time  REAL    NOT NULL,
+	rebuffers      INTEGER NOT NULL,
+	PRIMARY KEY (tenant, video_id, bucket_start)
+);
+
+CREATE INDEX metric_rollups_1m_time ON metric_rollups_1m (tenant, bucket_start);
+
+CREATE TABLE metric_rollups_1h (
+	tenant         TEXT    NOT NULL,
+	video_id       TEXT    NOT NULL,
+	bucket_start   TEXT    NOT NULL,
+	sessions       INTEGER NOT NULL,
+	plays          INTEGER NOT NULL,
+	completes      INTEGER NOT NULL,
+	error_sessions INTEGER NOT NULL,
+	watch_time     REAL    NOT NULL,
+	rebuffer_time  REAL    NOT NULL,
+	rebuffers      INTEGER NOT NULL,
+	PRIMARY KEY (tenant, video_id, bucket_start)
+);
+
+CREATE INDEX metric_rollups_1h_time ON metric_rollups_1h (tenant, bucket_start);
+
+CREATE TABLE metric_rollups_1d (
+	tenant         TEXT    NOT NULL,
+	video_id       TEXT    NOT NULL,
+	bucket_start   TEXT    NOT NULL,
+	sessions       INTEGER NOT NULL,
+	plays          INTEGER NOT NULL,
+	completes      INTEGER NOT NULL,
+	error_sessions INTEGER NOT NULL,
+	watch_time     REAL    NOT NULL,
+	rebuffer_time  REAL    NOT NULL,
+	rebuffers      INTEGER NOT NULL,
+	PRIMARY KEY (tenant, video_id, bucket_start)
+);
+
+CREATE INDEX metric_rollups_1d_time ON metric_rollups_1d (tenant, bucket_start);
@@ -0,0 +1,313 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var _ sink.Querier = (*Sink)(nil)
+
+// selectEvents reads events back together with their batch, playback
+// state, environment, ad, interaction and content
+const selectEvents = `SELECT
+	e.id, b.tenant, b.client_id, b.batch_id, b.session_id, b.batch_time, b.received_at, b.is_retry,
+	e.event_id, e.event_name, e.video_id, e.session_id, e.user_id, e.anonymous_id,
+	e.event_time, e.client_time, e.custom_data, e.sample_rate, e.is_bot, e.bot_reason, e.event_category,
+	p.event_ref, p.playhead, p.duration, p.paused, p.ended, p.playback_rate, p.volume, p.muted,
+	p.fullscreen, p.network_state, p.ready_state, p.bitrate, p.buffer_length, p.quality, p.live_latency,
+	v.event_ref, v.user_agent, v.screen_resolution, v.viewport_size, v.player_size, v.connection_type,
+	v.page_url, v.referrer, v.page_title, v.country, v.region, v.city, v.asn, v.as_org,
+	v.device_type, v.os, v.os_version, v.browser, v.browser_version, v.stream_type, v.cdn, v.edge_pop,
+	a.event_ref, a.ad_id, a.creative_id, a.position, a.quartile, a.skippable,
+	i.event_ref, i.element, i.label, i.target_url,
+	c.event_ref, c.title, c.duration, c.category, c.publisher
+FROM events e
+JOIN batches b ON b.id = e.batch_ref
+LEFT JOIN playback_states p ON p.event_ref = e.id
+LEFT JOIN event_environments v ON v.event_ref = e.id
+LEFT JOIN ad_states a ON a.event_ref = e.id
+LEFT JOIN interactions i ON i.event_ref = e.id
+LEFT JOIN contents c ON c.event_ref = e.id`
+
+// QueryEvents selects the matching events ordered by event time, then by
+// row ID, which the cursor continues from
+func (s *Sink) QueryEvents(ctx context.Context, q sink.Query) (sink.QueryResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return sink.QueryResult{}, ErrClosed
+	}
+
+	cursor, err := sink.ParseCursor(q.Cursor)
+	if err != nil {
+		return sink.QueryResult{}, err
+	}
+	var afterID int64
+	if q.Cursor != "" {
+		if afterID, err = strconv.ParseInt(cursor.Key, 10, 64); err != nil {
+			return sink.QueryResult{}, sink.ErrInvalidCursor
+		}
+	}
+	if q.Limit <= 0 {
+		q.Limit = sink.DefaultQueryLimit
+	}
+
+	where := []string{"b.tenant = ?"}
+	args := []any{q.Tenant}
+	for _, filter := range []struct{ column, value string }{
+		{"e.session_id", q.SessionID},
+		{"e.video_id", q.VideoID},
+		{"e.event_name", q.EventName},
+		{"e.event_category", q.EventCategory},
+		{"b.client_id", q.ClientID},
+	} {
+		if filter.value != "" {
+			where = append(where, filter.column+" = ?")
+			args = append(args, filter.value)
+		}
+	}
+	if !q.From.IsZero() {
+		where = append(where, "e.event_time >= ?")
+		args = append(args, s.dialect.time(q.From))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "e.event_time < ?")
+		args = append(args, s.dialect.time(q.To))
+	}
+	if q.Cursor != "" {
+		where = append(where, "(e.event_time > ? OR (e.event_time = ? AND e.id > ?))")
+		args = append(args, s.dialect.time(cursor.Time), s.dialect.time(cursor.Time), afterID)
+	}
+	// One more than the limit tells whether there is a next page
+	args = append(args, q.Limit+1)
+
+	query := selectEvents + "\nWHERE " + strings.Join(where, " AND ") + "\nORDER BY e.event_time, e.id LIMIT ?"
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return sink.QueryResult{}, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	result := sink.QueryResult{Events: []models.EventRecord{}}
+	var last sink.Cursor
+	for rows.Next() {
+		if len(result.Events) == q.Limit {
+			result.NextCursor = last.Encode()
+			break
+		}
+		id, eventTime, record, err := scanEvent(rows)
+		if err != nil {
+			return sink.QueryResult{}, fmt.Errorf("failed to read event: %w", err)
+		}
+		result.Events = append(result.Events, record)
+		last = sink.Cursor{Time: eventTime, Key: strconv.FormatInt(id, 10)}
+	}
+	if err := rows.Err(); err != nil {
+		return sink.QueryResult{}, fmt.Errorf("failed to query events: %w", err)
+	}
+	return result, nil
+}
+
+// scanEvent reads a row of selectEvents
+func scanEvent(rows *sql.Rows) (int64, time.Time, models.EventRecord, error) {
+	var (
+		id                            int64
+		record                        models.EventRecord
+		batchTime, receivedAt         timeValue
+		eventTime, clientTime         timeValue
+		sampleRate                    sql.NullFloat64
+		playbackRef                   sql.NullInt64
+		p                             nullPlayback
+		environmentRef                sql.NullInt64
+		t                             nullTechnical
+		pageURL, referrer, pageTitle  sql.NullString
+		country, region, city, asOrg  sql.NullString
+		asn                           sql.NullInt64
+		deviceType, osName, osVersion sql.NullString
+		browser, browserVersion       sql.NullString
+		adRef                         sql.NullInt64
+		ad                            nullAd
+		interactionRef                sql.NullInt64
+		element, label, targetURL     sql.NullString
+		contentRef                    sql.NullInt64
+		title, category, publisher    sql.NullString
+		duration                      sql.NullFloat64
+	)
+	err := rows.Scan(
+		&id, &record.Tenant, &record.ClientID, &record.BatchID, &record.BatchSessionID, &batchTime, &receivedAt, &record.IsRetry,
+		&record.EventID, &record.EventName, &record.VideoID, &record.SessionID, &record.UserID, &record.AnonymousID,
+		&eventTime, &clientTime, &record.CustomData, &sampleRate, &record.IsBot, &record.BotReason, &record.EventCategory,
+		&playbackRef, &p.CurrentTime, &p.Duration, &p.Paused, &p.Ended, &p.PlaybackRate, &p.Volume, &p.Muted,
+		&p.Fullscreen, &p.NetworkState, &p.ReadyState, &p.Bitrate, &p.BufferLength, &p.Quality, &p.LiveLatency,
+		&environmentRef, &t.UserAgent, &t.ScreenResolution, &t.ViewportSize, &t.PlayerSize, &t.ConnectionType,
+		&pageURL, &referrer, &pageTitle, &country, &region, &city, &asn, &asOrg,
+		&deviceType, &osName, &osVersion, &browser, &browserVersion, &t.StreamType, &t.CDN, &t.EdgePOP,
+		&adRef, &ad.AdID, &ad.CreativeID, &ad.Position, &ad.Quartile, &ad.Skippable,
+		&interactionRef, &element, &label, &targetURL,
+		&contentRef, &title, &duration, &category, &publisher,
+	)
+	if err != nil {
+		return 0, time.Time{}, models.EventRecord{}, err
+	}
+
+	record.BatchTimestamp = batchTime.String()
+	record.ReceivedAt = receivedAt.String()
+	record.Timestamp = eventTime.String()
+	record.ClientTimestamp = clientTime.String()
+	record.Sampled = sampleRate.Valid
+	record.SampleRate = sampleRate.Float64
+
+	if playbackRef.Valid {
+		record.PlaybackState = p.state()
+	}
+	if environmentRef.Valid {
+		record.Technical = t.technical()
+		record.Context = &models.Context{PageURL: pageURL.String, Referrer: referrer.String, PageTitle: pageTitle.String}
+		if country.Valid || region.Valid || city.Valid || asn.Valid || asOrg.Valid {
+			record.Context.Geo = &models.Geo{
+				Country: country.String,
+				Region:  region.String,
+				City:    city.String,
+				ASN:     uint(asn.Int64),
+				ASOrg:   asOrg.String,
+			}
+		}
+		if deviceType.Valid || osName.Valid || browser.Valid {
+			record.Context.Device = &models.Device{
+				Type:           deviceType.String,
+				OS:             osName.String,
+				OSVersion:      osVersion.String,
+				Browser:        browser.String,
+				BrowserVersion: browserVersion.String,
+			}
+		}
+	}
+	if adRef.Valid {
+		record.AdState = ad.state()
+	}
+	if interactionRef.Valid {
+		record.Interaction = &models.Interaction{Element: element.String, Label: label.String, TargetURL: targetURL.String}
+	}
+	if contentRef.Valid {
+		record.Content = &models.Content{
+			Title:     title.String,
+			Duration:  duration.Float64,
+			Category:  category.String,
+			Publisher: publisher.String,
+		}
+	}
+	return id, eventTime.Time, record, nil
+}
+
+// nullAd scans the columns of ad_states
+type nullAd struct {
+	AdID, CreativeID, Position sql.NullString
+	Quartile                   sql.NullInt64
+	Skippable                  sql.NullBool
+}
+
+func (a nullAd) state() *models.AdState {
+	return &models.AdState{
+		AdID:       a.AdID.String,
+		CreativeID: a.CreativeID.String,
+		Position:   a.Position.String,
+		Quartile:   int(a.Quartile.Int64),
+		Skippable:  a.Skippable.Bool,
+	}
+}
+
+// nullPlayback scans the columns of playback_states, which are NULL when
+// the event has no playback state
+type nullPlayback struct {
+	CurrentTime, Duration, PlaybackRate, Volume sql.NullFloat64
+	Paused, Ended, Muted, Fullscreen            sql.NullBool
+	NetworkState, ReadyState                    sql.NullInt64
+	Bitrate, BufferLength, LiveLatency          sql.NullFloat64
+	Quality                                     sql.NullString
+}
+
+func (p nullPlayback) state() *models.PlaybackState {
+	return &models.PlaybackState{
+		CurrentTime:  p.CurrentTime.Float64,
+		Duration:     p.Duration.Float64,
+		Paused:       p.Paused.Bool,
+		Ended:        p.Ended.Bool,
+		PlaybackRate: p.PlaybackRate.Float64,
+		Volume:       p.Volume.Float64,
+		Muted:        p.Muted.Bool,
+		Fullscreen:   p.Fullscreen.Bool,
+		NetworkState: int(p.NetworkState.Int64),
+		ReadyState:   int(p.ReadyState.Int64),
+		Bitrate:      p.Bitrate.Float64,
+		BufferLength: p.BufferLength.Float64,
+		Quality:      p.Quality.String,
+		LiveLatency:  p.LiveLatency.Float64,
+	}
+}
+
+// nullTechnical scans the technical columns of event_environments
+type nullTechnical struct {
+	UserAgent, ScreenResolution, ViewportSize, PlayerSize, ConnectionType sql.NullString
+	StreamType, CDN, EdgePOP                                              sql.NullString
+}
+
+func (t nullTechnical) technical() *models.Technical {
+	return &models.Technical{
+		UserAgent:        t.UserAgent.String,
+		ScreenResolution: t.ScreenResolution.String,
+		ViewportSize:     t.ViewportSize.String,
+		PlayerSize:       t.PlayerSize.String,
+		ConnectionType:   t.ConnectionType.String,
+		StreamType:       t.StreamType.String,
+		CDN:              t.CDN.String,
+		EdgePOP:          t.EdgePOP.String,
+	}
+}
+
+// timeValue scans a timestamp column, which is native in Postgres and
+// text in SQLite
+type timeValue struct {
+	time.Time
+	Valid bool
+}
+
+func (v *timeValue) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		*v = timeValue{}
+		return nil
+	case time.Time:
+		*v = timeValue{Time: src.UTC(), Valid: true}
+		return nil
+	case string:
+		return v.parse(src)
+	case []byte:
+		return v.parse(string(src))
+	}
+	return fmt.Errorf("unsupported timestamp type %T", src)
+}
+
+func (v *timeValue) parse(s string) error {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	*v = timeValue{Time: t.UTC(), Valid: true}
+	return nil
+}
+
+// String formats the time like ingest normalizes timestamps, or returns ""
+// for NULL
+func (v timeValue) String() string {
+	if !v.Valid {
+		return ""
+	}
+	return v.Time.Format(timeLayout)
+}
@@ -0,0 +1,133 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/rollup"
+)
+
+var _ rollup.Store = (*Sink)(nil)
+
+// rollupTables are the tables of the rollup tiers
+var rollupTables = map[string]string{
+	rollup.Minute: "metric_rollups_1m",
+	rollup.Hour:   "metric_rollups_1h",
+	rollup.Day:    "metric_rollups_1d",
+}
+
+func rollupTable(tier string) (string, error) {
+	table, ok := rollupTables[tier]
+	if !ok {
+		return "", fmt.Errorf("unknown rollup tier %q", tier)
+	}
+	return table, nil
+}
+
+// AddRollups adds rows to the rollup table of tier in one transaction
+func (s *Sink) AddRollups(ctx context.Context, tier string, rows []rollup.Row) error {
+	table, err := rollupTable(tier)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.dialect.rebind(`INSERT INTO `+table+`
+		(tenant, video_id, bucket_start, sessions, plays, completes, error_sessions, watch_time, rebuffer_time, rebuffers)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (tenant, video_id, bucket_start) DO UPDATE SET
+			sessions = `+table+`.sessions + excluded.sessions,
+			plays = `+table+`.plays + excluded.plays,
+			completes = `+table+`.completes + excluded.completes,
+			error_sessions = `+table+`.error_sessions + excluded.error_sessions,
+			watch_time = `+table+`.watch_time + excluded.watch_time,
+			rebuffer_time = `+table+`.rebuffer_time + excluded.rebuffer_time,
+			rebuffers = `+table+`.rebuffers + excluded.rebuffers`))
+	if err != nil {
+		return fmt.Errorf("failed to prepare rollup insert: %w", err)
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row.Tenant, row.VideoID, s.dialect.time(row.Start),
+			row.Sessions, row.Plays, row.Completes, row.ErrorSessions,
+			row.WatchTimeSeconds, row.RebufferTimeSeconds, row.Rebuffers); err != nil {
+			return fmt.Errorf("failed to insert rollup: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rollups: %w", err)
+	}
+	return nil
+}
+
+// QueryRollups returns the points of q.Tier, one per video and bucket
+func (s *Sink) QueryRollups(ctx context.Context, q rollup.Query) ([]rollup.Point, error) {
+	table, err := rollupTable(q.Tier)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	query := `SELECT video_id, bucket_start, sessions, plays, completes, error_sessions, watch_time, rebuffer_time, rebuffers
+		FROM ` + table + ` WHERE tenant = ? AND bucket_start >= ? AND bucket_start < ?`
+	args := []any{q.Tenant, s.dialect.time(q.From), s.dialect.time(q.To)}
+	if q.VideoID != "" {
+		query += " AND video_id = ?"
+		args = append(args, q.VideoID)
+	}
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rollups: %w", err)
+	}
+	defer rows.Close()
+
+	var points []rollup.Point
+	for rows.Next() {
+		var point rollup.Point
+		var start timeValue
+		if err := rows.Scan(&point.VideoID, &start, &point.Sessions, &point.Plays, &point.Completes, &point.ErrorSessions,
+			&point.WatchTimeSeconds, &point.RebufferTimeSeconds, &point.Rebuffers); err != nil {
+			return nil, fmt.Errorf("failed to read rollup: %w", err)
+		}
+		point.Start = start.Time
+		points = append(points, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query rollups: %w", err)
+	}
+	return points, nil
+}
+
+// PruneRollups deletes the points of tier starting before before
+func (s *Sink) PruneRollups(ctx context.Context, tier string, before time.Time) (int64, error) {
+	table, err := rollupTable(tier)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(`DELETE FROM `+table+` WHERE bucket_start < ?`), s.dialect.time(before))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune rollups: %w", err)
+	}
+	return result.RowsAffected()
+}
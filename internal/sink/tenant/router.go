@@ -0,0 +1,191 @@
+// Package tenant routes event batches to a separate sink per tenant
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/adtyap26/event-stream-video/internal/auth"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+// ErrClosed is returned when a batch is logged after Close
+var ErrClosed = errors.New("tenant router is closed")
+
+// Factory creates the sink for one tenant. The name has been passed through
+// SafeName and can be used in paths and table names.
+type Factory func(tenant string) (sink.EventSink, error)
+
+var (
+	_ sink.EventSink     = (*Router)(nil)
+	_ sink.Flusher       = (*Router)(nil)
+	_ sink.HealthChecker = (*Router)(nil)
+	_ sink.Backlogger    = (*Router)(nil)
+	_ sink.Eraser        = (*Router)(nil)
+	_ sink.Querier       = (*Router)(nil)
+)
+
+// Router keeps one sink per tenant, created on the tenant's first batch
+type Router struct {
+	mu      sync.Mutex
+	factory Factory
+	sinks   map[string]sink.EventSink
+	closed  bool
+}
+
+func NewRouter(factory Factory) *Router {
+	return &Router{
+		factory: factory,
+		sinks:   make(map[string]sink.EventSink),
+	}
+}
+
+// LogBatch writes the batch to its tenant's sink
+func (r *Router) LogBatch(ctx context.Context, batch models.EventBatch) error {
+	tenantSink, err := r.sink(batch.Tenant)
+	if err != nil {
+		return err
+	}
+	return tenantSink.LogBatch(ctx, batch)
+}
+
+// sink returns the sink for tenant, creating it if needed
+func (r *Router) sink(tenant string) (sink.EventSink, error) {
+	name := SafeName(tenant)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, ErrClosed
+	}
+	if tenantSink, ok := r.sinks[name]; ok {
+		return tenantSink, nil
+	}
+
+	tenantSink, err := r.factory(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sink for tenant %s: %w", name, err)
+	}
+	r.sinks[name] = tenantSink
+	return tenantSink, nil
+}
+
+// Flush flushes every tenant sink that supports it
+func (r *Router) Flush() error {
+	var errs []error
+	for _, tenantSink := range r.snapshot() {
+		if flusher, ok := tenantSink.(sink.Flusher); ok {
+			errs = append(errs, flusher.Flush())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckHealth checks every tenant sink that supports it
+func (r *Router) CheckHealth(ctx context.Context) error {
+	r.mu.Lock()
+	sinks := make(map[string]sink.EventSink, len(r.sinks))
+	for name, tenantSink := range r.sinks {
+		sinks[name] = tenantSink
+	}
+	r.mu.Unlock()
+
+	var errs []error
+	for name, tenantSink := range sinks {
+		checker, ok := tenantSink.(sink.HealthChecker)
+		if !ok {
+			continue
+		}
+		if err := checker.CheckHealth(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Backlog reports the backlog of the tenant sink whose queue is fullest,
+// so one tenant falling behind is enough to shed load
+func (r *Router) Backlog() (queued, capacity int) {
+	for _, tenantSink := range r.snapshot() {
+		backlogger, ok := tenantSink.(sink.Backlogger)
+		if !ok {
+			continue
+		}
+		q, c := backlogger.Backlog()
+		if c > 0 && (capacity == 0 || float64(q)/float64(c) > float64(queued)/float64(capacity)) {
+			queued, capacity = q, c
+		}
+	}
+	return queued, capacity
+}
+
+// EraseUser erases from the tenant's sink, creating it if no batch of the
+// tenant arrived since startup
+func (r *Router) EraseUser(ctx context.Context, tenant, userID string) (int64, error) {
+	tenantSink, err := r.sink(tenant)
+	if err != nil {
+		return 0, err
+	}
+	if eraser, ok := tenantSink.(sink.Eraser); ok {
+		return eraser.EraseUser(ctx, tenant, userID)
+	}
+	return 0, sink.ErrEraseUnsupported
+}
+
+// QueryEvents queries the sink of the query's tenant, creating it if no
+// batch of the tenant arrived since startup
+func (r *Router) QueryEvents(ctx context.Context, q sink.Query) (sink.QueryResult, error) {
+	tenantSink, err := r.sink(q.Tenant)
+	if err != nil {
+		return sink.QueryResult{}, err
+	}
+	if querier, ok := tenantSink.(sink.Querier); ok {
+		return querier.QueryEvents(ctx, q)
+	}
+	return sink.QueryResult{}, sink.ErrQueryUnsupported
+}
+
+// Close closes every tenant sink
+func (r *Router) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+
+	var errs []error
+	for _, tenantSink := range r.snapshot() {
+		errs = append(errs, tenantSink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Router) snapshot() []sink.EventSink {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sinks := make([]sink.EventSink, 0, len(r.sinks))
+	for _, tenantSink := range r.sinks {
+		sinks = append(sinks, tenantSink)
+	}
+	return sinks
+}
+
+// SafeName maps a tenant to a name made of letters, digits, '-' and '_'.
+// Empty tenants map to auth.DefaultTenant.
+func SafeName(tenant string) string {
+	if tenant == "" {
+		return auth.DefaultTenant
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, tenant)
+}
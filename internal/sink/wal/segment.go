@@ -0,0 +1,204 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A record is the length of its payload and the payload's CRC-32C, both
+// little-endian uint32, followed by the payload: one batch as JSON
+const headerSize = 8
+
+// maxRecordSize bounds the payload length read from a header, so a damaged
+// header can't make the reader allocate gigabytes
+const maxRecordSize = 256 << 20
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errDamaged is returned for records that were cut short or whose checksum
+// doesn't match, e.g. because the process died while appending them
+var errDamaged = errors.New("damaged write-ahead log record")
+
+// segmentExt is the extension of segment files, which are named after the
+// offset of their first record
+const segmentExt = ".wal"
+
+// segment is a file of the log holding the records from base on
+type segment struct {
+	base uint64
+	path string
+	size int64
+}
+
+func segmentPath(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+// listSegments returns the segments in dir, oldest first
+func listSegments(dir string) ([]segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list write-ahead log: %w", err)
+	}
+	var segments []segment
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), segmentExt)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		base, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list write-ahead log: %w", err)
+		}
+		segments = append(segments, segment{base: base, path: filepath.Join(dir, entry.Name()), size: info.Size()})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].base < segments[j].base })
+	return segments, nil
+}
+
+// encodeRecord frames payload as a record
+func encodeRecord(payload []byte) []byte {
+	record := make([]byte, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[headerSize:], payload)
+	return record
+}
+
+// readRecord reads the payload of the next record of r. It returns io.EOF
+// at a clean end of the segment and errDamaged for a partial or corrupt
+// record.
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errDamaged
+		}
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return nil, errDamaged
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errDamaged
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, errDamaged
+	}
+	return payload, nil
+}
+
+// recoverSegment counts the intact records of the segment at path and cuts
+// off whatever follows them, which is a record the process died appending.
+// It returns the record count and the segment's new size.
+func recoverSegment(path string) (uint64, int64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open write-ahead log segment: %w", err)
+	}
+	defer file.Close()
+
+	var count uint64
+	var size int64
+	for {
+		payload, err := readRecord(file)
+		if errors.Is(err, io.EOF) {
+			return count, size, nil
+		}
+		if errors.Is(err, errDamaged) {
+			if err := file.Truncate(size); err != nil {
+				return 0, 0, fmt.Errorf("failed to truncate write-ahead log segment: %w", err)
+			}
+			return count, size, file.Sync()
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to read write-ahead log segment: %w", err)
+		}
+		count++
+		size += headerSize + int64(len(payload))
+	}
+}
+
+// reader reads records in order, one segment after the other. offset is the
+// offset of the record the next read returns.
+type reader struct {
+	file   *os.File
+	base   uint64
+	offset uint64
+}
+
+// open opens the segment holding offset and skips to the record
+func (r *reader) open(seg segment) error {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return fmt.Errorf("failed to open write-ahead log segment: %w", err)
+	}
+	if r.offset < seg.base {
+		r.offset = seg.base
+	}
+	for skip := r.offset - seg.base; skip > 0; skip-- {
+		if _, err := readRecord(file); err != nil {
+			// Fewer records than the offset says; the next read ends the
+			// segment
+			break
+		}
+	}
+	r.file, r.base = file, seg.base
+	return nil
+}
+
+func (r *reader) close() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// readOffset reads the consumer's offset from path, zero when it doesn't
+// exist yet
+func readOffset(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read write-ahead log offset: %w", err)
+	}
+	offset, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read write-ahead log offset: %w", err)
+	}
+	return offset, nil
+}
+
+// writeOffset saves the consumer's offset under a temporary name and renames
+// it over path, so a crash leaves the old or the new offset
+func writeOffset(path string, offset uint64) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(strconv.FormatUint(offset, 10)+"\n"), 0644); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write write-ahead log offset: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write write-ahead log offset: %w", err)
+	}
+	return nil
+}
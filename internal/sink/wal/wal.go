@@ -0,0 +1,598 @@
+// Package wal puts a write-ahead log on local disk between the HTTP handlers
+// and the sink. Batches are appended to segment files and acknowledged as
+// soon as they are written; a consumer ships them to the sink in order and
+// records the offset it got to, so batches survive restarts and sink
+// outages without being lost.
+package wal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/deadletter"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/sink"
+)
+
+var (
+	_ sink.EventSink     = (*Log)(nil)
+	_ sink.Flusher       = (*Log)(nil)
+	_ sink.HealthChecker = (*Log)(nil)
+	_ sink.Backlogger    = (*Log)(nil)
+	_ sink.Eraser        = (*Log)(nil)
+	_ sink.Querier       = (*Log)(nil)
+)
+
+// ErrClosed is returned when a batch is logged after Close. It wraps
+// sink.ErrUnavailable.
+var ErrClosed = fmt.Errorf("write-ahead log is closed: %w", sink.ErrUnavailable)
+
+// ErrFull is returned while the segments on disk have reached MaxSize. It
+// wraps sink.ErrUnavailable.
+var ErrFull = fmt.Errorf("write-ahead log is full: %w", sink.ErrUnavailable)
+
+// offsetFile holds the offset of the first record not yet shipped
+const offsetFile = "offset"
+
+// Config configures the write-ahead log
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Dir holds the segment files and the consumer's offset
+	Dir string `yaml:"dir"`
+	// SegmentSize is how large a segment grows before the next one is
+	// started. Segments are deleted once all of their batches were shipped.
+	SegmentSize int64 `yaml:"segmentSize"`
+	// MaxSize bounds the segments on disk; batches are refused while it is
+	// reached. Zero doesn't bound them.
+	MaxSize int64 `yaml:"maxSize"`
+	// Sync flushes every append to the disk before the batch is
+	// acknowledged. Without it, a crash of the machine, though not of the
+	// process, can lose the last batches.
+	Sync bool `yaml:"sync"`
+	// RetryInterval is how long the consumer waits after the sink failed
+	RetryInterval time.Duration `yaml:"retryInterval"`
+	// MaxAttempts is how many times a batch is shipped before it is moved
+	// to the dead letter queue. Without a queue it is tried until it is
+	// stored.
+	MaxAttempts int `yaml:"maxAttempts"`
+	// CheckpointBatches and CheckpointInterval are how many batches are
+	// shipped, or how long, before the target is flushed and the offset
+	// saved. Sinks that buffer, such as ClickHouse, only hold shipped
+	// batches durably once flushed, so they stay in the log until then.
+	CheckpointBatches  int           `yaml:"checkpointBatches"`
+	CheckpointInterval time.Duration `yaml:"checkpointInterval"`
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		Dir:                "wal",
+		SegmentSize:        64 << 20,
+		Sync:               true,
+		RetryInterval:      time.Second,
+		MaxAttempts:        10,
+		CheckpointBatches:  1000,
+		CheckpointInterval: time.Second,
+	}
+}
+
+// withDefaults fills the unset fields of cfg
+func (cfg Config) withDefaults() Config {
+	defaults := DefaultConfig()
+	if cfg.Dir == "" {
+		cfg.Dir = defaults.Dir
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = defaults.SegmentSize
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaults.RetryInterval
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaults.MaxAttempts
+	}
+	if cfg.CheckpointBatches <= 0 {
+		cfg.CheckpointBatches = defaults.CheckpointBatches
+	}
+	if cfg.CheckpointInterval <= 0 {
+		cfg.CheckpointInterval = defaults.CheckpointInterval
+	}
+	return cfg
+}
+
+// Log appends batches to segment files and ships them to the target sink
+// from a single consumer. Offsets number the records from the first batch
+// ever logged. A batch is shipped at least once: after a crash between
+// storing a batch and saving the offset at the next checkpoint it is
+// shipped again, and sinks that deduplicate by batch ID store it once.
+type Log struct {
+	cfg         Config
+	target      sink.EventSink
+	deadLetters *deadletter.Queue
+
+	mu       sync.Mutex
+	closed   bool
+	segments []segment
+	active   *os.File
+	// size is the size of every segment together
+	size int64
+	// next is the offset the next batch is appended at, forwarded the
+	// offset of the first batch not yet handed to the target and committed
+	// that of the first batch not yet flushed by it
+	next      uint64
+	forwarded uint64
+	committed uint64
+
+	// uncommitted counts the batches forwarded since the last checkpoint,
+	// at checkpointed; only the consumer uses them
+	uncommitted  int
+	checkpointed time.Time
+
+	// notify wakes the consumer when a batch is appended, shipped wakes
+	// EraseUser when the consumer forwards one
+	notify  chan struct{}
+	shipped chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// Open opens or creates the log in cfg.Dir and starts shipping the batches
+// it holds to target. A record the process died appending is cut off.
+// Batches target fails to store MaxAttempts times are moved to deadLetters
+// when it isn't nil.
+func Open(cfg Config, target sink.EventSink, deadLetters *deadletter.Queue) (*Log, error) {
+	cfg = cfg.withDefaults()
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create write-ahead log directory: %w", err)
+	}
+
+	segments, err := listSegments(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := readOffset(filepath.Join(cfg.Dir, offsetFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var next uint64
+	if len(segments) == 0 {
+		next = committed
+		segments = []segment{{base: next, path: segmentPath(cfg.Dir, next)}}
+	} else {
+		last := &segments[len(segments)-1]
+		count, size, err := recoverSegment(last.path)
+		if err != nil {
+			return nil, err
+		}
+		last.size = size
+		next = last.base + count
+	}
+	committed = max(min(committed, next), segments[0].base)
+
+	active, err := os.OpenFile(segments[len(segments)-1].path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open write-ahead log segment: %w", err)
+	}
+
+	l := &Log{
+		cfg:         cfg,
+		target:      target,
+		deadLetters: deadLetters,
+		segments:    segments,
+		active:      active,
+		next:        next,
+		forwarded:   committed,
+		committed:   committed,
+		notify:      make(chan struct{}, 1),
+		shipped:     make(chan struct{}),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	for _, seg := range segments {
+		l.size += seg.size
+	}
+	l.removeShipped()
+	metrics.WALPending.Set(float64(next - committed))
+	if next > committed {
+		slog.Info("Shipping batches left in the write-ahead log", "batches", next-committed)
+	}
+
+	go l.consume()
+	return l, nil
+}
+
+// LogBatch appends batch to the log. The batch is stored by the target
+// later; the API key is not written to disk.
+func (l *Log) LogBatch(_ context.Context, batch models.EventBatch) error {
+	batch.APIKey = ""
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+	record := encodeRecord(payload)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if l.cfg.MaxSize > 0 && l.size+int64(len(record)) > l.cfg.MaxSize {
+		return ErrFull
+	}
+	activeSeg := &l.segments[len(l.segments)-1]
+	if activeSeg.size > 0 && activeSeg.size+int64(len(record)) > l.cfg.SegmentSize {
+		if err := l.roll(); err != nil {
+			return err
+		}
+		activeSeg = &l.segments[len(l.segments)-1]
+	}
+
+	if _, err := l.active.Write(record); err != nil {
+		// Cut off what was written, so the reader doesn't take it for a
+		// damaged record
+		l.active.Truncate(activeSeg.size)
+		return fmt.Errorf("failed to append to write-ahead log: %w", err)
+	}
+	if l.cfg.Sync {
+		if err := l.active.Sync(); err != nil {
+			l.active.Truncate(activeSeg.size)
+			return fmt.Errorf("failed to sync write-ahead log: %w", err)
+		}
+	}
+	activeSeg.size += int64(len(record))
+	l.size += int64(len(record))
+	l.next++
+	metrics.WALPending.Set(float64(l.next - l.committed))
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// roll starts a new segment at the next offset. l.mu must be held.
+func (l *Log) roll() error {
+	seg := segment{base: l.next, path: segmentPath(l.cfg.Dir, l.next)}
+	file, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to start write-ahead log segment: %w", err)
+	}
+	if err := l.active.Close(); err != nil {
+		slog.Warn("Error closing write-ahead log segment", "segment", l.segments[len(l.segments)-1].path, "error", err)
+	}
+	l.active = file
+	l.segments = append(l.segments, seg)
+	l.removeShipped()
+	return nil
+}
+
+// consume ships the batches from the committed offset on, waiting for new
+// ones when it has caught up
+func (l *Log) consume() {
+	defer close(l.done)
+
+	l.mu.Lock()
+	r := &reader{offset: l.committed}
+	l.mu.Unlock()
+	defer r.close()
+
+	l.checkpointed = time.Now()
+	for {
+		batch, offset, ok := l.read(r)
+		if !ok {
+			return
+		}
+		if !l.ship(batch) {
+			return
+		}
+		l.forward(offset + 1)
+		if l.uncommitted >= l.cfg.CheckpointBatches || time.Since(l.checkpointed) >= l.cfg.CheckpointInterval {
+			l.checkpoint()
+		}
+	}
+}
+
+// read returns the next batch and its offset, waiting until one was
+// appended. ok is false once the log is closing.
+func (l *Log) read(r *reader) (batch models.EventBatch, offset uint64, ok bool) {
+	for {
+		l.mu.Lock()
+		available := r.offset < l.next
+		l.mu.Unlock()
+		if !available {
+			// Caught up: check the forwarded batches in once they are due,
+			// not only when more arrive
+			var due <-chan time.Time
+			var timer *time.Timer
+			if l.uncommitted > 0 {
+				timer = time.NewTimer(time.Until(l.checkpointed.Add(l.cfg.CheckpointInterval)))
+				due = timer.C
+			}
+			select {
+			case <-l.notify:
+			case <-due:
+				l.checkpoint()
+			case <-l.stop:
+				return models.EventBatch{}, 0, false
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		}
+
+		if r.file == nil {
+			if err := r.open(l.segmentOf(r.offset)); err != nil {
+				slog.Error("Failed to read write-ahead log", "offset", r.offset, "error", err)
+				if !l.pause() {
+					return models.EventBatch{}, 0, false
+				}
+				continue
+			}
+		}
+
+		payload, err := readRecord(r.file)
+		if err == nil {
+			offset = r.offset
+			r.offset++
+			if err := json.Unmarshal(payload, &batch); err != nil {
+				// Trying again won't make the record readable
+				slog.Error("Dropped unreadable write-ahead log record", "offset", offset, "error", err)
+				l.forward(offset + 1)
+				continue
+			}
+			return batch, offset, true
+		}
+
+		// The end of the segment, or damage that ends it early: go on with
+		// the next one
+		if !errors.Is(err, errDamaged) && !errors.Is(err, io.EOF) {
+			slog.Error("Failed to read write-ahead log", "offset", r.offset, "error", err)
+		}
+		r.close()
+		nextBase, found := l.segmentAfter(r.base)
+		if !found {
+			// The appended batch isn't readable yet; try again shortly
+			if !l.pause() {
+				return models.EventBatch{}, 0, false
+			}
+			continue
+		}
+		if errors.Is(err, errDamaged) || r.offset < nextBase {
+			slog.Error("Skipping damaged write-ahead log records", "from", r.offset, "to", nextBase)
+			l.forward(nextBase)
+		}
+		r.offset = nextBase
+	}
+}
+
+// ship stores batch in the target, retrying until it is stored or moved to
+// the dead letter queue. It reports false when the log closed first; the
+// batch is then shipped again by the next run.
+func (l *Log) ship(batch models.EventBatch) bool {
+	// The request that appended the batch is long done
+	ctx := context.Background()
+	for attempt := 1; ; attempt++ {
+		err := l.target.LogBatch(ctx, batch)
+		if err == nil {
+			return true
+		}
+
+		if l.deadLetters == nil || attempt < l.cfg.MaxAttempts {
+			slog.Warn("Failed to ship batch from write-ahead log, will retry",
+				"clientId", batch.ClientID, "batchId", batch.BatchID, "attempts", attempt, "error", err)
+			if !l.pause() {
+				return false
+			}
+			continue
+		}
+		if dlqErr := l.deadLetters.Add(batch, err); dlqErr != nil {
+			slog.Error("Failed to dead-letter batch from write-ahead log",
+				"clientId", batch.ClientID, "batchId", batch.BatchID, "error", errors.Join(err, dlqErr))
+			if !l.pause() {
+				return false
+			}
+			continue
+		}
+		metrics.DeadLettered.WithLabelValues(batch.Tenant).Inc()
+		slog.Warn("Dead-lettered batch from write-ahead log",
+			"clientId", batch.ClientID, "batchId", batch.BatchID, "attempts", attempt, "error", err)
+		return true
+	}
+}
+
+// forward records that the batches before offset were handed to the
+// target, or skipped, and are to be committed by the next checkpoint
+func (l *Log) forward(offset uint64) {
+	l.mu.Lock()
+	l.forwarded = max(l.forwarded, offset)
+	close(l.shipped)
+	l.shipped = make(chan struct{})
+	l.mu.Unlock()
+	l.uncommitted++
+}
+
+// checkpoint flushes the target, if it buffers writes, and commits the
+// batches forwarded to it. When the flush fails they stay in the log, to
+// be shipped again after a restart, and the next checkpoint tries again.
+func (l *Log) checkpoint() {
+	l.checkpointed = time.Now()
+	if flusher, ok := l.target.(sink.Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			slog.Warn("Failed to flush the sink, keeping shipped batches in the write-ahead log", "error", err)
+			return
+		}
+	}
+	l.mu.Lock()
+	offset := l.forwarded
+	l.mu.Unlock()
+	l.commit(offset)
+	l.uncommitted = 0
+}
+
+// commit records that the batches before offset are stored and deletes
+// the segments holding only those
+func (l *Log) commit(offset uint64) {
+	if err := writeOffset(filepath.Join(l.cfg.Dir, offsetFile), offset); err != nil {
+		// The batches from the last saved offset on are shipped again
+		// after a restart
+		slog.Error("Failed to save write-ahead log offset", "offset", offset, "error", err)
+	}
+
+	l.mu.Lock()
+	l.committed = max(l.committed, offset)
+	l.removeShipped()
+	metrics.WALPending.Set(float64(l.next - l.committed))
+	l.mu.Unlock()
+}
+
+// removeShipped deletes the segments before the one holding the committed
+// offset. l.mu must be held.
+func (l *Log) removeShipped() {
+	for len(l.segments) > 1 && l.segments[1].base <= l.committed {
+		if err := os.Remove(l.segments[0].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Failed to remove shipped write-ahead log segment", "segment", l.segments[0].path, "error", err)
+			return
+		}
+		l.size -= l.segments[0].size
+		l.segments = l.segments[1:]
+	}
+}
+
+// segmentOf returns the segment holding offset
+func (l *Log) segmentOf(offset uint64) segment {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.segments) - 1; i > 0; i-- {
+		if l.segments[i].base <= offset {
+			return l.segments[i]
+		}
+	}
+	return l.segments[0]
+}
+
+// segmentAfter returns the base of the segment following the one at base
+func (l *Log) segmentAfter(base uint64) (uint64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, seg := range l.segments {
+		if seg.base > base {
+			return seg.base, true
+		}
+	}
+	return 0, false
+}
+
+// pause waits RetryInterval. It reports false if the log closed meanwhile.
+func (l *Log) pause() bool {
+	timer := time.NewTimer(l.cfg.RetryInterval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-l.stop:
+		return false
+	}
+}
+
+// Flush flushes the target if it buffers writes. Batches still in the log
+// are not waited for.
+func (l *Log) Flush() error {
+	if flusher, ok := l.target.(sink.Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// CheckHealth fails while the log is full. The target isn't checked: while
+// it is down batches wait in the log.
+func (l *Log) CheckHealth(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if l.cfg.MaxSize > 0 && l.size >= l.cfg.MaxSize {
+		return ErrFull
+	}
+	return nil
+}
+
+// Backlog reports the bytes of the segments on disk against MaxSize, zero
+// when the log is unbounded. The segment being shipped counts in full.
+func (l *Log) Backlog() (queued, capacity int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.size), int(l.cfg.MaxSize)
+}
+
+// EraseUser waits until the batches logged before the call were shipped,
+// so none of the user's events reach the target afterwards, then erases
+// them from the target
+func (l *Log) EraseUser(ctx context.Context, tenant, userID string) (int64, error) {
+	eraser, ok := l.target.(sink.Eraser)
+	if !ok {
+		return 0, sink.ErrEraseUnsupported
+	}
+
+	l.mu.Lock()
+	until := l.next
+	l.mu.Unlock()
+	for {
+		l.mu.Lock()
+		caughtUp, shipped := l.forwarded >= until, l.shipped
+		l.mu.Unlock()
+		if caughtUp {
+			break
+		}
+		select {
+		case <-shipped:
+		case <-l.done:
+			return 0, fmt.Errorf("write-ahead log closed with batches left to ship before erasing: %w", ErrClosed)
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	return eraser.EraseUser(ctx, tenant, userID)
+}
+
+// QueryEvents queries the target. Batches still waiting in the log aren't
+// searched.
+func (l *Log) QueryEvents(ctx context.Context, q sink.Query) (sink.QueryResult, error) {
+	if querier, ok := l.target.(sink.Querier); ok {
+		return querier.QueryEvents(ctx, q)
+	}
+	return sink.QueryResult{}, sink.ErrQueryUnsupported
+}
+
+// Close stops taking batches, waits for the batch being shipped, checks in
+// the shipped ones and closes the target. Batches still in the log are
+// shipped by the next run.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+
+	l.once.Do(func() { close(l.stop) })
+	<-l.done
+	if l.uncommitted > 0 {
+		l.checkpoint()
+	}
+
+	l.mu.Lock()
+	err := l.active.Close()
+	l.mu.Unlock()
+	return errors.Join(err, l.target.Close())
+}
@@ -0,0 +1,133 @@
+package stream
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// subscriberBuffer is how many records a slow subscriber may fall behind
+// before records are dropped for it
+const subscriberBuffer = 256
+
+// Filter selects which records a subscriber receives. Empty fields match
+// everything.
+type Filter struct {
+	Tenant    string
+	ClientID  string
+	SessionID string
+	EventName string
+	// EventCategory is matched against the event's category, see
+	// models.Event.Category
+	EventCategory string
+}
+
+// Matches reports whether record passes the filter
+func (f Filter) Matches(record models.EventRecord) bool {
+	if f.Tenant != "" && f.Tenant != record.Tenant {
+		return false
+	}
+	if f.ClientID != "" && f.ClientID != record.ClientID {
+		return false
+	}
+	if f.SessionID != "" && f.SessionID != record.SessionID {
+		return false
+	}
+	if f.EventName != "" && f.EventName != record.EventName {
+		return false
+	}
+	if f.EventCategory != "" && f.EventCategory != record.Category() {
+		return false
+	}
+	return true
+}
+
+// Subscription receives published records matching its filter
+type Subscription struct {
+	C       <-chan models.EventRecord
+	ch      chan models.EventRecord
+	filter  Filter
+	dropped atomic.Int64
+}
+
+// Dropped returns the number of records skipped because the subscriber
+// wasn't keeping up
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Broker fans incoming events out to live subscribers
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Subscribe registers a new subscriber. Call Unsubscribe when done.
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	ch := make(chan models.EventRecord, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, filter: filter}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Publish delivers the batch's events to matching subscribers. It never
+// blocks; subscribers that are behind miss records.
+func (b *Broker) Publish(batch models.EventBatch) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if len(b.subscribers) == 0 {
+		return
+	}
+
+	for _, record := range batch.Records() {
+		for sub := range b.subscribers {
+			if !sub.filter.Matches(record) {
+				continue
+			}
+			select {
+			case sub.ch <- record:
+			default:
+				sub.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// Subscribers returns the number of active subscribers
+func (b *Broker) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
+
+// Close ends all subscriptions, e.g. so streaming requests finish during
+// server shutdown
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,381 @@
+// Package taxonomy holds ingested events to the event names and fields a
+// deployment agreed on, so typos such as "vide_play" are caught at ingest
+// instead of polluting the analytics downstream
+package taxonomy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/adtyap26/event-stream-video/internal/deadletter"
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/validation"
+)
+
+// Action is what happens to events outside the taxonomy
+type Action string
+
+const (
+	// Reject leaves the events out of the batch and reports them to the
+	// client like other invalid events
+	Reject Action = "reject"
+	// Quarantine accepts the events but sets them aside in the quarantine
+	// directory instead of storing them, so they can be replayed once the
+	// taxonomy or the client is fixed
+	Quarantine Action = "quarantine"
+)
+
+// DefaultQuarantineDir is where quarantined events go when no directory is
+// configured
+const DefaultQuarantineDir = "quarantine"
+
+// maxSuggestionDistance is how many edits away from a known event name an
+// unknown one may be for the known one to be suggested
+const maxSuggestionDistance = 2
+
+// Config configures the event taxonomy
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Action is reject or quarantine
+	Action Action `yaml:"action"`
+	// Events are the allowed event names; events of other names are
+	// outside the taxonomy
+	Events []EventType `yaml:"events"`
+	// QuarantineDir holds quarantined events, in the dead letter format
+	QuarantineDir string `yaml:"quarantineDir"`
+}
+
+// EventType is an allowed event name and the fields its events must set
+type EventType struct {
+	Name string `yaml:"name"`
+	// Required are the fields events of this name must set, by their JSON
+	// path, e.g. videoId or playbackState.quality. Strings must not be empty
+	// and objects must be present.
+	Required []string `yaml:"required"`
+}
+
+// DefaultConfig rejects events outside the taxonomy
+func DefaultConfig() Config {
+	return Config{Action: Reject, QuarantineDir: DefaultQuarantineDir}
+}
+
+// Validate checks the action, the event names and the required fields
+func (c Config) Validate() error {
+	switch c.Action {
+	case "", Reject, Quarantine:
+	default:
+		return fmt.Errorf("unknown taxonomy action %q, must be reject or quarantine", c.Action)
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Events) == 0 {
+		return errors.New("the taxonomy lists no events")
+	}
+	seen := make(map[string]bool, len(c.Events))
+	for _, eventType := range c.Events {
+		if eventType.Name == "" {
+			return errors.New("taxonomy event without a name")
+		}
+		if seen[eventType.Name] {
+			return fmt.Errorf("taxonomy event %q is listed twice", eventType.Name)
+		}
+		seen[eventType.Name] = true
+		for _, field := range eventType.Required {
+			if err := checkPath(field); err != nil {
+				return fmt.Errorf("taxonomy event %q: %w", eventType.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Taxonomy checks events against the allowed event names and their
+// required fields. It is safe for concurrent use.
+type Taxonomy struct {
+	action Action
+	// events maps the allowed names to their required fields, split into
+	// path segments
+	events map[string][][]string
+	names  []string
+	// quarantine is nil unless the action is quarantine
+	quarantine *deadletter.Queue
+}
+
+// New builds the taxonomy of cfg, opening the quarantine directory when
+// events are quarantined. It returns nil when the taxonomy is disabled.
+func New(cfg Config) (*Taxonomy, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	t := &Taxonomy{action: cfg.Action, events: make(map[string][][]string, len(cfg.Events))}
+	if t.action == "" {
+		t.action = Reject
+	}
+	for _, eventType := range cfg.Events {
+		required := make([][]string, 0, len(eventType.Required))
+		for _, field := range eventType.Required {
+			required = append(required, strings.Split(field, "."))
+		}
+		t.events[eventType.Name] = required
+		t.names = append(t.names, eventType.Name)
+	}
+	slices.Sort(t.names)
+
+	if t.action == Quarantine {
+		dir := cfg.QuarantineDir
+		if dir == "" {
+			dir = DefaultQuarantineDir
+		}
+		queue, err := deadletter.Open(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open quarantine: %w", err)
+		}
+		t.quarantine = queue
+	}
+	return t, nil
+}
+
+// Action returns what happens to events outside the taxonomy
+func (t *Taxonomy) Action() Action {
+	return t.action
+}
+
+// Check returns the problems of the events of batch that are outside the
+// taxonomy, labelled with their index in the batch, and counts the events
+// as rejected
+func (t *Taxonomy) Check(batch models.EventBatch) []validation.Problem {
+	problems, rejected := t.problems(batch)
+	if rejected > 0 {
+		metrics.TaxonomyViolations.WithLabelValues(batch.Tenant, string(Reject)).Add(float64(rejected))
+	}
+	return problems
+}
+
+// Problems returns the problems Check would, without counting anything, for
+// batches that are only being tried out
+func (t *Taxonomy) Problems(batch models.EventBatch) []validation.Problem {
+	problems, _ := t.problems(batch)
+	return problems
+}
+
+// problems returns the problems of the events of batch and how many events
+// have any
+func (t *Taxonomy) problems(batch models.EventBatch) ([]validation.Problem, int) {
+	var problems []validation.Problem
+	events := 0
+	for i, event := range batch.Events {
+		eventProblems := t.checkEvent(i, event)
+		if len(eventProblems) > 0 {
+			problems = append(problems, eventProblems...)
+			events++
+		}
+	}
+	return problems, events
+}
+
+func (t *Taxonomy) checkEvent(index int, event models.Event) []validation.Problem {
+	required, ok := t.events[event.EventName]
+	if !ok {
+		if event.EventName == "" {
+			// Reported by validation already
+			return nil
+		}
+		reason := "is not in the event taxonomy"
+		if suggestion := t.suggest(event.EventName); suggestion != "" {
+			reason += fmt.Sprintf(", did you mean %q?", suggestion)
+		}
+		return []validation.Problem{{Index: index, Field: "eventName", Reason: reason}}
+	}
+
+	var problems []validation.Problem
+	value := reflect.ValueOf(event)
+	for _, path := range required {
+		if !present(value, path) {
+			problems = append(problems, validation.Problem{
+				Index:  index,
+				Field:  strings.Join(path, "."),
+				Reason: fmt.Sprintf("is required for %s events", event.EventName),
+			})
+		}
+	}
+	return problems
+}
+
+// Quarantine moves the events of batch that are outside the taxonomy to the
+// quarantine, as one batch with the same identifiers, and leaves the rest
+// in batch. It returns how many events it moved. When the quarantine can't
+// be written, batch is left as it was.
+func (t *Taxonomy) Quarantine(batch *models.EventBatch) (int, error) {
+	if t.quarantine == nil {
+		return 0, nil
+	}
+
+	var reasons []string
+	var kept, quarantined []models.Event
+	for i, event := range batch.Events {
+		problems := t.checkEvent(i, event)
+		if len(problems) == 0 {
+			kept = append(kept, event)
+			continue
+		}
+		quarantined = append(quarantined, event)
+		for _, problem := range problems {
+			reasons = append(reasons, fmt.Sprintf("event %d: %s %s", i, problem.Field, problem.Reason))
+		}
+	}
+	if len(quarantined) == 0 {
+		return 0, nil
+	}
+
+	set := *batch
+	set.Events = quarantined
+	if err := t.quarantine.Add(set, errors.New(strings.Join(reasons, "; "))); err != nil {
+		return 0, err
+	}
+	metrics.TaxonomyViolations.WithLabelValues(batch.Tenant, string(Quarantine)).Add(float64(len(quarantined)))
+	// A new slice, since the caller's batch shares the old one
+	batch.Events = kept
+	return len(quarantined), nil
+}
+
+// suggest returns the allowed name closest to name, if it is close enough
+// to be a typo of it
+func (t *Taxonomy) suggest(name string) string {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for _, known := range t.names {
+		if d := distance(name, known); d < bestDistance {
+			best, bestDistance = known, d
+		}
+	}
+	return best
+}
+
+// distance is the Levenshtein distance between a and b
+func distance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+var eventModel = reflect.TypeFor[models.Event]()
+
+// checkPath reports fields that don't exist on events. Segments under
+// objects that keep unknown keys, like playbackState, are not checked.
+func checkPath(field string) error {
+	if field == "" {
+		return errors.New("empty required field")
+	}
+	t := eventModel
+	for _, name := range strings.Split(field, ".") {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Map || t.Kind() == reflect.Interface {
+			return nil
+		}
+		if t.Kind() != reflect.Struct {
+			return fmt.Errorf("unknown required field %q", field)
+		}
+		index, ok := fieldByJSONName(t, name)
+		if !ok {
+			if _, hasExtra := t.FieldByName("Extra"); hasExtra {
+				return nil
+			}
+			return fmt.Errorf("unknown required field %q", field)
+		}
+		t = t.FieldByIndex(index).Type
+	}
+	return nil
+}
+
+// present reports whether the field at path is set in v: objects must be
+// there and strings must not be empty. Keys the models don't know are
+// looked up in the Extra map of their object.
+func present(v reflect.Value, path []string) bool {
+	for _, name := range path {
+		v = indirect(v)
+		if !v.IsValid() {
+			return false
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			if index, ok := fieldByJSONName(v.Type(), name); ok {
+				v = v.FieldByIndex(index)
+				continue
+			}
+			extra := v.FieldByName("Extra")
+			if !extra.IsValid() || extra.Kind() != reflect.Map || extra.IsNil() {
+				return false
+			}
+			v = extra.MapIndex(reflect.ValueOf(name))
+		case reflect.Map:
+			if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+				return false
+			}
+			v = v.MapIndex(reflect.ValueOf(name))
+		default:
+			return false
+		}
+	}
+	v = indirect(v)
+	if !v.IsValid() {
+		return false
+	}
+	if v.Kind() == reflect.String {
+		return v.String() != ""
+	}
+	return true
+}
+
+// indirect follows pointers and interfaces, returning the zero Value for
+// nil ones
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// fieldByJSONName finds the field of struct type t encoded as name
+func fieldByJSONName(t reflect.Type, name string) ([]int, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == "-" || !field.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		if tag == name {
+			return field.Index, true
+		}
+	}
+	return nil, false
+}
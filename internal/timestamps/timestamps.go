@@ -0,0 +1,129 @@
+// Package timestamps parses the timestamp formats clients send and
+// normalizes them to UTC RFC3339
+package timestamps
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// DefaultSkewThreshold is the clock skew below which timestamps are left alone
+const DefaultSkewThreshold = 5 * time.Second
+
+// ErrUnrecognized is returned for timestamps in none of the accepted formats
+var ErrUnrecognized = errors.New("unrecognized timestamp format")
+
+// layouts are tried in order. Layouts without a zone are read as UTC.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon Jan 02 2006 15:04:05 GMT-0700",
+	"2006-01-02",
+}
+
+// Parse reads value as an RFC3339-like date, an HTTP or JavaScript date
+// string, or a Unix time in seconds or milliseconds
+func Parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, ErrUnrecognized
+	}
+
+	if t, ok := parseUnix(value); ok {
+		return t, nil
+	}
+
+	// Date.toString() appends the zone name in parentheses
+	if i := strings.Index(value, " ("); i > 0 {
+		value = value[:i]
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrUnrecognized
+}
+
+// parseUnix reads numeric timestamps. Values too large to be seconds are
+// taken as milliseconds, which is what Date.now() returns.
+func parseUnix(value string) (time.Time, bool) {
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n < 0 {
+		return time.Time{}, false
+	}
+	if n >= 1e11 {
+		n /= 1000
+	}
+	sec, frac := math.Modf(n)
+	return time.Unix(int64(sec), int64(frac*1e9)), true
+}
+
+// Format renders t in the normalized form, UTC RFC3339 with nanoseconds
+func Format(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Normalizer rewrites batch and event timestamps at ingest
+type Normalizer struct {
+	// CorrectSkew shifts event timestamps by the difference between the
+	// server receive time and the batch timestamp set by the client
+	CorrectSkew bool
+	// SkewThreshold is the smallest skew that is corrected
+	SkewThreshold time.Duration
+}
+
+// Normalize stamps batch with receivedAt and rewrites every timestamp it can
+// parse to UTC RFC3339. Unparseable values are left as sent so validation
+// can report them. When skew correction applies, the event's original time
+// is kept in ClientTimestamp.
+func (n Normalizer) Normalize(batch *models.EventBatch, receivedAt time.Time) {
+	batch.ReceivedAt = Format(receivedAt)
+
+	var skew time.Duration
+	if sentAt, err := Parse(batch.Timestamp); err == nil {
+		batch.Timestamp = Format(sentAt)
+		skew = n.skew(sentAt, receivedAt)
+	}
+
+	for i := range batch.Events {
+		event := &batch.Events[i]
+		t, err := Parse(event.Timestamp)
+		if err != nil {
+			continue
+		}
+		event.Timestamp = Format(t)
+		if skew != 0 {
+			event.ClientTimestamp = event.Timestamp
+			event.Timestamp = Format(t.Add(skew))
+		}
+	}
+}
+
+// skew returns the correction to apply, or zero when correction is disabled
+// or the clocks agree within the threshold
+func (n Normalizer) skew(sentAt, receivedAt time.Time) time.Duration {
+	if !n.CorrectSkew {
+		return 0
+	}
+
+	threshold := n.SkewThreshold
+	if threshold <= 0 {
+		threshold = DefaultSkewThreshold
+	}
+
+	skew := receivedAt.Sub(sentAt)
+	if skew.Abs() < threshold {
+		return 0
+	}
+	return skew
+}
@@ -0,0 +1,161 @@
+// Package tracing sets up OpenTelemetry tracing and exports spans over OTLP
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OTLP protocols accepted in Config.Protocol
+const (
+	ProtocolHTTP = "http/protobuf"
+	ProtocolGRPC = "grpc"
+)
+
+// Span attribute keys set on ingestion spans
+const (
+	AttrBatchID  = attribute.Key("esv.batch.id")
+	AttrClientID = attribute.Key("esv.client.id")
+	AttrTenant   = attribute.Key("esv.tenant")
+	AttrEvents   = attribute.Key("esv.events")
+)
+
+// tracer creates every span of the collector. Until Setup installs a
+// provider it hands out no-op spans.
+var tracer = otel.Tracer("github.com/adtyap26/event-stream-video")
+
+// Config configures tracing
+type Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint is the OTLP receiver, as host:port or a URL, e.g.
+	// localhost:4318 for http/protobuf or localhost:4317 for grpc. When
+	// empty the exporter reads the OTEL_EXPORTER_OTLP_* variables.
+	Endpoint string `yaml:"endpoint"`
+	// Protocol is http/protobuf or grpc
+	Protocol string `yaml:"protocol"`
+	// Insecure sends spans without TLS
+	Insecure bool `yaml:"insecure"`
+	// ServiceName is reported as service.name
+	ServiceName string `yaml:"serviceName"`
+	// SampleRatio is the share of traces recorded, unless the caller's
+	// traceparent header already decided
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// DefaultConfig samples every trace and exports over HTTP once enabled
+func DefaultConfig() Config {
+	return Config{
+		Protocol:    ProtocolHTTP,
+		ServiceName: "event-stream-video",
+		SampleRatio: 1,
+	}
+}
+
+// Validate checks the protocol and sample ratio
+func (c Config) Validate() error {
+	switch c.Protocol {
+	case "", ProtocolHTTP, ProtocolGRPC:
+	default:
+		return fmt.Errorf("unknown OTLP protocol %q, must be %s or %s", c.Protocol, ProtocolHTTP, ProtocolGRPC)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("invalid trace sample ratio %v, must be between 0 and 1", c.SampleRatio)
+	}
+	return nil
+}
+
+// Setup installs the global tracer provider and W3C trace context
+// propagation. The returned function flushes buffered spans and stops the
+// exporter; it does nothing when tracing is disabled.
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultConfig().ServiceName
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, cfg Config) (*otlptrace.Exporter, error) {
+	isURL := strings.Contains(cfg.Endpoint, "://")
+
+	if cfg.Protocol == ProtocolGRPC {
+		var opts []otlptracegrpc.Option
+		switch {
+		case isURL:
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	}
+
+	var opts []otlptracehttp.Option
+	switch {
+	case isURL:
+		opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	case cfg.Endpoint != "":
+		opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// Start starts a span named name as a child of the span in ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed with err unless err is nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
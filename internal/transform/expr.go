@@ -0,0 +1,204 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// node is a parsed arithmetic expression
+type node interface {
+	// eval computes the node on the JSON of an event, reporting false when
+	// a field is missing or not a number or the result isn't finite
+	eval(doc map[string]any) (float64, bool)
+}
+
+type literal float64
+
+func (l literal) eval(map[string]any) (float64, bool) {
+	return float64(l), true
+}
+
+type field []string
+
+func (f field) eval(doc map[string]any) (float64, bool) {
+	value, ok := get(doc, f)
+	if !ok {
+		return 0, false
+	}
+	return number(value)
+}
+
+type negation struct {
+	operand node
+}
+
+func (n negation) eval(doc map[string]any) (float64, bool) {
+	v, ok := n.operand.eval(doc)
+	return -v, ok
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+func (b binary) eval(doc map[string]any) (float64, bool) {
+	left, ok := b.left.eval(doc)
+	if !ok {
+		return 0, false
+	}
+	right, ok := b.right.eval(doc)
+	if !ok {
+		return 0, false
+	}
+	var result float64
+	switch b.op {
+	case '+':
+		result = left + right
+	case '-':
+		result = left - right
+	case '*':
+		result = left * right
+	case '/':
+		if right == 0 {
+			return 0, false
+		}
+		result = left / right
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, false
+	}
+	return result, true
+}
+
+// parseExpr parses an expression of numbers, dotted fields, + - * / and
+// parentheses, with the usual precedence
+func parseExpr(s string) (node, error) {
+	p := &parser{input: s}
+	if strings.TrimSpace(s) == "" {
+		return nil, errors.New("expression is empty")
+	}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.input[p.pos], p.pos)
+	}
+	return n, nil
+}
+
+// parser is a recursive descent parser of expressions
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// peek returns the next character after spaces, or 0 at the end
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos == len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// expr := term (('+' | '-') term)*
+func (p *parser) expr() (node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// term := factor (('*' | '/') factor)*
+func (p *parser) term() (node, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// factor := number | field | '(' expr ')' | '-' factor
+func (p *parser) factor() (node, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, errors.New("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		p.pos++
+		return n, nil
+	case c == '-':
+		p.pos++
+		operand, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return negation{operand: operand}, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.input) && (p.input[p.pos] == '.' || (p.input[p.pos] >= '0' && p.input[p.pos] <= '9')) {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.input[start:p.pos])
+		}
+		return literal(f), nil
+	case isIdentStart(rune(c)):
+		start := p.pos
+		for p.pos < len(p.input) && (isIdentPart(rune(p.input[p.pos])) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		path, err := parsePath(p.input[start:p.pos])
+		if err != nil {
+			return nil, err
+		}
+		return field(path), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,384 @@
+// Package transform reshapes the events of players whose payloads don't
+// match the event model, by rules in the config: fields are renamed, values
+// coerced to the type the model expects and new fields derived from others
+// with arithmetic, so integrations don't need code changes
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// Types values can be coerced to
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+)
+
+// Config holds the transformation rules. Rules run in order, those of
+// every tenant before the tenant's own.
+type Config struct {
+	// Rules apply to the events of every tenant
+	Rules []Rule `yaml:"rules"`
+	// Tenants holds the rules of single tenants by name; without tenancy
+	// every event is the default tenant's
+	Tenants map[string][]Rule `yaml:"tenants"`
+}
+
+// Rule is one transformation, of the kind given by which of Rename, Coerce
+// and Derive is set. Fields are paths into the JSON of an event, with dots
+// between the keys of nested objects, e.g. technical.droppedFrames. Only
+// the fields of the event model and the keys of its nested objects are
+// kept when events are decoded, so unknown top-level keys can't be read.
+type Rule struct {
+	// Rename moves the value of this field to To, replacing what To held
+	Rename string `yaml:"rename"`
+	To     string `yaml:"to"`
+	// Coerce converts the value of this field to Type: string, number,
+	// integer or boolean. Values that don't convert are left alone.
+	Coerce string `yaml:"coerce"`
+	Type   string `yaml:"type"`
+	// Derive sets this field to the value of Expr, arithmetic on numbers
+	// and fields with + - * / and parentheses, e.g.
+	// technical.droppedFrames / technical.totalFrames. The field is left
+	// alone when a field of Expr is missing or not a number, or a divisor
+	// is zero.
+	Derive string `yaml:"derive"`
+	Expr   string `yaml:"expr"`
+	// Events limits the rule to events of these names; empty applies it
+	// to every event
+	Events []string `yaml:"events"`
+}
+
+// Validate checks the rules
+func (c Config) Validate() error {
+	_, _, err := c.compile()
+	return err
+}
+
+// HasRules reports whether c has any rule
+func (c Config) HasRules() bool {
+	if len(c.Rules) > 0 {
+		return true
+	}
+	for _, rules := range c.Tenants {
+		if len(rules) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compile parses the rules of every tenant and of each one
+func (c Config) compile() ([]rule, map[string][]rule, error) {
+	global, err := compileRules(c.Rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	tenants := make(map[string][]rule, len(c.Tenants))
+	for tenant, rules := range c.Tenants {
+		compiled, err := compileRules(rules)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+		tenants[tenant] = compiled
+	}
+	return global, tenants, nil
+}
+
+// rule is a parsed Rule
+type rule struct {
+	rename []string
+	to     []string
+	coerce []string
+	typ    string
+	derive []string
+	expr   node
+	events []string
+}
+
+func compileRules(rules []Rule) ([]rule, error) {
+	compiled := make([]rule, 0, len(rules))
+	for i, r := range rules {
+		c, err := compileRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+func compileRule(r Rule) (rule, error) {
+	c := rule{events: r.Events}
+	kinds := 0
+	var err error
+	if r.Rename != "" {
+		kinds++
+		if c.rename, err = parsePath(r.Rename); err != nil {
+			return rule{}, err
+		}
+		if c.to, err = parsePath(r.To); err != nil {
+			return rule{}, fmt.Errorf("to: %w", err)
+		}
+	}
+	if r.Coerce != "" {
+		kinds++
+		if c.coerce, err = parsePath(r.Coerce); err != nil {
+			return rule{}, err
+		}
+		switch r.Type {
+		case TypeString, TypeNumber, TypeInteger, TypeBoolean:
+			c.typ = r.Type
+		default:
+			return rule{}, fmt.Errorf("type must be string, number, integer or boolean, not %q", r.Type)
+		}
+	}
+	if r.Derive != "" {
+		kinds++
+		if c.derive, err = parsePath(r.Derive); err != nil {
+			return rule{}, err
+		}
+		if c.expr, err = parseExpr(r.Expr); err != nil {
+			return rule{}, fmt.Errorf("expr: %w", err)
+		}
+	}
+	if kinds != 1 {
+		return rule{}, errors.New("a rule sets exactly one of rename, coerce and derive")
+	}
+	return c, nil
+}
+
+// parsePath splits a dotted field path
+func parsePath(path string) ([]string, error) {
+	if path == "" {
+		return nil, errors.New("field is empty")
+	}
+	keys := strings.Split(path, ".")
+	if slices.Contains(keys, "") {
+		return nil, fmt.Errorf("invalid field %q", path)
+	}
+	return keys, nil
+}
+
+// Engine applies the rules of a Config. It is safe for concurrent use.
+type Engine struct {
+	mu      sync.RWMutex
+	global  []rule
+	tenants map[string][]rule
+}
+
+// New compiles the rules of cfg, or returns nil when there are none
+func New(cfg Config) (*Engine, error) {
+	if !cfg.HasRules() {
+		return nil, nil
+	}
+	e := &Engine{}
+	if err := e.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetConfig replaces the rules, e.g. on a config reload
+func (e *Engine) SetConfig(cfg Config) error {
+	global, tenants, err := cfg.compile()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.global, e.tenants = global, tenants
+	e.mu.Unlock()
+	return nil
+}
+
+// Transform applies the rules of the batch's tenant to its events. An
+// event the rules turned into one the model can't hold, such as a string
+// where a number belongs, is kept as it was.
+func (e *Engine) Transform(batch *models.EventBatch) {
+	e.mu.RLock()
+	rules := slices.Concat(e.global, e.tenants[batch.Tenant])
+	e.mu.RUnlock()
+	if len(rules) == 0 {
+		return
+	}
+
+	failed := 0
+	var lastErr error
+	for i := range batch.Events {
+		event := &batch.Events[i]
+		applicable := rules[:0:0]
+		for _, r := range rules {
+			if len(r.events) == 0 || slices.Contains(r.events, event.EventName) {
+				applicable = append(applicable, r)
+			}
+		}
+		if len(applicable) == 0 {
+			continue
+		}
+		transformed, err := apply(*event, applicable)
+		if err != nil {
+			failed++
+			lastErr = err
+			metrics.TransformedEvents.WithLabelValues(batch.Tenant, "failed").Inc()
+			continue
+		}
+		*event = transformed
+		metrics.TransformedEvents.WithLabelValues(batch.Tenant, "transformed").Inc()
+	}
+	if failed > 0 {
+		slog.Warn("Failed to transform events, keeping them as sent",
+			"tenant", batch.Tenant, "batchId", batch.BatchID, "events", failed, "error", lastErr)
+	}
+}
+
+// apply runs rules on the JSON of event and decodes the result
+func apply(event models.Event, rules []rule) (models.Event, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return models.Event{}, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return models.Event{}, err
+	}
+
+	for _, r := range rules {
+		switch {
+		case r.rename != nil:
+			if value, ok := get(doc, r.rename); ok {
+				remove(doc, r.rename)
+				set(doc, r.to, value)
+			}
+		case r.coerce != nil:
+			if value, ok := get(doc, r.coerce); ok {
+				if coerced, ok := coerce(value, r.typ); ok {
+					set(doc, r.coerce, coerced)
+				}
+			}
+		case r.derive != nil:
+			if value, ok := r.expr.eval(doc); ok {
+				set(doc, r.derive, value)
+			}
+		}
+	}
+
+	if data, err = json.Marshal(doc); err != nil {
+		return models.Event{}, err
+	}
+	var transformed models.Event
+	if err := json.Unmarshal(data, &transformed); err != nil {
+		return models.Event{}, err
+	}
+	return transformed, nil
+}
+
+// get returns the value at path in doc
+func get(doc map[string]any, path []string) (any, bool) {
+	var value any = doc
+	for _, key := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// set stores value at path in doc, creating the objects on the way
+func set(doc map[string]any, path []string, value any) {
+	object := doc
+	for _, key := range path[:len(path)-1] {
+		next, ok := object[key].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			object[key] = next
+		}
+		object = next
+	}
+	object[path[len(path)-1]] = value
+}
+
+// remove deletes the value at path from doc
+func remove(doc map[string]any, path []string) {
+	object := doc
+	for _, key := range path[:len(path)-1] {
+		next, ok := object[key].(map[string]any)
+		if !ok {
+			return
+		}
+		object = next
+	}
+	delete(object, path[len(path)-1])
+}
+
+// coerce converts a JSON value to typ
+func coerce(value any, typ string) (any, bool) {
+	switch typ {
+	case TypeString:
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	case TypeNumber:
+		return number(value)
+	case TypeInteger:
+		if f, ok := number(value); ok {
+			return math.Trunc(f), true
+		}
+	case TypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case float64:
+			return v != 0, true
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			return b, err == nil
+		}
+	}
+	return nil, false
+}
+
+// number reads a JSON number, numeric string or boolean as a finite number
+func number(value any) (float64, bool) {
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case string:
+		var err error
+		if f, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return 0, false
+		}
+	case bool:
+		if v {
+			f = 1
+		}
+	default:
+		return 0, false
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
@@ -0,0 +1,234 @@
+package validation
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+)
+
+// jsonSchemaDialect is the JSON Schema version the schemas are written in
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// timestampDescription documents the timestamps ingest understands, which
+// it normalizes to RFC 3339 before validating them
+const timestampDescription = "RFC 3339 time, or Unix time in seconds or milliseconds"
+
+// serverFields are set by the collector at ingest, never by players, so
+// the schemas leave them out
+var serverFields = map[reflect.Type][]string{
+	reflect.TypeFor[models.EventBatch](): {"receivedAt", "tenant"},
+	reflect.TypeFor[models.Event]():      {"clientTimestamp", "sampled", "sampleRate", "isBot", "botReason", "playerError"},
+	reflect.TypeFor[models.Context]():    {"geo", "device"},
+}
+
+// EventSchema returns the JSON Schema of the events ValidateEvent accepts,
+// at the current schema version
+func EventSchema() map[string]any {
+	return newJSONSchema("Event", reflect.TypeFor[models.Event](), Limits{})
+}
+
+// BatchSchema returns the JSON Schema of the batches ValidateBatch accepts
+// under limits, events included
+func BatchSchema(limits Limits) map[string]any {
+	return newJSONSchema("EventBatch", reflect.TypeFor[models.EventBatch](), limits)
+}
+
+// newJSONSchema generates the schema of root from the models and adds the
+// constraints validation checks that types can't express
+func newJSONSchema(title string, root reflect.Type, limits Limits) map[string]any {
+	b := &schemaBuilder{defs: make(map[string]map[string]any)}
+	ref := b.typeSchema(root)
+	constrain(b.defs, limits)
+	return map[string]any{
+		"$schema": jsonSchemaDialect,
+		"title":   title,
+		"$ref":    ref["$ref"],
+		"$defs":   b.defs,
+	}
+}
+
+// schemaBuilder derives JSON Schemas from Go types by their JSON encoding,
+// one definition per struct
+type schemaBuilder struct {
+	defs map[string]map[string]any
+}
+
+func (b *schemaBuilder) typeSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.typeSchema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := b.defs[name]; !ok {
+			// Claimed before the fields are built, for types that nest
+			// themselves
+			b.defs[name] = nil
+			b.defs[name] = b.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	}
+	return map[string]any{}
+}
+
+// structSchema lists the fields of t under their JSON names. Unknown
+// properties stay allowed, as decoding keeps or ignores them.
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || slices.Contains(serverFields[t], name) {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = b.typeSchema(field.Type)
+	}
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// constrain adds the rules of ValidateBatch and ValidateEvent to the
+// definitions generated from the models
+func constrain(defs map[string]map[string]any, limits Limits) {
+	versions := map[string]any{"minimum": schema.Unversioned, "maximum": schema.Current}
+
+	if batch, ok := defs["EventBatch"]; ok {
+		batch["required"] = []string{"clientId", "batchId", "events"}
+		set(batch, "events", map[string]any{"minItems": 1})
+		if limits.MaxEvents > 0 {
+			set(batch, "events", map[string]any{"maxItems": limits.MaxEvents})
+		}
+		set(batch, "timestamp", map[string]any{"description": timestampDescription})
+		set(batch, "schemaVersion", versions)
+		set(batch, "schemaVersion", map[string]any{"description": "version of the events that don't name their own"})
+		batch["properties"].(map[string]any)["checksum"] = map[string]any{
+			"type":        "string",
+			"description": "checksum of the events array as sent, e.g. xxh64:9c2f0f3a1b7d5e42; batches that don't match it are rejected",
+		}
+	}
+
+	if event, ok := defs["Event"]; ok {
+		event["required"] = []string{"eventName", "sessionId", "timestamp"}
+		set(event, "eventId", map[string]any{"maxLength": MaxEventIDLength})
+		set(event, "timestamp", map[string]any{"description": timestampDescription})
+		set(event, "schemaVersion", versions)
+		set(event, "eventCategory", map[string]any{
+			"enum":        []string{models.CategoryVideo, models.CategoryPage, models.CategoryInteraction},
+			"description": "video unless the eventName implies another category",
+		})
+		// Version 1 sends a string, version 2 any JSON value
+		event["properties"].(map[string]any)["customData"] = map[string]any{
+			"description": "any JSON value",
+		}
+		adEvents := []string{models.AdStart, models.AdQuartile, models.AdComplete, models.AdError}
+		event["allOf"] = []any{
+			whenEventName(adEvents, map[string]any{
+				"required":   []string{"adState"},
+				"properties": map[string]any{"adState": map[string]any{"required": []string{"adId"}}},
+			}),
+			whenEventName([]string{models.AdQuartile}, map[string]any{
+				"properties": map[string]any{"adState": map[string]any{
+					"required":   []string{"quartile"},
+					"properties": map[string]any{"quartile": map[string]any{"minimum": 1, "maximum": 4}},
+				}},
+			}),
+			whenEventName([]string{models.PageView}, map[string]any{
+				"properties": map[string]any{"eventCategory": map[string]any{"const": models.CategoryPage}},
+			}),
+			whenEventName([]string{models.CTAClick}, map[string]any{
+				"properties": map[string]any{"eventCategory": map[string]any{"const": models.CategoryInteraction}},
+			}),
+			whenCategory(models.CategoryPage, models.PageView, map[string]any{
+				"required":   []string{"context"},
+				"properties": map[string]any{"context": map[string]any{"required": []string{"pageUrl"}}},
+				"not":        map[string]any{"anyOf": hasAny("playbackState", "adState", "interaction")},
+			}),
+			whenCategory(models.CategoryInteraction, models.CTAClick, map[string]any{
+				"required":   []string{"interaction"},
+				"properties": map[string]any{"interaction": map[string]any{"required": []string{"element"}}},
+				"not":        map[string]any{"anyOf": hasAny("adState")},
+			}),
+		}
+	}
+
+	if ad, ok := defs["AdState"]; ok {
+		set(ad, "position", map[string]any{"enum": []string{models.AdPreroll, models.AdMidroll, models.AdPostroll}})
+	}
+	if technical, ok := defs["Technical"]; ok {
+		set(technical, "streamType", map[string]any{"enum": []string{models.StreamLive, models.StreamVOD, models.StreamDVR}})
+	}
+	if playback, ok := defs["PlaybackState"]; ok {
+		set(playback, "liveLatency", map[string]any{"minimum": 0})
+	}
+}
+
+// set adds keywords to the schema of a property of def
+func set(def map[string]any, property string, keywords map[string]any) {
+	prop, ok := def["properties"].(map[string]any)[property].(map[string]any)
+	if !ok {
+		return
+	}
+	for k, v := range keywords {
+		prop[k] = v
+	}
+}
+
+// whenEventName applies then to events named one of names
+func whenEventName(names []string, then map[string]any) map[string]any {
+	return map[string]any{
+		"if": map[string]any{
+			"required":   []string{"eventName"},
+			"properties": map[string]any{"eventName": map[string]any{"enum": names}},
+		},
+		"then": then,
+	}
+}
+
+// whenCategory applies then to events of category, including those without
+// an eventCategory whose name implies it
+func whenCategory(category, name string, then map[string]any) map[string]any {
+	return map[string]any{
+		"if": map[string]any{"anyOf": []any{
+			map[string]any{
+				"required":   []string{"eventCategory"},
+				"properties": map[string]any{"eventCategory": map[string]any{"const": category}},
+			},
+			map[string]any{
+				"not":        map[string]any{"required": []string{"eventCategory"}},
+				"required":   []string{"eventName"},
+				"properties": map[string]any{"eventName": map[string]any{"const": name}},
+			},
+		}},
+		"then": then,
+	}
+}
+
+// hasAny matches objects that set one of properties
+func hasAny(properties ...string) []any {
+	schemas := make([]any, 0, len(properties))
+	for _, property := range properties {
+		schemas = append(schemas, map[string]any{"required": []string{property}})
+	}
+	return schemas
+}
@@ -0,0 +1,251 @@
+package validation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+)
+
+// DefaultMaxEvents is the largest batch accepted when no limit is configured
+const DefaultMaxEvents = 500
+
+// MaxEventIDLength is the longest eventId accepted
+const MaxEventIDLength = 128
+
+// unsupportedVersion is the reason given for schema versions the collector
+// can't upgrade
+var unsupportedVersion = fmt.Sprintf("must be between %d and %d", schema.Unversioned, schema.Current)
+
+// BatchIndex is the Index reported for problems with the batch itself
+const BatchIndex = -1
+
+// Limits configures batch validation
+type Limits struct {
+	MaxEvents int
+}
+
+// DefaultLimits returns the limits used when none are configured
+func DefaultLimits() Limits {
+	return Limits{MaxEvents: DefaultMaxEvents}
+}
+
+// Problem describes one validation failure. Index is the position of the
+// offending event in the batch, or BatchIndex for batch-level fields.
+type Problem struct {
+	Index  int    `json:"index"`
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Error is returned when a batch fails validation
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	if len(e.Problems) == 1 {
+		return fmt.Sprintf("batch failed validation: %s %s", e.Problems[0].Field, e.Problems[0].Reason)
+	}
+	return fmt.Sprintf("batch failed validation with %d problems", len(e.Problems))
+}
+
+// Rejection is an event left out of an otherwise valid batch. Index is its
+// position in the batch as sent.
+type Rejection struct {
+	Index   int      `json:"index"`
+	Reasons []string `json:"reasons"`
+}
+
+// Rejections groups the problems by event, in batch order. ok is false when
+// a problem concerns the batch itself, so no event can be accepted.
+func (e *Error) Rejections() (rejections []Rejection, ok bool) {
+	byIndex := make(map[int]int)
+	for _, problem := range e.Problems {
+		if problem.Index == BatchIndex {
+			return nil, false
+		}
+		i, seen := byIndex[problem.Index]
+		if !seen {
+			i = len(rejections)
+			byIndex[problem.Index] = i
+			rejections = append(rejections, Rejection{Index: problem.Index})
+		}
+		rejections[i].Reasons = append(rejections[i].Reasons, problem.Field+" "+problem.Reason)
+	}
+	return rejections, true
+}
+
+// ValidateBatch checks required fields, timestamp formats, schema versions,
+// event IDs used twice and the batch size.
+// It returns nil if the batch is valid and an *Error otherwise.
+func ValidateBatch(batch models.EventBatch, limits Limits) error {
+	var problems []Problem
+	batchProblem := func(field, reason string) {
+		problems = append(problems, Problem{Index: BatchIndex, Field: field, Reason: reason})
+	}
+
+	if batch.ClientID == "" {
+		batchProblem("clientId", "is required")
+	}
+	if batch.BatchID == "" {
+		batchProblem("batchId", "is required")
+	}
+	if batch.Timestamp != "" && !validTimestamp(batch.Timestamp) {
+		batchProblem("timestamp", "must be an RFC3339 or Unix timestamp")
+	}
+	if batch.SchemaVersion != 0 && !schema.Supported(batch.SchemaVersion) {
+		batchProblem("schemaVersion", unsupportedVersion)
+	}
+	if len(batch.Events) == 0 {
+		batchProblem("events", "must contain at least one event")
+	}
+	if err := CheckBatchSize(batch, limits); err != nil {
+		batchProblem("events", fmt.Sprintf("must not contain more than %d events", limits.MaxEvents))
+	}
+
+	seen := make(map[string]int, len(batch.Events))
+	for i, event := range batch.Events {
+		problems = append(problems, ValidateEvent(i, event)...)
+		if event.EventID == "" {
+			continue
+		}
+		if first, ok := seen[event.EventID]; ok {
+			problems = append(problems, Problem{Index: i, Field: "eventId", Reason: fmt.Sprintf("repeats event %d", first)})
+			continue
+		}
+		seen[event.EventID] = i
+	}
+
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+// TooManyEventsError is returned when a batch exceeds Limits.MaxEvents
+type TooManyEventsError struct {
+	Count int
+	Max   int
+}
+
+func (e *TooManyEventsError) Error() string {
+	return fmt.Sprintf("batch has %d events, the limit is %d", e.Count, e.Max)
+}
+
+// CheckBatchSize returns a *TooManyEventsError if the batch has more events
+// than allowed
+func CheckBatchSize(batch models.EventBatch, limits Limits) error {
+	if limits.MaxEvents > 0 && len(batch.Events) > limits.MaxEvents {
+		return &TooManyEventsError{Count: len(batch.Events), Max: limits.MaxEvents}
+	}
+	return nil
+}
+
+// ValidateEvent checks a single event. index is used to label the problems.
+func ValidateEvent(index int, event models.Event) []Problem {
+	var problems []Problem
+	problem := func(field, reason string) {
+		problems = append(problems, Problem{Index: index, Field: field, Reason: reason})
+	}
+
+	if event.EventName == "" {
+		problem("eventName", "is required")
+	}
+	if event.SessionID == "" {
+		problem("sessionId", "is required")
+	}
+	if len(event.EventID) > MaxEventIDLength {
+		problem("eventId", fmt.Sprintf("must not be longer than %d characters", MaxEventIDLength))
+	}
+	if event.SchemaVersion != 0 && !schema.Supported(event.SchemaVersion) {
+		problem("schemaVersion", unsupportedVersion)
+	}
+	if event.Timestamp == "" {
+		problem("timestamp", "is required")
+	} else if !validTimestamp(event.Timestamp) {
+		problem("timestamp", "must be an RFC3339 or Unix timestamp")
+	}
+	switch category := event.Category(); category {
+	case models.CategoryVideo:
+		validateVideo(event, problem)
+	case models.CategoryPage:
+		validatePage(event, problem)
+	case models.CategoryInteraction:
+		validateInteraction(event, problem)
+	default:
+		problem("eventCategory", "must be video, page or interaction")
+	}
+	if implied := models.CategoryOf(event.EventName); implied != models.CategoryVideo && event.Category() != implied {
+		problem("eventCategory", fmt.Sprintf("must be %s for %s events", implied, event.EventName))
+	}
+
+	return problems
+}
+
+// validateVideo checks the fields of player events
+func validateVideo(event models.Event, problem func(field, reason string)) {
+	if models.IsAdEvent(event.EventName) {
+		validateAd(event, problem)
+	}
+	if t := event.StreamType(); t != "" && !models.IsStreamType(t) {
+		problem("technical.streamType", "must be live, vod or dvr")
+	}
+	if p := event.PlaybackState; p != nil && p.LiveLatency < 0 {
+		problem("playbackState.liveLatency", "must not be negative")
+	}
+	if event.Interaction != nil {
+		problem("interaction", "is only sent with interaction events")
+	}
+}
+
+// validatePage checks that page events name their page and carry no
+// player state
+func validatePage(event models.Event, problem func(field, reason string)) {
+	if event.Context == nil || event.Context.PageURL == "" {
+		problem("context.pageUrl", "is required for page events")
+	}
+	if event.PlaybackState != nil {
+		problem("playbackState", "is only sent with video events")
+	}
+	if event.AdState != nil {
+		problem("adState", "is only sent with video events")
+	}
+	if event.Interaction != nil {
+		problem("interaction", "is only sent with interaction events")
+	}
+}
+
+// validateInteraction checks that interaction events name their element.
+// They may carry the playback state of a player they happened on.
+func validateInteraction(event models.Event, problem func(field, reason string)) {
+	if event.Interaction == nil || event.Interaction.Element == "" {
+		problem("interaction.element", "is required for interaction events")
+	}
+	if event.AdState != nil {
+		problem("adState", "is only sent with video events")
+	}
+}
+
+// validateAd checks the AdState that ad events need to be attributed
+func validateAd(event models.Event, problem func(field, reason string)) {
+	ad := event.AdState
+	if ad == nil || ad.AdID == "" {
+		problem("adState.adId", "is required for ad events")
+		return
+	}
+	switch ad.Position {
+	case "", models.AdPreroll, models.AdMidroll, models.AdPostroll:
+	default:
+		problem("adState.position", "must be preroll, midroll or postroll")
+	}
+	if event.EventName == models.AdQuartile && (ad.Quartile < 1 || ad.Quartile > 4) {
+		problem("adState.quartile", "must be between 1 and 4")
+	}
+}
+
+func validTimestamp(value string) bool {
+	_, err := time.Parse(time.RFC3339Nano, value)
+	return err == nil
+}
@@ -0,0 +1,335 @@
+// Package webhook posts stored events that match a filter to external URLs,
+// so other services can subscribe to events without reading the sink
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adtyap26/event-stream-video/internal/metrics"
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// Request headers of a delivery
+const (
+	// HeaderWebhook names the webhook the delivery is for
+	HeaderWebhook = "X-ESV-Webhook"
+	// HeaderDelivery is the same on every attempt of a delivery, so
+	// receivers can drop repeats
+	HeaderDelivery = "X-ESV-Delivery"
+	// HeaderTimestamp is the unix time the attempt was signed at
+	HeaderTimestamp = "X-ESV-Timestamp"
+	// HeaderSignature is sha256= and the hex HMAC-SHA256 of the timestamp,
+	// a dot and the body, keyed with the webhook's secret
+	HeaderSignature = "X-ESV-Signature"
+)
+
+const (
+	// DefaultMaxAttempts is how often a delivery is tried by default
+	DefaultMaxAttempts = 5
+	// DefaultTimeout bounds one attempt by default
+	DefaultTimeout = 10 * time.Second
+	// DefaultQueueSize is how many deliveries may wait per webhook by default
+	DefaultQueueSize = 1000
+	// maxBackoff caps the delay between attempts
+	maxBackoff = time.Minute
+)
+
+// Filter selects the events a webhook receives. Empty fields match every
+// event; an event must match every field that is set.
+type Filter struct {
+	Tenants    []string `yaml:"tenants"`
+	EventNames []string `yaml:"eventNames"`
+	// VideoIDs are patterns in the syntax of path.Match, e.g. trailer-*
+	VideoIDs []string `yaml:"videoIds"`
+}
+
+// Match reports whether the filter selects event of tenant
+func (f Filter) Match(tenant string, event models.Event) bool {
+	if len(f.Tenants) > 0 && !slices.Contains(f.Tenants, tenant) {
+		return false
+	}
+	if len(f.EventNames) > 0 && !slices.Contains(f.EventNames, event.EventName) {
+		return false
+	}
+	if len(f.VideoIDs) == 0 {
+		return true
+	}
+	for _, pattern := range f.VideoIDs {
+		if ok, _ := path.Match(pattern, event.VideoID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Config configures one webhook
+type Config struct {
+	// Name identifies the webhook in logs, metrics and HeaderWebhook
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Secret signs deliveries in HeaderSignature; empty sends them unsigned
+	Secret string `yaml:"secret"`
+	Filter Filter `yaml:"filter"`
+
+	// MaxAttempts is how often a delivery is tried before it is dropped.
+	// Network errors and 408, 429 and 5xx answers are retried.
+	MaxAttempts int           `yaml:"maxAttempts"`
+	Timeout     time.Duration `yaml:"timeout"`
+	// QueueSize is how many deliveries may wait; more are dropped
+	QueueSize int `yaml:"queueSize"`
+}
+
+// Validate checks the name, URL and video ID patterns
+func (c Config) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("webhook %s has no name", c.URL)
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid URL %q for webhook %s", c.URL, c.Name)
+	}
+	for _, pattern := range c.Filter.VideoIDs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid video ID pattern %q for webhook %s: %w", pattern, c.Name, err)
+		}
+	}
+	return nil
+}
+
+// Payload is the JSON body of a delivery: the matching events of one stored
+// batch
+type Payload struct {
+	Webhook    string               `json:"webhook"`
+	DeliveryID string               `json:"deliveryId"`
+	Tenant     string               `json:"tenant,omitempty"`
+	Events     []models.EventRecord `json:"events"`
+}
+
+// Sign returns the HeaderSignature value of body sent at timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers matching events to every webhook in the background
+type Dispatcher struct {
+	// mu guards hooks, which Reload replaces while batches are dispatched
+	mu    sync.RWMutex
+	hooks []*hook
+	wg    sync.WaitGroup
+
+	// closing ends backoff waits, so Close tries each waiting delivery
+	// once more instead of sleeping through its retries. It is shared by
+	// the webhooks replaced by Reload that are still delivering.
+	closing chan struct{}
+}
+
+// hook is one webhook and its queue of deliveries
+type hook struct {
+	cfg    Config
+	client *http.Client
+	queue  chan Payload
+	// closing is the dispatcher's
+	closing chan struct{}
+}
+
+// New starts a worker per webhook. Zero fields of the configs select the
+// defaults.
+func New(configs []Config) (*Dispatcher, error) {
+	d := &Dispatcher{closing: make(chan struct{})}
+	hooks, err := d.newHooks(configs)
+	if err != nil {
+		return nil, err
+	}
+	d.start(hooks)
+	return d, nil
+}
+
+// Reload replaces the webhooks with configs. Deliveries already queued for
+// the old webhooks are still made, retries included. The webhooks are left
+// as they were when a config is invalid.
+func (d *Dispatcher) Reload(configs []Config) error {
+	hooks, err := d.newHooks(configs)
+	if err != nil {
+		return err
+	}
+	d.start(hooks)
+
+	d.mu.Lock()
+	old := d.hooks
+	d.hooks = hooks
+	d.mu.Unlock()
+	for _, h := range old {
+		close(h.queue)
+	}
+	return nil
+}
+
+func (d *Dispatcher) newHooks(configs []Config) ([]*hook, error) {
+	var hooks []*hook
+	for _, cfg := range configs {
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		if cfg.MaxAttempts <= 0 {
+			cfg.MaxAttempts = DefaultMaxAttempts
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = DefaultTimeout
+		}
+		if cfg.QueueSize <= 0 {
+			cfg.QueueSize = DefaultQueueSize
+		}
+		hooks = append(hooks, &hook{
+			cfg:     cfg,
+			client:  &http.Client{Timeout: cfg.Timeout},
+			queue:   make(chan Payload, cfg.QueueSize),
+			closing: d.closing,
+		})
+	}
+	return hooks, nil
+}
+
+// start runs a worker per hook
+func (d *Dispatcher) start(hooks []*hook) {
+	for _, h := range hooks {
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			h.run()
+		}()
+	}
+}
+
+// Dispatch queues the events of a stored batch for every webhook whose
+// filter matches some of them
+func (d *Dispatcher) Dispatch(batch models.EventBatch) {
+	records := batch.Records()
+	// Held while queueing, so Reload doesn't close a queue under the send
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, h := range d.hooks {
+		var matched []models.EventRecord
+		for _, record := range records {
+			if h.cfg.Filter.Match(batch.Tenant, record.Event) {
+				matched = append(matched, record)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+
+		payload := Payload{
+			Webhook:    h.cfg.Name,
+			DeliveryID: uuid.NewString(),
+			Tenant:     batch.Tenant,
+			Events:     matched,
+		}
+		select {
+		case h.queue <- payload:
+		default:
+			slog.Warn("Dropping webhook delivery, queue is full", "webhook", h.cfg.Name, "events", len(matched))
+			metrics.WebhookDeliveries.WithLabelValues(h.cfg.Name, "dropped").Inc()
+		}
+	}
+}
+
+func (h *hook) run() {
+	for payload := range h.queue {
+		outcome := "delivered"
+		if err := h.deliver(payload); err != nil {
+			slog.Error("Error delivering webhook", "webhook", h.cfg.Name, "deliveryId", payload.DeliveryID,
+				"events", len(payload.Events), "error", err)
+			outcome = "failed"
+		}
+		metrics.WebhookDeliveries.WithLabelValues(h.cfg.Name, outcome).Inc()
+	}
+}
+
+// deliver posts payload until it is accepted, the attempts run out or the
+// error can't be fixed by retrying
+func (h *hook) deliver(payload Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := h.post(payload.DeliveryID, body)
+		if err == nil || !retry || attempt == h.cfg.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-h.closing:
+			// Shutting down: one last try, then give up
+			_, err := h.post(payload.DeliveryID, body)
+			return err
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post makes one attempt, reporting whether a failure is worth retrying
+func (h *hook) post(deliveryID string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "event-stream-video-webhook")
+	req.Header.Set(HeaderWebhook, h.cfg.Name)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if h.cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(h.cfg.Secret, timestamp, body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return false, fmt.Errorf("webhook answered %s", resp.Status)
+}
+
+// Close waits for the queued deliveries, which are no longer retried with
+// a delay. Dispatch must not be called after Close.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	close(d.closing)
+	for _, h := range d.hooks {
+		close(h.queue)
+	}
+	d.hooks = nil
+	d.mu.Unlock()
+	d.wg.Wait()
+}
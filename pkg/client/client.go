@@ -0,0 +1,574 @@
+// Package client sends video analytics events to the collector from Go
+// services, so server-side players can report the same events as the
+// browser SDK. It behaves like that SDK: events are queued and sent in
+// batches once enough have accumulated or a timer fires, playback
+// milestones go out straight away, failed batches are retried with
+// exponential backoff, and Close hands whatever could not be sent to the
+// beacon endpoint.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adtyap26/event-stream-video/internal/checksum"
+	"github.com/adtyap26/event-stream-video/internal/schema"
+)
+
+const (
+	// DefaultBatchSize is how many queued events trigger a send
+	DefaultBatchSize = 15
+	// DefaultFlushInterval is how often queued events are sent regardless
+	DefaultFlushInterval = 5 * time.Second
+	// DefaultMaxRetries is how often a failed batch is retried before it
+	// is dropped
+	DefaultMaxRetries = 5
+	// DefaultMaxQueue caps the events waiting to be sent
+	DefaultMaxQueue = 10000
+	// DefaultBeaconTimeout bounds the beacon sent by Close
+	DefaultBeaconTimeout = 5 * time.Second
+
+	// maxBeaconSize is the collector's limit on beacon bodies
+	maxBeaconSize = 64 << 10
+
+	userAgent = "esv-go-client/1.0.0"
+
+	// codeChecksumMismatch is answered to batches corrupted on the way
+	codeChecksumMismatch = "checksum_mismatch"
+)
+
+// ErrClosed is returned when events are tracked after Close
+var ErrClosed = errors.New("client is closed")
+
+// errStopped aborts a retry because the client is closing
+var errStopped = errors.New("client is stopping")
+
+// Config configures a Client
+type Config struct {
+	// Endpoint is the collector's event endpoint, e.g.
+	// http://localhost:8080/api/v1/events. Beacons go to Endpoint/beacon.
+	Endpoint string
+	ClientID string
+	APIKey   string
+	// SessionID is set on events and batches without one. A random ID is
+	// generated when empty.
+	SessionID string
+
+	BatchSize     int
+	FlushInterval time.Duration
+	// ImmediateEvents are sent without waiting for the batch to fill
+	ImmediateEvents []string
+
+	// MaxRetries is how often a failed batch is retried; negative disables
+	// retries
+	MaxRetries int
+	// MinBackoff is the delay before the first retry. It doubles with
+	// every attempt up to MaxBackoff, unless the server sends Retry-After.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// MaxQueue caps the events waiting to be sent; the oldest are dropped
+	// once it is reached
+	MaxQueue int
+	// Compress gzips request bodies
+	Compress bool
+	// Checksum sends the checksum of each batch's events, so the collector
+	// rejects batches corrupted on the way and they are sent again
+	Checksum bool
+
+	HTTPClient *http.Client
+	// OnError is called with errors from background sends, such as a batch
+	// dropped after its last retry, and with a *RejectedError for events
+	// the collector rejected
+	OnError func(error)
+}
+
+// DefaultConfig returns the defaults applied to unset fields
+func DefaultConfig() Config {
+	return Config{
+		BatchSize:       DefaultBatchSize,
+		FlushInterval:   DefaultFlushInterval,
+		ImmediateEvents: []string{"play", "pause", "ended", "error"},
+		MaxRetries:      DefaultMaxRetries,
+		MinBackoff:      time.Second,
+		MaxBackoff:      30 * time.Second,
+		MaxQueue:        DefaultMaxQueue,
+		HTTPClient:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// StatusError is returned when the collector rejects a batch
+type StatusError struct {
+	StatusCode int
+	// Code is the error code of the response body, if any, e.g.
+	// checksum_mismatch
+	Code string
+	// RetryAfter is the delay the collector asked for, if any
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("collector responded with %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether sending the batch again may succeed
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 || e.Code == codeChecksumMismatch
+}
+
+// Rejection is an event the collector left out of a batch, by its index in
+// the batch
+type Rejection struct {
+	Index   int      `json:"index"`
+	Reasons []string `json:"reasons"`
+}
+
+// RejectedError is passed to OnError when the collector stored a batch
+// without some of its events because they were invalid. They are counted
+// as dropped and not sent again.
+type RejectedError struct {
+	BatchID  string
+	Rejected []Rejection
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("collector rejected %d events of batch %s", len(e.Rejected), e.BatchID)
+}
+
+// Client queues events and sends them to the collector from a background
+// goroutine. It is safe for concurrent use.
+type Client struct {
+	cfg       Config
+	immediate map[string]bool
+	dropped   atomic.Int64
+
+	mu     sync.Mutex
+	queue  []Event
+	closed bool
+
+	// unsent holds batches whose retries were cut short by Close. It is
+	// only touched by the background goroutine and, after it exits, Close.
+	unsent []Batch
+
+	wake     chan struct{}
+	flushReq chan flushRequest
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type flushRequest struct {
+	ctx   context.Context
+	reply chan error
+}
+
+// New creates a Client and starts its background sender
+func New(cfg Config) (*Client, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("client endpoint is required")
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("client ID is required")
+	}
+
+	defaults := DefaultConfig()
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	if cfg.SessionID == "" {
+		cfg.SessionID = uuid.NewString()
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaults.FlushInterval
+	}
+	if cfg.ImmediateEvents == nil {
+		cfg.ImmediateEvents = defaults.ImmediateEvents
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaults.MaxRetries
+	} else if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = defaults.MinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = max(defaults.MaxBackoff, cfg.MinBackoff)
+	}
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = defaults.MaxQueue
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = defaults.HTTPClient
+	}
+
+	c := &Client{
+		cfg:       cfg,
+		immediate: make(map[string]bool, len(cfg.ImmediateEvents)),
+		wake:      make(chan struct{}, 1),
+		flushReq:  make(chan flushRequest),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, name := range cfg.ImmediateEvents {
+		c.immediate[name] = true
+	}
+	go c.run()
+	return c, nil
+}
+
+// Track queues event for sending. Events without an ID, timestamp or
+// session get a new ID, the current time and the client's session. The ID
+// stays with the event through retries, so the collector stores it once.
+func (c *Client) Track(event Event) error {
+	if event.EventID == "" {
+		event.EventID = uuid.NewString()
+	}
+	if event.Timestamp == "" {
+		event.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	if event.SessionID == "" {
+		event.SessionID = c.cfg.SessionID
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.queue = append(c.queue, event)
+	if over := len(c.queue) - c.cfg.MaxQueue; over > 0 {
+		c.queue = c.queue[over:]
+		c.dropped.Add(int64(over))
+	}
+	send := len(c.queue) >= c.cfg.BatchSize || c.immediate[event.EventName]
+	c.mu.Unlock()
+
+	if send {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Dropped returns how many events were discarded because the queue was
+// full, their batch ran out of retries or the collector rejected them
+func (c *Client) Dropped() int64 {
+	return c.dropped.Load()
+}
+
+// Flush sends every queued event and waits until they are stored or ctx
+// ends
+func (c *Client) Flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+	select {
+	case c.flushReq <- flushRequest{ctx: ctx, reply: reply}:
+	case <-c.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-reply
+}
+
+// Close stops the background sender and sends the remaining events,
+// retrying until ctx ends. Events that still could not be sent are posted
+// once to the beacon endpoint, which may take up to DefaultBeaconTimeout
+// beyond ctx.
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	close(c.stop)
+	<-c.done
+
+	err := c.sendQueued(ctx, nil)
+	if err == nil {
+		return nil
+	}
+
+	var events []Event
+	for _, batch := range c.unsent {
+		events = append(events, batch.Events...)
+	}
+	c.unsent = nil
+	events = append(events, c.take(c.cfg.MaxQueue)...)
+	if len(events) == 0 {
+		return err
+	}
+
+	beaconCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), DefaultBeaconTimeout)
+	defer cancel()
+	if beaconErr := c.beacon(beaconCtx, events); beaconErr != nil {
+		c.dropped.Add(int64(len(events)))
+		return errors.Join(err, beaconErr)
+	}
+	return nil
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case req := <-c.flushReq:
+			req.reply <- c.sendQueued(req.ctx, c.stop)
+		case <-ticker.C:
+			c.report(c.sendQueued(ctx, c.stop))
+		case <-c.wake:
+			c.report(c.sendQueued(ctx, c.stop))
+		}
+	}
+}
+
+// sendQueued sends the unsent batches and then the queue, one batch at a
+// time. It gives up at the first batch that can't be sent because ctx
+// ended or stop was closed; that batch is kept in unsent and the rest stay
+// queued. Batches that fail for good are dropped.
+func (c *Client) sendQueued(ctx context.Context, stop <-chan struct{}) error {
+	var errs []error
+	for {
+		var batch Batch
+		if len(c.unsent) > 0 {
+			batch = c.unsent[0]
+			c.unsent = c.unsent[1:]
+		} else {
+			events := c.take(c.cfg.BatchSize)
+			if len(events) == 0 {
+				return errors.Join(errs...)
+			}
+			batch = c.newBatch(events)
+		}
+
+		err := c.send(ctx, batch, stop)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, errStopped) || ctx.Err() != nil {
+			c.unsent = append([]Batch{batch}, c.unsent...)
+			return errors.Join(append(errs, err)...)
+		}
+		c.dropped.Add(int64(len(batch.Events)))
+		errs = append(errs, fmt.Errorf("dropped batch %s: %w", batch.BatchID, err))
+	}
+}
+
+// take removes up to n events from the front of the queue
+func (c *Client) take(n int) []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n = min(n, len(c.queue))
+	events := make([]Event, n)
+	copy(events, c.queue)
+	c.queue = c.queue[n:]
+	return events
+}
+
+func (c *Client) newBatch(events []Event) Batch {
+	return Batch{
+		ClientID:  c.cfg.ClientID,
+		APIKey:    c.cfg.APIKey,
+		SessionID: c.cfg.SessionID,
+		BatchID:   uuid.NewString(),
+		Events:    events,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+
+		SchemaVersion: schema.Current,
+	}
+}
+
+// send posts batch, retrying temporary failures with backoff. Retries
+// keep the batch ID so the collector can drop copies it already stored.
+func (c *Client) send(ctx context.Context, batch Batch, stop <-chan struct{}) error {
+	for attempt := 0; ; attempt++ {
+		batch.IsRetry = attempt > 0
+		err := c.post(ctx, c.cfg.Endpoint, batch, attempt)
+		if err == nil || !temporary(err) || attempt >= c.cfg.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(c.backoff(attempt, err))
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return errStopped
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// backoff returns how long to wait before retry attempt+1
+func (c *Client) backoff(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return min(statusErr.RetryAfter, c.cfg.MaxBackoff)
+	}
+	delay := c.cfg.MinBackoff << attempt
+	if delay <= 0 || delay > c.cfg.MaxBackoff {
+		return c.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// temporary reports whether err may go away on retry: network errors, 429
+// or 5xx responses and batches corrupted on the way
+func temporary(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	return true
+}
+
+// beacon posts events to the beacon endpoint once, without retries, split
+// into batches that fit the beacon size limit
+func (c *Client) beacon(ctx context.Context, events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, batch := range c.beaconBatches(events) {
+		if err := c.post(ctx, c.cfg.Endpoint+"/beacon", batch, 0); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// beaconBatches halves events until each batch encodes within maxBeaconSize
+func (c *Client) beaconBatches(events []Event) []Batch {
+	batch := c.newBatch(events)
+	body, err := json.Marshal(batch)
+	if err != nil || len(body) <= maxBeaconSize || len(events) == 1 {
+		return []Batch{batch}
+	}
+	half := len(events) / 2
+	return append(c.beaconBatches(events[:half]), c.beaconBatches(events[half:])...)
+}
+
+// checksummedBatch is a batch sent with the checksum of its events
+type checksummedBatch struct {
+	Batch
+	Checksum string `json:"checksum"`
+}
+
+func (c *Client) post(ctx context.Context, url string, batch Batch, attempt int) error {
+	var payload any = batch
+	if c.cfg.Checksum {
+		events, err := json.Marshal(batch.Events)
+		if err != nil {
+			return fmt.Errorf("failed to encode batch: %w", err)
+		}
+		payload = checksummedBatch{Batch: batch, Checksum: checksum.Sum(events)}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+	if c.cfg.Compress {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(body)
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to compress batch: %w", err)
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Analytics-Client", userAgent)
+	req.Header.Set("X-Request-ID", batch.BatchID)
+	if c.cfg.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", c.cfg.APIKey)
+	}
+	if attempt > 0 {
+		req.Header.Set("X-Retry-Attempt", strconv.Itoa(attempt))
+	}
+
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		c.checkAck(batch.BatchID, resp.Body)
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	statusErr := &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(message))}
+	var errorBody struct {
+		Code string `json:"code"`
+	}
+	if json.Unmarshal(message, &errorBody) == nil {
+		statusErr.Code = errorBody.Code
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		statusErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return statusErr
+}
+
+// checkAck reports the events the collector rejected from a stored batch.
+// Beacons are answered without a body, which has nothing to report.
+func (c *Client) checkAck(batchID string, body io.Reader) {
+	var ack struct {
+		Rejected []Rejection `json:"rejected"`
+	}
+	if err := json.NewDecoder(body).Decode(&ack); err != nil || len(ack.Rejected) == 0 {
+		return
+	}
+	c.dropped.Add(int64(len(ack.Rejected)))
+	c.report(&RejectedError{BatchID: batchID, Rejected: ack.Rejected})
+}
+
+// report passes errors from background sends to OnError. Batches cut
+// short by Close aren't reported; Close sends them.
+func (c *Client) report(err error) {
+	if err != nil && !errors.Is(err, errStopped) && c.cfg.OnError != nil {
+		c.cfg.OnError(err)
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/adtyap26/event-stream-video/internal/models"
+)
+
+// The event types are the collector's own, so events built here decode on
+// the server exactly as they were sent
+type (
+	Event         = models.Event
+	Batch         = models.EventBatch
+	PlaybackState = models.PlaybackState
+	Technical     = models.Technical
+	Context       = models.Context
+	AdState       = models.AdState
+	Interaction   = models.Interaction
+)
+
+// The standard ad events, sent with an AdState
+const (
+	AdStart    = models.AdStart
+	AdQuartile = models.AdQuartile
+	AdComplete = models.AdComplete
+	AdError    = models.AdError
+)
+
+// The event categories of Event.EventCategory and the standard events of
+// the non-video ones
+const (
+	CategoryVideo       = models.CategoryVideo
+	CategoryPage        = models.CategoryPage
+	CategoryInteraction = models.CategoryInteraction
+
+	PageView = models.PageView
+	CTAClick = models.CTAClick
+)
+
+// The stream types of Technical.StreamType
+const (
+	StreamLive = models.StreamLive
+	StreamVOD  = models.StreamVOD
+	StreamDVR  = models.StreamDVR
+)
+
+// NewEvent returns an event named name for videoID, stamped with the
+// current time and given a new event ID. The client fills in the session
+// when it is left empty.
+func NewEvent(name, videoID string) Event {
+	return Event{
+		EventID:   uuid.NewString(),
+		EventName: name,
+		VideoID:   videoID,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// NewAdEvent returns an ad event like NewEvent, describing ad
+func NewAdEvent(name, videoID string, ad AdState) Event {
+	event := NewEvent(name, videoID)
+	event.AdState = &ad
+	return event
+}
+
+// NewPageView returns a page_view event like NewEvent for the page at
+// pageURL
+func NewPageView(pageURL, pageTitle string) Event {
+	event := NewEvent(PageView, "")
+	event.EventCategory = CategoryPage
+	event.Context = &Context{PageURL: pageURL, PageTitle: pageTitle}
+	return event
+}
+
+// NewInteractionEvent returns an interaction event like NewEvent, such as
+// a cta_click, on the element of interaction
+func NewInteractionEvent(name string, interaction Interaction) Event {
+	event := NewEvent(name, "")
+	event.EventCategory = CategoryInteraction
+	event.Interaction = &interaction
+	return event
+}
@@ -1,22 +1,11 @@
-package api
+package utils
 
-import (
-	"net/http"
+import "time"
 
-	"github.com/adtyap26/event-stream-video/internal/logger"
-)
+// FileTimestampLayout is the layout used to stamp log file names
+const FileTimestampLayout = "2006-01-02-15-04-05"
 
-func SetupRoutes(eventLogger *logger.EventLogger) http.Handler {
-	eventHandler := NewEventHandler(eventLogger)
-
-	mux := http.NewServeMux()
-
-	mux.Handle("/api/v1/events", CORSMiddleware(http.HandlerFunc(eventHandler.HandleEvents)))
-	mux.Handle("/api/v1/events/beacon", CORSMiddleware(http.HandlerFunc(eventHandler.HandleBeacons)))
-
-	// Serve static files
-	fs := http.FileServer(http.Dir("./"))
-	mux.Handle("/", fs)
-
-	return mux
+// FileTimestamp formats t for use in a file name
+func FileTimestamp(t time.Time) string {
+	return t.Format(FileTimestampLayout)
 }
@@ -0,0 +1,869 @@
+/**
+ * Video Analytics SDK for Video.js
+ * v1.0.0
+ */
+(function (window, document) {
+  "use strict";
+
+  // Longest URL an image beacon may use; browsers and proxies cut off
+  // longer ones
+  const MAX_PIXEL_URL_LENGTH = 8000;
+
+  // Version of the event contract the batches follow
+  const SCHEMA_VERSION = 2;
+
+  // Standard ad events, sent with an adState describing the ad
+  const AD_EVENTS = ["ad_start", "ad_quartile", "ad_complete", "ad_error"];
+
+  // Media response headers that name the CDN and edge server. Cross-origin
+  // CDNs must list them in Access-Control-Expose-Headers to be readable.
+  const CDN_HEADERS = [
+    "x-amz-cf-pop",
+    "cf-ray",
+    "x-served-by",
+    "x-akamai-request-id",
+    "server",
+    "via",
+  ];
+
+  // Seekable window from which a live stream counts as DVR, in seconds
+  const DVR_WINDOW_SECONDS = 60;
+
+  // Configuration defaults
+  const DEFAULT_CONFIG = {
+    apiEndpoint: "http://localhost:8080/api/v1/events",
+    batchSize: 15,
+    batchInterval: 5000, // 5 seconds
+    heartbeatInterval: 10000, // 10 seconds, 0 disables heartbeats
+    debug: false,
+    clientId: null,
+    apiKey: null,
+    autoDetect: true,
+    // Ask the collector for the regional collector nearest to the viewer
+    // and send there instead of apiEndpoint
+    regionRouting: false,
+    // Fetch batching, sampling and enabled events from the collector's
+    // GET /api/v1/sdk/config, which overrides the options here
+    remoteConfig: false,
+    sampleRate: {
+      timeupdate: 0.2, // Only send 20% of timeupdate events
+    },
+    sessionSampleRate: 1, // Share of sessions that report
+    enabledEvents: [], // Event types sent, empty for all
+  };
+
+  // SDK state
+  let config = { ...DEFAULT_CONFIG };
+  let eventQueue = [];
+  let retryQueue = [];
+  let retryAttempt = 0;
+  let retryTimeout = null;
+  let batchInterval = null;
+  let heartbeatInterval = null;
+  let trackedPlayers = new Map();
+  let isInitialized = false;
+
+  // Core SDK functionality
+  const VideoAnalytics = {
+    /**
+     * Initialize the SDK
+     * @param {string} clientId - Client identifier
+     * @param {Object} options - Configuration options
+     */
+    init: function (clientId, options = {}) {
+      if (isInitialized) {
+        this.log("SDK already initialized");
+        return;
+      }
+
+      // Merge configuration
+      config = { ...DEFAULT_CONFIG, ...options, clientId };
+
+      this.log("Initializing SDK with config:", config);
+
+      // Validate required config
+      if (!config.clientId) {
+        console.error("VideoAnalytics: clientId is required");
+        return;
+      }
+
+      if (config.regionRouting) {
+        this.routeToRegion();
+      }
+      if (config.remoteConfig) {
+        this.fetchRemoteConfig();
+      }
+
+      this.startTimers();
+
+      // Set up page unload handler
+      window.addEventListener("beforeunload", () => {
+        this.handlePageUnload();
+      });
+
+      // Auto-detect Video.js players if enabled
+      if (config.autoDetect) {
+        this.detectPlayers();
+
+        // Set up mutation observer to detect new players
+        const observer = new MutationObserver((mutations) => {
+          this.detectPlayers();
+        });
+
+        observer.observe(document.body, {
+          childList: true,
+          subtree: true,
+        });
+      }
+
+      isInitialized = true;
+      this.log("SDK initialized successfully");
+    },
+
+    /**
+     * (Re)start sending batches and heartbeats at the configured intervals
+     */
+    startTimers: function () {
+      clearInterval(batchInterval);
+      clearInterval(heartbeatInterval);
+      heartbeatInterval = null;
+
+      batchInterval = setInterval(() => {
+        this.sendBatch();
+      }, config.batchInterval);
+
+      // Heartbeats let the collector confirm watch time between events
+      if (config.heartbeatInterval > 0) {
+        heartbeatInterval = setInterval(() => {
+          this.sendHeartbeats();
+        }, config.heartbeatInterval);
+      }
+    },
+
+    /**
+     * Apply the settings GET /api/v1/sdk/config has for the tenant of
+     * apiKey. They are kept for their ttl; until they arrive the options
+     * passed to init apply.
+     */
+    fetchRemoteConfig: function () {
+      const cached = JSON.parse(localStorage.getItem("video_analytics_sdk_config") || "null");
+      if (cached && cached.apiKey === config.apiKey && cached.expires > Date.now()) {
+        this.applyRemoteConfig(cached.settings);
+        return;
+      }
+
+      let configUrl;
+      try {
+        configUrl = new URL("/api/v1/sdk/config", config.apiEndpoint);
+      } catch (e) {
+        return;
+      }
+      if (config.apiKey) {
+        configUrl.searchParams.set("apiKey", config.apiKey);
+      }
+      fetch(configUrl.href, { headers: { "X-Analytics-Client": "VideoAnalytics-SDK/1.0.0" } })
+        .then((response) => {
+          if (!response.ok) {
+            throw new Error(`Server responded with ${response.status}`);
+          }
+          return response.json();
+        })
+        .then((settings) => {
+          localStorage.setItem(
+            "video_analytics_sdk_config",
+            JSON.stringify({ apiKey: config.apiKey, settings, expires: Date.now() + settings.ttl * 1000 })
+          );
+          this.applyRemoteConfig(settings);
+        })
+        .catch((error) => {
+          this.log("Remote config unavailable, keeping the configured settings", error);
+        });
+    },
+
+    applyRemoteConfig: function (settings) {
+      config.batchSize = settings.batchSize;
+      config.batchInterval = settings.batchInterval;
+      config.heartbeatInterval = settings.heartbeatInterval;
+      config.sessionSampleRate = settings.sessionSampleRate;
+      config.sampleRate = settings.sampleRate;
+      config.enabledEvents = settings.enabledEvents;
+      this.log("Applied remote config", settings);
+      this.startTimers();
+    },
+
+    /**
+     * Whether this session is among the sampled ones. Each session draws
+     * once, so a changed rate keeps or drops whole sessions.
+     * @returns {boolean}
+     */
+    isSessionSampled: function () {
+      let draw = parseFloat(sessionStorage.getItem("video_analytics_session_draw"));
+      if (isNaN(draw)) {
+        draw = Math.random();
+        sessionStorage.setItem("video_analytics_session_draw", String(draw));
+      }
+      return draw < config.sessionSampleRate;
+    },
+
+    /**
+     * Switch apiEndpoint to the collector GET /api/v1/config names for this
+     * viewer. The hint is kept for its ttl, batches sent before it arrives
+     * go to the configured endpoint.
+     */
+    routeToRegion: function () {
+      const cached = JSON.parse(localStorage.getItem("video_analytics_region") || "null");
+      if (cached && cached.expires > Date.now()) {
+        this.applyRegion(cached.hint);
+        return;
+      }
+
+      let configUrl;
+      try {
+        configUrl = new URL("/api/v1/config", config.apiEndpoint).href;
+      } catch (e) {
+        return;
+      }
+      fetch(configUrl, { headers: { "X-Analytics-Client": "VideoAnalytics-SDK/1.0.0" } })
+        .then((response) => {
+          if (!response.ok) {
+            throw new Error(`Server responded with ${response.status}`);
+          }
+          return response.json();
+        })
+        .then((hint) => {
+          localStorage.setItem(
+            "video_analytics_region",
+            JSON.stringify({ hint, expires: Date.now() + hint.ttl * 1000 })
+          );
+          this.applyRegion(hint);
+        })
+        .catch((error) => {
+          this.log("Region routing unavailable, keeping the configured endpoint", error);
+        });
+    },
+
+    applyRegion: function (hint) {
+      if (!hint.current && hint.eventsUrl) {
+        this.log(`Sending to the ${hint.region} collector`, hint.eventsUrl);
+        config.apiEndpoint = hint.eventsUrl;
+      }
+    },
+
+    /**
+     * Track a specific Video.js player
+     * @param {Object} player - Video.js player instance
+     * @param {string} videoId - Identifier for the video
+     */
+    trackPlayer: function (player, videoId) {
+      if (!player || typeof player.on !== "function") {
+        console.error("VideoAnalytics: Invalid Video.js player instance");
+        return;
+      }
+
+      if (trackedPlayers.has(player)) {
+        this.log("Player already tracked");
+        return;
+      }
+
+      this.log("Tracking player for video:", videoId);
+
+      // Store player reference with video ID
+      trackedPlayers.set(player, {
+        videoId: videoId,
+        lastTimeupdateTracked: 0,
+        responseHeaders: null,
+      });
+      player.ready(() => this.watchResponseHeaders(player));
+
+      // Register event listeners
+      const events = [
+        "play",
+        "pause",
+        "playing",
+        "waiting",
+        "seeking",
+        "seeked",
+        "ended",
+        "loadstart",
+        "loadedmetadata",
+        "loadeddata",
+        "canplay",
+        "canplaythrough",
+        "volumechange",
+        "fullscreenchange",
+        "error",
+        "abort",
+        "stalled",
+        "suspend",
+        "emptied",
+        "ratechange",
+        "durationchange",
+        "progress",
+      ];
+
+      events.forEach((eventName) => {
+        player.on(eventName, () => {
+          this.trackEvent(player, eventName);
+        });
+      });
+
+      // Handle timeupdate with sampling
+      player.on("timeupdate", () => {
+        const now = Date.now();
+        const playerData = trackedPlayers.get(player);
+
+        // Apply sampling and minimum interval (500ms)
+        const rate = config.sampleRate.timeupdate;
+        if (
+          (rate === undefined || Math.random() < rate) &&
+          now - playerData.lastTimeupdateTracked > 500
+        ) {
+          playerData.lastTimeupdateTracked = now;
+          this.trackEvent(player, "timeupdate");
+        }
+      });
+
+      // Track initial player state
+      this.trackEvent(player, "playerInit");
+    },
+
+    /**
+     * Keep the CDN headers of the last media segment the player loaded, so
+     * the server can tell which CDN and edge server delivered it
+     * @param {Object} player - Video.js player instance
+     */
+    watchResponseHeaders: function (player) {
+      const tech = player.tech({ IWillNotUseThisInPlugins: true });
+      const vhs = tech && tech.vhs;
+      if (!vhs || !vhs.xhr || typeof vhs.xhr.onResponse !== "function") {
+        return;
+      }
+      vhs.xhr.onResponse((request, error, response) => {
+        const playerData = trackedPlayers.get(player);
+        if (!playerData || !response || !response.headers) {
+          return;
+        }
+        const headers = {};
+        CDN_HEADERS.forEach((name) => {
+          if (response.headers[name]) {
+            headers[name] = response.headers[name];
+          }
+        });
+        if (Object.keys(headers).length > 0) {
+          playerData.responseHeaders = headers;
+        }
+      });
+    },
+
+    /**
+     * Auto-detect Video.js players on the page
+     */
+    detectPlayers: function () {
+      if (typeof videojs === "undefined") {
+        return;
+      }
+
+      // Find all video.js players
+      const players = document.querySelectorAll(".video-js");
+      players.forEach((element) => {
+        const player = videojs.getPlayer(element);
+        if (player && !trackedPlayers.has(player)) {
+          // Try to find video ID from data attribute or generate one
+          const videoId =
+            element.dataset.videoId ||
+            element.id ||
+            "video-" + Math.random().toString(36).substring(2, 9);
+          this.trackPlayer(player, videoId);
+        }
+      });
+    },
+
+    /**
+     * Track a heartbeat for every player that is playing
+     */
+    sendHeartbeats: function () {
+      trackedPlayers.forEach((playerData, player) => {
+        if (!player.paused() && !player.ended()) {
+          this.trackEvent(player, "heartbeat");
+        }
+      });
+    },
+
+    /**
+     * Track an ad event, e.g. from an IMA or VAST ad integration
+     * @param {Object} player - Video.js player instance showing the ad
+     * @param {string} eventName - ad_start, ad_quartile, ad_complete or ad_error
+     * @param {Object} ad - adId, and optionally creativeId, position
+     *   (preroll, midroll or postroll), quartile (1-4) and skippable
+     */
+    trackAd: function (player, eventName, ad) {
+      if (!AD_EVENTS.includes(eventName)) {
+        console.error("VideoAnalytics: Unknown ad event", eventName);
+        return;
+      }
+      if (!ad || !ad.adId) {
+        console.error("VideoAnalytics: Ad events need an adId");
+        return;
+      }
+      this.trackEvent(player, eventName, {
+        adId: String(ad.adId),
+        creativeId: ad.creativeId ? String(ad.creativeId) : undefined,
+        position: ad.position,
+        quartile: ad.quartile,
+        skippable: ad.skippable === true,
+      });
+    },
+
+    /**
+     * Describe what kind of stream the player is showing
+     * @param {Object} player - Video.js player instance
+     * @returns {Object} streamType (live, vod or dvr) and, for live
+     *   streams, liveLatency, the seconds behind the live edge
+     */
+    getStreamState: function (player) {
+      const duration = player.duration();
+      const liveTracker = player.liveTracker;
+      const live = liveTracker ? liveTracker.isLive() : duration === Infinity;
+      if (!live) {
+        return { streamType: isFinite(duration) && duration > 0 ? "vod" : undefined };
+      }
+
+      const seekable = player.seekable();
+      const last = seekable.length - 1;
+      const seekableWindow = last >= 0 ? seekable.end(last) - seekable.start(0) : 0;
+      const state = { streamType: seekableWindow >= DVR_WINDOW_SECONDS ? "dvr" : "live" };
+      const liveEdge = liveTracker ? liveTracker.liveCurrentTime() : last >= 0 ? seekable.end(last) : NaN;
+      const latency = liveEdge - player.currentTime();
+      if (isFinite(latency) && latency >= 0) {
+        state.liveLatency = latency;
+      }
+      return state;
+    },
+
+    /**
+     * Track a player event
+     * @param {Object} player - Video.js player instance
+     * @param {string} eventName - Name of the event
+     * @param {Object} [adState] - The ad of ad events
+     */
+    trackEvent: function (player, eventName, adState) {
+      if (!isInitialized) {
+        console.error("VideoAnalytics: SDK not initialized");
+        return;
+      }
+
+      const playerData = trackedPlayers.get(player);
+      if (!playerData) {
+        return;
+      }
+
+      if (!this.shouldSend(eventName)) {
+        return;
+      }
+
+      const videoId = playerData.videoId;
+      const stream = this.getStreamState(player);
+
+      // Collect event data
+      const event = {
+        eventId: this.generateUUID(),
+        eventName: eventName,
+        videoId: videoId,
+        timestamp: new Date().toISOString(),
+        sessionId: this.getSessionId(),
+        ...this.getUserIdentifiers(),
+        playbackState: {
+          currentTime: player.currentTime(),
+          duration: player.duration(),
+          paused: player.paused(),
+          ended: player.ended(),
+          playbackRate: player.playbackRate(),
+          volume: player.volume(),
+          muted: player.muted(),
+          fullscreen: player.isFullscreen ? player.isFullscreen() : false,
+          networkState: player.networkState(),
+          readyState: player.readyState(),
+          liveLatency: stream.liveLatency,
+        },
+        technical: {
+          userAgent: navigator.userAgent,
+          screenResolution: `${screen.width}x${screen.height}`,
+          viewportSize: `${window.innerWidth}x${window.innerHeight}`,
+          playerSize: `${player.currentWidth()}x${player.currentHeight()}`,
+          connectionType: navigator.connection
+            ? navigator.connection.effectiveType
+            : null,
+          streamType: stream.streamType,
+          player: "video.js",
+          playerVersion: typeof videojs !== "undefined" ? videojs.VERSION : undefined,
+          responseHeaders: playerData.responseHeaders || undefined,
+          // Set in browsers driven by automation, for the bot filter
+          webdriver: navigator.webdriver === true,
+        },
+        context: {
+          pageUrl: window.location.href,
+          referrer: document.referrer,
+          pageTitle: document.title,
+        },
+      };
+      if (adState) {
+        event.adState = adState;
+      }
+      if (eventName === "error") {
+        // The MediaError code and message, classified by the collector
+        const error = player.error();
+        if (error) {
+          event.playbackState.error = { code: error.code, message: error.message };
+        }
+      }
+
+      this.log(`Tracked event: ${eventName}`, event);
+
+      // Add to queue
+      eventQueue.push(event);
+
+      // Send immediately for certain events
+      if (
+        ["play", "pause", "ended", "error", "ad_start", "ad_complete", "ad_error"].includes(eventName) ||
+        eventQueue.length >= config.batchSize
+      ) {
+        this.sendBatch();
+      }
+    },
+
+    /**
+     * Track a view of the current page, as a page event
+     */
+    trackPageView: function () {
+      this.trackPageEvent("page_view", "page");
+    },
+
+    /**
+     * Track an interaction with an element outside the players, such as a
+     * call to action
+     * @param {string} eventName - e.g. cta_click
+     * @param {Object} interaction - element, the ID or data attribute of
+     *   the element, and optionally its label and targetUrl
+     */
+    trackInteraction: function (eventName, interaction) {
+      if (!interaction || !interaction.element) {
+        console.error("VideoAnalytics: Interaction events need an element");
+        return;
+      }
+      this.trackPageEvent(eventName, "interaction", {
+        element: String(interaction.element),
+        label: interaction.label ? String(interaction.label) : undefined,
+        targetUrl: interaction.targetUrl ? String(interaction.targetUrl) : undefined,
+      });
+    },
+
+    /**
+     * Track a non-video event of the page
+     * @param {string} eventName - Name of the event
+     * @param {string} category - page or interaction
+     * @param {Object} [interaction] - The element of interaction events
+     */
+    trackPageEvent: function (eventName, category, interaction) {
+      if (!isInitialized) {
+        console.error("VideoAnalytics: SDK not initialized");
+        return;
+      }
+      if (!this.shouldSend(eventName)) {
+        return;
+      }
+
+      const event = {
+        eventId: this.generateUUID(),
+        eventName: eventName,
+        eventCategory: category,
+        timestamp: new Date().toISOString(),
+        sessionId: this.getSessionId(),
+        ...this.getUserIdentifiers(),
+        technical: {
+          userAgent: navigator.userAgent,
+          screenResolution: `${screen.width}x${screen.height}`,
+          viewportSize: `${window.innerWidth}x${window.innerHeight}`,
+          webdriver: navigator.webdriver === true,
+        },
+        context: {
+          pageUrl: window.location.href,
+          referrer: document.referrer,
+          pageTitle: document.title,
+        },
+      };
+      if (interaction) {
+        event.interaction = interaction;
+      }
+
+      this.log(`Tracked event: ${eventName}`, event);
+      eventQueue.push(event);
+      if (eventQueue.length >= config.batchSize) {
+        this.sendBatch();
+      }
+    },
+
+    /**
+     * Whether events named eventName are sent, by the enabled events and
+     * the sampling rates. Timeupdates are sampled where they are throttled.
+     * @param {string} eventName - Name of the event
+     * @returns {boolean}
+     */
+    shouldSend: function (eventName) {
+      if (config.enabledEvents.length > 0 && !config.enabledEvents.includes(eventName)) {
+        return false;
+      }
+      const rate = config.sampleRate[eventName];
+      if (eventName !== "timeupdate" && rate !== undefined && Math.random() >= rate) {
+        return false;
+      }
+      return this.isSessionSampled();
+    },
+
+    /**
+     * Send batched events to the server
+     */
+    sendBatch: function () {
+      if (eventQueue.length === 0) return;
+
+      const events = [...eventQueue];
+      eventQueue = [];
+
+      const payload = {
+        clientId: config.clientId,
+        apiKey: config.apiKey,
+        sessionId: this.getSessionId(),
+        batchId: this.generateUUID(),
+        schemaVersion: SCHEMA_VERSION,
+        events: events,
+        timestamp: new Date().toISOString(),
+      };
+
+      this.log(`Sending batch of ${events.length} events`);
+
+      fetch(config.apiEndpoint, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Analytics-Client": "VideoAnalytics-SDK/1.0.0",
+          "X-Request-ID": payload.batchId,
+        },
+        body: JSON.stringify(payload),
+        keepalive: true,
+      })
+        .then((response) => {
+          if (!response.ok) {
+            throw new Error(`Server responded with ${response.status}`);
+          }
+          return response.json();
+        })
+        .then((data) => {
+          this.log("Batch sent successfully", data);
+          retryAttempt = 0; // Reset retry counter on success
+          // Invalid events were left out of the batch; sending them again
+          // would only get them rejected again
+          if (data.rejected && data.rejected.length > 0) {
+            console.warn("VideoAnalytics: Collector rejected events", data.rejected);
+          }
+        })
+        .catch((error) => {
+          console.error("VideoAnalytics: Failed to send events", error);
+          retryQueue.push(...events);
+          this.scheduleRetry();
+        });
+    },
+
+    /**
+     * Handle page unload event
+     */
+    handlePageUnload: function () {
+      // Stop batching and heartbeats
+      if (batchInterval) {
+        clearInterval(batchInterval);
+      }
+      if (heartbeatInterval) {
+        clearInterval(heartbeatInterval);
+      }
+
+      // Combine queued events
+      const allEvents = [...eventQueue, ...retryQueue];
+      if (allEvents.length === 0) return;
+
+      // Add a final event
+      const finalEvent = {
+        eventId: this.generateUUID(),
+        eventName: "pageUnload",
+        timestamp: new Date().toISOString(),
+        sessionId: this.getSessionId(),
+        ...this.getUserIdentifiers(),
+        context: {
+          pageUrl: window.location.href,
+          referrer: document.referrer,
+        },
+      };
+
+      allEvents.push(finalEvent);
+
+      // Use sendBeacon for more reliable delivery during page unload
+      const payload = JSON.stringify({
+        clientId: config.clientId,
+        apiKey: config.apiKey,
+        sessionId: this.getSessionId(),
+        batchId: this.generateUUID(),
+        schemaVersion: SCHEMA_VERSION,
+        events: allEvents,
+        timestamp: new Date().toISOString(),
+      });
+
+      const url = config.apiEndpoint + "/beacon";
+      if (navigator.sendBeacon && navigator.sendBeacon(url, payload)) {
+        this.log(`Sent ${allEvents.length} events via beacon`);
+        return;
+      }
+
+      // Without sendBeacon, or when the browser refused the payload, fall
+      // back to an image request carrying the batch as base64 JSON
+      const pixelUrl =
+        url + "?data=" + encodeURIComponent(btoa(unescape(encodeURIComponent(payload))));
+      if (pixelUrl.length > MAX_PIXEL_URL_LENGTH) {
+        this.log(`Dropped ${allEvents.length} events, too many for an image beacon`);
+        return;
+      }
+      new Image(1, 1).src = pixelUrl;
+      this.log(`Sent ${allEvents.length} events via image beacon`);
+    },
+
+    /**
+     * Schedule retry for failed transmissions
+     */
+    scheduleRetry: function () {
+      if (retryTimeout) return; // Already scheduled
+
+      retryAttempt++;
+      const delay = Math.min(1000 * Math.pow(2, retryAttempt), 30000); // Max 30 seconds
+
+      this.log(`Scheduling retry attempt ${retryAttempt} in ${delay}ms`);
+
+      retryTimeout = setTimeout(() => {
+        retryTimeout = null;
+        this.attemptRetry();
+      }, delay);
+    },
+
+    /**
+     * Attempt to retry sending failed events
+     */
+    attemptRetry: function () {
+      if (retryQueue.length === 0) return;
+
+      this.log(`Retrying to send ${retryQueue.length} events`);
+
+      const events = [...retryQueue];
+      retryQueue = [];
+
+      const payload = {
+        clientId: config.clientId,
+        apiKey: config.apiKey,
+        sessionId: this.getSessionId(),
+        batchId: this.generateUUID(),
+        schemaVersion: SCHEMA_VERSION,
+        events: events,
+        timestamp: new Date().toISOString(),
+        isRetry: true,
+        retryAttempt: retryAttempt,
+      };
+
+      fetch(config.apiEndpoint, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "X-Analytics-Client": "VideoAnalytics-SDK/1.0.0",
+          "X-Retry-Attempt": retryAttempt.toString(),
+          "X-Request-ID": payload.batchId,
+        },
+        body: JSON.stringify(payload),
+      })
+        .then((response) => {
+          if (!response.ok) {
+            throw new Error(`Server responded with ${response.status}`);
+          }
+          return response.json();
+        })
+        .then((data) => {
+          this.log("Retry successful", data);
+          retryAttempt = 0; // Reset retry counter
+        })
+        .catch((error) => {
+          console.error("VideoAnalytics: Retry failed", error);
+
+          // Give up after 5 attempts
+          if (retryAttempt >= 5) {
+            this.log("Max retry attempts reached, discarding events");
+            retryAttempt = 0;
+            return;
+          }
+
+          // Put events back in retry queue
+          retryQueue.push(...events);
+          this.scheduleRetry();
+        });
+    },
+
+    /**
+     * Get or create session ID
+     * @returns {string} Session ID
+     */
+    getSessionId: function () {
+      let sessionId = sessionStorage.getItem("video_analytics_session_id");
+      if (!sessionId) {
+        sessionId = this.generateUUID();
+        sessionStorage.setItem("video_analytics_session_id", sessionId);
+      }
+      return sessionId;
+    },
+
+    /**
+     * Get user identifiers
+     * @returns {Object} User identifiers
+     */
+    getUserIdentifiers: function () {
+      // Get or create anonymous ID
+      let anonymousId = localStorage.getItem("video_analytics_user_id");
+      if (!anonymousId) {
+        anonymousId = this.generateUUID();
+        localStorage.setItem("video_analytics_user_id", anonymousId);
+      }
+
+      // Get authenticated ID if available from your app
+      const userId = window.currentUser?.id || null;
+
+      return { userId, anonymousId };
+    },
+
+    /**
+     * Generate a UUID v4
+     * @returns {string} UUID
+     */
+    generateUUID: function () {
+      return "xxxxxxxx-xxxx-4xxx-yxxx-xxxxxxxxxxxx".replace(
+        /[xy]/g,
+        function (c) {
+          const r = (Math.random() * 16) | 0;
+          const v = c === "x" ? r : (r & 0x3) | 0x8;
+          return v.toString(16);
+        },
+      );
+    },
+
+    /**
+     * Log debug messages
+     */
+    log: function (...args) {
+      if (config.debug) {
+        console.log("VideoAnalytics:", ...args);
+      }
+    },
+  };
+
+  // Expose the SDK globally
+  window.VideoAnalytics = VideoAnalytics;
+})(window, document);